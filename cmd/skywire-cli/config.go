@@ -0,0 +1,427 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/skycoin/skycoin/src/cipher"
+	"github.com/skycoin/skywire/pkg/net/resolver"
+	"github.com/skycoin/skywire/pkg/net/skycoin-messenger/factory"
+	"github.com/skycoin/skywire/pkg/node"
+)
+
+var configCommands = map[string]command{
+	"check": {"validate the configs and keys of the local node and manager and probe the services they use", configCheck},
+}
+
+// resolves the services probed, like the node
+var dns, _ = resolver.New(resolver.Config{})
+
+func configCmd(args []string) (err error) {
+	if len(args) == 0 {
+		return errors.New("usage: skywire-cli config check [flags]")
+	}
+	c, ok := configCommands[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown config command %s", args[0])
+	}
+	return c.run(args[1:])
+}
+
+// checkReport prints the result of each check with the fix of the problems
+type checkReport struct {
+	errors   int
+	warnings int
+}
+
+func (r *checkReport) ok(format string, args ...interface{}) {
+	fmt.Printf("ok     %s\n", fmt.Sprintf(format, args...))
+}
+
+func (r *checkReport) warn(problem, fix string) {
+	r.warnings++
+	fmt.Printf("warn   %s\n       fix: %s\n", problem, fix)
+}
+
+func (r *checkReport) fail(problem, fix string) {
+	r.errors++
+	fmt.Printf("error  %s\n       fix: %s\n", problem, fix)
+}
+
+// a listen address of the node or manager and the flag setting it
+type listenAddr struct {
+	owner   string
+	flag    string
+	address string
+	udp     bool
+}
+
+func configCheck(args []string) (err error) {
+	fs := flag.NewFlagSet("config check", flag.ExitOnError)
+	dir := fs.String("dir", defaultStateDir(), "state directory of the node and manager")
+	nodeConf := fs.String("node-conf", "", "node default config, default <dir>/node/conf.json")
+	seedPath := fs.String("seed-path", "", "keys of the node, default <dir>/node/keys.json")
+	quotaConfig := fs.String("quota-config", "", "quotas of the node, default <dir>/node/quotas.json")
+	managerSeedPath := fs.String("manager-seed-path", "", "keys of the manager, default <dir>/discovery/keys.json")
+	address := fs.String("address", ":5000", "address the node listens on")
+	webPort := fs.String("web-port", ":6001", "address of the web api of the node")
+	managerAddress := fs.String("manager-address", ":5998", "address the manager listens on for the nodes")
+	managerWeb := fs.String("manager-web-port", ":8000", "address of the web api of the manager")
+	probe := fs.Bool("probe", true, "connect to the discoveries and the manager in the config")
+	timeout := fs.Duration("timeout", 5*time.Second, "time a probe waits for a service")
+	fs.Parse(args)
+	if len(*nodeConf) == 0 {
+		*nodeConf = filepath.Join(*dir, "node", "conf.json")
+	}
+	if len(*seedPath) == 0 {
+		*seedPath = filepath.Join(*dir, "node", "keys.json")
+	}
+	if len(*quotaConfig) == 0 {
+		*quotaConfig = filepath.Join(*dir, "node", "quotas.json")
+	}
+	if len(*managerSeedPath) == 0 {
+		*managerSeedPath = filepath.Join(*dir, "discovery", "keys.json")
+	}
+
+	r := &checkReport{}
+	nodeKey := checkSeed(r, "node", *seedPath, "-seed-path")
+	checkSeed(r, "manager", *managerSeedPath, "-seed-path of the manager")
+	conf := checkNodeConf(r, *nodeConf, nodeKey)
+	checkQuotas(r, *quotaConfig)
+
+	addrs := []listenAddr{
+		{owner: "node", flag: "-address", address: *address},
+		{owner: "node", flag: "-web-port", address: *webPort},
+		{owner: "manager", flag: "-address", address: *managerAddress, udp: true},
+		{owner: "manager", flag: "-web-port", address: *managerWeb},
+	}
+	if conf != nil {
+		if len(conf.Address) > 0 {
+			addrs[0].address = conf.Address
+		}
+		if len(conf.WebPort) > 0 {
+			addrs[1].address = conf.WebPort
+		}
+	}
+	checkListenAddrs(r, addrs)
+
+	if *probe && conf != nil {
+		for _, d := range conf.DiscoveryAddresses {
+			probeTCP(r, "discovery "+d, d[:strings.LastIndex(d, "-")], *timeout,
+				"check the network and firewall, or replace the discovery in "+*nodeConf)
+		}
+		if conf.ConnectManager {
+			probeTCP(r, "manager", conf.ManagerAddr, *timeout,
+				"start the manager or fix manager_addr in "+*nodeConf)
+			probeHTTP(r, "web api of the manager", conf.ManagerWeb, *timeout,
+				"start the manager or fix manager_web in "+*nodeConf)
+		}
+	}
+
+	fmt.Printf("\n%d errors, %d warnings\n", r.errors, r.warnings)
+	if r.errors > 0 {
+		err = errors.New("the config has errors")
+	}
+	return
+}
+
+// parseKeys returns the keys of the hex strings, the cipher package panics on invalid keys
+func parseKeys(pub, sec string) (pk cipher.PubKey, sk cipher.SecKey, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("%v", e)
+		}
+	}()
+	if len(pub) != 2*len(pk) {
+		err = fmt.Errorf("a public key has %d hex digits", 2*len(pk))
+		return
+	}
+	pk, err = cipher.PubKeyFromHex(pub)
+	if err != nil {
+		return
+	}
+	err = pk.Verify()
+	if err != nil || len(sec) == 0 {
+		return
+	}
+	sk, err = cipher.SecKeyFromHex(sec)
+	if err != nil {
+		return
+	}
+	if cipher.PubKeyFromSecKey(sk) != pk {
+		err = errors.New("the public key is not the key of the secret key")
+	}
+	return
+}
+
+func checkSeed(r *checkReport, owner, path, flagName string) (key cipher.PubKey) {
+	d, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			r.warn(fmt.Sprintf("no keys of the %s at %s", owner, path),
+				fmt.Sprintf("the %s creates them at its first start, or point %s at the keys", owner, flagName))
+		} else {
+			r.fail(fmt.Sprintf("keys of the %s: %v", owner, err), "make "+path+" readable by the user running the "+owner)
+		}
+		return
+	}
+	sc := &factory.SeedConfig{}
+	err = json.Unmarshal(d, sc)
+	if err != nil {
+		r.fail(fmt.Sprintf("keys of the %s at %s are not valid json: %v", owner, path, err),
+			"restore the keys from a snapshot, or move the file away to create new keys")
+		return
+	}
+	pk, _, err := parseKeys(sc.PublicKey, sc.SecKey)
+	if err != nil {
+		r.fail(fmt.Sprintf("keys of the %s at %s: %v", owner, path, err),
+			"restore the keys from a snapshot, or move the file away to create new keys")
+		return
+	}
+	if len(sc.Seed) > 0 {
+		if seedKey, _ := cipher.GenerateDeterministicKeyPair([]byte(sc.Seed)); seedKey != pk {
+			r.warn(fmt.Sprintf("the seed in %s does not generate the public key of the %s", path, owner),
+				"the keys are used as they are, remove the seed or restore the keys it belongs to")
+		}
+	}
+	if info, err := os.Stat(path); err == nil && info.Mode().Perm()&0077 != 0 {
+		r.warn(fmt.Sprintf("the keys of the %s at %s are readable by other users", owner, path), "chmod 600 "+path)
+	}
+	r.ok("keys of the %s %s", owner, pk.Hex())
+	return pk
+}
+
+// checkNodeConf validates the default config of the node and returns the config of the node key
+func checkNodeConf(r *checkReport, path string, nodeKey cipher.PubKey) (conf *node.Config) {
+	d, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			r.ok("no node config at %s, the node writes the defaults at its first start", path)
+		} else {
+			r.fail(fmt.Sprintf("node config: %v", err), "make "+path+" readable by the user running the node")
+		}
+		return
+	}
+	cfs := &node.NodeConfigs{}
+	dec := json.NewDecoder(bytes.NewReader(d))
+	dec.DisallowUnknownFields()
+	err = dec.Decode(cfs)
+	if err != nil {
+		r.fail(fmt.Sprintf("node config %s: %v", path, err),
+			"the configs know discovery_addresses, connect_manager, manager_addr, manager_web, address, seed, seed_path, auto_start_path and web_port, fix the field or move the file away to write the defaults")
+		return
+	}
+	if cfs.Version != 1 {
+		r.warn(fmt.Sprintf("node config %s has version %d", path, cfs.Version), `set "version" to 1`)
+	}
+	valid := 0
+	for key, c := range cfs.Configs {
+		pk, _, err := parseKeys(key, "")
+		if err != nil {
+			r.fail(fmt.Sprintf("node config %s: %q is not a public key: %v", path, key, err),
+				"use the hex public key of the node from its keys.json as the key of the config")
+			continue
+		}
+		if nodeKey != (cipher.PubKey{}) && pk != nodeKey {
+			r.warn(fmt.Sprintf("node config %s has a config of node %s, not of this node", path, key),
+				"the node writes a config for its key at the next start, remove the one of the other node")
+			continue
+		}
+		if checkNodeConfig(r, path, c) {
+			valid++
+		}
+		conf = c
+	}
+	if nodeKey != (cipher.PubKey{}) && conf == nil {
+		r.ok("node config %s has no config of this node, the node writes the defaults at its next start", path)
+	} else if valid > 0 {
+		r.ok("node config %s", path)
+	}
+	return
+}
+
+func checkNodeConfig(r *checkReport, path string, c *node.Config) (valid bool) {
+	valid = true
+	if len(c.DiscoveryAddresses) == 0 {
+		r.warn(fmt.Sprintf("node config %s has no discovery", path),
+			"add discovery_addresses or start the node with -discovery-address <host:port-key>")
+	}
+	for _, d := range c.DiscoveryAddresses {
+		// the host may have dashes, the key has none
+		i := strings.LastIndex(d, "-")
+		if i < 1 {
+			valid = false
+			r.fail(fmt.Sprintf("discovery %q in %s is not host:port-key", d, path),
+				"write the discovery as <host>:<port>-<hex public key of the discovery>")
+			continue
+		}
+		if err := checkServiceAddress(d[:i]); err != nil {
+			valid = false
+			r.fail(fmt.Sprintf("discovery %q in %s: %v", d, path, err), "fix the host and port of the discovery")
+		}
+		if _, _, err := parseKeys(d[i+1:], ""); err != nil {
+			valid = false
+			r.fail(fmt.Sprintf("discovery %q in %s has an invalid key: %v", d, path, err),
+				"copy the hex public key from the keys.json of the discovery")
+		}
+	}
+	fields := []struct{ name, value string }{
+		{"address", c.Address},
+		{"web_port", c.WebPort},
+		{"manager_addr", c.ManagerAddr},
+		{"manager_web", c.ManagerWeb},
+	}
+	for _, f := range fields {
+		if len(f.value) == 0 {
+			continue
+		}
+		if f.name == "manager_addr" && checkServiceAddress(f.value) == nil {
+			continue
+		}
+		if err := checkHostPort(f.value, true); err != nil {
+			valid = false
+			r.fail(fmt.Sprintf("%s %q in %s: %v", f.name, f.value, path, err), "write "+f.name+" as <host>:<port> or :<port>")
+		}
+	}
+	if c.ConnectManager && len(c.ManagerAddr) == 0 {
+		valid = false
+		r.fail(fmt.Sprintf("node config %s connects to a manager without manager_addr", path),
+			`set manager_addr or "connect_manager": false`)
+	}
+	return
+}
+
+func checkHostPort(address string, emptyHost bool) error {
+	host, p, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if len(host) == 0 && !emptyHost {
+		return errors.New("missing host")
+	}
+	port, err := strconv.Atoi(p)
+	if err != nil || port < 1 || port > 65535 {
+		return fmt.Errorf("invalid port %s", p)
+	}
+	return nil
+}
+
+// checkServiceAddress accepts a host and port or the name of SRV records, like
+// _skywire-discovery._tcp.example.com
+func checkServiceAddress(address string) error {
+	if _, _, err := net.SplitHostPort(address); err != nil && strings.HasPrefix(address, "_") {
+		return nil
+	}
+	return checkHostPort(address, false)
+}
+
+func checkQuotas(r *checkReport, path string) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return
+	}
+	config, err := factory.LoadQuotaConfig(path)
+	if err != nil {
+		r.fail(fmt.Sprintf("quota config %s: %v", path, err), "fix the json, see the quotas in the README")
+		return
+	}
+	valid := true
+	for key, q := range config.Apps {
+		if key != "*" {
+			if _, _, err := parseKeys(key, ""); err != nil {
+				valid = false
+				r.fail(fmt.Sprintf("quota config %s: app %q is not a public key", path, key),
+					`use the hex public key of the app, or "*" for the apps not listed`)
+			}
+		}
+		if q.BytesPerSecond < 0 || q.MonthlyBytes < 0 {
+			valid = false
+			r.fail(fmt.Sprintf("quota config %s: app %s has a negative quota", path, key), "use 0 for no limit")
+		}
+	}
+	if valid {
+		r.ok("quota config %s", path)
+	}
+}
+
+// checkListenAddrs reports listen addresses shared by the node and manager and the ones
+// another process listens on
+func checkListenAddrs(r *checkReport, addrs []listenAddr) {
+	ports := make(map[string]listenAddr)
+	for _, a := range addrs {
+		_, port, err := net.SplitHostPort(a.address)
+		if err != nil {
+			r.fail(fmt.Sprintf("%s %s %q: %v", a.owner, a.flag, a.address, err), "write the address as <host>:<port> or :<port>")
+			continue
+		}
+		if o, ok := ports[port]; ok {
+			r.fail(fmt.Sprintf("%s %s and %s %s both use port %s", o.owner, o.flag, a.owner, a.flag, port),
+				fmt.Sprintf("give the %s another port with %s", a.owner, a.flag))
+			continue
+		}
+		ports[port] = a
+		ln, err := net.Listen("tcp", a.address)
+		if err == nil && a.udp {
+			var pc net.PacketConn
+			pc, err = net.ListenPacket("udp", a.address)
+			if err == nil {
+				pc.Close()
+			}
+		}
+		if ln != nil {
+			ln.Close()
+		}
+		if err != nil {
+			r.warn(fmt.Sprintf("%s %s %s is taken: %v", a.owner, a.flag, a.address, err),
+				fmt.Sprintf("ignore it if the %s is running, else stop the process on port %s (lsof -i :%s) or change %s", a.owner, port, port, a.flag))
+			continue
+		}
+		r.ok("%s %s %s is free", a.owner, a.flag, a.address)
+	}
+}
+
+func probeTCP(r *checkReport, name, address string, timeout time.Duration, fix string) {
+	start := time.Now()
+	// the address may be the name of SRV records or a host with several addresses
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	addrs, err := resolver.NewEndpoints(dns).Resolve(ctx, address)
+	cancel()
+	var c net.Conn
+	for _, a := range addrs {
+		c, err = net.DialTimeout("tcp", a, timeout)
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		r.fail(fmt.Sprintf("%s unreachable: %v", name, err), fix)
+		return
+	}
+	c.Close()
+	r.ok("%s reachable in %s", name, time.Since(start).Round(time.Millisecond))
+}
+
+func probeHTTP(r *checkReport, name, address string, timeout time.Duration, fix string) {
+	if strings.HasPrefix(address, ":") {
+		address = "127.0.0.1" + address
+	}
+	client := &http.Client{Timeout: timeout}
+	res, err := client.Get("http://" + address + "/")
+	if err != nil {
+		r.fail(fmt.Sprintf("%s unreachable: %v", name, err), fix)
+		return
+	}
+	res.Body.Close()
+	r.ok("%s answered %s", name, res.Status)
+}
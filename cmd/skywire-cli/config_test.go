@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/skycoin/skywire/pkg/net/skycoin-messenger/factory"
+	"github.com/skycoin/skywire/pkg/node"
+)
+
+// runCheck runs config check with the args and returns what it printed
+func runCheck(t *testing.T, args ...string) (out string, err error) {
+	f, err := ioutil.TempFile("", "check")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	defer func(stdout *os.File) { os.Stdout = stdout }(os.Stdout)
+	os.Stdout = f
+	err = configCheck(args)
+	b, e := ioutil.ReadFile(f.Name())
+	if e != nil {
+		t.Fatal(e)
+	}
+	return string(b), err
+}
+
+// freeAddr returns a local address nothing listens on
+func freeAddr(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+// writeNodeConf writes the config of the node key in the state directory
+func writeNodeConf(t *testing.T, dir, key string, c *node.Config) {
+	d, err := json.Marshal(node.NodeConfigs{Version: 1, Configs: map[string]*node.Config{key: c}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeState(t, dir, map[string]string{"node/conf.json": string(d)})
+}
+
+func TestConfigCheck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sc := factory.NewSeedConfig()
+	if err = factory.WriteSeedConfig(sc, filepath.Join(dir, "node", "keys.json")); err != nil {
+		t.Fatal(err)
+	}
+	if err = factory.WriteSeedConfig(factory.NewSeedConfig(), filepath.Join(dir, "discovery", "keys.json")); err != nil {
+		t.Fatal(err)
+	}
+	discovery, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer discovery.Close()
+	manager, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer manager.Close()
+	web := httptest.NewServer(http.NotFoundHandler())
+	defer web.Close()
+	conf := &node.Config{
+		DiscoveryAddresses: node.Addresses{discovery.Addr().String() + "-" + factory.NewSeedConfig().PublicKey},
+		ConnectManager:     true,
+		ManagerAddr:        manager.Addr().String(),
+		ManagerWeb:         web.Listener.Addr().String(),
+		Address:            freeAddr(t),
+		WebPort:            freeAddr(t),
+	}
+	writeNodeConf(t, dir, sc.PublicKey, conf)
+	managerAddrs := []string{"-manager-address", freeAddr(t), "-manager-web-port", freeAddr(t)}
+
+	out, err := runCheck(t, append([]string{"-dir", dir}, managerAddrs...)...)
+	if err != nil || !strings.Contains(out, "\n0 errors, 0 warnings\n") {
+		t.Fatalf("valid config: %v\n%s", err, out)
+	}
+	for _, want := range []string{"ok     keys of the node " + sc.PublicKey, "discovery " + conf.DiscoveryAddresses[0] + " reachable", "web api of the manager answered 404"} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("missing %q\n%s", want, out)
+		}
+	}
+
+	// each problem is reported with its fix
+	taken, err := net.Listen("tcp", conf.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+	conf.DiscoveryAddresses = node.Addresses{"127.0.0.1:1-nothex"}
+	conf.ManagerAddr = ""
+	conf.WebPort = managerAddrs[3]
+	writeNodeConf(t, dir, sc.PublicKey, conf)
+	writeState(t, dir, map[string]string{
+		"discovery/keys.json": "{",
+		"node/quotas.json":    `{"apps":{"*":{"monthly_bytes":-1}}}`,
+	})
+	out, err = runCheck(t, append([]string{"-dir", dir, "-probe=false"}, managerAddrs...)...)
+	if err == nil {
+		t.Fatalf("invalid config passed\n%s", out)
+	}
+	for _, want := range []string{
+		"keys of the manager at " + filepath.Join(dir, "discovery", "keys.json") + " are not valid json",
+		`discovery "127.0.0.1:1-nothex" in ` + filepath.Join(dir, "node", "conf.json") + " has an invalid key",
+		`fix: set manager_addr or "connect_manager": false`,
+		"negative quota\n       fix: use 0 for no limit",
+		"node -web-port and manager -web-port both use port",
+		"warn   node -address " + conf.Address + " is taken",
+		"\n5 errors, 1 warnings\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("missing %q\n%s", want, out)
+		}
+	}
+
+	// the unknown fields are not left out silently, the services are probed
+	writeState(t, dir, map[string]string{"node/conf.json": `{"version":1,"configs":{"` + sc.PublicKey + `":{"web_prot":":6001"}}}`})
+	out, _ = runCheck(t, append([]string{"-dir", dir}, managerAddrs...)...)
+	if !strings.Contains(out, `unknown field "web_prot"`) || !strings.Contains(out, "fix: the configs know") {
+		t.Fatalf("unknown field\n%s", out)
+	}
+	conf.DiscoveryAddresses = node.Addresses{freeAddr(t) + "-" + factory.NewSeedConfig().PublicKey}
+	conf.ManagerAddr, conf.WebPort = manager.Addr().String(), freeAddr(t)
+	writeNodeConf(t, dir, sc.PublicKey, conf)
+	out, _ = runCheck(t, append([]string{"-dir", dir}, managerAddrs...)...)
+	if !strings.Contains(out, "discovery "+conf.DiscoveryAddresses[0]+" unreachable") {
+		t.Fatalf("unreachable discovery\n%s", out)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// diagnostics as returned by the manager, the sections are written as separate files
+type diagnostics struct {
+	Version    string          `json:"version"`
+	GoVersion  string          `json:"go_version"`
+	OS         string          `json:"os"`
+	Arch       string          `json:"arch"`
+	Uptime     int64           `json:"uptime"`
+	Info       json.RawMessage `json:"info"`
+	Metrics    json.RawMessage `json:"metrics"`
+	Apps       json.RawMessage `json:"apps"`
+	Config     json.RawMessage `json:"config"`
+	AutoStart  json.RawMessage `json:"auto_start"`
+	Logs       []string        `json:"logs"`
+	Goroutines string          `json:"goroutines"`
+}
+
+func diag(args []string) (err error) {
+	fs := flag.NewFlagSet("node diag", flag.ExitOnError)
+	key := fs.String("key", "", "public key of the node")
+	output := fs.String("o", "", "archive to write, default skywire-diag-<node key>-<time>.tar.gz")
+	fs.Parse(args)
+	if len(*key) < 8 {
+		return errors.New("-key of the node is required")
+	}
+
+	res, err := request("GET", "/conn/getNodeDiag", url.Values{"key": {*key}})
+	if err != nil {
+		return
+	}
+	var d diagnostics
+	err = json.Unmarshal(res, &d)
+	if err != nil {
+		return
+	}
+	version, err := json.MarshalIndent(map[string]interface{}{
+		"node_key":   *key,
+		"version":    d.Version,
+		"go_version": d.GoVersion,
+		"os":         d.OS,
+		"arch":       d.Arch,
+		"uptime":     d.Uptime,
+	}, "", "  ")
+	if err != nil {
+		return
+	}
+	files := []struct {
+		name string
+		data []byte
+	}{
+		{"version.json", version},
+		{"info.json", indent(d.Info)},
+		{"metrics.json", indent(d.Metrics)},
+		{"apps.json", indent(d.Apps)},
+		{"config.json", indent(d.Config)},
+		{"auto_start.json", indent(d.AutoStart)},
+		{"logs.txt", []byte(strings.Join(d.Logs, "\n") + "\n")},
+		{"goroutines.txt", []byte(d.Goroutines)},
+	}
+
+	now := time.Now()
+	if len(*output) == 0 {
+		*output = fmt.Sprintf("skywire-diag-%s-%s.tar.gz", (*key)[:8], now.Format("20060102-150405"))
+	}
+	// the files are in a directory named after the archive, wherever it is written
+	dir := strings.TrimSuffix(strings.TrimSuffix(filepath.Base(*output), ".gz"), ".tar")
+	var b bytes.Buffer
+	gw := gzip.NewWriter(&b)
+	tw := tar.NewWriter(gw)
+	for _, f := range files {
+		if len(f.data) == 0 {
+			continue
+		}
+		err = writeTarFile(tw, dir+"/"+f.name, f.data, 0644)
+		if err != nil {
+			return
+		}
+	}
+	err = tw.Close()
+	if err != nil {
+		return
+	}
+	err = gw.Close()
+	if err != nil {
+		return
+	}
+	err = ioutil.WriteFile(*output, b.Bytes(), 0644)
+	if err != nil {
+		return
+	}
+	fmt.Printf("diagnostics of node %s written to %s\n", *key, *output)
+	return
+}
+
+func indent(raw json.RawMessage) []byte {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil
+	}
+	var b bytes.Buffer
+	if json.Indent(&b, raw, "", "  ") != nil {
+		return raw
+	}
+	return b.Bytes()
+}
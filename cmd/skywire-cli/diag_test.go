@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDiag(t *testing.T) {
+	key := strings.Repeat("02", 33)
+	manager := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/conn/getNodeDiag" || r.FormValue("key") != key {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"version":    "1.2.3",
+			"info":       map[string]interface{}{"transports": []string{}},
+			"config":     map[string]interface{}{"web_port": ":8000"},
+			"logs":       []string{"first", "second"},
+			"goroutines": "goroutine 1 [running]:",
+		})
+	}))
+	defer manager.Close()
+	defer func(u string) { managerURL = u }(managerURL)
+	managerURL = manager.URL
+
+	tmp, err := ioutil.TempDir("", "diag")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	archive := filepath.Join(tmp, "diag.tar.gz")
+	if err = diag([]string{"-key", key, "-o", archive}); err != nil {
+		t.Fatal(err)
+	}
+	files, err := readSnapshot(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := "diag/"
+	if !strings.Contains(string(files[dir+"version.json"]), `"version": "1.2.3"`) || !strings.Contains(string(files[dir+"version.json"]), key) {
+		t.Fatalf("version %s", files[dir+"version.json"])
+	}
+	if string(files[dir+"logs.txt"]) != "first\nsecond\n" || string(files[dir+"goroutines.txt"]) != "goroutine 1 [running]:" {
+		t.Fatalf("files %v", files)
+	}
+	if !strings.Contains(string(files[dir+"config.json"]), "\n  \"web_port\"") {
+		t.Fatalf("config not indented %s", files[dir+"config.json"])
+	}
+	// the sections the node left out are not archived
+	for _, name := range []string{"metrics.json", "apps.json", "auto_start.json"} {
+		if _, ok := files[dir+name]; ok {
+			t.Errorf("empty %s archived", name)
+		}
+	}
+
+	if err = diag([]string{"-key", strings.Repeat("03", 33), "-o", archive}); err == nil {
+		t.Fatal("diag of a node the manager does not know")
+	}
+	if err = diag(nil); err == nil {
+		t.Fatal("diag without a key")
+	}
+}
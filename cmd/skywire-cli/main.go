@@ -0,0 +1,121 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/skycoin/skywire/pkg/envflag"
+	"github.com/skycoin/skywire/pkg/httputil"
+)
+
+// command of the cli, run gets the arguments after the command name
+type command struct {
+	usage string
+	run   func(args []string) error
+}
+
+var commands = map[string]command{
+	"config":   {"check the configs of the local node and manager", configCmd},
+	"node":     {"snapshot, restore, diagnose and profile nodes", nodeCmd},
+	"topology": {"export the network topology known to the manager", topology},
+	"route":    {"explain why a transport of a node went through its discoveries", route},
+}
+
+var (
+	managerURL string
+	token      string
+)
+
+const requestTimeout = time.Minute
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: skywire-cli [flags] <command> [command flags]\n\ncommands:\n")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", name, commands[name].usage)
+	}
+	fmt.Fprintf(os.Stderr, "\nflags:\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.StringVar(&managerURL, "manager", "http://127.0.0.1:8000", "url of the manager web api")
+	flag.StringVar(&token, "token", "", "api token of the manager, see /auth/createToken")
+	flag.Usage = usage
+	err := envflag.Parse(flag.CommandLine, "SKYWIRE_CLI", os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+	c, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %s\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+	err = c.run(flag.Args()[1:])
+	if e, ok := err.(*httputil.Error); ok {
+		fmt.Fprintf(os.Stderr, "manager returned %d (%s): %s\n", e.Status, e.Code, e.Message)
+		if e.Retryable {
+			fmt.Fprintln(os.Stderr, "the request may succeed when it is sent again later")
+		}
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// request sends the form (values) to the manager api path with the api token
+func request(method, path string, values url.Values) (body []byte, err error) {
+	return requestWithTimeout(method, path, values, requestTimeout)
+}
+
+func requestWithTimeout(method, path string, values url.Values, timeout time.Duration) (body []byte, err error) {
+	u := strings.TrimRight(managerURL, "/") + path
+	var req *http.Request
+	if method == "GET" {
+		req, err = http.NewRequest(method, u+"?"+values.Encode(), nil)
+	} else {
+		req, err = http.NewRequest(method, u, strings.NewReader(values.Encode()))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	}
+	if err != nil {
+		return
+	}
+	if len(token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	client := &http.Client{Timeout: timeout}
+	res, err := client.Do(req)
+	if err != nil {
+		return
+	}
+	defer res.Body.Close()
+	body, err = ioutil.ReadAll(res.Body)
+	if err != nil {
+		return
+	}
+	if res.StatusCode != http.StatusOK {
+		err = httputil.ReadError(res, body)
+	}
+	return
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// maintenance drains a node before a reboot: the node takes no new transports and the
+// discoveries stop sending it setups while the transports open finish
+func maintenance(args []string) (err error) {
+	fs := flag.NewFlagSet("node maintenance", flag.ExitOnError)
+	key := fs.String("key", "", "public key of the node")
+	duration := fs.Duration("duration", 30*time.Minute, "how long the node is drained, 0 ends the maintenance")
+	fs.Parse(args)
+	if len(*key) < 8 {
+		return errors.New("-key of the node is required")
+	}
+
+	res, err := request("POST", "/conn/setNodeMaintenance", url.Values{
+		"key":      {*key},
+		"duration": {duration.String()},
+	})
+	if err != nil {
+		return
+	}
+	var s struct {
+		Until      int64 `json:"until"`
+		Transports int   `json:"transports"`
+	}
+	err = json.Unmarshal(res, &s)
+	if err != nil {
+		return
+	}
+	if s.Until == 0 {
+		fmt.Printf("maintenance of node %s ended, %d transports open\n", *key, s.Transports)
+		return
+	}
+	fmt.Printf("node %s in maintenance until %s, %d transports open\n", *key,
+		time.Unix(s.Until, 0).Format(time.RFC3339), s.Transports)
+	return
+}
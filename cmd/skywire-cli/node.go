@@ -0,0 +1,312 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/skycoin/skycoin/src/util/file"
+	"github.com/skycoin/skywire/pkg/net/skycoin-messenger/factory"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	manifestName = "snapshot.json"
+	// suffix of the key files encrypted with the snapshot password
+	encryptedSuffix = ".enc"
+	snapshotVersion = 1
+	pbkdf2Rounds    = 100000
+	saltSize        = 16
+)
+
+// state directories of the node and its apps, the manager state is not part of a node
+var snapshotDirs = []string{"node", "sc", "ss", "sshc", "sshs"}
+
+type snapshotManifest struct {
+	Version   int    `json:"version"`
+	Time      int64  `json:"time"`
+	NodeKey   string `json:"node_key"`
+	Encrypted bool   `json:"encrypted"`
+}
+
+var nodeCommands = map[string]command{
+	"snapshot":    {"archive the config, keys and app state of the local node", snapshot},
+	"restore":     {"restore a snapshot on this machine", restore},
+	"diag":        {"collect the diagnostics of a node from the manager for a bug report", diag},
+	"profile":     {"capture a cpu or runtime profile or an execution trace of a node", profile},
+	"transports":  {"list the transports of a node, a json object per line", transports},
+	"pair":        {"pair the manager with the node showing the code", pair},
+	"maintenance": {"drain a node before a reboot, it takes no new transports for -duration", maintenance},
+}
+
+func nodeCmd(args []string) (err error) {
+	if len(args) == 0 {
+		return errors.New("usage: skywire-cli node snapshot|restore|diag|profile|transports|pair|maintenance [flags]")
+	}
+	c, ok := nodeCommands[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown node command %s", args[0])
+	}
+	return c.run(args[1:])
+}
+
+func defaultStateDir() string {
+	return filepath.Join(file.UserHome(), ".skywire")
+}
+
+func snapshot(args []string) (err error) {
+	fs := flag.NewFlagSet("node snapshot", flag.ExitOnError)
+	dir := fs.String("dir", defaultStateDir(), "state directory of the node")
+	password := fs.String("password", "", "encrypt the keys with the password")
+	output := fs.String("o", "", "archive to write, default skywire-<node key>-<time>.tar.gz")
+	fs.Parse(args)
+
+	sc, err := factory.ReadSeedConfig(filepath.Join(*dir, "node", "keys.json"))
+	if err != nil {
+		return fmt.Errorf("no node keys in %s: %v", *dir, err)
+	}
+	now := time.Now()
+	manifest := snapshotManifest{
+		Version:   snapshotVersion,
+		Time:      now.Unix(),
+		NodeKey:   sc.PublicKey,
+		Encrypted: len(*password) > 0,
+	}
+	if len(*output) == 0 {
+		*output = fmt.Sprintf("skywire-%s-%s.tar.gz", sc.PublicKey[:8], now.Format("20060102-150405"))
+	}
+
+	var b bytes.Buffer
+	gw := gzip.NewWriter(&b)
+	tw := tar.NewWriter(gw)
+	d, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return
+	}
+	err = writeTarFile(tw, manifestName, d, 0644)
+	if err != nil {
+		return
+	}
+	for _, sub := range snapshotDirs {
+		root := filepath.Join(*dir, sub)
+		err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) && path == root {
+					return nil
+				}
+				return err
+			}
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+			d, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			name, err := filepath.Rel(*dir, path)
+			if err != nil {
+				return err
+			}
+			name = filepath.ToSlash(name)
+			if manifest.Encrypted && filepath.Base(path) == "keys.json" {
+				d, err = encrypt(d, *password)
+				if err != nil {
+					return err
+				}
+				name += encryptedSuffix
+			}
+			return writeTarFile(tw, name, d, info.Mode().Perm())
+		})
+		if err != nil {
+			return
+		}
+	}
+	err = tw.Close()
+	if err != nil {
+		return
+	}
+	err = gw.Close()
+	if err != nil {
+		return
+	}
+	err = ioutil.WriteFile(*output, b.Bytes(), 0600)
+	if err != nil {
+		return
+	}
+	fmt.Printf("snapshot of node %s written to %s\n", sc.PublicKey, *output)
+	return
+}
+
+func restore(args []string) (err error) {
+	fs := flag.NewFlagSet("node restore", flag.ExitOnError)
+	dir := fs.String("dir", defaultStateDir(), "state directory of the node")
+	password := fs.String("password", "", "password the keys were encrypted with")
+	force := fs.Bool("force", false, "overwrite the state of an existing node")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: skywire-cli node restore [flags] <snapshot.tar.gz>")
+	}
+
+	files, err := readSnapshot(fs.Arg(0))
+	if err != nil {
+		return
+	}
+	var manifest snapshotManifest
+	d, ok := files[manifestName]
+	if !ok {
+		return errors.New("not a node snapshot, snapshot.json is missing")
+	}
+	err = json.Unmarshal(d, &manifest)
+	if err != nil {
+		return
+	}
+	if manifest.Version > snapshotVersion {
+		return fmt.Errorf("snapshot version %d is not supported", manifest.Version)
+	}
+	if manifest.Encrypted && len(*password) == 0 {
+		return errors.New("the keys of the snapshot are encrypted, -password is required")
+	}
+	delete(files, manifestName)
+
+	// decrypt everything before writing anything, a wrong password leaves the node untouched
+	for name, d := range files {
+		if !strings.HasSuffix(name, encryptedSuffix) {
+			continue
+		}
+		d, err = decrypt(d, *password)
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		delete(files, name)
+		files[strings.TrimSuffix(name, encryptedSuffix)] = d
+	}
+
+	keys := filepath.Join(*dir, "node", "keys.json")
+	if sc, e := factory.ReadSeedConfig(keys); e == nil && !*force {
+		return fmt.Errorf("node %s exists in %s, use -force to replace it", sc.PublicKey, *dir)
+	}
+	for name, d := range files {
+		path := filepath.Join(*dir, filepath.FromSlash(name))
+		if !strings.HasPrefix(path, filepath.Clean(*dir)+string(filepath.Separator)) {
+			return fmt.Errorf("invalid file %s in snapshot", name)
+		}
+		err = os.MkdirAll(filepath.Dir(path), 0700)
+		if err != nil {
+			return
+		}
+		err = ioutil.WriteFile(path, d, 0600)
+		if err != nil {
+			return
+		}
+	}
+	fmt.Printf("node %s restored to %s\n", manifest.NodeKey, *dir)
+	return
+}
+
+func writeTarFile(tw *tar.Writer, name string, d []byte, mode os.FileMode) (err error) {
+	err = tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    int64(mode),
+		Size:    int64(len(d)),
+		ModTime: time.Now(),
+	})
+	if err != nil {
+		return
+	}
+	_, err = tw.Write(d)
+	return
+}
+
+func readSnapshot(path string) (files map[string][]byte, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return
+	}
+	tr := tar.NewReader(gr)
+	files = make(map[string][]byte)
+	for {
+		var h *tar.Header
+		h, err = tr.Next()
+		if err == io.EOF {
+			err = nil
+			return
+		}
+		if err != nil {
+			return
+		}
+		if h.Typeflag != tar.TypeReg {
+			continue
+		}
+		files[h.Name], err = ioutil.ReadAll(tr)
+		if err != nil {
+			return
+		}
+	}
+}
+
+func passwordCipher(password string, salt []byte) (aead cipher.AEAD, err error) {
+	block, err := aes.NewCipher(pbkdf2.Key([]byte(password), salt, pbkdf2Rounds, 32, sha256.New))
+	if err != nil {
+		return
+	}
+	return cipher.NewGCM(block)
+}
+
+// encrypt returns salt, nonce and the sealed data
+func encrypt(d []byte, password string) (sealed []byte, err error) {
+	salt := make([]byte, saltSize)
+	_, err = rand.Read(salt)
+	if err != nil {
+		return
+	}
+	aead, err := passwordCipher(password, salt)
+	if err != nil {
+		return
+	}
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return
+	}
+	sealed = append(salt, nonce...)
+	sealed = aead.Seal(sealed, nonce, d, nil)
+	return
+}
+
+func decrypt(sealed []byte, password string) (d []byte, err error) {
+	if len(sealed) < saltSize {
+		return nil, errors.New("encrypted data too short")
+	}
+	aead, err := passwordCipher(password, sealed[:saltSize])
+	if err != nil {
+		return
+	}
+	sealed = sealed[saltSize:]
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("encrypted data too short")
+	}
+	d, err = aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		err = errors.New("wrong password")
+	}
+	return
+}
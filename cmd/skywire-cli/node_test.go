@@ -0,0 +1,192 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/skycoin/skywire/pkg/net/skycoin-messenger/factory"
+)
+
+// writeState writes the files of a node state directory, by path relative to dir
+func writeState(t *testing.T, dir string, files map[string]string) {
+	for name, d := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(d), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSnapshotAndRestore(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	dir := filepath.Join(tmp, "old")
+	sc := factory.NewSeedConfig()
+	if err = factory.WriteSeedConfig(sc, filepath.Join(dir, "node", "keys.json")); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := ioutil.ReadFile(filepath.Join(dir, "node", "keys.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"node/autoStart.json":        `{"sockss":true}`,
+		"node/transports/2018.log":   "transport record",
+		"sshs/keys.json":             `{"app":"sshs"}`,
+		"manager/config.json":        "state of the manager",
+		"sshc/known/discovery.json":  "saved discovery",
+		"unrelated/not-a-state.json": "left out",
+	}
+	writeState(t, dir, files)
+	archive := filepath.Join(tmp, "node.tar.gz")
+	if err = snapshot([]string{"-dir", dir, "-password", "secret", "-o", archive}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the keys are encrypted in the archive, the rest of the state is not
+	archived, err := readSnapshot(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := archived["node/keys.json"]; ok {
+		t.Fatal("node keys archived in clear")
+	}
+	if d := archived["node/keys.json"+encryptedSuffix]; len(d) == 0 || bytes.Contains(d, []byte(sc.SecKey)) {
+		t.Fatalf("encrypted keys %q", d)
+	}
+	if _, ok := archived["manager/config.json"]; ok {
+		t.Fatal("manager state archived")
+	}
+	if _, ok := archived["unrelated/not-a-state.json"]; ok {
+		t.Fatal("unrelated directory archived")
+	}
+
+	restored := filepath.Join(tmp, "new")
+	if err = restore([]string{"-dir", restored, archive}); err == nil || !strings.Contains(err.Error(), "-password") {
+		t.Fatalf("restored without the password: %v", err)
+	}
+	// a wrong password leaves the directory untouched
+	if err = restore([]string{"-dir", restored, "-password", "wrong", archive}); err == nil || !strings.Contains(err.Error(), "wrong password") {
+		t.Fatalf("restored with a wrong password: %v", err)
+	}
+	if _, err = os.Stat(restored); !os.IsNotExist(err) {
+		t.Fatalf("written with a wrong password: %v", err)
+	}
+	if err = restore([]string{"-dir", restored, "-password", "secret", archive}); err != nil {
+		t.Fatal(err)
+	}
+	if d, err := ioutil.ReadFile(filepath.Join(restored, "node", "keys.json")); err != nil || !bytes.Equal(d, keys) {
+		t.Fatalf("keys restored as %q: %v", d, err)
+	}
+	for name, want := range files {
+		d, err := ioutil.ReadFile(filepath.Join(restored, filepath.FromSlash(name)))
+		top := strings.Split(name, "/")[0]
+		if top == "manager" || top == "unrelated" {
+			if !os.IsNotExist(err) {
+				t.Errorf("%s restored", name)
+			}
+			continue
+		}
+		if err != nil || string(d) != want {
+			t.Errorf("%s restored as %q: %v", name, d, err)
+		}
+	}
+
+	// the node restored is not replaced without -force
+	if err = restore([]string{"-dir", restored, "-password", "secret", archive}); err == nil || !strings.Contains(err.Error(), sc.PublicKey) {
+		t.Fatalf("existing node replaced: %v", err)
+	}
+	if err = restore([]string{"-dir", restored, "-password", "secret", "-force", archive}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSnapshotWithoutPassword(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	dir := filepath.Join(tmp, "old")
+	archive := filepath.Join(tmp, "node.tar.gz")
+	if err = snapshot([]string{"-dir", dir, "-o", archive}); err == nil {
+		t.Fatal("snapshot of a directory without a node")
+	}
+	if err = factory.WriteSeedConfig(factory.NewSeedConfig(), filepath.Join(dir, "node", "keys.json")); err != nil {
+		t.Fatal(err)
+	}
+	if err = snapshot([]string{"-dir", dir, "-o", archive}); err != nil {
+		t.Fatal(err)
+	}
+	archived, err := readSnapshot(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := archived["node/keys.json"]; !ok {
+		t.Fatalf("keys not archived %v", archived)
+	}
+	restored := filepath.Join(tmp, "new")
+	if err = restore([]string{"-dir", restored, archive}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = factory.ReadSeedConfig(filepath.Join(restored, "node", "keys.json")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRestoreInvalidSnapshot(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	archive := func(files map[string]string) string {
+		var b bytes.Buffer
+		gw := gzip.NewWriter(&b)
+		tw := tar.NewWriter(gw)
+		for name, d := range files {
+			if err := writeTarFile(tw, name, []byte(d), 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+		tw.Close()
+		gw.Close()
+		f, err := ioutil.TempFile(tmp, "archive")
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write(b.Bytes())
+		f.Close()
+		return f.Name()
+	}
+	dir := filepath.Join(tmp, "node")
+	for _, c := range []struct {
+		name  string
+		files map[string]string
+		err   string
+	}{
+		{"no manifest", map[string]string{"node/keys.json": "{}"}, "snapshot.json is missing"},
+		{"newer version", map[string]string{manifestName: `{"version":2}`}, "not supported"},
+		{"file outside the directory", map[string]string{manifestName: `{"version":1}`, "../escaped": "x"}, "invalid file"},
+	} {
+		err := restore([]string{"-dir", dir, archive(c.files)})
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%s: %v", c.name, err)
+		}
+	}
+	if _, err = os.Stat(filepath.Join(tmp, "escaped")); !os.IsNotExist(err) {
+		t.Fatal("file written outside the directory")
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+)
+
+// pair enters the pairing code a node shows in the manager
+func pair(args []string) (err error) {
+	if len(args) != 1 {
+		return errors.New("usage: skywire-cli node pair <code shown by the node>")
+	}
+	res, err := request("POST", "/pairing/pair", url.Values{"code": {args[0]}})
+	if err != nil {
+		return
+	}
+	var n struct {
+		Key string `json:"key"`
+	}
+	err = json.Unmarshal(res, &n)
+	if err != nil {
+		return
+	}
+	fmt.Printf("paired with node %s\n", n.Key)
+	return
+}
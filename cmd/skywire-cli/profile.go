@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+func profile(args []string) (err error) {
+	fs := flag.NewFlagSet("node profile", flag.ExitOnError)
+	key := fs.String("key", "", "public key of the node")
+	output := fs.String("o", "", "file to write, default <type>-<node key>-<time>.pprof or .trace")
+	fs.Usage = func() {
+		fmt.Println("usage: skywire-cli node profile -key <node key> [-o file] cpu|heap|goroutine|block|mutex|allocs|threadcreate|trace [duration]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if len(*key) < 8 || fs.NArg() < 1 || fs.NArg() > 2 {
+		fs.Usage()
+		return errors.New("-key and the profile type are required")
+	}
+	typ := fs.Arg(0)
+	d := 30 * time.Second
+	if fs.NArg() == 2 {
+		d, err = time.ParseDuration(fs.Arg(1))
+		if err != nil {
+			return
+		}
+	}
+	seconds := int(d / time.Second)
+	if seconds < 1 {
+		return errors.New("the duration must be at least 1s")
+	}
+
+	res, err := requestWithTimeout("GET", "/conn/getNodeProfile", url.Values{
+		"key":     {*key},
+		"type":    {typ},
+		"seconds": {strconv.Itoa(seconds)},
+	}, d+requestTimeout)
+	if err != nil {
+		return
+	}
+	if len(*output) == 0 {
+		ext := "pprof"
+		if typ == "trace" {
+			ext = "trace"
+		}
+		*output = fmt.Sprintf("%s-%s-%s.%s", typ, (*key)[:8], time.Now().Format("20060102-150405"), ext)
+	}
+	err = ioutil.WriteFile(*output, res, 0644)
+	if err != nil {
+		return
+	}
+	if typ == "trace" {
+		fmt.Printf("trace written to %s, view it with go tool trace %s\n", *output, *output)
+	} else {
+		fmt.Printf("%s profile written to %s, view it with go tool pprof %s\n", typ, *output, *output)
+	}
+	return
+}
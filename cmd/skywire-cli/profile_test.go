@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProfile(t *testing.T) {
+	key := strings.Repeat("02", 33)
+	manager := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/conn/getNodeProfile" || r.FormValue("key") != key {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, "%s %s", r.FormValue("type"), r.FormValue("seconds"))
+	}))
+	defer manager.Close()
+	defer func(u string) { managerURL = u }(managerURL)
+	managerURL = manager.URL
+
+	tmp, err := ioutil.TempDir("", "profile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	output := filepath.Join(tmp, "profile")
+	for _, c := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"heap"}, "heap 30"},
+		{[]string{"cpu", "2s"}, "cpu 2"},
+		{[]string{"trace", "1m"}, "trace 60"},
+	} {
+		if err = profile(append([]string{"-key", key, "-o", output}, c.args...)); err != nil {
+			t.Fatalf("%v: %v", c.args, err)
+		}
+		if d, err := ioutil.ReadFile(output); err != nil || string(d) != c.want {
+			t.Fatalf("%v: saved %q %v", c.args, d, err)
+		}
+	}
+
+	for _, args := range [][]string{
+		{"-key", key},
+		{"heap"},
+		{"-key", key, "cpu", "500ms"},
+		{"-key", key, "cpu", "soon"},
+		{"-key", key, "cpu", "1s", "extra"},
+		{"-key", strings.Repeat("03", 33), "heap"},
+	} {
+		if err = profile(args); err == nil {
+			t.Errorf("%v: no error", args)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/skycoin/skywire/pkg/net/skycoin-messenger/factory"
+)
+
+func route(args []string) (err error) {
+	if len(args) == 0 || args[0] != "explain" {
+		return errors.New("usage: skywire-cli route explain -key <node key> <route>")
+	}
+	return explainRoute(args[1:])
+}
+
+// explainRoute prints the inputs of the route decision of a transport and the steps of its
+// replay on the node
+func explainRoute(args []string) (err error) {
+	fs := flag.NewFlagSet("route explain", flag.ExitOnError)
+	key := fs.String("key", "", "public key of the node that set up the transport")
+	raw := fs.Bool("json", false, "print the explanation as json")
+	fs.Parse(args)
+	if len(*key) == 0 {
+		return errors.New("-key of the node is required")
+	}
+	if fs.NArg() != 1 {
+		return errors.New("the route of the transport is required, it is in the transports of the node info")
+	}
+	res, err := request("GET", "/conn/explainNodeRoute", url.Values{
+		"key": {*key},
+		"id":  {fs.Arg(0)},
+	})
+	if err != nil {
+		return
+	}
+	if *raw {
+		_, err = os.Stdout.Write(append(res, '\n'))
+		return
+	}
+	var e factory.RouteExplanation
+	err = json.Unmarshal(res, &e)
+	if err != nil {
+		return
+	}
+	d := e.Decision
+	fmt.Printf("route %s to node %s app %s at %s\n", d.ID, d.Node, d.App, time.Unix(d.Time, 0).Format(time.RFC3339))
+	if d.Cost != nil {
+		fmt.Printf("path cost: hops=%d,latency=%d,load=%d,reputation=%d\n", d.Cost.Hops, d.Cost.Latency, d.Cost.Load, d.Cost.Reputation)
+	}
+	fmt.Println("candidates:")
+	for _, c := range d.Candidates {
+		fmt.Printf("  %s hops %d latency %dms load %d reputation %.2f\n", c.Discovery, c.Hops, c.Latency, c.Load, c.Reputation)
+	}
+	fmt.Println("replay:")
+	for _, s := range e.Steps {
+		fmt.Printf("  %s\n", s)
+	}
+	fmt.Println("chosen:")
+	for _, c := range d.Chosen {
+		fmt.Printf("  %s\n", c)
+	}
+	return
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strconv"
+)
+
+func topology(args []string) (err error) {
+	fs := flag.NewFlagSet("topology", flag.ExitOnError)
+	format := fs.String("format", "json", "json, dot or graphml")
+	anonymize := fs.Bool("anonymize", false, "replace the public keys by salted hashes")
+	output := fs.String("o", "", "file to write, default stdout")
+	fs.Parse(args)
+
+	d, err := request("GET", "/topology/export", url.Values{
+		"format":    {*format},
+		"anonymize": {strconv.FormatBool(*anonymize)},
+	})
+	if err != nil {
+		return
+	}
+	if len(*output) > 0 {
+		return ioutil.WriteFile(*output, d, 0644)
+	}
+	_, err = os.Stdout.Write(d)
+	return
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"net/url"
+	"os"
+	"strconv"
+)
+
+// transports lists the transports of a node a page at a time, a json object per line is
+// written as soon as its page arrived
+func transports(args []string) (err error) {
+	fs := flag.NewFlagSet("node transports", flag.ExitOnError)
+	key := fs.String("key", "", "public key of the node")
+	limit := fs.Int("limit", 500, "transports requested per page")
+	fs.Parse(args)
+	if len(*key) < 8 {
+		return errors.New("-key of the node is required")
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	var cursor string
+	for {
+		var res []byte
+		res, err = request("GET", "/conn/getNodeTransports", url.Values{
+			"key":    {*key},
+			"cursor": {cursor},
+			"limit":  {strconv.Itoa(*limit)},
+		})
+		if err != nil {
+			return
+		}
+		var page struct {
+			Transports []json.RawMessage `json:"transports"`
+			Next       string            `json:"next"`
+		}
+		err = json.Unmarshal(res, &page)
+		if err != nil {
+			return
+		}
+		for _, t := range page.Transports {
+			out.Write(t)
+			out.WriteByte('\n')
+		}
+		err = out.Flush()
+		if err != nil || len(page.Next) == 0 {
+			return
+		}
+		cursor = page.Next
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestTransports(t *testing.T) {
+	key := strings.Repeat("02", 33)
+	var cursors []string
+	// the manager answers two transports a page, the cursor is the index of the next one
+	manager := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/conn/getNodeTransports" || r.FormValue("key") != key || r.FormValue("limit") != "2" {
+			http.NotFound(w, r)
+			return
+		}
+		cursors = append(cursors, r.FormValue("cursor"))
+		start, _ := strconv.Atoi(r.FormValue("cursor"))
+		page := map[string]interface{}{}
+		var transports []map[string]int
+		for i := start; i < start+2 && i < 5; i++ {
+			transports = append(transports, map[string]int{"upload_total": i})
+		}
+		page["transports"] = transports
+		if start+2 < 5 {
+			page["next"] = strconv.Itoa(start + 2)
+		}
+		json.NewEncoder(w).Encode(page)
+	}))
+	defer manager.Close()
+	defer func(u string) { managerURL = u }(managerURL)
+	managerURL = manager.URL
+
+	out, err := ioutil.TempFile("", "transports")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(out.Name())
+	defer out.Close()
+	defer func(f *os.File) { os.Stdout = f }(os.Stdout)
+	os.Stdout = out
+
+	if err = transports([]string{"-key", key, "-limit", "2"}); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(cursors, ",") != ",2,4" {
+		t.Fatalf("cursors %q", cursors)
+	}
+	b, err := ioutil.ReadFile(out.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a line per transport, in the order of the pages
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("lines %s", b)
+	}
+	for i, l := range lines {
+		if l != `{"upload_total":`+strconv.Itoa(i)+`}` {
+			t.Fatalf("line %d %s", i, l)
+		}
+	}
+
+	if err = transports([]string{"-key", strings.Repeat("03", 33), "-limit", "2"}); err == nil {
+		t.Fatal("transports of a node the manager does not know")
+	}
+	if err = transports(nil); err == nil {
+		t.Fatal("transports without a key")
+	}
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/skycoin/skycoin/src/cipher"
+	"github.com/skycoin/skywire/pkg/net/msg"
+	"github.com/skycoin/skywire/pkg/net/skycoin-messenger/factory"
+)
+
+const (
+	// probes running at the same time
+	probeParallel = 16
+	// pings sent to a node within the timeout, the udp packets may be lost
+	probePings = 3
+)
+
+type DiscoveryReport struct {
+	Address   string `json:"address"`
+	Connected bool   `json:"connected"`
+	Error     string `json:"error,omitempty"`
+	Nodes     int    `json:"nodes"`
+	// round trip of the service queries in ms
+	QueryRTT int64 `json:"query_rtt_ms"`
+}
+
+type NodeProbe struct {
+	Key       string   `json:"key"`
+	Discovery string   `json:"discovery"`
+	Apps      []string `json:"apps"`
+	Version   []string `json:"version,omitempty"`
+	Location  string   `json:"location,omitempty"`
+	// the node is registered at the discovery, messages and transports can reach it
+	Registered bool   `json:"registered"`
+	Address    string `json:"address,omitempty"`
+	// round trip of a ping to the service address in ms, -1 if the node did not answer it
+	RTT   int64  `json:"rtt_ms"`
+	Error string `json:"error,omitempty"`
+}
+
+type Summary struct {
+	Nodes      int            `json:"nodes"`
+	Sampled    int            `json:"sampled"`
+	Registered int            `json:"registered"`
+	Reachable  int            `json:"reachable"`
+	MedianRTT  int64          `json:"median_rtt_ms"`
+	Versions   map[string]int `json:"versions"`
+	Locations  map[string]int `json:"locations"`
+}
+
+// Report of the health of the network
+type Report struct {
+	Time        int64             `json:"time"`
+	Duration    int64             `json:"duration_ms"`
+	Summary     Summary           `json:"summary"`
+	Discoveries []DiscoveryReport `json:"discoveries"`
+	Nodes       []NodeProbe       `json:"nodes"`
+}
+
+type crawler struct {
+	f       *factory.MessengerFactory
+	timeout time.Duration
+
+	// pending queries by sequence
+	attrResults map[uint32]chan *factory.QueryByAttrsResp
+	keyResults  chan *factory.QueryResp
+	sync.Mutex
+}
+
+func newCrawler(seedPath string, timeout time.Duration) (c *crawler, err error) {
+	f := factory.NewMessengerFactory()
+	f.SetLoggerLevel(factory.InfoLevel)
+	err = f.SetDefaultSeedConfigPath(seedPath)
+	if err != nil {
+		return
+	}
+	c = &crawler{
+		f:           f,
+		timeout:     timeout,
+		attrResults: make(map[uint32]chan *factory.QueryByAttrsResp),
+		keyResults:  make(chan *factory.QueryResp, 1),
+	}
+	return
+}
+
+func (c *crawler) close() {
+	c.f.Close()
+}
+
+func (c *crawler) attrsCallback(resp *factory.QueryByAttrsResp) {
+	c.Lock()
+	ch, ok := c.attrResults[resp.Seq]
+	delete(c.attrResults, resp.Seq)
+	c.Unlock()
+	if ok {
+		ch <- resp
+	}
+}
+
+func (c *crawler) keysCallback(resp *factory.QueryResp) {
+	select {
+	case c.keyResults <- resp:
+	default:
+	}
+}
+
+func (c *crawler) connect(addr string) (conn *factory.Connection, err error) {
+	split := strings.Split(addr, "-")
+	if len(split) != 2 {
+		err = fmt.Errorf("discovery address %s is not valid", addr)
+		return
+	}
+	tk, err := cipher.PubKeyFromHex(split[1])
+	if err != nil {
+		return
+	}
+	err = c.f.ConnectWithConfig(split[0], &factory.ConnConfig{
+		TargetKey:                            tk,
+		FindServiceNodesByAttributesCallback: c.attrsCallback,
+		FindServiceNodesByKeysCallback:       c.keysCallback,
+	})
+	if err != nil {
+		return
+	}
+	c.f.ForEachConn(func(connection *factory.Connection) {
+		if connection.GetTargetKey() == tk {
+			conn = connection
+		}
+	})
+	if conn == nil {
+		err = errors.New("discovery connection not found")
+	}
+	return
+}
+
+// nodes of the discovery offering one of the attributes, by node key
+func (c *crawler) enumerate(conn *factory.Connection, attrs []string, dr *DiscoveryReport) (nodes map[string]*NodeProbe) {
+	nodes = make(map[string]*NodeProbe)
+	var rtts []int64
+	for _, attr := range attrs {
+		for page := 1; page <= maxPages; page++ {
+			ch := make(chan *factory.QueryByAttrsResp, 1)
+			start := time.Now()
+			c.Lock()
+			seq, err := conn.FindServiceNodesWithSeqByAttributesAndPaging(page, pageSize, attr)
+			if err == nil {
+				c.attrResults[seq] = ch
+			}
+			c.Unlock()
+			if err != nil {
+				dr.Error = err.Error()
+				return
+			}
+			var resp *factory.QueryByAttrsResp
+			select {
+			case resp = <-ch:
+			case <-time.After(c.timeout):
+				dr.Error = fmt.Sprintf("query %s page %d timed out", attr, page)
+			}
+			if resp == nil {
+				break
+			}
+			rtts = append(rtts, int64(time.Since(start)/time.Millisecond))
+			if len(resp.Error) > 0 {
+				dr.Error = resp.Error
+				break
+			}
+			if resp.Result == nil || len(resp.Result.Nodes) == 0 {
+				break
+			}
+			for _, n := range resp.Result.Nodes {
+				p, ok := nodes[n.Node.Hex()]
+				if !ok {
+					p = &NodeProbe{Key: n.Node.Hex(), Discovery: dr.Address, Location: n.Location, Version: n.Version, RTT: -1}
+					nodes[p.Key] = p
+				}
+				for _, a := range n.Apps {
+					p.Apps = append(p.Apps, a.Hex())
+				}
+			}
+			if int64(page*pageSize) >= resp.Result.Count {
+				break
+			}
+		}
+	}
+	dr.QueryRTT = median(rtts)
+	return
+}
+
+// register looks up the app keys of the probes at the discovery,
+// the nodes registered for them are reachable by messages and transports
+func (c *crawler) register(conn *factory.Connection, probes []*NodeProbe) (err error) {
+	var keys []cipher.PubKey
+	for _, p := range probes {
+		for _, a := range p.Apps {
+			k, e := cipher.PubKeyFromHex(a)
+			if e == nil {
+				keys = append(keys, k)
+			}
+		}
+	}
+	if len(keys) == 0 {
+		return
+	}
+	err = conn.FindServiceNodesByKeys(keys)
+	if err != nil {
+		return
+	}
+	var resp *factory.QueryResp
+	select {
+	case resp = <-c.keyResults:
+	case <-time.After(c.timeout):
+		return errors.New("service key query timed out")
+	}
+	if len(resp.Error) > 0 {
+		return errors.New(resp.Error)
+	}
+	byNode := make(map[string]*NodeProbe)
+	for _, p := range probes {
+		byNode[p.Key] = p
+	}
+	for _, s := range resp.Result {
+		for _, n := range s.Nodes {
+			if p, ok := byNode[n.PubKey.Hex()]; ok {
+				p.Registered = true
+				if len(n.Address) > 0 {
+					p.Address = n.Address
+				}
+			}
+		}
+	}
+	return
+}
+
+// probe pings the node at its service address with the udp protocol of its transports, the node
+// answers with a pong carrying the time of the ping. A port that is open but not served by a node
+// does not answer it
+func (c *crawler) probe(p *NodeProbe) {
+	if len(p.Address) == 0 {
+		p.Error = "no service address"
+		return
+	}
+	conn, err := net.DialTimeout("udp", p.Address, c.timeout)
+	if err != nil {
+		p.Error = err.Error()
+		return
+	}
+	defer conn.Close()
+	buf := make([]byte, msg.PKG_HEADER_SIZE+msg.PING_MSG_HEADER_SIZE)
+	for i := 0; i < probePings; i++ {
+		ping := msg.GenPingMsg()
+		pkg := make([]byte, msg.PKG_HEADER_SIZE+len(ping))
+		copy(pkg[msg.PKG_HEADER_SIZE:], ping)
+		binary.BigEndian.PutUint32(pkg[msg.PKG_CRC32_BEGIN:], crc32.ChecksumIEEE(ping))
+		start := time.Now()
+		_, err = conn.Write(pkg)
+		if err != nil {
+			p.Error = err.Error()
+			return
+		}
+		conn.SetReadDeadline(start.Add(c.timeout / probePings))
+		for {
+			var n int
+			n, err = conn.Read(buf)
+			if err != nil {
+				break
+			}
+			if isPong(buf[:n], ping) {
+				p.RTT = int64(time.Since(start) / time.Millisecond)
+				p.Error = ""
+				fin(conn)
+				return
+			}
+		}
+		p.Error = err.Error()
+		if e, ok := err.(net.Error); !ok || !e.Timeout() {
+			return
+		}
+	}
+	p.Error = "no pong from the node"
+}
+
+// isPong returns true if the packet is the pong of the node to the ping
+func isPong(pkg, ping []byte) bool {
+	if len(pkg) != msg.PKG_HEADER_SIZE+len(ping) {
+		return false
+	}
+	m := pkg[msg.PKG_HEADER_SIZE:]
+	if binary.BigEndian.Uint32(pkg[msg.PKG_CRC32_BEGIN:]) != crc32.ChecksumIEEE(m) {
+		return false
+	}
+	return m[msg.PING_MSG_TYPE_BEGIN] == msg.TYPE_PONG &&
+		string(m[msg.PING_MSG_TIME_BEGIN:msg.PING_MSG_TIME_END]) == string(ping[msg.PING_MSG_TIME_BEGIN:msg.PING_MSG_TIME_END])
+}
+
+// fin tells the node to drop the connection the ping opened instead of waiting for its gc
+func fin(conn net.Conn) {
+	pkg := make([]byte, msg.PKG_HEADER_SIZE+msg.UDP_TYPE_SIZE)
+	pkg[msg.PKG_HEADER_SIZE+msg.UDP_TYPE_BEGIN] = msg.TYPE_FIN
+	binary.BigEndian.PutUint32(pkg[msg.PKG_CRC32_BEGIN:], crc32.ChecksumIEEE(pkg[msg.PKG_HEADER_SIZE:]))
+	conn.Write(pkg)
+}
+
+func (c *crawler) crawl(discoveries []string, attrs []string, sample int) (r *Report) {
+	start := time.Now()
+	r = &Report{Time: start.Unix(), Discoveries: make([]DiscoveryReport, 0), Nodes: make([]NodeProbe, 0)}
+	conns := make(map[string]*factory.Connection)
+	all := make(map[string]*NodeProbe)
+	for _, addr := range discoveries {
+		dr := DiscoveryReport{Address: addr}
+		conn, err := c.connect(addr)
+		if err != nil {
+			dr.Error = err.Error()
+			r.Discoveries = append(r.Discoveries, dr)
+			continue
+		}
+		dr.Connected = true
+		conns[addr] = conn
+		nodes := c.enumerate(conn, attrs, &dr)
+		dr.Nodes = len(nodes)
+		for k, n := range nodes {
+			if _, ok := all[k]; !ok {
+				all[k] = n
+			}
+		}
+		r.Discoveries = append(r.Discoveries, dr)
+		log.Infof("discovery %s: %d nodes", addr, dr.Nodes)
+	}
+
+	probes := make([]*NodeProbe, 0, len(all))
+	for _, n := range all {
+		probes = append(probes, n)
+	}
+	for i := len(probes) - 1; i > 0; i-- {
+		j := rand.Intn(i + 1)
+		probes[i], probes[j] = probes[j], probes[i]
+	}
+	if sample > 0 && len(probes) > sample {
+		probes = probes[:sample]
+	}
+
+	byDiscovery := make(map[string][]*NodeProbe)
+	for _, p := range probes {
+		byDiscovery[p.Discovery] = append(byDiscovery[p.Discovery], p)
+	}
+	for addr, ps := range byDiscovery {
+		err := c.register(conns[addr], ps)
+		if err != nil {
+			log.Errorf("discovery %s: %v", addr, err)
+		}
+	}
+
+	sem := make(chan struct{}, probeParallel)
+	var wg sync.WaitGroup
+	for _, p := range probes {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(p *NodeProbe) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			c.probe(p)
+		}(p)
+	}
+	wg.Wait()
+
+	r.Summary = Summary{
+		Nodes:     len(all),
+		Sampled:   len(probes),
+		Versions:  make(map[string]int),
+		Locations: make(map[string]int),
+	}
+	var rtts []int64
+	for _, p := range probes {
+		if p.Registered {
+			r.Summary.Registered++
+		}
+		if p.RTT >= 0 {
+			r.Summary.Reachable++
+			rtts = append(rtts, p.RTT)
+		}
+		if len(p.Version) > 0 {
+			r.Summary.Versions[p.Version[0]]++
+		}
+		if len(p.Location) > 0 {
+			r.Summary.Locations[p.Location]++
+		}
+		r.Nodes = append(r.Nodes, *p)
+	}
+	r.Summary.MedianRTT = median(rtts)
+	r.Duration = int64(time.Since(start) / time.Millisecond)
+	return
+}
+
+func median(values []int64) int64 {
+	if len(values) == 0 {
+		return 0
+	}
+	s := append([]int64(nil), values...)
+	for i := 1; i < len(s); i++ {
+		for j := i; j > 0 && s[j] < s[j-1]; j-- {
+			s[j], s[j-1] = s[j-1], s[j]
+		}
+	}
+	return s[len(s)/2]
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skycoin/skywire/pkg/net/msg"
+	"github.com/skycoin/skywire/pkg/net/skycoin-messenger/factory"
+	"github.com/skycoin/skywire/pkg/node/nodetest"
+)
+
+// pongs answers the pings sent to a udp port the way the transports of a node do
+func pongs(t *testing.T) net.PacketConn {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if n < msg.PKG_HEADER_SIZE+msg.PING_MSG_HEADER_SIZE || buf[msg.PKG_HEADER_SIZE+msg.MSG_TYPE_BEGIN] != msg.TYPE_PING {
+				continue
+			}
+			m := buf[msg.PKG_HEADER_SIZE:n]
+			m[msg.PING_MSG_TYPE_BEGIN] = msg.TYPE_PONG
+			binary.BigEndian.PutUint32(buf[msg.PKG_CRC32_BEGIN:], crc32.ChecksumIEEE(m))
+			conn.WriteTo(buf[:n], addr)
+		}
+	}()
+	return conn
+}
+
+func TestCrawl(t *testing.T) {
+	e := nodetest.NewEnv(t, 1)
+	defer e.Close()
+	// a node without a service address is registered but can not be probed
+	n := e.StartNode("node")
+	e.ConnectApp(n, "sshs").Offer("", "sshs")
+	// a node serving its transports at a service address answers the probe
+	ln := pongs(t)
+	defer ln.Close()
+	_, port, _ := net.SplitHostPort(ln.LocalAddr().String())
+	served := e.Discoveries[0].ConnectClient(t)
+	defer served.Close()
+	err := served.UpdateServices(&factory.NodeServices{
+		ServiceAddress: ":" + port,
+		Services:       []*factory.Service{{Key: served.GetKey(), Attributes: []string{"sockss"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodetest.WaitFor(t, "the served node at the discovery", func() bool {
+		return e.Discoveries[0].Store.Services(served.GetKey()) != nil
+	})
+	pageSize, maxPages = 10, 10
+
+	c, err := newCrawler(filepath.Join(e.Dir, "crawler.json"), 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.close()
+	down := "127.0.0.1:1-" + e.DiscoveryKey(0).Hex()
+	r := c.crawl([]string{e.Discoveries[0].Address(), down}, []string{"sshs", "sockss"}, 0)
+
+	if len(r.Discoveries) != 2 || !r.Discoveries[0].Connected || r.Discoveries[0].Nodes != 2 {
+		t.Fatalf("discoveries %+v", r.Discoveries)
+	}
+	if r.Discoveries[1].Connected || len(r.Discoveries[1].Error) == 0 {
+		t.Fatalf("unreachable discovery %+v", r.Discoveries[1])
+	}
+	if r.Summary.Nodes != 2 || r.Summary.Sampled != 2 || r.Summary.Registered != 2 || r.Summary.Reachable != 1 {
+		t.Fatalf("summary %+v", r.Summary)
+	}
+	probes := make(map[string]NodeProbe)
+	for _, p := range r.Nodes {
+		probes[p.Key] = p
+	}
+	p := probes[served.GetKey().Hex()]
+	if !p.Registered || p.Address != "127.0.0.1:"+port || p.RTT < 0 || len(p.Error) > 0 {
+		t.Fatalf("probe of the served node %+v", p)
+	}
+	if p = probes[n.Key.Hex()]; !p.Registered || p.RTT != -1 || p.Error != "no service address" {
+		t.Fatalf("probe of the node %+v", p)
+	}
+
+	d, err := r.html()
+	if err != nil || !strings.Contains(string(d), n.Key.Hex()) || !strings.Contains(string(d), served.GetKey().Hex()) {
+		t.Fatalf("html %v: %s", err, d)
+	}
+}
+
+func TestProbeWithoutNode(t *testing.T) {
+	// a port served by something else than a node does not answer the ping
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	c := &crawler{timeout: 300 * time.Millisecond}
+	p := &NodeProbe{Address: conn.LocalAddr().String(), RTT: -1}
+	c.probe(p)
+	if p.RTT != -1 || p.Error != "no pong from the node" {
+		t.Fatalf("probe %+v", p)
+	}
+	p = &NodeProbe{RTT: -1}
+	c.probe(p)
+	if p.Error != "no service address" {
+		t.Fatalf("probe without address %+v", p)
+	}
+}
+
+func TestIsPong(t *testing.T) {
+	ping := msg.GenPingMsg()
+	pong := make([]byte, len(ping))
+	copy(pong, ping)
+	pong[msg.PING_MSG_TYPE_BEGIN] = msg.TYPE_PONG
+	pkg := func(m []byte) []byte {
+		b := make([]byte, msg.PKG_HEADER_SIZE+len(m))
+		copy(b[msg.PKG_HEADER_SIZE:], m)
+		binary.BigEndian.PutUint32(b[msg.PKG_CRC32_BEGIN:], crc32.ChecksumIEEE(m))
+		return b
+	}
+	if !isPong(pkg(pong), ping) {
+		t.Fatal("pong not recognized")
+	}
+	if isPong(pkg(ping), ping) {
+		t.Fatal("the ping taken for a pong")
+	}
+	bad := pkg(pong)
+	bad[len(bad)-1] ^= 1
+	if isPong(bad, ping) {
+		t.Fatal("pong with a bad checksum")
+	}
+	if median([]int64{5, 1, 3}) != 3 || median(nil) != 0 {
+		t.Fatal("median")
+	}
+}
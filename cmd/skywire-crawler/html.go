@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"time"
+)
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"time": func(t int64) string { return time.Unix(t, 0).UTC().Format(time.RFC1123) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Skywire network health</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+td, th { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+.bad { color: #c00; }
+</style>
+</head>
+<body>
+<h1>Skywire network health</h1>
+<p>{{time .Time}}, crawled in {{.Duration}} ms</p>
+<h2>Summary</h2>
+<table>
+<tr><th>Nodes</th><td>{{.Summary.Nodes}}</td></tr>
+<tr><th>Sampled</th><td>{{.Summary.Sampled}}</td></tr>
+<tr><th>Registered</th><td>{{.Summary.Registered}}</td></tr>
+<tr><th>Reachable</th><td>{{.Summary.Reachable}}</td></tr>
+<tr><th>Median RTT</th><td>{{.Summary.MedianRTT}} ms</td></tr>
+</table>
+<h2>Versions</h2>
+<table>
+{{range $v, $n := .Summary.Versions}}<tr><td>{{$v}}</td><td>{{$n}}</td></tr>
+{{end}}</table>
+<h2>Discoveries</h2>
+<table>
+<tr><th>Address</th><th>Connected</th><th>Nodes</th><th>Query RTT</th><th>Error</th></tr>
+{{range .Discoveries}}<tr><td>{{.Address}}</td><td>{{.Connected}}</td><td>{{.Nodes}}</td><td>{{.QueryRTT}} ms</td><td class="bad">{{.Error}}</td></tr>
+{{end}}</table>
+<h2>Probed nodes</h2>
+<table>
+<tr><th>Key</th><th>Location</th><th>Registered</th><th>Address</th><th>RTT</th><th>Error</th></tr>
+{{range .Nodes}}<tr><td>{{.Key}}</td><td>{{.Location}}</td><td>{{.Registered}}</td><td>{{.Address}}</td><td>{{if ge .RTT 0}}{{.RTT}} ms{{end}}</td><td class="bad">{{.Error}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+func (r *Report) html() (d []byte, err error) {
+	var b bytes.Buffer
+	err = reportTemplate.Execute(&b, r)
+	d = b.Bytes()
+	return
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/skycoin/skycoin/src/util/file"
+	"github.com/skycoin/skywire/pkg/node"
+)
+
+var (
+	discoveries node.Addresses
+	attrs       string
+	seedPath    string
+	sample      int
+	pageSize    int
+	maxPages    int
+	timeout     time.Duration
+	output      string
+	format      string
+)
+
+func parseFlags() {
+	flag.Var(&discoveries, "discovery-address", "addresses of the discoveries to crawl")
+	flag.StringVar(&attrs, "attrs", "sshs,sockss", "comma separated service attributes to enumerate the nodes by")
+	flag.StringVar(&seedPath, "seed-path", filepath.Join(file.UserHome(), ".skywire", "crawler", "keys.json"), "path to save the key of the crawler")
+	flag.IntVar(&sample, "sample", 50, "number of nodes to probe, 0 for all")
+	flag.IntVar(&pageSize, "page-size", 100, "nodes per discovery query")
+	flag.IntVar(&maxPages, "max-pages", 100, "pages to query per attribute and discovery")
+	flag.DurationVar(&timeout, "timeout", 5*time.Second, "timeout of a query or probe")
+	flag.StringVar(&output, "o", "", "file to write the report to, default stdout")
+	flag.StringVar(&format, "format", "json", "json or html")
+	flag.Parse()
+}
+
+func main() {
+	parseFlags()
+	if len(discoveries) == 0 {
+		discoveries = node.Addresses{"discovery.skycoin.net:5999-034b1cd4ebad163e457fb805b3ba43779958bba49f2c5e1e8b062482904bacdb68"}
+	}
+	if format != "json" && format != "html" {
+		log.Fatal("format must be json or html")
+	}
+	log.SetLevel(log.InfoLevel)
+	rand.Seed(time.Now().UnixNano())
+
+	c, err := newCrawler(seedPath, timeout)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer c.close()
+	report := c.crawl(discoveries, strings.Split(attrs, ","), sample)
+
+	var d []byte
+	if format == "html" {
+		d, err = report.html()
+	} else {
+		d, err = json.MarshalIndent(report, "", "  ")
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(output) > 0 {
+		err = ioutil.WriteFile(output, d, 0644)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	os.Stdout.Write(d)
+}
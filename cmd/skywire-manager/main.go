@@ -3,15 +3,22 @@ package main
 import (
 	"flag"
 	"fmt"
+	"net"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"syscall"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/skycoin/skycoin/src/util/file"
+	"github.com/skycoin/skywire/pkg/httputil"
 	"github.com/skycoin/skywire/pkg/manager"
 	"github.com/skycoin/skywire/pkg/net/skycoin-messenger/factory"
 	"github.com/skycoin/skywire/pkg/net/skycoin-messenger/monitor"
+	"github.com/skycoin/skywire/pkg/net/util"
+	"github.com/skycoin/skywire/pkg/systemd"
+	"github.com/skycoin/skywire/pkg/trace"
 )
 
 var (
@@ -21,6 +28,22 @@ var (
 	seedPath string
 
 	version bool
+
+	handshake factory.HandshakeProtection
+	queries   factory.QueryLimits
+	sends     factory.SendLimits
+
+	maxClockSkew  time.Duration
+	traceEndpoint string
+
+	twoFactor string
+
+	corsOrigins    string
+	trustedProxies string
+	tlsCert        string
+	tlsKey         string
+
+	network string
 )
 
 func parseFlags() {
@@ -30,6 +53,26 @@ func parseFlags() {
 	flag.StringVar(&address, "address", ":5998", "address to listen on")
 	flag.StringVar(&seedPath, "seed-path", filepath.Join(file.UserHome(), ".skywire", "discovery", "keys.json"), "path to save seed info")
 	flag.BoolVar(&version, "v", false, "print current version")
+	flag.Int64Var(&handshake.CookieThreshold, "handshake-cookie-threshold", 256, "require a cookie round trip once this many handshakes are in progress, 0 to disable")
+	flag.Float64Var(&handshake.IPRate, "handshake-ip-rate", 10, "handshakes per second allowed from an ip, 0 for no limit")
+	flag.Float64Var(&handshake.KeyRate, "handshake-key-rate", 2, "handshakes per second allowed for a public key, 0 for no limit")
+	flag.IntVar(&handshake.Burst, "handshake-burst", 20, "handshakes allowed at once above the rates")
+	flag.Float64Var(&queries.Rate, "query-rate", 5, "service queries per second allowed for a node, 0 for no limit")
+	flag.IntVar(&queries.Burst, "query-burst", 20, "service queries allowed at once above the rate")
+	flag.IntVar(&queries.HourlyQuota, "query-hourly-quota", 3600, "service queries allowed for a node per hour, 0 for no quota")
+	flag.Float64Var(&sends.Rate, "send-rate", 200, "messages per second a client may send to the others, 0 for no limit")
+	flag.IntVar(&sends.Burst, "send-burst", 400, "messages a client may send at once above the rate")
+	flag.IntVar(&sends.MaxQueued, "send-max-queued", 1<<20, "bytes queued for a client, the messages above are dropped, 0 to write them from the connection of the sender")
+	flag.DurationVar(&sends.StallTimeout, "send-stall-timeout", 30*time.Second, "evict a client that has not taken a message queued for this long, 0 to keep it")
+	flag.Int64Var(&sends.MaxTotalQueued, "send-max-total-queued", 256<<20, "bytes queued for all the clients, above it the client with the most queued is evicted, 0 for no limit")
+	flag.StringVar(&traceEndpoint, "trace-endpoint", "", "OTLP/HTTP endpoint to export the spans of the forwarded transport setups to, e.g. http://localhost:4318/v1/traces")
+	flag.DurationVar(&maxClockSkew, "max-clock-skew", 10*time.Minute, "ignore the services of nodes whose clock is further off, 0 to accept any")
+	flag.StringVar(&network, "network", factory.MainNetwork, "network of the discovery, the nodes of other networks are refused")
+	flag.StringVar(&twoFactor, "require-2fa", string(monitor.TwoFactorOptional), "password logins that must use two-factor authentication: optional, admin or all")
+	flag.StringVar(&corsOrigins, "cors-origins", "", "comma separated origins whose pages may call the api from the browser, like https://dashboard.example.com, * for any")
+	flag.StringVar(&trustedProxies, "trusted-proxies", "", "comma separated addresses and networks of the reverse proxies trusted to forward the address and scheme of the clients, like 127.0.0.1,10.0.0.0/8")
+	flag.StringVar(&tlsCert, "tls-cert", "", "certificate file to serve the api with https, with -tls-key")
+	flag.StringVar(&tlsKey, "tls-key", "", "key file of the certificate of -tls-cert")
 	flag.Parse()
 }
 
@@ -41,20 +84,78 @@ func main() {
 	}
 
 	osSignal := make(chan os.Signal, 1)
-	signal.Notify(osSignal, os.Interrupt, os.Kill)
+	signal.Notify(osSignal, os.Interrupt, os.Kill, syscall.SIGTERM)
 
+	err := factory.SelfCheck()
+	if err != nil {
+		log.Error(err)
+		os.Exit(1)
+	}
+	// every node keeps a connection open, the soft limit is often 1024
+	if limit, e := util.RaiseFDLimit(); e != nil {
+		log.Errorf("raise open file limit: %v", e)
+	} else {
+		log.Infof("open file limit %d", limit)
+	}
 	f := factory.NewMessengerFactory()
 	defer f.Close()
 	f.SetDefaultSeedConfigPath(seedPath)
 	f.SetLoggerLevel(factory.DebugLevel)
 	f.SetAppVersion(manager.Version)
-	err := f.Listen(address)
+	f.SetNetwork(network)
+	err = f.SetHandshakeProtection(handshake)
+	if err != nil {
+		log.Error(err)
+		os.Exit(1)
+	}
+	f.SetQueryLimits(queries)
+	f.SetSendLimits(sends)
+	f.SetMaxClockSkew(maxClockSkew)
+	if len(traceEndpoint) > 0 {
+		tracer := trace.NewTracer("skywire-manager", traceEndpoint)
+		defer tracer.Close()
+		f.SetTracer(tracer)
+	}
+	// the first socket passed by systemd is the address of the nodes, the second the web port
+	lns, err := systemd.Listeners()
+	if err != nil {
+		log.Errorf("socket activation err: %v", err)
+	}
+	if ln, ok := firstTCPListener(lns); ok {
+		err = f.ListenOn(ln)
+	} else {
+		err = f.Listen(address)
+	}
 	log.Debugf("listen on %s", address)
 	if err != nil {
 		log.Error(err)
 		os.Exit(1)
 	}
 	m := monitor.New(f, address, webPort, manager.Tag, manager.Version)
+	twoFactorPolicy, err := monitor.ParseTwoFactorPolicy(twoFactor)
+	if err != nil {
+		log.Error(err)
+		os.Exit(1)
+	}
+	m.SetTwoFactorPolicy(twoFactorPolicy)
+	proxies, err := httputil.ParseProxies(trustedProxies)
+	if err != nil {
+		log.Error(err)
+		os.Exit(1)
+	}
+	m.SetTrustedProxies(proxies)
+	m.SetCORS(httputil.ParseCORS(corsOrigins))
+	if len(tlsCert) > 0 || len(tlsKey) > 0 {
+		tlsConfig, err := httputil.LoadTLS(tlsCert, tlsKey)
+		if err != nil {
+			log.Error(err)
+			os.Exit(1)
+		}
+		m.SetTLS(tlsConfig)
+	}
+	if len(lns) > 1 {
+		m.SetListener(lns[1])
+	}
 	m.Start(webDir)
 	defer m.Close()
 	select {
@@ -63,6 +164,15 @@ func main() {
 			log.Debugln("exit by signal Interrupt")
 		} else if signal == os.Kill {
 			log.Debugln("exit by signal Kill")
+		} else if signal == syscall.SIGTERM {
+			log.Debugln("exit by signal Terminate")
 		}
 	}
 }
+
+func firstTCPListener(lns []net.Listener) (ln *net.TCPListener, ok bool) {
+	if len(lns) > 0 {
+		ln, ok = lns[0].(*net.TCPListener)
+	}
+	return
+}
@@ -1,31 +1,118 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"strings"
+	"syscall"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/skycoin/skycoin/src/util/file"
+	"github.com/skycoin/skywire/pkg/envflag"
+	"github.com/skycoin/skywire/pkg/httputil"
+	"github.com/skycoin/skywire/pkg/net/resolver"
+	"github.com/skycoin/skywire/pkg/net/skycoin-messenger/factory"
+	"github.com/skycoin/skywire/pkg/net/util"
 	"github.com/skycoin/skywire/pkg/node"
 	"github.com/skycoin/skywire/pkg/node/api"
+	"github.com/skycoin/skywire/pkg/systemd"
+	"github.com/skycoin/skywire/pkg/trace"
 )
 
+// the first start waits this long for the discoveries before printing the summary
+const onboardingWait = 30 * time.Second
+
 var (
 	config   node.Config
 	confPath string
 
 	version bool
+
+	genSystemd string
+
+	stateless bool
+	autoStart string
+
+	natDetect   bool
+	stunServers node.Addresses
+	portMapping bool
+
+	plainTransportNodes node.Addresses
+	pathCost            string
+	criticalApps        node.Addresses
+
+	setupTimeouts factory.SetupTimeouts
+	setupLimits   factory.SetupLimits
+
+	announceSchedule factory.AnnounceSchedule
+
+	routeCache factory.RouteCacheConfig
+	// name of a compiled in routing policy or file of a plugin, and its config
+	routingPolicy       string
+	routingPolicyConfig string
+
+	privateSetups bool
+
+	appPortsPath string
+
+	quotaConfigPath string
+
+	peerListsPath string
+
+	peerStorePath string
+	// transports to the apps the node takes and the bandwidth it announces
+	maxTransports int
+	bandwidth     int64
+
+	accountingConfig node.AccountingConfig
+
+	watchdog       bool
+	watchdogConfig node.WatchdogConfig
+
+	// event=command
+	hooks        node.Addresses
+	hookInterval time.Duration
+
+	// node:app
+	bandwidthPeers  node.Addresses
+	bandwidthConfig node.BandwidthTestConfig
+
+	clockCheck   bool
+	ntpServers   node.Addresses
+	maxClockSkew time.Duration
+
+	traceEndpoint string
+
+	appSocket string
+
+	shellManagerKeys node.Addresses
+
+	appConfigRoot string
+
+	network            string
+	networkProfilePath string
+
+	// resolves the discoveries and the manager
+	serviceDNS = resolver.DefaultConfig
+
+	localApi string
+
+	statusPage string
 )
 
 func parseFlags() {
 	flag.StringVar(&config.Address, "address", ":5000", "address to listen on")
 	flag.Var(&config.DiscoveryAddresses, "discovery-address", "addresses of discovery")
+	flag.StringVar(&network, "network", factory.MainNetwork, "network of the node, mainnet or testnet, the node refuses the discoveries, managers and nodes of other networks")
+	flag.StringVar(&networkProfilePath, "network-profile", "", "json file of a custom network with its discoveries, stun and ntp servers")
 	flag.BoolVar(&config.ConnectManager, "connect-manager", true, "connect to manager if true")
 	flag.StringVar(&config.ManagerAddr, "manager-address", ":5998", "address of node manager")
 	flag.StringVar(&config.ManagerWeb, "manager-web", ":8000", "address of node manager")
@@ -35,7 +122,70 @@ func parseFlags() {
 	flag.StringVar(&config.AutoStartPath, "auto-start-path", filepath.Join(file.UserHome(), ".skywire", "node", "autoStart.json"), "path to save launch info")
 	flag.StringVar(&confPath, "conf", filepath.Join(file.UserHome(), ".skywire", "node", "conf.json"), "node default config")
 	flag.BoolVar(&version, "v", false, "print current version")
-	flag.Parse()
+	flag.StringVar(&genSystemd, "gen-systemd", "", "write systemd unit files for the current flags to this directory and exit")
+	flag.BoolVar(&stateless, "stateless", false, "do not write any state, the keypair must exist at seed-path")
+	flag.StringVar(&autoStart, "auto-start", "", "json of the auto start config of a stateless node")
+	flag.BoolVar(&natDetect, "nat-detect", true, "detect the nat type at startup and periodically")
+	flag.Var(&stunServers, "stun-server", "stun servers for the nat detection")
+	flag.BoolVar(&portMapping, "port-mapping", false, "map the listen port on the gateway with NAT-PMP, PCP or UPnP")
+	flag.StringVar(&appPortsPath, "app-ports-path", filepath.Join(file.UserHome(), ".skywire", "node", "appPorts.json"), "path to save the ports the apps are served on")
+	flag.StringVar(&quotaConfigPath, "quota-config", filepath.Join(file.UserHome(), ".skywire", "node", "quotas.json"), "json file of the bandwidth and monthly quotas of the apps and transports, no quotas if missing")
+	flag.StringVar(&peerListsPath, "peer-lists-path", filepath.Join(file.UserHome(), ".skywire", "node", "peerLists.json"), "path to save the node allow and deny lists pushed by the manager")
+	flag.StringVar(&peerStorePath, "peer-store-path", filepath.Join(file.UserHome(), ".skywire", "node", "peers.json"), "path to save the nodes the transports were set up with, to ask the discovery that reached a node first after a restart")
+	flag.IntVar(&maxTransports, "max-transports", 0, "transports to the apps of the node it takes at once, the discoveries stop forwarding setups when it is full and the node answers busy, 0 for no limit")
+	flag.Int64Var(&bandwidth, "bandwidth", 0, "bytes per second the node offers the transports, announced to the discoveries, 0 to not announce")
+	flag.DurationVar(&accountingConfig.Interval, "usage-report-interval", time.Hour, "time a usage report of the apps and remote nodes covers, 0 to disable the reports")
+	flag.StringVar(&accountingConfig.Dir, "usage-report-dir", filepath.Join(file.UserHome(), ".skywire", "node", "usage"), "directory to write the usage reports to as json and csv")
+	flag.DurationVar(&accountingConfig.Keep, "usage-report-keep", 31*24*time.Hour, "remove the usage reports older than this, 0 to keep them all")
+	flag.StringVar(&traceEndpoint, "trace-endpoint", "", "OTLP/HTTP endpoint to export the transport setup spans to, e.g. http://localhost:4318/v1/traces")
+	flag.BoolVar(&clockCheck, "clock-check", true, "check the clock offset with ntp servers at startup and periodically")
+	flag.Var(&ntpServers, "ntp-server", "ntp servers for the clock check")
+	flag.DurationVar(&maxClockSkew, "max-clock-skew", time.Minute, "clock skew the node warns and refuses to sign above, 0 to only measure it")
+	flag.BoolVar(&watchdog, "watchdog", true, "watch the goroutines, open files and heap of the node")
+	flag.IntVar(&watchdogConfig.MaxGoroutines, "watchdog-max-goroutines", 10000, "goroutines the watchdog warns above, 0 to disable")
+	flag.IntVar(&watchdogConfig.MaxFDs, "watchdog-max-fds", 4096, "open files the watchdog warns above, 0 to disable")
+	flag.IntVar(&watchdogConfig.MaxHeapMB, "watchdog-max-heap-mb", 512, "heap size in MB the watchdog warns above, 0 to disable")
+	flag.Float64Var(&watchdogConfig.GoroutineSlope, "watchdog-goroutine-slope", 1000, "goroutine growth per hour the watchdog warns above, 0 to disable")
+	flag.Float64Var(&watchdogConfig.FDSlope, "watchdog-fd-slope", 200, "open file growth per hour the watchdog warns above, 0 to disable")
+	flag.Float64Var(&watchdogConfig.HeapSlopeMB, "watchdog-heap-slope-mb", 64, "heap growth in MB per hour the watchdog warns above, 0 to disable")
+	flag.StringVar(&watchdogConfig.DiagDir, "watchdog-diag-dir", "", "directory the watchdog writes a diagnostic bundle to when a limit is exceeded")
+	flag.Var(&hooks, "hook", fmt.Sprintf("event=command run with the shell on the event with its details in SKYWIRE_* environment variables, the events are %s", strings.Join(node.Events, ", ")))
+	flag.DurationVar(&hookInterval, "hook-interval", node.DefaultHookInterval, "least time between two runs of a hook, the events in between are dropped")
+	flag.Var(&bandwidthPeers, "bandwidth-test-peer", "node:app public keys of a volunteer app echoing the bandwidth tests, the loops to the peers are tested every -bandwidth-test-interval")
+	flag.DurationVar(&bandwidthConfig.Interval, "bandwidth-test-interval", 6*time.Hour, "time between two rounds of bandwidth tests")
+	flag.DurationVar(&bandwidthConfig.Duration, "bandwidth-test-duration", 10*time.Second, "time the loop to each peer is tested")
+	flag.StringVar(&bandwidthConfig.Dir, "bandwidth-test-dir", filepath.Join(file.UserHome(), ".skywire", "node", "bandwidth"), "directory to store the results of the bandwidth tests and the keys of the app echoing them in")
+	flag.StringVar(&bandwidthConfig.ReportURL, "bandwidth-report-url", "", "url to post the summaries of the bandwidth tests signed by the node to, empty to keep them local")
+	flag.BoolVar(&bandwidthConfig.Serve, "bandwidth-test-serve", false, "echo the bandwidth tests of other nodes, the key of the app is logged and in the local api")
+	flag.DurationVar(&setupTimeouts.Route, "setup-route-timeout", factory.DefaultSetupTimeouts.Route, "time the discovery has to find the node of an app connection and get its answer")
+	flag.DurationVar(&setupTimeouts.Connect, "setup-connect-timeout", factory.DefaultSetupTimeouts.Connect, "time the nodes have to connect for an app connection")
+	flag.DurationVar(&setupTimeouts.Confirm, "setup-confirm-timeout", factory.DefaultSetupTimeouts.Confirm, "time an app has to confirm its connection")
+	flag.IntVar(&setupLimits.MaxConcurrent, "setup-max-concurrent", 32, "app connections whose nodes connect at once, the others wait for their turn with the apps served in turn, 0 for no limit")
+	flag.IntVar(&setupLimits.MaxQueued, "setup-max-queued", 1024, "app connections waiting for their turn, the ones above fail as busy, 0 for no limit")
+	flag.DurationVar(&announceSchedule.Refresh, "announce-refresh", factory.DefaultAnnounceSchedule.Refresh, "announce the services to the discoveries again this often, 0 to announce changes only")
+	flag.DurationVar(&announceSchedule.ReconnectSpread, "announce-reconnect-spread", factory.DefaultAnnounceSchedule.ReconnectSpread, "announce the services to a reconnected discovery after a random wait up to this")
+	flag.DurationVar(&announceSchedule.Settle, "announce-settle", factory.DefaultAnnounceSchedule.Settle, "announce the changes of the services within this long at once, at least 100ms, 0 for the default")
+	flag.DurationVar(&announceSchedule.MaxBackoff, "announce-max-backoff", factory.DefaultAnnounceSchedule.MaxBackoff, "longest wait before retrying a failed announcement")
+	flag.IntVar(&routeCache.Size, "route-cache-size", factory.DefaultRouteCacheConfig.Size, "answers of the discoveries to the transports of the apps to keep, 0 to disable the cache")
+	flag.DurationVar(&routeCache.TTL, "route-cache-ttl", factory.DefaultRouteCacheConfig.TTL, "ask only the discovery that reached an app before for this long")
+	flag.DurationVar(&routeCache.NegativeTTL, "route-cache-negative-ttl", factory.DefaultRouteCacheConfig.NegativeTTL, "answer the apps without asking a discovery that did not find or was refused the app for this long")
+	flag.StringVar(&routingPolicy, "routing-policy", "", "routing policy choosing the discoveries of the transports of the apps, the name of a policy compiled in or a plugin .so file, empty for the route cache")
+	flag.StringVar(&routingPolicyConfig, "routing-policy-config", "", "config passed to the routing policy")
+	flag.Var(&plainTransportNodes, "plain-transport-node", "public key of a node that transports are not encrypted with, the link to it must already be secure")
+	flag.StringVar(&pathCost, "path-cost", "", "rank the discoveries of the transports by a weighted cost, e.g. hops=10,latency=1,load=2,reputation=10, empty to ask all at once")
+	flag.BoolVar(&privateSetups, "private-setup", false, "hide the apps of the transports of the node from the discoveries, the nodes of the apps must support it")
+	flag.Var(&criticalApps, "critical-app", "public key of an app that a standby transport is kept for when an app of the node connects to it")
+	flag.StringVar(&appSocket, "app-socket", "", "unix socket to serve the apps written in other languages on, see docs/api/AppSocket.md")
+	flag.StringVar(&appConfigRoot, "app-config-root", filepath.Join(file.UserHome(), ".skywire"), "directory holding the config directories of the apps the manager may edit, the keys are never shared, empty to share nothing")
+	flag.StringVar(&localApi, "local-api", "127.0.0.1:6002", "address of the read only json api of the node for the scripts of its host, see docs/api/LocalAPI.md, empty to disable")
+	flag.StringVar(&statusPage, "status-page", "", "address of the public status page of the node, showing its version, key, uptime, transports and bandwidth to anyone, empty to disable")
+	flag.Var(&shellManagerKeys, "shell-manager-key", "public key of a manager allowed to open a shell on the host of the node, the shell is disabled without one")
+	flag.Var((*resolver.List)(&serviceDNS.Upstreams), "service-dns-upstream", "tls://host[:port] or https://host/path DNS upstream resolving the discoveries and the manager, tried in order, the system resolver if none")
+	flag.DurationVar(&serviceDNS.CacheTTL, "service-dns-cache-ttl", resolver.DefaultConfig.CacheTTL, "keep the resolved discoveries and manager this long")
+	err := envflag.Parse(flag.CommandLine, "SKYWIRE_NODE", os.Args[1:], "discovery-address", "stun-server", "plain-transport-node", "ntp-server", "critical-app", "shell-manager-key", "service-dns-upstream", "bandwidth-test-peer")
+	if err != nil {
+		log.Fatal(err)
+	}
 }
 
 func main() {
@@ -44,9 +194,44 @@ func main() {
 		fmt.Println(node.Version)
 		return
 	}
+	if len(genSystemd) > 0 {
+		err := writeSystemdUnits(genSystemd)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	err := factory.SelfCheck()
+	if err != nil {
+		log.Fatal(err)
+	}
+	profile, err := node.LoadNetworkProfile(network, networkProfilePath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// a test network has no public discoveries, the node must not fall back to the ones of the main network
+	if len(config.DiscoveryAddresses) == 0 && len(profile.Discoveries) == 0 && profile.Name != factory.MainNetwork {
+		log.Fatalf("network %s has no discoveries, set them with -discovery-address or -network-profile", profile.Name)
+	}
+	// every transport needs a few descriptors, the soft limit is often 1024
+	if limit, e := util.RaiseFDLimit(); e != nil {
+		log.Errorf("raise open file limit: %v", e)
+	} else {
+		log.Infof("open file limit %d", limit)
+	}
 
 	osSignal := make(chan os.Signal, 1)
-	signal.Notify(osSignal, os.Interrupt, os.Kill)
+	signal.Notify(osSignal, os.Interrupt, os.Kill, syscall.SIGTERM)
+	if stateless {
+		// the identity is injected, e.g. from a secret mount, and never generated
+		_, err := factory.ReadSeedConfig(config.SeedPath)
+		if err != nil {
+			log.Fatalf("stateless node needs a keypair at %s: %v", config.SeedPath, err)
+		}
+		config.Seed = true
+	}
+	// the keys do not exist yet on the first start, the node generates them
+	firstRun := config.Seed && !stateless && node.FirstRun(config.SeedPath)
 	var n *node.Node
 	if !config.Seed {
 		n = node.New("", config.AutoStartPath, config.WebPort)
@@ -56,8 +241,133 @@ func main() {
 		}
 		n = node.New(config.SeedPath, config.AutoStartPath, config.WebPort)
 	}
-	var err error
-	if len(config.DiscoveryAddresses) == 0 {
+	if stateless {
+		var asc *node.AutoStartConfig
+		if len(autoStart) > 0 {
+			asc = &node.AutoStartConfig{}
+			err := json.Unmarshal([]byte(autoStart), asc)
+			if err != nil {
+				log.Fatalf("invalid auto start config: %v", err)
+			}
+		}
+		err := n.SetStateless(asc)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	// stateless nodes reserve the app ports in memory only
+	if stateless {
+		appPortsPath = ""
+	}
+	err = n.SetAppPortsPath(appPortsPath)
+	if err != nil {
+		log.Fatalf("app ports: %v", err)
+	}
+	quotaConfig, err := factory.LoadQuotaConfig(quotaConfigPath)
+	if err != nil {
+		log.Fatalf("quotas: %v", err)
+	}
+	// stateless nodes count the usage in memory only
+	if stateless {
+		quotaConfig.UsagePath = ""
+	} else if len(quotaConfig.UsagePath) == 0 {
+		quotaConfig.UsagePath = filepath.Join(filepath.Dir(quotaConfigPath), "quotaUsage.json")
+	}
+	err = n.SetQuotas(quotaConfig)
+	if err != nil {
+		log.Fatalf("quotas: %v", err)
+	}
+	// stateless nodes get the peer lists from the manager again after a restart
+	if stateless {
+		peerListsPath = ""
+	}
+	err = n.SetPeerListsPath(peerListsPath)
+	if err != nil {
+		log.Fatalf("peer lists: %v", err)
+	}
+	// stateless nodes learn the peers again after a restart
+	if stateless {
+		peerStorePath = ""
+	}
+	err = n.SetPeerStorePath(peerStorePath)
+	if err != nil {
+		// the peers only speed up the transports, the node learns them again
+		log.Errorf("peer store: %v", err)
+		n.SetPeerStorePath("")
+	}
+	n.SetCapacity(maxTransports, bandwidth)
+	if len(traceEndpoint) > 0 {
+		tracer := trace.NewTracer("skywire-node", traceEndpoint)
+		defer tracer.Close()
+		n.SetTracer(tracer)
+	}
+	n.SetNetwork(profile.Name)
+	// an address of a discovery or the manager without a port is the name of SRV records
+	dns, err := resolver.New(serviceDNS)
+	if err != nil {
+		log.Fatal(err)
+	}
+	n.SetServiceDNS(dns)
+	n.SetSetupTimeouts(setupTimeouts)
+	n.SetSetupLimits(setupLimits)
+	n.SetAnnounceSchedule(announceSchedule)
+	n.SetRouteCache(routeCache)
+	if len(routingPolicy) > 0 {
+		policy, err := factory.LoadRoutingPolicy(routingPolicy, routingPolicyConfig)
+		if err != nil {
+			log.Fatal(err)
+		}
+		n.SetRoutingPolicy(policy)
+	}
+	n.SetPrivateSetups(privateSetups)
+	if len(plainTransportNodes) > 0 {
+		err := n.SetPlainTransportNodes(plainTransportNodes)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if len(pathCost) > 0 {
+		w, err := factory.ParsePathCost(pathCost)
+		if err != nil {
+			log.Fatal(err)
+		}
+		n.SetPathCost(&w)
+	}
+	if len(criticalApps) > 0 {
+		err := n.SetCriticalApps(criticalApps)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if len(hooks) > 0 {
+		hooksConfig := node.HooksConfig{Interval: hookInterval}
+		for _, v := range hooks {
+			h, err := node.ParseHook(v)
+			if err != nil {
+				log.Fatal(err)
+			}
+			hooksConfig.Hooks = append(hooksConfig.Hooks, h)
+		}
+		if err := n.SetHooks(hooksConfig); err != nil {
+			log.Fatal(err)
+		}
+	}
+	// the first socket passed by systemd is the node address, the second the web port and the
+	// third the local api
+	lns, err := systemd.Listeners()
+	if err != nil {
+		log.Errorf("socket activation err: %v", err)
+	}
+	start := func(discoveries node.Addresses) error {
+		if len(lns) > 0 {
+			if ln, ok := lns[0].(*net.TCPListener); ok {
+				return n.StartWithListener(discoveries, ln)
+			}
+		}
+		return n.Start(discoveries, config.Address)
+	}
+	discoveries := config.DiscoveryAddresses
+	if len(config.DiscoveryAddresses) == 0 && !stateless {
 		cfs := &node.NodeConfigs{}
 		err = node.LoadConfig(cfs, confPath)
 		if err != nil {
@@ -68,26 +378,155 @@ func main() {
 			log.Error(err)
 		}
 		conf, ok := cfs.Configs[key]
+		if ok && conf.GetNetwork() != profile.Name {
+			log.Fatalf("the config of node %s in %s is for network %s, use -conf with another file for network %s", key, confPath, conf.GetNetwork(), profile.Name)
+		}
 		if !ok {
-			conf = node.NewNodeConf()
+			conf = profile.NodeConf()
 			if cfs.Configs == nil {
 				cfs.Configs = make(map[string]*node.Config)
 			}
 			cfs.Configs[key] = conf
 			node.WriteConfig(&cfs, confPath)
 		}
-		err = n.Start(conf.DiscoveryAddresses, config.Address)
-		if err != nil {
-			log.Error(err)
+		discoveries = conf.DiscoveryAddresses
+	}
+	n.SetAppConfigRoot(appConfigRoot)
+	// the node closes the discoveries, the transports and what runs until it closes, so it is
+	// stopped last. The discoveries are connected again in the background if they are down
+	n.AddSubsystem(node.Subsystem{
+		Name: node.NodeSubsystem,
+		Stop: n.Close,
+	})
+	n.AddSubsystem(node.Subsystem{
+		Name:     node.DiscoverySubsystem,
+		Requires: []string{node.NodeSubsystem},
+		Start: func() error {
+			return start(discoveries)
+		},
+	})
+	if natDetect {
+		if len(stunServers) == 0 {
+			stunServers = profile.STUN()
 		}
-	} else {
-		err = n.Start(config.DiscoveryAddresses, config.Address)
-		if err != nil {
-			log.Error(err)
+		n.AddSubsystem(node.Subsystem{
+			Name:     node.NATSubsystem,
+			Requires: []string{node.NodeSubsystem},
+			After:    []string{node.DiscoverySubsystem},
+			Start: func() error {
+				n.StartNATDetection(stunServers)
+				return nil
+			},
+		})
+	}
+	if portMapping {
+		n.AddSubsystem(node.Subsystem{
+			Name:     node.PortMappingSubsystem,
+			Requires: []string{node.NodeSubsystem},
+			After:    []string{node.DiscoverySubsystem},
+			Start: func() error {
+				n.StartPortMapping()
+				return nil
+			},
+		})
+	}
+	if watchdog {
+		n.AddSubsystem(node.Subsystem{
+			Name:     node.WatchdogSubsystem,
+			Requires: []string{node.NodeSubsystem},
+			After:    []string{node.DiscoverySubsystem},
+			Start: func() error {
+				n.StartWatchdog(watchdogConfig)
+				return nil
+			},
+		})
+	}
+	if clockCheck {
+		if len(ntpServers) == 0 {
+			ntpServers = profile.NTP()
 		}
+		n.AddSubsystem(node.Subsystem{
+			Name:     node.ClockSubsystem,
+			Requires: []string{node.NodeSubsystem},
+			After:    []string{node.DiscoverySubsystem},
+			Start: func() error {
+				n.StartClockCheck(ntpServers, maxClockSkew)
+				return nil
+			},
+		})
+	}
+	if len(bandwidthPeers) > 0 || bandwidthConfig.Serve {
+		for _, v := range bandwidthPeers {
+			p, err := node.ParseBandwidthPeer(v)
+			if err != nil {
+				log.Fatal(err)
+			}
+			bandwidthConfig.Peers = append(bandwidthConfig.Peers, p)
+		}
+		if stateless {
+			bandwidthConfig.Dir = ""
+		}
+		n.AddSubsystem(node.Subsystem{
+			Name:     node.BandwidthSubsystem,
+			Requires: []string{node.NodeSubsystem},
+			After:    []string{node.DiscoverySubsystem},
+			Start: func() error {
+				return n.StartBandwidthTests(bandwidthConfig)
+			},
+		})
+	}
+	if accountingConfig.Interval > 0 {
+		// stateless nodes keep the reports in memory only
+		if stateless {
+			accountingConfig.Dir = ""
+		}
+		n.AddSubsystem(node.Subsystem{
+			Name:     node.AccountingSubsystem,
+			Requires: []string{node.NodeSubsystem},
+			After:    []string{node.DiscoverySubsystem},
+			Start: func() error {
+				return n.StartAccounting(accountingConfig)
+			},
+		})
+	}
+	// the apps come once the transports they use are set up
+	if len(appSocket) > 0 {
+		n.AddSubsystem(node.Subsystem{
+			Name:     node.AppSocketSubsystem,
+			Requires: []string{node.NodeSubsystem},
+			After:    []string{node.DiscoverySubsystem, node.NATSubsystem, node.PortMappingSubsystem, node.AccountingSubsystem},
+			Start: func() error {
+				return n.StartAppSocket(appSocket)
+			},
+		})
+	}
+	if len(localApi) > 0 {
+		la := api.NewLocal(localApi, n, &config)
+		if len(lns) > 2 {
+			la.SetListener(lns[2])
+		}
+		n.AddSubsystem(node.Subsystem{
+			Name:     node.LocalApiSubsystem,
+			Requires: []string{node.NodeSubsystem},
+			After:    []string{node.DiscoverySubsystem},
+			Start:    la.Start,
+			Stop: func() {
+				la.Close()
+			},
+		})
+	}
+	if len(statusPage) > 0 {
+		sp := api.NewStatusPage(statusPage, n)
+		n.AddSubsystem(node.Subsystem{
+			Name:     node.StatusPageSubsystem,
+			Requires: []string{node.NodeSubsystem},
+			After:    []string{node.DiscoverySubsystem},
+			Start:    sp.Start,
+			Stop: func() {
+				sp.Close()
+			},
+		})
 	}
-	defer n.Close()
-	log.Debugf("listen on %s", config.Address)
 	var na *api.NodeApi
 	var tokenUrl string
 	if len(strings.Split(config.ManagerWeb, ":")) == 1 {
@@ -97,7 +536,23 @@ func main() {
 	}
 	if config.ConnectManager {
 		var setupNode = func() (success bool) {
-			resp, err := http.Get(tokenUrl)
+			// the manager gives the token to the nodes connected to it that sign the request
+			req, err := http.NewRequest(http.MethodGet, tokenUrl, nil)
+			if err != nil {
+				log.Error(err)
+				// failure
+				return false
+			}
+			headers, err := n.SignManagerRequest(req.Method, req.URL)
+			if err != nil {
+				log.Error(err)
+				// failure
+				return false
+			}
+			for k, v := range headers {
+				req.Header.Set(k, v)
+			}
+			resp, err := http.DefaultClient.Do(req)
 			if err != nil {
 				log.Error(err)
 				// failure
@@ -110,9 +565,27 @@ func main() {
 				// failure
 				return false
 			}
+			if resp.StatusCode != http.StatusOK {
+				e := httputil.ReadError(resp, token)
+				if !e.Retryable {
+					// asking again fails the same way, the token is asked for again when the
+					// manager is connected again
+					log.Errorf("manager refused the token: %s (%s)", e.Message, e.Code)
+					return true
+				}
+				log.Warnf("manager token: %s (%s)", e.Message, e.Code)
+				// failure
+				return false
+			}
 			if na == nil {
 				// na doesn't exist yet, create it and start the server
 				na = api.New(config.WebPort, string(token), n, &config, confPath, osSignal)
+				if err := na.SetShellKeys(shellManagerKeys); err != nil {
+					log.Fatal(err)
+				}
+				if len(lns) > 1 {
+					na.SetListener(lns[1])
+				}
 				na.StartSrv()
 			} else {
 				// na already exists, just update token
@@ -121,23 +594,127 @@ func main() {
 			// success
 			return true
 		}
-		err = n.ConnectManager(config.ManagerAddr, setupNode)
-		if err != nil {
-			log.Error(err)
-		}
-		// close node connection upon termination if na exists
-		defer func() {
-			if na != nil {
-				na.Close()
-			}
-		}()
+		// the manager is connected again in the background if it is down, so its api is
+		// closed even if the first connection failed
+		n.AddSubsystem(node.Subsystem{
+			Name:     node.ManagerSubsystem,
+			Requires: []string{node.NodeSubsystem},
+			After:    []string{node.DiscoverySubsystem, node.AppSocketSubsystem},
+			Start: func() error {
+				return n.ConnectManager(config.ManagerAddr, setupNode)
+			},
+			Stop: func() {
+				if na != nil {
+					na.Close()
+				}
+			},
+		})
+	}
+	err = n.StartSubsystems()
+	if err != nil {
+		// the node runs without them, they are listed in the node info
+		log.Error(err)
+	}
+	defer n.StopSubsystems()
+	log.Debugf("listen on %s", config.Address)
+	if firstRun {
+		go onboard(n)
+	} else if config.ConnectManager {
+		showPairingCodes(n, "")
 	}
+	systemd.Notify(systemd.Ready)
+	stopWatchdog := make(chan struct{})
+	systemd.StartWatchdog(nil, stopWatchdog)
+	defer func() {
+		close(stopWatchdog)
+		systemd.Notify(systemd.Stopping)
+	}()
 	select {
 	case signal := <-osSignal:
 		if signal == os.Interrupt {
 			log.Debugln("exit by signal Interrupt")
 		} else if signal == os.Kill {
 			log.Debugln("exit by signal Kill")
+		} else if signal == syscall.SIGTERM {
+			log.Debugln("exit by signal Terminate")
 		}
 	}
 }
+
+// onboard prints the summary of the first start once the node registered with the discoveries
+func onboard(n *node.Node) {
+	var conf, manager string
+	if len(config.DiscoveryAddresses) == 0 {
+		conf = confPath
+	}
+	if config.ConnectManager {
+		manager = config.ManagerAddr
+	}
+	o, err := n.Onboard(conf, manager, onboardingWait)
+	if err != nil {
+		log.Errorf("onboarding: %v", err)
+		if o == nil {
+			return
+		}
+	}
+	fmt.Print(o.Summary())
+	if config.ConnectManager {
+		showPairingCodes(n, o.PairingCode)
+	}
+}
+
+// showPairingCodes prints the pairing codes of the node but the one already shown until
+// a manager paired with it
+func showPairingCodes(n *node.Node, shown string) {
+	n.StartPairingCodes(func(code string, expires time.Time) {
+		if code == shown {
+			return
+		}
+		fmt.Printf("pairing code %s, enter it in the manager before %s\n", code, expires.Format("15:04"))
+	})
+}
+
+// writeSystemdUnits writes a notify service with watchdog and a socket unit for
+// the node address and the web port, the service is started with the flags of this call
+func writeSystemdUnits(dir string) (err error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return
+	}
+	args := []string{exe}
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "gen-systemd" {
+			return
+		}
+		if addrs, ok := f.Value.(*node.Addresses); ok {
+			for _, addr := range *addrs {
+				args = append(args, "-"+f.Name, addr)
+			}
+			return
+		}
+		args = append(args, "-"+f.Name+"="+f.Value.String())
+	})
+	unit := &systemd.Unit{
+		Name:        "skywire-node",
+		Description: "Skywire Node",
+		ExecStart:   args,
+		WatchdogSec: 30,
+		Sockets:     []string{config.Address, config.WebPort},
+	}
+	if len(localApi) > 0 {
+		unit.Sockets = append(unit.Sockets, localApi)
+	}
+	if u := os.Getenv("USER"); len(u) > 0 {
+		unit.User = u
+	}
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, unit.Name+".service"), []byte(unit.Service()), 0644)
+	if err != nil {
+		return
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, unit.Name+".socket"), []byte(unit.Socket()), 0644)
+	return
+}
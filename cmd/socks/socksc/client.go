@@ -8,11 +8,13 @@ import (
 	"os/signal"
 	"path/filepath"
 	"strconv"
+	"syscall"
 
 	ss "github.com/shadowsocks/shadowsocks-go/shadowsocks"
 	log "github.com/sirupsen/logrus"
 	"github.com/skycoin/skycoin/src/util/file"
 	"github.com/skycoin/skywire/pkg/app"
+	"github.com/skycoin/skywire/pkg/net/resolver"
 	"github.com/skycoin/skywire/pkg/net/skycoin-messenger/factory"
 )
 
@@ -34,6 +36,14 @@ var (
 
 	discoveryKey string
 
+	// the hosts are resolved by the exit unless upstreams are set
+	dnsConfig = resolver.DefaultConfig
+
+	// serve the proxy auto-config of the proxy
+	pacAddress string
+	// point the system proxy at the proxy auto-config until exit
+	systemProxy bool
+
 	version bool
 )
 
@@ -45,6 +55,10 @@ func parseFlags() {
 	flag.StringVar(&nodeKey, "node-key", "", "connect to node key")
 	flag.StringVar(&appKey, "app-key", "", "connect to app key")
 	flag.StringVar(&discoveryKey, "discovery-key", "", "connect to discovery key")
+	flag.Var((*resolver.List)(&dnsConfig.Upstreams), "dns-upstream", "tls://host[:port] or https://host/path DNS upstream resolving the hosts locally instead of by the exit, tried in order")
+	flag.Var((*resolver.List)(&dnsConfig.ExitDomains), "dns-exit-domain", "domain, and its subdomains, always resolved by the exit even with a DNS upstream")
+	flag.StringVar(&pacAddress, "pac-address", "", "serve the proxy auto-config (PAC) file of the proxy at http://<address>/proxy.pac")
+	flag.BoolVar(&systemProxy, "system-proxy", false, "point the proxy settings of the system at the PAC file while running, restored on exit")
 	flag.BoolVar(&version, "v", false, "print current version")
 	flag.Parse()
 }
@@ -67,8 +81,15 @@ func main() {
 		os.Exit(-1)
 	}
 
+	if len(dnsConfig.Upstreams) > 0 {
+		dns, err = resolver.New(dnsConfig)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	osSignal := make(chan os.Signal, 1)
-	signal.Notify(osSignal, os.Interrupt, os.Kill)
+	signal.Notify(osSignal, os.Interrupt, os.Kill, syscall.SIGTERM)
 
 	a := app.NewClient(app.Client, "socksc", Version)
 	a.AppConnectionInitCallback = func(resp *factory.AppConnResp) *factory.AppFeedback {
@@ -112,6 +133,26 @@ func main() {
 		log.Fatal(err)
 	}
 
+	if systemProxy && len(pacAddress) == 0 {
+		pacAddress = "127.0.0.1:0"
+	}
+	if len(pacAddress) > 0 {
+		pacURL, err := servePAC(pacAddress, localAddr(listenAddress))
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Infof("proxy auto-config at %s", pacURL)
+		if systemProxy {
+			revert, err := setSystemProxy(pacURL)
+			if err != nil {
+				log.Errorf("set system proxy: %v", err)
+			} else {
+				log.Infof("system proxy set to %s until exit", pacURL)
+				defer revert()
+			}
+		}
+	}
+
 	select {
 	case signal := <-osSignal:
 		if signal == os.Interrupt {
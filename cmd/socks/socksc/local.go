@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -14,10 +15,14 @@ import (
 	"time"
 
 	ss "github.com/shadowsocks/shadowsocks-go/shadowsocks"
+	"github.com/skycoin/skywire/pkg/net/resolver"
 )
 
 var debug ss.DebugLog
 
+// resolves the hosts locally instead of by the exit, nil to pass all to the exit
+var dns *resolver.Resolver
+
 var (
 	errAddrType      = errors.New("socks addr type not supported")
 	errVer           = errors.New("socks version not supported")
@@ -150,6 +155,35 @@ func getRequest(conn net.Conn) (rawaddr []byte, host string, err error) {
 	return
 }
 
+// resolveLocally replaces the domain of rawaddr by its address, unless the exit resolves
+// the domain or the local lookup fails, then the exit gets the domain
+func resolveLocally(rawaddr []byte) []byte {
+	const (
+		typeDm   = 3
+		typeIPv4 = 1
+		typeIPv6 = 4
+	)
+	if len(rawaddr) < 2 || rawaddr[0] != typeDm || len(rawaddr) < 2+int(rawaddr[1])+2 {
+		return rawaddr
+	}
+	host := string(rawaddr[2 : 2+rawaddr[1]])
+	port := rawaddr[len(rawaddr)-2:]
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	addrs, err := dns.LookupIPAddr(ctx, host)
+	cancel()
+	if err != nil {
+		if err != resolver.ErrViaExit {
+			log.Printf("resolve %s: %v, the exit resolves it\n", host, err)
+		}
+		return rawaddr
+	}
+	ip := addrs[0].IP
+	if ip4 := ip.To4(); ip4 != nil {
+		return append(append([]byte{typeIPv4}, ip4...), port...)
+	}
+	return append(append([]byte{typeIPv6}, ip.To16()...), port...)
+}
+
 type ServerCipher struct {
 	server string
 	cipher *ss.Cipher
@@ -301,6 +335,9 @@ func handleConnection(conn net.Conn) {
 		log.Println("error getting request:", err)
 		return
 	}
+	if dns != nil {
+		rawaddr = resolveLocally(rawaddr)
+	}
 	// Sending connection established message immediately to client.
 	// This some round trip time for creating socks connection with the client.
 	// But if connection failed, the client will get connection reset error.
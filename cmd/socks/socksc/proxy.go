@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/skycoin/skywire/pkg/httputil"
+)
+
+const pacPath = "/proxy.pac"
+
+// pacScript sends all hosts but the local ones to the socks proxy. Only address literals are
+// matched against the private networks, isInNet would resolve a name outside the proxy
+func pacScript(proxyAddr string) string {
+	return fmt.Sprintf(`function FindProxyForURL(url, host) {
+	if (isPlainHostName(host) || host == "localhost" || shExpMatch(host, "*.local")) {
+		return "DIRECT";
+	}
+	if (/^\d+\.\d+\.\d+\.\d+$/.test(host) && (isInNet(host, "127.0.0.0", "255.0.0.0") ||
+		isInNet(host, "10.0.0.0", "255.0.0.0") || isInNet(host, "172.16.0.0", "255.240.0.0") ||
+		isInNet(host, "192.168.0.0", "255.255.0.0"))) {
+		return "DIRECT";
+	}
+	return "SOCKS5 %[1]s; SOCKS %[1]s";
+}
+`, proxyAddr)
+}
+
+// servePAC serves the proxy auto-config of the socks proxy at proxyAddr and returns its url
+func servePAC(address, proxyAddr string) (url string, err error) {
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		return
+	}
+	script := pacScript(proxyAddr)
+	mux := http.NewServeMux()
+	mux.HandleFunc(pacPath, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ns-proxy-autoconfig")
+		w.Write([]byte(script))
+	})
+	go httputil.ServeListener(context.Background(), &http.Server{Handler: mux}, ln)
+	url = "http://" + localAddr(ln.Addr().String()) + pacPath
+	return
+}
+
+// localAddr returns addr with an unspecified host replaced by the loopback address
+func localAddr(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	if ip := net.ParseIP(host); len(host) == 0 || ip != nil && ip.IsUnspecified() {
+		host = "127.0.0.1"
+	}
+	return net.JoinHostPort(host, port)
+}
+
+var errSystemProxyUnsupported = errors.New("setting the system proxy is not supported on " + runtime.GOOS)
+
+// setSystemProxy points the proxy settings of the system at the proxy auto-config url,
+// revert restores the settings found before
+func setSystemProxy(pacURL string) (revert func(), err error) {
+	var undo [][]string
+	revert = func() {
+		for i := len(undo) - 1; i >= 0; i-- {
+			if out, e := exec.Command(undo[i][0], undo[i][1:]...).CombinedOutput(); e != nil {
+				log.Errorf("revert system proxy %v: %v %s", undo[i], e, out)
+			}
+		}
+	}
+	run := func(cmd ...string) (out string, err error) {
+		b, err := exec.Command(cmd[0], cmd[1:]...).CombinedOutput()
+		out = strings.TrimSpace(string(b))
+		if err != nil {
+			err = fmt.Errorf("%s: %v %s", strings.Join(cmd, " "), err, out)
+		}
+		return
+	}
+	defer func() {
+		if err != nil {
+			revert()
+		}
+	}()
+	switch runtime.GOOS {
+	case "linux":
+		// GNOME and the desktops sharing its settings
+		const schema = "org.gnome.system.proxy"
+		var mode, url string
+		if mode, err = run("gsettings", "get", schema, "mode"); err != nil {
+			return
+		}
+		if url, err = run("gsettings", "get", schema, "autoconfig-url"); err != nil {
+			return
+		}
+		undo = append(undo, []string{"gsettings", "set", schema, "autoconfig-url", url})
+		if _, err = run("gsettings", "set", schema, "autoconfig-url", pacURL); err != nil {
+			return
+		}
+		undo = append(undo, []string{"gsettings", "set", schema, "mode", mode})
+		_, err = run("gsettings", "set", schema, "mode", "auto")
+	case "darwin":
+		var list string
+		if list, err = run("networksetup", "-listallnetworkservices"); err != nil {
+			return
+		}
+		// the first line is a note, disabled services start with an asterisk
+		for _, service := range strings.Split(list, "\n")[1:] {
+			if len(service) == 0 || strings.HasPrefix(service, "*") {
+				continue
+			}
+			var current string
+			if current, err = run("networksetup", "-getautoproxyurl", service); err != nil {
+				return
+			}
+			if url := field(current, "URL:"); strings.Contains(current, "Enabled: Yes") && url != "(null)" {
+				undo = append(undo, []string{"networksetup", "-setautoproxyurl", service, url})
+			} else {
+				undo = append(undo, []string{"networksetup", "-setautoproxystate", service, "off"})
+			}
+			if _, err = run("networksetup", "-setautoproxyurl", service, pacURL); err != nil {
+				return
+			}
+		}
+	case "windows":
+		// the browsers read it when they start or open a new connection
+		const key = `HKCU\Software\Microsoft\Windows\CurrentVersion\Internet Settings`
+		if current, e := run("reg", "query", key, "/v", "AutoConfigURL"); e == nil {
+			undo = append(undo, []string{"reg", "add", key, "/v", "AutoConfigURL", "/t", "REG_SZ", "/d", field(current, "REG_SZ"), "/f"})
+		} else {
+			undo = append(undo, []string{"reg", "delete", key, "/v", "AutoConfigURL", "/f"})
+		}
+		_, err = run("reg", "add", key, "/v", "AutoConfigURL", "/t", "REG_SZ", "/d", pacURL, "/f")
+	default:
+		err = errSystemProxyUnsupported
+	}
+	return
+}
+
+// field returns the rest of the line of out after name
+func field(out, name string) string {
+	for _, line := range strings.Split(out, "\n") {
+		if i := strings.Index(line, name); i >= 0 {
+			return strings.TrimSpace(line[i+len(name):])
+		}
+	}
+	return ""
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestServePAC(t *testing.T) {
+	url, err := servePAC("127.0.0.1:0", localAddr("0.0.0.0:9443"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(url, "http://127.0.0.1:") || !strings.HasSuffix(url, pacPath) {
+		t.Fatalf("url %s", url)
+	}
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/x-ns-proxy-autoconfig" {
+		t.Fatalf("%d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	if !strings.Contains(string(body), `return "SOCKS5 127.0.0.1:9443; SOCKS 127.0.0.1:9443";`) {
+		t.Fatalf("script %s", body)
+	}
+}
+
+func TestLocalAddr(t *testing.T) {
+	for addr, want := range map[string]string{
+		":9443":          "127.0.0.1:9443",
+		"0.0.0.0:9443":   "127.0.0.1:9443",
+		"[::]:9443":      "127.0.0.1:9443",
+		"10.0.0.1:9443":  "10.0.0.1:9443",
+		"localhost:9443": "localhost:9443",
+		"invalid":        "invalid",
+	} {
+		if got := localAddr(addr); got != want {
+			t.Errorf("%s: got %s, want %s", addr, got, want)
+		}
+	}
+}
+
+func TestField(t *testing.T) {
+	out := "Enabled: Yes\nURL: http://127.0.0.1:8080/proxy.pac\n"
+	if got := field(out, "URL:"); got != "http://127.0.0.1:8080/proxy.pac" {
+		t.Fatalf("url %q", got)
+	}
+	if got := field(out, "Missing:"); len(got) != 0 {
+		t.Fatalf("missing field %q", got)
+	}
+}
+
+// fakeGsettings puts a gsettings in the path that records its calls in calls and answers
+// the gets with the settings of a system without a proxy
+func fakeGsettings(t *testing.T, dir string) (calls string) {
+	calls = filepath.Join(dir, "calls")
+	script := `#!/bin/sh
+echo "$@" >> ` + calls + `
+case "$1 $3" in
+"get mode") echo "'none'" ;;
+"get autoconfig-url") echo "''" ;;
+esac
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, "gsettings"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestSetSystemProxy(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the fake settings are the ones of linux")
+	}
+	dir, err := ioutil.TempDir("", "socksc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	calls := fakeGsettings(t, dir)
+	path := os.Getenv("PATH")
+	defer os.Setenv("PATH", path)
+	os.Setenv("PATH", dir)
+
+	revert, err := setSystemProxy("http://127.0.0.1:8080/proxy.pac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	revert()
+	b, err := ioutil.ReadFile(calls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the settings found are restored in the reverse order
+	want := []string{
+		"get org.gnome.system.proxy mode",
+		"get org.gnome.system.proxy autoconfig-url",
+		"set org.gnome.system.proxy autoconfig-url http://127.0.0.1:8080/proxy.pac",
+		"set org.gnome.system.proxy mode auto",
+		"set org.gnome.system.proxy mode 'none'",
+		"set org.gnome.system.proxy autoconfig-url ''",
+	}
+	if got := strings.Split(strings.TrimSpace(string(b)), "\n"); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("calls\n%s", b)
+	}
+
+	// nothing is changed without the settings
+	os.Setenv("PATH", filepath.Join(dir, "none"))
+	if _, err = setSystemProxy("http://127.0.0.1:8080/proxy.pac"); err == nil {
+		t.Fatal("set without gsettings")
+	}
+}
@@ -16,6 +16,7 @@ import (
 	"syscall"
 
 	ss "github.com/shadowsocks/shadowsocks-go/shadowsocks"
+	"github.com/skycoin/skywire/pkg/net/resolver"
 )
 
 const (
@@ -37,6 +38,9 @@ const (
 var debug ss.DebugLog
 var udp bool
 
+// resolves the hosts the clients connect to
+var dns *resolver.Resolver
+
 func getRequest(conn *ss.Conn, auth bool) (host string, ota bool, err error) {
 	ss.SetReadTimeout(conn)
 
@@ -147,15 +151,9 @@ func handleConnection(conn *ss.Conn, auth bool) {
 		return
 	}
 
-	// check if it is ok to forward traffic from this host
-	if !shouldConnectTo(host) {
-		log.Println("not connecting to", host)
-		closed = true
-		return
-	}
-
 	debug.Println("connecting", host)
-	remote, err := net.Dial("tcp", host)
+	// only the resolved addresses it is ok to forward traffic to are dialed
+	remote, err := dns.Dial("tcp", host, shouldConnectTo)
 	if err != nil {
 		if ne, ok := err.(*net.OpError); ok && (ne.Err == syscall.EMFILE || ne.Err == syscall.ENFILE) {
 			// log too many open file error
@@ -184,13 +182,7 @@ func handleConnection(conn *ss.Conn, auth bool) {
 	return
 }
 
-func shouldConnectTo(ipStr string) bool {
-	pTCPAddr, _ := net.ResolveTCPAddr("tcp", ipStr)
-	ip := pTCPAddr.IP
-	if ip == nil {
-		return false
-	}
-
+func shouldConnectTo(ip net.IP) bool {
 	// Check that the IP is not localhost, local multicast or unspecified
 	// TODO -- also block ip.IsMulticast()?
 	if ip.IsLoopback() || ip.IsInterfaceLocalMulticast() || ip.IsUnspecified() {
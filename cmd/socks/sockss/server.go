@@ -12,6 +12,7 @@ import (
 	log "github.com/sirupsen/logrus"
 	"github.com/skycoin/skycoin/src/util/file"
 	"github.com/skycoin/skywire/pkg/app"
+	"github.com/skycoin/skywire/pkg/net/resolver"
 )
 
 const (
@@ -27,6 +28,8 @@ var (
 	seedPath string
 	// allow node public keys to connect
 	nodeKeys app.NodeKeys
+	// resolves the hosts of the clients, the system resolver if no upstreams
+	dnsConfig = resolver.DefaultConfig
 
 	version bool
 )
@@ -37,6 +40,8 @@ func parseFlags() {
 	flag.BoolVar(&seed, "seed", true, "use fixed seed to connect if true")
 	flag.StringVar(&seedPath, "seed-path", filepath.Join(file.UserHome(), ".skywire", "ss", "keys.json"), "path to save seed info")
 	flag.Var(&nodeKeys, "node-key", "allow node public keys to connect")
+	flag.Var((*resolver.List)(&dnsConfig.Upstreams), "dns-upstream", "tls://host[:port] or https://host/path DNS upstream resolving the hosts of the clients, tried in order")
+	flag.DurationVar(&dnsConfig.CacheTTL, "dns-cache-ttl", resolver.DefaultConfig.CacheTTL, "keep the resolved hosts this long")
 	flag.BoolVar(&version, "v", false, "print current version")
 	flag.Parse()
 }
@@ -55,6 +60,11 @@ func main() {
 		PortPassword: map[string]string{strconv.Itoa(serverPort): "123456"},
 	}
 	ss.SetDebug(true)
+	var err error
+	dns, err = resolver.New(dnsConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
 	appmain()
 	a := app.NewServer(app.Public, "sockss", ":"+strconv.Itoa(serverPort), Version)
 	a.SetAllowNodes(nodeKeys)
@@ -66,7 +76,7 @@ func main() {
 			seedPath = filepath.Join(file.UserHome(), ".skywire", "ss", "keys.json")
 		}
 	}
-	err := a.Start(nodeAddress, seedPath)
+	err = a.Start(nodeAddress, seedPath)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -2,11 +2,15 @@ package app
 
 import (
 	"fmt"
+	"net"
 	"os"
+	"sync"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/skycoin/skycoin/src/cipher"
 	"github.com/skycoin/skywire/pkg/net/skycoin-messenger/factory"
+	"github.com/skycoin/skywire/pkg/trace"
 )
 
 type App struct {
@@ -16,8 +20,36 @@ type App struct {
 	appType     Type
 	allowNodes  NodeKeys
 	Version     string
+	// weights of the cost of the paths of the connections the app builds, the node ranks the
+	// paths by them, nil for the weights of the node
+	PathCost *factory.PathCost
+	// of the connections the app builds, the zero fields use the defaults of the nodes
+	SetupTimeouts factory.SetupTimeouts
+	// the node keeps a standby transport for the connections the app builds
+	Critical bool
+	// the discoveries only learn the nodes of the connections the app builds, not the apps
+	Private bool
+	// discoveries the connections the app builds must avoid
+	RouteConstraints *factory.RouteConstraints
+	// the node only accepts connections to the app set up with this token, and hides the
+	// app from the discovery
+	Knock []byte
+	// token of the apps the app connects to, if they take one
+	DialKnock []byte
 
 	AppConnectionInitCallback func(resp *factory.AppConnResp) *factory.AppFeedback
+	// called when the node closed a connection of the app to another app, with the reason
+	OnConnClosed func(closed *factory.AppConnClosed)
+	// small writes of the connections are held back this long and sent together, 0 sends
+	// every write at once
+	CoalesceDelay time.Duration
+	closeReasons  closeReasons
+	dials         dials
+
+	serveMutex  sync.Mutex
+	handlers    map[string]Handler
+	listeners   []net.Listener
+	serveClosed bool
 }
 
 type NodeKeys []string
@@ -63,9 +95,22 @@ func NewClient(appType Type, service, version string) *App {
 }
 
 func (app *App) Start(addr, scPath string) error {
+	// without a callback the node sends no feedback and the answers are not waited for
+	initCallback := app.AppConnectionInitCallback
+	if initCallback != nil {
+		initCallback = app.connectionInit
+	}
 	err := app.net.ConnectWithConfig(addr, &factory.ConnConfig{
 		SeedConfigPath: scPath,
 		OnConnected: func(connection *factory.Connection) {
+			if len(app.Knock) > 0 {
+				allowNodes := app.allowNodes
+				if app.appType == Public {
+					allowNodes = nil
+				}
+				connection.OfferKnockedServiceWithAddress(app.serviceAddr, app.Version, app.Knock, allowNodes, app.service)
+				return
+			}
 			switch app.appType {
 			case Public:
 				connection.OfferServiceWithAddress(app.serviceAddr, app.Version, app.service)
@@ -80,7 +125,8 @@ func (app *App) Start(addr, scPath string) error {
 			os.Exit(1)
 		},
 		FindServiceNodesByAttributesCallback: app.FindServiceByAttributesCallback,
-		AppConnectionInitCallback:            app.AppConnectionInitCallback,
+		AppConnectionInitCallback:            initCallback,
+		AppConnClosedCallback:                app.connClosed,
 	})
 	return err
 }
@@ -93,12 +139,24 @@ func (app *App) SetAllowNodes(nodes NodeKeys) {
 	app.allowNodes = nodes
 }
 
+// SetTracer records a span for each connection the app builds, the spans of the nodes that
+// set the connection up join its trace
+func (app *App) SetTracer(t *trace.Tracer) {
+	app.net.SetTracer(t)
+}
+
 func (app *App) ConnectTo(nodeKeyHex, appKeyHex, discoveryKeyHex string) (err error) {
+	_, err = app.connectTo(nodeKeyHex, appKeyHex, discoveryKeyHex, "")
+	return
+}
+
+// connectTo asks the node for a connection to the app, a new setup id if setupID is empty
+func (app *App) connectTo(nodeKeyHex, appKeyHex, discoveryKeyHex, setupID string) (appKey cipher.PubKey, err error) {
 	nodeKey, err := cipher.PubKeyFromHex(nodeKeyHex)
 	if err != nil {
 		return
 	}
-	appKey, err := cipher.PubKeyFromHex(appKeyHex)
+	appKey, err = cipher.PubKeyFromHex(appKeyHex)
 	if err != nil {
 		return
 	}
@@ -111,7 +169,15 @@ func (app *App) ConnectTo(nodeKeyHex, appKeyHex, discoveryKeyHex string) (err er
 		}
 	}
 	app.net.ForEachConn(func(connection *factory.Connection) {
-		connection.BuildAppConnection(nodeKey, appKey, discoveryKey)
+		connection.BuildAppConnectionWithOptions(nodeKey, appKey, discoveryKey, factory.AppDialOptions{
+			Timeouts:    app.SetupTimeouts,
+			Cost:        app.PathCost,
+			Critical:    app.Critical,
+			Private:     app.Private,
+			Constraints: app.RouteConstraints,
+			SetupID:     setupID,
+			Knock:       app.DialKnock,
+		})
 	})
 	return
 }
@@ -0,0 +1,86 @@
+package app
+
+import (
+	"sync"
+	"time"
+
+	"github.com/skycoin/skywire/pkg/net/skycoin-messenger/factory"
+)
+
+const (
+	// the node tells why a connection closed over another connection, a read at the end of
+	// a connection waits this long for the reason
+	closeReasonWait = 200 * time.Millisecond
+	// reasons of connections nobody read to the end are dropped after this
+	closeReasonKeep = time.Minute
+)
+
+type closeReason struct {
+	reason factory.CloseReason
+	time   time.Time
+}
+
+// closeReasons keeps the reasons the node sent until the connections read them
+type closeReasons struct {
+	reasons map[string]closeReason
+	// closed and replaced when a reason arrives
+	changed chan struct{}
+	sync.Mutex
+}
+
+func connKey(local, remote string) string {
+	return local + "|" + remote
+}
+
+func (r *closeReasons) put(closed *factory.AppConnClosed) {
+	now := time.Now()
+	r.Lock()
+	if r.reasons == nil {
+		r.reasons = make(map[string]closeReason)
+	}
+	for k, v := range r.reasons {
+		if now.Sub(v.time) > closeReasonKeep {
+			delete(r.reasons, k)
+		}
+	}
+	r.reasons[connKey(closed.Local, closed.Remote)] = closeReason{reason: closed.Reason, time: now}
+	if r.changed != nil {
+		close(r.changed)
+		r.changed = nil
+	}
+	r.Unlock()
+}
+
+// take returns the reason of the connection, waiting up to wait for it to arrive
+func (r *closeReasons) take(key string, wait time.Duration) (reason factory.CloseReason, ok bool) {
+	timeout := time.NewTimer(wait)
+	defer timeout.Stop()
+	for {
+		r.Lock()
+		var v closeReason
+		v, ok = r.reasons[key]
+		if ok {
+			delete(r.reasons, key)
+			r.Unlock()
+			reason = v.reason
+			return
+		}
+		if r.changed == nil {
+			r.changed = make(chan struct{})
+		}
+		changed := r.changed
+		r.Unlock()
+		select {
+		case <-changed:
+		case <-timeout.C:
+			return
+		}
+	}
+}
+
+func (app *App) connClosed(closed *factory.AppConnClosed) {
+	app.closeReasons.put(closed)
+	if app.OnConnClosed != nil {
+		app.OnConnClosed(closed)
+	}
+}
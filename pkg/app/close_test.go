@@ -0,0 +1,58 @@
+package app
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"github.com/skycoin/skywire/pkg/net/skycoin-messenger/factory"
+)
+
+func TestCloseReason(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	a := NewClient(Client, "test", "1.0.0")
+	var events int
+	a.OnConnClosed = func(closed *factory.AppConnClosed) {
+		events++
+	}
+
+	for _, reason := range []factory.CloseReason{factory.CloseRouteFailure, factory.CloseAppExit} {
+		conn := a.WrapConn(dial(t, ln.Addr().String()))
+		node, err := ln.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the node sees the addresses of the app reversed
+		a.connClosed(&factory.AppConnClosed{
+			Local:  node.RemoteAddr().String(),
+			Remote: node.LocalAddr().String(),
+			Reason: reason,
+		})
+		node.Close()
+		_, err = conn.Read(make([]byte, 1))
+		ce, ok := err.(*factory.CloseError)
+		if !ok || ce.Reason != reason {
+			t.Fatalf("read err %v, expected %s", err, reason)
+		}
+		conn.Close()
+	}
+	if events != 2 {
+		t.Fatalf("%d close events", events)
+	}
+
+	// without a reason the end of the connection is io.EOF
+	conn := a.WrapConn(dial(t, ln.Addr().String()))
+	node, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	node.Close()
+	if _, err = conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("read err %v", err)
+	}
+	conn.Close()
+}
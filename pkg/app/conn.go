@@ -0,0 +1,318 @@
+package app
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/skycoin/skywire/pkg/net/skycoin-messenger/factory"
+)
+
+// the data read from the node and not yet read by the app, the node is read again once the
+// app read some of it
+const connReadBuffer = 64 << 10
+
+// timeoutError fails the reads and writes of a Conn past their deadline, a net.Error that
+// tells a timeout like the ones of net.Conn
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// Conn is a connection of the app to or from the node. Its reads at the end fail with a
+// *factory.CloseError telling why the node closed it, and with a coalesce delay its small
+// writes are held back and sent together, so a chatty protocol sends fewer packets. Once
+// the node closed the connection its writes fail with a *factory.LoopFrameError, a second
+// Close with a *factory.LoopTransitionError. The data of the node is read into a buffer, so
+// the read deadline is kept by the Conn and a read past it fails with a timeout while the
+// data buffered waits for the next read. The write deadline is the one of the connection to
+// the node, a write held back is sent at once if the deadline comes before the delay ends.
+type Conn struct {
+	net.Conn
+	reasons *closeReasons
+
+	state      factory.LoopState
+	stateMutex sync.Mutex
+
+	closeOnce sync.Once
+	closeErr  error
+
+	// serializes the reads of the app
+	readMutex    sync.Mutex
+	readOnce     sync.Once
+	bufMutex     sync.Mutex
+	readBuf      []byte
+	readErr      error
+	readDeadline time.Time
+	// signaled when data or an error was buffered or the deadline changed
+	readWake chan struct{}
+	// signaled when the app read from a full buffer
+	readSpace chan struct{}
+	done      chan struct{}
+
+	writeMutex sync.Mutex
+	delay      time.Duration
+	noDelay    bool
+	buf        []byte
+	timer      *time.Timer
+	// of a write by the timer, returned by the next write
+	writeErr      error
+	writeDeadline time.Time
+}
+
+// WrapConn wraps conn, a connection to or from the node, to learn why it was closed and to
+// coalesce its writes by the CoalesceDelay of the app. The connections passed to the
+// handlers of Serve are wrapped already.
+func (app *App) WrapConn(conn net.Conn) *Conn {
+	return &Conn{
+		Conn:      conn,
+		reasons:   &app.closeReasons,
+		delay:     app.CoalesceDelay,
+		state:     factory.LoopOpen,
+		readWake:  make(chan struct{}, 1),
+		readSpace: make(chan struct{}, 1),
+		done:      make(chan struct{}),
+	}
+}
+
+// State returns open, half closed once the node closed the connection, closing or closed
+func (c *Conn) State() factory.LoopState {
+	c.stateMutex.Lock()
+	defer c.stateMutex.Unlock()
+	return c.state
+}
+
+func (c *Conn) transition(to factory.LoopState) error {
+	c.stateMutex.Lock()
+	defer c.stateMutex.Unlock()
+	if !c.state.CanTransition(to) {
+		return &factory.LoopTransitionError{From: c.state, To: to}
+	}
+	c.state = to
+	return nil
+}
+
+// Read returns the data buffered, or waits for the node until the read deadline. It returns
+// the reason instead of io.EOF once the node closed the connection
+func (c *Conn) Read(b []byte) (n int, err error) {
+	c.readMutex.Lock()
+	defer c.readMutex.Unlock()
+	c.readOnce.Do(func() {
+		go c.readLoop()
+	})
+	n, err = c.readBuffered(b)
+	if err != io.EOF {
+		return
+	}
+	c.transition(factory.LoopHalfClosed)
+	c.closeOnce.Do(func() {
+		reason, ok := c.reasons.take(connKey(c.LocalAddr().String(), c.RemoteAddr().String()), closeReasonWait)
+		if ok {
+			c.closeErr = &factory.CloseError{Reason: reason}
+		}
+	})
+	if c.closeErr != nil {
+		err = c.closeErr
+	}
+	return
+}
+
+func (c *Conn) readBuffered(b []byte) (n int, err error) {
+	var timer *time.Timer
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+	for {
+		c.bufMutex.Lock()
+		deadline := c.readDeadline
+		switch {
+		case !deadline.IsZero() && !time.Now().Before(deadline):
+			err = timeoutError{}
+		case len(c.readBuf) > 0:
+			n = copy(b, c.readBuf)
+			c.readBuf = c.readBuf[n:]
+			signal(c.readSpace)
+		case c.readErr != nil:
+			err = c.readErr
+		}
+		c.bufMutex.Unlock()
+		if n > 0 || err != nil || len(b) == 0 {
+			return
+		}
+		var timeout <-chan time.Time
+		if !deadline.IsZero() {
+			if timer != nil {
+				timer.Stop()
+			}
+			timer = time.NewTimer(time.Until(deadline))
+			timeout = timer.C
+		}
+		select {
+		case <-c.readWake:
+		case <-timeout:
+		}
+	}
+}
+
+// readLoop buffers the data of the node until it fails, it waits for the app while the
+// buffer is full
+func (c *Conn) readLoop() {
+	b := make([]byte, factory.MaxAppPayload)
+	for {
+		n, err := c.Conn.Read(b)
+		c.bufMutex.Lock()
+		c.readBuf = append(c.readBuf, b[:n]...)
+		c.readErr = err
+		full := len(c.readBuf) >= connReadBuffer
+		c.bufMutex.Unlock()
+		signal(c.readWake)
+		if err != nil {
+			return
+		}
+		for full {
+			select {
+			case <-c.readSpace:
+			case <-c.done:
+				return
+			}
+			c.bufMutex.Lock()
+			full = len(c.readBuf) >= connReadBuffer
+			c.bufMutex.Unlock()
+		}
+	}
+}
+
+func signal(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
+
+// Write sends b at once without a coalesce delay, else it is held back until the writes
+// fill a packet, the delay passes or Flush is called
+func (c *Conn) Write(b []byte) (n int, err error) {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	if s := c.State(); s != factory.LoopOpen {
+		err = &factory.LoopFrameError{State: s, Frame: factory.LoopFrameData}
+		return
+	}
+	if c.writeErr != nil {
+		err = c.writeErr
+		return
+	}
+	now := time.Now()
+	if !c.writeDeadline.IsZero() && !now.Before(c.writeDeadline) {
+		err = timeoutError{}
+		return
+	}
+	hold := c.delay > 0 && !c.noDelay && (c.writeDeadline.IsZero() || now.Add(c.delay).Before(c.writeDeadline))
+	if !hold || len(c.buf)+len(b) > factory.MaxAppPayload {
+		err = c.flush()
+		if err != nil {
+			return
+		}
+	}
+	if !hold || len(b) >= factory.MaxAppPayload {
+		return c.Conn.Write(b)
+	}
+	c.buf = append(c.buf, b...)
+	if c.timer == nil {
+		c.timer = time.AfterFunc(c.delay, c.flushLater)
+	}
+	return len(b), nil
+}
+
+// Flush sends the writes held back
+func (c *Conn) Flush() error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	return c.flush()
+}
+
+// SetNoDelay sends every write at once if noDelay, for the latency sensitive parts of a
+// protocol, the writes held back are sent first
+func (c *Conn) SetNoDelay(noDelay bool) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	c.noDelay = noDelay
+	if noDelay {
+		return c.flush()
+	}
+	return nil
+}
+
+// SetDeadline sets the read deadline and the write deadline of the connection to the node
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.SetReadDeadline(t)
+	return c.SetWriteDeadline(t)
+}
+
+// SetReadDeadline fails the reads waiting for the node past t, the zero time for none. A
+// read the deadline failed loses no data
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.bufMutex.Lock()
+	c.readDeadline = t
+	c.bufMutex.Unlock()
+	signal(c.readWake)
+	return nil
+}
+
+// SetWriteDeadline sets the write deadline of the connection to the node, the writes held
+// back are sent before it
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.setWriteDeadline(t, c.Conn.SetWriteDeadline)
+}
+
+func (c *Conn) setWriteDeadline(t time.Time, set func(time.Time) error) (err error) {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	c.writeDeadline = t
+	// the failure of a write past the old deadline is not one of the new
+	c.writeErr = nil
+	err = set(t)
+	if err != nil || t.IsZero() || len(c.buf) == 0 {
+		return
+	}
+	// the timer may fire after the deadline
+	c.writeErr = c.flush()
+	return
+}
+
+// Close sends the writes held back and closes the connection
+func (c *Conn) Close() error {
+	if err := c.transition(factory.LoopClosing); err != nil {
+		return err
+	}
+	c.writeMutex.Lock()
+	c.flush()
+	c.writeMutex.Unlock()
+	close(c.done)
+	err := c.Conn.Close()
+	c.transition(factory.LoopClosed)
+	return err
+}
+
+func (c *Conn) flushLater() {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	c.writeErr = c.flush()
+}
+
+func (c *Conn) flush() (err error) {
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	if len(c.buf) == 0 {
+		return
+	}
+	_, err = c.Conn.Write(c.buf)
+	c.buf = c.buf[:0]
+	return
+}
@@ -0,0 +1,272 @@
+package app
+
+import (
+	"bytes"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/skycoin/skywire/pkg/net/skycoin-messenger/factory"
+)
+
+// writesConn records the writes reaching the node
+type writesConn struct {
+	net.Conn
+	writes [][]byte
+	sync.Mutex
+}
+
+func (c *writesConn) Write(b []byte) (int, error) {
+	c.Lock()
+	c.writes = append(c.writes, append([]byte(nil), b...))
+	c.Unlock()
+	return len(b), nil
+}
+
+func (c *writesConn) Close() error {
+	return nil
+}
+
+func (c *writesConn) count() int {
+	c.Lock()
+	defer c.Unlock()
+	return len(c.writes)
+}
+
+func TestCoalesce(t *testing.T) {
+	a := NewClient(Client, "test", "1.0.0")
+	w := &writesConn{}
+	conn := a.WrapConn(w)
+	conn.Write([]byte("a"))
+	conn.Write([]byte("b"))
+	if w.count() != 2 {
+		t.Fatalf("%d writes without a delay", w.count())
+	}
+
+	a.CoalesceDelay = time.Hour
+	w = &writesConn{}
+	conn = a.WrapConn(w)
+	for _, b := range []string{"a", "b", "c"} {
+		conn.Write([]byte(b))
+	}
+	if w.count() != 0 {
+		t.Fatalf("%d writes before flush", w.count())
+	}
+	conn.Flush()
+	if w.count() != 1 || string(w.writes[0]) != "abc" {
+		t.Fatalf("writes %q", w.writes)
+	}
+
+	// a full packet is sent at once, after the writes held back
+	conn.Write([]byte("d"))
+	conn.Write(bytes.Repeat([]byte("e"), factory.MaxAppPayload))
+	if w.count() != 3 || string(w.writes[1]) != "d" {
+		t.Fatalf("writes %q", w.writes)
+	}
+
+	conn.Write([]byte("f"))
+	conn.SetNoDelay(true)
+	conn.Write([]byte("g"))
+	if w.count() != 5 || string(w.writes[3]) != "f" || string(w.writes[4]) != "g" {
+		t.Fatalf("writes %q", w.writes)
+	}
+	conn.SetNoDelay(false)
+	conn.Write([]byte("h"))
+	conn.Close()
+	if w.count() != 6 {
+		t.Fatalf("%d writes after close", w.count())
+	}
+
+	a.CoalesceDelay = 10 * time.Millisecond
+	w = &writesConn{}
+	conn = a.WrapConn(w)
+	conn.Write([]byte("i"))
+	conn.Write([]byte("j"))
+	time.Sleep(100 * time.Millisecond)
+	if w.count() != 1 || string(w.writes[0]) != "ij" {
+		t.Fatalf("writes %q after the delay", w.writes)
+	}
+}
+
+func TestConnStates(t *testing.T) {
+	a := NewClient(Client, "test", "1.0.0")
+	local, remote := net.Pipe()
+	conn := a.WrapConn(local)
+	if conn.State() != factory.LoopOpen {
+		t.Fatalf("state %s", conn.State())
+	}
+	remote.Close()
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("read after the node closed the connection")
+	}
+	if conn.State() != factory.LoopHalfClosed {
+		t.Fatalf("state %s after the node closed", conn.State())
+	}
+	_, err := conn.Write([]byte("a"))
+	if e, ok := err.(*factory.LoopFrameError); !ok || e.State != factory.LoopHalfClosed {
+		t.Fatalf("write when half closed: %v", err)
+	}
+	conn.Close()
+	if conn.State() != factory.LoopClosed {
+		t.Fatalf("state %s after close", conn.State())
+	}
+	err = conn.Close()
+	if e, ok := err.(*factory.LoopTransitionError); !ok || e.From != factory.LoopClosed {
+		t.Fatalf("second close: %v", err)
+	}
+}
+
+func TestConnDeadlines(t *testing.T) {
+	a := NewClient(Client, "test", "1.0.0")
+	a.CoalesceDelay = time.Hour
+	local, node := net.Pipe()
+	defer node.Close()
+	conn := a.WrapConn(local)
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	_, err := conn.Read(make([]byte, 1))
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		t.Fatalf("read after the deadline err %v", err)
+	}
+
+	// held back, then sent as the deadline is set
+	received := make(chan string, 2)
+	go func() {
+		b := make([]byte, 16)
+		for {
+			n, err := node.Read(b)
+			if err != nil {
+				return
+			}
+			received <- string(b[:n])
+		}
+	}()
+	if _, err = conn.Write([]byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetWriteDeadline(time.Now().Add(time.Second))
+	select {
+	case m := <-received:
+		if m != "a" {
+			t.Fatalf("received %q", m)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("write held back past the deadline")
+	}
+	// sent at once as the deadline comes before the delay
+	if _, err = conn.Write([]byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	if m := <-received; m != "b" {
+		t.Fatalf("received %q", m)
+	}
+
+	conn.SetWriteDeadline(time.Now().Add(-time.Second))
+	_, err = conn.Write([]byte("c"))
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		t.Fatalf("write after the deadline err %v", err)
+	}
+	conn.SetWriteDeadline(time.Time{})
+	if _, err = conn.Write([]byte("d")); err != nil {
+		t.Fatalf("write after the deadline was cleared err %v", err)
+	}
+}
+
+func isTimeout(err error) bool {
+	ne, ok := err.(net.Error)
+	return ok && ne.Timeout()
+}
+
+func TestConnReadDeadlines(t *testing.T) {
+	a := NewClient(Client, "test", "1.0.0")
+	local, node := net.Pipe()
+	defer node.Close()
+	conn := a.WrapConn(local)
+	defer conn.Close()
+	b := make([]byte, 16)
+
+	// expired, then extended while the data is on its way
+	conn.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	if _, err := conn.Read(b); !isTimeout(err) {
+		t.Fatalf("read after the deadline err %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	go node.Write([]byte("hello"))
+	n, err := conn.Read(b[:2])
+	if err != nil || string(b[:n]) != "he" {
+		t.Fatalf("read %q err %v after the deadline was extended", b[:n], err)
+	}
+
+	// the rest is buffered, the deadline fails the read without losing it
+	conn.SetReadDeadline(time.Now().Add(-time.Second))
+	if n, err = conn.Read(b); n != 0 || !isTimeout(err) {
+		t.Fatalf("read %d err %v past the deadline with data buffered", n, err)
+	}
+	conn.SetReadDeadline(time.Time{})
+	if n, err = conn.Read(b); err != nil || string(b[:n]) != "llo" {
+		t.Fatalf("read %q err %v after the deadline was cleared", b[:n], err)
+	}
+
+	// a read waiting for the node is woken when the deadline is cleared
+	conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		conn.SetReadDeadline(time.Time{})
+		time.Sleep(100 * time.Millisecond)
+		node.Write([]byte("late"))
+	}()
+	if n, err = conn.Read(b); err != nil || string(b[:n]) != "late" {
+		t.Fatalf("read %q err %v after the deadline was cleared while waiting", b[:n], err)
+	}
+}
+
+func TestConnWriteDeadlineExtended(t *testing.T) {
+	a := NewClient(Client, "test", "1.0.0")
+	a.CoalesceDelay = time.Hour
+	local, node := net.Pipe()
+	defer node.Close()
+	conn := a.WrapConn(local)
+	defer conn.Close()
+
+	// the node reads nothing, so the write held back fails at the deadline
+	if _, err := conn.Write([]byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetWriteDeadline(time.Now().Add(20 * time.Millisecond))
+	if _, err := conn.Write([]byte("b")); !isTimeout(err) {
+		t.Fatalf("write after the flush failed at the deadline err %v", err)
+	}
+
+	received := make(chan string, 1)
+	go func() {
+		b := make([]byte, 16)
+		n, _ := node.Read(b)
+		received <- string(b[:n])
+	}()
+	conn.SetWriteDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Write([]byte("c")); err != nil {
+		t.Fatalf("write after the deadline was extended err %v", err)
+	}
+	select {
+	case m := <-received:
+		if m != "c" {
+			t.Fatalf("received %q", m)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("write not received")
+	}
+
+	// the zero deadline holds back the writes again
+	conn.SetDeadline(time.Time{})
+	if _, err := conn.Write([]byte("d")); err != nil {
+		t.Fatalf("write without a deadline err %v", err)
+	}
+	go conn.Flush()
+	b := make([]byte, 16)
+	node.SetReadDeadline(time.Now().Add(time.Second))
+	if n, err := node.Read(b); err != nil || string(b[:n]) != "d" {
+		t.Fatalf("received %q err %v", b[:n], err)
+	}
+}
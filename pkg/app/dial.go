@@ -0,0 +1,104 @@
+package app
+
+import (
+	"context"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/skycoin/skycoin/src/cipher"
+	"github.com/skycoin/skywire/pkg/net/skycoin-messenger/factory"
+)
+
+// pendingDial is a connection being set up, cancel tells the node to give it up
+type pendingDial struct {
+	done   chan struct{}
+	cancel func()
+}
+
+// dials are the connections asked for with ConnectToContext, by setup id
+type dials struct {
+	dials map[string]*pendingDial
+	sync.Mutex
+}
+
+func (d *dials) add(setupID string, cancel func()) (di *pendingDial) {
+	di = &pendingDial{done: make(chan struct{}), cancel: cancel}
+	d.Lock()
+	if d.dials == nil {
+		d.dials = make(map[string]*pendingDial)
+	}
+	d.dials[setupID] = di
+	d.Unlock()
+	return
+}
+
+// finish forgets the dial once the node answered it, false if it was forgotten already
+func (d *dials) finish(setupID string) (ok bool) {
+	d.Lock()
+	di, ok := d.dials[setupID]
+	if ok {
+		delete(d.dials, setupID)
+	}
+	d.Unlock()
+	if ok {
+		close(di.done)
+	}
+	return
+}
+
+func (d *dials) cancelAll() {
+	d.Lock()
+	all := d.dials
+	d.dials = nil
+	d.Unlock()
+	for _, di := range all {
+		close(di.done)
+		di.cancel()
+	}
+}
+
+// ConnectToContext asks the node for a connection to the app as ConnectTo does and returns
+// the setup id the answer to AppConnectionInitCallback carries. If ctx is done or the app
+// is closed before the node answered, the node gives the connection up at once, tearing
+// the transports down at the discovery and the other node, and answers it as failed.
+func (app *App) ConnectToContext(ctx context.Context, nodeKeyHex, appKeyHex, discoveryKeyHex string) (setupID string, err error) {
+	setupID = factory.NewSetupID()
+	var appKey cipher.PubKey
+	appKey, err = cipher.PubKeyFromHex(appKeyHex)
+	if err != nil {
+		return
+	}
+	di := app.dials.add(setupID, func() {
+		app.cancelConnection(appKey, setupID)
+	})
+	_, err = app.connectTo(nodeKeyHex, appKeyHex, discoveryKeyHex, setupID)
+	if err != nil {
+		app.dials.finish(setupID)
+		return
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+			if app.dials.finish(setupID) {
+				di.cancel()
+			}
+		case <-di.done:
+		}
+	}()
+	return
+}
+
+func (app *App) cancelConnection(appKey cipher.PubKey, setupID string) {
+	app.net.ForEachConn(func(connection *factory.Connection) {
+		if err := connection.CancelAppConnection(appKey, setupID); err != nil {
+			log.Debugf("cancel connection %s: %v", setupID, err)
+		}
+	})
+}
+
+func (app *App) connectionInit(resp *factory.AppConnResp) *factory.AppFeedback {
+	if len(resp.SetupID) > 0 {
+		app.dials.finish(resp.SetupID)
+	}
+	return app.AppConnectionInitCallback(resp)
+}
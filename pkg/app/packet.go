@@ -0,0 +1,131 @@
+package app
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/skycoin/skywire/pkg/net/skycoin-messenger/factory"
+)
+
+// MaxPacketSize is the largest message of a PacketConn
+const MaxPacketSize = 0xffff
+
+var (
+	ErrPacketTooLarge    = errors.New("packet larger than MaxPacketSize")
+	ErrWriteToOtherPeer  = errors.New("packet conn only writes to the app it is connected to")
+	errPacketConnRefused = errors.New("connection to the app failed")
+)
+
+// PacketConn sends messages over a connection of the app to another app, each read returns
+// one message as it was written. A message is a 2 byte big endian length followed by it, so
+// both apps must wrap their ends of the connection. The connection to the other node is
+// still a loop, the messages arrive in order and none is lost while it is open. Its writes
+// are never held back by the CoalesceDelay of the app.
+type PacketConn struct {
+	conn *Conn
+
+	readMutex  sync.Mutex
+	writeMutex sync.Mutex
+	buf        []byte
+}
+
+// WrapPacketConn wraps conn, a connection to or from the node, to send messages over it
+func (app *App) WrapPacketConn(conn net.Conn) *PacketConn {
+	c := app.WrapConn(conn)
+	c.noDelay = true
+	return &PacketConn{conn: c}
+}
+
+// DialPacket dials the connection the node answered a connect with and wraps it, in the
+// AppConnectionInitCallback of the app
+func (app *App) DialPacket(resp *factory.AppConnResp) (*PacketConn, error) {
+	if resp.Failed {
+		if resp.Msg.Msg != "" {
+			return nil, errors.New(resp.Msg.Msg)
+		}
+		return nil, errPacketConnRefused
+	}
+	conn, err := net.Dial("tcp", net.JoinHostPort(resp.Host, strconv.Itoa(resp.Port)))
+	if err != nil {
+		return nil, err
+	}
+	return app.WrapPacketConn(conn), nil
+}
+
+// ReadFrom reads the next message into b, the rest of a message longer than b is dropped.
+// addr is the address of the connection to the node.
+func (c *PacketConn) ReadFrom(b []byte) (n int, addr net.Addr, err error) {
+	c.readMutex.Lock()
+	defer c.readMutex.Unlock()
+	var size [2]byte
+	if _, err = io.ReadFull(c.conn, size[:]); err != nil {
+		return
+	}
+	m := int(binary.BigEndian.Uint16(size[:]))
+	n = m
+	if n > len(b) {
+		n = len(b)
+	}
+	if _, err = io.ReadFull(c.conn, b[:n]); err != nil {
+		n = 0
+		return
+	}
+	if m > n {
+		if _, err = io.CopyN(ioutil.Discard, c.conn, int64(m-n)); err != nil {
+			n = 0
+			return
+		}
+	}
+	addr = c.conn.RemoteAddr()
+	return
+}
+
+// WriteTo writes b as one message, addr is nil or the address of the connection to the node
+func (c *PacketConn) WriteTo(b []byte, addr net.Addr) (n int, err error) {
+	if addr != nil && addr.String() != c.conn.RemoteAddr().String() {
+		return 0, ErrWriteToOtherPeer
+	}
+	if len(b) > MaxPacketSize {
+		return 0, ErrPacketTooLarge
+	}
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	c.buf = append(c.buf[:0], 0, 0)
+	binary.BigEndian.PutUint16(c.buf, uint16(len(b)))
+	c.buf = append(c.buf, b...)
+	if _, err = c.conn.Write(c.buf); err != nil {
+		return
+	}
+	return len(b), nil
+}
+
+// Conn returns the connection the messages go over
+func (c *PacketConn) Conn() *Conn {
+	return c.conn
+}
+
+func (c *PacketConn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *PacketConn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+func (c *PacketConn) SetDeadline(t time.Time) error {
+	return c.conn.SetDeadline(t)
+}
+
+func (c *PacketConn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+func (c *PacketConn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
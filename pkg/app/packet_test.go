@@ -0,0 +1,65 @@
+package app
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestPacketConn(t *testing.T) {
+	a := NewClient(Client, "test", "1.0.0")
+	a.CoalesceDelay = time.Hour
+	local, remote := net.Pipe()
+	client, server := a.WrapPacketConn(local), a.WrapPacketConn(remote)
+	defer client.Close()
+	defer server.Close()
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	server.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err := client.WriteTo(make([]byte, MaxPacketSize+1), nil); err != ErrPacketTooLarge {
+		t.Fatalf("large packet err %v", err)
+	}
+	if _, err := client.WriteTo([]byte("a"), &net.UDPAddr{Port: 1}); err != ErrWriteToOtherPeer {
+		t.Fatalf("write to another peer err %v", err)
+	}
+
+	// net.Pipe is not buffered and the writes are not held back
+	msgs := [][]byte{[]byte("first"), {}, bytes.Repeat([]byte("x"), 300), []byte("truncated")}
+	errs := make(chan error, 1)
+	go func() {
+		for _, m := range msgs {
+			if _, err := client.WriteTo(m, nil); err != nil {
+				errs <- err
+				return
+			}
+		}
+		errs <- nil
+	}()
+	b := make([]byte, 512)
+	for _, m := range msgs[:3] {
+		n, addr, err := server.ReadFrom(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b[:n], m) {
+			t.Fatalf("read %q, want %q", b[:n], m)
+		}
+		if addr.String() != remote.RemoteAddr().String() {
+			t.Fatalf("read from %v", addr)
+		}
+	}
+	// the rest of a packet longer than the buffer is dropped
+	n, _, err := server.ReadFrom(b[:5])
+	if err != nil || string(b[:n]) != "trunc" {
+		t.Fatalf("read %q, err %v", b[:n], err)
+	}
+	if err = <-errs; err != nil {
+		t.Fatal(err)
+	}
+	go client.WriteTo([]byte("next"), nil)
+	n, _, err = server.ReadFrom(b)
+	if err != nil || string(b[:n]) != "next" {
+		t.Fatalf("read %q after a truncated packet, err %v", b[:n], err)
+	}
+}
@@ -0,0 +1,169 @@
+package app
+
+import (
+	"errors"
+	"net"
+	"runtime/debug"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Handler serves a connection the node forwarded to the app, the connection is closed
+// when ServeConn returns
+type Handler interface {
+	ServeConn(conn net.Conn)
+}
+
+// HandlerFunc lets an ordinary function serve the connections
+type HandlerFunc func(conn net.Conn)
+
+func (f HandlerFunc) ServeConn(conn net.Conn) {
+	f(conn)
+}
+
+var (
+	ErrAppClosed     = errors.New("app closed")
+	ErrNoHandlers    = errors.New("no handlers to serve")
+	ErrNoServiceAddr = errors.New("app has no service address")
+)
+
+// Handle serves the connections to the local address addr, such as ":8080", with handler.
+// The address of the service is the one the nodes forward to, the others are for apps
+// offering more ports.
+func (app *App) Handle(addr string, handler Handler) {
+	app.serveMutex.Lock()
+	if app.handlers == nil {
+		app.handlers = make(map[string]Handler)
+	}
+	app.handlers[addr] = handler
+	app.serveMutex.Unlock()
+}
+
+// HandleFunc serves the connections to the local address addr with f
+func (app *App) HandleFunc(addr string, f func(conn net.Conn)) {
+	app.Handle(addr, HandlerFunc(f))
+}
+
+// Serve serves the service address with handler, if not nil, and the addresses registered
+// with Handle, each connection in a goroutine of its own. A panic of a handler is logged
+// and only closes its connection. Serve returns when a listener fails or the app is closed.
+//
+//	a := app.NewServer(app.Public, "echo", ":9000", Version)
+//	if err := a.Start(nodeAddress, seedPath); err != nil {
+//		log.Fatal(err)
+//	}
+//	log.Fatal(a.Serve(app.HandlerFunc(func(conn net.Conn) {
+//		io.Copy(conn, conn)
+//	})))
+func (app *App) Serve(handler Handler) (err error) {
+	if handler != nil {
+		if len(app.serviceAddr) == 0 {
+			return ErrNoServiceAddr
+		}
+		app.Handle(app.serviceAddr, handler)
+	}
+
+	app.serveMutex.Lock()
+	if app.serveClosed {
+		app.serveMutex.Unlock()
+		return ErrAppClosed
+	}
+	if len(app.handlers) == 0 {
+		app.serveMutex.Unlock()
+		return ErrNoHandlers
+	}
+	var listeners []net.Listener
+	errs := make(chan error, len(app.handlers))
+	for addr, h := range app.handlers {
+		var ln net.Listener
+		ln, err = net.Listen("tcp", addr)
+		if err != nil {
+			break
+		}
+		listeners = append(listeners, ln)
+		go func(ln net.Listener, h Handler) {
+			errs <- app.accept(ln, h)
+		}(ln, h)
+	}
+	app.listeners = append(app.listeners, listeners...)
+	app.serveMutex.Unlock()
+	if err == nil {
+		err = <-errs
+	}
+
+	app.serveMutex.Lock()
+	defer app.serveMutex.Unlock()
+	if app.serveClosed {
+		return ErrAppClosed
+	}
+	for _, ln := range listeners {
+		ln.Close()
+	}
+	open := app.listeners[:0]
+	for _, ln := range app.listeners {
+		if !containsListener(listeners, ln) {
+			open = append(open, ln)
+		}
+	}
+	app.listeners = open
+	return
+}
+
+func containsListener(listeners []net.Listener, ln net.Listener) bool {
+	for _, l := range listeners {
+		if l == ln {
+			return true
+		}
+	}
+	return false
+}
+
+func (app *App) accept(ln net.Listener, handler Handler) error {
+	var delay time.Duration
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				// out of files for a while, as net/http does
+				if delay == 0 {
+					delay = 5 * time.Millisecond
+				} else if delay *= 2; delay > time.Second {
+					delay = time.Second
+				}
+				log.Errorf("app accept on %s: %v, retrying in %v", ln.Addr(), err, delay)
+				time.Sleep(delay)
+				continue
+			}
+			return err
+		}
+		delay = 0
+		go serveConn(app.WrapConn(conn), handler)
+	}
+}
+
+func serveConn(conn net.Conn, handler Handler) {
+	defer func() {
+		if e := recover(); e != nil {
+			log.Errorf("app panic serving %s: %v\n%s", conn.RemoteAddr(), e, debug.Stack())
+		}
+		conn.Close()
+	}()
+	handler.ServeConn(conn)
+}
+
+// Close stops serving and cancels the connections being set up, the connections being
+// served are left to their handlers
+func (app *App) Close() (err error) {
+	app.dials.cancelAll()
+	app.serveMutex.Lock()
+	defer app.serveMutex.Unlock()
+	app.serveClosed = true
+	for _, ln := range app.listeners {
+		if e := ln.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	app.listeners = nil
+	return
+}
@@ -0,0 +1,75 @@
+package app
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+)
+
+func freeAddr(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+func dial(t *testing.T, addr string) net.Conn {
+	for i := 0; i < 50; i++ {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			return conn
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("dial %s failed", addr)
+	return nil
+}
+
+func TestServe(t *testing.T) {
+	serviceAddr, otherAddr := freeAddr(t), freeAddr(t)
+	a := NewServer(Public, "test", serviceAddr, "1.0.0")
+	a.HandleFunc(otherAddr, func(conn net.Conn) {
+		conn.Write([]byte("other\n"))
+	})
+	served := make(chan error, 1)
+	go func() {
+		served <- a.Serve(HandlerFunc(func(conn net.Conn) {
+			line, _ := bufio.NewReader(conn).ReadString('\n')
+			if line == "panic\n" {
+				panic("handler panic")
+			}
+			conn.Write([]byte(line))
+		}))
+	}()
+
+	// a panic only closes its connection
+	conn := dial(t, serviceAddr)
+	conn.Write([]byte("panic\n"))
+	if _, err := bufio.NewReader(conn).ReadString('\n'); err == nil {
+		t.Error("connection of the panicking handler left open")
+	}
+	conn.Close()
+
+	for addr, want := range map[string]string{serviceAddr: "echo\n", otherAddr: "other\n"} {
+		conn = dial(t, addr)
+		conn.Write([]byte("echo\n"))
+		got, err := bufio.NewReader(conn).ReadString('\n')
+		conn.Close()
+		if err != nil || got != want {
+			t.Errorf("%s answered %q %v, want %q", addr, got, err, want)
+		}
+	}
+
+	a.Close()
+	select {
+	case err := <-served:
+		if err != ErrAppClosed {
+			t.Errorf("Serve returned %v, want %v", err, ErrAppClosed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve did not return on Close")
+	}
+}
@@ -0,0 +1,157 @@
+package appnet
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/skycoin/skycoin/src/cipher"
+	"github.com/skycoin/skywire/pkg/net/skycoin-messenger/factory"
+)
+
+// listen starts a node for the apps and the app socket connecting to it, stop closes them
+func listen(t *testing.T) (path string, stop func()) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	f := factory.NewMessengerFactory()
+	f.SetDefaultSeedConfig(factory.NewSeedConfig())
+	if err = f.Listen(addr); err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "appnet")
+	if err != nil {
+		f.Close()
+		t.Fatal(err)
+	}
+	path = filepath.Join(dir, "apps.sock")
+	s, err := Listen(path, addr)
+	if err != nil {
+		f.Close()
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	stop = func() {
+		s.Close()
+		f.Close()
+		os.RemoveAll(dir)
+	}
+	return
+}
+
+func dial(t *testing.T, path string) net.Conn {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	return conn
+}
+
+func roundTrip(t *testing.T, conn net.Conn, f *Frame) *Frame {
+	if err := WriteFrame(conn, f); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := ReadFrame(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestRegister(t *testing.T) {
+	path, stop := listen(t)
+	defer stop()
+	conn := dial(t, path)
+	defer conn.Close()
+	if resp := roundTrip(t, conn, &Frame{Op: OpHello, Seq: 1, Version: ProtocolVersion}); resp.Op != OpHello || resp.Version != ProtocolVersion {
+		t.Fatalf("hello answered %+v", resp)
+	}
+	if resp := roundTrip(t, conn, &Frame{Op: OpConnect, Seq: 2}); resp.Op != OpError || resp.Seq != 2 {
+		t.Fatalf("connect before register answered %+v", resp)
+	}
+	resp := roundTrip(t, conn, &Frame{Op: OpRegister, Seq: 3, Service: "echo", Type: TypePublic, Address: ":9000"})
+	if resp.Op != OpRegistered || resp.Seq != 3 {
+		t.Fatalf("register answered %+v", resp)
+	}
+	if _, err := cipher.PubKeyFromHex(resp.Key); err != nil {
+		t.Errorf("registered key %q: %v", resp.Key, err)
+	}
+	if resp = roundTrip(t, conn, &Frame{Op: "jump", Seq: 4}); resp.Op != OpError || resp.Seq != 4 {
+		t.Fatalf("unknown op answered %+v", resp)
+	}
+}
+
+func TestHelloVersion(t *testing.T) {
+	path, stop := listen(t)
+	defer stop()
+	conn := dial(t, path)
+	defer conn.Close()
+	if resp := roundTrip(t, conn, &Frame{Op: OpHello, Version: ProtocolVersion + 1}); resp.Op != OpError {
+		t.Fatalf("hello of a newer version answered %+v", resp)
+	}
+	if _, err := ReadFrame(conn); err == nil {
+		t.Error("socket left open after a failed hello")
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	path, stop := listen(t)
+	defer stop()
+	conn := dial(t, path)
+	defer conn.Close()
+	resp := roundTrip(t, conn, &Frame{Op: OpHello, Version: ProtocolVersion, Ping: 1})
+	if resp.Op != OpHello || resp.Ping != int64(MinPingInterval/time.Millisecond) {
+		t.Fatalf("hello with heartbeats answered %+v", resp)
+	}
+	if resp = roundTrip(t, conn, &Frame{Op: OpPing, Seq: 1}); resp.Op != OpPong && resp.Op != OpPing {
+		t.Fatalf("ping answered %+v", resp)
+	}
+	// pinged by the node while answering
+	start := time.Now()
+	for i := 0; i < 2*PingMisses; i++ {
+		f, err := ReadFrame(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.Op == OpPing {
+			if err = WriteFrame(conn, &Frame{Op: OpPong}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if time.Since(start) < PingMisses*MinPingInterval {
+		t.Fatalf("%d frames in %v", 2*PingMisses, time.Since(start))
+	}
+	// closed once the app stops answering
+	for {
+		if _, err := ReadFrame(conn); err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				t.Fatal("socket left open without heartbeats of the app")
+			}
+			break
+		}
+	}
+}
+
+func TestNoHeartbeat(t *testing.T) {
+	path, stop := listen(t)
+	defer stop()
+	conn := dial(t, path)
+	defer conn.Close()
+	if resp := roundTrip(t, conn, &Frame{Op: OpHello, Version: ProtocolVersion}); resp.Ping != 0 {
+		t.Fatalf("hello without heartbeats answered %+v", resp)
+	}
+	conn.SetReadDeadline(time.Now().Add(PingMisses*MinPingInterval + 200*time.Millisecond))
+	if f, err := ReadFrame(conn); err == nil {
+		t.Fatalf("app without heartbeats got %+v", f)
+	} else if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		t.Fatalf("socket closed without heartbeats: %v", err)
+	}
+}
@@ -0,0 +1,169 @@
+// Package appnet lets apps written in any language use a node over a unix socket.
+//
+// Each frame on the socket is a 4 byte big endian length followed by that many bytes of
+// a JSON object, in both directions. The object always has an Op, the fields of the
+// other ops are left out. The app starts with a hello, registers once and can then ask
+// for connections to other apps; the node answers each request carrying a Seq with a
+// frame carrying the same Seq. The data of the connections does not go over the socket,
+// a server app listens on its Address and a client app on the LocalPort it connects
+// with, as the Go apps do. docs/api/AppSocket.md describes the ops in full.
+//
+// An app asking for heartbeats in its hello is pinged by the node every PingInterval and
+// pings the node as it likes. A side that read nothing from the other for PingMisses
+// intervals takes it for dead: the node closes the socket, drops the connections of the
+// app and the app should do the same.
+//
+// The protocol is stable: fields and ops are only added, an app ignores the ones it
+// does not know and the node answers an unknown op with an error. An incompatible
+// change bumps ProtocolVersion.
+package appnet
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	// ProtocolVersion is sent with the hello of both sides
+	ProtocolVersion = 1
+	// MaxFrameSize is the largest JSON object of a frame
+	MaxFrameSize = 64 << 10
+
+	// the shortest and longest interval of the heartbeats an app may ask for
+	MinPingInterval = 100 * time.Millisecond
+	MaxPingInterval = 10 * time.Minute
+	// heartbeats missed before a side takes the other for dead
+	PingMisses = 3
+)
+
+// Ops of the frames
+const (
+	// app => node, node => app
+	OpHello = "hello"
+	OpPing  = "ping"
+	OpPong  = "pong"
+	// app => node
+	OpRegister = "register"
+	OpConnect  = "connect"
+	OpCancel   = "cancel"
+	// node => app
+	OpRegistered   = "registered"
+	OpConnection   = "connection"
+	OpClosed       = "closed"
+	OpDisconnected = "disconnected"
+	OpError        = "error"
+)
+
+// Types of apps to register
+const (
+	// offered to every node
+	TypePublic = "public"
+	// offered to the nodes in AllowNodes only
+	TypePrivate = "private"
+	// offers nothing, only connects to other apps
+	TypeClient = "client"
+)
+
+// Frame is the JSON object of a frame
+type Frame struct {
+	Op  string
+	Seq uint32 `json:",omitempty"`
+
+	// hello
+	Version int `json:",omitempty"`
+	// hello, the interval of the heartbeats in milliseconds, none if 0. The node answers
+	// with the interval it pings at.
+	Ping int64 `json:",omitempty"`
+
+	// register
+	Service    string   `json:",omitempty"`
+	AppVersion string   `json:",omitempty"`
+	Type       string   `json:",omitempty"`
+	Address    string   `json:",omitempty"`
+	AllowNodes []string `json:",omitempty"`
+	// file on the node keeping the key of the app, a new key every time if empty
+	SeedPath string `json:",omitempty"`
+	// register, the token the connections to the app must present; connect, the token of
+	// the other app
+	Knock string `json:",omitempty"`
+
+	// registered, the hex key of the app; connect, cancel and connection, the hex key of the other app
+	Key string `json:",omitempty"`
+
+	// connect
+	Node      string `json:",omitempty"`
+	Discovery string `json:",omitempty"`
+	LocalPort int    `json:",omitempty"`
+	Critical  bool   `json:",omitempty"`
+	Private   bool   `json:",omitempty"`
+
+	// connection, the app dials Host:Port to reach the other app; cancel, the connection to
+	// give up, all connections to the app being set up if empty
+	Host    string `json:",omitempty"`
+	Port    int    `json:",omitempty"`
+	Failed  bool   `json:",omitempty"`
+	SetupID string `json:",omitempty"`
+
+	// closed, the addresses of the connection as the app sees them and why it was closed
+	Local  string `json:",omitempty"`
+	Remote string `json:",omitempty"`
+	Reason string `json:",omitempty"`
+
+	// connection, disconnected and error
+	Error string `json:",omitempty"`
+}
+
+// ReadFrame reads the next frame of r
+func ReadFrame(r io.Reader) (f *Frame, err error) {
+	var size [4]byte
+	if _, err = io.ReadFull(r, size[:]); err != nil {
+		return
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n > MaxFrameSize {
+		err = fmt.Errorf("frame of %d bytes larger than %d", n, MaxFrameSize)
+		return
+	}
+	b := make([]byte, n)
+	if _, err = io.ReadFull(r, b); err != nil {
+		return
+	}
+	f = new(Frame)
+	err = json.Unmarshal(b, f)
+	return
+}
+
+// PingInterval returns the interval of the heartbeats asked for by a hello within
+// MinPingInterval and MaxPingInterval, 0 for none
+func (f *Frame) PingInterval() time.Duration {
+	if f.Ping <= 0 {
+		return 0
+	}
+	if f.Ping > int64(MaxPingInterval/time.Millisecond) {
+		return MaxPingInterval
+	}
+	d := time.Duration(f.Ping) * time.Millisecond
+	if d < MinPingInterval {
+		return MinPingInterval
+	}
+	return d
+}
+
+// WriteFrame writes f to w in one write
+func WriteFrame(w io.Writer, f *Frame) (err error) {
+	b, err := json.Marshal(f)
+	if err != nil {
+		return
+	}
+	if len(b) > MaxFrameSize {
+		return fmt.Errorf("frame of %d bytes larger than %d", len(b), MaxFrameSize)
+	}
+	buf := make([]byte, 4+len(b))
+	binary.BigEndian.PutUint32(buf, uint32(len(b)))
+	copy(buf[4:], b)
+	_, err = w.Write(buf)
+	return
+}
@@ -0,0 +1,365 @@
+package appnet
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/skycoin/skycoin/src/cipher"
+	"github.com/skycoin/skywire/pkg/net/skycoin-messenger/factory"
+	"github.com/skycoin/skywire/pkg/net/util"
+)
+
+var errClosed = errors.New("app socket closed")
+
+// Server serves the apps on a unix socket, each app gets a connection of its own to the node
+type Server struct {
+	ln          net.Listener
+	nodeAddress string
+
+	mutex    sync.Mutex
+	sessions map[*session]struct{}
+	closed   bool
+}
+
+// Listen serves the apps on the unix socket at path, which only the user of the node may
+// use, and connects them to the node listening on nodeAddress
+func Listen(path, nodeAddress string) (s *Server, err error) {
+	// a socket left by a node that did not close it
+	if fi, e := os.Lstat(path); e == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return
+	}
+	if err = os.Chmod(path, 0600); err != nil {
+		ln.Close()
+		return
+	}
+	s = &Server{
+		ln:          ln,
+		nodeAddress: nodeAddress,
+		sessions:    make(map[*session]struct{}),
+	}
+	go s.serve()
+	return
+}
+
+func (s *Server) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			s.mutex.Lock()
+			closed := s.closed
+			s.mutex.Unlock()
+			if !closed {
+				log.Errorf("app socket accept err: %v", err)
+			}
+			return
+		}
+		err = util.ReserveFDs(0)
+		if err != nil {
+			log.Errorf("app socket connection refused: %v", err)
+			conn.Close()
+			continue
+		}
+		ss := &session{
+			conn:        conn,
+			nodeAddress: s.nodeAddress,
+			connects:    make(map[cipher.PubKey]*connect),
+		}
+		s.mutex.Lock()
+		if s.closed {
+			s.mutex.Unlock()
+			conn.Close()
+			return
+		}
+		s.sessions[ss] = struct{}{}
+		s.mutex.Unlock()
+		go func() {
+			ss.run()
+			s.mutex.Lock()
+			delete(s.sessions, ss)
+			s.mutex.Unlock()
+		}()
+	}
+}
+
+// Close stops serving and disconnects the apps
+func (s *Server) Close() (err error) {
+	s.mutex.Lock()
+	s.closed = true
+	sessions := s.sessions
+	s.sessions = make(map[*session]struct{})
+	s.mutex.Unlock()
+	err = s.ln.Close()
+	for ss := range sessions {
+		ss.conn.Close()
+	}
+	return
+}
+
+// the connects to an app waiting for their answers, and the port the app connects with
+type connect struct {
+	seqs      []uint32
+	localPort int
+}
+
+type session struct {
+	conn        net.Conn
+	nodeAddress string
+	writeMutex  sync.Mutex
+
+	// set when the app registered
+	factory *factory.MessengerFactory
+	node    *factory.Connection
+	mutex   sync.Mutex
+
+	connects map[cipher.PubKey]*connect
+
+	// of the heartbeats, 0 for none
+	pingInterval time.Duration
+	done         chan struct{}
+}
+
+func (s *session) write(f *Frame) {
+	s.writeMutex.Lock()
+	err := WriteFrame(s.conn, f)
+	s.writeMutex.Unlock()
+	if err != nil {
+		log.Debugf("app socket write %s err: %v", f.Op, err)
+	}
+}
+
+func (s *session) fail(seq uint32, err error) {
+	s.write(&Frame{Op: OpError, Seq: seq, Error: err.Error()})
+}
+
+func (s *session) run() {
+	defer func() {
+		s.conn.Close()
+		if s.factory != nil {
+			s.factory.Close()
+		}
+	}()
+	f, err := ReadFrame(s.conn)
+	if err != nil {
+		return
+	}
+	if f.Op != OpHello {
+		s.fail(f.Seq, fmt.Errorf("%s before %s", f.Op, OpHello))
+		return
+	}
+	if f.Version != ProtocolVersion {
+		s.fail(f.Seq, fmt.Errorf("protocol version %d not supported, the node speaks %d", f.Version, ProtocolVersion))
+		return
+	}
+	s.pingInterval = f.PingInterval()
+	hello := &Frame{Op: OpHello, Seq: f.Seq, Version: ProtocolVersion}
+	if s.pingInterval > 0 {
+		hello.Ping = int64(s.pingInterval / time.Millisecond)
+		s.done = make(chan struct{})
+		defer close(s.done)
+	}
+	s.write(hello)
+	if s.pingInterval > 0 {
+		go s.ping()
+	}
+
+	for {
+		if s.pingInterval > 0 {
+			s.conn.SetReadDeadline(time.Now().Add(PingMisses * s.pingInterval))
+		}
+		f, err = ReadFrame(s.conn)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				log.Infof("app socket: no heartbeat of the app for %v, closing", PingMisses*s.pingInterval)
+			}
+			return
+		}
+		switch f.Op {
+		case OpPing:
+			s.write(&Frame{Op: OpPong, Seq: f.Seq})
+		case OpPong:
+		case OpRegister:
+			err = s.register(f)
+		case OpConnect:
+			err = s.connect(f)
+		case OpCancel:
+			err = s.cancel(f)
+		default:
+			err = fmt.Errorf("unknown op %q", f.Op)
+		}
+		if err != nil {
+			s.fail(f.Seq, err)
+		}
+	}
+}
+
+// ping sends the heartbeats of the node until the session ends
+func (s *session) ping() {
+	ticker := time.NewTicker(s.pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.write(&Frame{Op: OpPing})
+		}
+	}
+}
+
+func (s *session) register(f *Frame) (err error) {
+	if s.factory != nil {
+		return errors.New("app already registered")
+	}
+	switch f.Type {
+	case TypePublic, TypePrivate, TypeClient:
+	default:
+		return fmt.Errorf("unknown app type %q", f.Type)
+	}
+	config := &factory.ConnConfig{
+		SeedConfigPath: f.SeedPath,
+		OnConnected: func(conn *factory.Connection) {
+			s.mutex.Lock()
+			s.node = conn
+			s.mutex.Unlock()
+			var err error
+			if len(f.Knock) > 0 && f.Type != TypeClient {
+				var allowNodes []string
+				if f.Type == TypePrivate {
+					allowNodes = f.AllowNodes
+				}
+				err = conn.OfferKnockedServiceWithAddress(f.Address, f.AppVersion, []byte(f.Knock), allowNodes, f.Service)
+			} else if f.Type == TypePublic {
+				err = conn.OfferServiceWithAddress(f.Address, f.AppVersion, f.Service)
+			} else {
+				err = conn.OfferPrivateServiceWithAddress(f.Address, f.AppVersion, f.AllowNodes, f.Service)
+			}
+			if err != nil {
+				s.fail(f.Seq, err)
+				return
+			}
+			s.write(&Frame{Op: OpRegistered, Seq: f.Seq, Key: conn.GetKey().Hex()})
+		},
+		OnDisconnected: func(conn *factory.Connection) {
+			s.write(&Frame{Op: OpDisconnected, Error: "disconnected from the node"})
+			s.conn.Close()
+		},
+		AppConnectionInitCallback: s.connection,
+		AppConnClosedCallback:     s.closed,
+	}
+	if len(f.SeedPath) == 0 {
+		config.SeedConfig = factory.NewSeedConfig()
+	}
+	s.factory = factory.NewMessengerFactory()
+	return s.factory.ConnectWithConfig(s.nodeAddress, config)
+}
+
+func (s *session) connect(f *Frame) (err error) {
+	s.mutex.Lock()
+	node := s.node
+	s.mutex.Unlock()
+	if node == nil {
+		return errors.New("app not registered")
+	}
+	nodeKey, err := cipher.PubKeyFromHex(f.Node)
+	if err != nil {
+		return
+	}
+	appKey, err := cipher.PubKeyFromHex(f.Key)
+	if err != nil {
+		return
+	}
+	var discoveryKey cipher.PubKey
+	if len(f.Discovery) > 0 {
+		discoveryKey, err = cipher.PubKeyFromHex(f.Discovery)
+		if err != nil {
+			return
+		}
+	}
+	s.mutex.Lock()
+	c, ok := s.connects[appKey]
+	if !ok {
+		c = &connect{}
+		s.connects[appKey] = c
+	}
+	c.seqs = append(c.seqs, f.Seq)
+	c.localPort = f.LocalPort
+	s.mutex.Unlock()
+	return node.BuildAppConnectionWithOptions(nodeKey, appKey, discoveryKey, factory.AppDialOptions{
+		Critical: f.Critical,
+		Private:  f.Private,
+		Knock:    []byte(f.Knock),
+	})
+}
+
+// cancel gives up the connections to the app being set up, their connects are answered
+// with a failed connection
+func (s *session) cancel(f *Frame) (err error) {
+	s.mutex.Lock()
+	node := s.node
+	s.mutex.Unlock()
+	if node == nil {
+		return errors.New("app not registered")
+	}
+	appKey, err := cipher.PubKeyFromHex(f.Key)
+	if err != nil {
+		return
+	}
+	return node.CancelAppConnection(appKey, f.SetupID)
+}
+
+// connection passes the answer to a connect on to the app, a standby taking over answers
+// again without a connect
+func (s *session) connection(resp *factory.AppConnResp) *factory.AppFeedback {
+	var seq uint32
+	var port int
+	s.mutex.Lock()
+	if c, ok := s.connects[resp.App]; ok {
+		if len(c.seqs) > 0 {
+			seq = c.seqs[0]
+			c.seqs = c.seqs[1:]
+		}
+		port = c.localPort
+	}
+	s.mutex.Unlock()
+	f := &Frame{
+		Op:      OpConnection,
+		Seq:     seq,
+		Key:     resp.App.Hex(),
+		Host:    resp.Host,
+		Port:    resp.Port,
+		Failed:  resp.Failed,
+		SetupID: resp.SetupID,
+	}
+	if resp.Discovery != (cipher.PubKey{}) {
+		f.Discovery = resp.Discovery.Hex()
+	}
+	if resp.Failed {
+		f.Error = resp.Msg.Msg
+	}
+	s.write(f)
+	return &factory.AppFeedback{
+		Port:   port,
+		Failed: resp.Failed,
+		Msg:    resp.Msg,
+	}
+}
+
+// closed tells the app why the node closed a connection to another app
+func (s *session) closed(closed *factory.AppConnClosed) {
+	s.write(&Frame{
+		Op:     OpClosed,
+		Key:    closed.App.Hex(),
+		Local:  closed.Local,
+		Remote: closed.Remote,
+		Reason: closed.Reason.String(),
+	})
+}
@@ -0,0 +1,332 @@
+//go:build compat
+// +build compat
+
+package compat
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/skycoin/skycoin/src/cipher"
+	"github.com/skycoin/skywire/pkg/net/skycoin-messenger/factory"
+)
+
+const previousEnv = "SKYWIRE_COMPAT_PREVIOUS"
+
+var packages = map[string]string{
+	"skywire-manager": "github.com/skycoin/skywire/cmd/skywire-manager",
+	"skywire-node":    "github.com/skycoin/skywire/cmd/skywire-node",
+}
+
+// the environment turns off the checks of the current node that need the internet,
+// older nodes ignore it
+var nodeEnv = []string{
+	"SKYWIRE_NODE_NAT_DETECT=false",
+	"SKYWIRE_NODE_CLOCK_CHECK=false",
+	"SKYWIRE_NODE_WATCHDOG=false",
+}
+
+const (
+	// the connection is requested again after this
+	connectTimeout = 10 * time.Second
+	interopTimeout = 60 * time.Second
+	payload        = "skywire compat"
+)
+
+type release struct {
+	name string
+	dir  string
+}
+
+// cleanups are run in reverse order when the test returns
+type cleanups []func()
+
+func (c *cleanups) add(f func()) {
+	*c = append(*c, f)
+}
+
+func (c *cleanups) run() {
+	for i := len(*c) - 1; i >= 0; i-- {
+		(*c)[i]()
+	}
+}
+
+// TestInterop builds a transport between the apps of two nodes through a
+// discovery for every mix of the previous release and the current tree, and
+// echoes a payload through it. The apps are of the current tree.
+func TestInterop(t *testing.T) {
+	dir := os.Getenv(previousEnv)
+	if len(dir) == 0 {
+		t.Skipf("%s is not set to the directory of the binaries of the previous release", previousEnv)
+	}
+	for name := range packages {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Fatalf("previous release: %v", err)
+		}
+	}
+	previous := release{name: "previous", dir: dir}
+	current := release{name: "current", dir: buildCurrent(t)}
+	defer os.RemoveAll(current.dir)
+
+	releases := []release{previous, current}
+	for _, discovery := range releases {
+		for _, a := range releases {
+			for _, b := range releases {
+				discovery, a, b := discovery, a, b
+				name := fmt.Sprintf("discovery=%s/a=%s/b=%s", discovery.name, a.name, b.name)
+				t.Run(name, func(t *testing.T) {
+					testInterop(t, discovery, a, b)
+				})
+			}
+		}
+	}
+}
+
+func buildCurrent(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "skywire-compat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, pkg := range packages {
+		out, err := exec.Command("go", "build", "-o", filepath.Join(dir, name), pkg).CombinedOutput()
+		if err != nil {
+			os.RemoveAll(dir)
+			t.Fatalf("build %s: %v\n%s", name, err, out)
+		}
+	}
+	return dir
+}
+
+func testInterop(t *testing.T, discovery, a, b release) {
+	var c cleanups
+	defer c.run()
+	home, err := ioutil.TempDir("", "skywire-compat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.add(func() { os.RemoveAll(home) })
+	echo := startEcho(t, &c)
+
+	discoveryAddr := freeAddr(t)
+	discoverySeed := filepath.Join(home, "discovery", "keys.json")
+	start(t, &c, discovery, filepath.Join(home, "discovery"), "skywire-manager", nil,
+		"-address", discoveryAddr,
+		"-web-port", freeAddr(t),
+		"-seed-path", discoverySeed)
+	discoveryKey := waitKey(t, discoverySeed)
+
+	nodeB, appsB := startNode(t, &c, b, filepath.Join(home, "b"), discoveryAddr+"-"+discoveryKey.Hex())
+	nodeA, appsA := startNode(t, &c, a, filepath.Join(home, "a"), discoveryAddr+"-"+discoveryKey.Hex())
+
+	server := connectApp(t, &c, appsB, filepath.Join(home, "server.json"), nil)
+	err = server.OfferServiceWithAddress(echo, "compat", "compat")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resps := make(chan factory.AppConnResp, 1)
+	client := connectApp(t, &c, appsA, filepath.Join(home, "client.json"), func(resp *factory.AppConnResp) *factory.AppFeedback {
+		select {
+		case resps <- *resp:
+		default:
+		}
+		return &factory.AppFeedback{Port: resp.Port, Failed: resp.Failed, Msg: resp.Msg}
+	})
+
+	deadline := time.Now().Add(interopTimeout)
+	for time.Now().Before(deadline) {
+		err = client.BuildAppConnection(nodeB, server.GetKey(), discoveryKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case resp := <-resps:
+			if resp.Failed {
+				t.Logf("connection from node %s failed: %s", nodeA.Hex(), resp.Msg.Msg)
+				time.Sleep(time.Second)
+				continue
+			}
+			err = echoThrough(net.JoinHostPort(resp.Host, strconv.Itoa(resp.Port)))
+			if err == nil {
+				return
+			}
+			t.Logf("echo from node %s: %v", nodeA.Hex(), err)
+		case <-time.After(connectTimeout):
+			t.Logf("no answer to the connection from node %s", nodeA.Hex())
+		}
+	}
+	t.Fatalf("no transport from node %s to node %s within %s", nodeA.Hex(), nodeB.Hex(), interopTimeout)
+}
+
+// connectApp registers an app of the current tree with a node, the way pkg/app does
+// but without exiting when the node goes away
+func connectApp(t *testing.T, c *cleanups, node, seedPath string, callback func(*factory.AppConnResp) *factory.AppFeedback) *factory.Connection {
+	f := factory.NewMessengerFactory()
+	c.add(func() {
+		f.Close()
+	})
+	connected := make(chan *factory.Connection, 1)
+	err := f.ConnectWithConfig(node, &factory.ConnConfig{
+		SeedConfigPath: seedPath,
+		OnConnected: func(connection *factory.Connection) {
+			connected <- connection
+		},
+		AppConnectionInitCallback: callback,
+	})
+	if err != nil {
+		t.Fatalf("connect app to %s: %v", node, err)
+	}
+	select {
+	case conn := <-connected:
+		return conn
+	case <-time.After(connectTimeout):
+		t.Fatalf("app not registered with node %s", node)
+	}
+	return nil
+}
+
+func startEcho(t *testing.T, c *cleanups) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.add(func() {
+		ln.Close()
+	})
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// echoThrough sends the payload to the port node A opened for the app and reads it back
+func echoThrough(addr string) (err error) {
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	_, err = io.WriteString(conn, payload)
+	if err != nil {
+		return
+	}
+	got := make([]byte, len(payload))
+	_, err = io.ReadFull(conn, got)
+	if err != nil {
+		return
+	}
+	if string(got) != payload {
+		return fmt.Errorf("got %q", got)
+	}
+	return
+}
+
+// startNode returns the key of the node and the address its apps connect to
+func startNode(t *testing.T, c *cleanups, r release, home, discovery string) (key cipher.PubKey, apps string) {
+	apps = freeAddr(t)
+	seed := filepath.Join(home, "keys.json")
+	start(t, c, r, home, "skywire-node", nodeEnv,
+		"-address", apps,
+		"-discovery-address", discovery,
+		"-connect-manager=false",
+		"-seed-path", seed,
+		"-web-port", freeAddr(t),
+		"-auto-start-path", filepath.Join(home, "autoStart.json"),
+		"-conf", filepath.Join(home, "conf.json"))
+	key = waitKey(t, seed)
+	return
+}
+
+// start runs a binary of the release with home as $HOME, its output is logged if the test fails
+func start(t *testing.T, c *cleanups, r release, home, name string, env []string, args ...string) *exec.Cmd {
+	err := os.MkdirAll(home, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logPath := filepath.Join(home, name+".log")
+	log, err := os.Create(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(filepath.Join(r.dir, name), args...)
+	cmd.Env = append(append(os.Environ(), "HOME="+home), env...)
+	cmd.Stdout = log
+	cmd.Stderr = log
+	err = cmd.Start()
+	if err != nil {
+		log.Close()
+		t.Fatalf("start %s of %s: %v", name, r.name, err)
+	}
+	c.add(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+		log.Close()
+		if t.Failed() {
+			out, _ := ioutil.ReadFile(logPath)
+			t.Logf("%s of %s:\n%s", name, r.name, tail(out, 40))
+		}
+	})
+	return cmd
+}
+
+func waitKey(t *testing.T, seedPath string) cipher.PubKey {
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		sc, err := factory.ReadSeedConfig(seedPath)
+		if err == nil {
+			key, err := cipher.PubKeyFromHex(sc.PublicKey)
+			if err != nil {
+				t.Fatalf("keys at %s: %v", seedPath, err)
+			}
+			return key
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no keys at %s: %v", seedPath, err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func freeAddr(t *testing.T) string {
+	return "127.0.0.1:" + strconv.Itoa(freePort(t))
+}
+
+func freePort(t *testing.T) int {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func tail(out []byte, lines int) []byte {
+	for i := len(out) - 1; i >= 0; i-- {
+		if out[i] == '\n' {
+			lines--
+			if lines < 0 {
+				return out[i+1:]
+			}
+		}
+	}
+	return bytes.TrimSpace(out)
+}
@@ -0,0 +1,7 @@
+// Package compat holds the tests of the interop between the previous release
+// and the current tree, run with
+//
+//	SKYWIRE_COMPAT_PREVIOUS=<dir of the released binaries> go test -tags=compat ./pkg/compat
+//
+// The directory holds skywire-manager and skywire-node of the previous release.
+package compat
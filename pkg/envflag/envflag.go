@@ -0,0 +1,52 @@
+// Package envflag sets command line flags from environment variables,
+// e.g. the flag "manager-address" from SKYWIRE_NODE_MANAGER_ADDRESS with the prefix "SKYWIRE_NODE".
+// Flags given on the command line override the environment, list flags add to it.
+package envflag
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Name returns the environment variable of the flag (name)
+func Name(prefix, name string) string {
+	return prefix + "_" + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+// Parse sets the flags of fs from the environment and then parses args,
+// the values of the flags in lists are split by commas and set one by one
+func Parse(fs *flag.FlagSet, prefix string, args []string, lists ...string) (err error) {
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil {
+			return
+		}
+		v, ok := os.LookupEnv(Name(prefix, f.Name))
+		if !ok {
+			return
+		}
+		values := []string{v}
+		for _, l := range lists {
+			if l == f.Name {
+				values = strings.Split(v, ",")
+				break
+			}
+		}
+		for _, value := range values {
+			value = strings.TrimSpace(value)
+			if len(value) == 0 {
+				continue
+			}
+			if e := fs.Set(f.Name, value); e != nil {
+				err = fmt.Errorf("invalid value %q for %s: %v", value, Name(prefix, f.Name), e)
+				return
+			}
+		}
+	})
+	if err != nil {
+		return
+	}
+	err = fs.Parse(args)
+	return
+}
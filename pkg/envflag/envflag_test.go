@@ -0,0 +1,58 @@
+package envflag
+
+import (
+	"flag"
+	"os"
+	"strings"
+	"testing"
+)
+
+type list []string
+
+func (l *list) String() string     { return strings.Join(*l, ",") }
+func (l *list) Set(v string) error { *l = append(*l, v); return nil }
+
+func TestParse(t *testing.T) {
+	os.Setenv("TEST_WEB_PORT", ":7001")
+	os.Setenv("TEST_SEED", "false")
+	os.Setenv("TEST_DISCOVERY_ADDRESS", "a:5999-key1, b:5999-key2")
+	defer func() {
+		os.Unsetenv("TEST_WEB_PORT")
+		os.Unsetenv("TEST_SEED")
+		os.Unsetenv("TEST_DISCOVERY_ADDRESS")
+	}()
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	webPort := fs.String("web-port", ":6001", "")
+	seed := fs.Bool("seed", true, "")
+	address := fs.String("address", ":5000", "")
+	var discoveries list
+	fs.Var(&discoveries, "discovery-address", "")
+
+	err := Parse(fs, "TEST", []string{"-address", ":5001"}, "discovery-address")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *webPort != ":7001" {
+		t.Errorf("web-port = %s", *webPort)
+	}
+	if *seed {
+		t.Error("seed should be false")
+	}
+	if *address != ":5001" {
+		t.Errorf("address = %s", *address)
+	}
+	if len(discoveries) != 2 || discoveries[1] != "b:5999-key2" {
+		t.Errorf("discovery-address = %v", discoveries)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	os.Setenv("TEST_SEED", "maybe")
+	defer os.Unsetenv("TEST_SEED")
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Bool("seed", true, "")
+	if err := Parse(fs, "TEST", nil); err == nil {
+		t.Error("expected an error for an invalid bool")
+	}
+}
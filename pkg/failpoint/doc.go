@@ -0,0 +1,13 @@
+// Package failpoint makes error paths fail on purpose, so tests and QA can check the
+// rollback and cleanup after a failure. The failpoints are compiled in with
+//
+//	go build -tags=failpoints ./cmd/...
+//
+// and turned on by name in SKYWIRE_FAILPOINTS or with Enable, e.g.
+//
+//	SKYWIRE_FAILPOINTS='factory/handshake-complete=return(refused);factory/discovery-register=2*return'
+//
+// An action is return or return(message) to fail with an error, sleep(duration) to delay,
+// panic, or off. With a count prefix, N*action, the action runs N times and the failpoint
+// turns off. Without the tag Inject always returns nil and costs nothing.
+package failpoint
@@ -0,0 +1,148 @@
+//go:build failpoints
+// +build failpoints
+
+package failpoint
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Enabled tells if the failpoints are compiled in
+const Enabled = true
+
+const envName = "SKYWIRE_FAILPOINTS"
+
+// Error is returned by Inject for a failpoint with the return action
+type Error struct {
+	Name    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("failpoint %s: %s", e.Name, e.Message)
+}
+
+type point struct {
+	kind    string
+	message string
+	delay   time.Duration
+	// runs left, 0 for always
+	count int
+}
+
+var points = struct {
+	m map[string]*point
+	sync.Mutex
+}{m: make(map[string]*point)}
+
+func init() {
+	for _, v := range strings.Split(os.Getenv(envName), ";") {
+		v = strings.TrimSpace(v)
+		if len(v) == 0 {
+			continue
+		}
+		kv := strings.SplitN(v, "=", 2)
+		if len(kv) != 2 {
+			log.Errorf("%s: %q is not name=action", envName, v)
+			continue
+		}
+		err := Enable(kv[0], kv[1])
+		if err != nil {
+			log.Errorf("%s: %v", envName, err)
+		}
+	}
+}
+
+func parse(action string) (p *point, err error) {
+	p = &point{}
+	if i := strings.Index(action, "*"); i > 0 {
+		p.count, err = strconv.Atoi(action[:i])
+		if err != nil || p.count <= 0 {
+			err = fmt.Errorf("invalid count in %q", action)
+			return
+		}
+		action = action[i+1:]
+	}
+	p.kind = action
+	var arg string
+	if i := strings.Index(action, "("); i > 0 && strings.HasSuffix(action, ")") {
+		p.kind, arg = action[:i], action[i+1:len(action)-1]
+	}
+	switch p.kind {
+	case "return":
+		p.message = arg
+		if len(p.message) == 0 {
+			p.message = "injected error"
+		}
+	case "sleep":
+		p.delay, err = time.ParseDuration(arg)
+	case "panic", "off":
+	default:
+		err = fmt.Errorf("unknown action %q", action)
+	}
+	return
+}
+
+// Enable sets the action of the failpoint name
+func Enable(name, action string) (err error) {
+	name = strings.TrimSpace(name)
+	if len(name) == 0 {
+		return errors.New("failpoint without a name")
+	}
+	p, err := parse(strings.TrimSpace(action))
+	if err != nil {
+		return
+	}
+	points.Lock()
+	defer points.Unlock()
+	if p.kind == "off" {
+		delete(points.m, name)
+		return
+	}
+	points.m[name] = p
+	log.Warnf("failpoint %s enabled: %s", name, action)
+	return
+}
+
+// Disable turns the failpoint name off
+func Disable(name string) {
+	points.Lock()
+	delete(points.m, name)
+	points.Unlock()
+}
+
+// Inject runs the action of the failpoint name, the error is returned by the failpoints
+// with the return action
+func Inject(name string) error {
+	points.Lock()
+	p, ok := points.m[name]
+	if !ok {
+		points.Unlock()
+		return nil
+	}
+	if p.count > 0 {
+		p.count--
+		if p.count == 0 {
+			delete(points.m, name)
+		}
+	}
+	points.Unlock()
+
+	switch p.kind {
+	case "return":
+		return &Error{Name: name, Message: p.message}
+	case "sleep":
+		time.Sleep(p.delay)
+	case "panic":
+		panic("failpoint " + name)
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+//go:build !failpoints
+// +build !failpoints
+
+package failpoint
+
+import "errors"
+
+// Enabled tells if the failpoints are compiled in
+const Enabled = false
+
+var errNotCompiled = errors.New("failpoints not compiled in, build with -tags=failpoints")
+
+// Inject returns the error of the failpoint name, always nil without the failpoints tag
+func Inject(name string) error {
+	return nil
+}
+
+// Enable fails without the failpoints tag
+func Enable(name, action string) error {
+	return errNotCompiled
+}
+
+// Disable turns the failpoint name off
+func Disable(name string) {
+}
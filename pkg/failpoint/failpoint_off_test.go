@@ -0,0 +1,15 @@
+//go:build !failpoints
+// +build !failpoints
+
+package failpoint
+
+import "testing"
+
+func TestFailpointOff(t *testing.T) {
+	if Enable("test/return", "return") == nil {
+		t.Fatal("enabled without the failpoints tag")
+	}
+	if err := Inject("test/return"); err != nil {
+		t.Fatal(err)
+	}
+}
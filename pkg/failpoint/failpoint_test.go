@@ -0,0 +1,64 @@
+//go:build failpoints
+// +build failpoints
+
+package failpoint
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFailpoint(t *testing.T) {
+	if err := Inject("test/none"); err != nil {
+		t.Fatal(err)
+	}
+
+	err := Enable("test/return", "return(refused)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		err = Inject("test/return")
+		if e, ok := err.(*Error); !ok || e.Message != "refused" {
+			t.Fatalf("inject err %v", err)
+		}
+	}
+	Disable("test/return")
+	if err = Inject("test/return"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the count turns the failpoint off
+	Enable("test/count", "2*return")
+	for i, fail := range []bool{true, true, false} {
+		if err = Inject("test/count"); (err != nil) != fail {
+			t.Fatalf("inject %d err %v", i, err)
+		}
+	}
+
+	Enable("test/sleep", "sleep(20ms)")
+	start := time.Now()
+	if err = Inject("test/sleep"); err != nil || time.Since(start) < 20*time.Millisecond {
+		t.Fatalf("sleep err %v after %s", err, time.Since(start))
+	}
+	Enable("test/sleep", "off")
+	if err = Inject("test/sleep"); err != nil {
+		t.Fatal(err)
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("no panic")
+			}
+		}()
+		Enable("test/panic", "1*panic")
+		Inject("test/panic")
+	}()
+
+	for _, action := range []string{"fail", "0*return", "sleep(soon)"} {
+		if Enable("test/invalid", action) == nil {
+			t.Fatalf("action %s enabled", action)
+		}
+	}
+}
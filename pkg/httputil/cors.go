@@ -0,0 +1,92 @@
+package httputil
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORS lets the pages of other origins, like dashboards, call an api from the browser. The
+// browsers send no cookies to other origins, their requests authenticate with tokens
+type CORS struct {
+	// the allowed origins, like https://dashboard.example.com, "*" allows any
+	Origins []string
+	// the methods and request headers allowed, GET, POST and the headers of the skywire apis
+	// when empty
+	Methods []string
+	Headers []string
+	// how long the browsers cache the answer to a preflight request
+	MaxAge time.Duration
+}
+
+var (
+	defaultCORSMethods = []string{http.MethodGet, http.MethodPost}
+	defaultCORSHeaders = []string{"Authorization", "Content-Type", "X-XSRF-TOKEN"}
+)
+
+// ParseCORS returns the CORS of the comma separated origins, nil for none
+func ParseCORS(origins string) *CORS {
+	var list []string
+	for _, o := range strings.Split(origins, ",") {
+		o = strings.TrimSpace(o)
+		if len(o) > 0 {
+			list = append(list, strings.TrimSuffix(o, "/"))
+		}
+	}
+	if len(list) == 0 {
+		return nil
+	}
+	return &CORS{Origins: list, MaxAge: 10 * time.Minute}
+}
+
+func (c *CORS) allows(origin string) bool {
+	for _, o := range c.Origins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// Handler adds the CORS headers to the answers of h to the allowed origins and answers their
+// preflight requests. The requests of other origins are passed on without them, so the
+// browsers keep the answers from their pages. A nil CORS returns h
+func (c *CORS) Handler(h http.Handler) http.Handler {
+	if c == nil {
+		return h
+	}
+	methods := c.Methods
+	if len(methods) == 0 {
+		methods = defaultCORSMethods
+	}
+	headers := c.Headers
+	if len(headers) == 0 {
+		headers = defaultCORSHeaders
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if len(origin) == 0 {
+			h.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+		if !c.allows(origin) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		if r.Method != http.MethodOptions || len(r.Header.Get("Access-Control-Request-Method")) == 0 {
+			h.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+		w.Header().Add("Vary", "Access-Control-Request-Headers")
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+		if c.MaxAge > 0 {
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(c.MaxAge/time.Second)))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,62 @@
+package httputil
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCORS(t *testing.T) {
+	if ParseCORS(" , ") != nil {
+		t.Fatal("cors without origins")
+	}
+	c := ParseCORS("https://dash.example.com/, http://localhost:4200")
+	h := c.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Method))
+	}))
+
+	for _, v := range []struct {
+		method, origin, requestMethod string
+		status                        int
+		allowOrigin, body             string
+	}{
+		{"GET", "", "", 200, "", "GET"},
+		{"GET", "https://dash.example.com", "", 200, "https://dash.example.com", "GET"},
+		{"POST", "http://localhost:4200", "", 200, "http://localhost:4200", "POST"},
+		{"GET", "https://evil.example.com", "", 200, "", "GET"},
+		{"OPTIONS", "https://dash.example.com", "POST", 204, "https://dash.example.com", ""},
+		{"OPTIONS", "https://evil.example.com", "POST", 200, "", "OPTIONS"},
+		{"OPTIONS", "https://dash.example.com", "", 200, "https://dash.example.com", "OPTIONS"},
+	} {
+		r := httptest.NewRequest(v.method, "/conn/getAll", nil)
+		if len(v.origin) > 0 {
+			r.Header.Set("Origin", v.origin)
+		}
+		if len(v.requestMethod) > 0 {
+			r.Header.Set("Access-Control-Request-Method", v.requestMethod)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != v.status || w.Header().Get("Access-Control-Allow-Origin") != v.allowOrigin || w.Body.String() != v.body {
+			t.Errorf("%s from %q: %d %q %q, want %d %q %q", v.method, v.origin, w.Code,
+				w.Header().Get("Access-Control-Allow-Origin"), w.Body, v.status, v.allowOrigin, v.body)
+		}
+		if len(v.origin) > 0 && w.Header().Get("Vary") != "Origin" {
+			t.Errorf("%s from %q: vary %q", v.method, v.origin, w.Header()["Vary"])
+		}
+		if v.status == 204 && (w.Header().Get("Access-Control-Allow-Methods") != "GET, POST" ||
+			w.Header().Get("Access-Control-Allow-Headers") != "Authorization, Content-Type, X-XSRF-TOKEN" ||
+			w.Header().Get("Access-Control-Max-Age") != "600") {
+			t.Errorf("preflight headers %v", w.Header())
+		}
+	}
+
+	any := (&CORS{Origins: []string{"*"}}).Handler(http.NotFoundHandler())
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Origin", "https://other.example.com")
+	w := httptest.NewRecorder()
+	any.ServeHTTP(w, r)
+	if w.Header().Get("Access-Control-Allow-Origin") != "https://other.example.com" {
+		t.Errorf("any origin %v", w.Header())
+	}
+}
@@ -0,0 +1,153 @@
+package httputil
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// the codes of the errors of the skywire apis, a client branches on them instead of the message
+const (
+	CodeBadRequest       = "bad_request"
+	CodeUnauthorized     = "unauthorized"
+	CodeForbidden        = "forbidden"
+	CodeNotFound         = "not_found"
+	CodeMethodNotAllowed = "method_not_allowed"
+	CodeConflict         = "conflict"
+	CodeTooLarge         = "too_large"
+	CodeRateLimited      = "rate_limited"
+	CodeTimeout          = "timeout"
+	CodeInternal         = "internal"
+	CodeBadGateway       = "bad_gateway"
+	CodeUnavailable      = "unavailable"
+	CodeUnknown          = "unknown"
+)
+
+// Error is answered with its status as {"error": message, "code": code, "retryable": bool}.
+// Retryable tells the client the same request may succeed later, else it fails until the
+// request is changed
+type Error struct {
+	Status    int    `json:"-"`
+	Message   string `json:"error"`
+	Code      string `json:"code"`
+	Retryable bool   `json:"retryable"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Errorf returns the error of the status, with the code and retryable of the status
+func Errorf(status int, format string, args ...interface{}) *Error {
+	return &Error{
+		Status:    status,
+		Message:   fmt.Sprintf(format, args...),
+		Code:      StatusCode(status),
+		Retryable: StatusRetryable(status),
+	}
+}
+
+// StatusCode returns the code of the errors answered with the status
+func StatusCode(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return CodeBadRequest
+	case http.StatusUnauthorized:
+		return CodeUnauthorized
+	case http.StatusForbidden:
+		return CodeForbidden
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusMethodNotAllowed:
+		return CodeMethodNotAllowed
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusRequestEntityTooLarge:
+		return CodeTooLarge
+	case http.StatusTooManyRequests:
+		return CodeRateLimited
+	case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+		return CodeTimeout
+	case http.StatusInternalServerError:
+		return CodeInternal
+	case http.StatusBadGateway:
+		return CodeBadGateway
+	case http.StatusServiceUnavailable:
+		return CodeUnavailable
+	}
+	return CodeUnknown
+}
+
+// StatusRetryable reports whether the requests failing with the status may succeed later
+func StatusRetryable(status int) bool {
+	switch status {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// WriteError answers the envelope of an *Error with its status, other errors as internal
+func WriteError(w http.ResponseWriter, err error) {
+	e, ok := err.(*Error)
+	if !ok {
+		e = Errorf(http.StatusInternalServerError, "%s", err)
+	}
+	if len(e.Code) == 0 {
+		e.Code = StatusCode(e.Status)
+	}
+	b, _ := json.Marshal(e)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(e.Status)
+	w.Write(b)
+}
+
+// Fail answers the message with the status in the envelope, in place of http.Error
+func Fail(w http.ResponseWriter, message string, status int) {
+	WriteError(w, Errorf(status, "%s", message))
+}
+
+// ReadError returns the error of the answer of an api with a status other than 2xx, decoded
+// from the envelope, or the text of the body with the code of the status for servers that
+// answer plain text
+func ReadError(res *http.Response, body []byte) *Error {
+	e := &Error{}
+	if strings.HasPrefix(res.Header.Get("Content-Type"), "application/json") &&
+		json.Unmarshal(body, e) == nil && len(e.Message) > 0 {
+		e.Status = res.StatusCode
+		if len(e.Code) == 0 {
+			e.Code = StatusCode(res.StatusCode)
+			e.Retryable = StatusRetryable(res.StatusCode)
+		}
+		return e
+	}
+	message := strings.TrimSpace(string(body))
+	if len(message) == 0 {
+		message = http.StatusText(res.StatusCode)
+	}
+	return &Error{
+		Status:    res.StatusCode,
+		Message:   message,
+		Code:      StatusCode(res.StatusCode),
+		Retryable: StatusRetryable(res.StatusCode),
+	}
+}
+
+// IsRetryable reports whether a request failing with err may succeed when sent again: an
+// *Error that tells so, and the errors of the network, like a refused connection or a timeout.
+// The errors wrapped with errors.Wrap are unwrapped
+func IsRetryable(err error) bool {
+	switch e := errors.Cause(err).(type) {
+	case *Error:
+		return e.Retryable
+	case net.Error:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,61 @@
+package httputil
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestErrors(t *testing.T) {
+	w := httptest.NewRecorder()
+	Fail(w, "too many requests", http.StatusTooManyRequests)
+	res := w.Result()
+	e := ReadError(res, w.Body.Bytes())
+	if e.Status != 429 || e.Message != "too many requests" || e.Code != CodeRateLimited || !e.Retryable {
+		t.Errorf("envelope %#v", e)
+	}
+	if res.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("content type %q", res.Header.Get("Content-Type"))
+	}
+
+	w = httptest.NewRecorder()
+	WriteError(w, errors.New("broken"))
+	e = ReadError(w.Result(), w.Body.Bytes())
+	if e.Status != 500 || e.Code != CodeInternal || e.Retryable {
+		t.Errorf("internal %#v", e)
+	}
+
+	// the servers answering text
+	w = httptest.NewRecorder()
+	http.Error(w, "no such node", http.StatusNotFound)
+	e = ReadError(w.Result(), w.Body.Bytes())
+	if e.Status != 404 || e.Message != "no such node" || e.Code != CodeNotFound || e.Retryable {
+		t.Errorf("text %#v", e)
+	}
+	w = httptest.NewRecorder()
+	w.WriteHeader(http.StatusServiceUnavailable)
+	e = ReadError(w.Result(), nil)
+	if e.Message != "Service Unavailable" || e.Code != CodeUnavailable || !e.Retryable {
+		t.Errorf("empty %#v", e)
+	}
+
+	for _, c := range []struct {
+		err  error
+		want bool
+	}{
+		{Errorf(http.StatusBadGateway, "down"), true},
+		{Errorf(http.StatusForbidden, "forbidden"), false},
+		{errors.Wrap(Errorf(http.StatusGatewayTimeout, "slow"), "wrapped"), true},
+		{fmt.Errorf("formatted: %v", Errorf(http.StatusGatewayTimeout, "slow")), false},
+		{&net.OpError{Op: "dial", Err: errors.New("connection refused")}, true},
+		{errors.New("bad config"), false},
+	} {
+		if IsRetryable(c.err) != c.want {
+			t.Errorf("IsRetryable(%v) = %v", c.err, !c.want)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+// Package httputil holds what the HTTP APIs of skywire share: a router of methods and paths
+// with parameters, like /transports/{id}, answering the results of its handlers as JSON, and
+// the errors carrying the status they are answered with, the OpenAPI documents of the routes,
+// the CORS and reverse proxy handling, the deadlines of the handlers, and the serving of the
+// servers until they are stopped.
+package httputil
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Params are the values of the parameters of the path of a route by their names
+type Params map[string]string
+
+// HandlerFunc returns the result answered as JSON, nil for 204 No Content. An *Error is
+// answered with its status, other errors with 500
+type HandlerFunc func(r *http.Request, p Params) (result interface{}, err error)
+
+// Route is a method and a path of a router, the segments of the path in braces are parameters.
+// Summary, Params and Result describe it in the OpenAPI document of its api, see Spec
+type Route struct {
+	Method  string
+	Path    string
+	Handler HandlerFunc
+	// how long the handler may take, see Within
+	Timeout time.Duration
+
+	Summary string
+	Params  []Param
+	Result  interface{}
+
+	segments []string
+}
+
+// Router routes the requests to the handler of their method and path
+type Router struct {
+	// how long the handlers of the routes without their own timeout may take, none when 0
+	Timeout time.Duration
+
+	routes []*Route
+}
+
+func NewRouter() *Router {
+	return &Router{}
+}
+
+// Handle adds the route of the method and path, a path added twice for the same method
+// panics like http.ServeMux does
+func (rt *Router) Handle(method, path string, h HandlerFunc) *Route {
+	r := &Route{Method: method, Path: path, Handler: h, segments: split(path)}
+	for _, v := range rt.routes {
+		if v.Method == method && v.Path == path {
+			panic(fmt.Sprintf("httputil: route %s %s added twice", method, path))
+		}
+	}
+	rt.routes = append(rt.routes, r)
+	return r
+}
+
+func (rt *Router) Get(path string, h HandlerFunc) *Route {
+	return rt.Handle(http.MethodGet, path, h)
+}
+
+func (rt *Router) Post(path string, h HandlerFunc) *Route {
+	return rt.Handle(http.MethodPost, path, h)
+}
+
+// Routes returns the routes in the order they were added
+func (rt *Router) Routes() []*Route {
+	return append([]*Route(nil), rt.routes...)
+}
+
+func split(path string) []string {
+	return strings.Split(strings.Trim(path, "/"), "/")
+}
+
+// match returns the parameters of the path if the route matches it
+func (r *Route) match(segments []string) (p Params, ok bool) {
+	if len(segments) != len(r.segments) {
+		return
+	}
+	for i, s := range r.segments {
+		if len(s) > 2 && s[0] == '{' && s[len(s)-1] == '}' {
+			if len(segments[i]) == 0 {
+				return nil, false
+			}
+			if p == nil {
+				p = make(Params)
+			}
+			p[s[1:len(s)-1]] = segments[i]
+			continue
+		}
+		if s != segments[i] {
+			return nil, false
+		}
+	}
+	return p, true
+}
+
+func (rt *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	segments := split(req.URL.Path)
+	var allowed []string
+	for _, r := range rt.routes {
+		p, ok := r.match(segments)
+		if !ok {
+			continue
+		}
+		if r.Method != req.Method {
+			allowed = append(allowed, r.Method)
+			continue
+		}
+		r.Timed(rt.Timeout, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			result, err := r.Handler(req, p)
+			if err != nil {
+				WriteError(w, err)
+				return
+			}
+			if result == nil {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			WriteJSON(w, http.StatusOK, result)
+		})).ServeHTTP(w, req)
+		return
+	}
+	if len(allowed) > 0 {
+		sort.Strings(allowed)
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		WriteError(w, Errorf(http.StatusMethodNotAllowed, "%s is not allowed on %s", req.Method, req.URL.Path))
+		return
+	}
+	WriteError(w, Errorf(http.StatusNotFound, "no route %s", req.URL.Path))
+}
+
+// WriteJSON answers v as JSON with the status
+func WriteJSON(w http.ResponseWriter, status int, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(b)
+}
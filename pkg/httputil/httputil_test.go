@@ -0,0 +1,53 @@
+package httputil
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRouter(t *testing.T) {
+	rt := NewRouter()
+	rt.Get("/transports", func(r *http.Request, p Params) (interface{}, error) {
+		return []string{"a"}, nil
+	})
+	rt.Get("/transports/{id}", func(r *http.Request, p Params) (interface{}, error) {
+		if p["id"] == "missing" {
+			return nil, Errorf(http.StatusNotFound, "no transport %s", p["id"])
+		}
+		return map[string]string{"id": p["id"]}, nil
+	})
+	rt.Get("/transports/{id}/loops", func(r *http.Request, p Params) (interface{}, error) {
+		return nil, errors.New("broken")
+	})
+	rt.Post("/transports/{id}", func(r *http.Request, p Params) (interface{}, error) {
+		return nil, nil
+	})
+
+	for _, c := range []struct {
+		method, path string
+		status       int
+		body         string
+	}{
+		{"GET", "/transports", 200, `["a"]`},
+		{"GET", "/transports/", 200, `["a"]`},
+		{"GET", "/transports/t1", 200, `{"id":"t1"}`},
+		{"GET", "/transports/missing", 404, `{"error":"no transport missing","code":"not_found","retryable":false}`},
+		{"GET", "/transports/t1/loops", 500, `{"error":"broken","code":"internal","retryable":false}`},
+		{"POST", "/transports/t1", 204, ``},
+		{"DELETE", "/transports/t1", 405, `{"error":"DELETE is not allowed on /transports/t1","code":"method_not_allowed","retryable":false}`},
+		{"GET", "/routes", 404, `{"error":"no route /routes","code":"not_found","retryable":false}`},
+	} {
+		w := httptest.NewRecorder()
+		rt.ServeHTTP(w, httptest.NewRequest(c.method, c.path, nil))
+		body, _ := ioutil.ReadAll(w.Body)
+		if w.Code != c.status || string(body) != c.body {
+			t.Errorf("%s %s: %d %s, want %d %s", c.method, c.path, w.Code, body, c.status, c.body)
+		}
+		if c.status == 405 && w.Header().Get("Allow") != "GET, POST" {
+			t.Errorf("%s %s: allow %q", c.method, c.path, w.Header().Get("Allow"))
+		}
+	}
+}
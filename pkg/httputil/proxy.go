@@ -0,0 +1,165 @@
+package httputil
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Proxies are the reverse proxies, like nginx or Caddy, trusted to tell the address of the
+// client and the scheme it used in the Forwarded or X-Forwarded-For and X-Forwarded-Proto
+// headers. The headers of the requests from other addresses are ignored, anyone can set them
+type Proxies struct {
+	nets []*net.IPNet
+}
+
+// ParseProxies returns the proxies of the comma separated addresses and networks, like
+// 127.0.0.1,10.0.0.0/8, nil for none
+func ParseProxies(list string) (p *Proxies, err error) {
+	for _, v := range strings.Split(list, ",") {
+		v = strings.TrimSpace(v)
+		if len(v) == 0 {
+			continue
+		}
+		if !strings.Contains(v, "/") {
+			ip := net.ParseIP(v)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid proxy address %q", v)
+			}
+			bits := 8 * net.IPv4len
+			if ip.To4() == nil {
+				bits = 8 * net.IPv6len
+			}
+			v = fmt.Sprintf("%s/%d", v, bits)
+		}
+		var n *net.IPNet
+		_, n, err = net.ParseCIDR(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy network %q", v)
+		}
+		if p == nil {
+			p = &Proxies{}
+		}
+		p.nets = append(p.nets, n)
+	}
+	return
+}
+
+func (p *Proxies) trusts(addr string) bool {
+	if p == nil {
+		return false
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range p.nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// hop is an address a request was forwarded for and the scheme it was received with
+type hop struct {
+	addr  string
+	proto string
+}
+
+// hops returns the addresses the request was forwarded for from the client to the last proxy,
+// from the Forwarded header if the request has one
+func hops(r *http.Request) (list []hop) {
+	if forwarded := r.Header["Forwarded"]; len(forwarded) > 0 {
+		for _, element := range strings.Split(strings.Join(forwarded, ","), ",") {
+			var h hop
+			for _, pair := range strings.Split(element, ";") {
+				kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+				if len(kv) != 2 {
+					continue
+				}
+				value := strings.Trim(kv[1], `"`)
+				switch strings.ToLower(kv[0]) {
+				case "for":
+					h.addr = forwardedHost(value)
+				case "proto":
+					h.proto = strings.ToLower(value)
+				}
+			}
+			list = append(list, h)
+		}
+		return
+	}
+	for _, v := range strings.Split(strings.Join(r.Header["X-Forwarded-For"], ","), ",") {
+		v = strings.TrimSpace(v)
+		if len(v) > 0 {
+			list = append(list, hop{addr: forwardedHost(v)})
+		}
+	}
+	// the schemes of the proxies that append them line up with the addresses from the end
+	protos := strings.Split(strings.Join(r.Header["X-Forwarded-Proto"], ","), ",")
+	for i := 1; i <= len(list) && i <= len(protos); i++ {
+		list[len(list)-i].proto = strings.ToLower(strings.TrimSpace(protos[len(protos)-i]))
+	}
+	return
+}
+
+// forwardedHost returns the ip of a forwarded address with or without a port, like
+// [2001:db8::1]:4711
+func forwardedHost(v string) string {
+	if host, _, err := net.SplitHostPort(v); err == nil {
+		return host
+	}
+	return strings.Trim(v, "[]")
+}
+
+// peer returns the address the request came from
+func peer(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Client returns the address of the client of the request and the scheme it used, going back
+// through the proxies it was forwarded by as long as they are trusted
+func (p *Proxies) Client(r *http.Request) (addr, scheme string) {
+	addr = peer(r)
+	scheme = "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if !p.trusts(addr) {
+		return
+	}
+	list := hops(r)
+	for i := len(list) - 1; i >= 0; i-- {
+		// an address the proxy could not tell, like unknown, stops at the proxy
+		if net.ParseIP(list[i].addr) == nil {
+			return
+		}
+		addr = list[i].addr
+		if len(list[i].proto) > 0 {
+			scheme = list[i].proto
+		}
+		if !p.trusts(addr) {
+			return
+		}
+	}
+	return
+}
+
+// Handler passes the requests on to h with the address of their client as RemoteAddr and
+// the scheme it used as URL.Scheme. A nil Proxies only sets the scheme
+func (p *Proxies) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		addr, scheme := p.Client(r)
+		if addr != peer(r) {
+			r.RemoteAddr = net.JoinHostPort(addr, "0")
+		}
+		r.URL.Scheme = scheme
+		h.ServeHTTP(w, r)
+	})
+}
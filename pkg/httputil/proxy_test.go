@@ -0,0 +1,82 @@
+package httputil
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestProxies(t *testing.T) {
+	if _, err := ParseProxies("10.0.0.0/33"); err == nil {
+		t.Error("invalid network parsed")
+	}
+	if _, err := ParseProxies("localhost"); err == nil {
+		t.Error("invalid address parsed")
+	}
+	if p, err := ParseProxies(" "); p != nil || err != nil {
+		t.Errorf("no proxies %v %v", p, err)
+	}
+	p, err := ParseProxies("127.0.0.1, 10.0.0.0/8, ::1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, v := range []struct {
+		remote  string
+		headers map[string]string
+		tls     bool
+		addr    string
+		scheme  string
+	}{
+		// the headers of clients are ignored
+		{"203.0.113.9:4000", map[string]string{"X-Forwarded-For": "1.2.3.4", "X-Forwarded-Proto": "https"}, false, "203.0.113.9", "http"},
+		{"203.0.113.9:4000", nil, true, "203.0.113.9", "https"},
+		{"127.0.0.1:4000", nil, false, "127.0.0.1", "http"},
+		{"127.0.0.1:4000", map[string]string{"X-Forwarded-For": "198.51.100.7", "X-Forwarded-Proto": "https"}, false, "198.51.100.7", "https"},
+		// a client faking the first address does not get past the proxies
+		{"127.0.0.1:4000", map[string]string{"X-Forwarded-For": "1.2.3.4, 198.51.100.7, 10.1.1.1"}, false, "198.51.100.7", "http"},
+		{"[::1]:4000", map[string]string{"X-Forwarded-For": "[2001:db8::1]:4711"}, false, "2001:db8::1", "http"},
+		{"127.0.0.1:4000", map[string]string{"Forwarded": `for=1.2.3.4, for="198.51.100.7:1234";proto=https, for=10.1.1.1;proto=http`}, false, "198.51.100.7", "https"},
+		{"127.0.0.1:4000", map[string]string{"Forwarded": `for="[2001:db8::1]";proto=https`, "X-Forwarded-For": "1.2.3.4"}, false, "2001:db8::1", "https"},
+		// the proxy could not tell the address
+		{"127.0.0.1:4000", map[string]string{"Forwarded": "for=unknown"}, false, "127.0.0.1", "http"},
+		{"127.0.0.1:4000", map[string]string{"X-Forwarded-For": "10.1.1.1"}, false, "10.1.1.1", "http"},
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = v.remote
+		for k, h := range v.headers {
+			r.Header.Set(k, h)
+		}
+		if v.tls {
+			r.TLS = &tls.ConnectionState{}
+		}
+		addr, scheme := p.Client(r)
+		if addr != v.addr || scheme != v.scheme {
+			t.Errorf("%d: %s %s, want %s %s", i, addr, scheme, v.addr, v.scheme)
+		}
+	}
+
+	var remote, scheme string
+	h := p.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remote, scheme = r.RemoteAddr, r.URL.Scheme
+	}))
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "127.0.0.1:4000"
+	r.Header.Set("X-Forwarded-For", "198.51.100.7")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	if remote != "198.51.100.7:0" || scheme != "http" {
+		t.Errorf("handler %s %s", remote, scheme)
+	}
+	var none *Proxies
+	h = none.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remote, scheme = r.RemoteAddr, r.URL.Scheme
+	}))
+	r = httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "127.0.0.1:4000"
+	r.Header.Set("X-Forwarded-For", "198.51.100.7")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	if remote != "127.0.0.1:4000" || scheme != "http" {
+		t.Errorf("nil proxies %s %s", remote, scheme)
+	}
+}
@@ -0,0 +1,98 @@
+package httputil
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// DrainTimeout is how long a stopping server waits for the requests in flight before it closes
+// their connections
+const DrainTimeout = 10 * time.Second
+
+// Serve serves srv on its address until ctx is done or the process gets SIGINT or SIGTERM, then
+// stops taking connections and drains the requests in flight, see Shutdown. It serves TLS when
+// srv.TLSConfig holds a certificate, see LoadTLS. It returns nil once the server stopped, also
+// when it is stopped by Shutdown, Close or srv.Shutdown.
+//
+// A binary calling Serve handles SIGTERM itself, the signal no longer stops the process
+func Serve(ctx context.Context, srv *http.Server) error {
+	return ServeListener(ctx, srv, nil)
+}
+
+// ServeListener is Serve on ln, like a socket passed by systemd socket activation, instead of
+// the address of the server. A nil ln listens on srv.Addr
+func ServeListener(ctx context.Context, srv *http.Server, ln net.Listener) (err error) {
+	if ln == nil {
+		addr := srv.Addr
+		if len(addr) == 0 {
+			addr = ":http"
+			if hasCertificate(srv.TLSConfig) {
+				addr = ":https"
+			}
+		}
+		ln, err = net.Listen("tcp", addr)
+		if err != nil {
+			return
+		}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	stopped := make(chan error, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-signals:
+		}
+		stopped <- Shutdown(srv)
+	}()
+
+	if hasCertificate(srv.TLSConfig) {
+		err = srv.ServeTLS(ln, "", "")
+	} else {
+		err = srv.Serve(ln)
+	}
+	if err != http.ErrServerClosed {
+		return
+	}
+	// stopped by Shutdown elsewhere, the goroutine shuts down the closed server right away
+	cancel()
+	return <-stopped
+}
+
+// Shutdown stops srv taking connections and waits up to DrainTimeout for the requests in
+// flight, then closes the connections left, like the websockets of the terminals
+func Shutdown(srv *http.Server) error {
+	ctx, cancel := context.WithTimeout(context.Background(), DrainTimeout)
+	defer cancel()
+	err := srv.Shutdown(ctx)
+	if err == context.DeadlineExceeded {
+		srv.Close()
+	}
+	return err
+}
+
+// LoadTLS returns the TLS config of the certificate and key files for http.Server.TLSConfig,
+// refusing the versions of TLS older than 1.2
+func LoadTLS(certFile, keyFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+func hasCertificate(c *tls.Config) bool {
+	return c != nil && (len(c.Certificates) > 0 || c.GetCertificate != nil)
+}
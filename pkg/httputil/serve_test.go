@@ -0,0 +1,79 @@
+package httputil
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestServe(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte("done"))
+	})}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- ServeListener(ctx, srv, ln)
+	}()
+
+	// the request in flight when the server stops is answered
+	answered := make(chan string, 1)
+	go func() {
+		res, err := http.Get("http://" + ln.Addr().String())
+		if err != nil {
+			answered <- err.Error()
+			return
+		}
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		answered <- string(body)
+	}()
+	<-started
+	cancel()
+	if body := <-answered; body != "done" {
+		t.Errorf("request in flight: %s", body)
+	}
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("serve: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("serve did not return")
+	}
+	if _, err := http.Get("http://" + ln.Addr().String()); err == nil {
+		t.Errorf("stopped server answered")
+	}
+
+	// and stops when shut down elsewhere
+	srv = &http.Server{Addr: "127.0.0.1:0"}
+	go func() {
+		served <- Serve(context.Background(), srv)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if err := Shutdown(srv); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("serve: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("serve did not return")
+	}
+
+	if _, err := LoadTLS("missing.crt", "missing.key"); err == nil {
+		t.Errorf("loaded missing certificate")
+	}
+}
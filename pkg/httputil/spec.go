@@ -0,0 +1,274 @@
+package httputil
+
+import (
+	"encoding"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// SpecPath is where the HTTP APIs of skywire serve their OpenAPI document
+const SpecPath = "/api/spec"
+
+// Param is a parameter of a route, read from the query of a GET and from the form of the
+// other methods
+type Param struct {
+	Name        string
+	Description string
+}
+
+// Doc sets the summary of the route
+func (r *Route) Doc(summary string) *Route {
+	r.Summary = summary
+	return r
+}
+
+// Param adds a parameter to the route
+func (r *Route) Param(name, description string) *Route {
+	r.Params = append(r.Params, Param{Name: name, Description: description})
+	return r
+}
+
+// Returns sets the result of the route to a value of the type it answers, the schema of the
+// result is the one of the type
+func (r *Route) Returns(v interface{}) *Route {
+	r.Result = v
+	return r
+}
+
+// Spec is the OpenAPI document of an api, generated from the routes it describes. The routes
+// of a Router are added by Router.ServeSpec, the routes registered with http.HandleFunc are
+// described with Describe
+type Spec struct {
+	Title       string
+	Version     string
+	Description string
+
+	routes []*Route
+}
+
+func NewSpec(title, version string) *Spec {
+	return &Spec{Title: title, Version: version}
+}
+
+// Describe adds the route of the method and path to the document, without a handler
+func (s *Spec) Describe(method, path string) *Route {
+	r := &Route{Method: method, Path: path, segments: split(path)}
+	s.routes = append(s.routes, r)
+	return r
+}
+
+// Add adds routes to the document
+func (s *Spec) Add(routes ...*Route) *Spec {
+	s.routes = append(s.routes, routes...)
+	return s
+}
+
+// Document returns the OpenAPI 3.0 document of the routes, to be marshalled to JSON
+func (s *Spec) Document() map[string]interface{} {
+	info := map[string]interface{}{"title": s.Title, "version": s.Version}
+	if len(s.Description) > 0 {
+		info["description"] = s.Description
+	}
+	paths := make(map[string]map[string]interface{})
+	for _, r := range s.routes {
+		item, ok := paths[r.Path]
+		if !ok {
+			item = make(map[string]interface{})
+			paths[r.Path] = item
+		}
+		item[strings.ToLower(r.Method)] = s.operation(r)
+	}
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info":    info,
+		"paths":   paths,
+	}
+}
+
+func (s *Spec) operation(r *Route) map[string]interface{} {
+	op := map[string]interface{}{"operationId": operationID(r)}
+	if len(r.Summary) > 0 {
+		op["summary"] = r.Summary
+	}
+	var params []interface{}
+	for _, seg := range r.segments {
+		if len(seg) > 2 && seg[0] == '{' && seg[len(seg)-1] == '}' {
+			params = append(params, map[string]interface{}{
+				"name":     seg[1 : len(seg)-1],
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]interface{}{"type": "string"},
+			})
+		}
+	}
+	if r.Method == http.MethodGet {
+		for _, p := range r.Params {
+			params = append(params, map[string]interface{}{
+				"name":        p.Name,
+				"in":          "query",
+				"description": p.Description,
+				"schema":      map[string]interface{}{"type": "string"},
+			})
+		}
+	} else if len(r.Params) > 0 {
+		props := make(map[string]interface{})
+		for _, p := range r.Params {
+			props[p.Name] = map[string]interface{}{"type": "string", "description": p.Description}
+		}
+		op["requestBody"] = map[string]interface{}{
+			"content": map[string]interface{}{
+				"application/x-www-form-urlencoded": map[string]interface{}{
+					"schema": map[string]interface{}{"type": "object", "properties": props},
+				},
+			},
+		}
+	}
+	if len(params) > 0 {
+		op["parameters"] = params
+	}
+
+	ok := map[string]interface{}{"description": "OK"}
+	if r.Result != nil {
+		ok["content"] = map[string]interface{}{
+			"application/json": map[string]interface{}{"schema": schemaOf(reflect.TypeOf(r.Result), nil)},
+		}
+	}
+	failed := map[string]interface{}{
+		"description": "Error",
+		"content": map[string]interface{}{
+			"application/json": map[string]interface{}{"schema": schemaOf(reflect.TypeOf(Error{}), nil)},
+		},
+	}
+	op["responses"] = map[string]interface{}{"200": ok, "default": failed}
+	return op
+}
+
+// operationID is the method and the words of the path, like getApiV1TransportsIdLoops
+func operationID(r *Route) string {
+	id := strings.ToLower(r.Method)
+	up := true
+	for _, c := range r.Path {
+		if !unicode.IsLetter(c) && !unicode.IsDigit(c) {
+			up = true
+			continue
+		}
+		if up {
+			c = unicode.ToUpper(c)
+			up = false
+		}
+		id += string(c)
+	}
+	return id
+}
+
+var (
+	timeType      = reflect.TypeOf(time.Time{})
+	durationType  = reflect.TypeOf(time.Duration(0))
+	marshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textType      = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// schemaOf returns the schema of the JSON encoding of t, the structs in seen are the ones
+// being described around t and are not described again
+func schemaOf(t reflect.Type, seen []reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t == durationType:
+		return map[string]interface{}{"type": "integer", "format": "int64", "description": "nanoseconds"}
+	case t.Implements(marshalerType) || reflect.PtrTo(t).Implements(marshalerType):
+		return map[string]interface{}{}
+	case t.Implements(textType) || reflect.PtrTo(t).Implements(textType):
+		return map[string]interface{}{"type": "string"}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int8, reflect.Int16, reflect.Int32:
+		return map[string]interface{}{"type": "integer", "format": "int32"}
+	case reflect.Int, reflect.Int64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]interface{}{"type": "integer", "format": "int32", "minimum": 0}
+	case reflect.Uint, reflect.Uint64, reflect.Uintptr:
+		return map[string]interface{}{"type": "integer", "format": "int64", "minimum": 0}
+	case reflect.Float32:
+		return map[string]interface{}{"type": "number", "format": "float"}
+	case reflect.Float64:
+		return map[string]interface{}{"type": "number", "format": "double"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": schemaOf(t.Elem(), seen)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaOf(t.Elem(), seen)}
+	case reflect.Struct:
+		for _, v := range seen {
+			if v == t {
+				return map[string]interface{}{"type": "object"}
+			}
+		}
+		seen = append(seen, t)
+		props := make(map[string]interface{})
+		addFields(t, props, seen)
+		return map[string]interface{}{"type": "object", "properties": props}
+	}
+	// interfaces and what JSON can't encode
+	return map[string]interface{}{}
+}
+
+// addFields adds the schemas of the fields of the struct t to props by their JSON names, the
+// fields of embedded structs like encoding/json does
+func addFields(t reflect.Type, props map[string]interface{}, seen []reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if f.Anonymous && len(name) == 0 {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				addFields(ft, props, seen)
+				continue
+			}
+		}
+		if len(f.PkgPath) > 0 {
+			continue
+		}
+		if len(name) == 0 {
+			name = f.Name
+		}
+		schema := schemaOf(f.Type, seen)
+		if strings.Contains(tag, ",string") {
+			schema = map[string]interface{}{"type": "string"}
+		}
+		props[name] = schema
+	}
+}
+
+// ServeHTTP answers the document
+func (s *Spec) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	WriteJSON(w, http.StatusOK, s.Document())
+}
+
+// ServeSpec adds the route of SpecPath answering the document of the routes of the router
+func (rt *Router) ServeSpec(title, version string) *Route {
+	return rt.Get(SpecPath, func(r *http.Request, p Params) (interface{}, error) {
+		return NewSpec(title, version).Add(rt.Routes()...).Document(), nil
+	}).Doc("The OpenAPI document of the api")
+}
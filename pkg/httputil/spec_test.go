@@ -0,0 +1,121 @@
+package httputil
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type specBase struct {
+	ID string `json:"id"`
+}
+
+type specTransport struct {
+	specBase
+	Loops   map[uint32]string `json:"loops"`
+	Signed  bool              `json:"signed,omitempty"`
+	Created time.Time         `json:"created"`
+	Next    *specTransport    `json:"next"`
+	Secret  string            `json:"-"`
+	hidden  int
+}
+
+func TestSpec(t *testing.T) {
+	rt := NewRouter()
+	rt.Get("/transports", func(r *http.Request, p Params) (interface{}, error) {
+		return nil, nil
+	}).Doc("The transports").Param("limit", "the most transports").Returns([]specTransport{})
+	rt.Post("/transports/{id}", func(r *http.Request, p Params) (interface{}, error) {
+		return nil, nil
+	}).Param("label", "the label")
+	rt.ServeSpec("Test API", "1.0")
+
+	w := httptest.NewRecorder()
+	rt.ServeHTTP(w, httptest.NewRequest("GET", SpecPath, nil))
+	if w.Code != 200 {
+		t.Fatalf("spec: %d %s", w.Code, w.Body)
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	get := func(v interface{}, path ...string) interface{} {
+		for _, p := range path {
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				t.Fatalf("%v: not an object at %s", path, p)
+			}
+			v = m[p]
+		}
+		return v
+	}
+	if get(doc, "openapi") != "3.0.3" || get(doc, "info", "title") != "Test API" || get(doc, "info", "version") != "1.0" {
+		t.Errorf("info %v", get(doc, "info"))
+	}
+
+	list := get(doc, "paths", "/transports", "get").(map[string]interface{})
+	if list["operationId"] != "getTransports" || list["summary"] != "The transports" {
+		t.Errorf("operation %v", list)
+	}
+	params := list["parameters"].([]interface{})
+	if len(params) != 1 || get(params[0], "in") != "query" || get(params[0], "name") != "limit" {
+		t.Errorf("parameters %v", params)
+	}
+	schema := get(list, "responses", "200", "content", "application/json", "schema")
+	if get(schema, "type") != "array" {
+		t.Fatalf("schema %v", schema)
+	}
+	props := get(schema, "items", "properties").(map[string]interface{})
+	want := map[string]interface{}{
+		"id":      "string",
+		"loops":   "object",
+		"signed":  "boolean",
+		"created": "string",
+		"next":    "object",
+	}
+	if len(props) != len(want) {
+		t.Errorf("properties %v", props)
+	}
+	for name, typ := range want {
+		if get(props, name, "type") != typ {
+			t.Errorf("property %s: %v, want %s", name, props[name], typ)
+		}
+	}
+	if get(props, "created", "format") != "date-time" || get(props, "loops", "additionalProperties", "type") != "string" {
+		t.Errorf("properties %v", props)
+	}
+	if get(props, "next", "properties") != nil {
+		t.Errorf("recursive next described %v", props["next"])
+	}
+	errProps := get(list, "responses", "default", "content", "application/json", "schema", "properties")
+	if get(errProps, "error", "type") != "string" || get(errProps, "code", "type") != "string" || get(errProps, "retryable", "type") != "boolean" {
+		t.Errorf("error response %v", get(list, "responses", "default"))
+	}
+
+	set := get(doc, "paths", "/transports/{id}", "post")
+	params = get(set, "parameters").([]interface{})
+	if len(params) != 1 || get(params[0], "in") != "path" || get(params[0], "name") != "id" || get(params[0], "required") != true {
+		t.Errorf("parameters %v", params)
+	}
+	form := get(set, "requestBody", "content", "application/x-www-form-urlencoded", "schema", "properties")
+	if !reflect.DeepEqual(form, map[string]interface{}{"label": map[string]interface{}{"type": "string", "description": "the label"}}) {
+		t.Errorf("form %v", form)
+	}
+	if get(doc, "paths", SpecPath, "get") == nil {
+		t.Errorf("no spec route")
+	}
+
+	s := NewSpec("Form API", "2")
+	s.Describe(http.MethodPost, "/node/getInfo").Param("token", "the token")
+	b, _ := json.Marshal(s.Document())
+	var d map[string]interface{}
+	if err := json.Unmarshal(b, &d); err != nil {
+		t.Fatal(err)
+	}
+	if get(d, "paths", "/node/getInfo", "post", "responses", "default", "content", "application/json") == nil {
+		t.Errorf("described %v", get(d, "paths"))
+	}
+}
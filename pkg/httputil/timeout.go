@@ -0,0 +1,122 @@
+package httputil
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// NoTimeout is the deadline of the routes that stream their answers or hijack the connection,
+// like websockets, they are not buffered and run as long as they need
+const NoTimeout time.Duration = -1
+
+// Within sets how long the handler of the route may take, the default of its api when 0
+func (r *Route) Within(d time.Duration) *Route {
+	r.Timeout = d
+	return r
+}
+
+// Timed returns h with the deadline of the route, def for a route without one. The deadline is
+// read at each request, so it can be set on the route after h is registered
+func (r *Route) Timed(def time.Duration, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		d := r.Timeout
+		if d == 0 {
+			d = def
+		}
+		Timeout(d, h).ServeHTTP(w, req)
+	})
+}
+
+// Timeout runs h with a context done after d and answers 503 with the code timeout when h has
+// not answered by then, so a slow handler does not tie up the connection. h keeps running
+// until it sees the context done, what it writes after the deadline is dropped. The answer of h
+// is buffered until it returns, h cannot stream or hijack the connection. A d <= 0 returns h
+func Timeout(d time.Duration, h http.Handler) http.Handler {
+	if d <= 0 {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), d)
+		defer cancel()
+		r = r.WithContext(ctx)
+		tw := &timeoutWriter{header: make(http.Header)}
+		done := make(chan struct{})
+		panicked := make(chan interface{}, 1)
+		go func() {
+			defer func() {
+				if p := recover(); p != nil {
+					panicked <- p
+				}
+			}()
+			h.ServeHTTP(tw, r)
+			close(done)
+		}()
+		select {
+		case p := <-panicked:
+			panic(p)
+		case <-done:
+			tw.Lock()
+			defer tw.Unlock()
+			for k, v := range tw.header {
+				w.Header()[k] = v
+			}
+			if tw.status == 0 {
+				tw.status = http.StatusOK
+			}
+			w.WriteHeader(tw.status)
+			w.Write(tw.body.Bytes())
+		case <-ctx.Done():
+			tw.Lock()
+			defer tw.Unlock()
+			tw.timedOut = true
+			if ctx.Err() != context.DeadlineExceeded {
+				// the client went away
+				return
+			}
+			WriteError(w, &Error{
+				Status:    http.StatusServiceUnavailable,
+				Message:   fmt.Sprintf("%s took longer than %s", r.URL.Path, d),
+				Code:      CodeTimeout,
+				Retryable: true,
+			})
+		}
+	})
+}
+
+// timeoutWriter buffers the answer of a handler run by Timeout
+type timeoutWriter struct {
+	header   http.Header
+	body     bytes.Buffer
+	status   int
+	timedOut bool
+	sync.Mutex
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	tw.Lock()
+	defer tw.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if tw.status == 0 {
+		tw.status = http.StatusOK
+	}
+	return tw.body.Write(b)
+}
+
+func (tw *timeoutWriter) WriteHeader(status int) {
+	tw.Lock()
+	defer tw.Unlock()
+	if tw.timedOut || tw.status != 0 {
+		return
+	}
+	tw.status = status
+}
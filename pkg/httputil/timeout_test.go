@@ -0,0 +1,63 @@
+package httputil
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTimeout(t *testing.T) {
+	rt := NewRouter()
+	rt.Timeout = 50 * time.Millisecond
+	stopped := make(chan error, 1)
+	rt.Get("/slow", func(r *http.Request, p Params) (interface{}, error) {
+		<-r.Context().Done()
+		stopped <- r.Context().Err()
+		return "late", nil
+	})
+	rt.Get("/fast", func(r *http.Request, p Params) (interface{}, error) {
+		return "fast", nil
+	})
+	rt.Get("/long", func(r *http.Request, p Params) (interface{}, error) {
+		time.Sleep(100 * time.Millisecond)
+		return "long", nil
+	}).Within(time.Second)
+	rt.Get("/stream", func(r *http.Request, p Params) (interface{}, error) {
+		if _, ok := r.Context().Deadline(); ok {
+			t.Errorf("stream has a deadline")
+		}
+		return "stream", nil
+	}).Within(NoTimeout)
+
+	w := httptest.NewRecorder()
+	rt.ServeHTTP(w, httptest.NewRequest("GET", "/slow", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("slow: %d %s", w.Code, w.Body)
+	}
+	var e Error
+	if err := json.Unmarshal(w.Body.Bytes(), &e); err != nil || e.Code != CodeTimeout || !e.Retryable {
+		t.Errorf("slow: %s", w.Body)
+	}
+	select {
+	case err := <-stopped:
+		if err == nil {
+			t.Errorf("context of the slow handler not done")
+		}
+	case <-time.After(time.Second):
+		t.Errorf("slow handler not stopped")
+	}
+
+	for path, want := range map[string]string{"/fast": `"fast"`, "/long": `"long"`, "/stream": `"stream"`} {
+		w = httptest.NewRecorder()
+		w.Header().Set("Vary", "Origin")
+		rt.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusOK || w.Body.String() != want {
+			t.Errorf("%s: %d %s", path, w.Code, w.Body)
+		}
+		if w.Header().Get("Content-Type") != "application/json" || w.Header().Get("Vary") != "Origin" {
+			t.Errorf("%s: headers %v", path, w.Header())
+		}
+	}
+}
@@ -63,8 +63,7 @@ func (c *ClientUDPConn) ReadLoop() (err error) {
 				return err
 			}
 		case msg.TYPE_FIN:
-			err = conn.ErrFin
-			break
+			return conn.ErrFin
 		default:
 			c.GetContextLogger().Debugf("not implemented msg type %d", t)
 			return fmt.Errorf("not implemented msg type %d", t)
@@ -0,0 +1,21 @@
+package conn
+
+import (
+	"unsafe"
+
+	"github.com/skycoin/skywire/pkg/net/util"
+)
+
+// CheckAlign checks the 64-bit fields of the connections accessed with sync/atomic are aligned
+func CheckAlign() error {
+	var c ConnCommonFields
+	var u UDPConn
+	var a ca
+	return util.CheckAlign64(
+		util.Field64{Name: "ConnCommonFields.lastReadTime", Offset: unsafe.Offsetof(c.lastReadTime)},
+		util.Field64{Name: "ConnCommonFields.sentBytes", Offset: unsafe.Offsetof(c.sentBytes)},
+		util.Field64{Name: "ConnCommonFields.receivedBytes", Offset: unsafe.Offsetof(c.receivedBytes)},
+		util.Field64{Name: "UDPConn.rtt", Offset: unsafe.Offsetof(u.rtt)},
+		util.Field64{Name: "ca.pacingRate", Offset: unsafe.Offsetof(a.pacingRate)},
+	)
+}
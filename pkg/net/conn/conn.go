@@ -40,6 +40,8 @@ type Connection interface {
 	NewPendingChannel() (channel int)
 	DeletePendingChannel(channel int)
 	WriteToChannel(channel int, bytes []byte) (err error)
+	SetChannelPolicy(channel, max int, policy SendPolicy) error
+	GetChannelQueue(channel int) (q ChannelQueue, ok bool)
 
 	WaitForDisconnected()
 	GetDisconnectedChan() <-chan struct{}
@@ -50,6 +52,7 @@ type Connection interface {
 	GetCrypto() *Crypto
 
 	SetStatusToError(err error)
+	GetStatusError() error
 }
 
 type ConnCommonFields struct {
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/skycoin/skycoin/src/cipher"
+	"github.com/skycoin/skywire/pkg/net/util"
 	"io"
 	"sync"
 	"sync/atomic"
@@ -20,6 +21,8 @@ type Crypto struct {
 	esMutex sync.Mutex
 	ds      cipher2.Stream
 	dsMutex sync.Mutex
+	// the data is left as it is, the link is trusted
+	plain bool
 }
 
 func NewCrypto(key cipher.PubKey, secKey cipher.SecKey) *Crypto {
@@ -29,6 +32,12 @@ func NewCrypto(key cipher.PubKey, secKey cipher.SecKey) *Crypto {
 	}
 }
 
+// NewPlainCrypto returns a crypto that leaves the data as it is, for links already
+// encrypted and authenticated by a lower layer
+func NewPlainCrypto() *Crypto {
+	return &Crypto{plain: true}
+}
+
 func (c *Crypto) SetTargetKey(target cipher.PubKey) (err error) {
 	defer func() {
 		if e := recover(); e != nil {
@@ -37,6 +46,7 @@ func (c *Crypto) SetTargetKey(target cipher.PubKey) (err error) {
 	}()
 	c.target = target
 	ecdh := cipher.ECDH(target, c.secKey)
+	defer util.Wipe(ecdh)
 	b, err := aes.NewCipher(ecdh)
 	c.block.Store(b)
 	return
@@ -59,6 +69,9 @@ func (c *Crypto) Init(iv []byte) (err error) {
 }
 
 func (c *Crypto) Encrypt(data []byte) (err error) {
+	if c.plain {
+		return
+	}
 	block := c.block.Load()
 	if block == nil {
 		err = errors.New("call SetTargetKey first")
@@ -72,6 +85,9 @@ func (c *Crypto) Encrypt(data []byte) (err error) {
 }
 
 func (c *Crypto) Decrypt(data []byte) (err error) {
+	if c.plain {
+		return
+	}
 	block := c.block.Load()
 	if block == nil {
 		err = errors.New("call SetTargetKey first")
@@ -0,0 +1,43 @@
+package conn
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+func TestPlainCrypto(t *testing.T) {
+	data := []byte("the data of a trusted link")
+	c := NewPlainCrypto()
+	b := append([]byte(nil), data...)
+	if err := c.Encrypt(b); err != nil || !bytes.Equal(b, data) {
+		t.Fatalf("encrypted %q, %v", b, err)
+	}
+	if err := c.Decrypt(b); err != nil || !bytes.Equal(b, data) {
+		t.Fatalf("decrypted %q, %v", b, err)
+	}
+
+	// the other cryptos still encrypt
+	pk, sk := cipher.GenerateKeyPair()
+	tpk, tsk := cipher.GenerateKeyPair()
+	iv := bytes.Repeat([]byte{1}, 16)
+	enc, dec := NewCrypto(pk, sk), NewCrypto(tpk, tsk)
+	for _, x := range []struct {
+		c      *Crypto
+		target cipher.PubKey
+	}{{enc, tpk}, {dec, pk}} {
+		if err := x.c.SetTargetKey(x.target); err != nil {
+			t.Fatal(err)
+		}
+		if err := x.c.Init(iv); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := enc.Encrypt(b); err != nil || bytes.Equal(b, data) {
+		t.Fatalf("not encrypted %q, %v", b, err)
+	}
+	if err := dec.Decrypt(b); err != nil || !bytes.Equal(b, data) {
+		t.Fatalf("decrypted %q, %v", b, err)
+	}
+}
@@ -31,15 +31,21 @@ func TestFec(t *testing.T) {
 		b5,
 	}
 
+	var recovered bool
 	for i, d := range datas {
+		// the lost packet never reaches the decoder
+		if d == nil {
+			continue
+		}
 		g, err := decoder.decode(uint32(i+1), d)
 		if err != nil {
 			t.Error(err)
 		}
-		if g != nil && g.Recovered {
-			for i, b := range g.DataRecv {
+		if g != nil && g.recovered {
+			recovered = true
+			for i, b := range g.dataRecv {
 				if !b {
-					m := g.Datas[i]
+					m := g.datas[i]
 					if len(m) <= msg.MSG_HEADER_SIZE {
 						t.Log("fec recovered len(m) <= msg.MSG_HEADER_SIZE")
 						continue
@@ -51,5 +57,7 @@ func TestFec(t *testing.T) {
 			}
 		}
 	}
-
+	if !recovered {
+		t.Error("the lost packet was not recovered")
+	}
 }
@@ -11,6 +11,8 @@ type UDPPendingMap struct {
 	pendings map[uint32]*msg.UDPMessage
 	sync.RWMutex
 	seqs *btree.BTree
+	// a message is resent once QUICK_LOST_THRESH later messages were acked
+	quickLost bool
 }
 
 type seq uint32
@@ -21,12 +23,20 @@ func (a seq) Less(b btree.Item) bool {
 
 func NewUDPPendingMap() *UDPPendingMap {
 	m := &UDPPendingMap{
-		pendings: make(map[uint32]*msg.UDPMessage),
-		seqs:     btree.New(2),
+		pendings:  make(map[uint32]*msg.UDPMessage),
+		seqs:      btree.New(2),
+		quickLost: QUICK_LOST_ENABLE,
 	}
 	return m
 }
 
+// SetQuickLost resends the messages the acks skipped without waiting for their rto
+func (m *UDPPendingMap) SetQuickLost(enable bool) {
+	m.Lock()
+	m.quickLost = enable
+	m.Unlock()
+}
+
 func (m *UDPPendingMap) AddMsg(k uint32, v *msg.UDPMessage) {
 	m.Lock()
 	m.pendings[k] = v
@@ -75,7 +85,7 @@ func (m *UDPPendingMap) DelMsgAndGetLossMsgs(k uint32) (ok bool, um *msg.UDPMess
 	delete(m.pendings, k)
 
 	m.seqs.Delete(seq(k))
-	if QUICK_LOST_ENABLE {
+	if m.quickLost {
 		m.seqs.AscendLessThan(seq(k), func(i btree.Item) bool {
 			v, ok := m.pendings[uint32(i.(seq))]
 			if ok {
@@ -1,6 +1,7 @@
 package conn
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/skycoin/skywire/pkg/net/msg"
@@ -18,16 +19,52 @@ func TestNewUDPPendingMap(t *testing.T) {
 	m.AddMsg(4, newUdp(4))
 	m.AddMsg(5, newUdp(5))
 
-	t.Log(m.DelMsgAndGetLossMsgs(1, 3))
+	t.Log(m.DelMsgAndGetLossMsgs(1))
 	//t.Log(m.DelMsgAndGetLossMsgs(3))
-	t.Log(m.DelMsgAndGetLossMsgs(4, 3))
-	t.Log(m.DelMsgAndGetLossMsgs(5, 3))
+	t.Log(m.DelMsgAndGetLossMsgs(4))
+	t.Log(m.DelMsgAndGetLossMsgs(5))
 	m.AddMsg(6, newUdp(6))
-	t.Log(m.DelMsgAndGetLossMsgs(3, 3))
+	t.Log(m.DelMsgAndGetLossMsgs(3))
 	m.AddMsg(7, newUdp(7))
-	t.Log(m.DelMsgAndGetLossMsgs(6, 3))
+	t.Log(m.DelMsgAndGetLossMsgs(6))
 	m.AddMsg(8, newUdp(8))
 	m.AddMsg(9, newUdp(9))
-	t.Log(m.DelMsgAndGetLossMsgs(8, 3))
-	t.Log(m.DelMsgAndGetLossMsgs(9, 3))
+	t.Log(m.DelMsgAndGetLossMsgs(8))
+	t.Log(m.DelMsgAndGetLossMsgs(9))
+}
+
+func TestQuickLost(t *testing.T) {
+	for _, c := range []struct {
+		name      string
+		quickLost bool
+		acks      []uint32
+		// the messages to resend after each ack
+		loss [][]uint32
+	}{
+		{name: "off", acks: []uint32{2, 3, 4}, loss: [][]uint32{nil, nil, nil}},
+		{name: "gap of one", quickLost: true, acks: []uint32{2, 3, 4}, loss: [][]uint32{nil, nil, {1}}},
+		{name: "gap of two", quickLost: true, acks: []uint32{3, 4, 5}, loss: [][]uint32{nil, nil, {1, 2}}},
+		// a message is resent once, then its rto resends it
+		{name: "resent once", quickLost: true, acks: []uint32{2, 3, 4, 5, 6, 7}, loss: [][]uint32{nil, nil, {1}, nil, nil, nil}},
+		{name: "gap filled", quickLost: true, acks: []uint32{2, 1, 3, 4}, loss: [][]uint32{nil, nil, nil, nil}},
+	} {
+		m := NewUDPPendingMap()
+		m.SetQuickLost(c.quickLost)
+		for i := uint32(1); i <= 8; i++ {
+			m.AddMsg(i, newUdp(i))
+		}
+		for i, ack := range c.acks {
+			ok, _, loss := m.DelMsgAndGetLossMsgs(ack)
+			if !ok {
+				t.Fatalf("%s: %d not pending", c.name, ack)
+			}
+			var seqs []uint32
+			for _, l := range loss {
+				seqs = append(seqs, l.GetSeq())
+			}
+			if fmt.Sprint(seqs) != fmt.Sprint(c.loss[i]) {
+				t.Errorf("%s: ack %d resends %v, want %v", c.name, ack, seqs, c.loss[i])
+			}
+		}
+	}
 }
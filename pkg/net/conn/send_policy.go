@@ -0,0 +1,127 @@
+package conn
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// SendPolicy tells what a write does to a channel whose queue is full
+type SendPolicy int
+
+const (
+	// wait until the queue has room, the default
+	SendBlock SendPolicy = iota
+	// drop the oldest message not sent yet
+	SendDropOldest
+	// fail with ErrSendQueueFull
+	SendError
+)
+
+func (p SendPolicy) String() string {
+	switch p {
+	case SendBlock:
+		return "block"
+	case SendDropOldest:
+		return "drop_oldest"
+	case SendError:
+		return "error"
+	}
+	return "unknown"
+}
+
+var (
+	ErrSendQueueFull = errors.New("send queue of the channel full")
+	// the TCP connections write at once and queue nothing
+	ErrNoSendQueue = errors.New("connection has no send queues")
+)
+
+// ChannelQueue is the send queue of a channel of a connection
+type ChannelQueue struct {
+	// messages written and not sent yet
+	Queued int    `json:"queued"`
+	Max    int    `json:"max"`
+	Policy string `json:"policy"`
+	// messages dropped or refused as the queue was full
+	Dropped uint64 `json:"dropped"`
+}
+
+func (c *ConnCommonFields) SetChannelPolicy(channel, max int, policy SendPolicy) error {
+	return ErrNoSendQueue
+}
+
+func (c *ConnCommonFields) GetChannelQueue(channel int) (q ChannelQueue, ok bool) {
+	return
+}
+
+// SetChannelPolicy sets the messages the channel queues at most, the default if max is 0,
+// and what a write to the channel does once it is full. Channel 0 carries the writes not
+// made to a channel.
+func (c *UDPConn) SetChannelPolicy(channel, max int, policy SendPolicy) error {
+	return c.ca.setChannelPolicy(channel, max, policy)
+}
+
+// GetChannelQueue returns the send queue of the channel, false if it does not exist
+func (c *UDPConn) GetChannelQueue(channel int) (q ChannelQueue, ok bool) {
+	return c.ca.getChannelQueue(channel)
+}
+
+func (ca *ca) getPdChan(channel int) (ch *pdChan, ok bool) {
+	ca.bifMtx.RLock()
+	ch, ok = ca.bifPdChans[channel]
+	ca.bifMtx.RUnlock()
+	return
+}
+
+func (ca *ca) setChannelPolicy(channel, max int, policy SendPolicy) error {
+	ch, ok := ca.getPdChan(channel)
+	if !ok {
+		return fmt.Errorf("no channel %d", channel)
+	}
+	ch.mtx.Lock()
+	if max > 0 {
+		ch.maxPd = max
+	} else {
+		ch.maxPd = ch.defaultMaxPd
+	}
+	ch.policy = policy
+	ch.mtx.Unlock()
+	// the writes waiting may have room or not wait anymore
+	ch.cond.Broadcast()
+	return nil
+}
+
+func (ca *ca) getChannelQueue(channel int) (q ChannelQueue, ok bool) {
+	ch, ok := ca.getPdChan(channel)
+	if !ok {
+		return
+	}
+	ch.mtx.Lock()
+	q = ChannelQueue{
+		Queued:  ch.pd.Len(),
+		Max:     ch.maxPd,
+		Policy:  ch.policy.String(),
+		Dropped: ch.dropped,
+	}
+	ch.mtx.Unlock()
+	return
+}
+
+// makeRoom makes room for a message in the full channel by its policy, with the mutex of the
+// channel locked, false if the write fails
+func (ca *ca) makeRoom(ch *pdChan) bool {
+	for ch.pd.Len() >= ch.maxPd {
+		switch ch.policy {
+		case SendError:
+			ch.dropped++
+			return false
+		case SendDropOldest:
+			ch.pd.DeleteMin()
+			ch.dropped++
+			atomic.AddInt32(&ca.pendingCnt, -1)
+		default:
+			ch.cond.Wait()
+		}
+	}
+	return true
+}
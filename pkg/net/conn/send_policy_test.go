@@ -0,0 +1,67 @@
+package conn
+
+import (
+	"testing"
+	"time"
+
+	"github.com/skycoin/skywire/pkg/net/msg"
+)
+
+func TestSendPolicy(t *testing.T) {
+	ca := newCA()
+	channel := ca.newPendingChannel()
+	if err := ca.setChannelPolicy(channel+1, 2, SendError); err == nil {
+		t.Error("policy set for a channel that does not exist")
+	}
+	newMsg := func() *msg.UDPMessage { return msg.NewUDPWithoutSeq(msg.TYPE_NORMAL, []byte("m")) }
+
+	ca.setChannelPolicy(channel, 2, SendError)
+	for i := 0; i < 2; i++ {
+		if err := ca.addToPendingChannel(channel, newMsg()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ca.addToPendingChannel(channel, newMsg()); err != ErrSendQueueFull {
+		t.Fatalf("write to a full queue err %v", err)
+	}
+	if q, _ := ca.getChannelQueue(channel); q.Queued != 2 || q.Max != 2 || q.Dropped != 1 || q.Policy != "error" {
+		t.Fatalf("queue %+v", q)
+	}
+
+	// the oldest message not sent is dropped for the new one
+	ca.setChannelPolicy(channel, 2, SendDropOldest)
+	newest := newMsg()
+	if err := ca.addToPendingChannel(channel, newest); err != nil {
+		t.Fatal(err)
+	}
+	if q, _ := ca.getChannelQueue(channel); q.Queued != 2 || q.Dropped != 2 {
+		t.Fatalf("queue %+v", q)
+	}
+	ca.popMessage()
+	if m := ca.popMessage(); m != newest {
+		t.Fatal("the newest message was dropped")
+	}
+
+	// waits until a message is sent
+	ca.setChannelPolicy(channel, 1, SendBlock)
+	ca.addToPendingChannel(channel, newMsg())
+	done := make(chan error)
+	go func() {
+		done <- ca.addToPendingChannel(channel, newMsg())
+	}()
+	select {
+	case <-done:
+		t.Fatal("write to a full queue did not wait")
+	case <-time.After(50 * time.Millisecond):
+	}
+	ca.popMessage()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	// back to the default size
+	ca.setChannelPolicy(channel, 0, SendBlock)
+	if q, _ := ca.getChannelQueue(channel); q.Max != 3 || q.Policy != "block" {
+		t.Fatalf("queue %+v", q)
+	}
+}
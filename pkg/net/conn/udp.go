@@ -226,7 +226,10 @@ func (c *UDPConn) writeToChannel(channel int, bytes []byte, msgt byte) (err erro
 
 func (c *UDPConn) addToChannel(channel int, bytes []byte, msgt byte) (err error) {
 	m := msg.NewUDPWithoutSeq(msgt, bytes)
-	c.addToPendingChannel(channel, m)
+	err = c.addToPendingChannel(channel, m)
+	if err != nil {
+		return
+	}
 	c.pacingChan <- struct{}{}
 	return
 }
@@ -258,7 +261,11 @@ func (c *UDPConn) resendMsg(m *msg.UDPMessage) (err error) {
 	m.Loss()
 	c.GetContextLogger().Debugf("resendMsg %s", m)
 	c.addToResendChannel(m)
-	c.pacingChan <- struct{}{}
+	// a wake up of the write loop pending is enough
+	select {
+	case c.pacingChan <- struct{}{}:
+	default:
+	}
 	return
 }
 
@@ -312,11 +319,13 @@ func (c *UDPConn) writePendingMsgs() (err error) {
 		c.pacingTimer.Reset(d)
 		c.pacingTimerMutex.Unlock()
 		if tx {
-			c.transmitted(m)
+			// once transmitted the message can be acked and its bytes put back to the pool,
+			// they are encoded before
 			ps, err := c.fecEncoder.encode(pkgBytes[msg.PKG_HEADER_SIZE:])
 			if err != nil {
 				return err
 			}
+			c.transmitted(m)
 			if len(ps) > 0 {
 				for _, v := range ps {
 					err = c.WriteBytes(fec(v, c.GetNextSeq()))
@@ -466,7 +475,7 @@ func (c *UDPConn) WriteBytes(bytes []byte) (err error) {
 	binary.BigEndian.PutUint32(bytes[msg.PKG_CRC32_BEGIN:], checksum)
 	l := len(bytes)
 	c.AddSentBytes(l)
-	n, err := c.UdpConn.WriteToUDP(bytes, c.addr)
+	n, err := c.writeToPeer(bytes)
 	if DEBUG_DATA_HEX {
 		c.GetContextLogger().Debugf("write out %x", bytes)
 	}
@@ -479,7 +488,7 @@ func (c *UDPConn) WriteBytes(bytes []byte) (err error) {
 func (c *UDPConn) WriteExt(bytes []byte) (err error) {
 	l := len(bytes)
 	c.AddSentBytes(l)
-	n, err := c.UdpConn.WriteToUDP(bytes, c.addr)
+	n, err := c.writeToPeer(bytes)
 	if DEBUG_DATA_HEX {
 		c.GetContextLogger().Debugf("write out %x", bytes)
 	}
@@ -489,6 +498,14 @@ func (c *UDPConn) WriteExt(bytes []byte) (err error) {
 	return
 }
 
+// writeToPeer sends a packet, a dialed socket is connected to the peer already
+func (c *UDPConn) writeToPeer(bytes []byte) (int, error) {
+	if c.UdpConn.RemoteAddr() != nil {
+		return c.UdpConn.Write(bytes)
+	}
+	return c.UdpConn.WriteToUDP(bytes, c.addr)
+}
+
 func (c *UDPConn) Ack(seq uint32) error {
 	c.lastAckMtx.Lock()
 	c.lastAck = seq
@@ -626,6 +643,43 @@ func (c *UDPConn) String() string {
 	)
 }
 
+// SetSelectiveRetransmission resends a message as soon as QUICK_LOST_THRESH later messages
+// were acked, the acks carry the messages received after a gap, instead of waiting for the
+// rto of the message
+func (c *UDPConn) SetSelectiveRetransmission(enable bool) {
+	c.SetQuickLost(enable)
+}
+
+// ARQStats are the counters of the retransmissions of a udp connection
+type ARQStats struct {
+	// messages resent after their rto
+	RTOResends uint32 `json:"rto_resends"`
+	// messages resent after later messages were acked
+	LossResends uint32 `json:"loss_resends"`
+	Acks        uint32 `json:"acks"`
+	// acks of messages acked already, resent in vain
+	OverAcks uint32 `json:"over_acks"`
+	// smallest recent round trip time and the current rto in ms
+	RTT int64 `json:"rtt_ms"`
+	RTO int64 `json:"rto_ms"`
+	// congestion window in messages and pacing rate in bytes per second
+	Cwnd       uint32 `json:"cwnd"`
+	PacingRate uint64 `json:"pacing_rate"`
+}
+
+func (c *UDPConn) GetARQStats() ARQStats {
+	return ARQStats{
+		RTOResends:  atomic.LoadUint32(&c.rtoResendCount),
+		LossResends: atomic.LoadUint32(&c.lossResendCount),
+		Acks:        atomic.LoadUint32(&c.ackCount),
+		OverAcks:    atomic.LoadUint32(&c.overAckCount),
+		RTT:         int64(c.getRTT() / time.Millisecond),
+		RTO:         int64(c.getRTO() / time.Millisecond),
+		Cwnd:        c.getCwnd(),
+		PacingRate:  c.getPacingRate(),
+	}
+}
+
 func (c *UDPConn) GetRemoteAddr() net.Addr {
 	return c.addr
 }
@@ -659,18 +713,16 @@ func (c *UDPConn) delMsg(seq uint32, ignore bool) error {
 			c.updateRTT(um.GetRTT())
 		}
 		c.updateDeliveryRate(um)
-		if QUICK_LOST_ENABLE {
-			if len(msgs) > 1 {
-				c.GetContextLogger().Debugf("resend loss msgs %v", msgs)
-				for _, msg := range msgs {
-					err := c.resendMsg(msg)
-					if err != nil {
-						c.SetStatusToError(err)
-						c.Close()
-						return err
-					}
-					c.AddLossResendCount()
+		if len(msgs) > 0 {
+			c.GetContextLogger().Debugf("resend loss msgs %v", msgs)
+			for _, msg := range msgs {
+				err := c.resendMsg(msg)
+				if err != nil {
+					c.SetStatusToError(err)
+					c.Close()
+					return err
 				}
+				c.AddLossResendCount()
 			}
 		}
 		c.UpdateLastAck(seq)
@@ -716,6 +768,11 @@ func (c *UDPConn) getRTT() time.Duration {
 	return time.Duration(atomic.LoadInt64((*int64)(&c.rtt)))
 }
 
+// GetRTT returns the smallest round trip time of the recent messages, 0 before the first ack
+func (c *UDPConn) GetRTT() time.Duration {
+	return c.getRTT()
+}
+
 type rttSampler struct {
 	tree  *btree.BTree
 	ring  []rtt
@@ -925,18 +982,22 @@ type ca struct {
 }
 
 type pdChan struct {
-	pd    *btree.BTree
-	seq   uint32
-	mtx   sync.Mutex
-	cond  *sync.Cond
-	maxPd int
-	end   bool
+	pd           *btree.BTree
+	seq          uint32
+	mtx          sync.Mutex
+	cond         *sync.Cond
+	maxPd        int
+	defaultMaxPd int
+	policy       SendPolicy
+	dropped      uint64
+	end          bool
 }
 
 func newPdChan(max int) *pdChan {
 	pd := &pdChan{
-		pd:    btree.New(2),
-		maxPd: max,
+		pd:           btree.New(2),
+		maxPd:        max,
+		defaultMaxPd: max,
 	}
 	pd.cond = sync.NewCond(&pd.mtx)
 	return pd
@@ -1021,7 +1082,7 @@ func (c *UDPConn) NewPendingChannel() (channel int) {
 	return c.ca.newPendingChannel()
 }
 
-func (ca *ca) addToPendingChannel(channel int, m *msg.UDPMessage) {
+func (ca *ca) addToPendingChannel(channel int, m *msg.UDPMessage) (err error) {
 	ca.bifMtx.RLock()
 	ch, ok := ca.bifPdChans[channel]
 	ca.bifMtx.RUnlock()
@@ -1030,14 +1091,16 @@ func (ca *ca) addToPendingChannel(channel int, m *msg.UDPMessage) {
 	}
 
 	ch.mtx.Lock()
-	for ch.pd.Len() >= ch.maxPd {
-		ch.cond.Wait()
+	if !ca.makeRoom(ch) {
+		ch.mtx.Unlock()
+		return ErrSendQueueFull
 	}
 	ch.seq++
 	m.SetChannelSeq(channel, ch.seq)
 	atomic.AddInt32(&ca.pendingCnt, 1)
 	ch.pd.ReplaceOrInsert(m)
 	ch.mtx.Unlock()
+	return
 }
 
 func (ca *ca) addToResendChannel(m *msg.UDPMessage) {
@@ -0,0 +1,278 @@
+// Package conntest holds the conformance tests every transport implementation of
+// conn.Connection has to pass, in the spirit of golang.org/x/net/nettest. A transport
+// package runs them on pairs of its connections:
+//
+//	func TestConformance(t *testing.T) {
+//		conntest.TestConnection(t, makePipe)
+//	}
+//
+// The connections carry messages, a message up to conn.MAX_UDP_PACKAGE_SIZE arrives whole,
+// a larger one up to msg.MAX_MESSAGE_SIZE may arrive split but in order. The connections have no deadlines, the
+// suite bounds every receive with a timeout instead.
+package conntest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/skycoin/skywire/pkg/net/conn"
+	"github.com/skycoin/skywire/pkg/net/msg"
+)
+
+// MakePipe creates two connections to each other, c1 dialed and c2 accepted, stop closes them and whatever the pipe
+// needed, e.g. the listener
+type MakePipe func() (c1, c2 conn.Connection, stop func(), err error)
+
+// a message not received in this time fails the test
+var receiveTimeout = 10 * time.Second
+
+// TestConnection runs the conformance tests on connections created by mp
+func TestConnection(t *testing.T, mp MakePipe) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, c1, c2 conn.Connection)
+	}{
+		{"BasicIO", testBasicIO},
+		{"Ordering", testOrdering},
+		{"LargeFrames", testLargeFrames},
+		{"ConcurrentReadWrite", testConcurrentReadWrite},
+		{"NoSpuriousData", testNoSpuriousData},
+		{"Counters", testCounters},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c1, c2, stop, err := mp()
+			if err != nil {
+				t.Fatalf("make pipe: %v", err)
+			}
+			defer stop()
+			test.fn(t, c1, c2)
+		})
+	}
+	// the closes end the pipe, each runs on a new one
+	t.Run("CloseSemantics", func(t *testing.T) {
+		c1, c2, stop, err := mp()
+		if err != nil {
+			t.Fatalf("make pipe: %v", err)
+		}
+		defer stop()
+		testCloseSemantics(t, c1, c2)
+	})
+	for _, dir := range []string{"Dialer", "Acceptor"} {
+		t.Run("PeerClose"+dir, func(t *testing.T) {
+			c1, c2, stop, err := mp()
+			if err != nil {
+				t.Fatalf("make pipe: %v", err)
+			}
+			defer stop()
+			if dir == "Acceptor" {
+				c1, c2 = c2, c1
+			}
+			testPeerClose(t, c1, c2)
+		})
+	}
+}
+
+// receive returns the next message of c
+func receive(t *testing.T, c conn.Connection) []byte {
+	t.Helper()
+	m, err := receiveMessage(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func receiveMessage(c conn.Connection) ([]byte, error) {
+	select {
+	case m, ok := <-c.GetChanIn():
+		if !ok {
+			return nil, errors.New("connection closed while receiving")
+		}
+		return m, nil
+	case <-time.After(receiveTimeout):
+		return nil, errors.New("receive timeout")
+	}
+}
+
+// receiveBytes returns the next n bytes of c, the messages are joined
+func receiveBytes(t *testing.T, c conn.Connection, n int) []byte {
+	t.Helper()
+	b := make([]byte, 0, n)
+	for len(b) < n {
+		b = append(b, receive(t, c)...)
+	}
+	if len(b) > n {
+		t.Fatalf("received %d bytes, expected %d", len(b), n)
+	}
+	return b
+}
+
+func randomBytes(r *rand.Rand, n int) []byte {
+	b := make([]byte, n)
+	r.Read(b)
+	return b
+}
+
+func testBasicIO(t *testing.T, c1, c2 conn.Connection) {
+	r := rand.New(rand.NewSource(1))
+	for _, pair := range [][2]conn.Connection{{c1, c2}, {c2, c1}} {
+		var sent []byte
+		for i := 0; i < 100; i++ {
+			m := randomBytes(r, 1+r.Intn(conn.MAX_UDP_PACKAGE_SIZE))
+			sent = append(sent, m...)
+			if err := pair[0].Write(m); err != nil {
+				t.Fatalf("write %d: %v", i, err)
+			}
+		}
+		if got := receiveBytes(t, pair[1], len(sent)); !bytes.Equal(got, sent) {
+			t.Fatal("received bytes differ from the sent ones")
+		}
+	}
+}
+
+func testOrdering(t *testing.T, c1, c2 conn.Connection) {
+	const n = 1000
+	go func() {
+		m := make([]byte, 4)
+		for i := uint32(0); i < n; i++ {
+			binary.BigEndian.PutUint32(m, i)
+			if c1.Write(append([]byte(nil), m...)) != nil {
+				return
+			}
+		}
+	}()
+	for i := uint32(0); i < n; i++ {
+		m := receive(t, c2)
+		if len(m) != 4 {
+			t.Fatalf("message %d of %d bytes, messages must arrive whole", i, len(m))
+		}
+		if got := binary.BigEndian.Uint32(m); got != i {
+			t.Fatalf("message %d arrived as %d", got, i)
+		}
+	}
+}
+
+func testLargeFrames(t *testing.T, c1, c2 conn.Connection) {
+	r := rand.New(rand.NewSource(2))
+	for _, size := range []int{conn.MAX_UDP_PACKAGE_SIZE, conn.MAX_UDP_PACKAGE_SIZE + 1, 3*conn.MAX_UDP_PACKAGE_SIZE + 7, msg.MAX_MESSAGE_SIZE} {
+		m := randomBytes(r, size)
+		go c1.Write(append([]byte(nil), m...))
+		if got := receiveBytes(t, c2, size); !bytes.Equal(got, m) {
+			t.Fatalf("frame of %d bytes received corrupted", size)
+		}
+	}
+}
+
+// each writer numbers its messages, the messages of a writer arrive in order
+func testConcurrentReadWrite(t *testing.T, c1, c2 conn.Connection) {
+	const writers, messages = 4, 200
+	var wg sync.WaitGroup
+	for _, pair := range [][2]conn.Connection{{c1, c2}, {c2, c1}} {
+		for w := 0; w < writers; w++ {
+			wg.Add(1)
+			go func(c conn.Connection, w byte) {
+				defer wg.Done()
+				for i := uint32(0); i < messages; i++ {
+					m := make([]byte, 5, 64)
+					m[0] = w
+					binary.BigEndian.PutUint32(m[1:], i)
+					if c.Write(m) != nil {
+						return
+					}
+				}
+			}(pair[0], byte(w))
+		}
+		wg.Add(1)
+		go func(c conn.Connection) {
+			defer wg.Done()
+			next := make([]uint32, writers)
+			for i := 0; i < writers*messages; i++ {
+				m, err := receiveMessage(c)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if len(m) != 5 || int(m[0]) >= writers {
+					t.Errorf("invalid message %x", m)
+					return
+				}
+				if got := binary.BigEndian.Uint32(m[1:]); got != next[m[0]] {
+					t.Errorf("writer %d message %d arrived as %d", m[0], got, next[m[0]])
+					return
+				}
+				next[m[0]]++
+			}
+		}(pair[1])
+	}
+	wg.Wait()
+}
+
+func testNoSpuriousData(t *testing.T, c1, c2 conn.Connection) {
+	for _, c := range []conn.Connection{c1, c2} {
+		select {
+		case m := <-c.GetChanIn():
+			t.Fatalf("received %x without a write", m)
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+}
+
+func testCounters(t *testing.T, c1, c2 conn.Connection) {
+	sent, received := c1.GetSentBytes(), c2.GetReceivedBytes()
+	m := make([]byte, 100)
+	if err := c1.Write(m); err != nil {
+		t.Fatal(err)
+	}
+	receiveBytes(t, c2, len(m))
+	if c1.GetSentBytes()-sent < uint64(len(m)) {
+		t.Fatalf("%d bytes counted as sent for %d", c1.GetSentBytes()-sent, len(m))
+	}
+	if c2.GetReceivedBytes()-received < uint64(len(m)) {
+		t.Fatalf("%d bytes counted as received for %d", c2.GetReceivedBytes()-received, len(m))
+	}
+	if c2.GetLastTime() == 0 {
+		t.Fatal("no last receive time")
+	}
+}
+
+func testCloseSemantics(t *testing.T, c1, c2 conn.Connection) {
+	if c1.IsClosed() {
+		t.Fatal("closed before Close")
+	}
+	c1.Close()
+	// a second close is harmless
+	c1.Close()
+	if !c1.IsClosed() {
+		t.Fatal("not closed after Close")
+	}
+	select {
+	case <-c1.GetDisconnectedChan():
+	default:
+		t.Fatal("disconnected channel open after Close")
+	}
+	c1.WaitForDisconnected()
+	select {
+	case _, ok := <-c1.GetChanIn():
+		if ok {
+			t.Fatal("message received after Close")
+		}
+	case <-time.After(receiveTimeout):
+		t.Fatal("in channel open after Close")
+	}
+}
+
+// the peer of a closed connection notices and closes too
+func testPeerClose(t *testing.T, c, peer conn.Connection) {
+	c.Close()
+	select {
+	case <-peer.GetDisconnectedChan():
+	case <-time.After(receiveTimeout):
+		t.Fatal("peer not disconnected after Close")
+	}
+}
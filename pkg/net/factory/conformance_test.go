@@ -0,0 +1,114 @@
+package factory
+
+import (
+	"crypto/aes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/skycoin/skycoin/src/cipher"
+	"github.com/skycoin/skywire/pkg/net/conn"
+	"github.com/skycoin/skywire/pkg/net/conntest"
+)
+
+const acceptTimeout = 5 * time.Second
+
+func TestTCPConformance(t *testing.T) {
+	conntest.TestConnection(t, func() (c1, c2 conn.Connection, stop func(), err error) {
+		f := NewTCPFactory()
+		accepted := make(chan *Connection, 1)
+		f.AcceptedCallback = func(c *Connection) {
+			accepted <- c
+		}
+		err = f.Listen("127.0.0.1:0")
+		if err != nil {
+			return
+		}
+		dialed, err := f.Connect(f.listener.Addr().String())
+		if err != nil {
+			f.Close()
+			return
+		}
+		select {
+		case c := <-accepted:
+			c1, c2 = dialed, c
+		case <-time.After(acceptTimeout):
+			f.Close()
+			err = errors.New("accept timeout")
+			return
+		}
+		stop = func() {
+			c1.Close()
+			c2.Close()
+			f.Close()
+		}
+		return
+	})
+}
+
+func TestUDPConformance(t *testing.T) {
+	conntest.TestConnection(t, func() (c1, c2 conn.Connection, stop func(), err error) {
+		f := NewUDPFactory()
+		accepted := make(chan *Connection, 1)
+		f.AcceptedCallback = func(c *Connection) {
+			accepted <- c
+		}
+		err = f.Listen("127.0.0.1:0")
+		if err != nil {
+			return
+		}
+		dialed, err := f.Connect(f.listener.LocalAddr().String())
+		if err != nil {
+			f.Close()
+			return
+		}
+		// the udp connections only carry encrypted messages, as after the handshake
+		pk1, sk1 := cipher.GenerateKeyPair()
+		pk2, sk2 := cipher.GenerateKeyPair()
+		iv := cipher.RandByte(aes.BlockSize)
+		crypto := func(c conn.Connection, pk cipher.PubKey, sk cipher.SecKey, target cipher.PubKey) {
+			cr := conn.NewCrypto(pk, sk)
+			if err = cr.SetTargetKey(target); err == nil {
+				err = cr.Init(iv)
+			}
+			c.SetCrypto(cr)
+		}
+		crypto(dialed, pk1, sk1, pk2)
+		if err != nil {
+			f.Close()
+			return
+		}
+		// the listener learns of the connection from its first message
+		err = dialed.Write([]byte("hello"))
+		if err != nil {
+			f.Close()
+			return
+		}
+		select {
+		case c := <-accepted:
+			c1, c2 = dialed, c
+		case <-time.After(acceptTimeout):
+			f.Close()
+			err = errors.New("accept timeout")
+			return
+		}
+		crypto(c2, pk2, sk2, pk1)
+		if err != nil {
+			f.Close()
+			return
+		}
+		select {
+		case <-c2.GetChanIn():
+		case <-time.After(acceptTimeout):
+			f.Close()
+			err = errors.New("first message not received")
+			return
+		}
+		stop = func() {
+			c1.Close()
+			c2.Close()
+			f.Close()
+		}
+		return
+	})
+}
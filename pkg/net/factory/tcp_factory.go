@@ -2,15 +2,38 @@ package factory
 
 import (
 	"net"
+	"os"
+	"syscall"
+	"time"
 
 	"github.com/sirupsen/logrus"
 
 	"github.com/skycoin/skywire/pkg/net/client"
 	"github.com/skycoin/skywire/pkg/net/server"
+	"github.com/skycoin/skywire/pkg/net/util"
 )
 
+const acceptRetryDelay = 100 * time.Millisecond
+
+// isOutOfFiles tells if accept failed for the lack of descriptors, the errno sits in an
+// *os.SyscallError in the *net.OpError
+func isOutOfFiles(err error) bool {
+	if e, ok := err.(*net.OpError); ok {
+		err = e.Err
+	}
+	if e, ok := err.(*os.SyscallError); ok {
+		err = e.Err
+	}
+	errno, ok := err.(syscall.Errno)
+	return ok && (errno == syscall.EMFILE || errno == syscall.ENFILE)
+}
+
+// Dialer connects to an address like net.Dial
+type Dialer func(network, address string) (net.Conn, error)
+
 type TCPFactory struct {
 	listener *net.TCPListener
+	dialer   Dialer
 
 	FactoryCommonFields
 }
@@ -28,6 +51,11 @@ func (factory *TCPFactory) Listen(address string) error {
 	if err != nil {
 		return err
 	}
+	return factory.ListenOn(ln)
+}
+
+// ListenOn accepts connections on an existing listener, e.g. a socket passed by the service manager
+func (factory *TCPFactory) ListenOn(ln *net.TCPListener) error {
 	factory.fieldsMutex.Lock()
 	factory.listener = ln
 	factory.fieldsMutex.Unlock()
@@ -36,14 +64,33 @@ func (factory *TCPFactory) Listen(address string) error {
 			c, err := ln.AcceptTCP()
 			if err != nil {
 				logrus.Errorf("AcceptTCP err %v", err)
+				// out of descriptors, wait for some to be closed instead of giving up the listener
+				if isOutOfFiles(err) {
+					time.Sleep(acceptRetryDelay)
+					continue
+				}
 				return
 			}
+			// the accepted socket is open already, the reserve keeps the others working
+			err = util.ReserveFDs(0)
+			if err != nil {
+				logrus.Errorf("refused connection from %s: %v", c.RemoteAddr(), err)
+				c.Close()
+				continue
+			}
 			factory.createConn(c)
 		}
 	}()
 	return nil
 }
 
+// SetDialer connects with d instead of net.Dial, nil for net.Dial again
+func (factory *TCPFactory) SetDialer(d Dialer) {
+	factory.fieldsMutex.Lock()
+	factory.dialer = d
+	factory.fieldsMutex.Unlock()
+}
+
 func (factory *TCPFactory) Close() error {
 	factory.FactoryCommonFields.Close()
 	factory.fieldsMutex.RLock()
@@ -65,7 +112,17 @@ func (factory *TCPFactory) createConn(c *net.TCPConn) *Connection {
 }
 
 func (factory *TCPFactory) Connect(address string) (conn *Connection, err error) {
-	c, err := net.Dial("tcp", address)
+	err = util.ReserveFDs(1)
+	if err != nil {
+		return
+	}
+	factory.fieldsMutex.RLock()
+	dial := factory.dialer
+	factory.fieldsMutex.RUnlock()
+	if dial == nil {
+		dial = net.Dial
+	}
+	c, err := dial("tcp", address)
 	if err != nil {
 		return
 	}
@@ -0,0 +1,32 @@
+package factory
+
+import (
+	"errors"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestIsOutOfFiles(t *testing.T) {
+	accept := func(errno error) error {
+		return &net.OpError{Op: "accept", Net: "tcp", Err: os.NewSyscallError("accept", errno)}
+	}
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{accept(syscall.EMFILE), true},
+		{accept(syscall.ENFILE), true},
+		{os.NewSyscallError("accept", syscall.EMFILE), true},
+		{syscall.ENFILE, true},
+		{accept(syscall.ECONNABORTED), false},
+		{&net.OpError{Op: "accept", Net: "tcp", Err: errors.New("use of closed network connection")}, false},
+		{errors.New("too many open files"), false},
+	}
+	for _, test := range tests {
+		if got := isOutOfFiles(test.err); got != test.want {
+			t.Errorf("isOutOfFiles(%v) = %v, want %v", test.err, got, test.want)
+		}
+	}
+}
@@ -12,8 +12,12 @@ import (
 	"github.com/skycoin/skywire/pkg/net/client"
 	"github.com/skycoin/skywire/pkg/net/conn"
 	"github.com/skycoin/skywire/pkg/net/server"
+	"github.com/skycoin/skywire/pkg/net/util"
 )
 
+// ErrIdleTimeout closes the connections nothing was received on for conn.UDP_GC_PERIOD seconds
+var ErrIdleTimeout = errors.New("udp gc timeout")
+
 type UDPFactory struct {
 	listener *net.UDPConn
 	server   *server.ServerUDPConn
@@ -44,6 +48,10 @@ func (factory *UDPFactory) Listen(address string) error {
 	if err != nil {
 		return err
 	}
+	err = util.ReserveFDs(1)
+	if err != nil {
+		return err
+	}
 	udp, err := net.ListenUDP("udp", addr)
 	if err != nil {
 		return err
@@ -137,7 +145,7 @@ func (factory *UDPFactory) GC() {
 			factory.udpConnMapMutex.RLock()
 			for k, udp := range factory.udpConnMap {
 				if nowUnix-udp.GetLastTime() >= conn.UDP_GC_PERIOD {
-					udp.SetStatusToError(errors.New("udp gc timeout"))
+					udp.SetStatusToError(ErrIdleTimeout)
 					udp.Close()
 					closed = append(closed, k)
 				}
@@ -160,6 +168,10 @@ func (factory *UDPFactory) Connect(address string) (conn *Connection, err error)
 	if err != nil {
 		return
 	}
+	err = util.ReserveFDs(1)
+	if err != nil {
+		return
+	}
 	udp, err := net.DialUDP("udp", nil, addr)
 	if err != nil {
 		return
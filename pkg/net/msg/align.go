@@ -0,0 +1,15 @@
+package msg
+
+import (
+	"unsafe"
+
+	"github.com/skycoin/skywire/pkg/net/util"
+)
+
+// CheckAlign checks the 64-bit fields of the messages accessed with sync/atomic are aligned
+func CheckAlign() error {
+	var m UDPMessage
+	return util.CheckAlign64(
+		util.Field64{Name: "UDPMessage.channel", Offset: unsafe.Offsetof(m.channel)},
+	)
+}
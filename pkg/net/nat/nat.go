@@ -0,0 +1,191 @@
+// Package nat detects the NAT type of the host with STUN servers, so users
+// and the transport logic can tell why direct UDP connections fail.
+package nat
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"time"
+)
+
+type Type int
+
+const (
+	Unknown Type = iota
+	// no NAT, the host has a public address
+	Open
+	// any remote host can send to the mapped address
+	FullCone
+	// only hosts the node sent to can send to the mapped address
+	Restricted
+	// only host:port pairs the node sent to can send to the mapped address
+	PortRestricted
+	// the mapping depends on the destination, hole punching fails
+	Symmetric
+	// no UDP response from any STUN server
+	Blocked
+)
+
+func (t Type) String() string {
+	switch t {
+	case Open:
+		return "open"
+	case FullCone:
+		return "full_cone"
+	case Restricted:
+		return "restricted"
+	case PortRestricted:
+		return "port_restricted"
+	case Symmetric:
+		return "symmetric"
+	case Blocked:
+		return "blocked"
+	}
+	return "unknown"
+}
+
+// DirectUDP reports if a remote node can reach this host by udp hole punching
+func (t Type) DirectUDP() bool {
+	return t == Open || t == FullCone || t == Restricted || t == PortRestricted
+}
+
+var DefaultServers = []string{
+	"stun.l.google.com:19302",
+	"stun1.l.google.com:19302",
+	"stun.stunprotocol.org:3478",
+}
+
+const (
+	requestTimeout = 2 * time.Second
+	requestRetries = 2
+)
+
+func (t Type) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.String())
+}
+
+type Result struct {
+	Type      Type   `json:"type"`
+	Mapped    string `json:"mapped_address,omitempty"`
+	Detected  int64  `json:"detected"`
+	Error     string `json:"error,omitempty"`
+	DirectUDP bool   `json:"direct_udp"`
+}
+
+func newResult(t Type, mapped *net.UDPAddr, err error) (r *Result) {
+	r = &Result{Type: t, Detected: time.Now().Unix(), DirectUDP: t.DirectUDP()}
+	if mapped != nil {
+		r.Mapped = mapped.String()
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	return
+}
+
+// Detect classifies the NAT of the host with the tests of RFC 3489.
+// Servers that ignore CHANGE-REQUEST make cone NATs look port restricted,
+// which is the conservative answer for hole punching.
+func Detect(servers []string) *Result {
+	if len(servers) == 0 {
+		return newResult(Unknown, nil, errors.New("no stun servers"))
+	}
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return newResult(Unknown, nil, err)
+	}
+	defer conn.Close()
+
+	// test I: the mapped address seen by the first server that answers
+	var first *net.UDPAddr
+	var res *stunResponse
+	rest := servers
+	for len(rest) > 0 && res == nil {
+		first, err = net.ResolveUDPAddr("udp4", rest[0])
+		rest = rest[1:]
+		if err != nil {
+			continue
+		}
+		res, err = request(conn, first, 0)
+	}
+	if res == nil {
+		return newResult(Blocked, nil, err)
+	}
+	mapped := res.mapped
+
+	if isLocal(mapped.IP) && mapped.Port == conn.LocalAddr().(*net.UDPAddr).Port {
+		return newResult(Open, mapped, nil)
+	}
+
+	// test II: the answer from another address reaches a full cone nat
+	if _, err := request(conn, first, changeIP|changePort); err == nil {
+		return newResult(FullCone, mapped, nil)
+	}
+
+	// test I to another server: a different mapping is a symmetric nat
+	second := res.other
+	for second == nil && len(rest) > 0 {
+		second, _ = net.ResolveUDPAddr("udp4", rest[0])
+		rest = rest[1:]
+		if second != nil && second.IP.Equal(first.IP) {
+			second = nil
+		}
+	}
+	if second != nil {
+		if res2, err := request(conn, second, 0); err == nil && res2.mapped.String() != mapped.String() {
+			return newResult(Symmetric, mapped, nil)
+		}
+	}
+
+	// test III: the answer from another port reaches a restricted nat
+	if _, err := request(conn, first, changePort); err == nil {
+		return newResult(Restricted, mapped, nil)
+	}
+	return newResult(PortRestricted, mapped, nil)
+}
+
+func request(conn *net.UDPConn, server *net.UDPAddr, change uint32) (res *stunResponse, err error) {
+	req, err := newStunRequest(change)
+	if err != nil {
+		return
+	}
+	b := req.marshal()
+	buf := make([]byte, 1024)
+	for i := 0; i < requestRetries; i++ {
+		_, err = conn.WriteToUDP(b, server)
+		if err != nil {
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(requestTimeout))
+		for {
+			var n int
+			n, _, err = conn.ReadFromUDP(buf)
+			if err != nil {
+				break
+			}
+			res, err = parseStunResponse(buf[:n])
+			if err == nil && res.id == req.id {
+				return
+			}
+			res = nil
+		}
+	}
+	if err == nil {
+		err = errors.New("no stun response")
+	}
+	return
+}
+
+func isLocal(ip net.IP) bool {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok && n.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
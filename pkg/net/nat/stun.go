@@ -0,0 +1,127 @@
+package nat
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+)
+
+// minimal STUN (RFC 5389) binding client with the CHANGE-REQUEST attribute of RFC 3489
+
+const (
+	stunHeaderSize  = 20
+	stunMagicCookie = 0x2112A442
+
+	stunBindingRequest  = 0x0001
+	stunBindingResponse = 0x0101
+
+	attrMappedAddress    = 0x0001
+	attrChangeRequest    = 0x0003
+	attrChangedAddress   = 0x0005
+	attrXorMappedAddress = 0x0020
+	attrOtherAddress     = 0x802c
+
+	changeIP   = 0x04
+	changePort = 0x02
+)
+
+var errInvalidMessage = errors.New("invalid stun message")
+
+type stunRequest struct {
+	id     [12]byte
+	change uint32
+}
+
+func newStunRequest(change uint32) (r *stunRequest, err error) {
+	r = &stunRequest{change: change}
+	_, err = rand.Read(r.id[:])
+	return
+}
+
+func (r *stunRequest) marshal() []byte {
+	var attrs []byte
+	if r.change != 0 {
+		attrs = make([]byte, 8)
+		binary.BigEndian.PutUint16(attrs[0:], attrChangeRequest)
+		binary.BigEndian.PutUint16(attrs[2:], 4)
+		binary.BigEndian.PutUint32(attrs[4:], r.change)
+	}
+	b := make([]byte, stunHeaderSize, stunHeaderSize+len(attrs))
+	binary.BigEndian.PutUint16(b[0:], stunBindingRequest)
+	binary.BigEndian.PutUint16(b[2:], uint16(len(attrs)))
+	binary.BigEndian.PutUint32(b[4:], stunMagicCookie)
+	copy(b[8:], r.id[:])
+	return append(b, attrs...)
+}
+
+type stunResponse struct {
+	id [12]byte
+	// address of the request as seen by the server
+	mapped *net.UDPAddr
+	// alternate address of the server for change requests
+	other *net.UDPAddr
+}
+
+func parseStunResponse(b []byte) (r *stunResponse, err error) {
+	if len(b) < stunHeaderSize || binary.BigEndian.Uint16(b[0:]) != stunBindingResponse {
+		err = errInvalidMessage
+		return
+	}
+	length := int(binary.BigEndian.Uint16(b[2:]))
+	if len(b) < stunHeaderSize+length {
+		err = errInvalidMessage
+		return
+	}
+	r = &stunResponse{}
+	copy(r.id[:], b[8:20])
+	attrs := b[stunHeaderSize : stunHeaderSize+length]
+	for len(attrs) >= 4 {
+		t := binary.BigEndian.Uint16(attrs[0:])
+		l := int(binary.BigEndian.Uint16(attrs[2:]))
+		if len(attrs) < 4+l {
+			err = errInvalidMessage
+			return
+		}
+		v := attrs[4 : 4+l]
+		switch t {
+		case attrXorMappedAddress:
+			r.mapped = parseAddress(v, true)
+		case attrMappedAddress:
+			if r.mapped == nil {
+				r.mapped = parseAddress(v, false)
+			}
+		case attrOtherAddress, attrChangedAddress:
+			r.other = parseAddress(v, false)
+		}
+		// attributes are padded to 4 bytes
+		next := 4 + (l+3)&^3
+		if next > len(attrs) {
+			break
+		}
+		attrs = attrs[next:]
+	}
+	if r.mapped == nil {
+		err = errors.New("stun response without mapped address")
+	}
+	return
+}
+
+// only ipv4 addresses are supported
+func parseAddress(v []byte, xor bool) *net.UDPAddr {
+	if len(v) < 8 || v[1] != 0x01 {
+		return nil
+	}
+	port := binary.BigEndian.Uint16(v[2:])
+	ip := make(net.IP, 4)
+	copy(ip, v[4:8])
+	if xor {
+		port ^= stunMagicCookie >> 16
+		var cookie [4]byte
+		binary.BigEndian.PutUint32(cookie[:], stunMagicCookie)
+		for i := range ip {
+			ip[i] ^= cookie[i]
+		}
+	}
+	return &net.UDPAddr{IP: ip, Port: int(port)}
+}
@@ -0,0 +1,59 @@
+package nat
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+func TestStunRequest(t *testing.T) {
+	req, err := newStunRequest(changeIP | changePort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := req.marshal()
+	if len(b) != stunHeaderSize+8 {
+		t.Fatalf("len = %d", len(b))
+	}
+	if binary.BigEndian.Uint32(b[4:]) != stunMagicCookie {
+		t.Error("missing magic cookie")
+	}
+	if binary.BigEndian.Uint32(b[stunHeaderSize+4:]) != changeIP|changePort {
+		t.Error("wrong change request")
+	}
+}
+
+func TestParseStunResponse(t *testing.T) {
+	mapped := &net.UDPAddr{IP: net.IPv4(203, 0, 113, 7).To4(), Port: 40123}
+	var id [12]byte
+	copy(id[:], "0123456789ab")
+
+	attr := make([]byte, 12)
+	binary.BigEndian.PutUint16(attr[0:], attrXorMappedAddress)
+	binary.BigEndian.PutUint16(attr[2:], 8)
+	attr[5] = 0x01
+	binary.BigEndian.PutUint16(attr[6:], uint16(mapped.Port)^stunMagicCookie>>16)
+	binary.BigEndian.PutUint32(attr[8:], binary.BigEndian.Uint32(mapped.IP)^stunMagicCookie)
+
+	b := make([]byte, stunHeaderSize)
+	binary.BigEndian.PutUint16(b[0:], stunBindingResponse)
+	binary.BigEndian.PutUint16(b[2:], uint16(len(attr)))
+	binary.BigEndian.PutUint32(b[4:], stunMagicCookie)
+	copy(b[8:], id[:])
+	b = append(b, attr...)
+
+	res, err := parseStunResponse(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.id != id {
+		t.Error("wrong transaction id")
+	}
+	if res.mapped.String() != mapped.String() {
+		t.Errorf("mapped = %s, want %s", res.mapped, mapped)
+	}
+
+	if _, err := parseStunResponse(b[:10]); err == nil {
+		t.Error("expected an error for a short message")
+	}
+}
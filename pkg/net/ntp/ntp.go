@@ -0,0 +1,144 @@
+// Package ntp measures the offset of the local clock with SNTP (RFC 4330) servers,
+// timestamps exchanged with other hosts are only meaningful on a synchronized clock.
+package ntp
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"time"
+)
+
+var DefaultServers = []string{
+	"pool.ntp.org:123",
+	"time.google.com:123",
+	"time.cloudflare.com:123",
+}
+
+const (
+	packetSize     = 48
+	requestTimeout = 2 * time.Second
+
+	// leap indicator 0, version 4, mode 3 (client)
+	clientHeader = 0<<6 | 4<<3 | 3
+	modeServer   = 4
+)
+
+// seconds from 1900, the ntp epoch, to 1970
+const ntpEpochOffset = 2208988800
+
+var errInvalidResponse = errors.New("invalid ntp response")
+
+func toNTP(t time.Time) uint64 {
+	secs := uint64(t.Unix() + ntpEpochOffset)
+	frac := uint64(t.Nanosecond()) << 32 / uint64(time.Second)
+	return secs<<32 | frac
+}
+
+func fromNTP(ts uint64) time.Time {
+	secs := int64(ts>>32) - ntpEpochOffset
+	nanos := int64((ts & 0xffffffff) * uint64(time.Second) >> 32)
+	return time.Unix(secs, nanos)
+}
+
+type response struct {
+	origin, receive, transmit uint64
+}
+
+func parseResponse(b []byte) (r *response, err error) {
+	if len(b) < packetSize {
+		return nil, errInvalidResponse
+	}
+	// stratum 0 is a kiss-o'-death
+	if b[0]&0x7 != modeServer || b[1] == 0 {
+		return nil, errInvalidResponse
+	}
+	r = &response{
+		origin:   binary.BigEndian.Uint64(b[24:]),
+		receive:  binary.BigEndian.Uint64(b[32:]),
+		transmit: binary.BigEndian.Uint64(b[40:]),
+	}
+	return
+}
+
+// Query returns the offset of the server clock to the local clock and the round trip time
+func Query(server string) (offset, rtt time.Duration, err error) {
+	conn, err := net.DialTimeout("udp", server, requestTimeout)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(requestTimeout))
+
+	req := make([]byte, packetSize)
+	req[0] = clientHeader
+	t1 := time.Now()
+	origin := toNTP(t1)
+	binary.BigEndian.PutUint64(req[40:], origin)
+	_, err = conn.Write(req)
+	if err != nil {
+		return
+	}
+	b := make([]byte, 512)
+	n, err := conn.Read(b)
+	if err != nil {
+		return
+	}
+	t4 := time.Now()
+	res, err := parseResponse(b[:n])
+	if err != nil {
+		return
+	}
+	if res.origin != origin {
+		err = errInvalidResponse
+		return
+	}
+	t2, t3 := fromNTP(res.receive), fromNTP(res.transmit)
+	offset = (t2.Sub(t1) + t3.Sub(t4)) / 2
+	rtt = t4.Sub(t1) - t3.Sub(t2)
+	return
+}
+
+type Result struct {
+	// clock of the servers minus the local clock
+	Offset  int64  `json:"offset_ms"`
+	RTT     int64  `json:"rtt_ms"`
+	Server  string `json:"server,omitempty"`
+	Checked int64  `json:"checked"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Check queries the servers and returns the offset measured with the shortest round trip
+func Check(servers []string) (r *Result) {
+	r = &Result{Checked: time.Now().Unix()}
+	var best time.Duration = -1
+	for _, s := range servers {
+		offset, rtt, err := Query(s)
+		if err != nil {
+			r.Error = err.Error()
+			continue
+		}
+		if best < 0 || rtt < best {
+			best = rtt
+			r.Offset = int64(offset / time.Millisecond)
+			r.RTT = int64(rtt / time.Millisecond)
+			r.Server = s
+		}
+	}
+	if best >= 0 {
+		r.Error = ""
+	}
+	return
+}
+
+// Skew is the absolute offset, 0 if no server answered
+func (r *Result) Skew() time.Duration {
+	if r == nil || len(r.Server) == 0 {
+		return 0
+	}
+	d := time.Duration(r.Offset) * time.Millisecond
+	if d < 0 {
+		d = -d
+	}
+	return d
+}
@@ -0,0 +1,75 @@
+package ntp
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTimestamp(t *testing.T) {
+	now := time.Unix(1530000000, 123456789)
+	got := fromNTP(toNTP(now))
+	if d := got.Sub(now); d > time.Microsecond || d < -time.Microsecond {
+		t.Errorf("round trip = %v, want %v", got, now)
+	}
+}
+
+// fake server whose clock is ahead by offset
+func serve(t *testing.T, offset time.Duration) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		defer conn.Close()
+		b := make([]byte, packetSize)
+		n, addr, err := conn.ReadFrom(b)
+		if err != nil || n < packetSize {
+			return
+		}
+		res := make([]byte, packetSize)
+		res[0] = 4<<3 | modeServer
+		res[1] = 2
+		copy(res[24:32], b[40:48])
+		binary.BigEndian.PutUint64(res[32:], toNTP(time.Now().Add(offset)))
+		binary.BigEndian.PutUint64(res[40:], toNTP(time.Now().Add(offset)))
+		conn.WriteTo(res, addr)
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestQuery(t *testing.T) {
+	offset, _, err := Query(serve(t, 5*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := offset - 5*time.Second; d > 100*time.Millisecond || d < -100*time.Millisecond {
+		t.Errorf("offset = %v, want 5s", offset)
+	}
+
+	r := Check([]string{serve(t, -3*time.Second)})
+	if len(r.Error) > 0 {
+		t.Fatal(r.Error)
+	}
+	if r.Skew() < 2900*time.Millisecond || r.Skew() > 3100*time.Millisecond {
+		t.Errorf("skew = %v, want 3s", r.Skew())
+	}
+}
+
+func TestParseResponse(t *testing.T) {
+	b := make([]byte, packetSize)
+	b[0] = 4<<3 | 3
+	b[1] = 2
+	if _, err := parseResponse(b); err == nil {
+		t.Error("expected an error for a client packet")
+	}
+	b[0] = 4<<3 | modeServer
+	b[1] = 0
+	if _, err := parseResponse(b); err == nil {
+		t.Error("expected an error for a kiss-o'-death")
+	}
+	if _, err := parseResponse(b[:10]); err == nil {
+		t.Error("expected an error for a short packet")
+	}
+}
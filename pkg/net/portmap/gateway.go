@@ -0,0 +1,58 @@
+package portmap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"net"
+	"os"
+	"strings"
+)
+
+// defaultGateway reads the default route on linux and guesses the .1 address of the
+// local network elsewhere
+func defaultGateway() (gw net.IP, err error) {
+	gw, err = linuxGateway()
+	if err == nil {
+		return
+	}
+	c, err := net.Dial("udp4", "8.8.8.8:53")
+	if err != nil {
+		return
+	}
+	defer c.Close()
+	ip := c.LocalAddr().(*net.UDPAddr).IP.To4()
+	if ip == nil {
+		err = errors.New("no ipv4 address")
+		return
+	}
+	gw = net.IPv4(ip[0], ip[1], ip[2], 1)
+	return
+}
+
+func linuxGateway() (gw net.IP, err error) {
+	f, err := os.Open("/proc/net/route")
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		// Iface Destination Gateway ...
+		if len(fields) < 3 || fields[1] != "00000000" {
+			continue
+		}
+		var b []byte
+		b, err = hex.DecodeString(fields[2])
+		if err != nil || len(b) != 4 {
+			continue
+		}
+		ip := make(net.IP, 4)
+		binary.BigEndian.PutUint32(ip, binary.LittleEndian.Uint32(b))
+		return ip, nil
+	}
+	err = errors.New("no default route")
+	return
+}
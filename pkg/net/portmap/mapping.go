@@ -0,0 +1,146 @@
+package portmap
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	mappingLifetime = time.Hour
+	// retry interval after a failed renewal
+	mappingRetry = time.Minute
+)
+
+// Mapping keeps a port mapped on the gateway until it is closed
+type Mapping struct {
+	mapper   Mapper
+	protocol string
+	internal int
+
+	external   int
+	externalIP net.IP
+	err        error
+	onChange   func(external string)
+	sync.RWMutex
+
+	closing chan struct{}
+	closed  sync.Once
+}
+
+// Map maps the internal port and renews the mapping at half of its lifetime,
+// onChange is called with the external host:port whenever it changes
+func Map(m Mapper, protocol string, internal int, onChange func(external string)) (mp *Mapping, err error) {
+	mp = &Mapping{
+		mapper:   m,
+		protocol: protocol,
+		internal: internal,
+		external: internal,
+		onChange: onChange,
+		closing:  make(chan struct{}),
+	}
+	err = mp.renew()
+	if err != nil {
+		return
+	}
+	go mp.keep()
+	return
+}
+
+func (mp *Mapping) renew() (err error) {
+	ip, err := mp.mapper.ExternalIP()
+	if err != nil {
+		mp.setErr(err)
+		return
+	}
+	mp.RLock()
+	want := mp.external
+	mp.RUnlock()
+	port, err := mp.mapper.AddMapping(mp.protocol, mp.internal, want, mappingLifetime)
+	if err != nil {
+		mp.setErr(err)
+		return
+	}
+	mp.Lock()
+	changed := mp.external != port || !mp.externalIP.Equal(ip)
+	mp.external = port
+	mp.externalIP = ip
+	mp.err = nil
+	mp.Unlock()
+	if changed && mp.onChange != nil {
+		mp.onChange(mp.External())
+	}
+	return
+}
+
+func (mp *Mapping) setErr(err error) {
+	mp.Lock()
+	mp.err = err
+	mp.Unlock()
+}
+
+func (mp *Mapping) keep() {
+	wait := mappingLifetime / 2
+	for {
+		select {
+		case <-mp.closing:
+			return
+		case <-time.After(wait):
+		}
+		err := mp.renew()
+		if err != nil {
+			log.Errorf("renew %s port mapping %d err: %v", mp.protocol, mp.internal, err)
+			wait = mappingRetry
+			continue
+		}
+		wait = mappingLifetime / 2
+	}
+}
+
+// External returns the mapped host:port, empty before the first mapping
+func (mp *Mapping) External() string {
+	mp.RLock()
+	defer mp.RUnlock()
+	if mp.externalIP == nil {
+		return ""
+	}
+	return net.JoinHostPort(mp.externalIP.String(), strconv.Itoa(mp.external))
+}
+
+type Status struct {
+	Protocol string `json:"protocol"`
+	Method   string `json:"method"`
+	Internal int    `json:"internal_port"`
+	External string `json:"external_address"`
+	Error    string `json:"error,omitempty"`
+}
+
+func (mp *Mapping) Status() (s Status) {
+	s = Status{
+		Protocol: mp.protocol,
+		Method:   mp.mapper.Name(),
+		Internal: mp.internal,
+		External: mp.External(),
+	}
+	mp.RLock()
+	if mp.err != nil {
+		s.Error = mp.err.Error()
+	}
+	mp.RUnlock()
+	return
+}
+
+// Close stops the renewal and deletes the mapping from the gateway
+func (mp *Mapping) Close() (err error) {
+	mp.closed.Do(func() {
+		close(mp.closing)
+		mp.RLock()
+		external := mp.external
+		mp.RUnlock()
+		err = mp.mapper.DeleteMapping(mp.protocol, mp.internal, external)
+	})
+	return
+}
@@ -0,0 +1,56 @@
+package portmap
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+type fakeMapper struct {
+	ip      net.IP
+	port    int
+	deleted bool
+}
+
+func (f *fakeMapper) Name() string { return "fake" }
+
+func (f *fakeMapper) ExternalIP() (net.IP, error) { return f.ip, nil }
+
+func (f *fakeMapper) AddMapping(protocol string, internal, external int, lifetime time.Duration) (int, error) {
+	return f.port, nil
+}
+
+func (f *fakeMapper) DeleteMapping(protocol string, internal, external int) error {
+	f.deleted = true
+	return nil
+}
+
+func TestMapping(t *testing.T) {
+	f := &fakeMapper{ip: net.IPv4(203, 0, 113, 7), port: 40000}
+	var changes []string
+	mp, err := Map(f, TCP, 5000, func(external string) {
+		changes = append(changes, external)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0] != "203.0.113.7:40000" {
+		t.Fatalf("changes = %v", changes)
+	}
+	mp.renew()
+	if len(changes) != 1 {
+		t.Fatalf("unchanged renewal reported %v", changes)
+	}
+	f.port = 40001
+	mp.renew()
+	if len(changes) != 2 || mp.External() != "203.0.113.7:40001" {
+		t.Fatalf("changes = %v", changes)
+	}
+	if s := mp.Status(); s.Method != "fake" || s.Internal != 5000 {
+		t.Errorf("status = %#v", s)
+	}
+	mp.Close()
+	if !f.deleted {
+		t.Error("mapping not deleted on close")
+	}
+}
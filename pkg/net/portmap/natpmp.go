@@ -0,0 +1,101 @@
+package portmap
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// NAT-PMP (RFC 6886)
+
+const (
+	natPMPPort    = 5351
+	natPMPTimeout = 2 * time.Second
+	natPMPRetries = 3
+)
+
+type natPMP struct {
+	gateway net.IP
+}
+
+func (n *natPMP) Name() string {
+	return "natpmp"
+}
+
+func (n *natPMP) request(req []byte, respSize int) (resp []byte, err error) {
+	c, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: n.gateway, Port: natPMPPort})
+	if err != nil {
+		return
+	}
+	defer c.Close()
+	buf := make([]byte, 64)
+	timeout := natPMPTimeout / 4
+	for i := 0; i < natPMPRetries; i++ {
+		_, err = c.Write(req)
+		if err != nil {
+			return
+		}
+		c.SetReadDeadline(time.Now().Add(timeout))
+		var l int
+		l, err = c.Read(buf)
+		if err != nil {
+			timeout *= 2
+			continue
+		}
+		if l < respSize || buf[0] != 0 || buf[1] != req[1]|0x80 {
+			err = errors.New("invalid nat-pmp response")
+			return
+		}
+		if code := binary.BigEndian.Uint16(buf[2:]); code != 0 {
+			err = fmt.Errorf("nat-pmp result code %d", code)
+			return
+		}
+		return buf[:l], nil
+	}
+	return
+}
+
+func (n *natPMP) ExternalIP() (ip net.IP, err error) {
+	resp, err := n.request([]byte{0, 0}, 12)
+	if err != nil {
+		return
+	}
+	ip = net.IPv4(resp[8], resp[9], resp[10], resp[11])
+	return
+}
+
+func natPMPOp(protocol string) (byte, error) {
+	switch protocol {
+	case UDP:
+		return 1, nil
+	case TCP:
+		return 2, nil
+	}
+	return 0, fmt.Errorf("unknown protocol %s", protocol)
+}
+
+func (n *natPMP) AddMapping(protocol string, internal, external int, lifetime time.Duration) (mapped int, err error) {
+	op, err := natPMPOp(protocol)
+	if err != nil {
+		return
+	}
+	req := make([]byte, 12)
+	req[1] = op
+	binary.BigEndian.PutUint16(req[4:], uint16(internal))
+	binary.BigEndian.PutUint16(req[6:], uint16(external))
+	binary.BigEndian.PutUint32(req[8:], uint32(lifetime/time.Second))
+	resp, err := n.request(req, 16)
+	if err != nil {
+		return
+	}
+	mapped = int(binary.BigEndian.Uint16(resp[10:]))
+	return
+}
+
+// a mapping with lifetime 0 is deleted
+func (n *natPMP) DeleteMapping(protocol string, internal, external int) (err error) {
+	_, err = n.AddMapping(protocol, internal, 0, 0)
+	return
+}
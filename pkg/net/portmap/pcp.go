@@ -0,0 +1,117 @@
+package portmap
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// PCP (RFC 6887) MAP requests, used by gateways that replaced NAT-PMP
+
+const (
+	pcpVersion = 2
+	pcpOpMap   = 1
+)
+
+type pcp struct {
+	gateway net.IP
+	nonce   [12]byte
+}
+
+func (p *pcp) Name() string {
+	return "pcp"
+}
+
+func pcpProtocol(protocol string) (byte, error) {
+	switch protocol {
+	case TCP:
+		return 6, nil
+	case UDP:
+		return 17, nil
+	}
+	return 0, fmt.Errorf("unknown protocol %s", protocol)
+}
+
+func (p *pcp) mapRequest(proto byte, internal, external int, lifetime time.Duration) (ip net.IP, mapped int, err error) {
+	local, err := localIP(p.gateway)
+	if err != nil {
+		return
+	}
+	if p.nonce == [12]byte{} {
+		_, err = rand.Read(p.nonce[:])
+		if err != nil {
+			return
+		}
+	}
+	req := make([]byte, 60)
+	req[0] = pcpVersion
+	req[1] = pcpOpMap
+	binary.BigEndian.PutUint32(req[4:], uint32(lifetime/time.Second))
+	copy(req[8:24], local.To16())
+	copy(req[24:36], p.nonce[:])
+	req[36] = proto
+	binary.BigEndian.PutUint16(req[40:], uint16(internal))
+	binary.BigEndian.PutUint16(req[42:], uint16(external))
+	// suggested external address ::ffff:0.0.0.0
+	req[54], req[55] = 0xff, 0xff
+
+	c, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: p.gateway, Port: natPMPPort})
+	if err != nil {
+		return
+	}
+	defer c.Close()
+	buf := make([]byte, 1100)
+	timeout := natPMPTimeout / 4
+	for i := 0; i < natPMPRetries; i++ {
+		_, err = c.Write(req)
+		if err != nil {
+			return
+		}
+		c.SetReadDeadline(time.Now().Add(timeout))
+		var l int
+		l, err = c.Read(buf)
+		if err != nil {
+			timeout *= 2
+			continue
+		}
+		if l < 60 || buf[0] != pcpVersion || buf[1] != pcpOpMap|0x80 {
+			err = errors.New("invalid pcp response")
+			return
+		}
+		if buf[3] != 0 {
+			err = fmt.Errorf("pcp result code %d", buf[3])
+			return
+		}
+		mapped = int(binary.BigEndian.Uint16(buf[42:]))
+		ip = net.IP(append([]byte(nil), buf[44:60]...))
+		return
+	}
+	return
+}
+
+// PCP has no external address request, a short lived mapping of the discard port reveals it
+func (p *pcp) ExternalIP() (ip net.IP, err error) {
+	ip, _, err = p.mapRequest(17, 9, 9, time.Second)
+	return
+}
+
+func (p *pcp) AddMapping(protocol string, internal, external int, lifetime time.Duration) (mapped int, err error) {
+	proto, err := pcpProtocol(protocol)
+	if err != nil {
+		return
+	}
+	_, mapped, err = p.mapRequest(proto, internal, external, lifetime)
+	return
+}
+
+func (p *pcp) DeleteMapping(protocol string, internal, external int) (err error) {
+	proto, err := pcpProtocol(protocol)
+	if err != nil {
+		return
+	}
+	_, _, err = p.mapRequest(proto, internal, 0, 0)
+	return
+}
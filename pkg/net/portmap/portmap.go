@@ -0,0 +1,57 @@
+// Package portmap maps ports on the internet gateway with NAT-PMP, PCP or UPnP IGD,
+// so remote nodes can open direct transports to a node behind a NAT.
+package portmap
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// Mapper adds and removes port mappings on the gateway
+type Mapper interface {
+	// Name of the protocol, natpmp, pcp or upnp
+	Name() string
+	ExternalIP() (net.IP, error)
+	// AddMapping maps the internal port and returns the external port chosen by the gateway
+	AddMapping(protocol string, internal, external int, lifetime time.Duration) (mapped int, err error)
+	DeleteMapping(protocol string, internal, external int) error
+}
+
+const (
+	TCP = "tcp"
+	UDP = "udp"
+)
+
+var ErrNoGateway = errors.New("no gateway supports port mapping")
+
+// Discover returns the first protocol the gateway supports, NAT-PMP or PCP before UPnP
+func Discover() (m Mapper, err error) {
+	gw, err := defaultGateway()
+	if err == nil {
+		pmp := &natPMP{gateway: gw}
+		if _, err = pmp.ExternalIP(); err == nil {
+			return pmp, nil
+		}
+		p := &pcp{gateway: gw}
+		if _, err = p.ExternalIP(); err == nil {
+			return p, nil
+		}
+	}
+	u, err := discoverUPnP()
+	if err == nil {
+		return u, nil
+	}
+	return nil, ErrNoGateway
+}
+
+// local address used to reach the gateway (gw)
+func localIP(gw net.IP) (ip net.IP, err error) {
+	c, err := net.Dial("udp4", net.JoinHostPort(gw.String(), "9"))
+	if err != nil {
+		return
+	}
+	defer c.Close()
+	ip = c.LocalAddr().(*net.UDPAddr).IP
+	return
+}
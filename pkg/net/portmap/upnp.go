@@ -0,0 +1,227 @@
+package portmap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UPnP Internet Gateway Device, WANIPConnection and WANPPPConnection services
+
+const (
+	ssdpAddr    = "239.255.255.250:1900"
+	ssdpTimeout = 3 * time.Second
+	soapTimeout = 5 * time.Second
+)
+
+var upnpServices = []string{
+	"urn:schemas-upnp-org:service:WANIPConnection:2",
+	"urn:schemas-upnp-org:service:WANIPConnection:1",
+	"urn:schemas-upnp-org:service:WANPPPConnection:1",
+}
+
+type upnp struct {
+	controlURL string
+	service    string
+	localIP    net.IP
+}
+
+func (u *upnp) Name() string {
+	return "upnp"
+}
+
+type upnpDevice struct {
+	Services []struct {
+		ServiceType string `xml:"serviceType"`
+		ControlURL  string `xml:"controlURL"`
+	} `xml:"serviceList>service"`
+	Devices []upnpDevice `xml:"deviceList>device"`
+}
+
+func (d *upnpDevice) find(service string) (controlURL string, ok bool) {
+	for _, s := range d.Services {
+		if s.ServiceType == service {
+			return s.ControlURL, true
+		}
+	}
+	for i := range d.Devices {
+		if controlURL, ok = d.Devices[i].find(service); ok {
+			return
+		}
+	}
+	return
+}
+
+func discoverUPnP() (u *upnp, err error) {
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	dst, err := net.ResolveUDPAddr("udp4", ssdpAddr)
+	if err != nil {
+		return
+	}
+	req := "M-SEARCH * HTTP/1.1\r\n" +
+		"HOST: " + ssdpAddr + "\r\n" +
+		"ST: urn:schemas-upnp-org:device:InternetGatewayDevice:1\r\n" +
+		"MAN: \"ssdp:discover\"\r\n" +
+		"MX: 2\r\n\r\n"
+	_, err = conn.WriteToUDP([]byte(req), dst)
+	if err != nil {
+		return
+	}
+	conn.SetReadDeadline(time.Now().Add(ssdpTimeout))
+	buf := make([]byte, 2048)
+	for {
+		var n int
+		n, _, err = conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		resp, e := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+		if e != nil {
+			continue
+		}
+		location := resp.Header.Get("Location")
+		resp.Body.Close()
+		if len(location) == 0 {
+			continue
+		}
+		u, e = newUPnP(location)
+		if e == nil {
+			return u, nil
+		}
+	}
+}
+
+func newUPnP(location string) (u *upnp, err error) {
+	client := &http.Client{Timeout: soapTimeout}
+	res, err := client.Get(location)
+	if err != nil {
+		return
+	}
+	defer res.Body.Close()
+	var root struct {
+		Device upnpDevice `xml:"device"`
+	}
+	err = xml.NewDecoder(res.Body).Decode(&root)
+	if err != nil {
+		return
+	}
+	base, err := url.Parse(location)
+	if err != nil {
+		return
+	}
+	host, _, err := net.SplitHostPort(base.Host)
+	if err != nil {
+		return
+	}
+	local, err := localIP(net.ParseIP(host))
+	if err != nil {
+		return
+	}
+	for _, s := range upnpServices {
+		if control, ok := root.Device.find(s); ok {
+			var ref *url.URL
+			ref, err = url.Parse(control)
+			if err != nil {
+				return
+			}
+			return &upnp{controlURL: base.ResolveReference(ref).String(), service: s, localIP: local}, nil
+		}
+	}
+	err = errors.New("gateway has no wan connection service")
+	return
+}
+
+func (u *upnp) soap(action string, args [][2]string) (body []byte, err error) {
+	var b bytes.Buffer
+	b.WriteString(`<?xml version="1.0"?><s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body>`)
+	fmt.Fprintf(&b, `<u:%s xmlns:u="%s">`, action, u.service)
+	for _, a := range args {
+		fmt.Fprintf(&b, "<%s>", a[0])
+		xml.EscapeText(&b, []byte(a[1]))
+		fmt.Fprintf(&b, "</%s>", a[0])
+	}
+	fmt.Fprintf(&b, "</u:%s></s:Body></s:Envelope>", action)
+	req, err := http.NewRequest("POST", u.controlURL, &b)
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", `"`+u.service+"#"+action+`"`)
+	client := &http.Client{Timeout: soapTimeout}
+	res, err := client.Do(req)
+	if err != nil {
+		return
+	}
+	defer res.Body.Close()
+	body, err = ioutil.ReadAll(res.Body)
+	if err != nil {
+		return
+	}
+	if res.StatusCode != http.StatusOK {
+		err = fmt.Errorf("upnp %s returned %d", action, res.StatusCode)
+	}
+	return
+}
+
+func (u *upnp) ExternalIP() (ip net.IP, err error) {
+	body, err := u.soap("GetExternalIPAddress", nil)
+	if err != nil {
+		return
+	}
+	var resp struct {
+		IP string `xml:"Body>GetExternalIPAddressResponse>NewExternalIPAddress"`
+	}
+	err = xml.Unmarshal(body, &resp)
+	if err != nil {
+		return
+	}
+	ip = net.ParseIP(strings.TrimSpace(resp.IP))
+	if ip == nil {
+		err = errors.New("invalid external address")
+	}
+	return
+}
+
+// UPnP gateways do not choose another port, the requested external port is used
+func (u *upnp) AddMapping(protocol string, internal, external int, lifetime time.Duration) (mapped int, err error) {
+	if external == 0 {
+		external = internal
+	}
+	_, err = u.soap("AddPortMapping", [][2]string{
+		{"NewRemoteHost", ""},
+		{"NewExternalPort", strconv.Itoa(external)},
+		{"NewProtocol", strings.ToUpper(protocol)},
+		{"NewInternalPort", strconv.Itoa(internal)},
+		{"NewInternalClient", u.localIP.String()},
+		{"NewEnabled", "1"},
+		{"NewPortMappingDescription", "skywire"},
+		{"NewLeaseDuration", strconv.Itoa(int(lifetime / time.Second))},
+	})
+	if err != nil {
+		return
+	}
+	mapped = external
+	return
+}
+
+func (u *upnp) DeleteMapping(protocol string, internal, external int) (err error) {
+	_, err = u.soap("DeletePortMapping", [][2]string{
+		{"NewRemoteHost", ""},
+		{"NewExternalPort", strconv.Itoa(external)},
+		{"NewProtocol", strings.ToUpper(protocol)},
+	})
+	return
+}
@@ -0,0 +1,228 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// an address that failed is tried after the others for this long, doubled with each
+	// failure in a row
+	endpointDownMin = 5 * time.Second
+	endpointDownMax = 5 * time.Minute
+)
+
+// Endpoints dials the services run on several hosts, like the discoveries. An address without
+// a port, like _skywire-discovery._tcp.example.com, is the name of SRV records: the records are
+// tried by priority and, within a priority, in a random order by weight. The addresses of a host
+// with several are tried in turns. The addresses that failed are tried after the others until
+// one of them answers again
+type Endpoints struct {
+	// the resolver, unless a test replaces them
+	lookupSRV func(ctx context.Context, name string) ([]*net.SRV, error)
+	lookupIP  func(ctx context.Context, host string) ([]net.IPAddr, error)
+	dial      func(network, address string) (net.Conn, error)
+
+	health map[string]*endpointHealth
+	turns  map[string]int
+	sync.Mutex
+}
+
+type endpointHealth struct {
+	failures int
+	err      string
+	until    time.Time
+}
+
+// EndpointStatus is an address of a service that failed the last time it was dialed
+type EndpointStatus struct {
+	Address  string `json:"address"`
+	Failures int    `json:"failures"`
+	Error    string `json:"error"`
+	// unix time until which the address is tried after the others
+	Until int64 `json:"until"`
+}
+
+// NewEndpoints returns the endpoints resolved by r
+func NewEndpoints(r *Resolver) *Endpoints {
+	return &Endpoints{
+		lookupSRV: r.LookupSRV,
+		lookupIP:  r.LookupIPAddr,
+		dial: func(network, address string) (net.Conn, error) {
+			return net.DialTimeout(network, address, requestTimeout)
+		},
+		health: make(map[string]*endpointHealth),
+		turns:  make(map[string]int),
+	}
+}
+
+// Resolve returns the addresses of the service at address in the order they are tried
+func (e *Endpoints) Resolve(ctx context.Context, address string) (addrs []string, err error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		addrs, err = e.resolveSRV(ctx, address)
+	} else {
+		addrs, err = e.resolveHost(ctx, host, port)
+	}
+	if err != nil {
+		return
+	}
+	return e.byHealth(addrs), nil
+}
+
+func (e *Endpoints) resolveSRV(ctx context.Context, name string) (addrs []string, err error) {
+	srvs, err := e.lookupSRV(ctx, name)
+	if err != nil {
+		return
+	}
+	for _, srv := range orderSRV(srvs) {
+		var ips []net.IPAddr
+		ips, err = e.lookupIP(ctx, strings.TrimSuffix(srv.Target, "."))
+		if err != nil {
+			continue
+		}
+		for _, ip := range ips {
+			addrs = append(addrs, net.JoinHostPort(ip.String(), strconv.Itoa(int(srv.Port))))
+		}
+	}
+	if len(addrs) > 0 {
+		err = nil
+	}
+	return
+}
+
+func (e *Endpoints) resolveHost(ctx context.Context, host, port string) (addrs []string, err error) {
+	ips, err := e.lookupIP(ctx, host)
+	if err != nil {
+		return
+	}
+	e.Lock()
+	turn := e.turns[host] % len(ips)
+	e.turns[host] = turn + 1
+	e.Unlock()
+	for i := range ips {
+		ip := ips[(turn+i)%len(ips)]
+		addrs = append(addrs, net.JoinHostPort(ip.String(), port))
+	}
+	return
+}
+
+// orderSRV sorts the records by priority, the records of a priority in a random order where
+// the ones with more weight come first more often (RFC 2782)
+func orderSRV(srvs []*net.SRV) (ordered []*net.SRV) {
+	left := append([]*net.SRV(nil), srvs...)
+	sort.SliceStable(left, func(i, j int) bool {
+		return left[i].Priority < left[j].Priority
+	})
+	for len(left) > 0 {
+		end := 1
+		for end < len(left) && left[end].Priority == left[0].Priority {
+			end++
+		}
+		same := left[:end]
+		for len(same) > 0 {
+			total := 0
+			for _, srv := range same {
+				total += int(srv.Weight)
+			}
+			pick := 0
+			if total == 0 {
+				pick = rand.Intn(len(same))
+			} else {
+				r := rand.Intn(total)
+				for sum := 0; ; pick++ {
+					sum += int(same[pick].Weight)
+					if sum > r {
+						break
+					}
+				}
+			}
+			ordered = append(ordered, same[pick])
+			same = append(same[:pick], same[pick+1:]...)
+		}
+		left = left[end:]
+	}
+	return
+}
+
+// byHealth moves the addresses that failed after the others, the one tried first again first
+func (e *Endpoints) byHealth(addrs []string) []string {
+	now := time.Now()
+	e.Lock()
+	defer e.Unlock()
+	var up, down []string
+	for _, a := range addrs {
+		if h, ok := e.health[a]; ok && now.Before(h.until) {
+			down = append(down, a)
+		} else {
+			up = append(up, a)
+		}
+	}
+	sort.SliceStable(down, func(i, j int) bool {
+		return e.health[down[i]].until.Before(e.health[down[j]].until)
+	})
+	return append(up, down...)
+}
+
+// report records how the dial of an address went
+func (e *Endpoints) report(addr string, err error) {
+	e.Lock()
+	defer e.Unlock()
+	if err == nil {
+		delete(e.health, addr)
+		return
+	}
+	h, ok := e.health[addr]
+	if !ok {
+		h = &endpointHealth{}
+		e.health[addr] = h
+	}
+	h.failures++
+	h.err = err.Error()
+	down := endpointDownMax
+	if h.failures < 8 {
+		if d := endpointDownMin << uint(h.failures-1); d < down {
+			down = d
+		}
+	}
+	h.until = time.Now().Add(down)
+}
+
+// Dial connects to the first address of the service at address that answers
+func (e *Endpoints) Dial(network, address string) (conn net.Conn, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	addrs, err := e.Resolve(ctx, address)
+	cancel()
+	if err != nil {
+		return
+	}
+	for _, a := range addrs {
+		conn, err = e.dial(network, a)
+		e.report(a, err)
+		if err == nil {
+			return
+		}
+	}
+	err = fmt.Errorf("no address of %s answered: %v", address, err)
+	return
+}
+
+// Status returns the addresses that failed the last time they were dialed
+func (e *Endpoints) Status() (list []EndpointStatus) {
+	e.Lock()
+	defer e.Unlock()
+	for a, h := range e.health {
+		list = append(list, EndpointStatus{Address: a, Failures: h.failures, Error: h.err, Until: h.until.Unix()})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Address < list[j].Address
+	})
+	return
+}
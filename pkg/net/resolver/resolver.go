@@ -0,0 +1,380 @@
+// Package resolver resolves the domains of proxy and VPN apps through encrypted DNS upstreams,
+// DNS over TLS (RFC 7858) and DNS over HTTPS (RFC 8484), and caches the answers. Domains
+// listed in the policy are never resolved locally, the app passes them on to its exit.
+// The nodes resolve the services they connect to, run on several hosts, with Endpoints.
+package resolver
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	dotPort        = "853"
+	requestTimeout = 5 * time.Second
+	dohContentType = "application/dns-message"
+)
+
+// Config of a resolver
+type Config struct {
+	// tls://host[:port] or https://host/path, tried in order. None uses the system resolver
+	Upstreams []string
+	// answers kept, 0 to disable the cache
+	CacheSize int
+	// an answer is kept this long
+	CacheTTL time.Duration
+	// a failed lookup is kept this long
+	NegativeTTL time.Duration
+	// domains, and their subdomains, the exit resolves
+	ExitDomains []string
+}
+
+var DefaultConfig = Config{
+	CacheSize:   1024,
+	CacheTTL:    5 * time.Minute,
+	NegativeTTL: 10 * time.Second,
+}
+
+// List of upstreams or domains, set by a repeated flag
+type List []string
+
+func (l *List) String() string {
+	return fmt.Sprintf("%v", []string(*l))
+}
+
+func (l *List) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// ErrViaExit is returned for the domains the exit resolves
+var ErrViaExit = errors.New("domain is resolved by the exit")
+
+type upstream struct {
+	name     string
+	resolver *net.Resolver
+}
+
+type entry struct {
+	addrs   []net.IPAddr
+	srvs    []*net.SRV
+	err     error
+	expires time.Time
+}
+
+// Resolver resolves domains through the upstreams of its config
+type Resolver struct {
+	config    Config
+	upstreams []upstream
+	client    *http.Client
+
+	cache      map[string]entry
+	cacheMutex sync.Mutex
+}
+
+// New returns a resolver, or an error if an upstream is not a tls:// or https:// url
+func New(config Config) (r *Resolver, err error) {
+	r = &Resolver{
+		config: config,
+		client: &http.Client{Timeout: requestTimeout},
+		cache:  make(map[string]entry),
+	}
+	for _, u := range config.Upstreams {
+		var res *net.Resolver
+		res, err = r.newUpstream(u)
+		if err != nil {
+			return nil, fmt.Errorf("dns upstream %s: %v", u, err)
+		}
+		r.upstreams = append(r.upstreams, upstream{name: u, resolver: res})
+	}
+	return
+}
+
+func (r *Resolver) newUpstream(upstream string) (res *net.Resolver, err error) {
+	u, err := url.Parse(upstream)
+	if err != nil {
+		return
+	}
+	var dial func(ctx context.Context) (net.Conn, error)
+	switch u.Scheme {
+	case "tls":
+		host, port := u.Hostname(), u.Port()
+		if len(port) == 0 {
+			port = dotPort
+		}
+		addr := net.JoinHostPort(host, port)
+		dial = func(ctx context.Context) (net.Conn, error) {
+			d := &net.Dialer{Timeout: requestTimeout}
+			raw, err := d.DialContext(ctx, "tcp", addr)
+			if err != nil {
+				return nil, err
+			}
+			conn := tls.Client(raw, &tls.Config{ServerName: host})
+			if err = conn.Handshake(); err != nil {
+				raw.Close()
+				return nil, err
+			}
+			return conn, nil
+		}
+	case "https":
+		dial = func(ctx context.Context) (net.Conn, error) {
+			return &dohConn{ctx: ctx, client: r.client, url: upstream}, nil
+		}
+	default:
+		err = fmt.Errorf("scheme %q is not tls or https", u.Scheme)
+		return
+	}
+	// a conn that is not a net.PacketConn gets the length prefixed messages of DNS over TCP
+	res = &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return dial(ctx)
+		},
+	}
+	return
+}
+
+// ViaExit reports whether the exit resolves the domain of host
+func (r *Resolver) ViaExit(host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	for _, d := range r.config.ExitDomains {
+		d = strings.TrimPrefix(strings.TrimSuffix(strings.ToLower(d), "."), ".")
+		if d == "*" || host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+// LookupIPAddr returns the addresses of host, from the cache or the first upstream that answers
+func (r *Resolver) LookupIPAddr(ctx context.Context, host string) (addrs []net.IPAddr, err error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IPAddr{{IP: ip}}, nil
+	}
+	if r.ViaExit(host) {
+		return nil, ErrViaExit
+	}
+	key := strings.ToLower(host)
+	if e, ok := r.get(key); ok {
+		return e.addrs, e.err
+	}
+	addrs, err = r.lookup(ctx, host)
+	if err == nil && len(addrs) == 0 {
+		err = fmt.Errorf("no addresses of %s", host)
+	}
+	if ctx.Err() == nil {
+		r.put(key, entry{addrs: addrs, err: err})
+	}
+	return
+}
+
+// LookupSRV returns the SRV records of name, like _skywire._tcp.example.com, from the cache or
+// the first upstream that answers
+func (r *Resolver) LookupSRV(ctx context.Context, name string) (srvs []*net.SRV, err error) {
+	key := "srv:" + strings.ToLower(name)
+	if e, ok := r.get(key); ok {
+		return e.srvs, e.err
+	}
+	srvs, err = r.lookupSRV(ctx, name)
+	if err == nil && len(srvs) == 0 {
+		err = fmt.Errorf("no SRV records of %s", name)
+	}
+	if ctx.Err() == nil {
+		r.put(key, entry{srvs: srvs, err: err})
+	}
+	return
+}
+
+func (r *Resolver) lookupSRV(ctx context.Context, name string) (srvs []*net.SRV, err error) {
+	if len(r.upstreams) == 0 {
+		_, srvs, err = net.DefaultResolver.LookupSRV(ctx, "", "", name)
+		return
+	}
+	for _, u := range r.upstreams {
+		_, srvs, err = u.resolver.LookupSRV(ctx, "", "", name)
+		if err == nil {
+			return
+		}
+		if isNotFound(err) {
+			return
+		}
+		err = fmt.Errorf("dns upstream %s: %v", u.name, err)
+	}
+	return
+}
+
+func (r *Resolver) lookup(ctx context.Context, host string) (addrs []net.IPAddr, err error) {
+	if len(r.upstreams) == 0 {
+		return net.DefaultResolver.LookupIPAddr(ctx, host)
+	}
+	for _, u := range r.upstreams {
+		addrs, err = u.resolver.LookupIPAddr(ctx, host)
+		if err == nil {
+			return
+		}
+		if isNotFound(err) {
+			// the domain does not exist, the other upstreams would say so too
+			return
+		}
+		err = fmt.Errorf("dns upstream %s: %v", u.name, err)
+	}
+	return
+}
+
+// isNotFound tells if the domain does not exist, DNSError has no IsNotFound before Go 1.13
+func isNotFound(err error) bool {
+	dnsErr, ok := err.(*net.DNSError)
+	return ok && dnsErr.Err == "no such host"
+}
+
+func (r *Resolver) get(key string) (e entry, ok bool) {
+	r.cacheMutex.Lock()
+	defer r.cacheMutex.Unlock()
+	e, ok = r.cache[key]
+	if ok && time.Now().After(e.expires) {
+		delete(r.cache, key)
+		ok = false
+	}
+	return
+}
+
+func (r *Resolver) put(key string, e entry) {
+	ttl := r.config.CacheTTL
+	if e.err != nil {
+		ttl = r.config.NegativeTTL
+	}
+	if r.config.CacheSize < 1 || ttl <= 0 {
+		return
+	}
+	now := time.Now()
+	r.cacheMutex.Lock()
+	defer r.cacheMutex.Unlock()
+	if len(r.cache) >= r.config.CacheSize {
+		// drop the expired answers, else the one expiring first
+		var first string
+		for k, c := range r.cache {
+			if now.After(c.expires) {
+				delete(r.cache, k)
+			} else if len(first) == 0 || c.expires.Before(r.cache[first].expires) {
+				first = k
+			}
+		}
+		if len(r.cache) >= r.config.CacheSize {
+			delete(r.cache, first)
+		}
+	}
+	e.expires = now.Add(ttl)
+	r.cache[key] = e
+}
+
+// Dial connects to address, its host resolved by the resolver. allow, if not nil,
+// rejects the addresses the app must not connect to
+func (r *Resolver) Dial(network, address string, allow func(net.IP) bool) (conn net.Conn, err error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	addrs, err := r.LookupIPAddr(ctx, host)
+	cancel()
+	if err != nil {
+		return
+	}
+	err = fmt.Errorf("no allowed addresses of %s", host)
+	for _, a := range addrs {
+		if allow != nil && !allow(a.IP) {
+			continue
+		}
+		conn, err = net.DialTimeout(network, net.JoinHostPort(a.String(), port), requestTimeout)
+		if err == nil {
+			return
+		}
+	}
+	return
+}
+
+// dohConn is the stream a net.Resolver writes its length prefixed queries to, each one
+// is posted to a DNS over HTTPS upstream and the answer read back length prefixed
+type dohConn struct {
+	ctx    context.Context
+	client *http.Client
+	url    string
+
+	query    bytes.Buffer
+	answer   bytes.Buffer
+	deadline time.Time
+}
+
+func (c *dohConn) Write(b []byte) (int, error) {
+	return c.query.Write(b)
+}
+
+func (c *dohConn) Read(b []byte) (n int, err error) {
+	if c.answer.Len() == 0 {
+		err = c.roundTrip()
+		if err != nil {
+			return
+		}
+	}
+	return c.answer.Read(b)
+}
+
+func (c *dohConn) roundTrip() (err error) {
+	q := c.query.Bytes()
+	if len(q) < 2 {
+		return io.EOF
+	}
+	q = q[2:]
+	ctx := c.ctx
+	if !c.deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, c.deadline)
+		defer cancel()
+	}
+	req, err := http.NewRequest(http.MethodPost, c.url, bytes.NewReader(q))
+	if err != nil {
+		return
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", dohContentType)
+	req.Header.Set("Accept", dohContentType)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("dns over https status %s", resp.Status)
+	}
+	a, err := ioutil.ReadAll(io.LimitReader(resp.Body, 65535))
+	if err != nil {
+		return
+	}
+	c.query.Reset()
+	c.answer.Write([]byte{byte(len(a) >> 8), byte(len(a))})
+	c.answer.Write(a)
+	return
+}
+
+func (c *dohConn) Close() error                       { return nil }
+func (c *dohConn) LocalAddr() net.Addr                { return dohAddr(c.url) }
+func (c *dohConn) RemoteAddr() net.Addr               { return dohAddr(c.url) }
+func (c *dohConn) SetDeadline(t time.Time) error      { c.deadline = t; return nil }
+func (c *dohConn) SetReadDeadline(t time.Time) error  { c.deadline = t; return nil }
+func (c *dohConn) SetWriteDeadline(t time.Time) error { return nil }
+
+type dohAddr string
+
+func (a dohAddr) Network() string { return "https" }
+func (a dohAddr) String() string  { return string(a) }
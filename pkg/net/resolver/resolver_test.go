@@ -0,0 +1,181 @@
+package resolver
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// fake DNS over HTTPS server answering the A queries with ip, counting them
+func serveDoH(t *testing.T, ip net.IP, queries *int32) *httptest.Server {
+	s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		q, err := ioutil.ReadAll(req.Body)
+		if err != nil || len(q) < 12 || req.Header.Get("Content-Type") != dohContentType {
+			http.Error(w, "bad query", http.StatusBadRequest)
+			return
+		}
+		// the name of the question ends with an empty label, its type and class follow
+		end := 12
+		for end < len(q) && q[end] != 0 {
+			end += int(q[end]) + 1
+		}
+		if end+5 > len(q) {
+			http.Error(w, "bad question", http.StatusBadRequest)
+			return
+		}
+		qtype := binary.BigEndian.Uint16(q[end+1:])
+		// the answer is the question without the additional records
+		a := append([]byte{}, q[:end+5]...)
+		a[2], a[3] = 0x81, 0x80
+		binary.BigEndian.PutUint16(a[6:], 0)
+		binary.BigEndian.PutUint16(a[8:], 0)
+		binary.BigEndian.PutUint16(a[10:], 0)
+		if qtype == 1 {
+			atomic.AddInt32(queries, 1)
+			binary.BigEndian.PutUint16(a[6:], 1)
+			a = append(a, 0xc0, 12, 0, 1, 0, 1, 0, 0, 0, 60, 0, 4)
+			a = append(a, ip.To4()...)
+		}
+		w.Header().Set("Content-Type", dohContentType)
+		w.Write(a)
+	}))
+	return s
+}
+
+func TestDoH(t *testing.T) {
+	var queries int32
+	s := serveDoH(t, net.IPv4(10, 1, 2, 3), &queries)
+	defer s.Close()
+	config := DefaultConfig
+	config.Upstreams = []string{s.URL + "/dns-query"}
+	config.ExitDomains = []string{"onion.example"}
+	r, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.client = s.Client()
+
+	for i := 0; i < 2; i++ {
+		addrs, err := r.LookupIPAddr(context.Background(), "skywire.example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(addrs) != 1 || !addrs[0].IP.Equal(net.IPv4(10, 1, 2, 3)) {
+			t.Fatalf("addrs = %v, want [10.1.2.3]", addrs)
+		}
+	}
+	if n := atomic.LoadInt32(&queries); n != 1 {
+		t.Errorf("%d queries, want 1 and the second answer from the cache", n)
+	}
+
+	_, err = r.LookupIPAddr(context.Background(), "www.onion.example")
+	if err != ErrViaExit {
+		t.Errorf("err = %v, want %v", err, ErrViaExit)
+	}
+}
+
+func TestViaExit(t *testing.T) {
+	r, err := New(Config{ExitDomains: []string{".corp.example", "intra.example."}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for host, want := range map[string]bool{
+		"corp.example":      true,
+		"a.b.CORP.example.": true,
+		"intra.example":     true,
+		"notcorp.example":   false,
+		"example":           false,
+		"intra.example.org": false,
+	} {
+		if got := r.ViaExit(host); got != want {
+			t.Errorf("ViaExit(%s) = %v, want %v", host, got, want)
+		}
+	}
+}
+
+func TestUpstreams(t *testing.T) {
+	for _, u := range []string{"tls://1.1.1.1", "tls://dns.example:8853", "https://dns.example/dns-query"} {
+		if _, err := New(Config{Upstreams: []string{u}}); err != nil {
+			t.Errorf("%s: %v", u, err)
+		}
+	}
+	if _, err := New(Config{Upstreams: []string{"udp://8.8.8.8"}}); err == nil {
+		t.Error("plain udp upstream accepted")
+	}
+}
+
+func TestEndpoints(t *testing.T) {
+	e := NewEndpoints(&Resolver{})
+	e.lookupSRV = func(ctx context.Context, name string) ([]*net.SRV, error) {
+		if name != "_discovery._tcp.example" {
+			return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+		}
+		return []*net.SRV{
+			{Target: "backup.example.", Port: 5000, Priority: 20, Weight: 1},
+			{Target: "a.example.", Port: 5999, Priority: 5, Weight: 0},
+			{Target: "b.example.", Port: 5999, Priority: 10, Weight: 0},
+		}, nil
+	}
+	hosts := map[string][]net.IPAddr{
+		"a.example":      {{IP: net.IPv4(10, 0, 0, 1)}},
+		"b.example":      {{IP: net.IPv4(10, 0, 0, 2)}},
+		"backup.example": {{IP: net.IPv4(10, 0, 0, 9)}},
+		"many.example":   {{IP: net.IPv4(10, 0, 1, 1)}, {IP: net.IPv4(10, 0, 1, 2)}},
+	}
+	e.lookupIP = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		return hosts[host], nil
+	}
+	down := map[string]bool{"10.0.0.1:5999": true}
+	var dialed []string
+	e.dial = func(network, address string) (net.Conn, error) {
+		dialed = append(dialed, address)
+		if down[address] {
+			return nil, errors.New("connection refused")
+		}
+		c, _ := net.Pipe()
+		return c, nil
+	}
+
+	addrs, err := e.Resolve(context.Background(), "_discovery._tcp.example")
+	if err != nil || len(addrs) != 3 || addrs[2] != "10.0.0.9:5000" {
+		t.Fatalf("srv %v, %v", addrs, err)
+	}
+	// the hosts of a name take turns
+	first, _ := e.Resolve(context.Background(), "many.example:5999")
+	second, _ := e.Resolve(context.Background(), "many.example:5999")
+	if first[0] == second[0] || first[0] != second[1] {
+		t.Fatalf("turns %v, %v", first, second)
+	}
+
+	for i := 0; i < 10; i++ {
+		dialed = nil
+		conn, err := e.Dial("tcp", "_discovery._tcp.example")
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+		// the address of the best priority failed, it is tried after the one that answers
+		if dialed[len(dialed)-1] != "10.0.0.2:5999" || i > 0 && len(dialed) != 1 {
+			t.Fatalf("dialed %v", dialed)
+		}
+	}
+	status := e.Status()
+	if len(status) != 1 || status[0].Address != "10.0.0.1:5999" || status[0].Failures != 1 {
+		t.Fatalf("status %#v", status)
+	}
+
+	delete(down, "10.0.0.1:5999")
+	e.report("10.0.0.1:5999", nil)
+	if status := e.Status(); len(status) != 0 {
+		t.Fatalf("status after the address answered %#v", status)
+	}
+	if _, err := e.Dial("tcp", "_missing._tcp.example"); err == nil {
+		t.Fatal("dialed a name without records")
+	}
+}
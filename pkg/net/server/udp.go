@@ -73,6 +73,7 @@ func (c *ServerUDPConn) ReadLoop(fn func(c *net.UDPConn, addr *net.UDPAddr) *con
 			c.GetContextLogger().Infof("udp server conn closed")
 			continue
 		}
+		cc.AddReceivedBytes(n)
 		m := pkg[msg.PKG_HEADER_SIZE:]
 		checksum := binary.BigEndian.Uint32(pkg[msg.PKG_CRC32_BEGIN:])
 		if checksum != crc32.ChecksumIEEE(m) {
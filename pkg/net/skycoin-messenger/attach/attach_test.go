@@ -0,0 +1,188 @@
+package attach
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/skycoin/skycoin/src/cipher"
+	"github.com/skycoin/skywire/pkg/net/skycoin-messenger/factory"
+)
+
+func listen(t *testing.T) (string, *factory.MessengerFactory) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	f := factory.NewMessengerFactory()
+	f.SetDefaultSeedConfig(factory.NewSeedConfig())
+	if err = f.Listen(addr); err != nil {
+		t.Fatal(err)
+	}
+	return addr, f
+}
+
+type client struct {
+	key       cipher.PubKey
+	dir       string
+	store     *Store
+	transfers *Transfers
+	events    chan Event
+	factory   *factory.MessengerFactory
+}
+
+func (c *client) close() {
+	c.transfers.Close()
+	c.factory.Close()
+	os.RemoveAll(c.dir)
+}
+
+// connect a client to the messenger server, the chat messages are control messages only
+func connect(t *testing.T, address string, maxSize int64) *client {
+	dir, err := ioutil.TempDir("", "attach")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewStore(dir, maxSize)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	c := &client{dir: dir, store: store, events: make(chan Event, 1024)}
+	connected := make(chan *factory.Connection, 1)
+	f := factory.NewMessengerFactory()
+	err = f.ConnectWithConfig(address, &factory.ConnConfig{
+		SeedConfig: factory.NewSeedConfig(),
+		OnConnected: func(conn *factory.Connection) {
+			connected <- conn
+		},
+	})
+	if err != nil {
+		f.Close()
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	conn := <-connected
+	c.key = conn.GetKey()
+	c.factory = f
+	c.transfers = NewTransfers(store, address, conn.Send, func(e Event) { c.events <- e })
+	go func() {
+		for m := range conn.GetChanIn() {
+			if len(m) >= factory.SEND_MSG_META_END && IsControl(m[factory.SEND_MSG_META_END:]) {
+				from := cipher.NewPubKey(m[factory.SEND_MSG_PUBLIC_KEY_BEGIN:factory.SEND_MSG_PUBLIC_KEY_END])
+				c.transfers.Handle(from, m[factory.SEND_MSG_META_END:])
+			}
+		}
+	}()
+	return c
+}
+
+// wait for the last event of the transfer
+func (c *client) wait(t *testing.T) Event {
+	timeout := time.After(20 * time.Second)
+	for {
+		select {
+		case e := <-c.events:
+			if e.Complete || len(e.Error) > 0 {
+				return e
+			}
+		case <-timeout:
+			t.Fatal("transfer did not finish")
+		}
+	}
+}
+
+func TestTransfer(t *testing.T) {
+	address, f := listen(t)
+	defer f.Close()
+	sender := connect(t, address, 0)
+	defer sender.close()
+	receiver := connect(t, address, 0)
+	defer receiver.close()
+
+	data := make([]byte, 5*ChunkSize+123)
+	rand.Read(data)
+	hash, _, err := sender.store.Put(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// half received before, the rest is resumed
+	err = ioutil.WriteFile(receiver.store.partPath(hash), data[:2*ChunkSize+7], 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = sender.transfers.Send(receiver.key, hash, "../photo.jpg", "image/jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := receiver.wait(t)
+	if !e.Complete || e.Name != "photo.jpg" || e.Size != int64(len(data)) {
+		t.Fatalf("receiver event %+v", e)
+	}
+	if e = sender.wait(t); !e.Complete {
+		t.Fatalf("sender event %+v", e)
+	}
+	got, err := ioutil.ReadFile(receiver.store.Path(hash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("received attachment differs")
+	}
+	if _, err = os.Stat(receiver.store.partPath(hash)); !os.IsNotExist(err) {
+		t.Errorf("part left: %v", err)
+	}
+}
+
+func TestTransferTooLarge(t *testing.T) {
+	address, f := listen(t)
+	defer f.Close()
+	sender := connect(t, address, 0)
+	defer sender.close()
+	receiver := connect(t, address, ChunkSize)
+	defer receiver.close()
+
+	hash, _, err := sender.store.Put(bytes.NewReader(make([]byte, 2*ChunkSize)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = sender.transfers.Send(receiver.key, hash, "big", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := sender.wait(t); len(e.Error) == 0 {
+		t.Fatalf("sender event %+v, want refused", e)
+	}
+}
+
+func TestStorePut(t *testing.T) {
+	dir, err := ioutil.TempDir("", "attach")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := NewStore(dir, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, size, err := s.Put(bytes.NewReader([]byte("0123456789")))
+	if err != nil || size != 10 {
+		t.Fatalf("put: %d %v", size, err)
+	}
+	if _, ok := s.Has(hash); !ok {
+		t.Error("put attachment not found")
+	}
+	if _, _, err = s.Put(bytes.NewReader([]byte("0123456789a"))); err == nil {
+		t.Error("attachment over the size limit put")
+	}
+	if _, ok := s.Has("../" + hash); ok {
+		t.Error("path outside the store accepted")
+	}
+}
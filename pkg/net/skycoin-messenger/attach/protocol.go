@@ -0,0 +1,94 @@
+// Package attach sends the files attached to chat messages between messenger clients.
+//
+// The offer, accept and done messages of a transfer go with the chat messages, the data
+// goes over a side stream: a connection of each client to the messenger server with a
+// key of its own, so a transfer never holds up the chat. The receiver accepts from the
+// bytes it already has, so a broken transfer resumes where it stopped, and checks the
+// size and hash of the file before handing it on.
+package attach
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+)
+
+const (
+	// data bytes in a chunk, a chunk fits in a message of the messenger
+	ChunkSize = 8 << 10
+	// largest attachment by default
+	DefaultMaxSize = 10 << 20
+)
+
+// the control messages are sent as chat messages starting with the prefix, which a
+// person can not type
+var ctrlPrefix = []byte("\x00attach:")
+
+var errChunk = errors.New("invalid chunk")
+
+// Offer of a file by the sender
+type Offer struct {
+	ID   string
+	Name string
+	Type string `json:",omitempty"`
+	Size int64
+	// sha256 of the file, hex
+	Hash string
+	// key of the side stream of the sender
+	Side string
+}
+
+// Accept of an offer by the receiver, which wants the file from offset
+type Accept struct {
+	ID string
+	// key of the side stream of the receiver
+	Side   string `json:",omitempty"`
+	Offset int64  `json:",omitempty"`
+	// the offer is refused if set
+	Error string `json:",omitempty"`
+}
+
+// Done of a transfer, sent by the receiver when it has checked the file
+type Done struct {
+	ID    string
+	Error string `json:",omitempty"`
+}
+
+type ctrl struct {
+	Offer  *Offer  `json:",omitempty"`
+	Accept *Accept `json:",omitempty"`
+	Done   *Done   `json:",omitempty"`
+}
+
+// IsControl reports whether the chat message is a control message of a transfer
+func IsControl(m []byte) bool {
+	return bytes.HasPrefix(m, ctrlPrefix)
+}
+
+func encodeCtrl(c *ctrl) []byte {
+	b, _ := json.Marshal(c)
+	return append(append([]byte{}, ctrlPrefix...), b...)
+}
+
+func decodeCtrl(m []byte) (c *ctrl, err error) {
+	c = new(ctrl)
+	err = json.Unmarshal(bytes.TrimPrefix(m, ctrlPrefix), c)
+	return
+}
+
+// a chunk on a side stream is the offset of its data in the file and the data
+func encodeChunk(offset int64, data []byte) []byte {
+	b := make([]byte, 8+len(data))
+	binary.BigEndian.PutUint64(b, uint64(offset))
+	copy(b[8:], data)
+	return b
+}
+
+func decodeChunk(b []byte) (offset int64, data []byte, err error) {
+	if len(b) < 8 {
+		err = errChunk
+		return
+	}
+	return int64(binary.BigEndian.Uint64(b)), b[8:], nil
+}
@@ -0,0 +1,161 @@
+package attach
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const partSuffix = ".part"
+
+// Store keeps the attachments by the sha256 of their data
+type Store struct {
+	dir     string
+	maxSize int64
+}
+
+// NewStore keeps the attachments of up to maxSize bytes in dir
+func NewStore(dir string, maxSize int64) (s *Store, err error) {
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return
+	}
+	if maxSize <= 0 {
+		maxSize = DefaultMaxSize
+	}
+	s = &Store{dir: dir, maxSize: maxSize}
+	return
+}
+
+func validHash(hash string) bool {
+	b, err := hex.DecodeString(hash)
+	return err == nil && len(b) == sha256.Size && hash == strings.ToLower(hash)
+}
+
+// Path of the attachment, which exists if it was received or put completely
+func (s *Store) Path(hash string) string {
+	return filepath.Join(s.dir, hash)
+}
+
+func (s *Store) partPath(hash string) string {
+	return s.Path(hash) + partSuffix
+}
+
+// Has reports whether the store has the whole attachment and its size
+func (s *Store) Has(hash string) (size int64, ok bool) {
+	if !validHash(hash) {
+		return
+	}
+	fi, err := os.Stat(s.Path(hash))
+	if err != nil {
+		return
+	}
+	return fi.Size(), true
+}
+
+// Put keeps the data read from r, or fails if there is more than the largest attachment
+func (s *Store) Put(r io.Reader) (hash string, size int64, err error) {
+	f, err := ioutil.TempFile(s.dir, "put")
+	if err != nil {
+		return
+	}
+	defer func() {
+		f.Close()
+		if err != nil {
+			os.Remove(f.Name())
+		}
+	}()
+	h := sha256.New()
+	size, err = io.Copy(io.MultiWriter(f, h), io.LimitReader(r, s.maxSize+1))
+	if err != nil {
+		return
+	}
+	if size > s.maxSize {
+		err = fmt.Errorf("attachment larger than %d bytes", s.maxSize)
+		return
+	}
+	if err = f.Close(); err != nil {
+		return
+	}
+	hash = hex.EncodeToString(h.Sum(nil))
+	err = os.Rename(f.Name(), s.Path(hash))
+	return
+}
+
+// part opens the partly received attachment to append to, offset is its size
+func (s *Store) part(hash string) (f *os.File, offset int64, err error) {
+	f, err = os.OpenFile(s.partPath(hash), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return
+	}
+	offset = fi.Size()
+	return
+}
+
+// complete checks the received attachment and keeps it, a wrong one is dropped to be sent again
+func (s *Store) complete(hash string) (err error) {
+	part := s.partPath(hash)
+	f, err := os.Open(part)
+	if err != nil {
+		return
+	}
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	f.Close()
+	if err != nil {
+		return
+	}
+	if hex.EncodeToString(h.Sum(nil)) != hash {
+		os.Remove(part)
+		return fmt.Errorf("attachment %s has a wrong hash", hash)
+	}
+	return os.Rename(part, s.Path(hash))
+}
+
+// ServeHTTP keeps the body of a POST and answers its hash and size, and serves the
+// attachment named by the hash in the path of a GET
+func (s *Store) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		hash, size, err := s.Put(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Hash string
+			Size int64
+		}{hash, size})
+	case http.MethodGet, http.MethodHead:
+		hash := filepath.Base(r.URL.Path)
+		if _, ok := s.Has(hash); !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if name := r.URL.Query().Get("name"); len(name) > 0 {
+			w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", filepath.Base(name)))
+		}
+		if t := r.URL.Query().Get("type"); len(t) > 0 {
+			w.Header().Set("Content-Type", t)
+		}
+		// the type comes from the sender, it must not make the browser run a script
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Content-Security-Policy", "sandbox")
+		http.ServeFile(w, r, s.Path(hash))
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
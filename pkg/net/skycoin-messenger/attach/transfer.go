@@ -0,0 +1,563 @@
+package attach
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/skycoin/skycoin/src/cipher"
+	"github.com/skycoin/skywire/pkg/net/skycoin-messenger/factory"
+)
+
+const (
+	// the peer answers an offer or a sent file within this, else it is offered again
+	answerTimeout = 30 * time.Second
+	// an incoming transfer without chunks for this long is dropped until offered again
+	idleTimeout = time.Minute
+	// offers of a transfer before it fails
+	maxOffers = 5
+	// a progress event every this many chunks
+	progressChunks = 64
+)
+
+var errClosed = errors.New("transfers closed")
+
+// Event of a transfer for the chat
+type Event struct {
+	ID string
+	// key of the other client
+	Peer     string
+	Incoming bool
+	Name     string
+	Type     string `json:",omitempty"`
+	Size     int64
+	Hash     string
+	// bytes sent or received
+	Done     int64
+	Complete bool   `json:",omitempty"`
+	Error    string `json:",omitempty"`
+}
+
+// Transfers of the attachments of a messenger client
+type Transfers struct {
+	store   *Store
+	address string
+	// sends a chat message
+	send   func(to cipher.PubKey, m []byte) error
+	events func(Event)
+
+	outgoing map[string]*outgoing
+	incoming map[string]*incoming
+	closed   bool
+	sync.Mutex
+}
+
+// NewTransfers of the client connected to the messenger server at address, send sends a
+// chat message with the control messages and events gets the progress of the transfers
+func NewTransfers(store *Store, address string, send func(to cipher.PubKey, m []byte) error, events func(Event)) *Transfers {
+	return &Transfers{
+		store:    store,
+		address:  address,
+		send:     send,
+		events:   events,
+		outgoing: make(map[string]*outgoing),
+		incoming: make(map[string]*incoming),
+	}
+}
+
+// side stream of a transfer, a connection to the messenger server with a new key
+type side struct {
+	f    *factory.MessengerFactory
+	conn *factory.Connection
+}
+
+func openSide(address string, recv func(from cipher.PubKey, data []byte)) (s *side, err error) {
+	f := factory.NewMessengerFactory()
+	connected := make(chan *factory.Connection, 1)
+	err = f.ConnectWithConfig(address, &factory.ConnConfig{
+		SeedConfig: factory.NewSeedConfig(),
+		OnConnected: func(c *factory.Connection) {
+			select {
+			case connected <- c:
+			default:
+			}
+		},
+	})
+	if err != nil {
+		f.Close()
+		return
+	}
+	select {
+	case c := <-connected:
+		s = &side{f: f, conn: c}
+	case <-time.After(answerTimeout):
+		f.Close()
+		err = fmt.Errorf("side stream to %s not connected", address)
+		return
+	}
+	if recv != nil {
+		go s.readLoop(recv)
+	}
+	return
+}
+
+func (s *side) key() cipher.PubKey {
+	return s.conn.GetKey()
+}
+
+func (s *side) readLoop(recv func(from cipher.PubKey, data []byte)) {
+	for m := range s.conn.GetChanIn() {
+		if len(m) < factory.SEND_MSG_META_END || m[factory.MSG_OP_BEGIN] != factory.OP_SEND {
+			continue
+		}
+		recv(cipher.NewPubKey(m[factory.SEND_MSG_PUBLIC_KEY_BEGIN:factory.SEND_MSG_PUBLIC_KEY_END]), m[factory.SEND_MSG_META_END:])
+	}
+}
+
+func (s *side) close() {
+	s.f.Close()
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Handle runs the control message of a transfer from the client of key from
+func (t *Transfers) Handle(from cipher.PubKey, m []byte) (err error) {
+	c, err := decodeCtrl(m)
+	if err != nil {
+		return
+	}
+	switch {
+	case c.Offer != nil:
+		t.offered(from, *c.Offer)
+	case c.Accept != nil:
+		if o, ok := t.getOutgoing(from, c.Accept.ID); ok {
+			o.accepted(*c.Accept)
+		}
+	case c.Done != nil:
+		if o, ok := t.getOutgoing(from, c.Done.ID); ok {
+			o.done(c.Done.Error)
+		}
+	}
+	return
+}
+
+// Close stops all transfers, the partly received attachments are kept to resume
+func (t *Transfers) Close() {
+	t.Lock()
+	t.closed = true
+	out, in := t.outgoing, t.incoming
+	t.outgoing, t.incoming = make(map[string]*outgoing), make(map[string]*incoming)
+	t.Unlock()
+	for _, o := range out {
+		o.stop()
+	}
+	for _, i := range in {
+		i.stop()
+	}
+}
+
+// Send offers the attachment of the store to the client of key to
+func (t *Transfers) Send(to cipher.PubKey, hash, name, typ string) (id string, err error) {
+	size, ok := t.store.Has(hash)
+	if !ok {
+		err = fmt.Errorf("attachment %s not found", hash)
+		return
+	}
+	id = newID()
+	o := &outgoing{
+		t:  t,
+		to: to,
+		offer: Offer{
+			ID:   id,
+			Name: filepath.Base(name),
+			Type: typ,
+			Size: size,
+			Hash: hash,
+		},
+	}
+	t.Lock()
+	if t.closed {
+		t.Unlock()
+		return "", errClosed
+	}
+	t.outgoing[to.Hex()+id] = o
+	t.Unlock()
+	go o.offerAgain()
+	return
+}
+
+func (t *Transfers) getOutgoing(to cipher.PubKey, id string) (o *outgoing, ok bool) {
+	t.Lock()
+	o, ok = t.outgoing[to.Hex()+id]
+	t.Unlock()
+	return
+}
+
+func (t *Transfers) deleteOutgoing(o *outgoing) {
+	t.Lock()
+	if t.outgoing[o.to.Hex()+o.offer.ID] == o {
+		delete(t.outgoing, o.to.Hex()+o.offer.ID)
+	}
+	t.Unlock()
+}
+
+func (t *Transfers) ctrl(to cipher.PubKey, c *ctrl) error {
+	return t.send(to, encodeCtrl(c))
+}
+
+type outgoing struct {
+	t     *Transfers
+	to    cipher.PubKey
+	offer Offer
+
+	side   *side
+	offers int
+	// changed by every offer, the stream of an older one stops
+	gen   int
+	timer *time.Timer
+	sync.Mutex
+}
+
+func (o *outgoing) event(done int64, complete bool, err string) {
+	o.t.events(Event{
+		ID:       o.offer.ID,
+		Peer:     o.to.Hex(),
+		Name:     o.offer.Name,
+		Type:     o.offer.Type,
+		Size:     o.offer.Size,
+		Hash:     o.offer.Hash,
+		Done:     done,
+		Complete: complete,
+		Error:    err,
+	})
+}
+
+// offerAgain offers the file on a new side stream, the receiver answers from where it is
+func (o *outgoing) offerAgain() {
+	o.Lock()
+	o.offers++
+	o.gen++
+	if o.side != nil {
+		o.side.close()
+		o.side = nil
+	}
+	if o.timer != nil {
+		o.timer.Stop()
+	}
+	if o.offers > maxOffers {
+		o.Unlock()
+		o.fail(fmt.Sprintf("no answer to %d offers", maxOffers))
+		return
+	}
+	gen := o.gen
+	o.Unlock()
+	s, err := openSide(o.t.address, nil)
+	o.Lock()
+	defer o.Unlock()
+	if gen != o.gen {
+		if s != nil {
+			s.close()
+		}
+		return
+	}
+	// a failed offer is offered again after the timeout as well
+	o.timer = time.AfterFunc(answerTimeout, o.offerAgain)
+	if err != nil {
+		return
+	}
+	o.side = s
+	offer := o.offer
+	offer.Side = s.key().Hex()
+	o.t.ctrl(o.to, &ctrl{Offer: &offer})
+}
+
+func (o *outgoing) accepted(a Accept) {
+	if len(a.Error) > 0 {
+		o.fail(a.Error)
+		return
+	}
+	peer, err := cipher.PubKeyFromHex(a.Side)
+	if err != nil || a.Offset < 0 || a.Offset > o.offer.Size {
+		o.fail("invalid accept")
+		return
+	}
+	o.Lock()
+	if o.timer != nil {
+		o.timer.Stop()
+	}
+	// the receiver is reachable, the offers count again from here
+	o.offers = 0
+	s, gen := o.side, o.gen
+	o.Unlock()
+	if s == nil {
+		return
+	}
+	go o.stream(s, gen, peer, a.Offset)
+}
+
+// stream sends the file from offset to the side stream of the peer
+func (o *outgoing) stream(s *side, gen int, peer cipher.PubKey, offset int64) {
+	current := func() bool {
+		o.Lock()
+		defer o.Unlock()
+		return gen == o.gen
+	}
+	f, err := os.Open(o.t.store.Path(o.offer.Hash))
+	if err != nil {
+		o.fail(err.Error())
+		return
+	}
+	defer f.Close()
+	_, err = f.Seek(offset, io.SeekStart)
+	if err != nil {
+		o.fail(err.Error())
+		return
+	}
+	buf := make([]byte, ChunkSize)
+	for n := 0; offset < o.offer.Size; n++ {
+		if !current() {
+			return
+		}
+		var l int
+		l, err = io.ReadFull(f, buf)
+		if l == 0 {
+			break
+		}
+		err = s.conn.Send(peer, encodeChunk(offset, buf[:l]))
+		if err != nil {
+			break
+		}
+		offset += int64(l)
+		if n%progressChunks == 0 {
+			o.event(offset, false, "")
+		}
+	}
+	o.Lock()
+	defer o.Unlock()
+	if gen != o.gen {
+		return
+	}
+	// the receiver answers when it checked the file, else the file is offered again
+	if o.timer != nil {
+		o.timer.Stop()
+	}
+	o.timer = time.AfterFunc(answerTimeout, o.offerAgain)
+}
+
+func (o *outgoing) done(err string) {
+	o.t.deleteOutgoing(o)
+	o.stop()
+	o.event(o.offer.Size, len(err) == 0, err)
+}
+
+func (o *outgoing) fail(err string) {
+	o.t.deleteOutgoing(o)
+	o.stop()
+	o.event(0, false, err)
+}
+
+func (o *outgoing) stop() {
+	o.Lock()
+	defer o.Unlock()
+	o.gen++
+	if o.timer != nil {
+		o.timer.Stop()
+	}
+	if o.side != nil {
+		o.side.close()
+		o.side = nil
+	}
+}
+
+type incoming struct {
+	t     *Transfers
+	from  cipher.PubKey
+	offer Offer
+	// side stream of the sender
+	sender cipher.PubKey
+
+	side   *side
+	file   *os.File
+	offset int64
+	chunks int
+	timer  *time.Timer
+	sync.Mutex
+}
+
+func (t *Transfers) offered(from cipher.PubKey, offer Offer) {
+	refuse := func(err string) {
+		t.ctrl(from, &ctrl{Accept: &Accept{ID: offer.ID, Error: err}})
+	}
+	sender, err := cipher.PubKeyFromHex(offer.Side)
+	if err != nil || !validHash(offer.Hash) || offer.Size < 0 {
+		refuse("invalid offer")
+		return
+	}
+	if offer.Size > t.store.maxSize {
+		refuse(fmt.Sprintf("attachment larger than %d bytes", t.store.maxSize))
+		return
+	}
+	offer.Name = filepath.Base(offer.Name)
+	key := from.Hex() + offer.ID
+	t.Lock()
+	if t.closed {
+		t.Unlock()
+		return
+	}
+	// an offer again resumes the transfer on the new side stream
+	old := t.incoming[key]
+	delete(t.incoming, key)
+	t.Unlock()
+	if old != nil {
+		old.stop()
+	}
+	in := &incoming{t: t, from: from, offer: offer, sender: sender}
+	if size, ok := t.store.Has(offer.Hash); ok && size == offer.Size {
+		t.ctrl(from, &ctrl{Done: &Done{ID: offer.ID}})
+		in.event(true, "")
+		return
+	}
+	in.file, in.offset, err = t.store.part(offer.Hash)
+	if err == nil && in.offset > offer.Size {
+		in.file.Close()
+		os.Remove(t.store.partPath(offer.Hash))
+		in.file, in.offset, err = t.store.part(offer.Hash)
+	}
+	if err != nil {
+		refuse(err.Error())
+		return
+	}
+	in.side, err = openSide(t.address, in.chunk)
+	if err != nil {
+		in.file.Close()
+		refuse(err.Error())
+		return
+	}
+	t.Lock()
+	if t.closed || t.incoming[key] != nil {
+		t.Unlock()
+		in.stop()
+		return
+	}
+	t.incoming[key] = in
+	t.Unlock()
+	in.Lock()
+	in.timer = time.AfterFunc(idleTimeout, in.idle)
+	in.Unlock()
+	in.event(false, "")
+	t.ctrl(from, &ctrl{Accept: &Accept{ID: offer.ID, Side: in.side.key().Hex(), Offset: in.offset}})
+	if offer.Size == 0 {
+		in.chunk(sender, nil)
+	}
+}
+
+func (in *incoming) event(complete bool, err string) {
+	in.t.events(Event{
+		ID:       in.offer.ID,
+		Peer:     in.from.Hex(),
+		Incoming: true,
+		Name:     in.offer.Name,
+		Type:     in.offer.Type,
+		Size:     in.offer.Size,
+		Hash:     in.offer.Hash,
+		Done:     in.offset,
+		Complete: complete,
+		Error:    err,
+	})
+}
+
+// chunk appends the data of a chunk on the side stream, the chunks before the received
+// bytes are sent again after a resume and skipped
+func (in *incoming) chunk(from cipher.PubKey, data []byte) {
+	if from != in.sender {
+		return
+	}
+	offset, data, err := decodeChunk(data)
+	in.Lock()
+	if in.file == nil {
+		in.Unlock()
+		return
+	}
+	if len(data) > 0 {
+		if err != nil || offset != in.offset || in.offset+int64(len(data)) > in.offer.Size {
+			in.Unlock()
+			return
+		}
+		_, err = in.file.Write(data)
+		if err != nil {
+			in.Unlock()
+			in.finish(err)
+			return
+		}
+		in.offset += int64(len(data))
+		in.chunks++
+		in.timer.Reset(idleTimeout)
+	}
+	complete := in.offset == in.offer.Size
+	progress := in.chunks%progressChunks == 0
+	in.Unlock()
+	if complete {
+		in.finish(nil)
+	} else if progress {
+		in.event(false, "")
+	}
+}
+
+// finish checks the received file and answers the sender
+func (in *incoming) finish(err error) {
+	in.t.Lock()
+	key := in.from.Hex() + in.offer.ID
+	if in.t.incoming[key] == in {
+		delete(in.t.incoming, key)
+	}
+	in.t.Unlock()
+	in.stop()
+	if err == nil {
+		err = in.t.store.complete(in.offer.Hash)
+	}
+	done := &Done{ID: in.offer.ID}
+	if err != nil {
+		done.Error = err.Error()
+		in.event(false, done.Error)
+	} else {
+		in.event(true, "")
+	}
+	in.t.ctrl(in.from, &ctrl{Done: done})
+}
+
+// idle drops the stalled transfer, the sender offers it again
+func (in *incoming) idle() {
+	in.t.Lock()
+	key := in.from.Hex() + in.offer.ID
+	if in.t.incoming[key] == in {
+		delete(in.t.incoming, key)
+	}
+	in.t.Unlock()
+	in.stop()
+}
+
+func (in *incoming) stop() {
+	in.Lock()
+	defer in.Unlock()
+	if in.timer != nil {
+		in.timer.Stop()
+	}
+	if in.side != nil {
+		in.side.close()
+		in.side = nil
+	}
+	if in.file != nil {
+		in.file.Close()
+		in.file = nil
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"net"
@@ -8,10 +9,13 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"syscall"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/skycoin/skycoin/src/util/browser"
 	"github.com/skycoin/skycoin/src/util/file"
+	"github.com/skycoin/skywire/pkg/httputil"
+	"github.com/skycoin/skywire/pkg/net/skycoin-messenger/attach"
 	"github.com/skycoin/skywire/pkg/net/skycoin-messenger/websocket"
 	"github.com/skycoin/skywire/pkg/net/skycoin-messenger/websocket/data"
 )
@@ -22,6 +26,9 @@ var (
 	openBrowser      bool
 	// dir path for seeds, public key and private key
 	seedPath string
+	// dir path for the attachments, empty to disable them
+	attachmentPath    string
+	maxAttachmentSize int64
 )
 
 func parseFlags() {
@@ -29,6 +36,8 @@ func parseFlags() {
 	flag.StringVar(&webSocketAddress, "websocket-address", "localhost:8082", "websocket address to listen on")
 	flag.BoolVar(&openBrowser, "open-browser", false, "whether to open browser")
 	flag.StringVar(&seedPath, "seed-path", filepath.Join(file.UserHome(), ".skyim", "account"), "dir path to save seeds info")
+	flag.StringVar(&attachmentPath, "attachment-path", filepath.Join(file.UserHome(), ".skyim", "attachments"), "dir path to keep the sent and received attachments, empty to disable attachments")
+	flag.Int64Var(&maxAttachmentSize, "max-attachment-size", attach.DefaultMaxSize, "largest attachment in bytes sent or received")
 	flag.Parse()
 }
 
@@ -41,10 +50,19 @@ func main() {
 	data.InitData(seedPath)
 
 	osSignal := make(chan os.Signal, 1)
-	signal.Notify(osSignal, os.Interrupt, os.Kill)
+	signal.Notify(osSignal, os.Interrupt, os.Kill, syscall.SIGTERM)
 
 	log.Debug("listening web")
 	http.Handle("/", http.FileServer(http.Dir(webDir)))
+	if len(attachmentPath) > 0 {
+		store, err := attach.NewStore(attachmentPath, maxAttachmentSize)
+		if err != nil {
+			log.Error("attachments: ", err)
+			os.Exit(1)
+		}
+		websocket.SetAttachmentStore(store)
+		http.Handle("/attachments/", store)
+	}
 	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
 		websocket.ServeWs(w, r)
 	})
@@ -60,7 +78,7 @@ func main() {
 		}()
 	}
 	go func() {
-		err := http.Serve(ln, http.DefaultServeMux)
+		err := httputil.ServeListener(context.Background(), &http.Server{Handler: http.DefaultServeMux}, ln)
 		if err != nil {
 			log.Error("http.Serve: ", err)
 			os.Exit(1)
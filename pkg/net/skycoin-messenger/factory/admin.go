@@ -0,0 +1,219 @@
+package factory
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+// ServerMetrics is the state of the clients of a discovery and the errors it counted
+type ServerMetrics struct {
+	Clients int `json:"clients"`
+	// services offered by the clients, the channels other nodes reach them by
+	Services int `json:"services"`
+	// keys banned now
+	Banned int `json:"banned"`
+	// counted since the discovery started, rates are the differences between two samples
+	Errors     ServerErrors   `json:"errors"`
+	Handshakes HandshakeStats `json:"handshakes"`
+	Queries    QueryStats     `json:"queries"`
+	Sends      SendStats      `json:"sends"`
+	// by client, the most bytes sent and received per second first
+	ClientMetrics []ClientMetrics `json:"client_metrics"`
+}
+
+// ServerErrors counts what a discovery failed or refused
+type ServerErrors struct {
+	// handshakes failed for any reason
+	Handshakes uint64 `json:"handshakes"`
+	// registrations of banned keys
+	Banned uint64 `json:"banned"`
+	// messages to keys not connected
+	UnknownKey uint64 `json:"unknown_key"`
+	// messages whose write to their client failed, the client is closed
+	WriteFailed uint64 `json:"write_failed"`
+}
+
+// ClientMetrics is a client connected to a discovery
+type ClientMetrics struct {
+	Key  string `json:"key"`
+	Type string `json:"type"`
+	// seconds since it connected
+	Connected int64 `json:"connected"`
+	Services  int   `json:"services"`
+	// bytes per second since the previous sample, 0 on the first
+	UploadBW   uint64 `json:"upload_bandwidth"`
+	DownloadBW uint64 `json:"download_bandwidth"`
+	// bytes sent to and received from the client
+	SentBytes     uint64 `json:"sent_bytes"`
+	ReceivedBytes uint64 `json:"received_bytes"`
+}
+
+// Ban is a key the discovery refuses until a time
+type Ban struct {
+	Key   string `json:"key"`
+	Until int64  `json:"until"`
+}
+
+// serverAdmin keeps the bans and error counters of a discovery
+type serverAdmin struct {
+	bans   map[cipher.PubKey]time.Time
+	errors ServerErrors
+	sync.Mutex
+}
+
+// throughput samples the bytes of a connection to tell its bandwidth between two samples
+type throughput struct {
+	last       time.Time
+	sent, recv uint64
+	sync.Mutex
+}
+
+func (t *throughput) sample(sent, recv uint64, now time.Time) (up, down uint64) {
+	t.Lock()
+	defer t.Unlock()
+	if !t.last.IsZero() {
+		if d := now.Sub(t.last).Seconds(); d > 0 {
+			up = uint64(float64(sent-t.sent) / d)
+			down = uint64(float64(recv-t.recv) / d)
+		}
+	}
+	t.last, t.sent, t.recv = now, sent, recv
+	return
+}
+
+func (f *MessengerFactory) countError(count func(e *ServerErrors)) {
+	a := &f.rootFactory().admin
+	a.Lock()
+	count(&a.errors)
+	a.Unlock()
+}
+
+// Disconnect closes the connection of the client with the key, false if it is not connected
+func (f *MessengerFactory) Disconnect(key cipher.PubKey) bool {
+	c, ok := f.GetConnection(key)
+	if ok {
+		c.GetContextLogger().Infof("disconnect client %s", key.Hex())
+		c.Close()
+	}
+	return ok
+}
+
+// Ban disconnects the client with the key and refuses its registrations for d
+func (f *MessengerFactory) Ban(key cipher.PubKey, d time.Duration) {
+	a := &f.rootFactory().admin
+	a.Lock()
+	if a.bans == nil {
+		a.bans = make(map[cipher.PubKey]time.Time)
+	}
+	a.bans[key] = time.Now().Add(d)
+	a.Unlock()
+	f.Disconnect(key)
+}
+
+// Unban lifts the ban of the key, false if it was not banned
+func (f *MessengerFactory) Unban(key cipher.PubKey) (ok bool) {
+	a := &f.rootFactory().admin
+	a.Lock()
+	_, ok = a.bans[key]
+	delete(a.bans, key)
+	a.Unlock()
+	return
+}
+
+// GetBans returns the keys banned now, the first to expire first
+func (f *MessengerFactory) GetBans() (bans []Ban) {
+	a := &f.rootFactory().admin
+	a.Lock()
+	a.pruneBans(time.Now())
+	bans = make([]Ban, 0, len(a.bans))
+	for k, until := range a.bans {
+		bans = append(bans, Ban{Key: k.Hex(), Until: until.Unix()})
+	}
+	a.Unlock()
+	sort.Slice(bans, func(i, j int) bool {
+		if bans[i].Until != bans[j].Until {
+			return bans[i].Until < bans[j].Until
+		}
+		return bans[i].Key < bans[j].Key
+	})
+	return
+}
+
+func (a *serverAdmin) pruneBans(now time.Time) {
+	for k, until := range a.bans {
+		if !now.Before(until) {
+			delete(a.bans, k)
+		}
+	}
+}
+
+// banned tells if the key is banned, counting the refused registration
+func (f *MessengerFactory) banned(key cipher.PubKey) bool {
+	a := &f.rootFactory().admin
+	a.Lock()
+	defer a.Unlock()
+	until, ok := a.bans[key]
+	if !ok {
+		return false
+	}
+	if !time.Now().Before(until) {
+		delete(a.bans, key)
+		return false
+	}
+	a.errors.Banned++
+	return true
+}
+
+// GetServerMetrics returns the clients of the discovery and the errors it counted
+func (f *MessengerFactory) GetServerMetrics() (m ServerMetrics) {
+	now := time.Now()
+	f.ForEachAcceptedConnection(func(key cipher.PubKey, conn *Connection) {
+		cm := ClientMetrics{
+			Key:           key.Hex(),
+			Type:          "UDP",
+			Connected:     now.Unix() - conn.GetConnectTime(),
+			SentBytes:     conn.GetSentBytes(),
+			ReceivedBytes: conn.GetReceivedBytes(),
+		}
+		if conn.IsTCP() {
+			cm.Type = "TCP"
+		}
+		if s := conn.GetServices(); s != nil {
+			cm.Services = len(s.Services)
+		}
+		cm.UploadBW, cm.DownloadBW = conn.throughput.sample(cm.SentBytes, cm.ReceivedBytes, now)
+		m.Services += cm.Services
+		m.ClientMetrics = append(m.ClientMetrics, cm)
+	})
+	m.Clients = len(m.ClientMetrics)
+	sort.Slice(m.ClientMetrics, func(i, j int) bool {
+		a, b := m.ClientMetrics[i], m.ClientMetrics[j]
+		if a.UploadBW+a.DownloadBW != b.UploadBW+b.DownloadBW {
+			return a.UploadBW+a.DownloadBW > b.UploadBW+b.DownloadBW
+		}
+		return a.Key < b.Key
+	})
+	if m.ClientMetrics == nil {
+		m.ClientMetrics = []ClientMetrics{}
+	}
+	m.Handshakes = f.GetHandshakeStats()
+	for _, p := range m.Handshakes.Patterns {
+		for _, n := range p.Failed {
+			m.Errors.Handshakes += n
+		}
+	}
+	m.Queries = f.GetQueryStats()
+	m.Sends = f.GetSendStats()
+	a := &f.rootFactory().admin
+	a.Lock()
+	a.pruneBans(now)
+	m.Banned = len(a.bans)
+	m.Errors.Banned = a.errors.Banned
+	m.Errors.UnknownKey = a.errors.UnknownKey
+	m.Errors.WriteFailed = a.errors.WriteFailed
+	a.Unlock()
+	return
+}
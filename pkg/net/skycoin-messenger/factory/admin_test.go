@@ -0,0 +1,93 @@
+package factory
+
+import (
+	"testing"
+	"time"
+
+	"github.com/skycoin/skycoin/src/cipher"
+	"github.com/skycoin/skywire/pkg/net/factory"
+)
+
+func TestThroughputSample(t *testing.T) {
+	start := time.Unix(100, 0)
+	var tp throughput
+	for _, c := range []struct {
+		sent, recv uint64
+		at         time.Duration
+		up, down   uint64
+	}{
+		// the first sample has nothing to compare with
+		{sent: 100, recv: 50, up: 0, down: 0},
+		{sent: 300, recv: 50, at: 2 * time.Second, up: 100, down: 0},
+		{sent: 300, recv: 1050, at: 4 * time.Second, up: 0, down: 500},
+		// sampled twice at once
+		{sent: 400, recv: 1050, at: 4 * time.Second, up: 0, down: 0},
+	} {
+		if up, down := tp.sample(c.sent, c.recv, start.Add(c.at)); up != c.up || down != c.down {
+			t.Errorf("%d/%d at %v: %d/%d, want %d/%d", c.sent, c.recv, c.at, up, down, c.up, c.down)
+		}
+	}
+}
+
+// synConn keeps the answers to the registrations written in order
+type synConn struct {
+	*fakeConn
+}
+
+func (c synConn) WriteSyn(b []byte) error { return c.Write(b) }
+
+func TestBans(t *testing.T) {
+	f := NewMessengerFactory()
+	if err := f.SetDefaultSeedConfig(NewSeedConfig()); err != nil {
+		t.Fatal(err)
+	}
+	// the accepted registrations encrypt to the key
+	a, _ := cipher.GenerateKeyPair()
+	b, _ := cipher.GenerateKeyPair()
+	c, _ := cipher.GenerateKeyPair()
+	f.Ban(a, time.Hour)
+	f.Ban(b, time.Minute)
+	// expired at once
+	f.Ban(c, -time.Second)
+	bans := f.GetBans()
+	if len(bans) != 2 || bans[0].Key != b.Hex() || bans[1].Key != a.Hex() {
+		t.Fatalf("bans %#v", bans)
+	}
+	reg := func(key cipher.PubKey) error {
+		conn, fake := newFakeConnection(f, "127.0.0.1:5000")
+		conn.Connection = &factory.Connection{Connection: synConn{fake}}
+		_, err := (&regWithKey{PublicKey: key, Version: RegWithKeyAndEncryptionVersion}).Execute(f, conn)
+		return err
+	}
+	for _, s := range []struct {
+		name   string
+		key    cipher.PubKey
+		unban  bool
+		banned bool
+		// the registrations refused so far
+		refused uint64
+	}{
+		{name: "banned", key: a, banned: true, refused: 1},
+		{name: "banned again", key: a, banned: true, refused: 2},
+		{name: "ban expired", key: c, refused: 2},
+		{name: "unbanned", key: a, unban: true, refused: 2},
+		{name: "other ban kept", key: b, banned: true, refused: 3},
+	} {
+		if s.unban && !f.Unban(s.key) {
+			t.Fatalf("%s: key not banned", s.name)
+		}
+		if err := reg(s.key); (err != nil) != s.banned {
+			t.Errorf("%s: %v", s.name, err)
+		}
+		m := f.GetServerMetrics()
+		if m.Errors.Banned != s.refused || m.Handshakes.Patterns["key_encryption"].Failed["banned"] != s.refused {
+			t.Errorf("%s: refused %d, metrics %#v", s.name, s.refused, m)
+		}
+	}
+	if f.Unban(a) || len(f.GetBans()) != 1 || f.GetServerMetrics().Banned != 1 {
+		t.Fatalf("bans %#v", f.GetBans())
+	}
+	if f.Disconnect(c) {
+		t.Fatal("disconnected a key not connected")
+	}
+}
@@ -0,0 +1,158 @@
+package factory
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// AnnounceSchedule of the announcements of the services of a node to its discoveries
+type AnnounceSchedule struct {
+	// the services are announced again this often, 0 to announce changes only
+	Refresh time.Duration
+	// the first announcement to a connected discovery waits a random time up to this,
+	// so that the nodes reconnecting after an outage of the discovery do not announce at once
+	ReconnectSpread time.Duration
+	// the changes within this long are announced at once, at least minAnnounceSettle
+	Settle time.Duration
+	// a failed announcement is retried after a wait doubling up to this
+	MaxBackoff time.Duration
+}
+
+var DefaultAnnounceSchedule = AnnounceSchedule{
+	Refresh:         30 * time.Minute,
+	ReconnectSpread: 10 * time.Second,
+	Settle:          time.Second,
+	MaxBackoff:      5 * time.Minute,
+}
+
+// the failed announcements back off from the settle time, with none they would be retried at once
+const minAnnounceSettle = 100 * time.Millisecond
+
+// SetAnnounceSchedule sets when the services are announced to the discoveries, a settle
+// time of 0 is the default one
+func (f *MessengerFactory) SetAnnounceSchedule(s AnnounceSchedule) {
+	if s.Settle <= 0 {
+		s.Settle = DefaultAnnounceSchedule.Settle
+	} else if s.Settle < minAnnounceSettle {
+		s.Settle = minAnnounceSettle
+	}
+	f.fieldsMutex.Lock()
+	f.announceSchedule = &s
+	f.fieldsMutex.Unlock()
+}
+
+func (f *MessengerFactory) GetAnnounceSchedule() (s AnnounceSchedule) {
+	f.fieldsMutex.RLock()
+	defer f.fieldsMutex.RUnlock()
+	if f.announceSchedule == nil {
+		return DefaultAnnounceSchedule
+	}
+	return *f.announceSchedule
+}
+
+// jitter returns d changed by a random amount of up to a tenth
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return d
+	}
+	return d - d/10 + time.Duration(rand.Int63n(int64(d/5)+1))
+}
+
+// spread returns a random wait up to d
+func spread(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d)))
+}
+
+// Backoff returns the jittered wait before the retry after the number of failures,
+// doubled from min for every failure up to max
+func Backoff(min, max time.Duration, failures int) time.Duration {
+	d := min
+	for i := 0; i < failures && d < max; i++ {
+		d *= 2
+	}
+	if max > 0 && d > max {
+		d = max
+	}
+	return jitter(d)
+}
+
+// announcer sends the services of the node to a discovery when they are due
+type announcer struct {
+	f    *MessengerFactory
+	conn *Connection
+
+	timer    *time.Timer
+	due      time.Time
+	failures int
+	sync.Mutex
+}
+
+func (f *MessengerFactory) getAnnouncer(conn *Connection) *announcer {
+	conn.fieldsMutex.Lock()
+	defer conn.fieldsMutex.Unlock()
+	if conn.announcer == nil {
+		conn.announcer = &announcer{f: f, conn: conn}
+	}
+	return conn.announcer
+}
+
+// announce sends the services to the discovery of conn after wait, or earlier if already due
+func (f *MessengerFactory) announce(conn *Connection, wait time.Duration) {
+	f.getAnnouncer(conn).schedule(wait)
+}
+
+// schedule the announcement after wait, unless one is due earlier
+func (a *announcer) schedule(wait time.Duration) {
+	a.Lock()
+	defer a.Unlock()
+	due := time.Now().Add(wait)
+	if a.timer != nil {
+		if a.due.Before(due) {
+			return
+		}
+		a.timer.Stop()
+	}
+	a.due = due
+	a.timer = time.AfterFunc(wait, a.run)
+}
+
+func (a *announcer) stop() {
+	a.Lock()
+	if a.timer != nil {
+		a.timer.Stop()
+		a.timer = nil
+	}
+	a.Unlock()
+}
+
+func (a *announcer) run() {
+	a.Lock()
+	a.timer = nil
+	a.Unlock()
+	if a.conn.IsClosed() {
+		return
+	}
+	s := a.f.GetAnnounceSchedule()
+	err := a.conn.UpdateServices(a.f.pack())
+	a.Lock()
+	if err != nil {
+		a.failures++
+	} else {
+		a.failures = 0
+	}
+	failures := a.failures
+	a.Unlock()
+	if err != nil {
+		wait := Backoff(s.Settle, s.MaxBackoff, failures)
+		a.conn.GetContextLogger().Errorf("announce services err %v, retry in %s", err, wait)
+		a.schedule(wait)
+		return
+	}
+	if s.Refresh > 0 {
+		a.schedule(jitter(s.Refresh))
+	}
+}
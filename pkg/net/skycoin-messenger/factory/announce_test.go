@@ -0,0 +1,99 @@
+package factory
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// within returns true if d is within a tenth of want
+func within(d, want time.Duration) bool {
+	return d >= want-want/10 && d <= want+want/10
+}
+
+func TestBackoff(t *testing.T) {
+	for _, c := range []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 100 * time.Millisecond},
+		{1, 200 * time.Millisecond},
+		{3, 800 * time.Millisecond},
+		{10, time.Second},
+	} {
+		for i := 0; i < 20; i++ {
+			if d := Backoff(100*time.Millisecond, time.Second, c.failures); !within(d, c.want) {
+				t.Fatalf("%d failures: %s", c.failures, d)
+			}
+		}
+	}
+	for i := 0; i < 20; i++ {
+		if d := spread(time.Second); d < 0 || d >= time.Second {
+			t.Fatalf("spread %s", d)
+		}
+	}
+	if spread(0) != 0 || jitter(0) != 0 {
+		t.Fatal("jitter without a time")
+	}
+}
+
+func TestAnnounceSchedule(t *testing.T) {
+	f := NewMessengerFactory()
+	if f.GetAnnounceSchedule() != DefaultAnnounceSchedule {
+		t.Fatalf("schedule %+v", f.GetAnnounceSchedule())
+	}
+	f.SetAnnounceSchedule(AnnounceSchedule{Refresh: time.Minute})
+	if s := f.GetAnnounceSchedule(); s.Settle != DefaultAnnounceSchedule.Settle || s.Refresh != time.Minute {
+		t.Fatalf("settle of 0 %+v", s)
+	}
+	f.SetAnnounceSchedule(AnnounceSchedule{Settle: time.Millisecond})
+	if s := f.GetAnnounceSchedule(); s.Settle != minAnnounceSettle {
+		t.Fatalf("settle below the minimum %+v", s)
+	}
+}
+
+func TestAnnouncer(t *testing.T) {
+	f := NewMessengerFactory()
+	// the announcements are run by the test, the timers never fire
+	f.SetAnnounceSchedule(AnnounceSchedule{Refresh: 10 * time.Hour, Settle: time.Hour, MaxBackoff: 3 * time.Hour})
+	c, fake := newFakeConnection(f, "10.0.0.1:1000")
+	a := f.getAnnouncer(c)
+	defer a.stop()
+	due := func() time.Duration {
+		a.Lock()
+		defer a.Unlock()
+		return time.Until(a.due)
+	}
+
+	// an announcement due earlier is kept
+	a.schedule(2 * time.Hour)
+	a.schedule(4 * time.Hour)
+	if d := due(); !within(d, 2*time.Hour) {
+		t.Fatalf("due in %s", d)
+	}
+	a.schedule(time.Hour)
+	if d := due(); !within(d, time.Hour) {
+		t.Fatalf("earlier announcement due in %s", d)
+	}
+
+	// the failed announcements back off from the settle time up to the maximum
+	fake.err = errors.New("closed")
+	for _, want := range []time.Duration{2 * time.Hour, 3 * time.Hour, 3 * time.Hour} {
+		a.stop()
+		a.run()
+		if d := due(); !within(d, want) {
+			t.Fatalf("retry in %s, want %s", d, want)
+		}
+	}
+
+	// once announced the services are refreshed
+	fake.err = nil
+	a.stop()
+	a.run()
+	if d := due(); len(fake.written) != 1 || !within(d, 10*time.Hour) {
+		t.Fatalf("%d announcements, refresh in %s", len(fake.written), d)
+	}
+	if a.failures != 0 {
+		t.Fatalf("%d failures after the announcement", a.failures)
+	}
+}
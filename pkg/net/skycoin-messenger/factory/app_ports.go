@@ -0,0 +1,179 @@
+package factory
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+// the port of a pair is dropped once its app stayed disconnected this long, the apps that
+// restart come back well before
+const appPortRelease = time.Hour
+
+// appPorts keeps the port a node serves an app pair on, so the address
+// saved by the app stays valid when the app or the node restarts
+type appPorts struct {
+	// empty path keeps the ports in memory only
+	path string
+	// port by from app and to app
+	ports map[string]int
+	used  map[int]string
+	// when the app of the pair disconnected, or the ports were loaded, by pair
+	released map[string]time.Time
+	sync.Mutex
+}
+
+func appPairKey(fromApp, toApp cipher.PubKey) string {
+	return fromApp.Hex() + "-" + toApp.Hex()
+}
+
+func newAppPorts(path string) (p *appPorts, err error) {
+	p = &appPorts{
+		path:     path,
+		ports:    make(map[string]int),
+		used:     make(map[int]string),
+		released: make(map[string]time.Time),
+	}
+	if len(path) < 1 {
+		return
+	}
+	d, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	err = json.Unmarshal(d, &p.ports)
+	if err != nil {
+		return
+	}
+	// the pairs are released until their apps come back
+	now := time.Now()
+	for k, v := range p.ports {
+		p.used[v] = k
+		p.released[k] = now
+	}
+	return
+}
+
+// reserved port of the pair, 0 if there is none
+func (p *appPorts) get(key string) int {
+	p.Lock()
+	defer p.Unlock()
+	return p.ports[key]
+}
+
+// ephemeral port that is not reserved by another pair
+func (p *appPorts) next(key string) (port int) {
+	p.Lock()
+	defer p.Unlock()
+	for i := 0; i < 60000-30000; i++ {
+		port = getAppPort()
+		if owner, ok := p.used[port]; !ok || owner == key {
+			return
+		}
+	}
+	return
+}
+
+// set makes the port sticky for the pair
+func (p *appPorts) set(key string, port int) (err error) {
+	p.Lock()
+	defer p.Unlock()
+	delete(p.released, key)
+	if p.ports[key] == port {
+		return
+	}
+	if old, ok := p.ports[key]; ok {
+		delete(p.used, old)
+	}
+	p.ports[key] = port
+	p.used[port] = key
+	return p.save()
+}
+
+// release starts the countdown of the ports of the pairs of the app that disconnected, the
+// pairs released for longer than appPortRelease are dropped
+func (p *appPorts) release(app cipher.PubKey) (err error) {
+	p.Lock()
+	defer p.Unlock()
+	now := time.Now()
+	hex := app.Hex()
+	for k := range p.ports {
+		if _, ok := p.released[k]; !ok && strings.Contains(k, hex) {
+			p.released[k] = now
+		}
+	}
+	var dropped bool
+	for k, t := range p.released {
+		if now.Sub(t) < appPortRelease {
+			continue
+		}
+		delete(p.used, p.ports[k])
+		delete(p.ports, k)
+		delete(p.released, k)
+		dropped = true
+	}
+	if !dropped {
+		return
+	}
+	return p.save()
+}
+
+func (p *appPorts) save() (err error) {
+	if len(p.path) < 1 {
+		return
+	}
+	d, err := json.Marshal(p.ports)
+	if err != nil {
+		return
+	}
+	err = os.MkdirAll(filepath.Dir(p.path), 0700)
+	if err != nil {
+		return
+	}
+	err = ioutil.WriteFile(p.path, d, 0600)
+	return
+}
+
+// SetAppPortsPath loads the app ports from the path and saves the new ones to it,
+// an empty path keeps them in memory only
+func (f *MessengerFactory) SetAppPortsPath(path string) (err error) {
+	p, err := newAppPorts(path)
+	if err != nil {
+		return
+	}
+	f.fieldsMutex.Lock()
+	f.appPorts = p
+	f.fieldsMutex.Unlock()
+	return
+}
+
+func (f *MessengerFactory) getAppPorts() (p *appPorts) {
+	f.fieldsMutex.RLock()
+	p = f.appPorts
+	f.fieldsMutex.RUnlock()
+	return
+}
+
+// GetAppPorts returns the ports reserved for the app pairs, keyed by "fromApp-toApp"
+func (f *MessengerFactory) GetAppPorts() (ports map[string]int) {
+	ports = make(map[string]int)
+	p := f.getAppPorts()
+	if p == nil {
+		return
+	}
+	p.Lock()
+	for k, v := range p.ports {
+		ports[k] = v
+	}
+	p.Unlock()
+	return
+}
@@ -0,0 +1,116 @@
+package factory
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+func TestAppPortsKeptAcrossRestarts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "app_ports")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state", "app_ports.json")
+	a, b, c := cipher.PubKey{0x02, 1}, cipher.PubKey{0x02, 2}, cipher.PubKey{0x02, 3}
+	ab, ac := appPairKey(a, b), appPairKey(a, c)
+
+	p, err := newAppPorts(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.get(ab) != 0 {
+		t.Fatal("port of a new pair")
+	}
+	port := p.next(ab)
+	if err = p.set(ab, port); err != nil {
+		t.Fatal(err)
+	}
+	// the ports of the other pairs are not handed out again
+	for i := 0; i < 100; i++ {
+		if p.next(ac) == port {
+			t.Fatalf("port %d of %s given to %s", port, ab, ac)
+		}
+	}
+
+	p, err = newAppPorts(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.get(ab) != port {
+		t.Fatalf("port %d after the restart, was %d", p.get(ab), port)
+	}
+	// loaded pairs are released until their apps come back
+	if _, ok := p.released[ab]; !ok {
+		t.Fatal("loaded pair not released")
+	}
+	if err = p.set(ab, port); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := p.released[ab]; ok {
+		t.Fatal("pair of a connected app released")
+	}
+}
+
+func TestAppPortsRelease(t *testing.T) {
+	dir, err := ioutil.TempDir("", "app_ports")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app_ports.json")
+	a, b, c := cipher.PubKey{0x02, 1}, cipher.PubKey{0x02, 2}, cipher.PubKey{0x02, 3}
+	ab, cb := appPairKey(a, b), appPairKey(c, b)
+	p, err := newAppPorts(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.set(ab, 31000)
+	p.set(cb, 31001)
+
+	// a disconnected app keeps its ports for a while
+	if err = p.release(a); err != nil {
+		t.Fatal(err)
+	}
+	if p.get(ab) != 31000 || p.get(cb) != 31001 {
+		t.Fatalf("ports dropped %v", p.ports)
+	}
+	// and loses them once it stayed away too long, the pairs of the other apps stay
+	p.released[ab] = time.Now().Add(-appPortRelease)
+	if err = p.release(c); err != nil {
+		t.Fatal(err)
+	}
+	if p.get(ab) != 0 || p.get(cb) != 31001 {
+		t.Fatalf("ports %v", p.ports)
+	}
+	if _, ok := p.used[31000]; ok {
+		t.Fatal("port of the dropped pair still used")
+	}
+	p, err = newAppPorts(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.get(ab) != 0 || p.get(cb) != 31001 {
+		t.Fatalf("saved ports %v", p.ports)
+	}
+}
+
+func TestAppPortsInMemory(t *testing.T) {
+	f := NewMessengerFactory()
+	if len(f.GetAppPorts()) != 0 {
+		t.Fatal("ports without them set")
+	}
+	if err := f.SetAppPortsPath(""); err != nil {
+		t.Fatal(err)
+	}
+	key := appPairKey(cipher.PubKey{0x02, 1}, cipher.PubKey{0x02, 2})
+	f.getAppPorts().set(key, 32000)
+	if ports := f.GetAppPorts(); len(ports) != 1 || ports[key] != 32000 {
+		t.Fatalf("ports %v", ports)
+	}
+}
@@ -0,0 +1,183 @@
+package factory
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+// busyRetryAfter is how long a dialing app is told to wait after a busy node refused it
+const busyRetryAfter = 30 * time.Second
+
+// Capacity is how many transports to its apps a node takes, announced to the discoveries.
+// The limits are soft, a discovery stops forwarding setups to a node announced full and the
+// node refuses the setups beyond them
+type Capacity struct {
+	// transports to the apps of the node at once, 0 for no limit
+	MaxTransports int `json:",omitempty"`
+	// transports to the apps of the node when it announced
+	Transports int `json:",omitempty"`
+	// bytes per second the node offers the transports, 0 if not announced
+	Bandwidth int64 `json:",omitempty"`
+	// unix time the maintenance of the node ends, 0 if it is not in maintenance
+	MaintenanceUntil int64 `json:",omitempty"`
+}
+
+func (c *Capacity) full() bool {
+	return c != nil && (c.MaxTransports > 0 && c.Transports >= c.MaxTransports || c.inMaintenance())
+}
+
+func (c *Capacity) inMaintenance() bool {
+	return c.MaintenanceUntil > time.Now().Unix()
+}
+
+// retryAfter is how long the app is told to wait, until the end of the maintenance if the
+// node is in maintenance
+func (c *Capacity) retryAfter() time.Duration {
+	if !c.inMaintenance() {
+		return busyRetryAfter
+	}
+	d := time.Until(time.Unix(c.MaintenanceUntil, 0))
+	if d < time.Second {
+		d = time.Second
+	}
+	return d
+}
+
+// SetCapacity limits the transports to the apps of the node, 0 for no limit, and sets the
+// bandwidth announced to the discoveries
+func (f *MessengerFactory) SetCapacity(maxTransports int, bandwidth int64) {
+	f.fieldsMutex.Lock()
+	f.capacity = Capacity{MaxTransports: maxTransports, Bandwidth: bandwidth}
+	f.fieldsMutex.Unlock()
+	f.capacityChanged()
+}
+
+// SetMaintenance drains the node until the time, zero to end the maintenance at once. The
+// node announces itself full and refuses the new transports to its apps, the apps are told
+// to retry when the maintenance ends, the transports open keep running until they close
+func (f *MessengerFactory) SetMaintenance(until time.Time) {
+	f.fieldsMutex.Lock()
+	f.maintenance = until
+	if f.maintenanceTimer != nil {
+		f.maintenanceTimer.Stop()
+		f.maintenanceTimer = nil
+	}
+	if !until.IsZero() {
+		// the discoveries learn the maintenance ended with the next announcement
+		f.maintenanceTimer = time.AfterFunc(time.Until(until)+time.Second, func() {
+			f.fieldsMutex.Lock()
+			if f.maintenance.Equal(until) {
+				f.maintenance = time.Time{}
+			}
+			f.fieldsMutex.Unlock()
+			f.capacityChanged()
+		})
+	}
+	f.fieldsMutex.Unlock()
+	f.capacityChanged()
+}
+
+// GetMaintenance returns the end of the maintenance, zero if the node is not in maintenance
+func (f *MessengerFactory) GetMaintenance() (until time.Time) {
+	f.fieldsMutex.RLock()
+	until = f.maintenance
+	f.fieldsMutex.RUnlock()
+	if !until.IsZero() && time.Now().After(until) {
+		until = time.Time{}
+	}
+	return
+}
+
+// GetCapacity returns the capacity of the node with the transports to its apps open now
+func (f *MessengerFactory) GetCapacity() (c Capacity) {
+	f.fieldsMutex.RLock()
+	c = f.capacity
+	if !f.maintenance.IsZero() {
+		c.MaintenanceUntil = f.maintenance.Unix()
+	}
+	f.fieldsMutex.RUnlock()
+	c.Transports = f.acceptedTransports()
+	return
+}
+
+// announcedCapacity is sent with the services, nil if the node sets none
+func (f *MessengerFactory) announcedCapacity() *Capacity {
+	c := f.GetCapacity()
+	if c.MaxTransports == 0 && c.Bandwidth == 0 && c.MaintenanceUntil == 0 {
+		return nil
+	}
+	return &c
+}
+
+// acceptedTransports counts the transports node B set up or is setting up for its apps
+func (f *MessengerFactory) acceptedTransports() int {
+	trs := make(map[*Transport]struct{})
+	f.pendingTransports.Range(func(key, value interface{}) bool {
+		trs[value.(*Transport)] = struct{}{}
+		return true
+	})
+	f.ForEachAcceptedConnection(func(key cipher.PubKey, conn *Connection) {
+		conn.ForEachTransport(func(t *Transport) {
+			if !t.IsClientSide() && !t.isClosed() {
+				trs[t] = struct{}{}
+			}
+		})
+	})
+	return len(trs)
+}
+
+func (t *Transport) isClosed() bool {
+	t.fieldsMutex.RLock()
+	defer t.fieldsMutex.RUnlock()
+	return t.factory == nil
+}
+
+// admitTransport returns an error and how long to wait if the node takes no more
+// transports to its apps
+func (f *MessengerFactory) admitTransport() (retryAfter time.Duration, err error) {
+	c := f.GetCapacity()
+	if !c.full() {
+		return
+	}
+	retryAfter = c.retryAfter()
+	if c.inMaintenance() {
+		err = fmt.Errorf("in maintenance, retry after %s", retryAfter.Round(time.Second))
+		return
+	}
+	err = fmt.Errorf("busy with %d transports, retry after %s", c.Transports, retryAfter)
+	return
+}
+
+// capacityChanged announces the services again when the node got full or has room again,
+// or its maintenance changed, so the discoveries stop or resume forwarding setups to it
+func (f *MessengerFactory) capacityChanged() {
+	if !f.Proxy {
+		return
+	}
+	c := f.GetCapacity()
+	full := c.full()
+	f.fieldsMutex.Lock()
+	changed := full != f.capacityFull || c.MaintenanceUntil != f.capacityMaintenance
+	f.capacityFull = full
+	f.capacityMaintenance = c.MaintenanceUntil
+	f.fieldsMutex.Unlock()
+	if !changed {
+		return
+	}
+	settle := f.GetAnnounceSchedule().Settle
+	f.ForEachConn(func(connection *Connection) {
+		f.announce(connection, settle)
+	})
+}
+
+// busyMsg tells node A that node B is full
+func busyMsg(cause string, retryAfter time.Duration) PriorityMsg {
+	return PriorityMsg{
+		Priority:   Busy,
+		Msg:        cause,
+		Type:       Failed,
+		RetryAfter: int64((retryAfter + time.Second - 1) / time.Second),
+	}
+}
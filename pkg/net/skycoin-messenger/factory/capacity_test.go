@@ -0,0 +1,104 @@
+package factory
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+func TestCapacityFull(t *testing.T) {
+	later := time.Now().Add(time.Hour).Unix()
+	for _, c := range []struct {
+		name       string
+		capacity   *Capacity
+		full       bool
+		retryAfter time.Duration
+	}{
+		{name: "none"},
+		{name: "no limit", capacity: &Capacity{Transports: 100, Bandwidth: 1 << 20}},
+		{name: "room left", capacity: &Capacity{MaxTransports: 2, Transports: 1}},
+		{name: "full", capacity: &Capacity{MaxTransports: 2, Transports: 2}, full: true, retryAfter: busyRetryAfter},
+		{name: "maintenance ended", capacity: &Capacity{MaintenanceUntil: time.Now().Add(-time.Hour).Unix()}},
+		{name: "in maintenance", capacity: &Capacity{MaintenanceUntil: later}, full: true, retryAfter: time.Hour},
+	} {
+		if full := c.capacity.full(); full != c.full {
+			t.Errorf("%s: full %v", c.name, full)
+		}
+		if !c.full {
+			continue
+		}
+		if d := c.capacity.retryAfter(); d > c.retryAfter || d < c.retryAfter-time.Minute {
+			t.Errorf("%s: retry after %s, want %s", c.name, d, c.retryAfter)
+		}
+	}
+}
+
+func TestBusyMsg(t *testing.T) {
+	for _, c := range []struct {
+		retryAfter time.Duration
+		seconds    int64
+	}{
+		{retryAfter: 30 * time.Second, seconds: 30},
+		{retryAfter: 1500 * time.Millisecond, seconds: 2},
+		{retryAfter: time.Millisecond, seconds: 1},
+	} {
+		if m := busyMsg("busy", c.retryAfter); m.Priority != Busy || m.Type != Failed || m.RetryAfter != c.seconds {
+			t.Errorf("%s: %#v, want retry after %ds", c.retryAfter, m, c.seconds)
+		}
+	}
+}
+
+func TestForwardToBusyNode(t *testing.T) {
+	fromNode, node := cipher.PubKey([33]byte{0x02, 1}), cipher.PubKey([33]byte{0x02, 2})
+	fromApp, app := cipher.PubKey([33]byte{0x03, 1}), cipher.PubKey([33]byte{0x03, 2})
+	for _, c := range []struct {
+		name string
+		// the node is connected to the discovery and announced the capacity
+		connected bool
+		capacity  *Capacity
+		priority  Priority
+	}{
+		// the discovery has no connection to read the capacity from
+		{name: "not connected", priority: NotFound},
+		{name: "no capacity", connected: true},
+		{name: "room left", connected: true, capacity: &Capacity{MaxTransports: 2, Transports: 1}},
+		{name: "full", connected: true, capacity: &Capacity{MaxTransports: 1, Transports: 1}, priority: Busy},
+		{name: "in maintenance", connected: true, capacity: &Capacity{MaintenanceUntil: time.Now().Add(time.Hour).Unix()}, priority: Busy},
+	} {
+		f := NewMessengerFactory()
+		conn, fake := newFakeConnection(f, "127.0.0.1:5000")
+		conn.SetKey(fromNode)
+		nodeConn, nodeFake := newFakeConnection(f, "127.0.0.1:5001")
+		nodeConn.SetKey(node)
+		nodeConn.setServices(&NodeServices{Services: []*Service{{Key: app}}, Capacity: c.capacity})
+		if c.connected {
+			f.regConnections[node] = nodeConn
+		}
+
+		req := &forwardNodeConn{FromNode: fromNode, Node: node, FromApp: fromApp, App: app, SetupID: "busy"}
+		if _, err := req.Execute(f, conn); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if c.priority == 0 {
+			if len(fake.written) != 0 || len(nodeFake.written) != 1 || nodeFake.written[0][MSG_OP_BEGIN] != OP_BUILD_NODE_CONN|RESP_PREFIX {
+				t.Errorf("%s: not forwarded, answered %x", c.name, fake.written)
+			}
+			if p, ok := globalTransportPairManagerInstance.get(fromApp, fromNode, node, app); ok {
+				p.close()
+			}
+			continue
+		}
+		var resp forwardNodeConnResp
+		if len(fake.written) != 1 || json.Unmarshal(fake.written[0][MSG_HEADER_END:], &resp) != nil {
+			t.Fatalf("%s: answered %x", c.name, fake.written)
+		}
+		if !resp.Failed || resp.Msg.Priority != c.priority || (c.priority == Busy) != (resp.Msg.RetryAfter > 0) {
+			t.Errorf("%s: answered %#v", c.name, resp)
+		}
+		if len(nodeFake.written) != 0 {
+			t.Errorf("%s: forwarded to the node", c.name)
+		}
+	}
+}
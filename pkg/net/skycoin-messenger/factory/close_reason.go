@@ -0,0 +1,125 @@
+package factory
+
+import (
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+func init() {
+	resps[OP_APP_CONN_CLOSED] = &sync.Pool{
+		New: func() interface{} {
+			return new(AppConnClosed)
+		},
+	}
+}
+
+// CloseReason tells why a connection of an app through a transport was closed. Both nodes
+// of the transport and the apps learn it, so a failure can be told apart from a normal close
+type CloseReason byte
+
+const (
+	// an older node or app closed without a reason
+	CloseUnknown CloseReason = iota
+	// the app on either end closed the connection or went away
+	CloseAppExit
+	// a quota or the peer lists of a node refused the transport
+	ClosePolicy
+	// nothing was received on the transport for too long
+	CloseIdleTimeout
+	// the connection between the nodes or to the discovery was lost
+	CloseRouteFailure
+	// the node on the other end is shutting down
+	ClosePeerShutdown
+	// the app gave up the connection while it was set up
+	CloseCancelled
+)
+
+var closeReasonNames = []string{
+	CloseUnknown:      "unknown",
+	CloseAppExit:      "app_exit",
+	ClosePolicy:       "policy",
+	CloseIdleTimeout:  "idle_timeout",
+	CloseRouteFailure: "route_failure",
+	ClosePeerShutdown: "peer_shutdown",
+	CloseCancelled:    "cancelled",
+}
+
+func (r CloseReason) String() string {
+	if int(r) < len(closeReasonNames) {
+		return closeReasonNames[r]
+	}
+	return fmt.Sprintf("reason_%d", r)
+}
+
+// Failed returns true if the connection did not end by a close of an app or node
+func (r CloseReason) Failed() bool {
+	switch r {
+	case CloseAppExit, ClosePeerShutdown, CloseCancelled:
+		return false
+	}
+	return true
+}
+
+// transportFailed returns true if the transport itself broke, a critical transport only
+// fails over to its standby then
+func (r CloseReason) transportFailed() bool {
+	switch r {
+	case CloseUnknown, CloseIdleTimeout, CloseRouteFailure:
+		return true
+	}
+	return false
+}
+
+// CloseError is returned by the reads of a connection closed with a reason
+type CloseError struct {
+	Reason CloseReason
+}
+
+func (e *CloseError) Error() string {
+	return "connection closed: " + e.Reason.String()
+}
+
+// AppConnClosed tells the app a connection to another app was closed, Local and Remote
+// are the addresses of the connection as the app sees them
+type AppConnClosed struct {
+	// the other app
+	App    cipher.PubKey `wire:"1"`
+	Local  string        `wire:"2"`
+	Remote string        `wire:"3"`
+	Reason CloseReason   `wire:"4"`
+}
+
+// run on app
+func (req *AppConnClosed) Run(conn *Connection) (err error) {
+	conn.GetContextLogger().Debugf("connection %s -> %s to app %x closed: %s", req.Local, req.Remote, req.App, req.Reason)
+	if conn.appConnClosedCallback != nil {
+		conn.appConnClosedCallback(req)
+	}
+	return
+}
+
+// appConnClosed tells the local app its connection appConn was closed for reason,
+// only apps announcing FeatureCloseReasons decode the message
+func (t *Transport) appConnClosed(appConn net.Conn, reason CloseReason) {
+	holder := t.appConnHolder
+	if holder == nil || !holder.getPeerFeatures().Has(FeatureCloseReasons) {
+		return
+	}
+	app := t.ToApp
+	if !t.clientSide {
+		app = t.FromApp
+	}
+	// the app dialed the transport or was dialed by it, the node sees its end reversed
+	err := holder.writeOP(OP_APP_CONN_CLOSED|RESP_PREFIX, &AppConnClosed{
+		App:    app,
+		Local:  appConn.RemoteAddr().String(),
+		Remote: appConn.LocalAddr().String(),
+		Reason: reason,
+	})
+	if err != nil {
+		t.Logger().Debugf("write app conn closed to app: %v", err)
+	}
+}
@@ -3,7 +3,6 @@ package factory
 import (
 	"crypto/aes"
 	"encoding/hex"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
@@ -15,11 +14,16 @@ import (
 	"github.com/skycoin/skycoin/src/cipher"
 	"github.com/skycoin/skywire/pkg/net/conn"
 	"github.com/skycoin/skywire/pkg/net/factory"
+	"github.com/skycoin/skywire/pkg/net/wire"
+	"github.com/skycoin/skywire/pkg/trace"
 )
 
 const keyWaitTimeout time.Duration = 60 * time.Second
 
 type Connection struct {
+	// accessed atomically, first for its 64-bit alignment on 32-bit platforms
+	connectTime int64
+
 	*factory.Connection
 	factory *MessengerFactory
 
@@ -40,21 +44,38 @@ type Connection struct {
 
 	proxyConnections map[uint32]*Connection
 
-	appTransports      map[cipher.PubKey]*Transport
+	appTransports map[cipher.PubKey]*Transport
+	// standby transports of the critical transports, by app
+	appStandbys map[cipher.PubKey]*Transport
+	// discovery of the last transport to the app
+	appDiscoveries     map[cipher.PubKey]cipher.PubKey
 	appTransportsMutex sync.RWMutex
+	// transports of node A set up for the app, until they close
+	setups sync.Map
+	// spans of the setups the app started, by setup id, until the node answers them
+	dialSpans sync.Map
 
 	CreatedByTransport *Transport
 	transportPair      *transportPair
 
-	connectTime int64
-
 	skipFactoryReg bool
 
+	// latest message schema the peer decodes, accessed atomically
+	peerSchema int32
+	// capabilities the peer announced in its registration
+	peerFeatures Features
+	// sends the services of the node to the discovery of the connection
+	announcer *announcer
+
 	appMessages        []PriorityMsg
 	appMessagesReadCnt int
 	appMessagesMutex   sync.RWMutex
 	appFeedback        *AppFeedback
 	appFeedbackMutex   sync.RWMutex
+	// the last answers of the discovery to the queries
+	queries queryCache
+	// bytes of the client last sampled by the metrics of the discovery
+	throughput throughput
 	// callbacks
 
 	// call after received response for FindServiceNodesByKeys
@@ -66,6 +87,9 @@ type Connection struct {
 	// call after received response for BuildAppConnection
 	appConnectionInitCallback func(resp *AppConnResp) *AppFeedback
 
+	// call after a connection of the app to another app was closed
+	appConnClosedCallback func(closed *AppConnClosed)
+
 	onConnected    func(connection *Connection)
 	onDisconnected func(connection *Connection)
 	reconnect      func()
@@ -160,6 +184,14 @@ func (c *Connection) IsKeySet() (b bool) {
 	return
 }
 
+// keyIfSet returns the key without waiting for it, ok is false if it is not set
+func (c *Connection) keyIfSet() (key cipher.PubKey, ok bool) {
+	c.fieldsMutex.RLock()
+	key, ok = c.key, c.keySet
+	c.fieldsMutex.RUnlock()
+	return
+}
+
 func (c *Connection) GetKey() cipher.PubKey {
 	c.fieldsMutex.RLock()
 	defer c.fieldsMutex.RUnlock()
@@ -227,18 +259,25 @@ func (c *Connection) GetServices() *NodeServices {
 }
 
 func (c *Connection) Reg() error {
+	c.handshakeStarted(anonymousRegVersion)
 	return c.Write(GenRegMsg())
 }
 
 func (c *Connection) RegWithKey(key cipher.PubKey, context map[string]string) error {
 	c.StoreContext(publicKey, key)
-	return c.writeOPSyn(OP_REG_KEY, &regWithKey{PublicKey: key, Context: context, Version: RegWithKeyAndEncryptionVersion})
+	c.handshakeStarted(RegWithKeyAndEncryptionVersion)
+	req := &regWithKey{PublicKey: key, Context: context, Version: RegWithKeyAndEncryptionVersion, Schema: wire.Version, Features: SupportedFeatures, Network: c.factory.announcedNetwork(), Versions: SupportedRegVersions}
+	c.StoreContext(regRequest, req)
+	return c.writeOPSyn(OP_REG_KEY, req)
 }
 
 func (c *Connection) RegWithKeys(key, target cipher.PubKey, context map[string]string) error {
 	c.StoreContext(publicKey, key)
 	c.SetTargetKey(target)
-	return c.writeOPSyn(OP_REG_KEY, &regWithKey{PublicKey: key, Context: context, Version: RegWithKeyAndEncryptionVersion})
+	c.handshakeStarted(RegWithKeyAndEncryptionVersion)
+	req := &regWithKey{PublicKey: key, Context: context, Version: RegWithKeyAndEncryptionVersion, Schema: wire.Version, Features: SupportedFeatures, Network: c.factory.announcedNetwork(), Versions: SupportedRegVersions}
+	c.StoreContext(regRequest, req)
+	return c.writeOPSyn(OP_REG_KEY, req)
 }
 
 // register services to discovery
@@ -249,6 +288,10 @@ func (c *Connection) UpdateServices(ns *NodeServices) (err error) {
 			return
 		}
 		ns.Version = []string{c.factory.GetAppVersion(), VERSION, conn.VERSION}
+		ns.NatType = c.factory.GetNatType()
+		ns.ExternalAddress = c.factory.GetExternalAddress()
+		ns.Time = time.Now().Unix()
+		ns.Capacity = c.factory.announcedCapacity()
 	}
 	c.setServices(ns)
 	if ns == nil {
@@ -314,6 +357,12 @@ func checkNodeServices(ns *NodeServices) (valid bool) {
 			return
 		}
 	}
+	if len(ns.ExternalAddress) > 0 {
+		valid = checkAddress(ns.ExternalAddress)
+		if !valid {
+			return
+		}
+	}
 	for _, s := range ns.Services {
 		valid = checkAttrs(s.Attributes)
 		if !valid {
@@ -369,13 +418,15 @@ func (c *Connection) OfferPrivateServiceWithAddress(address, version string, all
 
 // find services by attributes
 func (c *Connection) FindServiceNodesByAttributes(attrs ...string) error {
-	return c.writeOP(OP_QUERY_BY_ATTRS, newQueryByAttrs(attrs))
+	_, err := c.FindServiceNodesWithSeqByAttributes(attrs...)
+	return err
 }
 
 // find services by attributes
 func (c *Connection) FindServiceNodesWithSeqByAttributes(attrs ...string) (seq uint32, err error) {
 	q := newQueryByAttrs(attrs)
 	seq = q.Seq
+	q.ETag = c.queries.send(attrsQuery(q.Pages, q.Limit, attrs), seq)
 	err = c.writeOP(OP_QUERY_BY_ATTRS, q)
 	return
 }
@@ -384,17 +435,97 @@ func (c *Connection) FindServiceNodesWithSeqByAttributes(attrs ...string) (seq u
 func (c *Connection) FindServiceNodesWithSeqByAttributesAndPaging(pages, limit int, attrs ...string) (seq uint32, err error) {
 	q := newQueryByAttrsAndPage(pages, limit, attrs)
 	seq = q.Seq
+	q.ETag = c.queries.send(attrsQuery(pages, limit, attrs), seq)
 	err = c.writeOP(OP_QUERY_BY_ATTRS, q)
 	return
 }
 
 // find services nodes by service public keys
 func (c *Connection) FindServiceNodesByKeys(keys []cipher.PubKey) error {
-	return c.writeOP(OP_QUERY_SERVICE_NODES, newQuery(keys))
+	q := newQuery(keys)
+	q.ETag = c.queries.send(keysQuery(keys), q.Seq)
+	return c.writeOP(OP_QUERY_SERVICE_NODES, q)
 }
 
 func (c *Connection) BuildAppConnection(node, app, discovery cipher.PubKey) error {
-	return c.writeOP(OP_BUILD_APP_CONN, &appConn{Node: node, App: app, Discovery: discovery})
+	return c.BuildAppConnectionWithTimeouts(node, app, discovery, SetupTimeouts{})
+}
+
+// BuildAppConnectionWithTimeouts builds the connection with the timeouts of the setup phases,
+// the zero fields use the defaults of the nodes
+func (c *Connection) BuildAppConnectionWithTimeouts(node, app, discovery cipher.PubKey, timeouts SetupTimeouts) error {
+	return c.BuildAppConnectionWithOptions(node, app, discovery, AppDialOptions{Timeouts: timeouts})
+}
+
+// AppDialOptions of a connection built by an app
+type AppDialOptions struct {
+	// of the setup phases, the zero fields use the defaults of the nodes
+	Timeouts SetupTimeouts
+	// weights of the cost of the paths the node ranks, nil for the weights of the node
+	Cost *PathCost
+	// the node keeps a standby transport to the app and fails over to it when the transport closes
+	Critical bool
+	// the apps are sealed for the node of the app, the discovery only learns the nodes
+	Private bool
+	// discoveries the transport must avoid
+	Constraints *RouteConstraints
+	// names the setup in the logs and in CancelAppConnection, a new id if empty
+	SetupID string
+	// token of the app if it offers a knocked service
+	Knock []byte
+	// span the setup is a child of, the spans of the nodes join its trace
+	Trace *trace.SpanContext
+}
+
+func (c *Connection) BuildAppConnectionWithOptions(node, app, discovery cipher.PubKey, opts AppDialOptions) error {
+	id := opts.SetupID
+	if len(id) == 0 {
+		id = NewSetupID()
+	}
+	c.GetContextLogger().WithField("setup_id", id).Infof("build connection to node %x app %x", node, app)
+	req := &appConn{
+		Node:        node,
+		App:         app,
+		Discovery:   discovery,
+		SetupID:     id,
+		Cost:        opts.Cost,
+		Critical:    opts.Critical,
+		Private:     opts.Private,
+		Constraints: opts.Constraints,
+		Knock:       opts.Knock,
+		Trace:       opts.Trace,
+	}
+	if opts.Timeouts != (SetupTimeouts{}) {
+		req.Timeouts = &opts.Timeouts
+	}
+	// the setup starts here, the spans of the nodes are children of the span of the app
+	span := c.factory.getTracer().Start("app.dial", opts.Trace)
+	if span != nil {
+		span.SetAttribute("node", node.Hex())
+		span.SetAttribute("app", app.Hex())
+		span.SetAttribute("setup_id", id)
+		req.Trace = span.Context()
+		c.dialSpans.Store(id, span)
+	}
+	err := c.writeOP(OP_BUILD_APP_CONN, req)
+	if err != nil {
+		c.endDialSpan(id, err.Error())
+	}
+	return err
+}
+
+// endDialSpan ends the span of the setup the app started, failed if cause is not empty
+func (c *Connection) endDialSpan(setupID, cause string) {
+	v, ok := c.dialSpans.Load(setupID)
+	if !ok {
+		return
+	}
+	c.dialSpans.Delete(setupID)
+	s := v.(*trace.Span)
+	if len(cause) > 0 {
+		s.Fail(cause)
+	}
+	s.End()
 }
 
 func (c *Connection) Send(to cipher.PubKey, msg []byte) error {
@@ -437,7 +568,7 @@ OUTER:
 				if r != nil {
 					body := m[MSG_HEADER_END:]
 					if len(body) > 0 {
-						err = json.Unmarshal(body, r)
+						err = c.decodeBody(body, r)
 						if err != nil {
 							return
 						}
@@ -457,6 +588,9 @@ OUTER:
 					continue
 				}
 			}
+			if opn == OP_SEND_ACKED && c.factory.receiveReliable(c, m) {
+				continue
+			}
 
 			c.in <- m
 		}
@@ -487,6 +621,9 @@ func (c *Connection) Close() {
 		return
 	}
 	c.closed = true
+	if c.announcer != nil {
+		c.announcer.stop()
+	}
 	if c.reconnect != nil {
 		go c.reconnect()
 	}
@@ -497,6 +634,12 @@ func (c *Connection) Close() {
 		if !c.skipFactoryReg {
 			c.factory.unregister(c.key, c)
 		}
+		// the ports of the app are kept while the node shuts down, its apps come back with it
+		if ports := c.factory.getAppPorts(); ports != nil && !c.factory.isClosing() {
+			if err := ports.release(c.key); err != nil {
+				c.GetContextLogger().Errorf("save app ports: %v", err)
+			}
+		}
 		c.keySet = false
 	}
 	if c.in != nil {
@@ -507,13 +650,29 @@ func (c *Connection) Close() {
 		c.transportPair.close()
 	}
 
+	c.dialSpans.Range(func(k, v interface{}) bool {
+		c.endDialSpan(k.(string), "connection closed")
+		return true
+	})
+
+	// the app went away, or the node is shutting down and closes its apps
+	reason := CloseAppExit
+	if c.factory.isClosing() {
+		reason = ClosePeerShutdown
+	}
+	// closing a transport deletes it from the connection
 	c.appTransportsMutex.RLock()
-	if len(c.appTransports) > 0 {
-		for _, v := range c.appTransports {
-			v.Close()
-		}
+	transports := make([]*Transport, 0, len(c.appTransports))
+	for _, v := range c.appTransports {
+		transports = append(transports, v)
 	}
 	c.appTransportsMutex.RUnlock()
+	for _, v := range transports {
+		v.CloseWithReason(reason)
+	}
+	for _, tr := range c.takeStandbys() {
+		tr.CloseWithReason(reason)
+	}
 
 	c.Connection.Close()
 }
@@ -531,10 +690,16 @@ func (c *Connection) WaitForKey() (err error) {
 	select {
 	case <-time.After(keyWaitTimeout):
 		err = errors.New("reg timeout")
+		c.failHandshake(HandshakeFailureTimeout)
 		c.SetStatusToError(err)
 		c.Close()
 	case <-ok:
 		t2 := time.Now()
+		if c.IsKeySet() {
+			c.handshakeCompleted()
+		} else {
+			c.failHandshake(HandshakeFailureClosed)
+		}
 		c.GetContextLogger().WithField("elapsed", t2.Sub(t1)).Debug("WaitForKey completed")
 	}
 	return err
@@ -548,7 +713,7 @@ func (c *Connection) writeOPBytes(op byte, body []byte) error {
 }
 
 func (c *Connection) writeOP(op byte, object interface{}) error {
-	js, err := json.Marshal(object)
+	js, err := c.encodeBody(object)
 	if err != nil {
 		return err
 	}
@@ -561,7 +726,7 @@ func (c *Connection) writeOP(op byte, object interface{}) error {
 }
 
 func (c *Connection) writeOPSyn(op byte, object interface{}) error {
-	body, err := json.Marshal(object)
+	body, err := c.encodeBody(object)
 	if err != nil {
 		return err
 	}
@@ -633,17 +798,20 @@ func (c *Connection) IsSkipFactoryReg() (skip bool) {
 	return
 }
 
+// ForEachTransport calls fn with every transport of the app, the standbys included
 func (c *Connection) ForEachTransport(fn func(t *Transport)) {
 	filter := make(map[*Transport]struct{})
 	c.appTransportsMutex.RLock()
 	defer c.appTransportsMutex.RUnlock()
-	for _, tr := range c.appTransports {
-		_, ok := filter[tr]
-		if ok {
-			continue
+	for _, trs := range []map[cipher.PubKey]*Transport{c.appTransports, c.appStandbys} {
+		for _, tr := range trs {
+			_, ok := filter[tr]
+			if ok {
+				continue
+			}
+			filter[tr] = struct{}{}
+			fn(tr)
 		}
-		filter[tr] = struct{}{}
-		fn(tr)
 	}
 }
 
@@ -715,6 +883,17 @@ func (c *Connection) SetCrypto(pk cipher.PubKey, sk cipher.SecKey, target cipher
 	return
 }
 
+// SetPlain leaves the data of the connection unencrypted, for transports both nodes agreed
+// to run without encryption
+func (c *Connection) SetPlain() {
+	c.fieldsMutex.Lock()
+	defer c.fieldsMutex.Unlock()
+	if c.Connection.GetCrypto() != nil {
+		return
+	}
+	c.Connection.SetCrypto(conn.NewPlainCrypto())
+}
+
 func (c *Connection) SetTransportPair(pair *transportPair) {
 	c.fieldsMutex.Lock()
 	c.transportPair = pair
@@ -7,15 +7,24 @@ import (
 	"os"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	log "github.com/sirupsen/logrus"
 	"github.com/skycoin/skycoin/src/cipher"
 	"github.com/skycoin/skycoin/src/cipher/go-bip39"
+	"github.com/skycoin/skywire/pkg/net/util"
 )
 
 type ConnConfig struct {
 	Reconnect     bool
 	ReconnectWait time.Duration
+	// the wait doubles for every failed reconnect up to this, 0 to always wait ReconnectWait
+	ReconnectMaxWait time.Duration
+	// failed reconnects since the last connection, accessed atomically
+	reconnects int32
+	// 1 once connected, accessed atomically
+	everConnected int32
 
 	// generate seed, private key and public key for the connection
 	// seed config file path
@@ -39,12 +48,26 @@ type ConnConfig struct {
 
 	AppConnectionInitCallback func(resp *AppConnResp) *AppFeedback
 
+	// call after a connection of the app to another app was closed
+	AppConnClosedCallback func(closed *AppConnClosed)
+
 	// call after connected to server
 	OnConnected func(connection *Connection)
 	// call after disconnected
 	OnDisconnected func(connection *Connection)
 }
 
+// reconnectWait returns the jittered wait before the next reconnect
+func (config *ConnConfig) reconnectWait() time.Duration {
+	failures := int(atomic.AddInt32(&config.reconnects, 1)) - 1
+	return Backoff(config.ReconnectWait, config.ReconnectMaxWait, failures)
+}
+
+// connected starts the reconnects from ReconnectWait again
+func (config *ConnConfig) connected() {
+	atomic.StoreInt32(&config.reconnects, 0)
+}
+
 type SeedConfig struct {
 	Seed      string
 	SecKey    string
@@ -71,9 +94,18 @@ func (sc *SeedConfig) parse() (err error) {
 		return
 	}
 	sc.secKey = secKey
+	sc.lockSecKey()
 	return
 }
 
+// keep the secret key out of swap where the platform allows it
+func (sc *SeedConfig) lockSecKey() {
+	err := util.LockSecKey(&sc.secKey)
+	if err != nil {
+		log.Debugf("lock seed config secret key err %v", err)
+	}
+}
+
 func NewSeedConfig() *SeedConfig {
 	entropy, err := bip39.NewEntropy(128)
 	if err != nil {
@@ -91,6 +123,7 @@ func NewSeedConfig() *SeedConfig {
 		publicKey: pk,
 		secKey:    sk,
 	}
+	sc.lockSecKey()
 	return sc
 }
 
@@ -58,6 +58,19 @@ const (
 	// POW (unused)
 	OP_POW
 
+	// ask node B to dial back to node A
+	OP_REVERSE_CONN
+
+	// tell the app why its connection to another app was closed
+	OP_APP_CONN_CLOSED
+
+	// the app gave up a connection, node A tears down its setup at the discovery and node B
+	OP_CANCEL_APP_CONN
+	OP_CANCEL_NODE_CONN
+
+	// messages acknowledged by the peer, forwarded as OP_SEND
+	OP_SEND_ACKED
+
 	OP_SIZE
 )
 
@@ -0,0 +1,93 @@
+package factory
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+// pending returns true while the discovery waits for node B to answer the setup
+func (p *transportPair) pending() bool {
+	p.fieldsMutex.RLock()
+	defer p.fieldsMutex.RUnlock()
+	return !p.closed && p.timeoutTimer != nil
+}
+
+// crossingWins returns true if the setup from fromApp on fromNode is kept when the app it
+// dials dials back at the same time: the one dialed from the smaller node key, or from the
+// smaller app key if both apps are on one node, so both ends pick the same
+func crossingWins(fromNode, toNode, fromApp, toApp cipher.PubKey) bool {
+	if fromNode != toNode {
+		return bytes.Compare(fromNode[:], toNode[:]) < 0
+	}
+	return bytes.Compare(fromApp[:], toApp[:]) < 0
+}
+
+// resolveCrossing runs on the discovery before the setup is forwarded to node B. If node B
+// waits for a setup to the dialing app itself, the two apps dial each other and only one
+// transport is built: the losing setup is answered Crossed, its app gets the connection of
+// the other as a server instead. Returns true if req lost. Private setups name the apps by
+// route ids and are not matched
+func (req *forwardNodeConn) resolveCrossing(f *MessengerFactory, conn *Connection) (lost bool, err error) {
+	if len(req.Sealed) > 0 {
+		return
+	}
+	p, ok := globalTransportPairManagerInstance.get(req.App, req.Node, req.FromNode, req.FromApp)
+	if !ok || !p.pending() {
+		return
+	}
+	logger := conn.GetContextLogger().WithField("setup_id", req.SetupID)
+	if !crossingWins(req.FromNode, req.Node, req.FromApp, req.App) {
+		logger.Infof("transport from node %x app %x crossed the one of node %x app %x, dropped", req.FromNode, req.FromApp, req.Node, req.App)
+		lost = true
+		err = conn.writeOP(OP_FORWARD_NODE_CONN_RESP|RESP_PREFIX, &forwardNodeConnResp{
+			Node:     req.Node,
+			App:      req.App,
+			FromApp:  req.FromApp,
+			FromNode: req.FromNode,
+			Failed:   true,
+			Msg:      crossedMsg(req.Node, req.App),
+			Num:      req.Num,
+			SetupID:  req.SetupID,
+		})
+		return
+	}
+
+	// the other setup is dropped at its node A, and at node B, which is node A of req
+	logger.Infof("transport from node %x app %x crossed the one of node %x app %x, that one dropped", req.FromNode, req.FromApp, req.Node, req.App)
+	p.close()
+	if c, ok := f.GetConnection(req.Node); ok {
+		e := c.writeOP(OP_FORWARD_NODE_CONN_RESP|RESP_PREFIX, &forwardNodeConnResp{
+			Node:     req.FromNode,
+			App:      req.FromApp,
+			FromApp:  req.App,
+			FromNode: req.Node,
+			Failed:   true,
+			Msg:      crossedMsg(req.FromNode, req.FromApp),
+		})
+		if e != nil {
+			logger.Debugf("drop crossed transport of node %x: %v", req.Node, e)
+		}
+	}
+	if c, ok := f.GetConnection(req.FromNode); ok {
+		e := c.writeOP(OP_CANCEL_NODE_CONN|RESP_PREFIX, &cancelNodeConn{
+			Node:     req.FromNode,
+			App:      req.FromApp,
+			FromApp:  req.App,
+			FromNode: req.Node,
+		})
+		if e != nil {
+			logger.Debugf("drop crossed transport at node %x: %v", req.FromNode, e)
+		}
+	}
+	return
+}
+
+func crossedMsg(node, app cipher.PubKey) PriorityMsg {
+	return PriorityMsg{
+		Priority: Crossed,
+		Msg:      fmt.Sprintf("Node %x app %x dialed back at the same time, its connection is used", node, app),
+		Type:     Failed,
+	}
+}
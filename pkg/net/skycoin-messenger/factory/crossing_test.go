@@ -0,0 +1,92 @@
+package factory
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+func TestCrossingWins(t *testing.T) {
+	small, large := cipher.PubKey([33]byte{0x02, 1}), cipher.PubKey([33]byte{0x02, 2})
+	for _, c := range []struct {
+		name                             string
+		fromNode, toNode, fromApp, toApp cipher.PubKey
+		wins                             bool
+	}{
+		{name: "from the smaller node", fromNode: small, toNode: large, fromApp: large, toApp: small, wins: true},
+		{name: "from the larger node", fromNode: large, toNode: small, fromApp: small, toApp: large},
+		{name: "one node, smaller app", fromNode: small, toNode: small, fromApp: small, toApp: large, wins: true},
+		{name: "one node, larger app", fromNode: small, toNode: small, fromApp: large, toApp: small},
+	} {
+		if wins := crossingWins(c.fromNode, c.toNode, c.fromApp, c.toApp); wins != c.wins {
+			t.Errorf("%s: wins %v", c.name, wins)
+		}
+		// both ends of the crossing pick the same setup
+		if c.fromNode != c.toNode || c.fromApp != c.toApp {
+			if crossingWins(c.toNode, c.fromNode, c.toApp, c.fromApp) == c.wins {
+				t.Errorf("%s: both setups win or lose", c.name)
+			}
+		}
+	}
+}
+
+func TestResolveCrossing(t *testing.T) {
+	for i, c := range []struct {
+		name string
+		// req is dialed from the node with the smaller key
+		fromSmaller bool
+		// the discovery has a setup from the other app
+		other   bool
+		pending bool
+		sealed  bool
+		lost    bool
+	}{
+		{name: "no setup the other way"},
+		{name: "answered the other way", other: true},
+		{name: "crossed from the larger node", other: true, pending: true, lost: true},
+		{name: "crossed from the smaller node", fromSmaller: true, other: true, pending: true},
+		{name: "private setup", other: true, pending: true, sealed: true},
+	} {
+		small, large := cipher.PubKey([33]byte{0x02, byte(i), 1}), cipher.PubKey([33]byte{0x02, byte(i), 2})
+		fromApp, app := cipher.PubKey([33]byte{0x03, byte(i), 1}), cipher.PubKey([33]byte{0x03, byte(i), 2})
+		req := &forwardNodeConn{FromNode: large, Node: small, FromApp: fromApp, App: app, SetupID: "crossing"}
+		if c.fromSmaller {
+			req.FromNode, req.Node = small, large
+		}
+		if c.sealed {
+			req.Sealed = []byte{1}
+		}
+		var p *transportPair
+		if c.other {
+			p = globalTransportPairManagerInstance.create(req.App, req.Node, req.FromNode, req.FromApp)
+			if !c.pending {
+				p.ok()
+			}
+		}
+
+		f := NewMessengerFactory()
+		conn, fake := newFakeConnection(f, "127.0.0.1:5000")
+		lost, err := req.resolveCrossing(f, conn)
+		if err != nil || lost != c.lost {
+			t.Errorf("%s: lost %v, want %v: %v", c.name, lost, c.lost, err)
+		}
+		if c.lost {
+			var resp forwardNodeConnResp
+			if len(fake.written) != 1 || json.Unmarshal(fake.written[0][MSG_HEADER_END:], &resp) != nil ||
+				!resp.Failed || resp.Msg.Priority != Crossed || resp.SetupID != "crossing" {
+				t.Errorf("%s: answered %x", c.name, fake.written)
+			}
+		} else if len(fake.written) > 0 {
+			t.Errorf("%s: answered %x", c.name, fake.written)
+		}
+		// the winning setup drops the other one at the discovery
+		if p != nil {
+			_, kept := globalTransportPairManagerInstance.get(req.App, req.Node, req.FromNode, req.FromApp)
+			if dropped := c.pending && !c.lost && !c.sealed; kept == dropped {
+				t.Errorf("%s: other setup kept %v", c.name, kept)
+			}
+			p.close()
+		}
+	}
+}
@@ -1,21 +1,27 @@
 package factory
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/skycoin/skycoin/src/cipher"
+	"github.com/skycoin/skywire/pkg/failpoint"
 	"github.com/skycoin/skywire/pkg/net/conn"
 	"github.com/skycoin/skywire/pkg/net/factory"
 	"github.com/skycoin/skywire/pkg/net/msg"
+	"github.com/skycoin/skywire/pkg/trace"
 )
 
 type MessengerFactory struct {
+	// start with 64-bit counters accessed atomically, first for their alignment on 32-bit platforms
+	guard   handshakeGuard
+	queries queryGuard
+
 	factory             factory.Factory
 	udp                 *factory.UDPFactory
 	udpMutex            sync.Mutex
@@ -30,11 +36,88 @@ type MessengerFactory struct {
 
 	serviceDiscovery
 
+	handshakes handshakeMetrics
+
+	// server side transports by from node, from app and app
+	pendingTransports sync.Map
+
 	defaultSeedConfig *SeedConfig
 
 	Parent *MessengerFactory
 
-	appVersion string
+	appVersion      string
+	natType         string
+	externalAddress string
+	// nodes that transports without encryption are allowed with
+	plainTransportNodes map[cipher.PubKey]bool
+	// weights of the cost of the paths of the transports of node A, nil to ask all discoveries
+	pathCost *PathCost
+	// of the discoveries, the setups of the transports of node A through them
+	paths pathStats
+	// the last choices of the discoveries of the transports of node A
+	routeDecisions routeDecisionLog
+
+	// sticky ports of the app pairs, nil if ports are not reserved
+	appPorts *appPorts
+	// services sent with a time further off are ignored by the discovery, 0 to accept any
+	maxClockSkew time.Duration
+	// spans of the transport setups
+	tracer *trace.Tracer
+	// of the transport setups the apps do not set
+	setupTimeouts SetupTimeouts
+	// of the services announced to the discoveries, nil for the default
+	announceSchedule *AnnounceSchedule
+	// recent answers of the discoveries to the transports of node A
+	routes routeCache
+	// chooses the discoveries of the transports of node A in place of the cache, nil if none
+	routingPolicy RoutingPolicy
+	// what the node fixed after the last reconnects to the discoveries
+	reconciler reconciler
+	// transports to these apps are critical
+	criticalApps map[cipher.PubKey]bool
+	// the apps of all transports of node A are sealed for node B
+	privateSetups bool
+	// the discovery refuses the transports without a signed record
+	requireSignedTransports bool
+	// peers that do not bind the offer of their handshake are refused
+	requireHandshakeTranscript bool
+	// apps of the private setups of node A, by the route id of the app
+	privateRoutes sync.Map
+	// data limits of the transports of the apps, nil if not limited
+	quotas *quotas
+	// limits of the messages forwarded between the clients of a discovery, nil if not limited
+	sends *sendGuard
+	// keys banned from the discovery and the errors it counted
+	admin serverAdmin
+	// the messages sent with SendReliable and the acks of the peers, nil if not enabled
+	reliable *reliable
+	// transport setups of node A running and waiting for their turn, nil if not limited
+	setups *setupQueue
+	// called with every transport of the apps once it is closed
+	onTransportClosed func(t *Transport)
+	// called with the transports to the apps refused by the peer lists
+	onPeerRefused func(node, app cipher.PubKey)
+	// nodes the transports of the apps are allowed with, nil filter allows all
+	peerLists  PeerLists
+	peerFilter *peerFilter
+	// capabilities announced in the transport setups
+	transportFeatures Features
+	// what the transports of the apps learned of the other nodes, nil if not kept
+	peers *peerStore
+	// network of the factory, empty for the main network
+	network string
+	// connects to the servers, nil for net.Dial
+	dialer factory.Dialer
+	// transports to the apps the node takes, announced to the discoveries
+	capacity Capacity
+	// the announced capacity was full
+	capacityFull        bool
+	capacityMaintenance int64
+	// end of the maintenance of the node, zero if it is not in maintenance
+	maintenance      time.Time
+	maintenanceTimer *time.Timer
+	// Close was called, the transports closed with the apps tell the other nodes it shuts down
+	closing bool
 
 	fieldsMutex sync.RWMutex
 
@@ -49,19 +132,42 @@ type MessengerFactory struct {
 }
 
 func NewMessengerFactory() *MessengerFactory {
-	return &MessengerFactory{regConnections: make(map[cipher.PubKey]*Connection), serviceDiscovery: newServiceDiscovery()}
+	return &MessengerFactory{regConnections: make(map[cipher.PubKey]*Connection), serviceDiscovery: newServiceDiscovery(), transportFeatures: SupportedFeatures}
 }
 
 func (f *MessengerFactory) Listen(address string) (err error) {
 	tcp := factory.NewTCPFactory()
 	tcp.AcceptedCallback = f.acceptedCallback
 	f.fieldsMutex.Lock()
+	tcp.SetDialer(f.dialer)
 	f.factory = tcp
 	f.fieldsMutex.Unlock()
 	err = tcp.Listen(address)
 	if err != nil {
 		return
 	}
+	err = f.listenUDP(address)
+	return
+}
+
+// ListenOn accepts tcp connections on an existing listener,
+// the udp factory of a server listens on the same address
+func (f *MessengerFactory) ListenOn(ln *net.TCPListener) (err error) {
+	tcp := factory.NewTCPFactory()
+	tcp.AcceptedCallback = f.acceptedCallback
+	f.fieldsMutex.Lock()
+	tcp.SetDialer(f.dialer)
+	f.factory = tcp
+	f.fieldsMutex.Unlock()
+	err = tcp.ListenOn(ln)
+	if err != nil {
+		return
+	}
+	err = f.listenUDP(ln.Addr().String())
+	return
+}
+
+func (f *MessengerFactory) listenUDP(address string) (err error) {
 	if !f.Proxy {
 		udp := factory.NewUDPFactory()
 		udp.BeforeReadOnConn = f.BeforeReadOnConn
@@ -106,7 +212,7 @@ func (f *MessengerFactory) acceptedUDPCallback(connection *factory.Connection) {
 }
 
 func (f *MessengerFactory) callbackLoop(conn *Connection) (err error) {
-	go conn.WaitForKey()
+	go f.rootFactory().guard.waitForKey(conn)
 	var m []byte
 	var ok bool
 	defer func() {
@@ -133,7 +239,7 @@ func (f *MessengerFactory) callbackLoop(conn *Connection) (err error) {
 			if sop, ok := op.(simpleOP); ok {
 				body := m[MSG_HEADER_END:]
 				if len(body) > 0 {
-					err = json.Unmarshal(m[MSG_HEADER_END:], sop)
+					err = conn.decodeBody(body, sop)
 					if err != nil {
 						return
 					}
@@ -144,7 +250,7 @@ func (f *MessengerFactory) callbackLoop(conn *Connection) (err error) {
 					return
 				}
 				if r != nil {
-					rb, err = json.Marshal(r)
+					rb, err = conn.encodeBody(r)
 				}
 			} else if rop, ok := op.(rawOP); ok {
 				rb, err = rop.RawExecute(f, conn, m)
@@ -308,6 +414,17 @@ func (f *MessengerFactory) GetDefaultSeedConfig() (sc *SeedConfig) {
 	return
 }
 
+// SetDialer connects to the servers with d, like the discoveries run on several hosts behind
+// SRV records, nil for net.Dial
+func (f *MessengerFactory) SetDialer(d factory.Dialer) {
+	f.fieldsMutex.Lock()
+	f.dialer = d
+	if tcp, ok := f.factory.(*factory.TCPFactory); ok {
+		tcp.SetDialer(d)
+	}
+	f.fieldsMutex.Unlock()
+}
+
 func (f *MessengerFactory) ConnectWithConfig(address string, config *ConnConfig) (err error) {
 	var conn *Connection
 	defer func() {
@@ -318,6 +435,7 @@ func (f *MessengerFactory) ConnectWithConfig(address string, config *ConnConfig)
 	f.fieldsMutex.Lock()
 	if f.factory == nil {
 		tcpFactory := factory.NewTCPFactory()
+		tcpFactory.SetDialer(f.dialer)
 		f.factory = tcpFactory
 	}
 	c, err := f.factory.Connect(address)
@@ -325,7 +443,7 @@ func (f *MessengerFactory) ConnectWithConfig(address string, config *ConnConfig)
 	if err != nil {
 		if config != nil && config.Reconnect {
 			go func() {
-				time.Sleep(config.ReconnectWait)
+				time.Sleep(config.reconnectWait())
 				f.ConnectWithConfig(address, config)
 			}()
 		}
@@ -339,9 +457,10 @@ func (f *MessengerFactory) ConnectWithConfig(address string, config *ConnConfig)
 		conn.findServiceNodesByKeysCallback = config.FindServiceNodesByKeysCallback
 		conn.findServiceNodesByAttributesCallback = config.FindServiceNodesByAttributesCallback
 		conn.appConnectionInitCallback = config.AppConnectionInitCallback
+		conn.appConnClosedCallback = config.AppConnClosedCallback
 		if config.Reconnect {
 			conn.reconnect = func() {
-				time.Sleep(config.ReconnectWait)
+				time.Sleep(config.reconnectWait())
 				f.ConnectWithConfig(address, config)
 			}
 		}
@@ -372,6 +491,15 @@ func (f *MessengerFactory) ConnectWithConfig(address string, config *ConnConfig)
 		return
 	}
 	err = conn.WaitForKey()
+	if err == nil {
+		go f.resendReliable()
+	}
+	if err == nil && config != nil {
+		config.connected()
+		if config.reconnected() {
+			go f.reconcile(conn)
+		}
+	}
 	return
 }
 
@@ -466,6 +594,12 @@ func (f *MessengerFactory) acceptUDPWithConfig(address string, config *ConnConfi
 }
 
 func (f *MessengerFactory) Close() (err error) {
+	f.fieldsMutex.Lock()
+	f.closing = true
+	if f.reliable != nil {
+		f.reliable.close()
+	}
+	f.fieldsMutex.Unlock()
 	f.fieldsMutex.RLock()
 	defer f.fieldsMutex.RUnlock()
 	if f.factory != nil {
@@ -480,6 +614,14 @@ func (f *MessengerFactory) Close() (err error) {
 	return
 }
 
+// isClosing returns true once Close was called
+func (f *MessengerFactory) isClosing() (closing bool) {
+	f.fieldsMutex.RLock()
+	closing = f.closing
+	f.fieldsMutex.RUnlock()
+	return
+}
+
 // Execute fn for each connection that connected to server
 func (f *MessengerFactory) ForEachConn(fn func(connection *Connection)) {
 	f.factory.ForEachConn(func(conn *factory.Connection) {
@@ -498,19 +640,35 @@ func (f *MessengerFactory) ForEachConn(fn func(connection *Connection)) {
 	})
 }
 
+// SetMaxClockSkew sets how far off the time of the services sent by a node may be
+// from the clock of the discovery
+func (f *MessengerFactory) SetMaxClockSkew(d time.Duration) {
+	f.fieldsMutex.Lock()
+	f.maxClockSkew = d
+	f.fieldsMutex.Unlock()
+}
+
+func (f *MessengerFactory) GetMaxClockSkew() (d time.Duration) {
+	f.fieldsMutex.RLock()
+	d = f.maxClockSkew
+	f.fieldsMutex.RUnlock()
+	return
+}
+
 func (f *MessengerFactory) discoveryRegister(conn *Connection, ns *NodeServices) (err error) {
 	if ns != nil && !checkNodeServices(ns) {
 		err = fmt.Errorf("invalid NodeServices %#v", ns)
 		return
 	}
+	err = failpoint.Inject(FailpointDiscoveryRegister)
+	if err != nil {
+		return
+	}
 	if f.Proxy {
 		f.serviceDiscovery.register(conn, ns)
-		nodeServices := f.pack()
+		settle := f.GetAnnounceSchedule().Settle
 		f.ForEachConn(func(connection *Connection) {
-			err := connection.UpdateServices(nodeServices)
-			if err != nil {
-				connection.GetContextLogger().Errorf("discoveryRegister err %v", err)
-			}
+			f.announce(connection, settle)
 		})
 	} else {
 		f.serviceDiscovery.discoveryRegister(conn, ns)
@@ -518,27 +676,33 @@ func (f *MessengerFactory) discoveryRegister(conn *Connection, ns *NodeServices)
 	return
 }
 
+// ResyncToDiscovery announces the services to a connected discovery after a random wait
+// up to the ReconnectSpread of the announce schedule
 func (f *MessengerFactory) ResyncToDiscovery(connection *Connection) (err error) {
 	if !f.Proxy {
 		return
 	}
-	nodeServices := f.pack()
-	if nodeServices == nil {
+	if f.pack() == nil {
 		return
 	}
-	err = connection.UpdateServices(nodeServices)
-	if err != nil {
-		connection.GetContextLogger().Errorf("ResyncToDiscovery err %v", err)
-	}
+	f.announce(connection, spread(f.GetAnnounceSchedule().ReconnectSpread))
 	return
 }
 
 func (f *MessengerFactory) discoveryUnregister(conn *Connection) {
+	// the node connected again before the connection it lost was closed, the services it
+	// registered on the new connection stay
+	if key, ok := conn.keyIfSet(); ok {
+		if c, ok := f.GetConnection(key); ok && c != conn {
+			conn.setServices(nil)
+			return
+		}
+	}
 	if f.Proxy {
 		f.serviceDiscovery.unregister(conn)
-		nodeServices := f.pack()
+		settle := f.GetAnnounceSchedule().Settle
 		f.ForEachConn(func(connection *Connection) {
-			connection.UpdateServices(nodeServices)
+			f.announce(connection, settle)
 		})
 	} else {
 		f.serviceDiscovery.discoveryUnregister(conn)
@@ -588,3 +752,51 @@ func (f *MessengerFactory) GetAppVersion() (v string) {
 	f.fieldsMutex.RUnlock()
 	return
 }
+
+// SetNatType sets the nat type announced to discoveries with the services
+func (f *MessengerFactory) SetNatType(t string) {
+	f.fieldsMutex.Lock()
+	f.natType = t
+	f.fieldsMutex.Unlock()
+}
+
+func (f *MessengerFactory) GetNatType() (t string) {
+	f.fieldsMutex.RLock()
+	t = f.natType
+	f.fieldsMutex.RUnlock()
+	return
+}
+
+// SetPlainTransportNodes sets the nodes that transports are not encrypted with,
+// for links already encrypted and authenticated by a lower layer.
+// Both nodes must list each other, otherwise the transport stays encrypted
+func (f *MessengerFactory) SetPlainTransportNodes(keys []cipher.PubKey) {
+	nodes := make(map[cipher.PubKey]bool, len(keys))
+	for _, k := range keys {
+		nodes[k] = true
+	}
+	f.fieldsMutex.Lock()
+	f.plainTransportNodes = nodes
+	f.fieldsMutex.Unlock()
+}
+
+func (f *MessengerFactory) allowsPlainTransport(node cipher.PubKey) (ok bool) {
+	f.fieldsMutex.RLock()
+	ok = f.plainTransportNodes[node]
+	f.fieldsMutex.RUnlock()
+	return
+}
+
+// SetExternalAddress sets the address mapped on the gateway announced to discoveries
+func (f *MessengerFactory) SetExternalAddress(addr string) {
+	f.fieldsMutex.Lock()
+	f.externalAddress = addr
+	f.fieldsMutex.Unlock()
+}
+
+func (f *MessengerFactory) GetExternalAddress() (addr string) {
+	f.fieldsMutex.RLock()
+	addr = f.externalAddress
+	f.fieldsMutex.RUnlock()
+	return
+}
@@ -0,0 +1,11 @@
+package factory
+
+// failpoints of the factory, only compiled in with the failpoints tag, see pkg/failpoint
+const (
+	// the transport of node A was set up and is about to listen for its app
+	FailpointTransportInstall = "factory/transport-install"
+	// the signature of a connection was checked and its key is about to be set
+	FailpointHandshakeComplete = "factory/handshake-complete"
+	// the services of a node are about to be registered
+	FailpointDiscoveryRegister = "factory/discovery-register"
+)
@@ -0,0 +1,71 @@
+package factory
+
+import "strings"
+
+// Features is the bitmap of the transport capabilities a node supports, sent along with the
+// transport setup so both nodes use a capability only if the other node supports it too.
+// Older nodes send no bitmap and get no capability
+type Features uint32
+
+const (
+	// the data of the apps may be split into fragments smaller than the path mtu
+	FeatureFragmentation Features = 1 << iota
+	// the data of the apps may be compressed
+	FeatureCompression
+	// the nodes may signal a new key for the encryption of an open transport
+	FeatureRekey
+	// the nodes send why a transport is closed, an app announcing it is told why its connections close
+	FeatureCloseReasons
+	// the data frames carry their sequence in the connection of the apps, the receiving node
+	// puts them back in order
+	FeatureSequence
+)
+
+var featureNames = []struct {
+	feature Features
+	name    string
+}{
+	{FeatureFragmentation, "fragmentation"},
+	{FeatureCompression, "compression"},
+	{FeatureRekey, "rekey"},
+	{FeatureCloseReasons, "close_reasons"},
+	{FeatureSequence, "sequence"},
+}
+
+// SupportedFeatures are the capabilities the transports of this version implement, each
+// capability is added here once it is implemented
+const SupportedFeatures = FeatureCloseReasons | FeatureSequence
+
+// Has returns true if all the capabilities of o are set
+func (f Features) Has(o Features) bool {
+	return f&o == o
+}
+
+// Names returns the names of the known capabilities that are set
+func (f Features) Names() (names []string) {
+	for _, n := range featureNames {
+		if f.Has(n.feature) {
+			names = append(names, n.name)
+		}
+	}
+	return
+}
+
+func (f Features) String() string {
+	return strings.Join(f.Names(), ",")
+}
+
+// SetTransportFeatures sets the capabilities announced in the transport setups, to roll a
+// capability out or back, the ones this version does not implement are never announced
+func (f *MessengerFactory) SetTransportFeatures(features Features) {
+	f.fieldsMutex.Lock()
+	f.transportFeatures = features & SupportedFeatures
+	f.fieldsMutex.Unlock()
+}
+
+func (f *MessengerFactory) getTransportFeatures() (features Features) {
+	f.fieldsMutex.RLock()
+	features = f.transportFeatures
+	f.fieldsMutex.RUnlock()
+	return
+}
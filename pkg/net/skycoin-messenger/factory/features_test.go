@@ -0,0 +1,82 @@
+package factory
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/skycoin/skywire/pkg/net/wire"
+)
+
+func TestFeatures(t *testing.T) {
+	f := FeatureCloseReasons | FeatureSequence
+	if !f.Has(FeatureSequence) || !f.Has(FeatureCloseReasons|FeatureSequence) {
+		t.Fatal("a capability set is missing")
+	}
+	if f.Has(FeatureRekey) || f.Has(FeatureRekey|FeatureSequence) {
+		t.Fatal("a capability not set is had")
+	}
+	if s := f.String(); s != "close_reasons,sequence" {
+		t.Fatalf("got %q", s)
+	}
+	if s := Features(1 << 31).String(); s != "" {
+		t.Fatalf("an unknown capability named %q", s)
+	}
+}
+
+func TestSetTransportFeatures(t *testing.T) {
+	f := NewMessengerFactory()
+	if got := f.getTransportFeatures(); got != SupportedFeatures {
+		t.Fatalf("a new factory announces %s", got)
+	}
+	f.SetTransportFeatures(FeatureCompression | FeatureSequence)
+	if got := f.getTransportFeatures(); got != FeatureSequence {
+		t.Fatalf("got %s, a capability not implemented is announced", got)
+	}
+	f.SetTransportFeatures(0)
+	if got := f.getTransportFeatures(); got != 0 {
+		t.Fatalf("got %s, want none", got)
+	}
+}
+
+func TestTransportFeaturesNegotiated(t *testing.T) {
+	f := NewMessengerFactory()
+	f.SetTransportFeatures(FeatureCloseReasons)
+	tr := &Transport{creator: f}
+	tr.setFeatures(FeatureCloseReasons | FeatureSequence | FeatureRekey)
+	if got := tr.Features(); got != FeatureCloseReasons {
+		t.Fatalf("got %s, want the capabilities both nodes support", got)
+	}
+	// an older node sends no bitmap
+	tr.setFeatures(0)
+	if got := tr.Features(); got != 0 {
+		t.Fatalf("got %s with an older node", got)
+	}
+}
+
+func TestBuildConnFeatures(t *testing.T) {
+	req := &buildConn{Schema: wire.Version, Features: FeatureCloseReasons | FeatureSequence}
+	b, err := wire.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got buildConn
+	if err = wire.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Features != req.Features {
+		t.Fatalf("got %s, want %s", got.Features, req.Features)
+	}
+
+	// the json of an older node has no bitmap
+	old, err := json.Marshal(struct{ Address string }{"127.0.0.1:1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = buildConn{}
+	if err = json.Unmarshal(old, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Features != 0 {
+		t.Fatalf("an older node supports %s", got.Features)
+	}
+}
@@ -0,0 +1,231 @@
+package factory
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+// HandshakeProtection protects a server factory from handshake floods,
+// the zero value disables all protections
+type HandshakeProtection struct {
+	// require a stateless cookie round trip before the handshake state is allocated
+	// once this many handshakes are in progress, 0 disables the cookies and 1 always requires them
+	CookieThreshold int64
+	// handshakes per second allowed from a remote ip, 0 for no limit
+	IPRate float64
+	// handshakes per second allowed for a public key, counted once the key signed the challenge
+	// so that others cannot use up the handshakes of a key, 0 for no limit
+	KeyRate float64
+	// handshakes allowed at once above the rates
+	Burst int
+}
+
+const (
+	// a cookie is valid for the current and the previous period
+	cookiePeriod = 30 * time.Second
+	cookieSize   = 16
+	// rate limit buckets of idle ips and keys are dropped above this count
+	rateLimitMaxBuckets = 10000
+)
+
+var (
+	errCookieSent  = errors.New("handshake cookie sent")
+	errBadCookie   = errors.New("invalid handshake cookie")
+	errRateLimited = errors.New("handshake rate limited")
+)
+
+type HandshakeProtectionStats struct {
+	InProgress     int64  `json:"in_progress"`
+	CookiesSent    uint64 `json:"cookies_sent"`
+	CookiesInvalid uint64 `json:"cookies_invalid"`
+	IPLimited      uint64 `json:"ip_limited"`
+	KeyLimited     uint64 `json:"key_limited"`
+}
+
+type handshakeGuard struct {
+	// accessed atomically, first for their 64-bit alignment on 32-bit platforms
+	inProgress     int64
+	cookiesSent    uint64
+	cookiesInvalid uint64
+	ipLimited      uint64
+	keyLimited     uint64
+
+	config HandshakeProtection
+	secret []byte
+	ips    *rateLimiter
+	keys   *rateLimiter
+
+	sync.RWMutex
+}
+
+// SetHandshakeProtection enables the handshake DoS protections of a server factory
+func (f *MessengerFactory) SetHandshakeProtection(p HandshakeProtection) (err error) {
+	secret := make([]byte, 32)
+	_, err = rand.Read(secret)
+	if err != nil {
+		return
+	}
+	g := &f.rootFactory().guard
+	g.Lock()
+	g.config = p
+	g.secret = secret
+	g.ips = newRateLimiter(p.IPRate, p.Burst)
+	g.keys = newRateLimiter(p.KeyRate, p.Burst)
+	g.Unlock()
+	return
+}
+
+func (g *handshakeGuard) snapshot() *HandshakeProtectionStats {
+	g.RLock()
+	enabled := g.secret != nil
+	g.RUnlock()
+	if !enabled {
+		return nil
+	}
+	return &HandshakeProtectionStats{
+		InProgress:     atomic.LoadInt64(&g.inProgress),
+		CookiesSent:    atomic.LoadUint64(&g.cookiesSent),
+		CookiesInvalid: atomic.LoadUint64(&g.cookiesInvalid),
+		IPLimited:      atomic.LoadUint64(&g.ipLimited),
+		KeyLimited:     atomic.LoadUint64(&g.keyLimited),
+	}
+}
+
+func (g *handshakeGuard) cookie(ip string, key cipher.PubKey, period int64) []byte {
+	mac := hmac.New(sha256.New, g.secret)
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(period))
+	mac.Write(b[:])
+	mac.Write([]byte(ip))
+	mac.Write(key[:])
+	return mac.Sum(nil)[:cookieSize]
+}
+
+func (g *handshakeGuard) validCookie(cookie []byte, ip string, key cipher.PubKey) bool {
+	period := time.Now().Unix() / int64(cookiePeriod/time.Second)
+	return hmac.Equal(cookie, g.cookie(ip, key, period)) || hmac.Equal(cookie, g.cookie(ip, key, period-1))
+}
+
+// check runs before any state of the handshake of a key (key) with the cookie (cookie)
+// is allocated, errCookieSent means the client has to retry with the cookie. The key is not
+// verified yet, only the ip is rate limited.
+func (g *handshakeGuard) check(conn *Connection, key cipher.PubKey, cookie []byte) (err error) {
+	g.RLock()
+	config, secret, ips := g.config, g.secret, g.ips
+	g.RUnlock()
+	if secret == nil {
+		return
+	}
+	ip, _, err := net.SplitHostPort(conn.GetRemoteAddr().String())
+	if err != nil {
+		return
+	}
+	if !ips.allow(ip) {
+		atomic.AddUint64(&g.ipLimited, 1)
+		err = errRateLimited
+		return
+	}
+	if config.CookieThreshold > 0 && atomic.LoadInt64(&g.inProgress) >= config.CookieThreshold {
+		if len(cookie) == 0 {
+			period := time.Now().Unix() / int64(cookiePeriod/time.Second)
+			err = conn.writeOP(OP_REG_KEY|RESP_PREFIX, &regWithKeyResp{Cookie: g.cookie(ip, key, period)})
+			if err == nil {
+				atomic.AddUint64(&g.cookiesSent, 1)
+				err = errCookieSent
+			}
+			return
+		}
+		if !g.validCookie(cookie, ip, key) {
+			atomic.AddUint64(&g.cookiesInvalid, 1)
+			err = errBadCookie
+			return
+		}
+	}
+	return
+}
+
+// checkKey rate limits the handshakes of a key once it signed the challenge
+func (g *handshakeGuard) checkKey(key cipher.PubKey) (err error) {
+	g.RLock()
+	secret, keys := g.secret, g.keys
+	g.RUnlock()
+	if secret == nil {
+		return
+	}
+	if !keys.allow(key.Hex()) {
+		atomic.AddUint64(&g.keyLimited, 1)
+		err = errRateLimited
+	}
+	return
+}
+
+// waitForKey counts the handshakes of accepted connections in progress
+func (g *handshakeGuard) waitForKey(conn *Connection) {
+	atomic.AddInt64(&g.inProgress, 1)
+	conn.WaitForKey()
+	atomic.AddInt64(&g.inProgress, -1)
+}
+
+type rateLimiter struct {
+	rate    float64
+	burst   float64
+	buckets map[string]*tokenBucket
+	sync.Mutex
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{rate: rate, burst: float64(burst), buckets: make(map[string]*tokenBucket)}
+}
+
+func (l *rateLimiter) allow(key string) bool {
+	if l == nil || l.rate <= 0 {
+		return true
+	}
+	now := time.Now()
+	l.Lock()
+	defer l.Unlock()
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= rateLimitMaxBuckets {
+			l.prune(now)
+		}
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * l.rate
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// drop the buckets that are full again
+func (l *rateLimiter) prune(now time.Time) {
+	for k, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, k)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package factory
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/skycoin/skycoin/src/cipher"
+	cn "github.com/skycoin/skywire/pkg/net/conn"
+	"github.com/skycoin/skywire/pkg/net/factory"
+)
+
+// fakeConn is a connection from addr that keeps what is written to it, or fails the writes
+// with err, the other methods are the ones of a tcp connection that was never opened
+type fakeConn struct {
+	cn.Connection
+	addr    net.Addr
+	written [][]byte
+	err     error
+}
+
+func (c *fakeConn) GetRemoteAddr() net.Addr { return c.addr }
+
+func (c *fakeConn) Write(b []byte) error {
+	if c.err != nil {
+		return c.err
+	}
+	c.written = append(c.written, b)
+	return nil
+}
+
+func newFakeConnection(f *MessengerFactory, addr string) (*Connection, *fakeConn) {
+	tcp, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		panic(err)
+	}
+	fake := &fakeConn{Connection: &cn.TCPConn{ConnCommonFields: cn.NewConnCommonFileds()}, addr: tcp}
+	c := newTestConnection()
+	c.Connection = &factory.Connection{Connection: fake}
+	c.factory = f
+	return c, fake
+}
+
+func TestRateLimiter(t *testing.T) {
+	var none *rateLimiter
+	if !none.allow("a") || !newRateLimiter(0, 1).allow("a") {
+		t.Fatal("limited without a rate")
+	}
+
+	l := newRateLimiter(1, 2)
+	for i := 0; i < 2; i++ {
+		if !l.allow("a") {
+			t.Fatalf("handshake %d over the burst", i)
+		}
+	}
+	if l.allow("a") {
+		t.Fatal("allowed above the burst")
+	}
+	if !l.allow("b") {
+		t.Fatal("another ip limited")
+	}
+
+	// a second later a is allowed one more
+	l.buckets["a"].last = l.buckets["a"].last.Add(-time.Second)
+	if !l.allow("a") {
+		t.Fatal("not refilled")
+	}
+	if l.allow("a") {
+		t.Fatal("refilled over the rate")
+	}
+
+	// b is full again an hour later, a is not
+	l.buckets["b"].last = l.buckets["b"].last.Add(-time.Hour)
+	l.prune(time.Now())
+	if _, ok := l.buckets["b"]; ok {
+		t.Fatal("an idle bucket kept")
+	}
+	if _, ok := l.buckets["a"]; !ok {
+		t.Fatal("a limited bucket dropped")
+	}
+}
+
+func TestHandshakeCookie(t *testing.T) {
+	f := NewMessengerFactory()
+	if err := f.SetHandshakeProtection(HandshakeProtection{CookieThreshold: 1}); err != nil {
+		t.Fatal(err)
+	}
+	g := &f.guard
+	key := cipher.PubKey([33]byte{0x01})
+	period := time.Now().Unix() / int64(cookiePeriod/time.Second)
+
+	if c := g.cookie("10.0.0.1", key, period); len(c) != cookieSize || !g.validCookie(c, "10.0.0.1", key) {
+		t.Fatalf("cookie %x invalid", c)
+	}
+	if !g.validCookie(g.cookie("10.0.0.1", key, period-1), "10.0.0.1", key) {
+		t.Fatal("a cookie of the previous period invalid")
+	}
+	if g.validCookie(g.cookie("10.0.0.1", key, period-2), "10.0.0.1", key) {
+		t.Fatal("an expired cookie valid")
+	}
+	c := g.cookie("10.0.0.1", key, period)
+	if g.validCookie(c, "10.0.0.2", key) {
+		t.Fatal("a cookie valid from another ip")
+	}
+	if g.validCookie(c, "10.0.0.1", cipher.PubKey([33]byte{0x02})) {
+		t.Fatal("a cookie valid for another key")
+	}
+
+	// a new secret invalidates the cookies
+	if err := f.SetHandshakeProtection(HandshakeProtection{CookieThreshold: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if g.validCookie(c, "10.0.0.1", key) {
+		t.Fatal("a cookie of the old secret valid")
+	}
+}
+
+func TestHandshakeGuardCheck(t *testing.T) {
+	f := NewMessengerFactory()
+	key := cipher.PubKey([33]byte{0x01})
+	conn, fake := newFakeConnection(f, "10.0.0.1:1000")
+	if err := f.guard.check(conn, key, nil); err != nil || f.guard.snapshot() != nil {
+		t.Fatalf("a guard without protection: %v", err)
+	}
+
+	err := f.SetHandshakeProtection(HandshakeProtection{CookieThreshold: 2, IPRate: 1, KeyRate: 1, Burst: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := &f.guard
+	if err := g.check(conn, key, nil); err != nil {
+		t.Fatalf("a cookie required below the threshold: %v", err)
+	}
+
+	// two handshakes in progress
+	g.inProgress = 2
+	if err := g.check(conn, key, nil); err != errCookieSent {
+		t.Fatalf("got %v, want %v", err, errCookieSent)
+	}
+	if len(fake.written) != 1 || fake.written[0][MSG_OP_BEGIN] != OP_REG_KEY|RESP_PREFIX {
+		t.Fatalf("written %x", fake.written)
+	}
+	var resp regWithKeyResp
+	if err := json.Unmarshal(fake.written[0][MSG_HEADER_END:], &resp); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.check(conn, key, resp.Cookie); err != nil {
+		t.Fatalf("the cookie sent refused: %v", err)
+	}
+	if err := g.check(conn, key, []byte("forged")); err != errRateLimited {
+		t.Fatalf("got %v, want the ip limited past its burst", err)
+	}
+	other, _ := newFakeConnection(f, "10.0.0.2:1000")
+	if err := g.check(other, key, resp.Cookie); err != errBadCookie {
+		t.Fatalf("got %v, want %v", err, errBadCookie)
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := g.checkKey(key); err != nil {
+			t.Fatalf("handshake %d of the key: %v", i, err)
+		}
+	}
+	if err := g.checkKey(key); err != errRateLimited {
+		t.Fatalf("got %v, want the key limited past its burst", err)
+	}
+
+	stats := g.snapshot()
+	if stats == nil || stats.InProgress != 2 || stats.CookiesSent != 1 || stats.CookiesInvalid != 1 ||
+		stats.IPLimited != 1 || stats.KeyLimited != 1 {
+		t.Fatalf("stats %+v", stats)
+	}
+}
@@ -0,0 +1,235 @@
+package factory
+
+import (
+	"sync"
+	"time"
+)
+
+type HandshakeFailure int
+
+const (
+	// signature of the remote static key could not be verified
+	HandshakeFailureBadKey HandshakeFailure = iota
+	// key was not set before keyWaitTimeout
+	HandshakeFailureTimeout
+	// crypto could not be set up or used for the connection
+	HandshakeFailureDecrypt
+	// connection was closed before the handshake completed
+	HandshakeFailureClosed
+	// too many handshakes from the remote ip or for the public key
+	HandshakeFailureRateLimited
+	// cookie of the retried handshake was wrong or expired
+	HandshakeFailureBadCookie
+	// peer belongs to another network
+	HandshakeFailureNetwork
+	// the offer or the answer was changed on the way, or the peer does not bind the handshake
+	HandshakeFailureDowngrade
+	// the key of the peer is banned from the discovery
+	HandshakeFailureBanned
+)
+
+func (hf HandshakeFailure) String() string {
+	switch hf {
+	case HandshakeFailureBadKey:
+		return "bad_static_key"
+	case HandshakeFailureTimeout:
+		return "timeout"
+	case HandshakeFailureDecrypt:
+		return "decrypt_error"
+	case HandshakeFailureClosed:
+		return "closed"
+	case HandshakeFailureRateLimited:
+		return "rate_limited"
+	case HandshakeFailureBadCookie:
+		return "bad_cookie"
+	case HandshakeFailureNetwork:
+		return "wrong_network"
+	case HandshakeFailureDowngrade:
+		return "downgrade"
+	case HandshakeFailureBanned:
+		return "banned"
+	}
+	return "unknown"
+}
+
+func (v RegVersion) String() string {
+	switch v {
+	case regWithKeyVersion:
+		return "key"
+	case RegWithKeyAndEncryptionVersion:
+		return "key_encryption"
+	}
+	return "anonymous"
+}
+
+// anonymous OP_REG handshakes have no version, use -1 for them
+const anonymousRegVersion RegVersion = -1
+
+// upper bounds of the handshake duration buckets in milliseconds
+var handshakeDurationBuckets = []int64{10, 50, 100, 250, 500, 1000, 2500, 5000, 10000, 30000, 60000}
+
+type HandshakePatternStats struct {
+	Started   uint64            `json:"started"`
+	Completed uint64            `json:"completed"`
+	Failed    map[string]uint64 `json:"failed"`
+	// bucket upper bound in ms => count, "+Inf" for the rest
+	Durations map[string]uint64 `json:"durations_ms"`
+	// sum of all completed durations in ms
+	DurationSum int64 `json:"duration_sum_ms"`
+}
+
+type HandshakeStats struct {
+	Patterns map[string]*HandshakePatternStats `json:"patterns"`
+	// cookies and rate limits, nil if the protection is disabled
+	Protection *HandshakeProtectionStats `json:"protection,omitempty"`
+}
+
+type handshakeMetrics struct {
+	patterns map[RegVersion]*handshakePattern
+	sync.Mutex
+}
+
+type handshakePattern struct {
+	started     uint64
+	completed   uint64
+	failed      map[HandshakeFailure]uint64
+	buckets     []uint64
+	durationSum int64
+}
+
+func (m *handshakeMetrics) pattern(v RegVersion) *handshakePattern {
+	if m.patterns == nil {
+		m.patterns = make(map[RegVersion]*handshakePattern)
+	}
+	p, ok := m.patterns[v]
+	if !ok {
+		p = &handshakePattern{
+			failed:  make(map[HandshakeFailure]uint64),
+			buckets: make([]uint64, len(handshakeDurationBuckets)+1),
+		}
+		m.patterns[v] = p
+	}
+	return p
+}
+
+func (m *handshakeMetrics) started(v RegVersion) {
+	m.Lock()
+	m.pattern(v).started++
+	m.Unlock()
+}
+
+func (m *handshakeMetrics) completed(v RegVersion, d time.Duration) {
+	ms := int64(d / time.Millisecond)
+	m.Lock()
+	p := m.pattern(v)
+	p.completed++
+	p.durationSum += ms
+	i := 0
+	for ; i < len(handshakeDurationBuckets); i++ {
+		if ms <= handshakeDurationBuckets[i] {
+			break
+		}
+	}
+	p.buckets[i]++
+	m.Unlock()
+}
+
+func (m *handshakeMetrics) failed(v RegVersion, reason HandshakeFailure) {
+	m.Lock()
+	m.pattern(v).failed[reason]++
+	m.Unlock()
+}
+
+func (m *handshakeMetrics) snapshot() (stats HandshakeStats) {
+	stats.Patterns = make(map[string]*HandshakePatternStats)
+	m.Lock()
+	defer m.Unlock()
+	for v, p := range m.patterns {
+		ps := &HandshakePatternStats{
+			Started:     p.started,
+			Completed:   p.completed,
+			Failed:      make(map[string]uint64),
+			Durations:   make(map[string]uint64),
+			DurationSum: p.durationSum,
+		}
+		for reason, n := range p.failed {
+			ps.Failed[reason.String()] = n
+		}
+		for i, n := range p.buckets {
+			if i < len(handshakeDurationBuckets) {
+				ps.Durations[formatBucket(handshakeDurationBuckets[i])] = n
+			} else {
+				ps.Durations["+Inf"] = n
+			}
+		}
+		stats.Patterns[v.String()] = ps
+	}
+	return
+}
+
+func formatBucket(ms int64) string {
+	return time.Duration(ms * int64(time.Millisecond)).String()
+}
+
+// the metrics of transport factories are accounted to the node factory
+func (f *MessengerFactory) rootFactory() *MessengerFactory {
+	for f.Parent != nil {
+		f = f.Parent
+	}
+	return f
+}
+
+// Get handshake counters, duration histograms and classified failures
+func (f *MessengerFactory) GetHandshakeStats() (stats HandshakeStats) {
+	root := f.rootFactory()
+	stats = root.handshakes.snapshot()
+	stats.Protection = root.guard.snapshot()
+	return
+}
+
+func (c *Connection) getHandshakeVersion() RegVersion {
+	v, ok := c.LoadContext(handshakeVersion)
+	if !ok {
+		return anonymousRegVersion
+	}
+	version, ok := v.(RegVersion)
+	if !ok {
+		return anonymousRegVersion
+	}
+	return version
+}
+
+func (c *Connection) handshakeStarted(v RegVersion) {
+	c.StoreContext(handshakeVersion, v)
+	if _, loaded := c.context.LoadOrStore(handshakeStart, time.Now()); loaded {
+		return
+	}
+	c.factory.rootFactory().handshakes.started(v)
+}
+
+// record the handshake completion once per connection
+func (c *Connection) handshakeCompleted() {
+	v, ok := c.LoadContext(handshakeStart)
+	if !ok {
+		return
+	}
+	if _, loaded := c.context.LoadOrStore(handshakeDone, true); loaded {
+		return
+	}
+	if _, failed := c.LoadContext(handshakeFailed); failed {
+		return
+	}
+	c.factory.rootFactory().handshakes.completed(c.getHandshakeVersion(), time.Since(v.(time.Time)))
+}
+
+// record the handshake failure once per connection
+func (c *Connection) failHandshake(reason HandshakeFailure) {
+	if _, done := c.LoadContext(handshakeDone); done {
+		return
+	}
+	if _, loaded := c.context.LoadOrStore(handshakeFailed, reason); loaded {
+		return
+	}
+	c.factory.rootFactory().handshakes.failed(c.getHandshakeVersion(), reason)
+	c.GetContextLogger().WithField("reason", reason).Debug("handshake failed")
+}
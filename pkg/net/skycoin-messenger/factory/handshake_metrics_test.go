@@ -0,0 +1,90 @@
+package factory
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHandshakeMetricsBuckets(t *testing.T) {
+	var m handshakeMetrics
+	for _, d := range []time.Duration{5 * time.Millisecond, 10 * time.Millisecond, 11 * time.Millisecond, 2 * time.Minute} {
+		m.started(RegWithKeyAndEncryptionVersion)
+		m.completed(RegWithKeyAndEncryptionVersion, d)
+	}
+	m.started(anonymousRegVersion)
+	m.failed(anonymousRegVersion, HandshakeFailureTimeout)
+	m.failed(anonymousRegVersion, HandshakeFailureTimeout)
+
+	stats := m.snapshot()
+	p := stats.Patterns["key_encryption"]
+	if p == nil || p.Started != 4 || p.Completed != 4 || len(p.Failed) != 0 {
+		t.Fatalf("key_encryption %+v", p)
+	}
+	if p.Durations["10ms"] != 2 || p.Durations["50ms"] != 1 || p.Durations["+Inf"] != 1 || p.Durations["1m0s"] != 0 {
+		t.Fatalf("durations %v", p.Durations)
+	}
+	if len(p.Durations) != len(handshakeDurationBuckets)+1 {
+		t.Fatalf("%d buckets", len(p.Durations))
+	}
+	if p.DurationSum != 5+10+11+120000 {
+		t.Fatalf("duration sum %d", p.DurationSum)
+	}
+	a := stats.Patterns["anonymous"]
+	if a == nil || a.Started != 1 || a.Completed != 0 || a.Failed["timeout"] != 2 {
+		t.Fatalf("anonymous %+v", a)
+	}
+}
+
+func TestHandshakeFailureNames(t *testing.T) {
+	seen := make(map[string]bool)
+	for hf := HandshakeFailureBadKey; hf <= HandshakeFailureBanned; hf++ {
+		name := hf.String()
+		if name == "unknown" || seen[name] {
+			t.Fatalf("failure %d named %q", hf, name)
+		}
+		seen[name] = true
+	}
+	if HandshakeFailure(-1).String() != "unknown" {
+		t.Fatal("an undefined failure named")
+	}
+}
+
+func TestConnectionHandshakeOnce(t *testing.T) {
+	root := NewMessengerFactory()
+	// the transport factories of a node account to the node factory
+	transport := NewMessengerFactory()
+	transport.Parent = root
+
+	c, _ := newFakeConnection(transport, "10.0.0.1:1000")
+	c.handshakeStarted(RegWithKeyAndEncryptionVersion)
+	c.handshakeStarted(RegWithKeyAndEncryptionVersion)
+	c.handshakeCompleted()
+	c.handshakeCompleted()
+	c.failHandshake(HandshakeFailureClosed)
+
+	// a failed handshake is not completed after it
+	failed, _ := newFakeConnection(root, "10.0.0.2:1000")
+	failed.handshakeStarted(RegWithKeyAndEncryptionVersion)
+	failed.failHandshake(HandshakeFailureBadKey)
+	failed.failHandshake(HandshakeFailureDecrypt)
+	failed.handshakeCompleted()
+
+	// a handshake never started is not completed
+	never, _ := newFakeConnection(root, "10.0.0.3:1000")
+	never.handshakeCompleted()
+
+	if len(transport.handshakes.snapshot().Patterns) != 0 {
+		t.Fatal("accounted to the transport factory")
+	}
+	stats := transport.GetHandshakeStats()
+	p := stats.Patterns["key_encryption"]
+	if p == nil || p.Started != 2 || p.Completed != 1 {
+		t.Fatalf("key_encryption %+v", p)
+	}
+	if len(p.Failed) != 1 || p.Failed["bad_static_key"] != 1 {
+		t.Fatalf("failures %v", p.Failed)
+	}
+	if stats.Protection != nil {
+		t.Fatalf("protection %+v without it set", stats.Protection)
+	}
+}
@@ -0,0 +1,142 @@
+package factory
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+var (
+	errDowngrade    = errors.New("handshake downgraded")
+	errNoTranscript = errors.New("handshake without transcript refused")
+)
+
+// SupportedRegVersions are the handshake versions a client offers, the best first. The offer
+// is bound into the signature of the handshake, so a peer in the middle can not strip the
+// encryption or the features of the request without the server noticing
+var SupportedRegVersions = []RegVersion{RegWithKeyAndEncryptionVersion, regWithKeyVersion}
+
+// bestRegVersion returns the best version of the offer the factory supports
+func bestRegVersion(offered []RegVersion) (best RegVersion, ok bool) {
+	for _, s := range SupportedRegVersions {
+		for _, v := range offered {
+			if v == s {
+				return s, true
+			}
+		}
+	}
+	return
+}
+
+// handshakePrologue encodes what both sides agreed on in the handshake: the offer and the
+// request of the client and the answer of the server. The cookie is left out, the client
+// sends the same request again with it
+func handshakePrologue(req *regWithKey, respVersion RegVersion, respSchema int, respNetwork string) []byte {
+	b := []byte("skywire-handshake:")
+	b = append(b, req.PublicKey[:]...)
+	b = appendUint32(b, uint32(len(req.Versions)))
+	for _, v := range req.Versions {
+		b = appendUint32(b, uint32(v))
+	}
+	b = appendUint32(b, uint32(req.Version))
+	b = appendUint32(b, uint32(req.Schema))
+	b = appendUint32(b, uint32(req.Features))
+	b = appendUint32(b, uint32(len(req.Network)))
+	b = append(b, req.Network...)
+	b = appendUint32(b, uint32(respVersion))
+	b = appendUint32(b, uint32(respSchema))
+	b = appendUint32(b, uint32(len(respNetwork)))
+	b = append(b, respNetwork...)
+	return b
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	var n [4]byte
+	binary.BigEndian.PutUint32(n[:], v)
+	return append(b, n[:]...)
+}
+
+// transcriptHash is signed by the client instead of the challenge of the server once both
+// sides bound the handshake
+func transcriptHash(challenge cipher.SHA256, prologue []byte) cipher.SHA256 {
+	return cipher.SumSHA256(append(challenge[:], prologue...))
+}
+
+// SetRequireHandshakeTranscript refuses the peers that do not bind their handshake, the
+// clients and servers before the transcripts are refused too. Without it a peer in the
+// middle can still pass a handshake off as one of an older peer
+func (f *MessengerFactory) SetRequireHandshakeTranscript(require bool) {
+	f.fieldsMutex.Lock()
+	f.requireHandshakeTranscript = require
+	f.fieldsMutex.Unlock()
+}
+
+func (f *MessengerFactory) handshakeTranscriptRequired() (require bool) {
+	f = f.rootFactory()
+	f.fieldsMutex.RLock()
+	require = f.requireHandshakeTranscript
+	f.fieldsMutex.RUnlock()
+	return
+}
+
+// checkOffer runs on the server, the client must have asked for the best version of its
+// offer both sides support. An offer with a better version than the request means the
+// request was downgraded on the way
+func (f *MessengerFactory) checkOffer(reg *regWithKey) error {
+	if len(reg.Versions) == 0 {
+		if f.handshakeTranscriptRequired() {
+			return errNoTranscript
+		}
+		return nil
+	}
+	best, ok := bestRegVersion(reg.Versions)
+	if !ok || best != reg.Version {
+		return errDowngrade
+	}
+	return nil
+}
+
+// bindHandshake runs on the server, keeps the prologue the signature of the client must cover
+func (c *Connection) bindHandshake(reg *regWithKey, resp *regWithKeyResp) {
+	if len(reg.Versions) == 0 {
+		return
+	}
+	resp.Bound = true
+	c.StoreContext(handshakeTranscript, handshakePrologue(reg, resp.Version, resp.Schema, resp.Network))
+}
+
+// signedChallenge runs on the server, returns what the signature of the client covers
+func (c *Connection) signedChallenge(challenge cipher.SHA256) cipher.SHA256 {
+	p, ok := c.LoadContext(handshakeTranscript)
+	if !ok {
+		return challenge
+	}
+	return transcriptHash(challenge, p.([]byte))
+}
+
+// checkAnswer runs on the client, the server must answer with the version the client asked for
+func (c *Connection) checkAnswer(resp *regWithKeyResp) (req *regWithKey, err error) {
+	v, ok := c.LoadContext(regRequest)
+	if !ok {
+		err = errors.New("reg request not found")
+		return
+	}
+	req = v.(*regWithKey)
+	if resp.Version != req.Version {
+		err = errDowngrade
+		return
+	}
+	if !resp.Bound && c.factory.handshakeTranscriptRequired() {
+		err = errNoTranscript
+	}
+	return
+}
+
+// challengeToSign runs on the client, binds the handshake if the server does
+func challengeToSign(challenge cipher.SHA256, req *regWithKey, resp *regWithKeyResp) cipher.SHA256 {
+	if !resp.Bound {
+		return challenge
+	}
+	return transcriptHash(challenge, handshakePrologue(req, resp.Version, resp.Schema, resp.Network))
+}
@@ -0,0 +1,105 @@
+package factory
+
+import (
+	"testing"
+
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+func TestCheckOffer(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		versions []RegVersion
+		version  RegVersion
+		// accepted without and with the transcripts required
+		accepted, required bool
+	}{
+		{name: "client before the transcripts", version: RegWithKeyAndEncryptionVersion, accepted: true},
+		{name: "best version asked", versions: SupportedRegVersions, version: RegWithKeyAndEncryptionVersion, accepted: true, required: true},
+		{name: "only version offered", versions: []RegVersion{regWithKeyVersion}, version: regWithKeyVersion, accepted: true, required: true},
+		{name: "downgraded", versions: SupportedRegVersions, version: regWithKeyVersion},
+		{name: "nothing supported", versions: []RegVersion{99}, version: 99},
+	} {
+		f := NewMessengerFactory()
+		reg := &regWithKey{Versions: c.versions, Version: c.version}
+		for _, required := range []bool{false, true} {
+			f.SetRequireHandshakeTranscript(required)
+			want := c.accepted
+			if required {
+				want = c.required
+			}
+			if err := f.checkOffer(reg); (err == nil) != want {
+				t.Errorf("%s: required %v: %v", c.name, required, err)
+			}
+		}
+	}
+}
+
+func TestHandshakeBound(t *testing.T) {
+	challenge := cipher.SumSHA256([]byte("challenge"))
+	request := func() *regWithKey {
+		return &regWithKey{PublicKey: cipher.PubKey([33]byte{0x02, 1}), Versions: SupportedRegVersions,
+			Version: RegWithKeyAndEncryptionVersion, Schema: 2, Network: "testnet"}
+	}
+	// the server binds what it received and answered, the client what it sent and received
+	for _, c := range []struct {
+		name   string
+		tamper func(req *regWithKey, resp *regWithKeyResp)
+		bound  bool
+	}{
+		{name: "untouched", tamper: func(req *regWithKey, resp *regWithKeyResp) {}, bound: true},
+		{name: "offer stripped", tamper: func(req *regWithKey, resp *regWithKeyResp) {
+			req.Versions = req.Versions[:1]
+		}},
+		{name: "features stripped", tamper: func(req *regWithKey, resp *regWithKeyResp) { req.Features = 0 }},
+		{name: "network changed", tamper: func(req *regWithKey, resp *regWithKeyResp) { req.Network = "" }},
+		{name: "answer changed", tamper: func(req *regWithKey, resp *regWithKeyResp) { resp.Schema = 1 }},
+	} {
+		sent := request()
+		sent.Features = 1
+		received := *sent
+		resp := &regWithKeyResp{Version: received.Version, Schema: 2, Network: "testnet"}
+		answered := *resp
+		c.tamper(&received, &answered)
+
+		server := newTestConnection()
+		server.bindHandshake(&received, &answered)
+		if !answered.Bound {
+			t.Fatalf("%s: not bound", c.name)
+		}
+		resp.Bound = true
+		if bound := server.signedChallenge(challenge) == challengeToSign(challenge, sent, resp); bound != c.bound {
+			t.Errorf("%s: signature of the client covers what the server saw %v", c.name, bound)
+		}
+	}
+
+	// a server before the transcripts has the challenge signed alone
+	server := newTestConnection()
+	resp := &regWithKeyResp{}
+	server.bindHandshake(&regWithKey{}, resp)
+	if resp.Bound || server.signedChallenge(challenge) != challenge || challengeToSign(challenge, request(), resp) != challenge {
+		t.Fatal("handshake without an offer bound")
+	}
+}
+
+func TestCheckAnswer(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		resp     regWithKeyResp
+		required bool
+		ok       bool
+	}{
+		{name: "bound", resp: regWithKeyResp{Version: RegWithKeyAndEncryptionVersion, Bound: true}, required: true, ok: true},
+		{name: "server before the transcripts", resp: regWithKeyResp{Version: RegWithKeyAndEncryptionVersion}, ok: true},
+		{name: "server before the transcripts refused", resp: regWithKeyResp{Version: RegWithKeyAndEncryptionVersion}, required: true},
+		{name: "downgraded answer", resp: regWithKeyResp{Version: regWithKeyVersion, Bound: true}},
+	} {
+		f := NewMessengerFactory()
+		f.SetRequireHandshakeTranscript(c.required)
+		conn, _ := newFakeConnection(f, "127.0.0.1:5000")
+		conn.StoreContext(regRequest, &regWithKey{Versions: SupportedRegVersions, Version: RegWithKeyAndEncryptionVersion})
+		if _, err := conn.checkAnswer(&c.resp); (err == nil) != c.ok {
+			t.Errorf("%s: %v", c.name, err)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package factory
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+// knockMAC binds the token of a knocked service to one setup, the discovery forwarding the
+// setup learns neither the token nor a knock it can present for another setup
+func knockMAC(token []byte, fromNode, node, app cipher.PubKey, num []byte) []byte {
+	mac := hmac.New(sha256.New, token)
+	mac.Write(fromNode[:])
+	mac.Write(node[:])
+	mac.Write(app[:])
+	mac.Write(num)
+	return mac.Sum(nil)
+}
+
+// knockAllows returns true if the service takes no knock or knock was made with its token
+func (s *Service) knockAllows(knock []byte, fromNode, node, app cipher.PubKey, num []byte) bool {
+	if len(s.Knock) == 0 {
+		return true
+	}
+	return hmac.Equal(knock, knockMAC(s.Knock, fromNode, node, app, num))
+}
+
+// OfferKnockedServiceWithAddress offers a service the node only accepts transports to from
+// apps dialing with the token in AppDialOptions.Knock, refused ones are answered as if the
+// app did not exist. The discovery learns neither the service nor the token. allowNodes
+// limits the nodes further if not empty
+func (c *Connection) OfferKnockedServiceWithAddress(address, version string, token []byte, allowNodes []string, attrs ...string) error {
+	return c.UpdateServices(&NodeServices{
+		Services: []*Service{{
+			Key:               c.GetKey(),
+			Attributes:        attrs,
+			Address:           address,
+			HideFromDiscovery: true,
+			AllowNodes:        allowNodes,
+			Version:           version,
+			Knock:             token,
+		}}})
+}
@@ -0,0 +1,56 @@
+package factory
+
+import (
+	"testing"
+
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+func TestKnockAllows(t *testing.T) {
+	fromNode, node, app := cipher.PubKey([33]byte{0x02, 1}), cipher.PubKey([33]byte{0x02, 2}), cipher.PubKey([33]byte{0x03, 1})
+	num := []byte{1, 2, 3}
+	token := []byte("secret")
+	knocked := &Service{Key: app, Knock: token}
+	for _, c := range []struct {
+		name    string
+		service *Service
+		knock   []byte
+		allowed bool
+	}{
+		{name: "no token", service: &Service{Key: app}, allowed: true},
+		{name: "no token, knocked anyway", service: &Service{Key: app}, knock: []byte("guess"), allowed: true},
+		{name: "no knock", service: knocked},
+		{name: "the token itself", service: knocked, knock: token},
+		{name: "another token", service: knocked, knock: knockMAC([]byte("guess"), fromNode, node, app, num)},
+		{name: "another setup", service: knocked, knock: knockMAC(token, fromNode, node, app, []byte{4})},
+		{name: "from another node", service: knocked, knock: knockMAC(token, node, fromNode, app, num)},
+		{name: "to another app", service: knocked, knock: knockMAC(token, fromNode, node, fromNode, num)},
+		{name: "made with the token", service: knocked, knock: knockMAC(token, fromNode, node, app, num), allowed: true},
+	} {
+		if allowed := c.service.knockAllows(c.knock, fromNode, node, app, num); allowed != c.allowed {
+			t.Errorf("%s: allowed %v", c.name, allowed)
+		}
+	}
+}
+
+func TestKnockedServiceHidden(t *testing.T) {
+	sd := newServiceDiscovery()
+	conn := newTestConnection()
+	conn.SetKey(cipher.PubKey([33]byte{0x02, 1}))
+	knocked := &Service{Key: cipher.PubKey([33]byte{0x03, 1}), Attributes: []string{"knocked"}, Knock: []byte("secret")}
+	sd.register(conn, &NodeServices{Services: []*Service{knocked, {Key: cipher.PubKey([33]byte{0x03, 2}), Attributes: []string{"open"}}}})
+
+	ns := sd.pack()
+	if ns == nil || len(ns.Services) != 2 {
+		t.Fatalf("packed %#v", ns)
+	}
+	for _, s := range ns.Services {
+		if len(s.Knock) > 0 || s.HideFromDiscovery != (s.Key == knocked.Key) {
+			t.Errorf("service %x sent to the discovery %#v", s.Key, s)
+		}
+	}
+	// the node keeps the token to check the knocks
+	if string(knocked.Knock) != "secret" {
+		t.Errorf("token of the node %q", knocked.Knock)
+	}
+}
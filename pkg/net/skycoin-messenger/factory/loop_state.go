@@ -0,0 +1,187 @@
+package factory
+
+import (
+	"errors"
+	"fmt"
+	"net"
+)
+
+// LoopState is the state of a loop, a connection of two apps carried by a transport under
+// an id in the frames between the nodes
+type LoopState int
+
+const (
+	// node A accepted the connection of the app, node B got the open frame
+	LoopRequested LoopState = iota
+	// node A sent the open frame, node B dials the app
+	LoopConfirming
+	// node A got data from node B, node B connected to the app
+	LoopOpen
+	// the close was sent to or received from the other node
+	LoopHalfClosed
+	// the connection of the app is being closed
+	LoopClosing
+	LoopClosed
+)
+
+func (s LoopState) String() string {
+	switch s {
+	case LoopRequested:
+		return "requested"
+	case LoopConfirming:
+		return "confirming"
+	case LoopOpen:
+		return "open"
+	case LoopHalfClosed:
+		return "half_closed"
+	case LoopClosing:
+		return "closing"
+	case LoopClosed:
+		return "closed"
+	}
+	return "unknown"
+}
+
+var loopTransitions = map[LoopState][]LoopState{
+	LoopRequested:  {LoopConfirming, LoopClosing, LoopClosed},
+	LoopConfirming: {LoopOpen, LoopHalfClosed, LoopClosing, LoopClosed},
+	LoopOpen:       {LoopHalfClosed, LoopClosing},
+	LoopHalfClosed: {LoopClosing},
+	LoopClosing:    {LoopClosed},
+}
+
+// CanTransition returns true if a loop in the state may move to the state to
+func (s LoopState) CanTransition(to LoopState) bool {
+	for _, v := range loopTransitions[s] {
+		if v == to {
+			return true
+		}
+	}
+	return false
+}
+
+// LoopTransitionError is returned for a change of state a loop does not allow
+type LoopTransitionError struct {
+	ID       uint32
+	From, To LoopState
+}
+
+func (e *LoopTransitionError) Error() string {
+	return fmt.Sprintf("loop %d: invalid transition from %s to %s", e.ID, e.From, e.To)
+}
+
+// LoopFrame is the kind of a frame of a loop between the nodes
+type LoopFrame int
+
+const (
+	LoopFrameOpen LoopFrame = iota
+	LoopFrameData
+	LoopFrameClose
+)
+
+func (f LoopFrame) String() string {
+	switch f {
+	case LoopFrameOpen:
+		return "open"
+	case LoopFrameData:
+		return "data"
+	case LoopFrameClose:
+		return "close"
+	}
+	return "unknown"
+}
+
+// LoopFrameError is returned for a frame the loop does not take in its state, the frame
+// is dropped
+type LoopFrameError struct {
+	ID    uint32
+	State LoopState
+	Frame LoopFrame
+}
+
+func (e *LoopFrameError) Error() string {
+	return fmt.Sprintf("loop %d: %s frame invalid in state %s", e.ID, e.Frame, e.State)
+}
+
+// ErrLoopUnknown is returned for a frame of a loop that was never opened on the node
+var ErrLoopUnknown = errors.New("frame for an unknown loop")
+
+type loop struct {
+	id    uint32
+	conn  net.Conn
+	state LoopState
+	// data frames of the other node held back, OP_TRANSPORT_SEQ only
+	reorder reorder
+	// pending channel of the connection to the other node the data of the app is sent on,
+	// 0 before the app is read
+	channel int
+}
+
+// transition moves the loop to the state, with the connsMutex of the transport locked
+func (l *loop) transition(to LoopState) error {
+	if !l.state.CanTransition(to) {
+		return &LoopTransitionError{ID: l.id, From: l.state, To: to}
+	}
+	l.state = to
+	return nil
+}
+
+// receive checks the frame from the other node against the state of the loop and moves
+// it on, with the connsMutex of the transport locked. Data before the open frame was
+// answered or after a close never reaches the app
+func (l *loop) receive(frame LoopFrame) error {
+	switch frame {
+	case LoopFrameData:
+		switch l.state {
+		case LoopOpen:
+			return nil
+		case LoopConfirming:
+			// the first data of node B confirms the loop on node A
+			return l.transition(LoopOpen)
+		}
+	case LoopFrameClose:
+		if l.state == LoopOpen || l.state == LoopConfirming {
+			return l.transition(LoopHalfClosed)
+		}
+	}
+	return &LoopFrameError{ID: l.id, State: l.state, Frame: frame}
+}
+
+// loopFrame returns the kind of a frame from the other node
+func loopFrame(m []byte) LoopFrame {
+	switch {
+	case m[PKG_HEADER_OP_BEGIN] == OP_CLOSE:
+		return LoopFrameClose
+	case m[PKG_HEADER_OP_BEGIN] == OP_TRANSPORT && len(m) <= PKG_HEADER_END:
+		return LoopFrameOpen
+	}
+	return LoopFrameData
+}
+
+// LoopStates returns the state of the loops of the transport by their ids
+func (t *Transport) LoopStates() map[uint32]LoopState {
+	t.connsMutex.RLock()
+	defer t.connsMutex.RUnlock()
+	states := make(map[uint32]LoopState, len(t.loops))
+	for id, l := range t.loops {
+		states[id] = l.state
+	}
+	return states
+}
+
+// closeLoop closes the connection of the app of the loop, telling the app why if notify
+func (t *Transport) closeLoop(l *loop, reason CloseReason, notify bool) {
+	t.connsMutex.Lock()
+	err := l.transition(LoopClosing)
+	t.connsMutex.Unlock()
+	if err != nil {
+		return
+	}
+	if notify {
+		t.appConnClosed(l.conn, reason)
+	}
+	l.conn.Close()
+	t.connsMutex.Lock()
+	l.transition(LoopClosed)
+	t.connsMutex.Unlock()
+}
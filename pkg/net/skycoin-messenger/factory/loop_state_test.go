@@ -0,0 +1,93 @@
+package factory
+
+import (
+	"testing"
+)
+
+func TestLoopTransitions(t *testing.T) {
+	states := []LoopState{LoopRequested, LoopConfirming, LoopOpen, LoopHalfClosed, LoopClosing, LoopClosed}
+	allowed := map[LoopState]string{
+		LoopRequested:  "confirming,closing,closed",
+		LoopConfirming: "open,half_closed,closing,closed",
+		LoopOpen:       "half_closed,closing",
+		LoopHalfClosed: "closing",
+		LoopClosing:    "closed",
+		LoopClosed:     "",
+	}
+	for _, from := range states {
+		var to string
+		for _, s := range states {
+			l := &loop{id: 1, state: from}
+			err := l.transition(s)
+			if from.CanTransition(s) != (err == nil) {
+				t.Errorf("%s to %s: %v", from, s, err)
+			}
+			if err != nil {
+				if _, ok := err.(*LoopTransitionError); !ok || l.state != from {
+					t.Errorf("%s to %s: state %s, error %v", from, s, l.state, err)
+				}
+				continue
+			}
+			if len(to) > 0 {
+				to += ","
+			}
+			to += s.String()
+		}
+		if to != allowed[from] {
+			t.Errorf("%s moves to %q, want %q", from, to, allowed[from])
+		}
+	}
+}
+
+func TestLoopReceive(t *testing.T) {
+	for _, c := range []struct {
+		state LoopState
+		frame LoopFrame
+		// the state after the frame, the frame is refused if it is the same and not open
+		next LoopState
+	}{
+		{LoopRequested, LoopFrameData, LoopRequested},
+		{LoopRequested, LoopFrameClose, LoopRequested},
+		{LoopConfirming, LoopFrameData, LoopOpen},
+		{LoopConfirming, LoopFrameClose, LoopHalfClosed},
+		{LoopOpen, LoopFrameData, LoopOpen},
+		{LoopOpen, LoopFrameClose, LoopHalfClosed},
+		{LoopOpen, LoopFrameOpen, LoopOpen},
+		{LoopHalfClosed, LoopFrameData, LoopHalfClosed},
+		{LoopHalfClosed, LoopFrameClose, LoopHalfClosed},
+		{LoopClosed, LoopFrameData, LoopClosed},
+	} {
+		l := &loop{id: 1, state: c.state}
+		err := l.receive(c.frame)
+		accepted := c.next != c.state || (c.state == LoopOpen && c.frame == LoopFrameData)
+		if (err == nil) != accepted || l.state != c.next {
+			t.Errorf("%s frame in %s: state %s, error %v", c.frame, c.state, l.state, err)
+		}
+		if err != nil {
+			if e, ok := err.(*LoopFrameError); !ok || e.State != c.state || e.Frame != c.frame {
+				t.Errorf("%s frame in %s: error %#v", c.frame, c.state, err)
+			}
+		}
+	}
+}
+
+func TestLoopFrame(t *testing.T) {
+	frame := func(op byte, body int) []byte {
+		m := make([]byte, PKG_HEADER_END+body)
+		m[PKG_HEADER_OP_BEGIN] = op
+		return m
+	}
+	for _, c := range []struct {
+		name string
+		m    []byte
+		want LoopFrame
+	}{
+		{"open", frame(OP_TRANSPORT, 0), LoopFrameOpen},
+		{"data", frame(OP_TRANSPORT, 1), LoopFrameData},
+		{"close", frame(OP_CLOSE, 0), LoopFrameClose},
+	} {
+		if got := loopFrame(c.m); got != c.want {
+			t.Errorf("%s: %s", c.name, got)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package factory
+
+import "fmt"
+
+// MainNetwork is the network of the factories no network was set for, and of the peers
+// that announce none, as the versions before networks did
+const MainNetwork = "mainnet"
+
+// SetNetwork sets the network the factory belongs to. A server refuses the peers of
+// another network, a client with a network refuses the servers of another network and
+// node B refuses the transports node A asks for from another network, so the nodes of
+// a test network never register with the discoveries of the main network
+func (f *MessengerFactory) SetNetwork(name string) {
+	f.fieldsMutex.Lock()
+	f.network = name
+	f.fieldsMutex.Unlock()
+}
+
+// GetNetwork returns the network the factory belongs to
+func (f *MessengerFactory) GetNetwork() string {
+	f.fieldsMutex.RLock()
+	defer f.fieldsMutex.RUnlock()
+	if len(f.network) == 0 {
+		return MainNetwork
+	}
+	return f.network
+}
+
+func (f *MessengerFactory) networkSet() bool {
+	f.fieldsMutex.RLock()
+	defer f.fieldsMutex.RUnlock()
+	return len(f.network) > 0
+}
+
+// announcedNetwork is the network sent to the peers, empty for the main network so the
+// messages stay the same for the versions before networks
+func (f *MessengerFactory) announcedNetwork() (name string) {
+	name = f.GetNetwork()
+	if name == MainNetwork {
+		name = ""
+	}
+	return
+}
+
+// checkNetwork returns an error if the peer announced another network than the factory
+func (f *MessengerFactory) checkNetwork(peer string) error {
+	if len(peer) == 0 {
+		peer = MainNetwork
+	}
+	if own := f.GetNetwork(); own != peer {
+		return fmt.Errorf("peer of network %s, this is network %s", peer, own)
+	}
+	return nil
+}
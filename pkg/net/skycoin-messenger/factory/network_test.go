@@ -0,0 +1,29 @@
+package factory
+
+import "testing"
+
+func TestCheckNetwork(t *testing.T) {
+	for _, c := range []struct {
+		own, peer string
+		announced string
+		ok        bool
+	}{
+		{own: "", peer: "", ok: true},
+		{own: "", peer: MainNetwork, ok: true},
+		{own: MainNetwork, peer: "", ok: true},
+		{own: "", peer: "testnet"},
+		{own: "testnet", peer: "", announced: "testnet"},
+		{own: "testnet", peer: "testnet", announced: "testnet", ok: true},
+		{own: "testnet", peer: "devnet", announced: "testnet"},
+	} {
+		f := NewMessengerFactory()
+		f.SetNetwork(c.own)
+		if err := f.checkNetwork(c.peer); (err == nil) != c.ok {
+			t.Errorf("network %q, peer %q: %v", c.own, c.peer, err)
+		}
+		// the main network is not announced, as by the versions before networks
+		if got := f.announcedNetwork(); got != c.announced {
+			t.Errorf("network %q announced as %q", c.own, got)
+		}
+	}
+}
@@ -27,7 +27,7 @@ var (
 )
 
 func getOP(n int) interface{} {
-	if n < 0 || n > OP_SIZE {
+	if n < 0 || n >= OP_SIZE {
 		return nil
 	}
 	pool := ops[n]
@@ -38,7 +38,7 @@ func getOP(n int) interface{} {
 }
 
 func putOP(n int, op interface{}) {
-	if n < 0 || n > OP_SIZE {
+	if n < 0 || n >= OP_SIZE {
 		return
 	}
 	pool := ops[n]
@@ -49,7 +49,7 @@ func putOP(n int, op interface{}) {
 }
 
 func getResp(n int) resp {
-	if n < 0 || n > OP_SIZE {
+	if n < 0 || n >= OP_SIZE {
 		return nil
 	}
 	pool := resps[n]
@@ -60,7 +60,7 @@ func getResp(n int) resp {
 }
 
 func putResp(n int, r resp) {
-	if n < 0 || n > OP_SIZE {
+	if n < 0 || n >= OP_SIZE {
 		return
 	}
 	pool := resps[n]
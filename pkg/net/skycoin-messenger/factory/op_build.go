@@ -7,8 +7,12 @@ import (
 	"io"
 	"net"
 	"sync"
+	"time"
 
 	"github.com/skycoin/skycoin/src/cipher"
+	"github.com/skycoin/skywire/pkg/failpoint"
+	"github.com/skycoin/skywire/pkg/net/wire"
+	"github.com/skycoin/skywire/pkg/trace"
 )
 
 func init() {
@@ -65,9 +69,28 @@ func init() {
 }
 
 type appConn struct {
-	Node      cipher.PubKey
-	App       cipher.PubKey
-	Discovery cipher.PubKey
+	Node      cipher.PubKey `wire:"1"`
+	App       cipher.PubKey `wire:"2"`
+	Discovery cipher.PubKey `wire:"3"`
+	// span of the app, the setup on node A is its child
+	Trace *trace.SpanContext `json:",omitempty" wire:"4"`
+	// correlates the setup on all nodes, generated by the app or node A
+	SetupID string `json:",omitempty" wire:"5"`
+	// weights of the cost of the paths, node A ranks the paths by them
+	Cost *PathCost `json:",omitempty" wire:"6"`
+	// of the setup phases, over the defaults of node A
+	Timeouts *SetupTimeouts `json:",omitempty" wire:"7"`
+	// node A keeps a standby transport to the app and fails over to it
+	Critical bool `json:",omitempty" wire:"8"`
+	// node A seals the apps for node B, the discovery only learns the nodes
+	Private bool `json:",omitempty" wire:"9"`
+	// discoveries the transport must avoid
+	Constraints *RouteConstraints `json:",omitempty" wire:"10"`
+	// token of the knocked service of the app
+	Knock []byte `json:",omitempty" wire:"11"`
+
+	// set up as the standby of a critical transport
+	standby bool
 }
 
 // run on node A
@@ -75,8 +98,34 @@ func (req *appConn) Execute(f *MessengerFactory, conn *Connection) (r resp, err
 	if !f.Proxy {
 		return
 	}
+	if len(req.SetupID) == 0 {
+		req.SetupID = NewSetupID()
+	}
+	if f.getQuotas().exceeded(conn.GetKey()) {
+		conn.GetContextLogger().WithField("setup_id", req.SetupID).Infof("transport to node %x app %x: %v", req.Node, req.App, errQuotaExceeded)
+		err = conn.writeOP(OP_BUILD_APP_CONN|RESP_PREFIX, &AppConnResp{
+			App:     req.App,
+			Failed:  true,
+			Msg:     PriorityMsg{Priority: QuotaExceeded, Msg: errQuotaExceeded.Error(), Type: Failed},
+			SetupID: req.SetupID,
+		})
+		return
+	}
+
+	if !f.peerAllowed(req.Node) {
+		msg := fmt.Sprintf("node %x refused by the peer lists", req.Node)
+		conn.GetContextLogger().WithField("setup_id", req.SetupID).Infof("transport to node %x app %x: %s", req.Node, req.App, msg)
+		err = conn.writeOP(OP_BUILD_APP_CONN|RESP_PREFIX, &AppConnResp{
+			App:     req.App,
+			Failed:  true,
+			Msg:     PriorityMsg{Priority: NotAllowed, Msg: msg, Type: Failed},
+			SetupID: req.SetupID,
+		})
+		return
+	}
 
 	sent := make(map[string]struct{})
+	var discoveries []*Connection
 	f.ForEachConn(func(connection *Connection) {
 		discoveryKey := connection.GetTargetKey()
 		if discoveryKey != req.Discovery && req.Discovery != EMPTY_PUBLIC_KEY {
@@ -87,45 +136,155 @@ func (req *appConn) Execute(f *MessengerFactory, conn *Connection) (r resp, err
 			return
 		}
 		sent[discoveryKey.Hex()] = struct{}{}
-		fromNode := connection.GetKey()
-		fromApp := conn.GetKey()
-		iv := make([]byte, aes.BlockSize)
-		if _, err = io.ReadFull(rand.Reader, iv); err != nil {
-			conn.GetContextLogger().Debugf("transport err %v", err)
-			return
-		}
-		tr := NewTransport(f, conn, fromNode, req.Node, fromApp, req.App)
-		tr.SetOnAcceptedUDPCallback(func(connection *Connection) {
-			connection.CreatedByTransport = tr
-			sc := f.GetDefaultSeedConfig()
-			connection.GetContextLogger().Debugf("set crypto sc %v", sc)
-			if sc == nil {
-				connection.GetContextLogger().Debugf("tr sc is nil")
-			}
-			connection.SetKey(req.Node)
-			err := connection.SetCrypto(sc.publicKey, sc.secKey, req.Node, iv)
-			if err != nil {
-				connection.GetContextLogger().Debugf("set crypto err %v", err)
-			}
+		discoveries = append(discoveries, connection)
+	})
+	discoveries, err = req.Constraints.filter(conn, discoveries, req.App)
+	if err != nil {
+		conn.GetContextLogger().WithField("setup_id", req.SetupID).Infof("transport to node %x app %x: %v", req.Node, req.App, err)
+		err = conn.writeOP(OP_BUILD_APP_CONN|RESP_PREFIX, &AppConnResp{
+			App:     req.App,
+			Failed:  true,
+			Msg:     PriorityMsg{Priority: NotAllowed, Msg: err.Error(), Type: Failed},
+			SetupID: req.SetupID,
 		})
-		conn.GetContextLogger().Debugf("app conn create transport to %s", connection.GetRemoteAddr().String())
-		c, err := tr.ListenAndConnect(connection.GetRemoteAddr().String(), discoveryKey)
-		if err != nil {
-			conn.GetContextLogger().Debugf("transport err %v", err)
+		return
+	}
+	discoveries, refused, decision := f.choosePaths(req, discoveries)
+	f.recordRoute(conn, decision)
+	if len(discoveries) == 0 && refused == nil && decision.Policy {
+		msg := "no path allowed by the routing policy"
+		conn.GetContextLogger().WithField("setup_id", req.SetupID).Infof("transport to node %x app %x: %s", req.Node, req.App, msg)
+		err = conn.writeOP(OP_BUILD_APP_CONN|RESP_PREFIX, &AppConnResp{
+			App:     req.App,
+			Failed:  true,
+			Msg:     PriorityMsg{Priority: NotAllowed, Msg: msg, Type: Failed},
+			SetupID: req.SetupID,
+		})
+		return
+	}
+	if len(discoveries) == 0 && refused != nil {
+		msg := refused.msg
+		msg.Msg += " (cached)"
+		conn.GetContextLogger().WithField("setup_id", req.SetupID).Infof("transport to node %x app %x refused by discovery %x before: %s",
+			req.Node, req.App, refused.key.discovery, refused.msg.Msg)
+		err = conn.writeOP(OP_BUILD_APP_CONN|RESP_PREFIX, &AppConnResp{
+			Discovery: refused.key.discovery,
+			App:       req.App,
+			Failed:    true,
+			Msg:       msg,
+			SetupID:   req.SetupID,
+		})
+		return
+	}
+	for _, connection := range discoveries {
+		f.startSetup(conn, req, connection)
+	}
+	return
+}
+
+// setup builds the transport to the app through the discovery of connection, release is
+// called once the nodes connected or the setup failed, if not nil
+func (req *appConn) setup(f *MessengerFactory, conn, connection *Connection, release func()) {
+	discoveryKey := connection.GetTargetKey()
+	fromNode := connection.GetKey()
+	fromApp := conn.GetKey()
+	iv := make([]byte, aes.BlockSize)
+	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+		conn.GetContextLogger().Debugf("transport err %v", err)
+		if release != nil {
+			release()
+		}
+		return
+	}
+	tr := NewTransport(f, conn, fromNode, req.Node, fromApp, req.App)
+	tr.setupRelease = release
+	tr.setupID = req.SetupID
+	if req.Timeouts != nil {
+		tr.timeouts = req.Timeouts.clamp().or(tr.timeouts)
+	}
+	tr.critical = req.Critical || f.isCriticalApp(req.App)
+	tr.standby = req.standby
+	tr.dial = *req
+	tr.dial.Critical = tr.critical
+	tr.dial.Private = req.Private || f.privateSetupsEnabled()
+	tr.dial.standby = false
+	tr.Logger().Infof("setup transport to node %x app %x by discovery %x", req.Node, req.App, discoveryKey)
+	span := tr.startSpan("transport.setup", req.Trace)
+	span.SetAttribute("discovery", discoveryKey.Hex())
+	plain := f.allowsPlainTransport(req.Node)
+	if plain {
+		tr.askPlain()
+	}
+	// the callback runs after req went back to its pool
+	node := req.Node
+	tr.SetOnAcceptedUDPCallback(func(connection *Connection) {
+		connection.CreatedByTransport = tr
+		connection.SetKey(node)
+		if tr.waitPlain() {
+			connection.SetPlain()
 			return
 		}
-		nodeConn := &forwardNodeConn{
-			Node:     req.Node,
-			App:      req.App,
-			FromApp:  fromApp,
-			FromNode: fromNode,
-			Num:      iv,
+		sc := f.GetDefaultSeedConfig()
+		connection.GetContextLogger().Debugf("set crypto sc %v", sc)
+		if sc == nil {
+			connection.GetContextLogger().Debugf("tr sc is nil")
+		}
+		err := connection.SetCrypto(sc.publicKey, sc.secKey, node, iv)
+		if err != nil {
+			connection.GetContextLogger().Debugf("set crypto err %v", err)
 		}
-		c.writeOP(OP_FORWARD_NODE_CONN, nodeConn)
-		tr.SetupTimeout()
-		conn.setTransport(discoveryKey, tr)
 	})
-	return
+	conn.GetContextLogger().Debugf("app conn create transport to %s", connection.GetRemoteAddr().String())
+	c, err := tr.ListenAndConnect(connection.GetRemoteAddr().String(), discoveryKey)
+	if err != nil {
+		conn.GetContextLogger().Debugf("transport err %v", err)
+		tr.endSpan(err.Error())
+		tr.setupDone()
+		return
+	}
+	nodeConn := &forwardNodeConn{
+		Node:     req.Node,
+		App:      req.App,
+		FromApp:  fromApp,
+		FromNode: fromNode,
+		Num:      iv,
+		Plain:    plain,
+		Trace:    span.Context(),
+		SetupID:  req.SetupID,
+		Schema:   wire.Version,
+		Timeouts: req.Timeouts,
+		Features: f.getTransportFeatures(),
+		Network:  f.announcedNetwork(),
+	}
+	if len(req.Knock) > 0 {
+		nodeConn.Knock = knockMAC(req.Knock, fromNode, req.Node, req.App, iv)
+	}
+	if tr.dial.Private {
+		tr.routeFromApp, tr.routeApp = newRouteID(), newRouteID()
+		nodeConn.Sealed, err = sealApps(f.GetDefaultSeedConfig(), req.Node, sealedApps{FromApp: fromApp, App: req.App}, tr.routeFromApp, tr.routeApp)
+		if err != nil {
+			tr.Logger().Errorf("seal apps: %v", err)
+			tr.endSpan(err.Error())
+			tr.Close()
+			return
+		}
+		f.privateRoutes.Store(tr.routeFromApp, privateRoute{fromApp: fromApp, app: req.App})
+		nodeConn.FromApp, nodeConn.App = tr.routeFromApp, tr.routeApp
+	}
+	record := TransportRecord{ID: newTransportID(), FromNode: fromNode, ToNode: req.Node, FromApp: nodeConn.FromApp, ToApp: nodeConn.App}
+	record.FromSig, err = record.sign(f.GetDefaultSeedConfig())
+	if err != nil {
+		tr.Logger().Errorf("sign transport record: %v", err)
+		tr.endSpan(err.Error())
+		tr.Close()
+		return
+	}
+	nodeConn.TransportID, nodeConn.FromSig = record.ID, record.FromSig
+	tr.setRecord(record, false)
+	conn.setups.Store(tr, struct{}{})
+	c.writeOP(OP_FORWARD_NODE_CONN, nodeConn)
+	tr.SetupTimeout(SetupRoute)
+	conn.setTransport(discoveryKey, tr)
 }
 
 type Priority int
@@ -144,27 +303,43 @@ const (
 	Connected
 	Timeout
 	TransportClosed
+	QuotaExceeded
+	Cancelled
+	// the other app dialed back at the same time, the app is served its connection instead
+	Crossed
+	// the other node takes no more transports, the app dials again after RetryAfter
+	Busy
 )
 
 type PriorityMsg struct {
-	Priority Priority `json:"priority"`
-	Msg      string   `json:"msg"`
-	Type     MsgType  `json:"type"`
-	Time     int64    `json:"time"`
+	Priority Priority `json:"priority" wire:"1"`
+	Msg      string   `json:"msg" wire:"2"`
+	Type     MsgType  `json:"type" wire:"3"`
+	Time     int64    `json:"time" wire:"4"`
+	// seconds the app should wait before it dials again, 0 if not told
+	RetryAfter int64 `json:"retry_after,omitempty" wire:"5"`
 }
 
 type AppConnResp struct {
-	Discovery cipher.PubKey
-	App       cipher.PubKey
-	Host      string `json:",omitempty"`
-	Port      int
-	Failed    bool
-	Msg       PriorityMsg
+	Discovery cipher.PubKey `wire:"1"`
+	App       cipher.PubKey `wire:"2"`
+	Host      string        `json:",omitempty" wire:"3"`
+	Port      int           `wire:"4"`
+	Failed    bool          `wire:"5"`
+	Msg       PriorityMsg   `wire:"6"`
+	// of the setup, to find it in the logs of the nodes and the discovery
+	SetupID string `json:",omitempty" wire:"7"`
 }
 
 // run on app
 func (req *AppConnResp) Run(conn *Connection) (err error) {
 	conn.GetContextLogger().Debugf("recv %#v", req)
+	if req.Failed {
+		conn.GetContextLogger().WithField("setup_id", req.SetupID).Errorf("connection to app %x failed: %s", req.App, req.Msg.Msg)
+		conn.endDialSpan(req.SetupID, req.Msg.Msg)
+	} else {
+		conn.endDialSpan(req.SetupID, "")
+	}
 	if conn.appConnectionInitCallback != nil {
 		addr := conn.GetRemoteAddr().String()
 		host, _, err := net.SplitHostPort(addr)
@@ -181,17 +356,19 @@ func (req *AppConnResp) Run(conn *Connection) (err error) {
 }
 
 type AppFeedback struct {
-	Discovery cipher.PubKey
+	Discovery cipher.PubKey `wire:"1"`
 	// to app
-	App    cipher.PubKey
-	Port   int         `json:"port"`
-	Failed bool        `json:"failed"`
-	Msg    PriorityMsg `json:"msg"`
+	App    cipher.PubKey `wire:"2"`
+	Port   int           `json:"port" wire:"3"`
+	Failed bool          `json:"failed" wire:"4"`
+	Msg    PriorityMsg   `json:"msg" wire:"5"`
 }
 
 func (req *AppFeedback) Execute(f *MessengerFactory, conn *Connection) (r resp, err error) {
 	conn.GetContextLogger().Debugf("recv %#v", req)
-	conn.SetAppFeedback(req)
+	// req goes back to the pool and is decoded into again, the connection keeps a copy
+	fb := *req
+	conn.SetAppFeedback(&fb)
 	tr, ok := conn.getTransport(req.App)
 	if !ok {
 		conn.GetContextLogger().Debugf("AppFeedback tr %x not found", req.App)
@@ -218,32 +395,65 @@ func (req *buildConnResp) Execute(f *MessengerFactory, conn *Connection) (r resp
 	}
 	tr.setUDPConn(conn)
 	tr.connAck()
-	exists := appConn.setTransportIfNotExists(req.App, tr)
-	if exists {
-		tr.Close()
-		conn.GetContextLogger().Debugf("buildConnResp transport exists")
-		return
-	}
-	fnOK := func(port int) {
-		msg := fmt.Sprintf("Discovery(%x): Connected app %x",
-			tr.getDiscoveryKey(), req.App)
-		priorityMsg := PriorityMsg{Priority: Connected, Msg: msg}
-		appConn.PutMessage(priorityMsg)
-		appConn.writeOP(OP_BUILD_APP_CONN|RESP_PREFIX, &AppConnResp{
-			Discovery: tr.getDiscoveryKey(),
-			App:       req.App,
-			Port:      port,
-			Msg:       priorityMsg,
-		})
+	tr.setupDone()
+	standby := tr.isStandby()
+	if standby {
+		if appConn.setStandbyIfNotExists(req.App, tr) {
+			tr.Close()
+			conn.GetContextLogger().Debugf("buildConnResp standby exists")
+			return
+		}
+		tr.StopTimeout()
+		tr.endSpan("")
+		tr.Logger().Infof("standby transport to app %x ready", req.App)
+	} else {
+		exists := appConn.setTransportIfNotExists(req.App, tr)
+		if exists {
+			tr.Close()
+			conn.GetContextLogger().Debugf("buildConnResp transport exists")
+			return
+		}
+		fnOK := func(port int) {
+			msg := fmt.Sprintf("Discovery(%x): Connected app %x",
+				tr.getDiscoveryKey(), req.App)
+			priorityMsg := PriorityMsg{Priority: Connected, Msg: msg}
+			appConn.PutMessage(priorityMsg)
+			// armed before the answer, the feedback of the app may stop it right away
+			tr.setupTimeout(SetupConfirm)
+			appConn.writeOP(OP_BUILD_APP_CONN|RESP_PREFIX, &AppConnResp{
+				Discovery: tr.getDiscoveryKey(),
+				App:       req.App,
+				Port:      port,
+				Msg:       priorityMsg,
+				SetupID:   tr.setupID,
+			})
+			tr.Logger().Infof("transport to app %x connected on port %d", req.App, port)
+		}
+		// fnOK runs with the fields of tr locked, the span is ended after
+		err = failpoint.Inject(FailpointTransportInstall)
+		if err == nil {
+			err = tr.ListenForApp(fnOK)
+		}
+		if err != nil {
+			err = fmt.Errorf("ListenForApp err %v", err)
+			tr.endSpan(err.Error())
+			// the app gets the failure and may retry the transport
+			tr.Close()
+			return
+		}
+		tr.endSpan("")
+		appConn.setPreviousDiscovery(req.App, tr.getDiscoveryKey())
 	}
-	err = tr.ListenForApp(fnOK)
-	if err != nil {
-		err = fmt.Errorf("ListenForApp err %v", err)
-		return
+	f.Parent.getPeerStore().succeeded(req.Node, tr.getDiscoveryKey(), conn.GetRemoteAddr().String())
+	if tr.critical && !standby {
+		go tr.creator.prepareStandby(appConn, tr.dial, tr.getDiscoveryKey())
 	}
 	err = conn.writeOP(OP_APP_CONN_ACK|RESP_PREFIX, &connAck{
 		FromApp: req.FromApp,
 		App:     req.App,
+		Trace:   tr.spanContext(),
+		SetupID: tr.setupID,
+		Standby: standby,
 	})
 	if err != nil {
 		err = fmt.Errorf("buildConnResp err %v", err)
@@ -255,33 +465,102 @@ func (req *buildConnResp) Execute(f *MessengerFactory, conn *Connection) (r resp
 }
 
 type forwardNodeConn struct {
-	Node     cipher.PubKey
-	App      cipher.PubKey
-	FromApp  cipher.PubKey
-	FromNode cipher.PubKey
-	Num      []byte
+	Node     cipher.PubKey `wire:"1"`
+	App      cipher.PubKey `wire:"2"`
+	FromApp  cipher.PubKey `wire:"3"`
+	FromNode cipher.PubKey `wire:"4"`
+	Num      []byte        `wire:"5"`
+	// node A asks for a transport without encryption
+	Plain   bool               `json:",omitempty" wire:"6"`
+	Trace   *trace.SpanContext `json:",omitempty" wire:"7"`
+	SetupID string             `json:",omitempty" wire:"8"`
+	// latest message schema node A decodes
+	Schema int `json:",omitempty" wire:"9"`
+	// of the setup phases set by the app
+	Timeouts *SetupTimeouts `json:",omitempty" wire:"10"`
+	// apps sealed for node B in a private setup, FromApp and App are route ids then
+	Sealed []byte `json:",omitempty" wire:"11"`
+	// capabilities node A supports
+	Features Features `json:",omitempty" wire:"12"`
+	// network of node A, empty for the main network
+	Network string `json:",omitempty" wire:"13"`
+	// mac of the setup with the token of a knocked service
+	Knock []byte `json:",omitempty" wire:"14"`
+	// record of the transport signed by node A
+	TransportID string `json:",omitempty" wire:"15"`
+	FromSig     []byte `json:",omitempty" wire:"16"`
+}
+
+func (req *forwardNodeConn) record() TransportRecord {
+	return TransportRecord{
+		ID:       req.TransportID,
+		FromNode: req.FromNode,
+		ToNode:   req.Node,
+		FromApp:  req.FromApp,
+		ToApp:    req.App,
+		FromSig:  req.FromSig,
+	}
 }
 
 // run on manager, conn is udp conn from node A
 func (req *forwardNodeConn) Execute(f *MessengerFactory, conn *Connection) (r resp, err error) {
+	span := f.getTracer().Start("discovery.forward_conn", req.Trace)
+	span.SetAttribute("from_node", req.FromNode.Hex())
+	span.SetAttribute("to_node", req.Node.Hex())
+	defer span.End()
+	logger := conn.GetContextLogger().WithField("setup_id", req.SetupID)
 	c, ok := f.GetConnection(req.Node)
+	priority, cause := NotFound, fmt.Sprintf("Node %x not exists", req.Node)
+	if ok && len(req.Sealed) > 0 && c.PeerSchema() < PrivateSetupVersion {
+		ok = false
+		priority, cause = NotAllowed, fmt.Sprintf("Node %x does not support private setups", req.Node)
+	}
+	record := req.record()
+	if ok {
+		if key, set := conn.keyIfSet(); !set || key != req.FromNode {
+			ok = false
+			priority, cause = NotAllowed, fmt.Sprintf("transport of node %x on the connection of %x", req.FromNode, key)
+		} else if e := f.checkRecordFrom(&record); e != nil {
+			ok = false
+			priority, cause = NotAllowed, fmt.Sprintf("Node %x: %v", req.FromNode, e)
+		}
+	}
+	msg := PriorityMsg{Priority: priority, Msg: cause, Type: Failed}
+	// c is nil when the node is not connected
+	if ok {
+		if ns := c.GetServices(); ns != nil && ns.Capacity.full() {
+			ok = false
+			cause = fmt.Sprintf("Node %x announced it is busy", req.Node)
+			if ns.Capacity.inMaintenance() {
+				cause = fmt.Sprintf("Node %x announced it is in maintenance", req.Node)
+			}
+			msg = busyMsg(cause, ns.Capacity.retryAfter())
+		}
+	}
 	if !ok {
-		cause := fmt.Sprintf("Node %x not exists", req.Node)
-		conn.GetContextLogger().Debugf(cause)
+		logger.Debugf("forward transport from node %x: %s", req.FromNode, cause)
+		span.Fail(cause)
 		err = conn.writeOP(OP_FORWARD_NODE_CONN_RESP|RESP_PREFIX, &forwardNodeConnResp{
 			Node:     req.Node,
 			App:      req.App,
 			FromApp:  req.FromApp,
 			FromNode: req.FromNode,
 			Failed:   true,
-			Msg:      PriorityMsg{Priority: NotFound, Msg: cause, Type: Failed},
+			Msg:      msg,
 			Num:      req.Num,
+			Trace:    span.Context(),
+			SetupID:  req.SetupID,
 		})
 		return
 	}
 
-	conn.GetContextLogger().Debugf("conn remote addr %v", conn.GetRemoteAddr())
+	if lost, e := req.resolveCrossing(f, conn); lost {
+		err = e
+		return
+	}
+	logger.Debugf("forward transport from node %x at %s to node %x", req.FromNode, conn.GetRemoteAddr(), req.Node)
 	p := globalTransportPairManagerInstance.create(req.FromApp, req.FromNode, req.Node, req.App)
+	p.setRecord(record)
 	err = p.setFromConn(conn)
 	if err != nil {
 		err = fmt.Errorf("set from Conn err: %s", err)
@@ -290,34 +569,68 @@ func (req *forwardNodeConn) Execute(f *MessengerFactory, conn *Connection) (r re
 	conn.SetTransportPair(p)
 	err = c.writeOP(OP_BUILD_NODE_CONN|RESP_PREFIX,
 		&buildConn{
-			Address:  conn.GetRemoteAddr().String(),
-			Node:     req.Node,
-			App:      req.App,
-			FromApp:  req.FromApp,
-			FromNode: req.FromNode,
-			Num:      req.Num,
+			Address:     conn.GetRemoteAddr().String(),
+			Node:        req.Node,
+			App:         req.App,
+			FromApp:     req.FromApp,
+			FromNode:    req.FromNode,
+			Num:         req.Num,
+			Plain:       req.Plain,
+			Trace:       span.Context(),
+			SetupID:     req.SetupID,
+			Schema:      req.Schema,
+			Timeouts:    req.Timeouts,
+			Sealed:      req.Sealed,
+			Features:    req.Features,
+			Network:     req.Network,
+			Knock:       req.Knock,
+			TransportID: req.TransportID,
+			FromSig:     req.FromSig,
 		})
 	return
 }
 
 type forwardNodeConnResp struct {
-	Node     cipher.PubKey
-	App      cipher.PubKey
-	FromApp  cipher.PubKey
-	FromNode cipher.PubKey
-	Failed   bool
-	Msg      PriorityMsg
-	Address  string
-	Num      []byte
+	Node     cipher.PubKey `wire:"1"`
+	App      cipher.PubKey `wire:"2"`
+	FromApp  cipher.PubKey `wire:"3"`
+	FromNode cipher.PubKey `wire:"4"`
+	Failed   bool          `wire:"5"`
+	Msg      PriorityMsg   `wire:"6"`
+	Address  string        `wire:"7"`
+	Num      []byte        `wire:"8"`
+	// node B agreed to a transport without encryption
+	Plain   bool               `json:",omitempty" wire:"9"`
+	Trace   *trace.SpanContext `json:",omitempty" wire:"10"`
+	SetupID string             `json:",omitempty" wire:"11"`
+	// latest message schema node B decodes
+	Schema int `json:",omitempty" wire:"12"`
+	// capabilities node B supports
+	Features Features `json:",omitempty" wire:"13"`
+	// signature of node B over the record of the transport
+	Sig []byte `json:",omitempty" wire:"14"`
 }
 
 // run on manager, conn is tcp/udp from node B
 func (req *forwardNodeConnResp) Execute(f *MessengerFactory, conn *Connection) (r resp, err error) {
+	span := f.getTracer().Start("discovery.forward_conn_resp", req.Trace)
+	span.SetAttribute("from_node", req.FromNode.Hex())
+	span.SetAttribute("to_node", req.Node.Hex())
+	defer span.End()
+	if req.Failed {
+		span.Fail(req.Msg.Msg)
+	}
+	logger := conn.GetContextLogger().WithField("setup_id", req.SetupID)
+	if req.Failed {
+		logger.Infof("transport from node %x failed: %s", req.FromNode, req.Msg.Msg)
+	}
 	c, ok := f.GetConnection(req.FromNode)
 	if !ok {
-		conn.GetContextLogger().Debugf("node %x not exists", req.FromNode)
+		logger.Debugf("node %x not exists", req.FromNode)
+		span.Fail("node A not connected")
 		return
 	}
+	req.Trace = span.Context()
 
 	if conn.IsUDP() {
 		req.Address = conn.GetRemoteAddr().String()
@@ -327,6 +640,23 @@ func (req *forwardNodeConnResp) Execute(f *MessengerFactory, conn *Connection) (
 				err = fmt.Errorf("conn transport pair not exists!? %#v", req)
 				return
 			}
+			if e := p.agreed(f, conn, req.Sig); e != nil {
+				logger.Infof("transport from node %x refused: %v", req.FromNode, e)
+				span.Fail(e.Error())
+				p.close()
+				conn.writeOP(OP_CANCEL_NODE_CONN|RESP_PREFIX, &cancelNodeConn{
+					Node:     req.Node,
+					App:      req.App,
+					FromApp:  req.FromApp,
+					FromNode: req.FromNode,
+					SetupID:  req.SetupID,
+				})
+				req.Failed = true
+				req.Address = ""
+				req.Msg = PriorityMsg{Priority: NotAllowed, Msg: fmt.Sprintf("Node %x: %v", req.Node, e), Type: Failed}
+				err = c.writeOP(OP_FORWARD_NODE_CONN_RESP|RESP_PREFIX, req)
+				return
+			}
 			p.ok()
 			err = p.setToConn(conn)
 			if err != nil {
@@ -346,80 +676,182 @@ func (req *forwardNodeConnResp) Run(conn *Connection) (err error) {
 	if factory == nil {
 		factory = conn.factory
 	}
-	appConn, ok := factory.GetConnection(req.FromApp)
+	fromApp, app := req.FromApp, req.App
+	if r, ok := factory.privateRoutes.Load(req.FromApp); ok {
+		fromApp, app = r.(privateRoute).fromApp, r.(privateRoute).app
+	}
+	appConn, ok := factory.GetConnection(fromApp)
 	if !ok {
-		conn.GetContextLogger().Debugf("forwardNodeConnResp app %x not found", req.FromApp)
+		conn.GetContextLogger().Debugf("forwardNodeConnResp app %x not found", fromApp)
 		return
 	}
 	tr, ok := appConn.getTransport(conn.GetTargetKey())
 	if !ok {
-		conn.GetContextLogger().Debugf("forwardNodeConnResp tr %x not found", req.App)
+		conn.GetContextLogger().Debugf("forwardNodeConnResp tr %x not found", app)
 		return
 	}
 	appConn.deleteTransport(conn.GetTargetKey())
+	if !req.Failed && len(req.Sig) > 0 {
+		record, _ := tr.Record()
+		record.ToSig = req.Sig
+		if e := record.verifySig(req.Node, req.Sig); e != nil {
+			req.Failed = true
+			req.Msg = PriorityMsg{Priority: NotAllowed, Msg: fmt.Sprintf("Node %x: %v", req.Node, e), Type: Failed}
+		} else {
+			tr.setRecord(record, record.Signed())
+		}
+	}
+	// a crossed setup reached the other app, it is not a failure of the route
+	if req.Msg.Priority != Crossed {
+		setup := time.Since(tr.created)
+		factory.paths.add(conn.GetTargetKey(), !req.Failed, setup)
+		factory.routes.put(routeKey{discovery: conn.GetTargetKey(), node: req.Node, app: app}, req.Failed, req.Msg, setup)
+		if req.Failed {
+			factory.getPeerStore().failed(req.Node)
+		} else {
+			factory.routeEstablished(conn.GetTargetKey(), req.Node, app, setup)
+		}
+	}
+	tr.decidePlain(req.Plain && !req.Failed)
 	if tr.isConnAck() {
 		return
 	}
-	appConn.PutMessage(req.Msg)
+	if tr.isStandby() {
+		if req.Failed {
+			tr.Logger().Infof("standby transport to node %x app %x failed: %s", req.Node, app, req.Msg.Msg)
+			tr.endSpan(req.Msg.Msg)
+			tr.Close()
+			return
+		}
+	} else {
+		appConn.PutMessage(req.Msg)
+	}
 	if req.Failed {
+		tr.Logger().Infof("transport to node %x app %x failed: %s", req.Node, app, req.Msg.Msg)
 		appConn.writeOP(OP_BUILD_APP_CONN|RESP_PREFIX, &AppConnResp{
 			Discovery: conn.GetTargetKey(),
-			App:       req.App,
+			App:       app,
 			Failed:    req.Failed,
 			Msg:       req.Msg,
+			SetupID:   tr.setupID,
 		})
+		tr.endSpan(req.Msg.Msg)
 		tr.Close()
 		return
 	}
 	if len(req.Address) > 0 {
+		tr.SetupTimeout(SetupConnect)
+		tr.setPeerSchema(req.Schema)
+		tr.setFeatures(req.Features)
+		span := factory.getTracer().Start("node.connect", req.Trace)
+		span.SetAttribute("address", req.Address)
 		e := tr.clientSideConnect(req.Address, conn.factory.GetDefaultSeedConfig(), req.Num)
 		if e != nil {
-			conn.GetContextLogger().Debugf("forwardNodeConnResp clientSideConnect %v", e)
+			tr.Logger().Debugf("connect to node %x at %s: %v", req.Node, req.Address, e)
+			span.Fail(e.Error())
+		} else {
+			go tr.requestReversal(reverseConnDelay)
 		}
+		span.End()
 	}
 	return
 }
 
 type buildConn struct {
-	Address  string
-	Node     cipher.PubKey
-	App      cipher.PubKey
-	FromApp  cipher.PubKey
-	FromNode cipher.PubKey
-	Num      []byte
+	Address  string             `wire:"1"`
+	Node     cipher.PubKey      `wire:"2"`
+	App      cipher.PubKey      `wire:"3"`
+	FromApp  cipher.PubKey      `wire:"4"`
+	FromNode cipher.PubKey      `wire:"5"`
+	Num      []byte             `wire:"6"`
+	Plain    bool               `json:",omitempty" wire:"7"`
+	Trace    *trace.SpanContext `json:",omitempty" wire:"8"`
+	SetupID  string             `json:",omitempty" wire:"9"`
+	// latest message schema node A decodes
+	Schema int `json:",omitempty" wire:"10"`
+	// of the setup phases set by the app
+	Timeouts *SetupTimeouts `json:",omitempty" wire:"11"`
+	// apps sealed for node B in a private setup, FromApp and App are route ids then
+	Sealed []byte `json:",omitempty" wire:"12"`
+	// capabilities node A supports
+	Features Features `json:",omitempty" wire:"13"`
+	// network of node A, empty for the main network
+	Network string `json:",omitempty" wire:"14"`
+	// mac of the setup with the token of a knocked service
+	Knock []byte `json:",omitempty" wire:"15"`
+	// record of the transport signed by node A
+	TransportID string `json:",omitempty" wire:"16"`
+	FromSig     []byte `json:",omitempty" wire:"17"`
+}
+
+// fail answers node A through the discovery that the transport can not be built
+func (req *buildConn) fail(conn *Connection, priority Priority, cause string) error {
+	return req.failWith(conn, PriorityMsg{Priority: priority, Msg: cause, Type: Failed})
+}
+
+func (req *buildConn) failWith(conn *Connection, msg PriorityMsg) error {
+	cause := msg.Msg
+	conn.GetContextLogger().WithField("setup_id", req.SetupID).Debugf("refuse transport from node %x: %s", req.FromNode, cause)
+	span := conn.factory.getTracer().Start("node.accept", req.Trace)
+	span.Fail(cause)
+	span.End()
+	return conn.writeOP(OP_FORWARD_NODE_CONN_RESP, &forwardNodeConnResp{
+		Node:     req.Node,
+		App:      req.App,
+		FromApp:  req.FromApp,
+		FromNode: req.FromNode,
+		Failed:   true,
+		Msg:      msg,
+		Num:      req.Num,
+		Trace:    span.Context(),
+		SetupID:  req.SetupID,
+	})
 }
 
 func (req *buildConn) Run(conn *Connection) (err error) {
-	appConn, ok := conn.factory.GetConnection(req.App)
+	// the answers through the discovery only name the apps of a private setup by the route ids
+	fromApp, app := req.FromApp, req.App
+	if e := conn.factory.checkNetwork(req.Network); e != nil {
+		return req.fail(conn, NotAllowed, fmt.Sprintf("Node %x: %v", req.Node, e))
+	}
+	record := TransportRecord{
+		ID:       req.TransportID,
+		FromNode: req.FromNode,
+		ToNode:   req.Node,
+		FromApp:  req.FromApp,
+		ToApp:    req.App,
+		FromSig:  req.FromSig,
+	}
+	if len(record.FromSig) > 0 {
+		if e := record.verifySig(req.FromNode, record.FromSig); e != nil {
+			return req.fail(conn, NotAllowed, fmt.Sprintf("Node %x: %v", req.Node, e))
+		}
+	}
+	if len(req.Sealed) > 0 {
+		apps, e := openApps(conn.factory.GetDefaultSeedConfig(), req.FromNode, req.Sealed, req.FromApp, req.App)
+		if e != nil {
+			return req.fail(conn, NotAllowed, fmt.Sprintf("Node %x: %v", req.Node, e))
+		}
+		fromApp, app = apps.FromApp, apps.App
+	}
+	appConn, ok := conn.factory.GetConnection(app)
 	if !ok {
-		cause := fmt.Sprintf("Node %x app %x not exists", req.Node, req.App)
-		conn.GetContextLogger().Debugf(cause)
-		err = conn.writeOP(OP_FORWARD_NODE_CONN_RESP, &forwardNodeConnResp{
-			Node:     req.Node,
-			App:      req.App,
-			FromApp:  req.FromApp,
-			FromNode: req.FromNode,
-			Failed:   true,
-			Msg:      PriorityMsg{Priority: NotFound, Msg: cause, Type: Failed},
-			Num:      req.Num,
-		})
-		return
+		return req.fail(conn, NotFound, fmt.Sprintf("Node %x app %x not exists", req.Node, req.App))
 	}
 
-	s, ok := appConn.getService(req.App)
+	s, ok := appConn.getService(app)
 	if !ok {
-		cause := fmt.Sprintf("Node %x app %x not exists", req.Node, req.App)
-		conn.GetContextLogger().Debugf(cause)
-		err = conn.writeOP(OP_FORWARD_NODE_CONN_RESP, &forwardNodeConnResp{
-			Node:     req.Node,
-			App:      req.App,
-			FromApp:  req.FromApp,
-			FromNode: req.FromNode,
-			Failed:   true,
-			Msg:      PriorityMsg{Priority: NotFound, Msg: cause, Type: Failed},
-			Num:      req.Num,
-		})
-		return
+		return req.fail(conn, NotFound, fmt.Sprintf("Node %x app %x not exists", req.Node, req.App))
+	}
+	if !s.knockAllows(req.Knock, req.FromNode, req.Node, app, req.Num) {
+		// the same answer as for a missing app, scanning does not find the service
+		conn.GetContextLogger().WithField("setup_id", req.SetupID).Debugf("transport from node %x to app %x without its knock", req.FromNode, app)
+		return req.fail(conn, NotFound, fmt.Sprintf("Node %x app %x not exists", req.Node, req.App))
+	}
+
+	if !conn.factory.peerAllowed(req.FromNode) {
+		conn.factory.peerRefused(req.FromNode, app)
+		return req.fail(conn, NotAllowed, fmt.Sprintf("Node %x refuses node %x", req.Node, req.FromNode))
 	}
 
 	if len(s.AllowNodes) > 0 {
@@ -431,24 +863,46 @@ func (req *buildConn) Run(conn *Connection) (err error) {
 			}
 		}
 		if !allow {
-			cause := fmt.Sprintf("Node %x app %x forbid %x", req.Node, req.App, req.FromNode)
-			conn.GetContextLogger().Debugf(cause)
-			err = conn.writeOP(OP_FORWARD_NODE_CONN_RESP, &forwardNodeConnResp{
-				Node:     req.Node,
-				App:      req.App,
-				FromApp:  req.FromApp,
-				FromNode: req.FromNode,
-				Failed:   true,
-				Msg:      PriorityMsg{Priority: NotAllowed, Msg: cause, Type: Failed},
-				Num:      req.Num,
-			})
-			return
+			return req.fail(conn, NotAllowed, fmt.Sprintf("Node %x app %x forbid %x", req.Node, req.App, req.FromNode))
 		}
 	}
 
-	tr := NewTransport(conn.factory, appConn, req.FromNode, req.Node, req.FromApp, req.App)
+	if conn.factory.getQuotas().exceeded(app) {
+		return req.fail(conn, QuotaExceeded, fmt.Sprintf("Node %x app %x: %v", req.Node, req.App, errQuotaExceeded))
+	}
+
+	if retryAfter, e := conn.factory.admitTransport(); e != nil {
+		return req.failWith(conn, busyMsg(fmt.Sprintf("Node %x: %v", req.Node, e), retryAfter))
+	}
+
+	tr := NewTransport(conn.factory, appConn, req.FromNode, req.Node, fromApp, app)
+	if len(req.Sealed) > 0 {
+		tr.routeFromApp, tr.routeApp = req.FromApp, req.App
+	}
+	tr.setupID = req.SetupID
+	tr.peerSchema = req.Schema
+	tr.setFeatures(req.Features)
+	if req.Timeouts != nil {
+		tr.timeouts = req.Timeouts.clamp().or(tr.timeouts)
+	}
+	if len(record.FromSig) > 0 {
+		record.ToSig, err = record.sign(conn.factory.GetDefaultSeedConfig())
+		if err != nil {
+			return
+		}
+	}
+	tr.setRecord(record, record.Signed())
+	tr.Logger().Infof("accept transport from node %x app %x", req.FromNode, fromApp)
+	span := tr.startSpan("node.accept", req.Trace)
+	// plain only if both nodes list each other
+	plain := req.Plain && conn.factory.allowsPlainTransport(req.FromNode)
+	if plain {
+		tr.askPlain()
+		tr.decidePlain(true)
+	}
 	connection, err := tr.ListenAndConnect(conn.GetRemoteAddr().String(), conn.GetTargetKey())
 	if err != nil {
+		tr.endSpan(err.Error())
 		return
 	}
 	msg := PriorityMsg{
@@ -456,12 +910,16 @@ func (req *buildConn) Run(conn *Connection) (err error) {
 		Msg: fmt.Sprintf("Discovery(%x): Building connection from node %x app %x to node %x app %x",
 			conn.GetTargetKey(),
 			req.FromNode,
-			req.FromApp,
+			fromApp,
 			req.Node,
-			req.App,
+			app,
 		),
 	}
 	appConn.PutMessage(msg)
+	if len(req.Sealed) > 0 {
+		msg.Msg = fmt.Sprintf("Discovery(%x): Building private connection from node %x to node %x",
+			conn.GetTargetKey(), req.FromNode, req.Node)
+	}
 	err = connection.writeOP(OP_FORWARD_NODE_CONN_RESP, &forwardNodeConnResp{
 		Node:     req.Node,
 		App:      req.App,
@@ -469,17 +927,33 @@ func (req *buildConn) Run(conn *Connection) (err error) {
 		FromNode: req.FromNode,
 		Msg:      msg,
 		Num:      req.Num,
+		Plain:    plain,
+		Trace:    span.Context(),
+		SetupID:  req.SetupID,
+		Schema:   wire.Version,
+		Features: conn.factory.getTransportFeatures(),
+		Sig:      record.ToSig,
 	})
 	if err != nil {
+		tr.endSpan(err.Error())
 		return
 	}
 	err = tr.serverSiceConnect(req.Address, s.Address, conn.factory.GetDefaultSeedConfig(), req.Num)
-	tr.SetupTimeout()
+	if err == nil {
+		conn.factory.setPendingTransport(tr)
+		conn.factory.capacityChanged()
+	}
+	tr.SetupTimeout(SetupConnect)
 	return
 }
 
 type connAck struct {
-	FromApp, App cipher.PubKey
+	FromApp cipher.PubKey      `wire:"1"`
+	App     cipher.PubKey      `wire:"2"`
+	Trace   *trace.SpanContext `json:",omitempty" wire:"3"`
+	SetupID string             `json:",omitempty" wire:"4"`
+	// the transport is the standby of the one between the apps
+	Standby bool `json:",omitempty" wire:"5"`
 }
 
 // run on node b from node a udp
@@ -490,8 +964,22 @@ func (req *connAck) Run(conn *Connection) (err error) {
 		err = fmt.Errorf("tr %x not exists", tr)
 		return
 	}
-	tr.appConnHolder.setTransportIfNotExists(req.FromApp, tr)
+	if req.Standby {
+		tr.fieldsMutex.Lock()
+		tr.standby = true
+		tr.fieldsMutex.Unlock()
+		// kept so that it is closed with the app and takes over when the transport closes
+		if tr.appConnHolder.setStandbyIfNotExists(req.FromApp, tr) {
+			tr.Close()
+			return
+		}
+	} else {
+		tr.appConnHolder.setTransportIfNotExists(req.FromApp, tr)
+	}
+	tr.creator.deletePendingTransport(tr)
 	tr.StopTimeout()
+	tr.Logger().Infof("transport from app %x connected", req.FromApp)
+	tr.endSpan("")
 	msg := PriorityMsg{
 		Priority: Connected,
 		Msg: fmt.Sprintf("Discovery(%x): Connected by app %x",
@@ -0,0 +1,95 @@
+package factory_test
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/skycoin/skycoin/src/cipher"
+	"github.com/skycoin/skywire/pkg/node/nodetest"
+)
+
+// setupLogs records the messages logged with a setup id
+type setupLogs struct {
+	messages map[string][]string
+	sync.Mutex
+}
+
+var setups = &setupLogs{messages: make(map[string][]string)}
+
+// the hooks of logrus are not safe to add while the nodes of other tests log,
+// the one of the setups is added before any test runs
+func TestMain(m *testing.M) {
+	log.AddHook(setups)
+	os.Exit(m.Run())
+}
+
+func (l *setupLogs) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (l *setupLogs) Fire(entry *log.Entry) error {
+	id, ok := entry.Data["setup_id"].(string)
+	if !ok || len(id) == 0 {
+		return nil
+	}
+	l.Lock()
+	l.messages[id] = append(l.messages[id], entry.Message)
+	l.Unlock()
+	return nil
+}
+
+// logged returns true if a message of the setup starts with each of the prefixes
+func (l *setupLogs) logged(id string, prefixes ...string) bool {
+	l.Lock()
+	defer l.Unlock()
+	for _, p := range prefixes {
+		found := false
+		for _, m := range l.messages[id] {
+			found = found || strings.HasPrefix(m, p)
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSetupID(t *testing.T) {
+	e := nodetest.NewEnv(t, 1)
+	defer e.Close()
+	a, b := e.StartNode("a"), e.StartNode("b")
+	server := e.ConnectApp(b, "server")
+	server.Offer("127.0.0.1:1", "setup")
+	client := e.ConnectApp(a, "client")
+
+	// a failure returned to the app carries the id the discovery logged it with
+	resp := client.Dial(cipher.PubKey{0x02, 1}, server.GetKey(), e.DiscoveryKey(0))
+	if !resp.Failed || len(resp.SetupID) == 0 {
+		t.Fatalf("answer of the failed setup %+v", resp)
+	}
+	nodetest.WaitFor(t, "the logs of the failed setup", func() bool {
+		return setups.logged(resp.SetupID, "build connection to node", "forward transport from node", "transport to node")
+	})
+
+	// the app, node A, the discovery and node B log the setup with the id the app gets
+	resp = client.Connect(b.Key, server.GetKey(), e.DiscoveryKey(0))
+	if len(resp.SetupID) == 0 {
+		t.Fatalf("answer without a setup id %+v", resp)
+	}
+	nodetest.WaitFor(t, "the logs of the setup", func() bool {
+		return setups.logged(resp.SetupID,
+			"build connection to node",
+			"setup transport to node",
+			"forward transport from node",
+			"accept transport from node",
+			"transport to app",
+			"transport from app")
+	})
+	// the decision of the route is found by the id too
+	if d, ok := a.ExplainRoute(resp.SetupID); !ok || d.Decision.ID != resp.SetupID {
+		t.Fatalf("route of the setup %+v", d)
+	}
+}
@@ -0,0 +1,141 @@
+package factory
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+func init() {
+	ops[OP_CANCEL_APP_CONN] = &sync.Pool{
+		New: func() interface{} {
+			return new(cancelAppConn)
+		},
+	}
+	ops[OP_CANCEL_NODE_CONN] = &sync.Pool{
+		New: func() interface{} {
+			return new(cancelNodeConn)
+		},
+	}
+	resps[OP_CANCEL_NODE_CONN] = &sync.Pool{
+		New: func() interface{} {
+			return new(cancelNodeConn)
+		},
+	}
+}
+
+// cancelAppConn is sent by an app that gave up the connections it asked for to another app
+type cancelAppConn struct {
+	App cipher.PubKey
+	// of the connection, all connections to the app being set up if empty
+	SetupID string `json:",omitempty"`
+}
+
+// CancelAppConnection gives up the connections to the app being set up, node A tears the
+// transports down at the discovery and node B at once instead of waiting for the timeouts.
+// A connection the app confirmed is closed as usual
+func (c *Connection) CancelAppConnection(app cipher.PubKey, setupID string) error {
+	c.GetContextLogger().WithField("setup_id", setupID).Infof("cancel connection to app %x", app)
+	return c.writeOP(OP_CANCEL_APP_CONN, &cancelAppConn{App: app, SetupID: setupID})
+}
+
+// run on node A, conn is tcp from the app
+func (req *cancelAppConn) Execute(f *MessengerFactory, conn *Connection) (r resp, err error) {
+	f.cancelQueuedSetups(conn, req)
+	var cancelled []*Transport
+	conn.setups.Range(func(key, value interface{}) bool {
+		tr := key.(*Transport)
+		if tr.ToApp == req.App && (len(req.SetupID) == 0 || tr.setupID == req.SetupID) &&
+			!tr.isStandby() && tr.inSetup() {
+			cancelled = append(cancelled, tr)
+		}
+		return true
+	})
+	for _, tr := range cancelled {
+		tr.cancel()
+	}
+	return
+}
+
+// inSetup returns true while a setup phase of the transport runs
+func (t *Transport) inSetup() bool {
+	t.fieldsMutex.RLock()
+	defer t.fieldsMutex.RUnlock()
+	return t.factory != nil && t.timeoutTimer != nil
+}
+
+// cancel tells the discovery and node B to drop the setup, answers the app and closes
+func (t *Transport) cancel() {
+	t.Logger().Infof("transport to node %x app %x cancelled by the app", t.ToNode, t.ToApp)
+	discovery := t.getDiscoveryKey()
+	if t.discoveryConn != nil {
+		fromApp, app := t.routeApps()
+		err := t.discoveryConn.writeOP(OP_CANCEL_NODE_CONN, &cancelNodeConn{
+			Node:     t.ToNode,
+			App:      app,
+			FromApp:  fromApp,
+			FromNode: t.FromNode,
+			SetupID:  t.setupID,
+		})
+		if err != nil {
+			t.discoveryConn.GetContextLogger().Debugf("cancel transport err %v", err)
+		}
+	}
+	if tr, ok := t.appConnHolder.getTransport(discovery); ok && tr == t {
+		t.appConnHolder.deleteTransport(discovery)
+	}
+	t.appConnHolder.writeOP(OP_BUILD_APP_CONN|RESP_PREFIX, &AppConnResp{
+		Discovery: discovery,
+		App:       t.ToApp,
+		Failed:    true,
+		Msg: PriorityMsg{
+			Priority: Cancelled,
+			Msg:      fmt.Sprintf("Discovery(%x): cancelled by the app", discovery),
+			Type:     Failed,
+		},
+		SetupID: t.setupID,
+	})
+	t.endSpan("cancelled by the app")
+	t.CloseWithReason(CloseCancelled)
+}
+
+// cancelNodeConn drops a transport setup at the discovery and node B
+type cancelNodeConn struct {
+	Node     cipher.PubKey
+	App      cipher.PubKey
+	FromApp  cipher.PubKey
+	FromNode cipher.PubKey
+	SetupID  string `json:",omitempty"`
+}
+
+// run on manager, conn is udp conn from node A
+func (req *cancelNodeConn) Execute(f *MessengerFactory, conn *Connection) (r resp, err error) {
+	if req.FromNode != conn.GetKey() {
+		err = fmt.Errorf("cancel conn from node %x on conn of %x", req.FromNode, conn.GetKey())
+		return
+	}
+	conn.GetContextLogger().WithField("setup_id", req.SetupID).Infof("cancel transport from node %x to node %x", req.FromNode, req.Node)
+	if p, ok := globalTransportPairManagerInstance.get(req.FromApp, req.FromNode, req.Node, req.App); ok {
+		p.close()
+	}
+	c, ok := f.GetConnection(req.Node)
+	if !ok {
+		return
+	}
+	err = c.writeOP(OP_CANCEL_NODE_CONN|RESP_PREFIX, req)
+	return
+}
+
+// run on node B, from manager
+func (req *cancelNodeConn) Run(conn *Connection) (err error) {
+	tr, ok := conn.factory.getPendingTransport(req.FromNode, req.FromApp, req.App)
+	if !ok {
+		conn.GetContextLogger().Debugf("cancel conn tr %x not found", req.FromApp)
+		return
+	}
+	tr.Logger().Infof("transport from node %x app %x cancelled by node A", req.FromNode, req.FromApp)
+	tr.endSpan("cancelled by node A")
+	tr.CloseWithReason(CloseCancelled)
+	return
+}
@@ -0,0 +1,83 @@
+package factory
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+func TestCancelAppConn(t *testing.T) {
+	fromNode, toNode := cipher.PubKey([33]byte{0x02, 1}), cipher.PubKey([33]byte{0x02, 2})
+	fromApp, app, other := cipher.PubKey([33]byte{0x03, 1}), cipher.PubKey([33]byte{0x03, 2}), cipher.PubKey([33]byte{0x03, 3})
+	for _, c := range []struct {
+		name      string
+		req       cancelAppConn
+		cancelled []string
+	}{
+		{name: "all setups to the app", req: cancelAppConn{App: app}, cancelled: []string{"a", "b"}},
+		{name: "one setup", req: cancelAppConn{App: app, SetupID: "b"}, cancelled: []string{"b"}},
+		{name: "unknown setup", req: cancelAppConn{App: app, SetupID: "z"}},
+		{name: "another app", req: cancelAppConn{App: cipher.PubKey([33]byte{0x03, 4})}},
+	} {
+		f := NewMessengerFactory()
+		conn, fake := newFakeConnection(f, "127.0.0.1:5000")
+		conn.SetKey(fromApp)
+		transports := make(map[string]*Transport)
+		for _, s := range []struct {
+			id      string
+			app     cipher.PubKey
+			standby bool
+			inSetup bool
+		}{
+			{id: "a", app: app, inSetup: true},
+			{id: "b", app: app, inSetup: true},
+			// the standby and the transport the app confirmed are closed as usual
+			{id: "standby", app: app, standby: true, inSetup: true},
+			{id: "confirmed", app: app},
+			{id: "other", app: other, inSetup: true},
+		} {
+			tr := NewTransport(f, conn, fromNode, toNode, fromApp, s.app)
+			tr.setupID = s.id
+			tr.standby = s.standby
+			if s.inSetup {
+				tr.timeoutTimer = time.NewTimer(time.Hour)
+			}
+			conn.setups.Store(tr, struct{}{})
+			transports[s.id] = tr
+		}
+
+		req := c.req
+		if _, err := req.Execute(f, conn); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		var cancelled, answered []string
+		for id, tr := range transports {
+			if tr.CloseReason() == CloseCancelled {
+				cancelled = append(cancelled, id)
+			}
+		}
+		for _, w := range fake.written {
+			if w[MSG_OP_BEGIN] != OP_BUILD_APP_CONN|RESP_PREFIX {
+				continue
+			}
+			var resp AppConnResp
+			if err := json.Unmarshal(w[MSG_HEADER_END:], &resp); err != nil {
+				t.Fatal(err)
+			}
+			if !resp.Failed || resp.Msg.Priority != Cancelled || resp.App != app {
+				t.Errorf("%s: answered %#v", c.name, resp)
+			}
+			answered = append(answered, resp.SetupID)
+		}
+		sort.Strings(cancelled)
+		sort.Strings(answered)
+		if strings.Join(cancelled, ",") != strings.Join(c.cancelled, ",") ||
+			strings.Join(answered, ",") != strings.Join(c.cancelled, ",") {
+			t.Errorf("%s: cancelled %v, answered %v, want %v", c.name, cancelled, answered, c.cancelled)
+		}
+	}
+}
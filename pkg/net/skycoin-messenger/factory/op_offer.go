@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net"
 	"sync"
+	"time"
 
 	"github.com/skycoin/skywire/pkg/net/util"
 )
@@ -36,6 +37,17 @@ func (offer *offer) UnmarshalJSON(data []byte) (err error) {
 }
 
 func (offer *offer) Execute(f *MessengerFactory, conn *Connection) (r resp, err error) {
+	if max := f.GetMaxClockSkew(); max > 0 && offer.Services.Time != 0 {
+		skew := time.Since(time.Unix(offer.Services.Time, 0))
+		if skew > max || skew < -max {
+			// the services the node registered before are dropped too, they may be as stale as
+			// its clock
+			conn.GetContextLogger().Warnf("services of node %x refused, the clock of the node is off by %s, more than %s",
+				conn.GetKey(), skew, max)
+			f.discoveryUnregister(conn)
+			return
+		}
+	}
 	remoteAddr := conn.GetRemoteAddr().String()
 	host, _, err := net.SplitHostPort(remoteAddr)
 	if len(offer.Services.ServiceAddress) > 0 {
@@ -0,0 +1,54 @@
+package factory
+
+import (
+	"testing"
+	"time"
+
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+func TestOfferClockSkew(t *testing.T) {
+	now := time.Now().Unix()
+	for _, c := range []struct {
+		name string
+		max  time.Duration
+		time int64
+		// whether the services are registered after the offer
+		registered bool
+	}{
+		{name: "no bound", time: now - 3600, registered: true},
+		{name: "no time", max: time.Minute, registered: true},
+		{name: "in time", max: time.Minute, time: now, registered: true},
+		{name: "behind", max: time.Minute, time: now - 3600},
+		{name: "ahead", max: time.Minute, time: now + 3600},
+	} {
+		f := NewMessengerFactory()
+		f.SetMaxClockSkew(c.max)
+		registered := make(map[cipher.PubKey]*NodeServices)
+		f.serviceDiscovery.RegisterService = func(key cipher.PubKey, ns *NodeServices) error {
+			registered[key] = ns
+			return nil
+		}
+		f.serviceDiscovery.UnRegisterService = func(key cipher.PubKey) error {
+			delete(registered, key)
+			return nil
+		}
+		conn, _ := newFakeConnection(f, "127.0.0.1:5000")
+		key := cipher.PubKey([33]byte{0x02, 0x01})
+		conn.SetKey(key)
+		services := func() *NodeServices {
+			return &NodeServices{Services: []*Service{{Key: cipher.PubKey([33]byte{0x02, 0x02}), Attributes: []string{"skew"}}}}
+		}
+		// the services registered before are dropped with the skewed ones
+		f.discoveryRegister(conn, services())
+
+		o := &offer{Services: services()}
+		o.Services.Time = c.time
+		if _, err := o.Execute(f, conn); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if _, ok := registered[key]; ok != c.registered {
+			t.Errorf("%s: registered %v, want %v", c.name, ok, c.registered)
+		}
+	}
+}
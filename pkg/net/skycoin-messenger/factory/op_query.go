@@ -39,6 +39,8 @@ var (
 type query struct {
 	Keys []cipher.PubKey
 	Seq  uint32
+	// etag of the last answer of the node, answered with NotModified while the result is the same
+	ETag string `json:",omitempty"`
 }
 
 func newQuery(keys []cipher.PubKey) *query {
@@ -48,10 +50,20 @@ func newQuery(keys []cipher.PubKey) *query {
 
 func (query *query) Execute(f *MessengerFactory, conn *Connection) (r resp, err error) {
 	if !f.Proxy {
-		r = &QueryResp{
-			Seq:    query.Seq,
-			Result: f.findServiceAddresses(query.Keys, conn.GetKey()),
+		if e := f.queries.check(conn); e != nil {
+			conn.GetContextLogger().Debugf("query rejected: %v", e)
+			r = &QueryResp{Seq: query.Seq, Error: e.Error()}
+			return
 		}
+		result := f.findServiceAddresses(query.Keys, conn.GetKey())
+		resp := &QueryResp{Seq: query.Seq, ETag: resultETag(result)}
+		if len(query.ETag) > 0 && query.ETag == resp.ETag {
+			f.queries.countNotModified()
+			resp.NotModified = true
+		} else {
+			resp.Result = result
+		}
+		r = resp
 		return
 	}
 	f.ForEachConn(func(connection *Connection) {
@@ -65,12 +77,18 @@ func (query *query) Execute(f *MessengerFactory, conn *Connection) (r resp, err
 type QueryResp struct {
 	Seq    uint32
 	Result []*ServiceInfo
+	// why the discovery did not serve the query
+	Error string `json:",omitempty"`
+	// etag of the result, the result is left out when it is the one of the etag of the query
+	ETag        string `json:",omitempty"`
+	NotModified bool   `json:",omitempty"`
 }
 
 func (resp *QueryResp) Run(conn *Connection) (err error) {
 	if connection, ok := conn.removeProxyConnection(resp.Seq); ok {
 		return connection.writeOP(OP_QUERY_SERVICE_NODES|RESP_PREFIX, resp)
 	}
+	conn.queries.services(resp)
 	if conn.findServiceNodesByKeysCallback != nil {
 		conn.findServiceNodesByKeysCallback(resp)
 	}
@@ -83,6 +101,8 @@ type queryByAttrs struct {
 	Seq   uint32
 	Pages int
 	Limit int
+	// etag of the last answer of the node, answered with NotModified while the result is the same
+	ETag string `json:",omitempty"`
 }
 
 func newQueryByAttrs(attrs []string) *queryByAttrs {
@@ -108,7 +128,20 @@ func (query *queryByAttrs) Execute(f *MessengerFactory, conn *Connection) (r res
 		query.Limit = 5
 	}
 	if !f.Proxy {
-		r = &QueryByAttrsResp{Seq: query.Seq, Result: f.FindByAttributesAndPaging(query.Pages, query.Limit, query.Attrs...)}
+		if e := f.queries.check(conn); e != nil {
+			conn.GetContextLogger().Debugf("query rejected: %v", e)
+			r = &QueryByAttrsResp{Seq: query.Seq, Error: e.Error()}
+			return
+		}
+		result := f.FindByAttributesAndPaging(query.Pages, query.Limit, query.Attrs...)
+		resp := &QueryByAttrsResp{Seq: query.Seq, ETag: resultETag(result)}
+		if len(query.ETag) > 0 && query.ETag == resp.ETag {
+			f.queries.countNotModified()
+			resp.NotModified = true
+		} else {
+			resp.Result = result
+		}
+		r = resp
 		return
 	}
 	f.ForEachConn(func(connection *Connection) {
@@ -122,12 +155,18 @@ func (query *queryByAttrs) Execute(f *MessengerFactory, conn *Connection) (r res
 type QueryByAttrsResp struct {
 	Result *AttrNodesInfo
 	Seq    uint32
+	// why the discovery did not serve the query
+	Error string `json:",omitempty"`
+	// etag of the result, the result is left out when it is the one of the etag of the query
+	ETag        string `json:",omitempty"`
+	NotModified bool   `json:",omitempty"`
 }
 
 func (resp *QueryByAttrsResp) Run(conn *Connection) (err error) {
 	if connection, ok := conn.removeProxyConnection(resp.Seq); ok {
 		return connection.writeOP(OP_QUERY_BY_ATTRS|RESP_PREFIX, resp)
 	}
+	conn.queries.nodes(resp)
 	if conn.findServiceNodesByAttributesCallback != nil {
 		conn.findServiceNodesByAttributesCallback(resp)
 	}
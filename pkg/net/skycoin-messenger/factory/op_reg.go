@@ -8,6 +8,8 @@ import (
 	"sync"
 
 	"github.com/skycoin/skycoin/src/cipher"
+	"github.com/skycoin/skywire/pkg/failpoint"
+	"github.com/skycoin/skywire/pkg/net/wire"
 )
 
 func init() {
@@ -53,6 +55,7 @@ func (reg *reg) Execute(f *MessengerFactory, conn *Connection) (r resp, err erro
 		conn.GetContextLogger().WithField("pubkey", conn.key.Hex()).Infof("reg already")
 		return
 	}
+	conn.handshakeStarted(anonymousRegVersion)
 	key, _ := cipher.GenerateKeyPair()
 	conn.SetKey(key)
 	conn.SetContextLogger(conn.GetContextLogger().WithField("pubkey", key.Hex()))
@@ -74,6 +77,14 @@ func (resp *regResp) Run(conn *Connection) (err error) {
 const (
 	publicKey = iota
 	randomBytes
+	handshakeVersion
+	handshakeStart
+	handshakeDone
+	handshakeFailed
+	// request of the client, sent again with a cookie
+	regRequest
+	// prologue of the handshake the client signs with the challenge
+	handshakeTranscript
 )
 
 type RegVersion int
@@ -87,6 +98,16 @@ type regWithKey struct {
 	PublicKey cipher.PubKey
 	Context   map[string]string
 	Version   RegVersion
+	// cookie of the server, required by a server under load
+	Cookie []byte `json:",omitempty"`
+	// latest message schema the client decodes
+	Schema int `json:",omitempty"`
+	// capabilities of the client, an app with FeatureCloseReasons is told why its connections close
+	Features Features `json:",omitempty"`
+	// network of the client, empty for the main network
+	Network string `json:",omitempty"`
+	// versions the client supports, the best first, empty from clients before the transcripts
+	Versions []RegVersion `json:",omitempty"`
 }
 
 func (reg *regWithKey) Execute(f *MessengerFactory, conn *Connection) (r resp, err error) {
@@ -94,10 +115,53 @@ func (reg *regWithKey) Execute(f *MessengerFactory, conn *Connection) (r resp, e
 		conn.GetContextLogger().WithField("pubkey", conn.key.Hex()).Infof("reg already")
 		return
 	}
+	err = f.rootFactory().guard.check(conn, reg.PublicKey, reg.Cookie)
+	switch err {
+	case nil:
+	case errCookieSent:
+		err = nil
+		return
+	case errRateLimited:
+		conn.handshakeStarted(reg.Version)
+		conn.failHandshake(HandshakeFailureRateLimited)
+		conn.Close()
+		return
+	case errBadCookie:
+		conn.handshakeStarted(reg.Version)
+		conn.failHandshake(HandshakeFailureBadCookie)
+		conn.Close()
+		return
+	default:
+		return
+	}
 	for k, v := range reg.Context {
 		conn.StoreContext(k, v)
 	}
 	conn.StoreContext(publicKey, reg.PublicKey)
+	conn.handshakeStarted(reg.Version)
+	conn.setPeerSchema(reg.Schema)
+	conn.setPeerFeatures(reg.Features)
+	if f.banned(reg.PublicKey) {
+		err = errors.New("key is banned")
+		conn.GetContextLogger().WithField("pubkey", reg.PublicKey.Hex()).Warnf("refuse reg: %v", err)
+		conn.failHandshake(HandshakeFailureBanned)
+		return
+	}
+	// the proxy of a node only accepts its apps, which belong to no network
+	if !f.Proxy {
+		err = f.checkNetwork(reg.Network)
+		if err != nil {
+			conn.GetContextLogger().WithField("pubkey", reg.PublicKey.Hex()).Warnf("refuse reg: %v", err)
+			conn.failHandshake(HandshakeFailureNetwork)
+			return
+		}
+	}
+	err = f.checkOffer(reg)
+	if err != nil {
+		conn.GetContextLogger().WithField("pubkey", reg.PublicKey.Hex()).Warnf("refuse reg: %v", err)
+		conn.failHandshake(HandshakeFailureDowngrade)
+		return
+	}
 	if reg.Version == RegWithKeyAndEncryptionVersion {
 		sc := f.GetDefaultSeedConfig()
 		if sc == nil {
@@ -112,14 +176,18 @@ func (reg *regWithKey) Execute(f *MessengerFactory, conn *Connection) (r resp, e
 			PublicKey: sc.publicKey,
 			Version:   reg.Version,
 			Hash:      hash,
+			Schema:    wire.Version,
+			Network:   f.announcedNetwork(),
 		}
 		if _, err = io.ReadFull(rand.Reader, resp.Num); err != nil {
 			return
 		}
 		err = conn.SetCrypto(sc.publicKey, sc.secKey, reg.PublicKey, resp.Num)
 		if err != nil {
+			conn.failHandshake(HandshakeFailureDecrypt)
 			return
 		}
+		conn.bindHandshake(reg, resp)
 
 		err = conn.writeOPSyn(OP_REG_KEY|RESP_PREFIX,
 			resp)
@@ -127,7 +195,9 @@ func (reg *regWithKey) Execute(f *MessengerFactory, conn *Connection) (r resp, e
 	}
 	n := cipher.RandByte(64)
 	conn.StoreContext(randomBytes, n)
-	r = &regWithKeyResp{Num: n}
+	resp := &regWithKeyResp{Num: n, Schema: wire.Version, Network: f.announcedNetwork()}
+	conn.bindHandshake(reg, resp)
+	r = resp
 	return
 }
 
@@ -136,9 +206,45 @@ type regWithKeyResp struct {
 	Hash      cipher.SHA256
 	PublicKey cipher.PubKey
 	Version   RegVersion
+	// the server requires the request again with this cookie
+	Cookie []byte `json:",omitempty"`
+	// latest message schema the server decodes
+	Schema int `json:",omitempty"`
+	// network of the server, empty for the main network
+	Network string `json:",omitempty"`
+	// the server expects the signature over the transcript of the handshake
+	Bound bool `json:",omitempty"`
 }
 
 func (resp *regWithKeyResp) Run(conn *Connection) (err error) {
+	if len(resp.Cookie) > 0 {
+		v, ok := conn.context.Load(regRequest)
+		if !ok {
+			err = errors.New("reg request not found")
+			return
+		}
+		req := *v.(*regWithKey)
+		if len(req.Cookie) > 0 {
+			err = errors.New("cookie requested twice")
+			return
+		}
+		req.Cookie = resp.Cookie
+		err = conn.writeOP(OP_REG_KEY, &req)
+		return
+	}
+	req, err := conn.checkAnswer(resp)
+	if err != nil {
+		conn.failHandshake(HandshakeFailureDowngrade)
+		return
+	}
+	conn.setPeerSchema(resp.Schema)
+	if conn.factory.networkSet() {
+		err = conn.factory.checkNetwork(resp.Network)
+		if err != nil {
+			conn.failHandshake(HandshakeFailureNetwork)
+			return
+		}
+	}
 	if resp.Version == RegWithKeyAndEncryptionVersion {
 		k, ok := conn.context.Load(publicKey)
 		if !ok {
@@ -157,9 +263,10 @@ func (resp *regWithKeyResp) Run(conn *Connection) (err error) {
 		}
 		err = conn.SetCrypto(pk, conn.GetSecKey(), tpk, resp.Num)
 		if err != nil {
+			conn.failHandshake(HandshakeFailureDecrypt)
 			return
 		}
-		sig := cipher.SignHash(resp.Hash, conn.GetSecKey())
+		sig := cipher.SignHash(challengeToSign(resp.Hash, req, resp), conn.GetSecKey())
 		err = conn.writeOP(OP_REG_SIG, &regCheckSig{
 			Sig:     sig,
 			Version: resp.Version,
@@ -168,7 +275,7 @@ func (resp *regWithKeyResp) Run(conn *Connection) (err error) {
 		return
 	}
 	sk := conn.GetSecKey()
-	hash := cipher.SumSHA256(resp.Num)
+	hash := challengeToSign(cipher.SumSHA256(resp.Num), req, resp)
 	sig := cipher.SignHash(hash, sk)
 	err = conn.writeOP(OP_REG_SIG, &regCheckSig{Sig: sig})
 	return
@@ -196,6 +303,7 @@ func (reg *regCheckSig) Execute(f *MessengerFactory, conn *Connection) (r resp,
 	}
 	if reg.Version == RegWithKeyAndEncryptionVersion {
 		if conn.GetCrypto() == nil {
+			conn.failHandshake(HandshakeFailureDecrypt)
 			err = errors.New("regCheckSig conn crypto is nil")
 			return
 		}
@@ -209,8 +317,9 @@ func (reg *regCheckSig) Execute(f *MessengerFactory, conn *Connection) (r resp,
 			err = errors.New("hash is invalid")
 			return
 		}
-		err = cipher.VerifySignature(pk, reg.Sig, hash)
+		err = cipher.VerifySignature(pk, reg.Sig, conn.signedChallenge(hash))
 		if err != nil {
+			conn.failHandshake(HandshakeFailureBadKey)
 			return
 		}
 		goto OK
@@ -221,13 +330,27 @@ func (reg *regCheckSig) Execute(f *MessengerFactory, conn *Connection) (r resp,
 			return
 		}
 		hash := cipher.SumSHA256(n.([]byte))
-		err = cipher.VerifySignature(pk, reg.Sig, hash)
+		err = cipher.VerifySignature(pk, reg.Sig, conn.signedChallenge(hash))
 		if err != nil {
+			conn.failHandshake(HandshakeFailureBadKey)
 			return
 		}
 	}
 	r = &regResp{PubKey: pk}
 OK:
+	err = f.rootFactory().guard.checkKey(pk)
+	if err != nil {
+		r = nil
+		conn.failHandshake(HandshakeFailureRateLimited)
+		conn.Close()
+		return
+	}
+	err = failpoint.Inject(FailpointHandshakeComplete)
+	if err != nil {
+		r = nil
+		conn.failHandshake(HandshakeFailureClosed)
+		return
+	}
 	conn.SetKey(pk)
 	conn.SetContextLogger(conn.GetContextLogger().WithField("pubkey", pk.Hex()))
 	if conn.IsTCP() {
@@ -0,0 +1,91 @@
+package factory
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+func init() {
+	ops[OP_REVERSE_CONN] = &sync.Pool{
+		New: func() interface{} {
+			return new(reverseConn)
+		},
+	}
+	resps[OP_REVERSE_CONN] = &sync.Pool{
+		New: func() interface{} {
+			return new(reverseConn)
+		},
+	}
+}
+
+// node A asks for the reversal when the build response of node B did not arrive this long
+// after node A sent to node B
+const reverseConnDelay = 3 * time.Second
+
+// reverseConn asks node B to dial back to the public endpoint of node A,
+// node A has sent to node B before so a restricted nat of node A lets the packets of node B in
+type reverseConn struct {
+	Node     cipher.PubKey
+	App      cipher.PubKey
+	FromApp  cipher.PubKey
+	FromNode cipher.PubKey
+	// public endpoint of the transport of node A, set by the discovery
+	Address string
+}
+
+// run on manager, conn is udp conn from node A
+func (req *reverseConn) Execute(f *MessengerFactory, conn *Connection) (r resp, err error) {
+	if req.FromNode != conn.GetKey() {
+		err = fmt.Errorf("reverse conn from node %x on conn of %x", req.FromNode, conn.GetKey())
+		return
+	}
+	c, ok := f.GetConnection(req.Node)
+	if !ok {
+		conn.GetContextLogger().Debugf("reverse conn node %x not exists", req.Node)
+		return
+	}
+	req.Address = conn.GetRemoteAddr().String()
+	err = c.writeOP(OP_REVERSE_CONN|RESP_PREFIX, req)
+	return
+}
+
+// run on node B, from manager
+func (req *reverseConn) Run(conn *Connection) (err error) {
+	tr, ok := conn.factory.getPendingTransport(req.FromNode, req.FromApp, req.App)
+	if !ok {
+		conn.GetContextLogger().Debugf("reverse conn tr %x not found", req.FromApp)
+		return
+	}
+	err = tr.dialBack(req.Address)
+	return
+}
+
+func pendingTransportKey(fromNode, fromApp, app cipher.PubKey) string {
+	return fromNode.Hex() + fromApp.Hex() + app.Hex()
+}
+
+// transports of node B waiting for the conn ack of node A
+func (f *MessengerFactory) setPendingTransport(tr *Transport) {
+	fromApp, app := tr.routeApps()
+	f.pendingTransports.Store(pendingTransportKey(tr.FromNode, fromApp, app), tr)
+}
+
+func (f *MessengerFactory) deletePendingTransport(tr *Transport) {
+	fromApp, app := tr.routeApps()
+	key := pendingTransportKey(tr.FromNode, fromApp, app)
+	if v, ok := f.pendingTransports.Load(key); ok && v == tr {
+		f.pendingTransports.Delete(key)
+	}
+}
+
+func (f *MessengerFactory) getPendingTransport(fromNode, fromApp, app cipher.PubKey) (tr *Transport, ok bool) {
+	v, ok := f.pendingTransports.Load(pendingTransportKey(fromNode, fromApp, app))
+	if !ok {
+		return
+	}
+	tr, ok = v.(*Transport)
+	return
+}
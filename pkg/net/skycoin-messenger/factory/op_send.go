@@ -12,6 +12,11 @@ func init() {
 			return new(send)
 		},
 	}
+	ops[OP_SEND_ACKED] = &sync.Pool{
+		New: func() interface{} {
+			return new(send)
+		},
+	}
 }
 
 type send struct {
@@ -27,12 +32,14 @@ func (send *send) RawExecute(f *MessengerFactory, conn *Connection, m []byte) (r
 	f.regConnectionsMutex.RUnlock()
 	if !ok {
 		conn.GetContextLogger().Infof("Key %s not found", key.Hex())
+		f.countError(func(e *ServerErrors) { e.UnknownKey++ })
 		return
 	}
-	err = c.Write(m)
+	err = f.forward(conn, c, m)
 	if err != nil {
 		conn.GetContextLogger().Errorf("forward to Key %s err %v", key.Hex(), err)
 		c.GetContextLogger().Errorf("write %x err %v", m, err)
+		f.countError(func(e *ServerErrors) { e.WriteFailed++ })
 		c.Close()
 	}
 	return
@@ -0,0 +1,262 @@
+package factory
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+// PathCost weighs the terms of the cost of a path, the transports take the paths of the lowest
+// cost. An app sends its own weights with its setup, node A uses the weights of SetPathCost else
+type PathCost struct {
+	// per hop of the path, a path of this tree has one
+	Hops uint32 `json:",omitempty" wire:"1"`
+	// per 100ms the setups through the discovery took lately, pathLatencyUnknown for a discovery
+	// no setup went through
+	Latency uint32 `json:",omitempty" wire:"2"`
+	// per transport of node A through the discovery, which shares its bandwidth with them
+	Load uint32 `json:",omitempty" wire:"3"`
+	// taken off times the share of the setups through the discovery that reached their app
+	Reputation uint32 `json:",omitempty" wire:"4"`
+}
+
+var DefaultPathCost = PathCost{Hops: 10, Latency: 1, Load: 2, Reputation: 10}
+
+// latency of a discovery no setup went through lately
+const pathLatencyUnknown = time.Second
+
+// Path is a way a transport of node A to an app can go. The routes of this tree have a single
+// hop, so a path is the discovery the transport is set up through
+type Path struct {
+	Discovery cipher.PubKey
+	Node      cipher.PubKey
+	App       cipher.PubKey
+	// the discovery reached the app lately, Setup is how long the setup took then
+	Reached bool
+	Setup   time.Duration
+	// the last transport to the node went through the discovery and no setup failed since
+	Preferred bool
+	// discoveries between node A and node B, always 1 in this tree
+	Hops int
+	// how long the setups through the discovery took lately to be answered, 0 if none
+	Latency time.Duration
+	// transports of the apps of node A through the discovery
+	Load int
+	// share of the setups through the discovery that reached their app lately, 0.5 if none
+	Reputation float64
+}
+
+// Cost of the path, the lower the better
+func (w PathCost) Cost(p Path) float64 {
+	latency := p.Latency
+	if latency <= 0 {
+		latency = pathLatencyUnknown
+	}
+	return float64(w.Hops)*float64(p.Hops) +
+		float64(w.Latency)*latency.Seconds()*10 +
+		float64(w.Load)*float64(p.Load) -
+		float64(w.Reputation)*p.Reputation
+}
+
+// ParsePathCost reads the weights of a config like "hops=10,latency=1,load=2,reputation=10",
+// the weights left out are the ones of DefaultPathCost
+func ParsePathCost(config string) (w PathCost, err error) {
+	w = DefaultPathCost
+	for _, kv := range strings.Split(config, ",") {
+		kv = strings.TrimSpace(kv)
+		if len(kv) == 0 {
+			continue
+		}
+		i := strings.Index(kv, "=")
+		if i < 0 {
+			err = fmt.Errorf("path cost %q: want name=weight", kv)
+			return
+		}
+		var v uint64
+		v, err = strconv.ParseUint(strings.TrimSpace(kv[i+1:]), 10, 32)
+		if err != nil {
+			err = fmt.Errorf("path cost %q: %v", kv, err)
+			return
+		}
+		switch strings.TrimSpace(kv[:i]) {
+		case "hops":
+			w.Hops = uint32(v)
+		case "latency":
+			w.Latency = uint32(v)
+		case "load":
+			w.Load = uint32(v)
+		case "reputation":
+			w.Reputation = uint32(v)
+		default:
+			err = fmt.Errorf("path cost %q: the weights are hops, latency, load and reputation", kv)
+			return
+		}
+	}
+	return
+}
+
+// rankPaths returns the indexes of the paths of the lowest cost, the transport is set up
+// through all of them at once and takes the first that reaches the app
+func rankPaths(paths []Path, w PathCost) (chosen []int) {
+	var best float64
+	for i, p := range paths {
+		cost := w.Cost(p)
+		if len(chosen) == 0 || cost < best {
+			chosen, best = []int{i}, cost
+		} else if cost == best {
+			chosen = append(chosen, i)
+		}
+	}
+	return
+}
+
+// SetPathCost ranks the paths of the transports of the apps by the weights, nil to set them up
+// through all the discoveries at once. The weights an app sends with its setup are used over them
+func (f *MessengerFactory) SetPathCost(w *PathCost) {
+	f.fieldsMutex.Lock()
+	f.pathCost = w
+	f.fieldsMutex.Unlock()
+}
+
+func (f *MessengerFactory) getPathCost() (w *PathCost) {
+	f.fieldsMutex.RLock()
+	w = f.pathCost
+	f.fieldsMutex.RUnlock()
+	return
+}
+
+// choosePaths returns the discoveries of node A the transport of the setup is set up through, the
+// answer of the route cache to give the app when none is and the decision to record
+func (f *MessengerFactory) choosePaths(req *appConn, discoveries []*Connection) (chosen []*Connection, refused *route, d RouteDecision) {
+	d = RouteDecision{
+		ID:      req.SetupID,
+		Time:    time.Now().Unix(),
+		Node:    req.Node.Hex(),
+		App:     req.App.Hex(),
+		Cost:    req.Cost,
+		AppCost: req.Cost != nil,
+		Draw:    rand.Float64(),
+	}
+	policy := f.getRoutingPolicy()
+	switch {
+	case policy != nil:
+		d.Policy = true
+		d.Cost, d.AppCost = nil, false
+	case d.Cost == nil:
+		d.Cost = f.getPathCost()
+	}
+	preferred, hasPreferred := f.getPeerStore().preferred(req.Node)
+	routes := make([]route, len(discoveries))
+	for i, connection := range discoveries {
+		key := connection.GetTargetKey()
+		r, ok := f.routes.get(routeKey{discovery: key, node: req.Node, app: req.App})
+		routes[i] = r
+		latency, reputation := f.paths.get(key)
+		var setup time.Duration
+		if ok && !r.failed {
+			setup = r.setup
+		}
+		d.Candidates = append(d.Candidates, RouteCandidate{
+			Discovery:  key.Hex(),
+			Cached:     ok && !r.failed,
+			Refused:    ok && r.failed,
+			Preferred:  hasPreferred && key == preferred,
+			Setup:      int64(setup / time.Millisecond),
+			Hops:       1,
+			Latency:    int64(latency / time.Millisecond),
+			Load:       f.discoveryLoad(key),
+			Reputation: reputation,
+		})
+	}
+	if policy != nil {
+		scorePaths(policy, &d, req.Node, req.App)
+	}
+	indexes, refusedIndex := decideRoute(&d, nil)
+	d.Chosen = []string{}
+	for _, i := range indexes {
+		chosen = append(chosen, discoveries[i])
+		d.Chosen = append(d.Chosen, d.Candidates[i].Discovery)
+	}
+	if refusedIndex >= 0 {
+		refused = &routes[refusedIndex]
+		if len(chosen) == 0 {
+			d.Refused = d.Candidates[refusedIndex].Discovery
+		}
+	}
+	return
+}
+
+// discoveryLoad returns the transports of the apps of node A through the discovery
+func (f *MessengerFactory) discoveryLoad(discovery cipher.PubKey) (n int) {
+	f.ForEachAcceptedConnection(func(key cipher.PubKey, conn *Connection) {
+		conn.ForEachTransport(func(t *Transport) {
+			if t.IsClientSide() && t.getDiscoveryKey() == discovery {
+				n++
+			}
+		})
+	})
+	return
+}
+
+const (
+	// the counts of a discovery are halved above this many setups, so that the last ones weigh more
+	pathStatsWindow = 100
+	// weight of the last setup in the latency of a discovery
+	pathLatencyWeight = 0.25
+)
+
+// pathStats keep of each discovery the setups through it that reached their app or failed, and
+// how long they took to be answered
+type pathStats struct {
+	discoveries map[cipher.PubKey]*pathStat
+	sync.Mutex
+}
+
+type pathStat struct {
+	reached, failed float64
+	latency         time.Duration
+}
+
+func (s *pathStats) add(discovery cipher.PubKey, reached bool, setup time.Duration) {
+	s.Lock()
+	defer s.Unlock()
+	if s.discoveries == nil {
+		s.discoveries = make(map[cipher.PubKey]*pathStat)
+	}
+	st, ok := s.discoveries[discovery]
+	if !ok {
+		st = &pathStat{}
+		s.discoveries[discovery] = st
+	}
+	if !reached {
+		st.failed++
+	} else {
+		st.reached++
+		if st.latency == 0 {
+			st.latency = setup
+		} else {
+			st.latency += time.Duration(pathLatencyWeight * float64(setup-st.latency))
+		}
+	}
+	if st.reached+st.failed > pathStatsWindow {
+		st.reached /= 2
+		st.failed /= 2
+	}
+}
+
+// get returns how long the setups through the discovery took to be answered, 0 if none reached
+// its app, and the share of them that reached their app, 0.5 for a discovery without any
+func (s *pathStats) get(discovery cipher.PubKey) (latency time.Duration, reputation float64) {
+	s.Lock()
+	defer s.Unlock()
+	st, ok := s.discoveries[discovery]
+	if !ok {
+		return 0, 0.5
+	}
+	return st.latency, (st.reached + 1) / (st.reached + st.failed + 2)
+}
@@ -0,0 +1,63 @@
+package factory
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRankPaths(t *testing.T) {
+	paths := []Path{
+		{Hops: 1, Latency: 900 * time.Millisecond, Load: 0, Reputation: 0.5},
+		{Hops: 2, Latency: 100 * time.Millisecond, Load: 5, Reputation: 0.5},
+		{Hops: 2, Latency: 500 * time.Millisecond, Load: 1, Reputation: 0.95},
+		// no setup went through it, its latency counts as pathLatencyUnknown
+		{Hops: 3, Load: 0, Reputation: 0.2},
+	}
+	for _, c := range []struct {
+		name   string
+		w      PathCost
+		chosen []int
+	}{
+		{name: "hops", w: PathCost{Hops: 1}, chosen: []int{0}},
+		{name: "latency", w: PathCost{Latency: 1}, chosen: []int{1}},
+		{name: "load, a tie", w: PathCost{Load: 1}, chosen: []int{0, 3}},
+		{name: "reputation", w: PathCost{Reputation: 1}, chosen: []int{2}},
+		// 14, 26, 17.5 and 38
+		{name: "default", w: DefaultPathCost, chosen: []int{0}},
+		// 9, 11, 7 and 10
+		{name: "latency and load", w: PathCost{Latency: 1, Load: 2}, chosen: []int{2}},
+		{name: "no weights, all at once", w: PathCost{}, chosen: []int{0, 1, 2, 3}},
+	} {
+		if chosen := rankPaths(paths, c.w); !reflect.DeepEqual(chosen, c.chosen) {
+			t.Errorf("%s: chosen %v, want %v", c.name, chosen, c.chosen)
+		}
+	}
+	if cost := (PathCost{Latency: 1}).Cost(Path{}); cost != 10 {
+		t.Fatalf("cost of an unknown latency %g", cost)
+	}
+	if chosen := rankPaths(nil, DefaultPathCost); len(chosen) != 0 {
+		t.Fatalf("chosen of no paths %v", chosen)
+	}
+}
+
+func TestParsePathCost(t *testing.T) {
+	for _, c := range []struct {
+		config string
+		w      PathCost
+	}{
+		{"", DefaultPathCost},
+		{"hops=1, latency=0", PathCost{Hops: 1, Latency: 0, Load: 2, Reputation: 10}},
+		{"load=7,reputation=3,", PathCost{Hops: 10, Latency: 1, Load: 7, Reputation: 3}},
+	} {
+		w, err := ParsePathCost(c.config)
+		if err != nil || w != c.w {
+			t.Errorf("%q: %+v %v, want %+v", c.config, w, err, c.w)
+		}
+	}
+	for _, config := range []string{"hops", "hops=-1", "load=x", "speed=1", "latency=4294967296"} {
+		if w, err := ParsePathCost(config); err == nil {
+			t.Errorf("%q: parsed %+v", config, w)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package factory
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+// PeerLists are the nodes the transports of the apps are allowed with, maintained by the manager
+type PeerLists struct {
+	// increased by the manager with every change, the lists of an older version are refused
+	Version int64 `json:"version"`
+	// hex keys of the only nodes allowed, any node if empty
+	Allow []string `json:"allow,omitempty"`
+	// hex keys of the nodes refused, even if allowed
+	Deny []string `json:"deny,omitempty"`
+}
+
+type peerFilter struct {
+	allow map[cipher.PubKey]bool
+	deny  map[cipher.PubKey]bool
+}
+
+func peerKeys(hexKeys []string) (keys map[cipher.PubKey]bool, err error) {
+	keys = make(map[cipher.PubKey]bool, len(hexKeys))
+	for _, h := range hexKeys {
+		// PubKeyFromHex panics on a key of the wrong length
+		if len(h) != 2*len(cipher.PubKey{}) {
+			return nil, fmt.Errorf("invalid node key %q", h)
+		}
+		k, e := cipher.PubKeyFromHex(h)
+		if e != nil {
+			return nil, fmt.Errorf("invalid node key %q: %v", h, e)
+		}
+		keys[k] = true
+	}
+	return
+}
+
+// Check returns an error if a key of the lists is invalid
+func (l PeerLists) Check() (err error) {
+	_, err = l.filter()
+	return
+}
+
+func (l PeerLists) filter() (f *peerFilter, err error) {
+	allow, err := peerKeys(l.Allow)
+	if err != nil {
+		return
+	}
+	deny, err := peerKeys(l.Deny)
+	if err != nil {
+		return
+	}
+	f = &peerFilter{allow: allow, deny: deny}
+	return
+}
+
+func (p *peerFilter) allowed(node cipher.PubKey) bool {
+	if p == nil {
+		return true
+	}
+	if p.deny[node] {
+		return false
+	}
+	return len(p.allow) < 1 || p.allow[node]
+}
+
+// SetPeerLists replaces the nodes the transports of the apps are allowed with, the
+// transports open with the nodes no longer allowed are closed
+func (f *MessengerFactory) SetPeerLists(l PeerLists) (err error) {
+	p, err := l.filter()
+	if err != nil {
+		return
+	}
+	f.fieldsMutex.Lock()
+	f.peerLists = l
+	f.peerFilter = p
+	f.fieldsMutex.Unlock()
+
+	var closing []*Transport
+	f.ForEachAcceptedConnection(func(key cipher.PubKey, conn *Connection) {
+		conn.ForEachTransport(func(t *Transport) {
+			peer := t.ToNode
+			if !t.IsClientSide() {
+				peer = t.FromNode
+			}
+			if !p.allowed(peer) {
+				closing = append(closing, t)
+			}
+		})
+	})
+	for _, t := range closing {
+		log.Infof("close transport %x -> %x refused by the peer lists version %d", t.FromNode, t.ToNode, l.Version)
+		t.CloseWithReason(ClosePolicy)
+	}
+	return
+}
+
+// GetPeerLists returns the lists set by SetPeerLists
+func (f *MessengerFactory) GetPeerLists() (l PeerLists) {
+	f.fieldsMutex.RLock()
+	l = f.peerLists
+	f.fieldsMutex.RUnlock()
+	return
+}
+
+// SetOnPeerRefused calls fn with the node and the local app of every transport the peer lists
+// refuse to an app of the node
+func (f *MessengerFactory) SetOnPeerRefused(fn func(node, app cipher.PubKey)) {
+	f.fieldsMutex.Lock()
+	f.onPeerRefused = fn
+	f.fieldsMutex.Unlock()
+}
+
+func (f *MessengerFactory) peerRefused(node, app cipher.PubKey) {
+	f.fieldsMutex.RLock()
+	fn := f.onPeerRefused
+	f.fieldsMutex.RUnlock()
+	if fn != nil {
+		go fn(node, app)
+	}
+}
+
+func (f *MessengerFactory) peerAllowed(node cipher.PubKey) (ok bool) {
+	f.fieldsMutex.RLock()
+	ok = f.peerFilter.allowed(node)
+	f.fieldsMutex.RUnlock()
+	return
+}
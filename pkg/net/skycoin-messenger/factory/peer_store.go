@@ -0,0 +1,229 @@
+package factory
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+const (
+	peerStoreSaveInterval = time.Minute
+	// the discovery of the last transport to a node is asked alone for this long after it
+	// succeeded, if no transport to the node failed since
+	peerPreferTTL = 24 * time.Hour
+)
+
+// PeerRecord is what node A learned of the transports to a node, kept across restarts
+type PeerRecord struct {
+	Node string `json:"node"`
+	// discovery the last transport was set up through and the address node B answered from
+	Discovery string `json:"discovery,omitempty"`
+	Address   string `json:"address,omitempty"`
+	// unix time of the last transport set up and of the last setup that failed
+	LastSuccess int64 `json:"last_success,omitempty"`
+	LastFailure int64 `json:"last_failure,omitempty"`
+	// setups that failed since the last one that succeeded
+	Failures int `json:"failures,omitempty"`
+	// smallest round trip time of the last transport in milliseconds, 0 if not measured
+	RTT float64 `json:"rtt_ms,omitempty"`
+	// transports set up
+	Transports int64 `json:"transports"`
+}
+
+type peerStore struct {
+	path     string
+	peers    map[string]*PeerRecord
+	saved    time.Time
+	modified bool
+	sync.Mutex
+}
+
+func newPeerStore(path string) (s *peerStore, err error) {
+	s = &peerStore{path: path, peers: make(map[string]*PeerRecord), saved: time.Now()}
+	if len(path) < 1 {
+		return
+	}
+	d, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	var peers []*PeerRecord
+	err = json.Unmarshal(d, &peers)
+	if err != nil {
+		return
+	}
+	for _, p := range peers {
+		s.peers[p.Node] = p
+	}
+	return
+}
+
+// SetPeerStorePath keeps the peers in the json file at path, empty to keep them in memory
+func (f *MessengerFactory) SetPeerStorePath(path string) (err error) {
+	s, err := newPeerStore(path)
+	if err != nil {
+		return
+	}
+	f.fieldsMutex.Lock()
+	f.peers = s
+	f.fieldsMutex.Unlock()
+	return
+}
+
+func (f *MessengerFactory) getPeerStore() (s *peerStore) {
+	f.fieldsMutex.RLock()
+	s = f.peers
+	f.fieldsMutex.RUnlock()
+	return
+}
+
+// GetPeers returns the nodes the transports of the apps were set up or failed with, the
+// most recently reached first
+func (f *MessengerFactory) GetPeers() (peers []PeerRecord) {
+	s := f.getPeerStore()
+	if s == nil {
+		return
+	}
+	s.Lock()
+	for _, p := range s.peers {
+		peers = append(peers, *p)
+	}
+	s.Unlock()
+	sort.Slice(peers, func(i, j int) bool {
+		if peers[i].LastSuccess != peers[j].LastSuccess {
+			return peers[i].LastSuccess > peers[j].LastSuccess
+		}
+		return peers[i].Node < peers[j].Node
+	})
+	return
+}
+
+// SavePeers writes the peers to the peer store path
+func (f *MessengerFactory) SavePeers() {
+	s := f.getPeerStore()
+	if s == nil {
+		return
+	}
+	s.Lock()
+	s.save()
+	s.Unlock()
+}
+
+// update changes the record of the node, the records are saved once in a while
+func (s *peerStore) update(node cipher.PubKey, fn func(p *PeerRecord)) {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	key := node.Hex()
+	p, ok := s.peers[key]
+	if !ok {
+		p = &PeerRecord{Node: key}
+		s.peers[key] = p
+	}
+	fn(p)
+	s.modified = true
+	if time.Since(s.saved) >= peerStoreSaveInterval {
+		s.save()
+	}
+}
+
+func (s *peerStore) succeeded(node, discovery cipher.PubKey, address string) {
+	s.update(node, func(p *PeerRecord) {
+		p.Discovery = discovery.Hex()
+		p.Address = address
+		p.LastSuccess = time.Now().Unix()
+		p.Failures = 0
+		p.Transports++
+	})
+}
+
+func (s *peerStore) failed(node cipher.PubKey) {
+	s.update(node, func(p *PeerRecord) {
+		p.LastFailure = time.Now().Unix()
+		p.Failures++
+	})
+}
+
+func (s *peerStore) measured(node cipher.PubKey, rtt time.Duration) {
+	if rtt <= 0 {
+		return
+	}
+	s.update(node, func(p *PeerRecord) {
+		p.RTT = float64(rtt) / float64(time.Millisecond)
+	})
+}
+
+// preferred returns the discovery of the last transport to the node, if it succeeded lately
+// and no setup failed since
+func (s *peerStore) preferred(node cipher.PubKey) (discovery cipher.PubKey, ok bool) {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	p, found := s.peers[node.Hex()]
+	var r PeerRecord
+	if found {
+		r = *p
+	}
+	s.Unlock()
+	if !found || r.Failures > 0 || len(r.Discovery) == 0 ||
+		time.Since(time.Unix(r.LastSuccess, 0)) > peerPreferTTL {
+		return
+	}
+	discovery, err := cipher.PubKeyFromHex(r.Discovery)
+	ok = err == nil
+	return
+}
+
+func (s *peerStore) save() {
+	s.saved = time.Now()
+	if !s.modified || len(s.path) < 1 {
+		return
+	}
+	s.modified = false
+	peers := make([]*PeerRecord, 0, len(s.peers))
+	for _, p := range s.peers {
+		peers = append(peers, p)
+	}
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].Node < peers[j].Node
+	})
+	d, err := json.Marshal(peers)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(s.path), 0700)
+	}
+	if err == nil {
+		err = ioutil.WriteFile(s.path, d, 0600)
+	}
+	if err != nil {
+		log.Errorf("save peers: %v", err)
+	}
+}
+
+// rttGetter is implemented by the udp connections
+type rttGetter interface {
+	GetRTT() time.Duration
+}
+
+// getRTT returns the smallest recent round trip time of the connection, 0 if unknown
+func (c *Connection) getRTT() time.Duration {
+	if c.Connection == nil {
+		return 0
+	}
+	if r, ok := c.Connection.Connection.(rttGetter); ok {
+		return r.GetRTT()
+	}
+	return 0
+}
@@ -0,0 +1,102 @@
+package factory
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+func TestPeerStorePreferred(t *testing.T) {
+	node, discovery := cipher.PubKey([33]byte{0x02, 1}), cipher.PubKey([33]byte{0x03, 1})
+	old := time.Now().Add(-peerPreferTTL - time.Hour).Unix()
+	for _, c := range []struct {
+		name      string
+		record    func(s *peerStore)
+		preferred bool
+	}{
+		{name: "unknown", record: func(s *peerStore) {}},
+		{name: "succeeded", record: func(s *peerStore) { s.succeeded(node, discovery, "127.0.0.1:1") }, preferred: true},
+		{name: "failed since", record: func(s *peerStore) {
+			s.succeeded(node, discovery, "127.0.0.1:1")
+			s.failed(node)
+		}},
+		{name: "succeeded again", record: func(s *peerStore) {
+			s.failed(node)
+			s.succeeded(node, discovery, "127.0.0.1:1")
+		}, preferred: true},
+		{name: "too old", record: func(s *peerStore) {
+			s.succeeded(node, discovery, "127.0.0.1:1")
+			s.peers[node.Hex()].LastSuccess = old
+		}},
+	} {
+		s, err := newPeerStore("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.record(s)
+		got, ok := s.preferred(node)
+		if ok != c.preferred || (ok && got != discovery) {
+			t.Errorf("%s: preferred %x %v, want %v", c.name, got, ok, c.preferred)
+		}
+	}
+	var s *peerStore
+	s.failed(node)
+	if _, ok := s.preferred(node); ok {
+		t.Error("preferred without a store")
+	}
+}
+
+func TestPeerStoreSaved(t *testing.T) {
+	dir, err := ioutil.TempDir("", "peers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "node", "peers.json")
+	s, err := newPeerStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b, discovery := cipher.PubKey([33]byte{0x02, 1}), cipher.PubKey([33]byte{0x02, 2}), cipher.PubKey([33]byte{0x03, 1})
+	s.succeeded(a, discovery, "127.0.0.1:1")
+	s.measured(a, 20*time.Millisecond)
+	s.measured(a, 0)
+	s.failed(b)
+	s.failed(b)
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("saved before the interval: %v", err)
+	}
+	f := NewMessengerFactory()
+	f.peers = s
+	f.SavePeers()
+
+	// a restarted node reads the records back
+	if err := f.SetPeerStorePath(path); err != nil {
+		t.Fatal(err)
+	}
+	peers := f.GetPeers()
+	if len(peers) != 2 {
+		t.Fatalf("peers %#v", peers)
+	}
+	if p := peers[0]; p.Node != a.Hex() || p.Discovery != discovery.Hex() || p.Address != "127.0.0.1:1" ||
+		p.Transports != 1 || p.RTT != 20 || p.Failures != 0 {
+		t.Errorf("reached peer %#v", p)
+	}
+	if p := peers[1]; p.Node != b.Hex() || p.Failures != 2 || p.LastFailure == 0 || p.Transports != 0 {
+		t.Errorf("failed peer %#v", p)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.SetPeerStorePath(path); err == nil {
+		t.Error("invalid store loaded")
+	}
+	if err := f.SetPeerStorePath(filepath.Join(dir, "missing.json")); err != nil || len(f.GetPeers()) != 0 {
+		t.Errorf("missing store: %v %#v", err, f.GetPeers())
+	}
+}
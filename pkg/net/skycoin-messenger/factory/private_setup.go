@@ -0,0 +1,121 @@
+package factory
+
+import (
+	"crypto/aes"
+	cipher2 "crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/skycoin/skycoin/src/cipher"
+	"github.com/skycoin/skywire/pkg/net/util"
+	"github.com/skycoin/skywire/pkg/net/wire"
+)
+
+// PrivateSetupVersion is the first message schema of the nodes that read private setups
+const PrivateSetupVersion = 2
+
+// In a private setup node A seals the apps of the transport for node B, the discovery
+// forwarding the setup only learns the two nodes. Random route ids stand in for the apps
+// in the messages through the discovery.
+
+// sealedApps are the apps of a private setup, only node B can read them
+type sealedApps struct {
+	FromApp cipher.PubKey `wire:"1"`
+	App     cipher.PubKey `wire:"2"`
+}
+
+// privateRoute are the apps of a private setup of node A, by the route id of its app
+type privateRoute struct {
+	fromApp, app cipher.PubKey
+}
+
+var errSealedApps = errors.New("sealed apps can not be opened")
+
+// SetPrivateSetups seals the apps of all transports of the node, not only of the apps asking for it
+func (f *MessengerFactory) SetPrivateSetups(private bool) {
+	f.fieldsMutex.Lock()
+	f.privateSetups = private
+	f.fieldsMutex.Unlock()
+}
+
+func (f *MessengerFactory) privateSetupsEnabled() (private bool) {
+	f.fieldsMutex.RLock()
+	private = f.privateSetups
+	f.fieldsMutex.RUnlock()
+	return
+}
+
+func newRouteID() (id cipher.PubKey) {
+	rand.Read(id[:])
+	return
+}
+
+// sealCipher returns the cipher of the sealed apps between the node of sc and the node of key
+func sealCipher(sc *SeedConfig, key cipher.PubKey) (aead cipher2.AEAD, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("seal key recovered err %v", e)
+		}
+	}()
+	if sc == nil {
+		err = errors.New("GetDefaultSeedConfig is nil")
+		return
+	}
+	ecdh := cipher.ECDH(key, sc.secKey)
+	defer util.Wipe(ecdh)
+	hash := cipher.SumSHA256(ecdh)
+	defer util.Wipe(hash[:])
+	block, err := aes.NewCipher(hash[:])
+	if err != nil {
+		return
+	}
+	return cipher2.NewGCM(block)
+}
+
+// sealApps seals the apps for node, bound to the route ids standing in for them
+func sealApps(sc *SeedConfig, node cipher.PubKey, apps sealedApps, routeFromApp, routeApp cipher.PubKey) (sealed []byte, err error) {
+	aead, err := sealCipher(sc, node)
+	if err != nil {
+		return
+	}
+	data, err := wire.Marshal(&apps)
+	if err != nil {
+		return
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return
+	}
+	sealed = aead.Seal(nonce, nonce, data, append(routeFromApp[:], routeApp[:]...))
+	return
+}
+
+// openApps opens the apps sealed by fromNode
+func openApps(sc *SeedConfig, fromNode cipher.PubKey, sealed []byte, routeFromApp, routeApp cipher.PubKey) (apps sealedApps, err error) {
+	aead, err := sealCipher(sc, fromNode)
+	if err != nil {
+		return
+	}
+	if len(sealed) < aead.NonceSize() {
+		err = errSealedApps
+		return
+	}
+	nonce, sealed := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	data, err := aead.Open(nil, nonce, sealed, append(routeFromApp[:], routeApp[:]...))
+	if err != nil {
+		err = errSealedApps
+		return
+	}
+	err = wire.Unmarshal(data, &apps)
+	return
+}
+
+// routeApps returns the ids of the apps of the transport in the messages through the discovery
+func (t *Transport) routeApps() (fromApp, app cipher.PubKey) {
+	if t.routeFromApp != EMPTY_PUBLIC_KEY {
+		return t.routeFromApp, t.routeApp
+	}
+	return t.FromApp, t.ToApp
+}
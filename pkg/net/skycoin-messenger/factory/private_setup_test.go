@@ -0,0 +1,52 @@
+package factory_test
+
+import (
+	"testing"
+
+	"github.com/skycoin/skycoin/src/cipher"
+	"github.com/skycoin/skywire/pkg/net/skycoin-messenger/factory"
+	"github.com/skycoin/skywire/pkg/node/nodetest"
+)
+
+func TestPrivateSetup(t *testing.T) {
+	e := nodetest.NewEnv(t, 1)
+	defer e.Close()
+	d := e.Discoveries[0]
+	a, b := e.StartNode("a"), e.StartNode("b")
+	server := e.ConnectApp(b, "server")
+	server.Offer(e.Echo(), "private")
+
+	// records returns the apps of the transports from node A to node B the discovery paired
+	records := func() (apps []cipher.PubKey) {
+		d.ForEachTransportRecord(func(r factory.TransportRecord) {
+			if r.FromNode == a.Key && r.ToNode == b.Key {
+				apps = append(apps, r.FromApp, r.ToApp)
+			}
+		})
+		return
+	}
+
+	// the app asks for it, then the node seals the setups of all its apps
+	for i, name := range []string{"asking", "any"} {
+		client := e.ConnectApp(a, name)
+		var resp factory.AppConnResp
+		if i == 0 {
+			resp = client.ConnectWithOptions(b.Key, server.GetKey(), e.DiscoveryKey(0), factory.AppDialOptions{Private: true})
+		} else {
+			a.SetPrivateSetups(true)
+			resp = client.Connect(b.Key, server.GetKey(), e.DiscoveryKey(0))
+		}
+		nodetest.Ping(t, resp.Port).Close()
+
+		apps := records()
+		if len(apps) != 2*(i+1) {
+			t.Fatalf("%s: apps at the discovery %x", name, apps)
+		}
+		// the discovery only learns the route ids standing in for the apps
+		for _, app := range apps {
+			if app == client.GetKey() || app == server.GetKey() {
+				t.Fatalf("%s: app %x at the discovery", name, app)
+			}
+		}
+	}
+}
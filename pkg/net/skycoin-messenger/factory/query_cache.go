@@ -0,0 +1,159 @@
+package factory
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+const (
+	// the answers of a discovery a connection keeps to ask for them again with their etag
+	maxCachedQueries = 64
+	// a query not answered by then is forgotten
+	queryAnswerTimeout = time.Minute
+)
+
+// queryCache keeps the last answer of the discovery to each query of a connection. The query
+// is sent again with the etag of the answer, and the discovery answers NotModified instead of
+// the same result, so polling the unchanged services costs a few bytes
+type queryCache struct {
+	answers map[string]*cachedAnswer
+	sent    map[uint32]sentQuery
+	sync.Mutex
+}
+
+type cachedAnswer struct {
+	etag     string
+	services []*ServiceInfo
+	nodes    *AttrNodesInfo
+	used     time.Time
+}
+
+type sentQuery struct {
+	key string
+	// the answer whose etag was sent, kept for the answer even if it is evicted meanwhile
+	answer *cachedAnswer
+	time   time.Time
+}
+
+// resultETag returns the etag of a result of a query, the hash of its JSON
+func resultETag(result interface{}) string {
+	js, err := json.Marshal(result)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(js)
+	return hex.EncodeToString(sum[:16])
+}
+
+func keysQuery(keys []cipher.PubKey) string {
+	hexes := make([]string, len(keys))
+	for i, k := range keys {
+		hexes[i] = k.Hex()
+	}
+	return "keys:" + strings.Join(hexes, ",")
+}
+
+func attrsQuery(pages, limit int, attrs []string) string {
+	return fmt.Sprintf("attrs:%d:%d:%s", pages, limit, strings.Join(attrs, ","))
+}
+
+// send records the query of seq and returns the etag of its last answer, empty if there is none
+func (c *queryCache) send(key string, seq uint32) (etag string) {
+	now := time.Now()
+	c.Lock()
+	defer c.Unlock()
+	if c.sent == nil {
+		c.sent = make(map[uint32]sentQuery)
+	}
+	for s, q := range c.sent {
+		if now.Sub(q.time) > queryAnswerTimeout {
+			delete(c.sent, s)
+		}
+	}
+	answer := c.answers[key]
+	c.sent[seq] = sentQuery{key: key, answer: answer, time: now}
+	if answer == nil {
+		return
+	}
+	return answer.etag
+}
+
+// answered returns the query of seq and the answer whose etag it was sent with
+func (c *queryCache) answered(seq uint32) (q sentQuery, ok bool) {
+	c.Lock()
+	defer c.Unlock()
+	q, ok = c.sent[seq]
+	delete(c.sent, seq)
+	return
+}
+
+// keep keeps the answer to the query of key, evicting the least recently used answer
+func (c *queryCache) keep(key string, answer *cachedAnswer) {
+	answer.used = time.Now()
+	c.Lock()
+	defer c.Unlock()
+	if c.answers == nil {
+		c.answers = make(map[string]*cachedAnswer)
+	}
+	if _, ok := c.answers[key]; !ok && len(c.answers) >= maxCachedQueries {
+		var oldest string
+		for k, v := range c.answers {
+			if len(oldest) == 0 || v.used.Before(c.answers[oldest].used) {
+				oldest = k
+			}
+		}
+		delete(c.answers, oldest)
+	}
+	c.answers[key] = answer
+}
+
+// use marks the answer as used again
+func (c *queryCache) use(answer *cachedAnswer) {
+	c.Lock()
+	answer.used = time.Now()
+	c.Unlock()
+}
+
+// services fills in the result of an answer not modified since the one the query was sent
+// with, and keeps the answers with an etag
+func (c *queryCache) services(resp *QueryResp) {
+	q, ok := c.answered(resp.Seq)
+	if !ok || len(resp.Error) > 0 {
+		return
+	}
+	if resp.NotModified {
+		if q.answer != nil {
+			c.use(q.answer)
+			resp.Result = q.answer.services
+		}
+		return
+	}
+	if len(resp.ETag) > 0 {
+		c.keep(q.key, &cachedAnswer{etag: resp.ETag, services: resp.Result})
+	}
+}
+
+// nodes is services for the queries by attributes
+func (c *queryCache) nodes(resp *QueryByAttrsResp) {
+	q, ok := c.answered(resp.Seq)
+	if !ok || len(resp.Error) > 0 {
+		return
+	}
+	if resp.NotModified {
+		if q.answer != nil {
+			c.use(q.answer)
+			resp.Result = q.answer.nodes
+		}
+		return
+	}
+	if len(resp.ETag) > 0 {
+		c.keep(q.key, &cachedAnswer{etag: resp.ETag, nodes: resp.Result})
+	}
+}
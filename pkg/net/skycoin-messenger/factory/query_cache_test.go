@@ -0,0 +1,106 @@
+package factory
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+func TestQueryCache(t *testing.T) {
+	key := keysQuery([]cipher.PubKey{cipher.PubKey([33]byte{0x02, 1})})
+	first := []*ServiceInfo{{PubKey: cipher.PubKey([33]byte{0x03, 1})}}
+	second := []*ServiceInfo{{PubKey: cipher.PubKey([33]byte{0x03, 2})}}
+	c := &queryCache{}
+	// each query is sent and answered in turn
+	for i, s := range []struct {
+		name string
+		key  string
+		// the etag the query is sent with
+		etag string
+		resp QueryResp
+		// the result once the answer is filled in
+		result []*ServiceInfo
+	}{
+		{name: "first answer", key: key, resp: QueryResp{Result: first, ETag: "a"}, result: first},
+		{name: "not modified", key: key, etag: "a", resp: QueryResp{NotModified: true}, result: first},
+		{name: "other query", key: attrsQuery(1, 5, []string{"x"}), resp: QueryResp{Result: second}, result: second},
+		{name: "changed", key: key, etag: "a", resp: QueryResp{Result: second, ETag: "b"}, result: second},
+		{name: "failed", key: key, etag: "b", resp: QueryResp{Error: "refused"}},
+		{name: "kept after a failure", key: key, etag: "b", resp: QueryResp{NotModified: true}, result: second},
+	} {
+		seq := uint32(i + 1)
+		if etag := c.send(s.key, seq); etag != s.etag {
+			t.Fatalf("%s: sent with etag %q, want %q", s.name, etag, s.etag)
+		}
+		resp := s.resp
+		resp.Seq = seq
+		c.services(&resp)
+		if len(resp.Result) != len(s.result) || (len(s.result) > 0 && resp.Result[0] != s.result[0]) {
+			t.Fatalf("%s: result %v", s.name, resp.Result)
+		}
+	}
+	// an answer to a query never sent is left alone
+	resp := QueryResp{Seq: 99, NotModified: true}
+	c.services(&resp)
+	if resp.Result != nil {
+		t.Fatalf("answer of an unknown query %v", resp.Result)
+	}
+
+	// the least recently used answer is evicted
+	for i := 0; i < maxCachedQueries; i++ {
+		k := "query" + strconv.Itoa(i)
+		c.send(k, uint32(100+i))
+		c.services(&QueryResp{Seq: uint32(100 + i), ETag: "e"})
+	}
+	if etag := c.send(key, 200); etag != "" || len(c.answers) != maxCachedQueries {
+		t.Fatalf("oldest answer kept with %d answers", len(c.answers))
+	}
+}
+
+func TestResultETag(t *testing.T) {
+	a := []*ServiceInfo{{PubKey: cipher.PubKey([33]byte{0x03, 1})}}
+	b := []*ServiceInfo{{PubKey: cipher.PubKey([33]byte{0x03, 2})}}
+	if resultETag(a) != resultETag([]*ServiceInfo{{PubKey: cipher.PubKey([33]byte{0x03, 1})}}) || resultETag(a) == resultETag(b) {
+		t.Fatal("etags of the results")
+	}
+	if keysQuery(nil) == attrsQuery(0, 0, nil) || attrsQuery(1, 5, []string{"a"}) == attrsQuery(2, 5, []string{"a"}) {
+		t.Fatal("queries share a key")
+	}
+}
+
+func TestQueryNotModified(t *testing.T) {
+	f := NewMessengerFactory()
+	result := []*ServiceInfo{{PubKey: cipher.PubKey([33]byte{0x03, 1})}}
+	f.serviceDiscovery.FindServiceAddresses = func(keys []cipher.PubKey, exclude cipher.PubKey) []*ServiceInfo {
+		return result
+	}
+	etag := resultETag(result)
+	conn := newSignedConnection(cipher.PubKey([33]byte{0x02, 1}))
+	for _, c := range []struct {
+		name        string
+		etag        string
+		change      bool
+		notModified bool
+	}{
+		{name: "no etag"},
+		{name: "same result", etag: etag, notModified: true},
+		{name: "other etag", etag: "other"},
+		{name: "result changed", etag: etag, change: true},
+	} {
+		if c.change {
+			result = []*ServiceInfo{{PubKey: cipher.PubKey([33]byte{0x03, 2})}}
+		}
+		r, err := (&query{Seq: 1, ETag: c.etag}).Execute(f, conn)
+		resp, ok := r.(*QueryResp)
+		if err != nil || !ok {
+			t.Fatalf("%s: %#v %v", c.name, r, err)
+		}
+		if resp.NotModified != c.notModified || (len(resp.Result) == 0) != c.notModified || resp.ETag != resultETag(result) {
+			t.Errorf("%s: answered %#v", c.name, resp)
+		}
+	}
+	if s := f.GetQueryStats(); s.NotModified != 1 || s.Served != 4 {
+		t.Errorf("stats %+v", s)
+	}
+}
@@ -0,0 +1,116 @@
+package factory
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+// QueryLimits of the service queries of a node served by a discovery,
+// the zero value serves all queries
+type QueryLimits struct {
+	// queries per second of a node, 0 for no limit
+	Rate float64
+	// queries allowed at once above the rate
+	Burst int
+	// queries of a node per hour, 0 for no quota
+	HourlyQuota int
+}
+
+var (
+	errQueryUnauthenticated = errors.New("query from a connection without a signed key")
+	errQueryRateLimited     = errors.New("query rate limited")
+	errQueryQuotaExceeded   = errors.New("hourly query quota exceeded")
+)
+
+type QueryStats struct {
+	Served          uint64 `json:"served"`
+	Unauthenticated uint64 `json:"unauthenticated"`
+	RateLimited     uint64 `json:"rate_limited"`
+	QuotaExceeded   uint64 `json:"quota_exceeded"`
+	// served queries answered without the result, the node had it already
+	NotModified uint64 `json:"not_modified"`
+}
+
+type queryGuard struct {
+	// accessed atomically, first for their 64-bit alignment on 32-bit platforms
+	served          uint64
+	unauthenticated uint64
+	rateLimited     uint64
+	quotaExceeded   uint64
+	notModified     uint64
+
+	limits  QueryLimits
+	rate    *rateLimiter
+	hour    int64
+	counts  map[cipher.PubKey]int
+	enabled bool
+
+	sync.Mutex
+}
+
+// SetQueryLimits enables the per node limits of the service queries of a discovery
+func (f *MessengerFactory) SetQueryLimits(l QueryLimits) {
+	g := &f.queries
+	g.Lock()
+	g.limits = l
+	g.rate = newRateLimiter(l.Rate, l.Burst)
+	g.counts = make(map[cipher.PubKey]int)
+	g.enabled = true
+	g.Unlock()
+}
+
+func (f *MessengerFactory) GetQueryStats() QueryStats {
+	g := &f.queries
+	return QueryStats{
+		Served:          atomic.LoadUint64(&g.served),
+		Unauthenticated: atomic.LoadUint64(&g.unauthenticated),
+		RateLimited:     atomic.LoadUint64(&g.rateLimited),
+		QuotaExceeded:   atomic.LoadUint64(&g.quotaExceeded),
+		NotModified:     atomic.LoadUint64(&g.notModified),
+	}
+}
+
+func (g *queryGuard) countNotModified() {
+	atomic.AddUint64(&g.notModified, 1)
+}
+
+// check a query of the connection, the key of the node must have been proven
+// by its signature, anonymous connections get a random key per connection
+func (g *queryGuard) check(conn *Connection) (err error) {
+	g.Lock()
+	defer g.Unlock()
+	if !g.enabled {
+		atomic.AddUint64(&g.served, 1)
+		return
+	}
+	if !conn.IsKeySet() || conn.getHandshakeVersion() == anonymousRegVersion {
+		atomic.AddUint64(&g.unauthenticated, 1)
+		err = errQueryUnauthenticated
+		return
+	}
+	key := conn.GetKey()
+	if !g.rate.allow(key.Hex()) {
+		atomic.AddUint64(&g.rateLimited, 1)
+		err = errQueryRateLimited
+		return
+	}
+	if g.limits.HourlyQuota > 0 {
+		hour := time.Now().Unix() / 3600
+		if hour != g.hour {
+			g.hour = hour
+			g.counts = make(map[cipher.PubKey]int)
+		}
+		if g.counts[key] >= g.limits.HourlyQuota {
+			atomic.AddUint64(&g.quotaExceeded, 1)
+			err = errQueryQuotaExceeded
+			return
+		}
+		g.counts[key]++
+	}
+	atomic.AddUint64(&g.served, 1)
+	return
+}
@@ -0,0 +1,94 @@
+package factory
+
+import (
+	"testing"
+
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+func newSignedConnection(key cipher.PubKey) *Connection {
+	conn := newTestConnection()
+	conn.SetKey(key)
+	conn.StoreContext(handshakeVersion, regWithKeyVersion)
+	return conn
+}
+
+func TestQueryGuardUnlimited(t *testing.T) {
+	f := NewMessengerFactory()
+	if err := f.queries.check(newTestConnection()); err != nil {
+		t.Fatalf("a query refused without limits: %v", err)
+	}
+	if s := f.GetQueryStats(); s.Served != 1 {
+		t.Fatalf("stats %+v", s)
+	}
+}
+
+func TestQueryGuardUnauthenticated(t *testing.T) {
+	f := NewMessengerFactory()
+	f.SetQueryLimits(QueryLimits{})
+	if err := f.queries.check(newTestConnection()); err != errQueryUnauthenticated {
+		t.Fatalf("a connection without a key: got %v, want %v", err, errQueryUnauthenticated)
+	}
+	anonymous := newTestConnection()
+	anonymous.SetKey(cipher.PubKey([33]byte{0x01}))
+	anonymous.StoreContext(handshakeVersion, anonymousRegVersion)
+	if err := f.queries.check(anonymous); err != errQueryUnauthenticated {
+		t.Fatalf("an anonymous key: got %v, want %v", err, errQueryUnauthenticated)
+	}
+	if err := f.queries.check(newSignedConnection(cipher.PubKey([33]byte{0x01}))); err != nil {
+		t.Fatalf("a signed key refused: %v", err)
+	}
+	if s := f.GetQueryStats(); s.Unauthenticated != 2 || s.Served != 1 {
+		t.Fatalf("stats %+v", s)
+	}
+}
+
+func TestQueryGuardRate(t *testing.T) {
+	f := NewMessengerFactory()
+	f.SetQueryLimits(QueryLimits{Rate: 1, Burst: 2})
+	a := newSignedConnection(cipher.PubKey([33]byte{0x01}))
+	for i := 0; i < 2; i++ {
+		if err := f.queries.check(a); err != nil {
+			t.Fatalf("query %d: %v", i, err)
+		}
+	}
+	if err := f.queries.check(a); err != errQueryRateLimited {
+		t.Fatalf("got %v, want %v", err, errQueryRateLimited)
+	}
+	// the limit is per node, not per connection
+	if err := f.queries.check(newSignedConnection(cipher.PubKey([33]byte{0x01}))); err != errQueryRateLimited {
+		t.Fatalf("another connection of the node: got %v, want %v", err, errQueryRateLimited)
+	}
+	if err := f.queries.check(newSignedConnection(cipher.PubKey([33]byte{0x02}))); err != nil {
+		t.Fatalf("another node limited: %v", err)
+	}
+	if s := f.GetQueryStats(); s.RateLimited != 2 || s.Served != 3 {
+		t.Fatalf("stats %+v", s)
+	}
+}
+
+func TestQueryGuardHourlyQuota(t *testing.T) {
+	f := NewMessengerFactory()
+	f.SetQueryLimits(QueryLimits{HourlyQuota: 3})
+	a := newSignedConnection(cipher.PubKey([33]byte{0x01}))
+	for i := 0; i < 3; i++ {
+		if err := f.queries.check(a); err != nil {
+			t.Fatalf("query %d: %v", i, err)
+		}
+	}
+	if err := f.queries.check(a); err != errQueryQuotaExceeded {
+		t.Fatalf("got %v, want %v", err, errQueryQuotaExceeded)
+	}
+	if err := f.queries.check(newSignedConnection(cipher.PubKey([33]byte{0x02}))); err != nil {
+		t.Fatalf("the quota of another node used: %v", err)
+	}
+
+	// the next hour the quota is full again
+	f.queries.hour--
+	if err := f.queries.check(a); err != nil {
+		t.Fatalf("the quota not renewed: %v", err)
+	}
+	if s := f.GetQueryStats(); s.QuotaExceeded != 1 || s.Served != 5 {
+		t.Fatalf("stats %+v", s)
+	}
+}
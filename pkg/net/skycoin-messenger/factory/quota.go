@@ -0,0 +1,332 @@
+package factory
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+// Quota limits the data of transports, the zero fields do not limit
+type Quota struct {
+	// bytes per second in each direction, the data above it waits
+	BytesPerSecond int64 `json:"bytes_per_second,omitempty"`
+	// bytes in both directions per calendar month (UTC), the transports are closed above it
+	MonthlyBytes int64 `json:"monthly_bytes,omitempty"`
+}
+
+func (q Quota) limited() bool {
+	return q.BytesPerSecond > 0 || q.MonthlyBytes > 0
+}
+
+// QuotaConfig limits the data of the transports of the apps of a node
+type QuotaConfig struct {
+	// of all the transports of an app together, by the hex key of the app, "*" for the apps not listed
+	Apps map[string]Quota `json:"apps,omitempty"`
+	// of each transport, the monthly bytes count for the lifetime of the transport
+	Transport Quota `json:"transport,omitempty"`
+	// file keeping the monthly usage of the apps across restarts, in memory only if empty
+	UsagePath string `json:"usage_path,omitempty"`
+}
+
+// LoadQuotaConfig reads the quotas from the json file at path, a missing file limits nothing
+func LoadQuotaConfig(path string) (config QuotaConfig, err error) {
+	d, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	err = json.Unmarshal(d, &config)
+	return
+}
+
+const quotaUsageSaveInterval = time.Minute
+
+var errQuotaExceeded = errors.New("monthly quota exceeded")
+
+type quotas struct {
+	config QuotaConfig
+	// pacers shared by the transports of each app
+	apps map[cipher.PubKey]*appQuota
+
+	// usage of the apps in the month, by the hex key of the app
+	month    string
+	usage    map[string]int64
+	saved    time.Time
+	modified bool
+	sync.Mutex
+}
+
+type appQuota struct {
+	quota    Quota
+	up, down *pacer
+}
+
+type quotaUsage struct {
+	Month string           `json:"month"`
+	Apps  map[string]int64 `json:"apps"`
+}
+
+func thisMonth() string {
+	return time.Now().UTC().Format("2006-01")
+}
+
+func newQuotas(config QuotaConfig) (q *quotas, err error) {
+	q = &quotas{
+		config: config,
+		apps:   make(map[cipher.PubKey]*appQuota),
+		month:  thisMonth(),
+		usage:  make(map[string]int64),
+		saved:  time.Now(),
+	}
+	if len(config.UsagePath) < 1 {
+		return
+	}
+	d, err := ioutil.ReadFile(config.UsagePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	var u quotaUsage
+	err = json.Unmarshal(d, &u)
+	if err != nil {
+		return
+	}
+	if u.Month == q.month && u.Apps != nil {
+		q.usage = u.Apps
+	}
+	return
+}
+
+// quota of the app, the zero quota if it is not limited
+func (q *quotas) appQuota(app cipher.PubKey) Quota {
+	if quota, ok := q.config.Apps[app.Hex()]; ok {
+		return quota
+	}
+	return q.config.Apps["*"]
+}
+
+// forTransport returns the quota of a transport of the local app, nil if nothing limits it
+func (q *quotas) forTransport(app cipher.PubKey) *transportQuota {
+	if q == nil {
+		return nil
+	}
+	quota := q.appQuota(app)
+	if !quota.limited() && !q.config.Transport.limited() {
+		return nil
+	}
+	q.Lock()
+	a, ok := q.apps[app]
+	if !ok {
+		a = &appQuota{
+			quota: quota,
+			up:    newPacer(quota.BytesPerSecond),
+			down:  newPacer(quota.BytesPerSecond),
+		}
+		q.apps[app] = a
+	}
+	q.Unlock()
+	return &transportQuota{
+		quotas: q,
+		app:    app,
+		shared: a,
+		quota:  q.config.Transport,
+		up:     newPacer(q.config.Transport.BytesPerSecond),
+		down:   newPacer(q.config.Transport.BytesPerSecond),
+	}
+}
+
+// exceeded tells whether the app used up its monthly bytes
+func (q *quotas) exceeded(app cipher.PubKey) bool {
+	if q == nil {
+		return false
+	}
+	quota := q.appQuota(app)
+	if quota.MonthlyBytes <= 0 {
+		return false
+	}
+	q.Lock()
+	defer q.Unlock()
+	q.rollover()
+	return q.usage[app.Hex()] >= quota.MonthlyBytes
+}
+
+// add counts n bytes of the app and returns its usage in the month
+func (q *quotas) add(app cipher.PubKey, n int) (used int64) {
+	q.Lock()
+	q.rollover()
+	key := app.Hex()
+	q.usage[key] += int64(n)
+	used = q.usage[key]
+	q.modified = true
+	if time.Since(q.saved) >= quotaUsageSaveInterval {
+		q.save()
+	}
+	q.Unlock()
+	return
+}
+
+// rollover starts the usage again in a new month
+func (q *quotas) rollover() {
+	month := thisMonth()
+	if month == q.month {
+		return
+	}
+	q.month = month
+	q.usage = make(map[string]int64)
+	q.modified = true
+}
+
+func (q *quotas) save() {
+	q.saved = time.Now()
+	if !q.modified || len(q.config.UsagePath) < 1 {
+		return
+	}
+	q.modified = false
+	d, err := json.Marshal(quotaUsage{Month: q.month, Apps: q.usage})
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(q.config.UsagePath), 0700)
+	}
+	if err == nil {
+		err = ioutil.WriteFile(q.config.UsagePath, d, 0600)
+	}
+	if err != nil {
+		log.Errorf("save quota usage: %v", err)
+	}
+}
+
+// getUsage returns the bytes each app used in the month
+func (q *quotas) getUsage() (usage map[string]int64) {
+	usage = make(map[string]int64)
+	if q == nil {
+		return
+	}
+	q.Lock()
+	q.rollover()
+	for k, v := range q.usage {
+		usage[k] = v
+	}
+	q.Unlock()
+	return
+}
+
+// transportQuota paces the data of a transport and counts it for its app
+type transportQuota struct {
+	// bytes of the transport, accessed atomically, first for its 64-bit alignment on 32-bit platforms
+	used int64
+
+	quotas   *quotas
+	app      cipher.PubKey
+	shared   *appQuota
+	quota    Quota
+	up, down *pacer
+}
+
+// use waits until n more bytes may pass and fails when a monthly quota is used up
+func (tq *transportQuota) use(n int, upload bool) error {
+	if tq == nil {
+		return nil
+	}
+	if upload {
+		tq.shared.up.wait(n)
+		tq.up.wait(n)
+	} else {
+		tq.shared.down.wait(n)
+		tq.down.wait(n)
+	}
+	used := tq.quotas.add(tq.app, n)
+	if monthly := tq.shared.quota.MonthlyBytes; monthly > 0 && used > monthly {
+		return fmt.Errorf("app %x: %v, %d of %d bytes", tq.app, errQuotaExceeded, used, monthly)
+	}
+	own := atomic.AddInt64(&tq.used, int64(n))
+	if monthly := tq.quota.MonthlyBytes; monthly > 0 && own > monthly {
+		return fmt.Errorf("transport: %v, %d of %d bytes", errQuotaExceeded, own, monthly)
+	}
+	return nil
+}
+
+// pacer is a token bucket holding a second of data, taking more than it holds waits
+type pacer struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+	sync.Mutex
+}
+
+func newPacer(bytesPerSecond int64) *pacer {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	rate := float64(bytesPerSecond)
+	return &pacer{rate: rate, tokens: rate, last: time.Now()}
+}
+
+func (p *pacer) wait(n int) {
+	if p == nil {
+		return
+	}
+	p.Lock()
+	now := time.Now()
+	p.tokens += now.Sub(p.last).Seconds() * p.rate
+	if p.tokens > p.rate {
+		p.tokens = p.rate
+	}
+	p.last = now
+	p.tokens -= float64(n)
+	var d time.Duration
+	if p.tokens < 0 {
+		d = time.Duration(-p.tokens / p.rate * float64(time.Second))
+	}
+	p.Unlock()
+	if d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// SetQuotas limits the data of the transports of the apps from now on
+func (f *MessengerFactory) SetQuotas(config QuotaConfig) (err error) {
+	q, err := newQuotas(config)
+	if err != nil {
+		return
+	}
+	f.fieldsMutex.Lock()
+	f.quotas = q
+	f.fieldsMutex.Unlock()
+	return
+}
+
+func (f *MessengerFactory) getQuotas() (q *quotas) {
+	f.fieldsMutex.RLock()
+	q = f.quotas
+	f.fieldsMutex.RUnlock()
+	return
+}
+
+// GetQuotaUsage returns the bytes the transports of each app used in the month, by the hex
+// key of the app
+func (f *MessengerFactory) GetQuotaUsage() map[string]int64 {
+	return f.getQuotas().getUsage()
+}
+
+// SaveQuotaUsage writes the monthly usage of the apps to the usage path
+func (f *MessengerFactory) SaveQuotaUsage() {
+	q := f.getQuotas()
+	if q == nil {
+		return
+	}
+	q.Lock()
+	q.save()
+	q.Unlock()
+}
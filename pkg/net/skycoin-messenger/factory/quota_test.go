@@ -0,0 +1,85 @@
+package factory_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/skycoin/skywire/pkg/net/skycoin-messenger/factory"
+	"github.com/skycoin/skywire/pkg/node/nodetest"
+)
+
+func TestMonthlyQuota(t *testing.T) {
+	e := nodetest.NewEnv(t, 1)
+	defer e.Close()
+	a, b := e.StartNode("a"), e.StartNode("b")
+	server := e.ConnectApp(b, "server")
+	server.Offer(e.Echo(), "quota")
+	client := e.ConnectApp(a, "client")
+	config := factory.QuotaConfig{Apps: map[string]factory.Quota{"*": {MonthlyBytes: 1024}}, UsagePath: e.Path("usage.json")}
+	if err := a.SetQuotas(config); err != nil {
+		t.Fatal(err)
+	}
+
+	resp := client.Connect(b.Key, server.GetKey(), e.DiscoveryKey(0))
+	conn := nodetest.Ping(t, resp.Port)
+	defer conn.Close()
+	if used := a.GetQuotaUsage()[client.GetKey().Hex()]; used <= 0 || used > 1024 {
+		t.Fatalf("usage after a ping %d", used)
+	}
+	// the transport is closed above the quota and the app is told why
+	if _, err := conn.Write(make([]byte, 2048)); err != nil {
+		t.Fatal(err)
+	}
+	if resp = client.Answer(); !resp.Failed || resp.Msg.Priority != factory.QuotaExceeded {
+		t.Fatalf("answer above the quota %#v", resp)
+	}
+	if used := a.GetQuotaUsage()[client.GetKey().Hex()]; used <= 1024 {
+		t.Fatalf("usage above the quota %d", used)
+	}
+	// no transport is set up until the next month, the usage is kept across restarts
+	a.Close()
+	a = e.Start(e.NewNode("a"))
+	if err := a.SetQuotas(config); err != nil {
+		t.Fatal(err)
+	}
+	client = e.ConnectApp(a, "client")
+	if resp = client.Dial(b.Key, server.GetKey(), e.DiscoveryKey(0)); !resp.Failed || resp.Msg.Priority != factory.QuotaExceeded {
+		t.Fatalf("transport above the quota %#v", resp)
+	}
+}
+
+func TestBandwidthQuota(t *testing.T) {
+	e := nodetest.NewEnv(t, 1)
+	defer e.Close()
+	a, b := e.StartNode("a"), e.StartNode("b")
+	server := e.ConnectApp(b, "server")
+	server.Offer(e.Echo(), "quota")
+	client := e.ConnectApp(a, "client")
+	err := a.SetQuotas(factory.QuotaConfig{Transport: factory.Quota{BytesPerSecond: 1000}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp := client.Connect(b.Key, server.GetKey(), e.DiscoveryKey(0))
+	conn := nodetest.Ping(t, resp.Port)
+	defer conn.Close()
+	// a second of data passes at once, the rest waits for the rate
+	data := bytes.Repeat([]byte("quota"), 600)
+	start := time.Now()
+	if _, err = conn.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	echo := make([]byte, len(data))
+	if _, err = io.ReadFull(conn, echo); err != nil || !bytes.Equal(echo, data) {
+		t.Fatalf("echo: %v", err)
+	}
+	if d := time.Since(start); d < 1500*time.Millisecond {
+		t.Fatalf("%d bytes at 1000 bytes per second in %s", len(data), d)
+	}
+	if usage := a.GetQuotaUsage(); len(usage) != 1 || usage[client.GetKey().Hex()] < int64(2*len(data)) {
+		t.Fatalf("usage %v", usage)
+	}
+}
@@ -0,0 +1,114 @@
+package factory
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/skycoin/skycoin/src/cipher"
+	cn "github.com/skycoin/skywire/pkg/net/conn"
+)
+
+const (
+	// a transport the other node did not answer on for this long is taken as lost when the
+	// node reconnects, the nodes ping every UDP_PING_TICK_PERIOD on a quiet transport
+	reconcileSilence = 3 * cn.UDP_PING_TICK_PERIOD * time.Second
+	reconciliations  = 20
+)
+
+// Reconciliation is what a node fixed after it connected again to a discovery it lost
+type Reconciliation struct {
+	Time      int64  `json:"time"`
+	Discovery string `json:"discovery"`
+	// transports closed because the other node no longer answered on them
+	Closed int `json:"closed"`
+	// answers of the discovery dropped from the route cache
+	Purged int `json:"purged"`
+}
+
+type reconciler struct {
+	last []Reconciliation
+	sync.Mutex
+}
+
+// reconnected returns true if the connection of the config was up before, for every
+// connection after the first one
+func (config *ConnConfig) reconnected() bool {
+	return atomic.SwapInt32(&config.everConnected, 1) == 1
+}
+
+// reconcile runs when the node connected again to a discovery it lost, after a partition
+// of the node or an outage of the discovery. The transports the other node stopped
+// answering on while the node was cut off are closed instead of waiting for the udp gc,
+// the answers the discovery gave before are dropped from the route cache and the services
+// are announced again with what is left
+func (f *MessengerFactory) reconcile(discovery *Connection) {
+	if !f.Proxy {
+		return
+	}
+	key := discovery.GetTargetKey()
+	r := Reconciliation{Time: time.Now().Unix(), Discovery: key.Hex()}
+	var lost []*Transport
+	f.ForEachAcceptedConnection(func(_ cipher.PubKey, conn *Connection) {
+		conn.ForEachTransport(func(t *Transport) {
+			if t.silentFor() >= reconcileSilence {
+				lost = append(lost, t)
+			}
+		})
+	})
+	for _, t := range lost {
+		t.Logger().Infof("transport to node %x lost while disconnected from discovery %x", t.ToNode, key)
+		t.CloseWithReason(CloseRouteFailure)
+	}
+	r.Closed = len(lost)
+	r.Purged = f.routes.forgetDiscovery(key)
+	discovery.GetContextLogger().Infof("reconciled after reconnect: %d transports closed, %d routes purged", r.Closed, r.Purged)
+
+	f.reconciler.Lock()
+	f.reconciler.last = append(f.reconciler.last, r)
+	if len(f.reconciler.last) > reconciliations {
+		f.reconciler.last = f.reconciler.last[len(f.reconciler.last)-reconciliations:]
+	}
+	f.reconciler.Unlock()
+
+	if r.Closed > 0 {
+		f.capacityChanged()
+	}
+	if f.pack() != nil {
+		f.announce(discovery, f.GetAnnounceSchedule().Settle)
+	}
+}
+
+// GetReconciliations returns the last reconciliations after reconnects to the discoveries
+func (f *MessengerFactory) GetReconciliations() []Reconciliation {
+	f.reconciler.Lock()
+	defer f.reconciler.Unlock()
+	return append([]Reconciliation(nil), f.reconciler.last...)
+}
+
+// silentFor returns how long nothing was received from the other node on the transport, 0
+// while it is set up or after it closed
+func (t *Transport) silentFor() time.Duration {
+	t.fieldsMutex.RLock()
+	conn := t.conn
+	ready := t.factory != nil && t.timeoutTimer == nil
+	t.fieldsMutex.RUnlock()
+	if conn == nil || !ready {
+		return 0
+	}
+	return time.Since(time.Unix(conn.GetLastTime(), 0))
+}
+
+// forgetDiscovery drops the answers of the discovery, returns how many were dropped
+func (c *routeCache) forgetDiscovery(discovery cipher.PubKey) (n int) {
+	c.Lock()
+	defer c.Unlock()
+	for key, e := range c.entries {
+		if key.discovery == discovery {
+			c.lru.Remove(e)
+			delete(c.entries, key)
+			n++
+		}
+	}
+	return
+}
@@ -0,0 +1,95 @@
+package factory
+
+import (
+	"testing"
+	"time"
+
+	"github.com/skycoin/skycoin/src/cipher"
+	cn "github.com/skycoin/skywire/pkg/net/conn"
+)
+
+// silentConn is a transport connection nothing was received on since last
+type silentConn struct {
+	cn.Connection
+	last int64
+}
+
+func (c *silentConn) GetLastTime() int64 { return c.last }
+
+func TestReconcile(t *testing.T) {
+	discovery, other := cipher.PubKey([33]byte{0x04, 1}), cipher.PubKey([33]byte{0x04, 2})
+	fromNode, toNode := cipher.PubKey([33]byte{0x02, 1}), cipher.PubKey([33]byte{0x02, 2})
+	appKey := cipher.PubKey([33]byte{0x03, 1})
+	f := NewMessengerFactory()
+	f.Proxy = true
+	f.SetRouteCache(DefaultRouteCacheConfig)
+	for i, d := range []cipher.PubKey{discovery, discovery, other} {
+		f.routes.put(routeKey{discovery: d, node: toNode, app: cipher.PubKey([33]byte{0x05, byte(i)})}, false, PriorityMsg{}, time.Second)
+	}
+	appConn, _ := newFakeConnection(f, "127.0.0.1:5000")
+	appConn.SetKey(appKey)
+	appConn.appTransports = make(map[cipher.PubKey]*Transport)
+	f.regConnections[appKey] = appConn
+
+	transports := make(map[string]*Transport)
+	for i, c := range []struct {
+		name string
+		// nothing received for this long, negative while it is set up
+		silence time.Duration
+	}{
+		{name: "live", silence: time.Second},
+		{name: "quiet", silence: reconcileSilence - 5*time.Second},
+		{name: "in setup", silence: -time.Hour},
+		{name: "lost", silence: reconcileSilence + 5*time.Second},
+	} {
+		app := cipher.PubKey([33]byte{0x03, 2, byte(i)})
+		tr := NewTransport(f, appConn, fromNode, toNode, appKey, app)
+		conn, fake := newFakeConnection(f, "127.0.0.1:5001")
+		silence := c.silence
+		if silence < 0 {
+			silence = -silence
+			tr.timeoutTimer = time.NewTimer(time.Hour)
+		}
+		conn.Connection.Connection = &silentConn{Connection: fake, last: time.Now().Add(-silence).Unix()}
+		tr.conn = conn
+		appConn.appTransports[app] = tr
+		transports[c.name] = tr
+	}
+
+	d, _ := newFakeConnection(f, "127.0.0.1:5002")
+	d.targetKey = discovery
+	f.reconcile(d)
+	rs := f.GetReconciliations()
+	if len(rs) != 1 || rs[0].Discovery != discovery.Hex() || rs[0].Closed != 1 || rs[0].Purged != 2 {
+		t.Fatalf("reconciliations %#v", rs)
+	}
+	for name, tr := range transports {
+		if closed := tr.isClosed(); closed != (name == "lost") {
+			t.Errorf("transport %s closed %v", name, closed)
+		}
+	}
+	if r := transports["lost"].CloseReason(); r != CloseRouteFailure {
+		t.Errorf("lost transport closed for %s", r)
+	}
+	// the answers of the other discoveries are kept
+	if routes := f.GetRoutes(); len(routes) != 1 {
+		t.Errorf("routes %#v", routes)
+	}
+
+	// the last reconciliations are kept
+	for i := 0; i < reconciliations; i++ {
+		f.reconcile(d)
+	}
+	if rs = f.GetReconciliations(); len(rs) != reconciliations || rs[0].Closed != 0 {
+		t.Fatalf("%d reconciliations, first %#v", len(rs), rs[0])
+	}
+}
+
+func TestReconnected(t *testing.T) {
+	config := &ConnConfig{}
+	for i, want := range []bool{false, true, true} {
+		if got := config.reconnected(); got != want {
+			t.Errorf("connection %d reconnected %v", i, got)
+		}
+	}
+}
@@ -0,0 +1,399 @@
+package factory
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/skycoin/skycoin/src/cipher"
+	"github.com/skycoin/skywire/pkg/net/factory"
+)
+
+const (
+	// the messages not acknowledged are sent again this often by default
+	DefaultReliableRetransmit = 2 * time.Second
+	// messages to a peer not acknowledged at most by default
+	DefaultReliableWindow = 256
+)
+
+// the header of the reliable messages after the keys of OP_SEND_ACKED: the kind, the session
+// of the sender and the sequence, of the message or the last one received in order for an ack
+const (
+	reliableKindBegin    = SEND_MSG_TO_PUBLIC_KEY_END
+	reliableSessionBegin = reliableKindBegin + 1
+	reliableSeqBegin     = reliableSessionBegin + 8
+	reliableHeaderEnd    = reliableSeqBegin + 8
+)
+
+const (
+	reliableData byte = iota
+	reliableAck
+)
+
+var (
+	ErrReliableDisabled   = errors.New("reliable delivery is not enabled")
+	ErrReliableWindowFull = errors.New("too many messages to the peer not acknowledged")
+)
+
+// ReliableConfig of the messages sent with SendReliable. They are acknowledged by the peer and
+// sent again until they are, through every discovery the factory is connected to and after
+// it reconnects, so they survive a short outage of a discovery. Both peers must enable it.
+type ReliableConfig struct {
+	// the messages not acknowledged are sent again this often, DefaultReliableRetransmit if 0
+	Retransmit time.Duration
+	// messages to a peer not acknowledged at most, SendReliable fails above it,
+	// DefaultReliableWindow if 0
+	Window int
+	// a message not acknowledged for this long is dropped with the later ones to the peer,
+	// 0 to send it until it is acknowledged
+	Expire time.Duration
+	// called with the messages of each peer once, in the order they were sent
+	OnMessage func(from cipher.PubKey, msg []byte)
+	// called with the messages dropped before they were acknowledged
+	OnExpired func(to cipher.PubKey, msg []byte)
+}
+
+func (c ReliableConfig) retransmit() time.Duration {
+	if c.Retransmit <= 0 {
+		return DefaultReliableRetransmit
+	}
+	return c.Retransmit
+}
+
+func (c ReliableConfig) window() int {
+	if c.Window <= 0 {
+		return DefaultReliableWindow
+	}
+	return c.Window
+}
+
+// ReliableStats counts the reliable messages of the factory
+type ReliableStats struct {
+	// messages sent and not acknowledged yet
+	Pending       int    `json:"pending"`
+	Sent          uint64 `json:"sent"`
+	Retransmitted uint64 `json:"retransmitted"`
+	Acknowledged  uint64 `json:"acknowledged"`
+	Expired       uint64 `json:"expired"`
+	Received      uint64 `json:"received"`
+	// messages received again, their ack was lost
+	Duplicates uint64 `json:"duplicates"`
+}
+
+type reliableFrame struct {
+	seq   uint64
+	msg   []byte
+	first time.Time
+	sent  time.Time
+}
+
+// reliableOut are the messages to a peer, a new session once messages expired so the peer
+// does not wait for them
+type reliableOut struct {
+	session uint64
+	next    uint64
+	// by seq
+	pending []*reliableFrame
+}
+
+// reliableIn are the messages of a peer
+type reliableIn struct {
+	session   uint64
+	delivered uint64
+	// received before the ones they follow
+	early map[uint64][]byte
+}
+
+type reliable struct {
+	config ReliableConfig
+	out    map[cipher.PubKey]*reliableOut
+	in     map[cipher.PubKey]*reliableIn
+	stats  ReliableStats
+	stop   chan struct{}
+	sync.Mutex
+
+	// the messages are passed to OnMessage one at a time
+	deliverMutex sync.Mutex
+}
+
+func newReliableSession() uint64 {
+	var b [8]byte
+	rand.Read(b[:])
+	return binary.BigEndian.Uint64(b[:])
+}
+
+// SetReliableDelivery enables SendReliable and the acks of the reliable messages of the peers,
+// the messages not acknowledged yet are kept when the config changes
+func (f *MessengerFactory) SetReliableDelivery(config ReliableConfig) {
+	f.fieldsMutex.Lock()
+	r := f.reliable
+	if r == nil {
+		r = &reliable{
+			out:  make(map[cipher.PubKey]*reliableOut),
+			in:   make(map[cipher.PubKey]*reliableIn),
+			stop: make(chan struct{}),
+		}
+		f.reliable = r
+		go f.retransmitReliable(r)
+	}
+	f.fieldsMutex.Unlock()
+	r.Lock()
+	r.config = config
+	r.Unlock()
+}
+
+func (f *MessengerFactory) getReliable() (r *reliable) {
+	f.fieldsMutex.RLock()
+	r = f.reliable
+	f.fieldsMutex.RUnlock()
+	return
+}
+
+// GetReliableStats returns the counters of the reliable messages, zero if not enabled
+func (f *MessengerFactory) GetReliableStats() (s ReliableStats) {
+	r := f.getReliable()
+	if r == nil {
+		return
+	}
+	r.Lock()
+	s = r.stats
+	s.Pending = 0
+	for _, o := range r.out {
+		s.Pending += len(o.pending)
+	}
+	r.Unlock()
+	return
+}
+
+// SendReliable sends msg to the peer until it acknowledges it, the message is queued if the
+// factory is not connected to a discovery now
+func (f *MessengerFactory) SendReliable(to cipher.PubKey, msg []byte) (err error) {
+	r := f.getReliable()
+	if r == nil {
+		return ErrReliableDisabled
+	}
+	now := time.Now()
+	r.Lock()
+	o, ok := r.out[to]
+	if !ok {
+		o = &reliableOut{session: newReliableSession()}
+		r.out[to] = o
+	}
+	if len(o.pending) >= r.config.window() {
+		r.Unlock()
+		return ErrReliableWindowFull
+	}
+	o.next++
+	frame := &reliableFrame{seq: o.next, msg: append([]byte(nil), msg...), first: now, sent: now}
+	o.pending = append(o.pending, frame)
+	session := o.session
+	r.stats.Sent++
+	r.Unlock()
+	f.writeReliable(to, reliableData, session, frame.seq, frame.msg)
+	return
+}
+
+func genReliableMsg(from, to cipher.PubKey, kind byte, session, seq uint64, msg []byte) []byte {
+	result := make([]byte, reliableHeaderEnd+len(msg))
+	result[MSG_OP_BEGIN] = OP_SEND_ACKED
+	copy(result[SEND_MSG_PUBLIC_KEY_BEGIN:], from[:])
+	copy(result[SEND_MSG_TO_PUBLIC_KEY_BEGIN:], to[:])
+	result[reliableKindBegin] = kind
+	binary.BigEndian.PutUint64(result[reliableSessionBegin:], session)
+	binary.BigEndian.PutUint64(result[reliableSeqBegin:], seq)
+	copy(result[reliableHeaderEnd:], msg)
+	return result
+}
+
+// writeReliable writes the message through every discovery registered with, the peer drops
+// the copies
+func (f *MessengerFactory) writeReliable(to cipher.PubKey, kind byte, session, seq uint64, msg []byte) {
+	f.fieldsMutex.RLock()
+	ff := f.factory
+	f.fieldsMutex.RUnlock()
+	if ff == nil {
+		return
+	}
+	ff.ForEachConn(func(conn *factory.Connection) {
+		c, ok := conn.RealObject.(*Connection)
+		if !ok {
+			return
+		}
+		key, ok := c.keyIfSet()
+		if !ok {
+			return
+		}
+		if err := c.Write(genReliableMsg(key, to, kind, session, seq, msg)); err != nil {
+			c.GetContextLogger().Debugf("write reliable message to %s err %v", to.Hex(), err)
+		}
+	})
+}
+
+// resendReliable sends the messages not acknowledged again, once the factory connected
+func (f *MessengerFactory) resendReliable() {
+	r := f.getReliable()
+	if r == nil {
+		return
+	}
+	f.retransmit(r, time.Now(), true)
+}
+
+func (f *MessengerFactory) retransmitReliable(r *reliable) {
+	for {
+		r.Lock()
+		interval := r.config.retransmit()
+		r.Unlock()
+		select {
+		case <-r.stop:
+			return
+		case now := <-time.After(interval):
+			f.retransmit(r, now, false)
+		}
+	}
+}
+
+func (f *MessengerFactory) retransmit(r *reliable, now time.Time, all bool) {
+	type resend struct {
+		to      cipher.PubKey
+		session uint64
+		frame   reliableFrame
+	}
+	var resends []resend
+	type expired struct {
+		to  cipher.PubKey
+		msg []byte
+	}
+	var expires []expired
+	r.Lock()
+	config := r.config
+	for to, o := range r.out {
+		if len(o.pending) == 0 {
+			continue
+		}
+		if config.Expire > 0 && now.Sub(o.pending[0].first) > config.Expire {
+			// the peer waits for the first one, the later ones would never be delivered
+			for _, fr := range o.pending {
+				expires = append(expires, expired{to: to, msg: fr.msg})
+			}
+			r.stats.Expired += uint64(len(o.pending))
+			o.pending = nil
+			o.session = newReliableSession()
+			o.next = 0
+			continue
+		}
+		for _, fr := range o.pending {
+			if all || now.Sub(fr.sent) >= config.retransmit() {
+				fr.sent = now
+				resends = append(resends, resend{to: to, session: o.session, frame: *fr})
+			}
+		}
+	}
+	r.stats.Retransmitted += uint64(len(resends))
+	r.Unlock()
+	for _, s := range resends {
+		f.writeReliable(s.to, reliableData, s.session, s.frame.seq, s.frame.msg)
+	}
+	if config.OnExpired != nil {
+		for _, e := range expires {
+			config.OnExpired(e.to, e.msg)
+		}
+	}
+}
+
+// receiveReliable takes the reliable messages to the key of the connection, false for the
+// messages the connection forwards or reads as other messages
+func (f *MessengerFactory) receiveReliable(c *Connection, m []byte) bool {
+	r := f.getReliable()
+	if r == nil || len(m) < reliableHeaderEnd {
+		return false
+	}
+	key, ok := c.keyIfSet()
+	if !ok || cipher.NewPubKey(m[SEND_MSG_TO_PUBLIC_KEY_BEGIN:SEND_MSG_TO_PUBLIC_KEY_END]) != key {
+		return false
+	}
+	from := cipher.NewPubKey(m[SEND_MSG_PUBLIC_KEY_BEGIN:SEND_MSG_PUBLIC_KEY_END])
+	session := binary.BigEndian.Uint64(m[reliableSessionBegin:])
+	seq := binary.BigEndian.Uint64(m[reliableSeqBegin:])
+	switch m[reliableKindBegin] {
+	case reliableAck:
+		r.ack(from, session, seq)
+	case reliableData:
+		ack := r.receive(from, session, seq, m[reliableHeaderEnd:])
+		err := c.Write(genReliableMsg(key, from, reliableAck, session, ack, nil))
+		if err != nil {
+			c.GetContextLogger().Debugf("write reliable ack to %s err %v", from.Hex(), err)
+		}
+	}
+	return true
+}
+
+func (r *reliable) ack(from cipher.PubKey, session, seq uint64) {
+	r.Lock()
+	defer r.Unlock()
+	o, ok := r.out[from]
+	if !ok || o.session != session {
+		return
+	}
+	i := 0
+	for i < len(o.pending) && o.pending[i].seq <= seq {
+		i++
+	}
+	r.stats.Acknowledged += uint64(i)
+	o.pending = o.pending[i:]
+}
+
+// receive passes the message and the ones received early that follow it to OnMessage and
+// returns the last one passed in order
+func (r *reliable) receive(from cipher.PubKey, session, seq uint64, msg []byte) (ack uint64) {
+	r.deliverMutex.Lock()
+	defer r.deliverMutex.Unlock()
+	r.Lock()
+	in, ok := r.in[from]
+	if !ok || in.session != session {
+		in = &reliableIn{session: session, early: make(map[uint64][]byte)}
+		r.in[from] = in
+	}
+	var deliver [][]byte
+	switch {
+	case seq <= in.delivered:
+		r.stats.Duplicates++
+	case seq == in.delivered+1:
+		deliver = append(deliver, append([]byte(nil), msg...))
+		in.delivered++
+		for {
+			m, ok := in.early[in.delivered+1]
+			if !ok {
+				break
+			}
+			delete(in.early, in.delivered+1)
+			deliver = append(deliver, m)
+			in.delivered++
+		}
+	case len(in.early) < r.config.window():
+		if _, ok := in.early[seq]; ok {
+			r.stats.Duplicates++
+		} else {
+			in.early[seq] = append([]byte(nil), msg...)
+		}
+	}
+	r.stats.Received += uint64(len(deliver))
+	ack = in.delivered
+	onMessage := r.config.OnMessage
+	r.Unlock()
+	if onMessage != nil {
+		for _, m := range deliver {
+			onMessage(from, m)
+		}
+	}
+	return
+}
+
+func (r *reliable) close() {
+	select {
+	case <-r.stop:
+	default:
+		close(r.stop)
+	}
+}
@@ -0,0 +1,137 @@
+package factory
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+func TestReliableReceive(t *testing.T) {
+	from := cipher.PubKey([33]byte{0x02, 1})
+	type frame struct {
+		session, seq uint64
+	}
+	for _, c := range []struct {
+		name   string
+		window int
+		frames []frame
+		// the messages passed on and the ack of each frame
+		delivered  string
+		acks       []uint64
+		duplicates uint64
+	}{
+		{name: "in order", frames: []frame{{1, 1}, {1, 2}, {1, 3}}, delivered: "1,2,3", acks: []uint64{1, 2, 3}},
+		{name: "early", frames: []frame{{1, 2}, {1, 3}, {1, 1}}, delivered: "1,2,3", acks: []uint64{0, 0, 3}},
+		{name: "delivered twice", frames: []frame{{1, 1}, {1, 1}}, delivered: "1", acks: []uint64{1, 1}, duplicates: 1},
+		{name: "early twice", frames: []frame{{1, 2}, {1, 2}, {1, 1}}, delivered: "1,2", acks: []uint64{0, 0, 2}, duplicates: 1},
+		{name: "new session", frames: []frame{{1, 1}, {1, 2}, {2, 1}}, delivered: "1,2,1", acks: []uint64{1, 2, 1}},
+		{name: "early above the window", window: 1, frames: []frame{{1, 2}, {1, 3}, {1, 1}}, delivered: "1,2", acks: []uint64{0, 0, 2}},
+	} {
+		var delivered []string
+		r := &reliable{in: make(map[cipher.PubKey]*reliableIn), config: ReliableConfig{
+			Window: c.window,
+			OnMessage: func(k cipher.PubKey, msg []byte) {
+				if k != from {
+					t.Errorf("%s: message from %s", c.name, k.Hex())
+				}
+				delivered = append(delivered, string(msg))
+			},
+		}}
+		var acks []uint64
+		for _, fr := range c.frames {
+			acks = append(acks, r.receive(from, fr.session, fr.seq, []byte{byte('0' + fr.seq)}))
+		}
+		if strings.Join(delivered, ",") != c.delivered || len(acks) != len(c.acks) || r.stats.Duplicates != c.duplicates {
+			t.Errorf("%s: delivered %v, acks %v, stats %+v", c.name, delivered, acks, r.stats)
+			continue
+		}
+		for i := range acks {
+			if acks[i] != c.acks[i] {
+				t.Errorf("%s: acks %v, want %v", c.name, acks, c.acks)
+				break
+			}
+		}
+	}
+}
+
+func TestSendReliable(t *testing.T) {
+	f := NewMessengerFactory()
+	if err := f.SendReliable(cipher.PubKey{}, nil); err != ErrReliableDisabled {
+		t.Fatalf("send without reliable delivery err %v", err)
+	}
+	var expired []string
+	f.SetReliableDelivery(ReliableConfig{Retransmit: time.Hour, Window: 3, Expire: 2 * time.Hour, OnExpired: func(to cipher.PubKey, msg []byte) {
+		expired = append(expired, string(msg))
+	}})
+	r := f.getReliable()
+	defer r.close()
+	to := cipher.PubKey([33]byte{0x02, 1})
+	for _, m := range []string{"1", "2", "3"} {
+		if err := f.SendReliable(to, []byte(m)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.SendReliable(to, []byte("4")); err != ErrReliableWindowFull {
+		t.Fatalf("sent above the window: %v", err)
+	}
+	session := r.out[to].session
+	now := time.Now()
+	for _, c := range []struct {
+		name string
+		// an ack of session and seq, or a retransmission at after
+		ack          bool
+		session, seq uint64
+		after        time.Duration
+		stats        ReliableStats
+	}{
+		{name: "ack of another session", ack: true, session: session + 1, seq: 3, stats: ReliableStats{Pending: 3, Sent: 3}},
+		{name: "ack", ack: true, session: session, seq: 1, stats: ReliableStats{Pending: 2, Sent: 3, Acknowledged: 1}},
+		{name: "not due", after: time.Minute, stats: ReliableStats{Pending: 2, Sent: 3, Acknowledged: 1}},
+		{name: "due", after: time.Hour, stats: ReliableStats{Pending: 2, Sent: 3, Acknowledged: 1, Retransmitted: 2}},
+		{name: "ack again", ack: true, session: session, seq: 1, stats: ReliableStats{Pending: 2, Sent: 3, Acknowledged: 1, Retransmitted: 2}},
+		{name: "expired", after: 3 * time.Hour, stats: ReliableStats{Sent: 3, Acknowledged: 1, Retransmitted: 2, Expired: 2}},
+	} {
+		if c.ack {
+			r.ack(to, c.session, c.seq)
+		} else {
+			f.retransmit(r, now.Add(c.after), false)
+		}
+		if s := f.GetReliableStats(); s != c.stats {
+			t.Errorf("%s: stats %+v, want %+v", c.name, s, c.stats)
+		}
+	}
+	// the peer is sent the later messages in a new session
+	if strings.Join(expired, ",") != "2,3" || r.out[to].session == session || r.out[to].next != 0 {
+		t.Fatalf("expired %v, session %d", expired, r.out[to].session)
+	}
+}
+
+func TestReceiveReliable(t *testing.T) {
+	f := NewMessengerFactory()
+	key, from := cipher.PubKey([33]byte{0x02, 1}), cipher.PubKey([33]byte{0x02, 2})
+	conn, fake := newFakeConnection(f, "127.0.0.1:5000")
+	conn.SetKey(key)
+	data := genReliableMsg(from, key, reliableData, 7, 1, []byte("hello"))
+	if f.receiveReliable(conn, data) {
+		t.Fatal("received without reliable delivery")
+	}
+	var received []string
+	f.SetReliableDelivery(ReliableConfig{OnMessage: func(k cipher.PubKey, msg []byte) {
+		received = append(received, string(msg))
+	}})
+	defer f.getReliable().close()
+	if f.receiveReliable(conn, genReliableMsg(from, from, reliableData, 7, 1, nil)) || f.receiveReliable(conn, data[:reliableHeaderEnd-1]) {
+		t.Fatal("received a message to another key")
+	}
+	if !f.receiveReliable(conn, data) || strings.Join(received, ",") != "hello" || len(fake.written) != 1 {
+		t.Fatalf("received %v, written %d", received, len(fake.written))
+	}
+	ack := fake.written[0]
+	if ack[reliableKindBegin] != reliableAck || binary.BigEndian.Uint64(ack[reliableSessionBegin:]) != 7 ||
+		binary.BigEndian.Uint64(ack[reliableSeqBegin:]) != 1 || cipher.NewPubKey(ack[SEND_MSG_TO_PUBLIC_KEY_BEGIN:SEND_MSG_TO_PUBLIC_KEY_END]) != from {
+		t.Fatalf("ack %x", ack)
+	}
+}
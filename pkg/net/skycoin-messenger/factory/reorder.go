@@ -0,0 +1,114 @@
+package factory
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+const (
+	// the OP_TRANSPORT_SEQ frames carry the sequence of the frame in its loop after the
+	// header, the open and close frames carry none
+	PKG_SEQ_SIZE = 4
+	PKG_SEQ_END  = PKG_HEADER_END + PKG_SEQ_SIZE
+
+	// frames of a loop held back waiting for a missing one, a loop missing a frame for
+	// longer is closed
+	reorderWindow = 64
+	// a loop missing a frame for longer is closed, the frames after it would be held forever
+	reorderGapTimeout = 10 * time.Second
+)
+
+var (
+	errReorderOverflow = errors.New("reordering window exceeded")
+	errReorderGap      = errors.New("frame missing for too long")
+)
+
+// reorder puts the data frames of a loop back in order, a transport that loses or
+// reorders packets delivers the data to the app as the app sent it. Only the read loop
+// of the transport uses it
+type reorder struct {
+	// sequence of the next frame the app gets, the first data frame is 1
+	next    uint32
+	pending map[uint32][]byte
+	// when the frame the app gets next was first missed, zero while none is held
+	missing time.Time
+}
+
+// push returns the data the app gets in order with the frame of seq received at now,
+// nothing if a frame before it is missing. Frames seen already are dropped
+func (r *reorder) push(seq uint32, data []byte, now time.Time) (ready [][]byte, err error) {
+	if r.next == 0 {
+		r.next = 1
+	}
+	// the distance wraps with the sequence
+	ahead := seq - r.next
+	if ahead >= 1<<31 {
+		return
+	}
+	if ahead == 0 {
+		ready = append(ready, data)
+		r.next++
+		for {
+			d, ok := r.pending[r.next]
+			if !ok {
+				break
+			}
+			delete(r.pending, r.next)
+			ready = append(ready, d)
+			r.next++
+		}
+		if len(r.pending) == 0 {
+			r.missing = time.Time{}
+		} else {
+			r.missing = now
+		}
+		return
+	}
+	if ahead >= reorderWindow || len(r.pending) >= reorderWindow {
+		err = errReorderOverflow
+		return
+	}
+	if r.pending == nil {
+		r.pending = make(map[uint32][]byte)
+	}
+	if _, ok := r.pending[seq]; !ok {
+		r.pending[seq] = append([]byte(nil), data...)
+	}
+	if r.missing.IsZero() {
+		r.missing = now
+	}
+	return
+}
+
+// expired returns errReorderGap if the frame the app gets next is missing for longer than
+// reorderGapTimeout at now
+func (r *reorder) expired(now time.Time) error {
+	if len(r.pending) > 0 && now.Sub(r.missing) >= reorderGapTimeout {
+		return errReorderGap
+	}
+	return nil
+}
+
+// drop forgets the frames held back, the loop is closed
+func (r *reorder) drop() {
+	r.pending = nil
+	r.missing = time.Time{}
+}
+
+// sequence is the counter of the data frames a loop sends
+type sequence uint32
+
+// stamp writes the next sequence into the frame in buf
+func (s *sequence) stamp(buf []byte) {
+	*s++
+	binary.BigEndian.PutUint32(buf[PKG_HEADER_END:PKG_SEQ_END], uint32(*s))
+}
+
+// frameSeq returns the sequence and the data of an OP_TRANSPORT_SEQ frame
+func frameSeq(m []byte) (seq uint32, data []byte, ok bool) {
+	if len(m) <= PKG_SEQ_END {
+		return
+	}
+	return binary.BigEndian.Uint32(m[PKG_HEADER_END:PKG_SEQ_END]), m[PKG_SEQ_END:], true
+}
@@ -0,0 +1,170 @@
+package factory
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func frames(ready [][]byte) string {
+	return string(bytes.Join(ready, nil))
+}
+
+func TestReorderOutOfOrder(t *testing.T) {
+	var r reorder
+	now := time.Now()
+	for _, c := range []struct {
+		seq   uint32
+		data  string
+		ready string
+	}{
+		{2, "b", ""},
+		{4, "d", ""},
+		{1, "a", "ab"},
+		{3, "c", "cd"},
+		{5, "e", "e"},
+	} {
+		ready, err := r.push(c.seq, []byte(c.data), now)
+		if err != nil {
+			t.Fatalf("push %d: %v", c.seq, err)
+		}
+		if got := frames(ready); got != c.ready {
+			t.Fatalf("push %d: got %q, want %q", c.seq, got, c.ready)
+		}
+	}
+	if len(r.pending) != 0 || !r.missing.IsZero() {
+		t.Fatalf("frames still held %v", r.pending)
+	}
+}
+
+func TestReorderDuplicates(t *testing.T) {
+	var r reorder
+	now := time.Now()
+	push := func(seq uint32, data string) string {
+		ready, err := r.push(seq, []byte(data), now)
+		if err != nil {
+			t.Fatalf("push %d: %v", seq, err)
+		}
+		return frames(ready)
+	}
+	if got := push(1, "a"); got != "a" {
+		t.Fatalf("got %q", got)
+	}
+	if got := push(1, "a"); got != "" {
+		t.Fatalf("a delivered frame delivered again %q", got)
+	}
+	push(3, "c")
+	push(3, "x")
+	if got := push(2, "b"); got != "bc" {
+		t.Fatalf("got %q, want the first of the duplicates", got)
+	}
+}
+
+func TestReorderHoldsACopy(t *testing.T) {
+	var r reorder
+	buf := []byte("b")
+	r.push(2, buf, time.Now())
+	buf[0] = 'x'
+	ready, _ := r.push(1, []byte("a"), time.Now())
+	if got := frames(ready); got != "ab" {
+		t.Fatalf("got %q, the read buffer is reused", got)
+	}
+}
+
+func TestReorderWrap(t *testing.T) {
+	r := reorder{next: 1<<32 - 1}
+	now := time.Now()
+	if ready, _ := r.push(0, []byte("b"), now); len(ready) != 0 {
+		t.Fatal("a frame after the wrap delivered early")
+	}
+	ready, err := r.push(1<<32-1, []byte("a"), now)
+	if err != nil || frames(ready) != "ab" {
+		t.Fatalf("got %q %v", frames(ready), err)
+	}
+	if r.next != 1 {
+		t.Fatalf("next %d", r.next)
+	}
+}
+
+func TestReorderGapTimeout(t *testing.T) {
+	var r reorder
+	start := time.Now()
+	if err := r.expired(start.Add(time.Hour)); err != nil {
+		t.Fatalf("expired without frames held: %v", err)
+	}
+	r.push(2, []byte("b"), start)
+	r.push(4, []byte("d"), start.Add(reorderGapTimeout/2))
+	if err := r.expired(start.Add(reorderGapTimeout - time.Millisecond)); err != nil {
+		t.Fatalf("expired early: %v", err)
+	}
+	if err := r.expired(start.Add(reorderGapTimeout)); err != errReorderGap {
+		t.Fatalf("got %v, want %v", err, errReorderGap)
+	}
+
+	// filling the gap starts the wait for the next missing frame over
+	filled := start.Add(reorderGapTimeout - time.Second)
+	if ready, _ := r.push(1, []byte("a"), filled); frames(ready) != "ab" {
+		t.Fatalf("got %q", frames(ready))
+	}
+	if err := r.expired(start.Add(reorderGapTimeout)); err != nil {
+		t.Fatalf("expired after the gap was filled: %v", err)
+	}
+	if err := r.expired(filled.Add(reorderGapTimeout)); err != errReorderGap {
+		t.Fatalf("got %v, want %v", err, errReorderGap)
+	}
+
+	r.drop()
+	if err := r.expired(filled.Add(time.Hour)); err != nil {
+		t.Fatalf("expired after drop: %v", err)
+	}
+}
+
+func TestReorderWindow(t *testing.T) {
+	var r reorder
+	now := time.Now()
+	if _, err := r.push(reorderWindow+1, nil, now); err != errReorderOverflow {
+		t.Fatalf("a frame past the window: got %v, want %v", err, errReorderOverflow)
+	}
+	for seq := uint32(2); seq <= reorderWindow; seq++ {
+		if _, err := r.push(seq, []byte{byte(seq)}, now); err != nil {
+			t.Fatalf("push %d: %v", seq, err)
+		}
+	}
+	if len(r.pending) != reorderWindow-1 {
+		t.Fatalf("held %d", len(r.pending))
+	}
+	ready, err := r.push(1, []byte{1}, now)
+	if err != nil || len(ready) != reorderWindow {
+		t.Fatalf("got %d frames, %v", len(ready), err)
+	}
+	for i, d := range ready {
+		if d[0] != byte(i+1) {
+			t.Fatalf("frame %d out of order", i)
+		}
+	}
+
+	// the window holds as many frames as it spans
+	r = reorder{}
+	r.pending = make(map[uint32][]byte)
+	for seq := uint32(100); len(r.pending) < reorderWindow; seq++ {
+		r.pending[seq] = nil
+	}
+	if _, err := r.push(2, nil, now); err != errReorderOverflow {
+		t.Fatalf("a full window: got %v, want %v", err, errReorderOverflow)
+	}
+}
+
+func TestFrameSeq(t *testing.T) {
+	buf := make([]byte, PKG_SEQ_END+1)
+	buf[PKG_SEQ_END] = 'a'
+	var s sequence
+	s.stamp(buf)
+	s.stamp(buf)
+	seq, data, ok := frameSeq(buf)
+	if !ok || seq != 2 || string(data) != "a" {
+		t.Fatalf("got %d %q %t", seq, data, ok)
+	}
+	if _, _, ok := frameSeq(buf[:PKG_SEQ_END]); ok {
+		t.Fatal("a frame without data read")
+	}
+}
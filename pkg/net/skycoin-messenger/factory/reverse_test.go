@@ -0,0 +1,148 @@
+package factory
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+func TestReverseConnRelayed(t *testing.T) {
+	f := NewMessengerFactory()
+	nodeA, nodeB := cipher.PubKey([33]byte{0x0a}), cipher.PubKey([33]byte{0x0b})
+	connA, fakeA := newFakeConnection(f, "203.0.113.1:4000")
+	connA.SetKey(nodeA)
+	connB, fakeB := newFakeConnection(f, "198.51.100.1:5000")
+	connB.SetKey(nodeB)
+
+	req := &reverseConn{Node: nodeB, App: cipher.PubKey([33]byte{0x02}), FromApp: cipher.PubKey([33]byte{0x01}), FromNode: nodeA}
+	// node B is not connected to the discovery
+	if _, err := req.Execute(f, connA); err != nil {
+		t.Fatal(err)
+	}
+	f.regConnections[nodeB] = connB
+	// only node A asks for its own transports
+	other := *req
+	other.FromNode = nodeB
+	if _, err := other.Execute(f, connA); err == nil {
+		t.Fatal("a reversal for another node relayed")
+	}
+	if len(fakeA.written) != 0 || len(fakeB.written) != 0 {
+		t.Fatalf("written %x, %x", fakeA.written, fakeB.written)
+	}
+
+	if _, err := req.Execute(f, connA); err != nil {
+		t.Fatal(err)
+	}
+	if len(fakeB.written) != 1 || fakeB.written[0][MSG_OP_BEGIN] != OP_REVERSE_CONN|RESP_PREFIX {
+		t.Fatalf("written %x", fakeB.written)
+	}
+	var relayed reverseConn
+	if err := json.Unmarshal(fakeB.written[0][MSG_HEADER_END:], &relayed); err != nil {
+		t.Fatal(err)
+	}
+	// node B learns the public endpoint of node A from the discovery
+	if relayed.Address != "203.0.113.1:4000" || relayed.FromNode != nodeA || relayed.FromApp != req.FromApp || relayed.App != req.App {
+		t.Fatalf("relayed %+v", relayed)
+	}
+}
+
+func TestRequestReversal(t *testing.T) {
+	f := NewMessengerFactory()
+	discovery, fake := newFakeConnection(f, "192.0.2.1:5999")
+	holder := newTestConnection()
+	tr := &Transport{
+		FromNode:      cipher.PubKey([33]byte{0x0a}),
+		ToNode:        cipher.PubKey([33]byte{0x0b}),
+		FromApp:       cipher.PubKey([33]byte{0x01}),
+		ToApp:         cipher.PubKey([33]byte{0x02}),
+		factory:       NewMessengerFactory(),
+		appConnHolder: holder,
+		discoveryConn: discovery,
+	}
+	tr.requestReversal(0)
+	if len(fake.written) != 1 || fake.written[0][MSG_OP_BEGIN] != OP_REVERSE_CONN {
+		t.Fatalf("written %x", fake.written)
+	}
+	var req reverseConn
+	if err := json.Unmarshal(fake.written[0][MSG_HEADER_END:], &req); err != nil {
+		t.Fatal(err)
+	}
+	if req.Node != tr.ToNode || req.App != tr.ToApp || req.FromNode != tr.FromNode || req.FromApp != tr.FromApp || len(req.Address) > 0 {
+		t.Fatalf("request %+v", req)
+	}
+
+	// the response of node B arrived in time
+	holder.appTransports = map[cipher.PubKey]*Transport{tr.ToApp: tr}
+	tr.requestReversal(0)
+	// the transport was closed meanwhile
+	holder.appTransports = nil
+	tr.factory = nil
+	tr.requestReversal(0)
+	if len(fake.written) != 1 {
+		t.Fatalf("written %x", fake.written)
+	}
+}
+
+func TestDialBack(t *testing.T) {
+	f := NewMessengerFactory()
+	conn, fake := newFakeConnection(f, "203.0.113.1:4000")
+	tr := &Transport{
+		FromNode: cipher.PubKey([33]byte{0x0a}),
+		ToNode:   cipher.PubKey([33]byte{0x0b}),
+		FromApp:  cipher.PubKey([33]byte{0x01}),
+		ToApp:    cipher.PubKey([33]byte{0x02}),
+	}
+	if err := tr.dialBack("203.0.113.1:4000"); err == nil {
+		t.Fatal("a closed transport dialed back")
+	}
+	tr.conn = conn
+	// a nat mapping every destination to another port
+	if err := tr.dialBack("203.0.113.1:4001"); err == nil {
+		t.Fatal("dialed back to a changed endpoint")
+	}
+	if err := tr.dialBack("203.0.113.1:4000"); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.written) != 1 || fake.written[0][MSG_OP_BEGIN] != OP_BUILD_APP_CONN_OK {
+		t.Fatalf("written %x", fake.written)
+	}
+	var resp buildConnResp
+	if err := json.Unmarshal(fake.written[0][MSG_HEADER_END:], &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.FromNode != tr.FromNode || resp.Node != tr.ToNode || resp.FromApp != tr.FromApp || resp.App != tr.ToApp {
+		t.Fatalf("response %+v", resp)
+	}
+
+	// node A acked meanwhile
+	tr.connAcked = true
+	if err := tr.dialBack("203.0.113.1:4000"); err != nil || len(fake.written) != 1 {
+		t.Fatalf("dialed back after the ack: %v, written %x", err, fake.written)
+	}
+}
+
+func TestPendingTransports(t *testing.T) {
+	f := NewMessengerFactory()
+	from, fromApp, app := cipher.PubKey([33]byte{0x0a}), cipher.PubKey([33]byte{0x01}), cipher.PubKey([33]byte{0x02})
+	tr := &Transport{FromNode: from, FromApp: fromApp, ToApp: app}
+	f.setPendingTransport(tr)
+	if got, ok := f.getPendingTransport(from, fromApp, app); !ok || got != tr {
+		t.Fatalf("got %p %v", got, ok)
+	}
+	if _, ok := f.getPendingTransport(from, app, fromApp); ok {
+		t.Fatal("found by other apps")
+	}
+
+	// a newer transport of the same apps replaced it, the old one does not remove it
+	newer := &Transport{FromNode: from, FromApp: fromApp, ToApp: app}
+	f.setPendingTransport(newer)
+	f.deletePendingTransport(tr)
+	if got, ok := f.getPendingTransport(from, fromApp, app); !ok || got != newer {
+		t.Fatalf("got %p %v", got, ok)
+	}
+	f.deletePendingTransport(newer)
+	if _, ok := f.getPendingTransport(from, fromApp, app); ok {
+		t.Fatal("not deleted")
+	}
+}
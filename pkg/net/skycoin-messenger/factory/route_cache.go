@@ -0,0 +1,150 @@
+package factory
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+// RouteCacheConfig of the answers of the discoveries to the transports of node A,
+// so that apps redialing the same app skip the discoveries that can not reach it
+type RouteCacheConfig struct {
+	// answers kept, 0 to disable the cache
+	Size int
+	// a discovery that reached the app is asked alone for this long
+	TTL time.Duration
+	// a discovery that did not find or was refused the app is not asked for this long
+	NegativeTTL time.Duration
+}
+
+var DefaultRouteCacheConfig = RouteCacheConfig{
+	Size:        256,
+	TTL:         time.Minute,
+	NegativeTTL: 10 * time.Second,
+}
+
+type routeKey struct {
+	discovery, node, app cipher.PubKey
+}
+
+type route struct {
+	key     routeKey
+	failed  bool
+	msg     PriorityMsg
+	expires time.Time
+	// from the start of the setup to the answer of the discovery
+	setup time.Duration
+}
+
+type routeCache struct {
+	config  RouteCacheConfig
+	entries map[routeKey]*list.Element
+	lru     *list.List
+	sync.Mutex
+}
+
+// SetRouteCache sets the cache of the answers of the discoveries, the cached answers are dropped
+func (f *MessengerFactory) SetRouteCache(config RouteCacheConfig) {
+	c := &f.routes
+	c.Lock()
+	c.config = config
+	c.entries = make(map[routeKey]*list.Element)
+	c.lru = list.New()
+	c.Unlock()
+}
+
+func (c *routeCache) get(key routeKey) (r route, ok bool) {
+	c.Lock()
+	defer c.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return
+	}
+	r = e.Value.(route)
+	if time.Now().After(r.expires) {
+		c.lru.Remove(e)
+		delete(c.entries, key)
+		ok = false
+		return
+	}
+	c.lru.MoveToFront(e)
+	return
+}
+
+// put keeps the answer of the discovery, the least recently used one goes if the cache is full
+func (c *routeCache) put(key routeKey, failed bool, msg PriorityMsg, setup time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+	if c.config.Size < 1 {
+		return
+	}
+	ttl := c.config.TTL
+	if failed {
+		ttl = c.config.NegativeTTL
+	}
+	if ttl <= 0 {
+		return
+	}
+	r := route{key: key, failed: failed, msg: msg, expires: time.Now().Add(ttl), setup: setup}
+	if e, ok := c.entries[key]; ok {
+		e.Value = r
+		c.lru.MoveToFront(e)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(r)
+	for c.lru.Len() > c.config.Size {
+		e := c.lru.Back()
+		c.lru.Remove(e)
+		delete(c.entries, e.Value.(route).key)
+	}
+}
+
+// CachedRoute is an answer of a discovery to a transport of node A kept by the route cache
+type CachedRoute struct {
+	Discovery string `json:"discovery"`
+	Node      string `json:"node"`
+	App       string `json:"app"`
+	// the discovery did not find or was refused the app
+	Failed bool `json:"failed,omitempty"`
+	// from the start of the setup to the answer of the discovery
+	Setup   int64 `json:"setup_ms"`
+	Expires int64 `json:"expires"`
+}
+
+// GetRoutes returns the answers of the discoveries in the route cache, the most recently used first
+func (f *MessengerFactory) GetRoutes() (routes []CachedRoute) {
+	c := &f.routes
+	c.Lock()
+	defer c.Unlock()
+	if c.lru == nil {
+		return
+	}
+	now := time.Now()
+	for e := c.lru.Front(); e != nil; e = e.Next() {
+		r := e.Value.(route)
+		if now.After(r.expires) {
+			continue
+		}
+		routes = append(routes, CachedRoute{
+			Discovery: r.key.discovery.Hex(),
+			Node:      r.key.node.Hex(),
+			App:       r.key.app.Hex(),
+			Failed:    r.failed,
+			Setup:     int64(r.setup / time.Millisecond),
+			Expires:   r.expires.Unix(),
+		})
+	}
+	return
+}
+
+// forget drops the answer, e.g. when the transport it led to failed
+func (c *routeCache) forget(key routeKey) {
+	c.Lock()
+	defer c.Unlock()
+	if e, ok := c.entries[key]; ok {
+		c.lru.Remove(e)
+		delete(c.entries, key)
+	}
+}
@@ -0,0 +1,56 @@
+package factory_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skycoin/skycoin/src/cipher"
+	"github.com/skycoin/skywire/pkg/net/skycoin-messenger/factory"
+	"github.com/skycoin/skywire/pkg/node/nodetest"
+)
+
+func TestRouteCache(t *testing.T) {
+	e := nodetest.NewEnv(t, 1)
+	defer e.Close()
+	a, b := e.StartNode("a"), e.StartNode("b")
+	a.SetRouteCache(factory.RouteCacheConfig{Size: 1, TTL: time.Minute, NegativeTTL: time.Minute})
+	server := e.ConnectApp(b, "server")
+	server.Offer("127.0.0.1:1", "cache")
+	client := e.ConnectApp(a, "client")
+	unknown := cipher.PubKey{0x02, 1}
+
+	// the app is answered without the discovery that did not find the node
+	first := client.Dial(unknown, server.GetKey(), e.DiscoveryKey(0))
+	resp := client.Dial(unknown, server.GetKey(), e.DiscoveryKey(0))
+	if !first.Failed || strings.HasSuffix(first.Msg.Msg, "(cached)") {
+		t.Fatalf("first answer %#v", first)
+	}
+	if !resp.Failed || resp.Msg.Priority != first.Msg.Priority || resp.Msg.Msg != first.Msg.Msg+" (cached)" {
+		t.Fatalf("cached answer %#v", resp)
+	}
+	routes := a.GetRoutes()
+	if len(routes) != 1 || !routes[0].Failed || routes[0].Node != unknown.Hex() || routes[0].Discovery != e.DiscoveryKey(0).Hex() {
+		t.Fatalf("routes %+v", routes)
+	}
+
+	// the route that reached the app takes the place of the least recently used one
+	client.Connect(b.Key, server.GetKey(), e.DiscoveryKey(0))
+	// the answer of the discovery may come after the one of node B
+	nodetest.WaitFor(t, "the route to the app", func() bool {
+		routes = a.GetRoutes()
+		return len(routes) == 1 && routes[0].Node == b.Key.Hex()
+	})
+	if routes[0].Failed || routes[0].App != server.GetKey().Hex() {
+		t.Fatalf("routes %+v", routes)
+	}
+	if resp = client.Dial(unknown, server.GetKey(), e.DiscoveryKey(0)); strings.HasSuffix(resp.Msg.Msg, "(cached)") {
+		t.Fatalf("answer of an evicted route %#v", resp)
+	}
+
+	// the answers are dropped with the cache
+	a.SetRouteCache(factory.RouteCacheConfig{})
+	if routes = a.GetRoutes(); len(routes) != 0 {
+		t.Fatalf("routes without a cache %+v", routes)
+	}
+}
@@ -0,0 +1,91 @@
+package factory
+
+import (
+	"fmt"
+
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+// RouteConstraints of a transport set by the app, so that its transports do not all
+// go through one discovery that could correlate them
+type RouteConstraints struct {
+	// the transport shares no discovery with the transports (and standbys) of the app to these apps
+	DisjointFrom []cipher.PubKey `json:",omitempty" wire:"1"`
+	// hops of the route that differ from the previous transport to the same app. The routes
+	// of this tree have a single hop, the discovery, so only 0 and 1 can be satisfied
+	MinChangedHops int `json:",omitempty" wire:"2"`
+}
+
+// routeHops is the number of relays of a route, node A and node B only meet at the discovery
+const routeHops = 1
+
+// setPreviousDiscovery records the discovery of the last transport to the app
+func (c *Connection) setPreviousDiscovery(app, discovery cipher.PubKey) {
+	c.appTransportsMutex.Lock()
+	if c.appDiscoveries == nil {
+		c.appDiscoveries = make(map[cipher.PubKey]cipher.PubKey)
+	}
+	c.appDiscoveries[app] = discovery
+	c.appTransportsMutex.Unlock()
+}
+
+func (c *Connection) getPreviousDiscovery(app cipher.PubKey) (discovery cipher.PubKey, ok bool) {
+	c.appTransportsMutex.RLock()
+	discovery, ok = c.appDiscoveries[app]
+	c.appTransportsMutex.RUnlock()
+	return
+}
+
+// avoided returns the discoveries the transport of conn to app must not use
+func (rc *RouteConstraints) avoided(conn *Connection, app cipher.PubKey) (avoid map[cipher.PubKey]string, err error) {
+	avoid = make(map[cipher.PubKey]string)
+	if rc == nil {
+		return
+	}
+	if rc.MinChangedHops > routeHops {
+		err = fmt.Errorf("%d changed hops asked, routes have %d", rc.MinChangedHops, routeHops)
+		return
+	}
+	if rc.MinChangedHops > 0 {
+		if d, ok := conn.getPreviousDiscovery(app); ok {
+			avoid[d] = "previous transport"
+		}
+	}
+	// the discovery keys are read without holding the transports of conn locked
+	var trs []*Transport
+	conn.appTransportsMutex.RLock()
+	for _, k := range rc.DisjointFrom {
+		if tr, ok := conn.appTransports[k]; ok {
+			trs = append(trs, tr)
+		}
+		if tr, ok := conn.appStandbys[k]; ok {
+			trs = append(trs, tr)
+		}
+	}
+	conn.appTransportsMutex.RUnlock()
+	for _, tr := range trs {
+		avoid[tr.getDiscoveryKey()] = fmt.Sprintf("transport to app %x", tr.ToApp)
+	}
+	return
+}
+
+// filter returns the discoveries the transport of conn to app may go through
+func (rc *RouteConstraints) filter(conn *Connection, discoveries []*Connection, app cipher.PubKey) (allowed []*Connection, err error) {
+	avoid, err := rc.avoided(conn, app)
+	if err != nil {
+		return
+	}
+	var causes []string
+	for _, d := range discoveries {
+		key := d.GetTargetKey()
+		if cause, ok := avoid[key]; ok {
+			causes = append(causes, fmt.Sprintf("discovery %x used by %s", key, cause))
+			continue
+		}
+		allowed = append(allowed, d)
+	}
+	if len(allowed) == 0 && len(causes) > 0 {
+		err = fmt.Errorf("no discovery satisfies the route constraints: %v", causes)
+	}
+	return
+}
@@ -0,0 +1,44 @@
+package factory_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/skycoin/skycoin/src/cipher"
+	"github.com/skycoin/skywire/pkg/net/skycoin-messenger/factory"
+	"github.com/skycoin/skywire/pkg/node/nodetest"
+)
+
+func TestRouteConstraints(t *testing.T) {
+	e := nodetest.NewEnv(t, 2)
+	defer e.Close()
+	a, b := e.StartNode("a"), e.StartNode("b")
+	first, second := e.ConnectApp(b, "first"), e.ConnectApp(b, "second")
+	first.Offer("127.0.0.1:1", "first")
+	second.Offer("127.0.0.1:2", "second")
+	client := e.ConnectApp(a, "client")
+	refused := func(name string, resp factory.AppConnResp, cause string) {
+		if !resp.Failed || resp.Msg.Priority != factory.NotAllowed || !strings.Contains(resp.Msg.Msg, cause) {
+			t.Fatalf("%s: %#v", name, resp)
+		}
+	}
+
+	// the discoveries of a test share their transport pairs, the setup is not raced through both
+	used := client.Connect(b.Key, first.GetKey(), e.DiscoveryKey(0)).Discovery
+	disjoint := &factory.RouteConstraints{DisjointFrom: []cipher.PubKey{first.GetKey()}}
+	resp := client.DialWithOptions(b.Key, second.GetKey(), used, factory.AppDialOptions{Constraints: disjoint})
+	refused("through the discovery of the other transport", resp, "used by transport to app")
+	resp = client.ConnectWithOptions(b.Key, second.GetKey(), cipher.PubKey{}, factory.AppDialOptions{Constraints: disjoint})
+	if resp.Discovery == used {
+		t.Fatalf("transport through the discovery %x of the other transport", used)
+	}
+
+	// the previous transport to the app went through the other discovery
+	changed := &factory.RouteConstraints{MinChangedHops: 1}
+	resp = client.DialWithOptions(b.Key, second.GetKey(), resp.Discovery, factory.AppDialOptions{Constraints: changed})
+	refused("through the previous discovery", resp, "used by previous transport")
+	// the routes have a single hop
+	changed.MinChangedHops = 2
+	resp = client.DialWithOptions(b.Key, second.GetKey(), cipher.PubKey{}, factory.AppDialOptions{Constraints: changed})
+	refused("more hops than the route has", resp, "2 changed hops asked")
+}
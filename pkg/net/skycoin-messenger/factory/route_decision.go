@@ -0,0 +1,297 @@
+package factory
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+// route decisions kept to be explained, the older ones are only in the debug log
+const routeDecisions = 256
+
+// RouteCandidate is a discovery a transport of node A could be set up through, with what node
+// A knew of it when it chose
+type RouteCandidate struct {
+	Discovery string `json:"discovery"`
+	// the route cache holds that the discovery reached the app lately
+	Cached bool `json:"cached,omitempty"`
+	// the route cache holds that the discovery refused the app lately
+	Refused bool `json:"refused,omitempty"`
+	// the last transport to the node went through the discovery and no setup failed since
+	Preferred bool `json:"preferred,omitempty"`
+	// how long the setup that reached the app lately took
+	Setup int64 `json:"setup_ms,omitempty"`
+	// the routing policy ruled the discovery out, else scored it
+	Filtered bool    `json:"filtered,omitempty"`
+	Score    float64 `json:"score,omitempty"`
+	Hops     int     `json:"hops"`
+	// how long the setups through the discovery took lately, 0 if none reached its app
+	Latency    int64   `json:"latency_ms,omitempty"`
+	Load       int     `json:"load"`
+	Reputation float64 `json:"reputation"`
+}
+
+func (c *RouteCandidate) path() Path {
+	key, _ := cipher.PubKeyFromHex(c.Discovery)
+	return Path{
+		Discovery:  key,
+		Reached:    c.Cached,
+		Setup:      time.Duration(c.Setup) * time.Millisecond,
+		Preferred:  c.Preferred,
+		Hops:       c.Hops,
+		Latency:    time.Duration(c.Latency) * time.Millisecond,
+		Load:       c.Load,
+		Reputation: c.Reputation,
+	}
+}
+
+// RouteDecision holds the inputs of the choice of the discoveries a transport of node A was
+// set up through and what was chosen, the choice is replayed from them by ExplainRoute
+type RouteDecision struct {
+	// the setup id of the transports
+	ID   string `json:"id"`
+	Time int64  `json:"time"`
+	Node string `json:"node"`
+	App  string `json:"app"`
+	// the routing policy of the node scored the candidates
+	Policy bool `json:"policy,omitempty"`
+	// the weights of the cost, nil when the transport was set up through all the discoveries
+	// or a policy chose
+	Cost *PathCost `json:"cost,omitempty"`
+	// the app sent the weights with its setup
+	AppCost bool `json:"app_cost,omitempty"`
+	// the discoveries the app allowed
+	Candidates []RouteCandidate `json:"candidates"`
+	// in [0, 1), picks one of the discoveries that reached the app about as fast
+	Draw   float64  `json:"draw"`
+	Chosen []string `json:"chosen"`
+	// none was chosen, the app was answered the refusal this discovery gave before
+	Refused string `json:"refused,omitempty"`
+}
+
+// RouteExplanation is a decision replayed, Steps tells why the discoveries were chosen
+type RouteExplanation struct {
+	Decision RouteDecision `json:"decision"`
+	Steps    []string      `json:"steps"`
+	// chosen by the replay, always the same as the decision
+	Replayed []string `json:"replayed"`
+}
+
+type routeDecisionLog struct {
+	last []RouteDecision
+	sync.Mutex
+}
+
+func (l *routeDecisionLog) add(d RouteDecision) {
+	l.Lock()
+	l.last = append(l.last, d)
+	if len(l.last) > routeDecisions {
+		l.last = l.last[len(l.last)-routeDecisions:]
+	}
+	l.Unlock()
+}
+
+func (l *routeDecisionLog) get(id string) (d RouteDecision, ok bool) {
+	l.Lock()
+	defer l.Unlock()
+	for i := len(l.last) - 1; i >= 0; i-- {
+		if l.last[i].ID == id {
+			return l.last[i], true
+		}
+	}
+	return
+}
+
+// explainer collects the steps of a decision, nil while the transport is set up
+type explainer []string
+
+func (e *explainer) step(format string, a ...interface{}) {
+	if e != nil {
+		*e = append(*e, fmt.Sprintf(format, a...))
+	}
+}
+
+// decideRoute chooses the candidates the transport is set up through. The ones that refused the
+// app lately are left out, refused is the last of them or -1. With a routing policy the ones of
+// the highest score it gave are asked. Else one of the ones that reached the app lately is asked
+// alone, else the one of the last transport to the node, else all the others without weights or
+// the ones of the lowest cost. It only reads the decision, so replaying it chooses what it chose
+func decideRoute(d *RouteDecision, why *explainer) (chosen []int, refused int) {
+	refused = -1
+	candidates, w := d.Candidates, d.Cost
+	var ask, reached []int
+	for i, c := range candidates {
+		switch {
+		case c.Refused:
+			why.step("%s refused the app lately, it is not asked", c.Discovery)
+			refused = i
+		case d.Policy && c.Filtered:
+			why.step("%s is ruled out by the routing policy", c.Discovery)
+		case d.Policy:
+			ask = append(ask, i)
+		case c.Cached:
+			reached = append(reached, i)
+		default:
+			ask = append(ask, i)
+		}
+	}
+	if d.Policy {
+		return bestScores(candidates, ask, why), refused
+	}
+	if len(reached) > 0 {
+		return []int{pickSpread(candidates, reached, d.Draw, why)}, -1
+	}
+	if len(ask) > 1 {
+		for _, i := range ask {
+			if candidates[i].Preferred {
+				why.step("%s is asked alone, the last transport to the node went through it", candidates[i].Discovery)
+				return []int{i}, refused
+			}
+		}
+	}
+	if w == nil {
+		chosen = ask
+		if len(chosen) > 0 {
+			why.step("no path cost is set, the %d discoveries are asked at once", len(chosen))
+		}
+		return
+	}
+	paths := make([]Path, len(ask))
+	for j, i := range ask {
+		c := &candidates[i]
+		paths[j] = c.path()
+		latency := paths[j].Latency
+		if latency <= 0 {
+			latency = pathLatencyUnknown
+			why.step("%s reached no app lately, its latency counts as %v", c.Discovery, latency)
+		}
+		why.step("%s costs %g: hops %d*%d + latency %d*%.1f + load %d*%d - reputation %d*%.2f",
+			c.Discovery, w.Cost(paths[j]),
+			w.Hops, c.Hops, w.Latency, latency.Seconds()*10, w.Load, c.Load, w.Reputation, c.Reputation)
+	}
+	for _, j := range rankPaths(paths, *w) {
+		chosen = append(chosen, ask[j])
+	}
+	if len(chosen) > 1 {
+		why.step("the %d of the lowest cost are asked at once", len(chosen))
+	} else if len(chosen) == 1 {
+		why.step("%s is asked alone, it has the lowest cost", candidates[chosen[0]].Discovery)
+	}
+	return
+}
+
+const (
+	// a discovery is about as fast as the fastest if it answered within this factor of its
+	// time, or within nearEqualSlack of it for the fast answers
+	nearEqualFactor = 1.5
+	nearEqualSlack  = 50 * time.Millisecond
+)
+
+// pickSpread picks one of the candidates that reached the app about as fast as the fastest, by
+// the draw with the weight 1/(1+transports through it), so that the transports spread over them
+// instead of all going through the fastest
+func pickSpread(candidates []RouteCandidate, reached []int, draw float64, why *explainer) int {
+	best := candidates[reached[0]].Setup
+	for _, i := range reached[1:] {
+		if candidates[i].Setup < best {
+			best = candidates[i].Setup
+		}
+	}
+	limit := time.Duration(float64(best) * nearEqualFactor * float64(time.Millisecond))
+	if slack := time.Duration(best)*time.Millisecond + nearEqualSlack; limit < slack {
+		limit = slack
+	}
+	var near []int
+	var weights []float64
+	var total float64
+	for _, i := range reached {
+		c := &candidates[i]
+		if time.Duration(c.Setup)*time.Millisecond > limit {
+			why.step("%s reached the app lately in %dms, slower than %v", c.Discovery, c.Setup, limit)
+			continue
+		}
+		near = append(near, i)
+		weights = append(weights, 1/float64(1+c.Load))
+		total += weights[len(weights)-1]
+	}
+	if len(near) == 1 {
+		why.step("%s is asked alone, it reached the app lately", candidates[near[0]].Discovery)
+		return near[0]
+	}
+	why.step("%d discoveries reached the app lately within %v, one is drawn with the weight 1/(1+load)", len(near), limit)
+	x := draw * total
+	for j, w := range weights {
+		if x < w || j == len(weights)-1 {
+			why.step("%s is asked alone, the draw %.3f picked it", candidates[near[j]].Discovery, draw)
+			return near[j]
+		}
+		x -= w
+	}
+	return near[len(near)-1]
+}
+
+// bestScores returns the candidates of the highest score of the routing policy
+func bestScores(candidates []RouteCandidate, ask []int, why *explainer) (chosen []int) {
+	var best float64
+	for _, i := range ask {
+		c := &candidates[i]
+		why.step("%s scores %g by the routing policy", c.Discovery, c.Score)
+		if len(chosen) == 0 || c.Score > best {
+			chosen, best = []int{i}, c.Score
+		} else if c.Score == best {
+			chosen = append(chosen, i)
+		}
+	}
+	if len(chosen) > 1 {
+		why.step("the %d of the highest score are asked at once", len(chosen))
+	} else if len(chosen) == 1 {
+		why.step("%s is asked alone, it has the highest score", candidates[chosen[0]].Discovery)
+	}
+	return
+}
+
+// recordRoute keeps the decision to be explained and writes it to the debug log
+func (f *MessengerFactory) recordRoute(conn *Connection, d RouteDecision) {
+	f.routeDecisions.add(d)
+	if b, err := json.Marshal(d); err == nil {
+		conn.GetContextLogger().WithField("setup_id", d.ID).Debugf("route decision %s", b)
+	}
+}
+
+// ExplainRoute replays the choice of the discoveries of the transports of a setup of node A
+// from its recorded inputs, id is the route of the transports
+func (f *MessengerFactory) ExplainRoute(id string) (e RouteExplanation, ok bool) {
+	d, ok := f.routeDecisions.get(id)
+	if !ok {
+		return
+	}
+	e.Decision = d
+	why := &explainer{}
+	switch {
+	case d.Policy:
+		why.step("the routing policy of the node scored the discoveries")
+	case d.Cost == nil:
+	case d.AppCost:
+		why.step("the app sent the weights of the path cost")
+	default:
+		why.step("the weights of the path cost are the ones of the node")
+	}
+	chosen, refused := decideRoute(&d, why)
+	for _, i := range chosen {
+		e.Replayed = append(e.Replayed, d.Candidates[i].Discovery)
+	}
+	switch {
+	case len(chosen) > 0:
+	case refused >= 0:
+		why.step("no discovery was asked, the app was answered the refusal of %s", d.Candidates[refused].Discovery)
+	case d.Policy:
+		why.step("no discovery was asked, the routing policy allowed none")
+	default:
+		why.step("no discovery was asked, the node was connected to none the app allowed")
+	}
+	e.Steps = *why
+	return
+}
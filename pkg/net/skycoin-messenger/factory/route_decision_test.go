@@ -0,0 +1,122 @@
+package factory
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestPickSpread(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		// setup in ms and load of the discoveries that reached the app
+		setup, load []int
+		draw        float64
+		picked      int
+	}{
+		{name: "one", setup: []int{100}, load: []int{5}, draw: 0.9, picked: 0},
+		{name: "much slower", setup: []int{1000, 100}, load: []int{0, 9}, draw: 0.1, picked: 1},
+		{name: "near, first drawn", setup: []int{100, 140}, load: []int{0, 0}, draw: 0.2, picked: 0},
+		{name: "near, second drawn", setup: []int{100, 140}, load: []int{0, 0}, draw: 0.7, picked: 1},
+		// the weights are 1 and 1/4, the first takes 0.8 of the draws
+		{name: "loaded, first drawn", setup: []int{100, 100}, load: []int{0, 3}, draw: 0.79, picked: 0},
+		{name: "loaded, second drawn", setup: []int{100, 100}, load: []int{0, 3}, draw: 0.81, picked: 1},
+		// fast answers are near within the slack rather than the factor
+		{name: "fast, within the slack", setup: []int{10, 55}, load: []int{1, 0}, draw: 0.9, picked: 1},
+		{name: "fast, beyond the slack", setup: []int{10, 70}, load: []int{1, 0}, draw: 0.9, picked: 0},
+	} {
+		var candidates []RouteCandidate
+		var reached []int
+		for i := range c.setup {
+			candidates = append(candidates, RouteCandidate{Cached: true, Setup: int64(c.setup[i]), Load: c.load[i]})
+			reached = append(reached, i)
+		}
+		if picked := pickSpread(candidates, reached, c.draw, nil); picked != c.picked {
+			t.Errorf("%s: picked %d, want %d", c.name, picked, c.picked)
+		}
+	}
+}
+
+func TestRouteSpread(t *testing.T) {
+	// the transports of node A spread over the discoveries that reached the app as fast, the
+	// more transports go through one the fewer it is drawn for
+	d := RouteDecision{Candidates: []RouteCandidate{
+		{Discovery: "a", Cached: true, Setup: 100},
+		{Discovery: "b", Cached: true, Setup: 110},
+		{Discovery: "slow", Cached: true, Setup: 900},
+		{Discovery: "other"},
+	}}
+	used := make(map[string]int)
+	for i := 0; i < 100; i++ {
+		// draws spread evenly over [0, 1) in no order
+		d.Draw = math.Mod(float64(i)*0.618034, 1)
+		chosen, refused := decideRoute(&d, nil)
+		if len(chosen) != 1 || refused != -1 {
+			t.Fatalf("chosen %v, refused %d", chosen, refused)
+		}
+		c := &d.Candidates[chosen[0]]
+		used[c.Discovery]++
+		c.Load++
+	}
+	if used["slow"] > 0 || used["other"] > 0 || used["a"] < 45 || used["b"] < 45 {
+		t.Fatalf("transports by discovery %v", used)
+	}
+}
+
+func TestReplayRecordedDecision(t *testing.T) {
+	cost := DefaultPathCost
+	for _, d := range []RouteDecision{
+		{ID: "cost", Cost: &cost, Candidates: []RouteCandidate{
+			{Discovery: "a", Hops: 1, Latency: 200, Load: 3, Reputation: 0.9},
+			{Discovery: "b", Hops: 1, Load: 0, Reputation: 0.5},
+			{Discovery: "refused", Refused: true},
+		}},
+		{ID: "app cost", Cost: &PathCost{Load: 1}, AppCost: true, Candidates: []RouteCandidate{
+			{Discovery: "a", Hops: 1, Load: 1},
+			{Discovery: "b", Hops: 1, Load: 1},
+		}},
+		{ID: "all at once", Candidates: []RouteCandidate{{Discovery: "a"}, {Discovery: "b"}}},
+		{ID: "preferred", Candidates: []RouteCandidate{{Discovery: "a"}, {Discovery: "b", Preferred: true}}},
+		{ID: "spread", Draw: 0.7, Candidates: []RouteCandidate{
+			{Discovery: "a", Cached: true, Setup: 100},
+			{Discovery: "b", Cached: true, Setup: 120, Load: 1},
+		}},
+		{ID: "policy", Policy: true, Candidates: []RouteCandidate{
+			{Discovery: "a", Score: 2},
+			{Discovery: "b", Score: 3},
+			{Discovery: "c", Filtered: true},
+		}},
+		{ID: "refused", Candidates: []RouteCandidate{{Discovery: "a", Refused: true}}},
+	} {
+		// recorded as choosePaths does
+		chosen, _ := decideRoute(&d, nil)
+		d.Chosen = []string{}
+		for _, i := range chosen {
+			d.Chosen = append(d.Chosen, d.Candidates[i].Discovery)
+		}
+		f := NewMessengerFactory()
+		f.routeDecisions.add(d)
+		ex, ok := f.ExplainRoute(d.ID)
+		if !ok || !reflect.DeepEqual(ex.Decision, d) || len(ex.Steps) == 0 {
+			t.Fatalf("%s: explanation %+v", d.ID, ex)
+		}
+		if len(ex.Replayed) != len(d.Chosen) || (len(d.Chosen) > 0 && !reflect.DeepEqual(ex.Replayed, d.Chosen)) {
+			t.Errorf("%s: replayed %v, chosen %v", d.ID, ex.Replayed, d.Chosen)
+		}
+		// the decision read back from the debug log replays into the same explanation
+		b, err := json.Marshal(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var logged RouteDecision
+		if err = json.Unmarshal(b, &logged); err != nil {
+			t.Fatal(err)
+		}
+		f = NewMessengerFactory()
+		f.routeDecisions.add(logged)
+		if again, _ := f.ExplainRoute(d.ID); !reflect.DeepEqual(again.Steps, ex.Steps) || !reflect.DeepEqual(again.Replayed, ex.Replayed) {
+			t.Errorf("%s: replayed from the log %q, was %q", d.ID, again.Steps, ex.Steps)
+		}
+	}
+}
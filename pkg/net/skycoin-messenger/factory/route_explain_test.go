@@ -0,0 +1,58 @@
+package factory_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/skycoin/skycoin/src/cipher"
+	"github.com/skycoin/skywire/pkg/net/skycoin-messenger/factory"
+	"github.com/skycoin/skywire/pkg/node/nodetest"
+)
+
+func TestExplainRoute(t *testing.T) {
+	e := nodetest.NewEnv(t, 1)
+	defer e.Close()
+	a, b := e.StartNode("a"), e.StartNode("b")
+	a.SetPathCost(&factory.DefaultPathCost)
+	server := e.ConnectApp(b, "server")
+	server.Offer("127.0.0.1:1", "explain")
+
+	// the first transport ranks the discovery by the weights of the node, the next one by the
+	// weights of its app
+	first := e.ConnectApp(a, "client").Connect(b.Key, server.GetKey(), cipher.PubKey{})
+	cost := factory.PathCost{Hops: 1}
+	second := e.ConnectApp(a, "other").ConnectWithOptions(b.Key, server.GetKey(), cipher.PubKey{}, factory.AppDialOptions{Cost: &cost})
+	for _, c := range []struct {
+		resp factory.AppConnResp
+		// the first step and the cost of the discovery
+		weights, cost string
+	}{
+		{first, "the weights of the path cost are the ones of the node", "hops 10*1 + latency 1*10.0"},
+		{second, "the app sent the weights of the path cost", "hops 1*1 + latency 0*"},
+	} {
+		ex, ok := a.ExplainRoute(c.resp.SetupID)
+		if !ok {
+			t.Fatalf("no decision for setup %s", c.resp.SetupID)
+		}
+		d := ex.Decision
+		if d.ID != c.resp.SetupID || d.Node != b.Key.Hex() || d.App != server.GetKey().Hex() || len(d.Candidates) != 1 {
+			t.Fatalf("decision %+v", d)
+		}
+		// the replay chooses what the setup chose, with the same explanation every time
+		if len(d.Chosen) != 1 || d.Chosen[0] != e.DiscoveryKey(0).Hex() || !reflect.DeepEqual(ex.Replayed, d.Chosen) {
+			t.Fatalf("replayed %v, chosen %v", ex.Replayed, d.Chosen)
+		}
+		last := len(ex.Steps) - 1
+		if last < 2 || ex.Steps[0] != c.weights || !strings.Contains(ex.Steps[last-1], c.cost) ||
+			!strings.HasSuffix(ex.Steps[last], "is asked alone, it has the lowest cost") {
+			t.Fatalf("steps %q", ex.Steps)
+		}
+		if again, _ := a.ExplainRoute(c.resp.SetupID); !reflect.DeepEqual(again, ex) {
+			t.Fatalf("explained again %+v, was %+v", again, ex)
+		}
+	}
+	if ex, ok := a.ExplainRoute("unknown"); ok {
+		t.Fatalf("explained an unknown setup %+v", ex)
+	}
+}
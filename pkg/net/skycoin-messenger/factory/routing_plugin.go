@@ -0,0 +1,26 @@
+//go:build (linux || darwin || freebsd) && cgo
+// +build linux darwin freebsd
+// +build cgo
+
+package factory
+
+import (
+	"fmt"
+	"plugin"
+)
+
+func loadRoutingPlugin(path, config string) (RoutingPolicy, error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	sym, err := p.Lookup("NewRoutingPolicy")
+	if err != nil {
+		return nil, err
+	}
+	fn, ok := sym.(func(string) (RoutingPolicy, error))
+	if !ok {
+		return nil, fmt.Errorf("routing policy plugin %s: NewRoutingPolicy is a %T, not a func(string) (factory.RoutingPolicy, error)", path, sym)
+	}
+	return fn(config)
+}
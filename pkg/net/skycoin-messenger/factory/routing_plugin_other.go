@@ -0,0 +1,10 @@
+//go:build !((linux || darwin || freebsd) && cgo)
+// +build !linux,!darwin,!freebsd !cgo
+
+package factory
+
+import "fmt"
+
+func loadRoutingPlugin(path, config string) (RoutingPolicy, error) {
+	return nil, fmt.Errorf("routing policy plugin %s: this build of the node can not load plugins, register the policy with RegisterRoutingPolicy", path)
+}
@@ -0,0 +1,132 @@
+package factory
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+// RoutingPolicy chooses the paths of the transports of the apps of node A in place of the
+// route cache, the peer store and the path cost. The paths ruled out by the route constraints of the app and
+// the discoveries that refused the app lately are not offered to it. The methods are called
+// while the transports are set up, they must not block
+type RoutingPolicy interface {
+	// FilterPaths returns the paths the transport may take, none refuses the transport
+	FilterPaths(paths []Path) []Path
+	// ScorePath scores a path left by FilterPaths, the transport is set up through the paths
+	// with the highest score at once and takes the first that reaches the app
+	ScorePath(p Path) float64
+	// OnRouteEstablished is called once the discovery of the path reached the app, setup is how
+	// long it took
+	OnRouteEstablished(p Path, setup time.Duration)
+}
+
+// NewRoutingPolicyFunc returns the policy of the config, a string the policy defines
+type NewRoutingPolicyFunc func(config string) (RoutingPolicy, error)
+
+var routingPolicies = struct {
+	policies map[string]NewRoutingPolicyFunc
+	sync.RWMutex
+}{policies: make(map[string]NewRoutingPolicyFunc)}
+
+// RegisterRoutingPolicy makes the policy loadable by its name, called from the init function
+// of the package of the policy compiled into the node. A name registered twice panics
+func RegisterRoutingPolicy(name string, fn NewRoutingPolicyFunc) {
+	routingPolicies.Lock()
+	defer routingPolicies.Unlock()
+	if _, ok := routingPolicies.policies[name]; ok {
+		panic(fmt.Sprintf("routing policy %s registered twice", name))
+	}
+	routingPolicies.policies[name] = fn
+}
+
+// RoutingPolicies returns the names of the registered policies
+func RoutingPolicies() (names []string) {
+	routingPolicies.RLock()
+	for name := range routingPolicies.policies {
+		names = append(names, name)
+	}
+	routingPolicies.RUnlock()
+	sort.Strings(names)
+	return
+}
+
+// LoadRoutingPolicy returns the registered policy of the name, or the policy of the Go plugin
+// of the file when the name ends with .so. The plugin exports
+//
+//	func NewRoutingPolicy(config string) (factory.RoutingPolicy, error)
+func LoadRoutingPolicy(name, config string) (RoutingPolicy, error) {
+	if strings.HasSuffix(name, ".so") {
+		return loadRoutingPlugin(name, config)
+	}
+	routingPolicies.RLock()
+	fn, ok := routingPolicies.policies[name]
+	routingPolicies.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown routing policy %s, the policies are %v or a plugin file", name, RoutingPolicies())
+	}
+	return fn(config)
+}
+
+// SetRoutingPolicy chooses the paths of the transports of the apps with p, nil for the route
+// cache, the peer store and the path cost
+func (f *MessengerFactory) SetRoutingPolicy(p RoutingPolicy) {
+	f.fieldsMutex.Lock()
+	f.routingPolicy = p
+	f.fieldsMutex.Unlock()
+}
+
+func (f *MessengerFactory) getRoutingPolicy() (p RoutingPolicy) {
+	f.fieldsMutex.RLock()
+	p = f.routingPolicy
+	f.fieldsMutex.RUnlock()
+	return
+}
+
+// scorePaths offers the policy the paths of the candidates that did not refuse the app lately,
+// and records which the policy ruled out and the scores of the others
+func scorePaths(policy RoutingPolicy, d *RouteDecision, node, app cipher.PubKey) {
+	var paths []Path
+	for i := range d.Candidates {
+		c := &d.Candidates[i]
+		if c.Refused {
+			continue
+		}
+		c.Filtered = true
+		p := c.path()
+		p.Node, p.App = node, app
+		paths = append(paths, p)
+	}
+	if len(paths) == 0 {
+		return
+	}
+	for _, p := range policy.FilterPaths(paths) {
+		for i := range d.Candidates {
+			c := &d.Candidates[i]
+			// the policy may return paths it was not offered
+			if c.Filtered && c.Discovery == p.Discovery.Hex() {
+				c.Filtered = false
+				c.Score = policy.ScorePath(p)
+			}
+		}
+	}
+}
+
+// routeEstablished tells the policy the discovery reached the app
+func (f *MessengerFactory) routeEstablished(discovery, node, app cipher.PubKey, setup time.Duration) {
+	policy := f.getRoutingPolicy()
+	if policy == nil {
+		return
+	}
+	policy.OnRouteEstablished(Path{
+		Discovery: discovery,
+		Node:      node,
+		App:       app,
+		Reached:   true,
+		Setup:     setup,
+	}, setup)
+}
@@ -0,0 +1,114 @@
+package factory
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+// scorePolicy allows the discoveries it scores, offered or not
+type scorePolicy struct {
+	scores      map[cipher.PubKey]float64
+	established []Path
+}
+
+func (p *scorePolicy) FilterPaths(paths []Path) (allowed []Path) {
+	for _, path := range paths {
+		if _, ok := p.scores[path.Discovery]; ok {
+			allowed = append(allowed, path)
+		}
+	}
+	// a path it was not offered is ignored
+	return append(allowed, Path{Discovery: cipher.PubKey([33]byte{0x04, 0xff})})
+}
+
+func (p *scorePolicy) ScorePath(path Path) float64 {
+	return p.scores[path.Discovery]
+}
+
+func (p *scorePolicy) OnRouteEstablished(path Path, setup time.Duration) {
+	p.established = append(p.established, path)
+}
+
+func TestRoutingPolicyChoice(t *testing.T) {
+	a, b, c := cipher.PubKey([33]byte{0x04, 1}), cipher.PubKey([33]byte{0x04, 2}), cipher.PubKey([33]byte{0x04, 3})
+	for _, s := range []struct {
+		name   string
+		scores map[cipher.PubKey]float64
+		// the candidates that refused the app lately
+		refused []cipher.PubKey
+		chosen  []cipher.PubKey
+	}{
+		{name: "highest score", scores: map[cipher.PubKey]float64{a: 1, b: 3, c: 2}, chosen: []cipher.PubKey{b}},
+		{name: "tie", scores: map[cipher.PubKey]float64{a: 2, b: 2, c: 1}, chosen: []cipher.PubKey{a, b}},
+		{name: "filtered", scores: map[cipher.PubKey]float64{c: 0}, chosen: []cipher.PubKey{c}},
+		{name: "refused lately", scores: map[cipher.PubKey]float64{a: 1, b: 3}, refused: []cipher.PubKey{b}, chosen: []cipher.PubKey{a}},
+		{name: "none allowed", scores: map[cipher.PubKey]float64{}},
+	} {
+		d := RouteDecision{Policy: true}
+		for _, k := range []cipher.PubKey{a, b, c} {
+			candidate := RouteCandidate{Discovery: k.Hex(), Cached: true}
+			for _, r := range s.refused {
+				candidate.Refused = candidate.Refused || r == k
+			}
+			d.Candidates = append(d.Candidates, candidate)
+		}
+		scorePaths(&scorePolicy{scores: s.scores}, &d, cipher.PubKey([33]byte{0x02, 1}), cipher.PubKey([33]byte{0x03, 1}))
+		chosen, _ := decideRoute(&d, nil)
+		var got, want []string
+		for _, i := range chosen {
+			got = append(got, d.Candidates[i].Discovery)
+		}
+		for _, k := range s.chosen {
+			want = append(want, k.Hex())
+		}
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("%s: chosen %v, want %v", s.name, got, want)
+		}
+	}
+}
+
+func TestLoadRoutingPolicy(t *testing.T) {
+	policy := &scorePolicy{}
+	RegisterRoutingPolicy("test-score", func(config string) (RoutingPolicy, error) {
+		return policy, nil
+	})
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("policy registered twice")
+			}
+		}()
+		RegisterRoutingPolicy("test-score", nil)
+	}()
+	names := RoutingPolicies()
+	found := false
+	for i, name := range names {
+		found = found || name == "test-score"
+		if i > 0 && names[i-1] > name {
+			t.Errorf("policies not sorted %v", names)
+		}
+	}
+	if !found {
+		t.Errorf("policies %v", names)
+	}
+	if _, err := LoadRoutingPolicy("unknown", ""); err == nil || !strings.Contains(err.Error(), "test-score") {
+		t.Errorf("unknown policy loaded: %v", err)
+	}
+	p, err := LoadRoutingPolicy("test-score", "")
+	if err != nil || p != policy {
+		t.Fatalf("policy %v: %v", p, err)
+	}
+
+	// the policy set on the factory hears of the routes established
+	f := NewMessengerFactory()
+	discovery := cipher.PubKey([33]byte{0x04, 1})
+	f.routeEstablished(discovery, cipher.PubKey{}, cipher.PubKey{}, time.Second)
+	f.SetRoutingPolicy(p)
+	f.routeEstablished(discovery, cipher.PubKey([33]byte{0x02, 1}), cipher.PubKey([33]byte{0x03, 1}), time.Second)
+	if len(policy.established) != 1 || policy.established[0].Discovery != discovery || !policy.established[0].Reached {
+		t.Fatalf("established %#v", policy.established)
+	}
+}
@@ -0,0 +1,69 @@
+package factory
+
+import (
+	"encoding/json"
+	"reflect"
+	"sync/atomic"
+
+	"github.com/skycoin/skywire/pkg/net/wire"
+)
+
+// setPeerSchema records the latest message schema the peer decodes, it never goes back
+func (c *Connection) setPeerSchema(version int) {
+	for {
+		old := atomic.LoadInt32(&c.peerSchema)
+		if int32(version) <= old || atomic.CompareAndSwapInt32(&c.peerSchema, old, int32(version)) {
+			return
+		}
+	}
+}
+
+// PeerSchema returns the latest message schema the peer decodes, 0 for JSON only
+func (c *Connection) PeerSchema() int {
+	return int(atomic.LoadInt32(&c.peerSchema))
+}
+
+func (c *Connection) setPeerFeatures(features Features) {
+	c.fieldsMutex.Lock()
+	c.peerFeatures = features
+	c.fieldsMutex.Unlock()
+}
+
+func (c *Connection) getPeerFeatures() (features Features) {
+	c.fieldsMutex.RLock()
+	features = c.peerFeatures
+	c.fieldsMutex.RUnlock()
+	return
+}
+
+// encodeBody encodes object with the binary schema if it has one and the peer decodes it
+func (c *Connection) encodeBody(object interface{}) ([]byte, error) {
+	if c.PeerSchema() > 0 && wire.Has(object) {
+		return wire.Marshal(object)
+	}
+	return json.Marshal(object)
+}
+
+// decodeBody decodes a body in either encoding, a peer sending the binary schema decodes it too
+func (c *Connection) decodeBody(body []byte, v interface{}) (err error) {
+	if !wire.Is(body) {
+		// v comes from a pool, fields left out by an older peer must not keep the values of the last message
+		if rv := reflect.ValueOf(v); rv.Kind() == reflect.Ptr && !rv.IsNil() {
+			rv.Elem().Set(reflect.Zero(rv.Elem().Type()))
+		}
+		return json.Unmarshal(body, v)
+	}
+	version, err := wire.MessageVersion(body)
+	if err != nil {
+		return
+	}
+	err = wire.Unmarshal(body, v)
+	if err != nil {
+		return
+	}
+	if version > wire.Version {
+		version = wire.Version
+	}
+	c.setPeerSchema(int(version))
+	return
+}
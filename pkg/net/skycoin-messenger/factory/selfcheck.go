@@ -0,0 +1,50 @@
+package factory
+
+import (
+	"unsafe"
+
+	"github.com/skycoin/skywire/pkg/net/conn"
+	"github.com/skycoin/skywire/pkg/net/msg"
+	"github.com/skycoin/skywire/pkg/net/util"
+	"github.com/skycoin/skywire/pkg/net/wire"
+)
+
+// SelfCheck checks on the platform it runs on that the 64-bit fields accessed with sync/atomic
+// are aligned and the binary schema decodes what it encodes, so a build for a 32-bit or
+// big-endian platform fails at startup instead of panicking or garbling messages later
+func SelfCheck() (err error) {
+	var f MessengerFactory
+	var c Connection
+	var tq transportQuota
+	var sg sendGuard
+	err = util.CheckAlign64(
+		util.Field64{Name: "MessengerFactory.guard.inProgress", Offset: unsafe.Offsetof(f.guard) + unsafe.Offsetof(f.guard.inProgress)},
+		util.Field64{Name: "MessengerFactory.guard.cookiesSent", Offset: unsafe.Offsetof(f.guard) + unsafe.Offsetof(f.guard.cookiesSent)},
+		util.Field64{Name: "MessengerFactory.guard.cookiesInvalid", Offset: unsafe.Offsetof(f.guard) + unsafe.Offsetof(f.guard.cookiesInvalid)},
+		util.Field64{Name: "MessengerFactory.guard.ipLimited", Offset: unsafe.Offsetof(f.guard) + unsafe.Offsetof(f.guard.ipLimited)},
+		util.Field64{Name: "MessengerFactory.guard.keyLimited", Offset: unsafe.Offsetof(f.guard) + unsafe.Offsetof(f.guard.keyLimited)},
+		util.Field64{Name: "MessengerFactory.queries.served", Offset: unsafe.Offsetof(f.queries) + unsafe.Offsetof(f.queries.served)},
+		util.Field64{Name: "MessengerFactory.queries.unauthenticated", Offset: unsafe.Offsetof(f.queries) + unsafe.Offsetof(f.queries.unauthenticated)},
+		util.Field64{Name: "MessengerFactory.queries.rateLimited", Offset: unsafe.Offsetof(f.queries) + unsafe.Offsetof(f.queries.rateLimited)},
+		util.Field64{Name: "MessengerFactory.queries.quotaExceeded", Offset: unsafe.Offsetof(f.queries) + unsafe.Offsetof(f.queries.quotaExceeded)},
+		util.Field64{Name: "sendGuard.forwarded", Offset: unsafe.Offsetof(sg.forwarded)},
+		util.Field64{Name: "sendGuard.rateLimited", Offset: unsafe.Offsetof(sg.rateLimited)},
+		util.Field64{Name: "sendGuard.dropped", Offset: unsafe.Offsetof(sg.dropped)},
+		util.Field64{Name: "sendGuard.evicted", Offset: unsafe.Offsetof(sg.evicted)},
+		util.Field64{Name: "sendGuard.queued", Offset: unsafe.Offsetof(sg.queued)},
+		util.Field64{Name: "Connection.connectTime", Offset: unsafe.Offsetof(c.connectTime)},
+		util.Field64{Name: "transportQuota.used", Offset: unsafe.Offsetof(tq.used)},
+	)
+	if err != nil {
+		return
+	}
+	err = conn.CheckAlign()
+	if err != nil {
+		return
+	}
+	err = msg.CheckAlign()
+	if err != nil {
+		return
+	}
+	return wire.SelfCheck()
+}
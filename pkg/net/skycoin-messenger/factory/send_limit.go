@@ -0,0 +1,236 @@
+package factory
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SendLimits of the messages a discovery forwards between its clients, the zero value writes
+// each message to its client from the connection of the sender, so a client that stops reading
+// holds up the senders
+type SendLimits struct {
+	// messages per second a client may send, 0 for no limit
+	Rate float64
+	// messages allowed at once above the rate
+	Burst int
+	// bytes queued for a client, the messages above are dropped, 0 for no queue
+	MaxQueued int
+	// a client that has not taken a message for this long is evicted when the next message to
+	// it comes, 0 to keep it
+	StallTimeout time.Duration
+	// bytes queued for all the clients, above it the client with the most queued is evicted,
+	// 0 for no limit
+	MaxTotalQueued int64
+}
+
+// SendStats counts the messages a discovery forwarded and the clients it evicted
+type SendStats struct {
+	Forwarded   uint64 `json:"forwarded"`
+	RateLimited uint64 `json:"rate_limited"`
+	// messages dropped as the queue of their client was full
+	Dropped uint64 `json:"dropped"`
+	// clients closed as they stalled or queued the most
+	Evicted uint64 `json:"evicted"`
+	// bytes queued now
+	Queued int64 `json:"queued"`
+}
+
+// sendGuard is allocated on its own, its first word is 64-bit aligned on 32-bit platforms
+type sendGuard struct {
+	// accessed atomically, first for their 64-bit alignment
+	forwarded   uint64
+	rateLimited uint64
+	dropped     uint64
+	evicted     uint64
+	queued      int64
+
+	limits SendLimits
+	rate   *rateLimiter
+	// the queues with messages, by their client
+	outboxes map[*Connection]*outbox
+
+	sync.Mutex
+}
+
+// outbox queues the messages to a client, written by a goroutine while it has any
+type outbox struct {
+	conn   *Connection
+	frames [][]byte
+	bytes  int
+	// since when the message being written waits for the client, zero if none
+	writing time.Time
+	running bool
+	closed  bool
+	sync.Mutex
+}
+
+// SetSendLimits enables the per client limits of the messages a discovery forwards
+func (f *MessengerFactory) SetSendLimits(l SendLimits) {
+	g := &sendGuard{
+		limits:   l,
+		rate:     newRateLimiter(l.Rate, l.Burst),
+		outboxes: make(map[*Connection]*outbox),
+	}
+	f.fieldsMutex.Lock()
+	f.sends = g
+	f.fieldsMutex.Unlock()
+}
+
+func (f *MessengerFactory) getSends() (g *sendGuard) {
+	f.fieldsMutex.RLock()
+	g = f.sends
+	f.fieldsMutex.RUnlock()
+	return
+}
+
+// GetSendStats returns the counters of the messages forwarded by the discovery, zero if it
+// does not limit them
+func (f *MessengerFactory) GetSendStats() (s SendStats) {
+	g := f.getSends()
+	if g == nil {
+		return
+	}
+	return SendStats{
+		Forwarded:   atomic.LoadUint64(&g.forwarded),
+		RateLimited: atomic.LoadUint64(&g.rateLimited),
+		Dropped:     atomic.LoadUint64(&g.dropped),
+		Evicted:     atomic.LoadUint64(&g.evicted),
+		Queued:      atomic.LoadInt64(&g.queued),
+	}
+}
+
+// forward writes the message m of from to the client to, through its queue if the discovery
+// has one
+func (f *MessengerFactory) forward(from, to *Connection, m []byte) (err error) {
+	g := f.getSends()
+	if g == nil {
+		return to.Write(m)
+	}
+	limits := g.limits
+	if !g.rate.allow(from.GetKey().Hex()) {
+		atomic.AddUint64(&g.rateLimited, 1)
+		return
+	}
+	if limits.MaxQueued <= 0 {
+		atomic.AddUint64(&g.forwarded, 1)
+		return to.Write(m)
+	}
+
+	now := time.Now()
+	// the outbox is only dropped from the map by its writer under both locks, so a message is
+	// never queued to an outbox without a writer
+	g.Lock()
+	o, ok := g.outboxes[to]
+	if !ok {
+		o = &outbox{conn: to}
+		g.outboxes[to] = o
+	}
+	o.Lock()
+	if limits.StallTimeout > 0 && !o.writing.IsZero() && now.Sub(o.writing) > limits.StallTimeout {
+		o.Unlock()
+		g.Unlock()
+		g.evict(o, "stalled")
+		return
+	}
+	if o.closed || o.bytes+len(m) > limits.MaxQueued {
+		o.Unlock()
+		g.Unlock()
+		atomic.AddUint64(&g.dropped, 1)
+		return
+	}
+	// the buffer of m is reused once it is forwarded
+	o.frames = append(o.frames, append([]byte(nil), m...))
+	o.bytes += len(m)
+	start := !o.running
+	o.running = true
+	o.Unlock()
+	g.Unlock()
+	atomic.AddUint64(&g.forwarded, 1)
+	if atomic.AddInt64(&g.queued, int64(len(m))) > limits.MaxTotalQueued && limits.MaxTotalQueued > 0 {
+		g.evictLargest()
+	}
+	if start {
+		go g.write(f, o)
+	}
+	return
+}
+
+// write writes the messages of the outbox until it is empty
+func (g *sendGuard) write(f *MessengerFactory, o *outbox) {
+	for {
+		g.Lock()
+		o.Lock()
+		if len(o.frames) == 0 || o.closed {
+			o.running = false
+			o.writing = time.Time{}
+			if g.outboxes[o.conn] == o {
+				delete(g.outboxes, o.conn)
+			}
+			o.Unlock()
+			g.Unlock()
+			return
+		}
+		g.Unlock()
+		m := o.frames[0]
+		o.frames[0] = nil
+		o.frames = o.frames[1:]
+		o.writing = time.Now()
+		o.Unlock()
+		err := o.conn.Write(m)
+		o.Lock()
+		o.bytes -= len(m)
+		o.Unlock()
+		atomic.AddInt64(&g.queued, -int64(len(m)))
+		if err != nil {
+			o.conn.GetContextLogger().Errorf("write forwarded message err %v", err)
+			f.countError(func(e *ServerErrors) { e.WriteFailed++ })
+			g.close(o)
+			o.conn.Close()
+		}
+	}
+}
+
+// close drops the messages of the outbox, false if it was closed already
+func (g *sendGuard) close(o *outbox) (open bool) {
+	o.Lock()
+	open = !o.closed
+	o.closed = true
+	var dropped int
+	for _, m := range o.frames {
+		dropped += len(m)
+	}
+	o.frames = nil
+	o.bytes -= dropped
+	o.Unlock()
+	atomic.AddInt64(&g.queued, -int64(dropped))
+	return
+}
+
+// evict closes the client of the outbox
+func (g *sendGuard) evict(o *outbox, why string) {
+	if !g.close(o) {
+		return
+	}
+	atomic.AddUint64(&g.evicted, 1)
+	o.conn.GetContextLogger().Warnf("evict client %x: %s", o.conn.GetKey(), why)
+	o.conn.Close()
+}
+
+// evictLargest evicts the client with the most bytes queued
+func (g *sendGuard) evictLargest() {
+	var largest *outbox
+	var most int
+	g.Lock()
+	for _, o := range g.outboxes {
+		o.Lock()
+		if !o.closed && o.bytes > most {
+			largest, most = o, o.bytes
+		}
+		o.Unlock()
+	}
+	g.Unlock()
+	if largest != nil {
+		g.evict(largest, "most messages queued")
+	}
+}
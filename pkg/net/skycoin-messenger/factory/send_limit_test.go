@@ -0,0 +1,129 @@
+package factory
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/skycoin/skycoin/src/cipher"
+	cn "github.com/skycoin/skywire/pkg/net/conn"
+	"github.com/skycoin/skywire/pkg/net/factory"
+)
+
+// stallConn is a client that takes no message until release is closed
+type stallConn struct {
+	cn.Connection
+	release chan struct{}
+	written int
+	sync.Mutex
+}
+
+func (c *stallConn) GetRemoteAddr() net.Addr { return &net.TCPAddr{} }
+
+func (c *stallConn) Write(b []byte) error {
+	<-c.release
+	c.Lock()
+	c.written++
+	c.Unlock()
+	return nil
+}
+
+func (c *stallConn) Close() {}
+
+func (c *stallConn) count() int {
+	c.Lock()
+	defer c.Unlock()
+	return c.written
+}
+
+func TestSendLimits(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		limits SendLimits
+		// the clients the messages of 8 bytes go to in turn, 0 or 1
+		to []int
+		// the clients take no message until the stats are checked
+		stalled bool
+		// the stall timeout passes before the last message
+		stall   bool
+		stats   SendStats
+		written [2]int
+	}{
+		{name: "no limits", to: []int{0, 0, 1}, stats: SendStats{Forwarded: 3}, written: [2]int{2, 1}},
+		{name: "rate", limits: SendLimits{Rate: 0.1, Burst: 3}, to: []int{0, 0, 0, 1, 1},
+			stats: SendStats{Forwarded: 3, RateLimited: 2}, written: [2]int{3}},
+		{name: "queued", limits: SendLimits{MaxQueued: 16}, to: []int{0, 0, 1}, stalled: true,
+			stats: SendStats{Forwarded: 3, Queued: 24}, written: [2]int{2, 1}},
+		{name: "queue full", limits: SendLimits{MaxQueued: 16}, to: []int{0, 0, 0, 1}, stalled: true,
+			stats: SendStats{Forwarded: 3, Dropped: 1, Queued: 24}, written: [2]int{2, 1}},
+		{name: "stalled", limits: SendLimits{MaxQueued: 64, StallTimeout: 50 * time.Millisecond}, to: []int{0, 0, 0}, stalled: true, stall: true,
+			stats: SendStats{Forwarded: 2, Evicted: 1, Queued: 8}, written: [2]int{1}},
+		{name: "most queued", limits: SendLimits{MaxQueued: 64, MaxTotalQueued: 20}, to: []int{0, 0, 1}, stalled: true,
+			stats: SendStats{Forwarded: 3, Evicted: 1, Queued: 16}, written: [2]int{1, 1}},
+	} {
+		f := NewMessengerFactory()
+		f.SetSendLimits(c.limits)
+		from := newSignedConnection(cipher.PubKey([33]byte{0x02, 1}))
+		var to [2]*Connection
+		var clients [2]*stallConn
+		release := make(chan struct{})
+		if !c.stalled {
+			close(release)
+		}
+		for i := range to {
+			clients[i] = &stallConn{Connection: &cn.TCPConn{ConnCommonFields: cn.NewConnCommonFileds()}, release: release}
+			to[i] = newTestConnection()
+			to[i].Connection = &factory.Connection{Connection: clients[i]}
+			to[i].factory = f
+			to[i].SetKey(cipher.PubKey([33]byte{0x03, byte(i)}))
+		}
+		for i, client := range c.to {
+			if c.stall && i == len(c.to)-1 {
+				time.Sleep(2 * c.limits.StallTimeout)
+			}
+			if err := f.forward(from, to[client], make([]byte, 8)); err != nil {
+				t.Fatalf("%s: %v", c.name, err)
+			}
+			// the writer of the client takes the first message
+			for c.stalled {
+				g := f.getSends()
+				g.Lock()
+				o := g.outboxes[to[client]]
+				g.Unlock()
+				if o == nil {
+					break
+				}
+				o.Lock()
+				taken := !o.writing.IsZero() || o.closed
+				o.Unlock()
+				if taken {
+					break
+				}
+				time.Sleep(time.Millisecond)
+			}
+		}
+		if s := f.GetSendStats(); s != c.stats {
+			t.Errorf("%s: stats %+v, want %+v", c.name, s, c.stats)
+		}
+		if c.stalled {
+			close(release)
+		}
+		for i := 0; i < 500 && f.GetSendStats().Queued > 0; i++ {
+			time.Sleep(time.Millisecond)
+		}
+		if written := [2]int{clients[0].count(), clients[1].count()}; written != c.written || f.GetSendStats().Queued != 0 {
+			t.Errorf("%s: written %v, want %v, %d bytes queued", c.name, written, c.written, f.GetSendStats().Queued)
+		}
+	}
+
+	// a discovery without limits writes the messages to the clients and counts none
+	f := NewMessengerFactory()
+	to, fake := newFakeConnection(f, "127.0.0.1:5000")
+	if err := f.forward(newTestConnection(), to, []byte("hello")); err != nil || len(fake.written) != 1 {
+		t.Fatalf("forwarded %q: %v", fake.written, err)
+	}
+	if s := f.GetSendStats(); s != (SendStats{}) {
+		t.Fatalf("stats %+v", s)
+	}
+}
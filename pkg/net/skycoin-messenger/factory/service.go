@@ -13,6 +13,8 @@ type Service struct {
 	HideFromDiscovery bool     `json:",omitempty"`
 	AllowNodes        []string `json:",omitempty"`
 	Version           string   `json:",omitempty"`
+	// token the transports to the service must be set up with, kept by the node of the app
+	Knock []byte `json:",omitempty"`
 }
 
 type NodeServices struct {
@@ -24,6 +26,14 @@ type NodeServices struct {
 	Location string `json:",omitempty"`
 	// Node version info
 	Version []string `json:",omitempty"`
+	// Node nat type, tells why direct transports to the node fail
+	NatType string `json:",omitempty"`
+	// Address mapped on the gateway of the node, reachable from outside its nat
+	ExternalAddress string `json:",omitempty"`
+	// Unix time the node sent the services at, 0 from nodes before it was added
+	Time int64 `json:",omitempty"`
+	// transports the node takes, nil if not limited
+	Capacity *Capacity `json:",omitempty"`
 }
 
 type serviceDiscovery struct {
@@ -52,6 +62,13 @@ func (sd *serviceDiscovery) pack() *NodeServices {
 	var ss []*Service
 	for _, value := range sd.subscription2Subscriber {
 		for _, service := range value.Services {
+			if len(service.Knock) > 0 {
+				// the discovery never learns the token nor lists the service
+				s := *service
+				s.Knock = nil
+				s.HideFromDiscovery = true
+				service = &s
+			}
 			ss = append(ss, service)
 		}
 	}
@@ -210,3 +227,22 @@ func (sd *serviceDiscovery) unRegisterService(key cipher.PubKey) (err error) {
 	}
 	return
 }
+
+// DiscoveryStore keeps the services the nodes register on a discovery, the discovery keeps
+// nothing without a store
+type DiscoveryStore interface {
+	RegisterService(key cipher.PubKey, ns *NodeServices) error
+	UnRegisterService(key cipher.PubKey) error
+	FindServiceAddresses(keys []cipher.PubKey, exclude cipher.PubKey) []*ServiceInfo
+	FindByAttributes(attrs ...string) *AttrNodesInfo
+	FindByAttributesAndPaging(page, limit int, attrs ...string) *AttrNodesInfo
+}
+
+// SetDiscoveryStore sets all the service hooks of the discovery to the store
+func (f *MessengerFactory) SetDiscoveryStore(s DiscoveryStore) {
+	f.RegisterService = s.RegisterService
+	f.UnRegisterService = s.UnRegisterService
+	f.FindServiceAddresses = s.FindServiceAddresses
+	f.FindByAttributes = s.FindByAttributes
+	f.FindByAttributesAndPaging = s.FindByAttributesAndPaging
+}
@@ -1,10 +1,10 @@
 package factory
 
 import (
+	"sync"
 	"testing"
 
 	"github.com/skycoin/skycoin/src/cipher"
-	"sync"
 )
 
 func newTestConnection() *Connection {
@@ -13,89 +13,107 @@ func newTestConnection() *Connection {
 	return connection
 }
 
-func TestRegisterAndFind(t *testing.T) {
+func TestRegisterAndUnregister(t *testing.T) {
 	conn1 := newTestConnection()
 	connkey1 := cipher.PubKey([33]byte{0x01})
 	key1 := cipher.PubKey([33]byte{0xf1})
 	subs1 := []*Service{{Key: key1, Attributes: []string{"vpn"}},
-		{Key: cipher.PubKey([33]byte{0xf2}), Attributes: []string{"vpn"}}}
+		{Key: cipher.PubKey([33]byte{0xf2}), Attributes: []string{"vpn"}},
+		{Key: key1, Attributes: []string{"ss"}}}
 	conn1.SetKey(connkey1)
 	service := newServiceDiscovery()
 	service.register(conn1, &NodeServices{Services: subs1})
 
-	var result []cipher.PubKey
-	result = service.find(key1)
-	if len(result) != 1 || result[0] != connkey1 {
-		t.Fatalf("len(result) != 1 || result[0] != connkey1 %v", result)
+	ns := conn1.GetServices()
+	if ns == nil || len(ns.Services) != 2 {
+		t.Fatalf("the services of a key are not kept once %v", ns)
 	}
-	resultOfAttrs := service.findByAttributes("vpn")
-	if len(resultOfAttrs) != 1 || result[0] != connkey1 {
-		t.Fatalf("len(result) != 1 || result[0] != connkey1 %v", result)
+	if len(service.subscription2Subscriber) != 1 {
+		t.Fatal(service.subscription2Subscriber)
 	}
 
 	conn2 := newTestConnection()
 	connkey2 := cipher.PubKey([33]byte{0x02})
-	key2 := cipher.PubKey([33]byte{0xa1})
-	subs2 := []*Service{{Key: key2, Attributes: []string{"ss"}},
-		{Key: key1, Attributes: []string{"ss"}}}
+	subs2 := []*Service{{Key: cipher.PubKey([33]byte{0xa1}), Attributes: []string{"ss"}}}
 	conn2.SetKey(connkey2)
-
 	service.register(conn2, &NodeServices{Services: subs2})
-
-	result = service.find(key1)
-	if len(result) != 2 {
-		t.Fatalf("len(result) != 2 %v", result)
+	if len(service.subscription2Subscriber) != 2 {
+		t.Fatal(service.subscription2Subscriber)
+	}
+	if ns := service.pack(); ns == nil || len(ns.Services) != 3 {
+		t.Fatalf("pack %v", ns)
 	}
-	resultOfAttrs = service.findByAttributes("a")
-	if len(resultOfAttrs) != 0 {
-		t.Fatalf("len(result) != 0 %v", result)
+
+	service.register(conn2, &NodeServices{})
+	if len(service.subscription2Subscriber) != 1 || conn2.GetServices() != nil {
+		t.Fatal(service.subscription2Subscriber)
 	}
-	resultOfAttrs = service.findByAttributes("vpn")
-	if len(resultOfAttrs) != 2 {
-		t.Fatalf("len(result) != 2 %v", result)
+	service.unregister(conn1)
+	if len(service.subscription2Subscriber) != 0 || conn1.GetServices() != nil {
+		t.Fatal(service.subscription2Subscriber)
 	}
-	resultOfAttrs = service.findByAttributes("ss")
-	if len(resultOfAttrs) != 2 {
-		t.Fatalf("len(result) != 2 %v", result)
+	if ns := service.pack(); ns != nil {
+		t.Fatalf("pack %v", ns)
 	}
 
 	conn3 := newTestConnection()
-	connkey3 := cipher.PubKey([33]byte{0x03})
-	subs3 := []*Service{
-		{Key: cipher.PubKey([33]byte{0xff}), Attributes: []string{"vpn"}}}
-	conn3.SetKey(connkey3)
+	service.register(conn3, &NodeServices{Services: subs2})
+	if len(service.subscription2Subscriber) != 0 {
+		t.Fatal("a connection without a key registered")
+	}
+}
 
-	service.register(conn3, &NodeServices{Services: subs3})
+func TestPackHidesKnock(t *testing.T) {
+	conn := newTestConnection()
+	conn.SetKey(cipher.PubKey([33]byte{0x01}))
+	service := newServiceDiscovery()
+	knocked := &Service{Key: cipher.PubKey([33]byte{0xf1}), Knock: []byte("token")}
+	service.register(conn, &NodeServices{Services: []*Service{knocked}})
 
-	resultOfAttrs = service.findByAttributes("vpn")
-	if len(resultOfAttrs) != 3 {
-		t.Fatalf("len(result) != 3 %v", result)
+	ns := service.pack()
+	if ns == nil || len(ns.Services) != 1 {
+		t.Fatalf("pack %v", ns)
 	}
-
-	resultOfAttrs = service.findByAttributes("vpn", "a")
-	if len(resultOfAttrs) != 0 {
-		t.Fatalf("len(result) != 0 %v", result)
+	if s := ns.Services[0]; len(s.Knock) != 0 || !s.HideFromDiscovery {
+		t.Fatalf("the discovery learns the knock of %v", s)
+	}
+	if len(knocked.Knock) == 0 || knocked.HideFromDiscovery {
+		t.Fatal("pack changed the service of the node")
 	}
+}
 
-	if len(service.subscription2Subscriber) != 4 {
-		t.Fatal(service.subscription2Subscriber)
+func TestDiscoveryRegister(t *testing.T) {
+	conn := newTestConnection()
+	connkey := cipher.PubKey([33]byte{0x01})
+	conn.SetKey(connkey)
+	registered := make(map[cipher.PubKey]*NodeServices)
+	service := newServiceDiscovery()
+	service.RegisterService = func(key cipher.PubKey, ns *NodeServices) error {
+		registered[key] = ns
+		return nil
 	}
-	service.unregister(conn3)
-	if len(service.subscription2Subscriber) != 3 {
-		t.Fatal(service.subscription2Subscriber)
+	service.UnRegisterService = func(key cipher.PubKey) error {
+		delete(registered, key)
+		return nil
 	}
-	service.unregister(conn2)
-	if len(service.subscription2Subscriber) != 2 {
-		t.Fatal(service.subscription2Subscriber)
+
+	key := cipher.PubKey([33]byte{0xf1})
+	service.discoveryRegister(conn, &NodeServices{Services: []*Service{{Key: key}, {Key: key}}})
+	if ns := registered[connkey]; ns == nil || len(ns.Services) != 1 {
+		t.Fatalf("registered %v", registered)
 	}
-	service.unregister(conn1)
-	if len(service.subscription2Subscriber) != 0 {
-		t.Fatal(service.subscription2Subscriber)
+	service.discoveryRegister(conn, &NodeServices{})
+	if len(registered) != 0 || conn.GetServices() != nil {
+		t.Fatalf("registered %v", registered)
+	}
+
+	if r := service.findByAttributes("vpn"); r != nil {
+		t.Fatalf("found %v without a store", r)
 	}
-	if len(service.attribute2Keys) != 0 {
-		t.Fatal(service.attribute2Keys)
+	service.FindByAttributes = func(attrs ...string) *AttrNodesInfo {
+		return &AttrNodesInfo{Count: int64(len(attrs))}
 	}
-	if len(service.key2Attributes) != 0 {
-		t.Fatal(service.key2Attributes)
+	if r := service.findByAttributes("vpn", "ss"); r == nil || r.Count != 2 {
+		t.Fatalf("found %v", r)
 	}
 }
@@ -0,0 +1,270 @@
+package factory
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+// SetupLimits of the transports node A sets up for its apps at once, the zero value sets up
+// every transport as soon as its app asks for it
+type SetupLimits struct {
+	// transports finding their route and connecting at once, 0 for no limit. The other
+	// setups wait for their turn, the apps are served in turn, and their timeouts start once
+	// they run.
+	MaxConcurrent int
+	// setups waiting for their turn, the ones above fail at once as busy, 0 for no limit
+	MaxQueued int
+}
+
+// SetupQueueStats counts the transport setups of node A held back by its SetupLimits
+type SetupQueueStats struct {
+	Running int `json:"running"`
+	Queued  int `json:"queued"`
+	// setups that waited for their turn
+	Waited uint64 `json:"waited"`
+	// setups failed as too many were waiting
+	Rejected uint64 `json:"rejected"`
+}
+
+// queuedSetup is a setup of a transport of the app of conn through the discovery
+type queuedSetup struct {
+	conn      *Connection
+	req       *appConn
+	discovery *Connection
+}
+
+type setupQueue struct {
+	limits  SetupLimits
+	running int
+	// the setups waiting by app, the apps with any served in turn
+	waiting map[*Connection][]*queuedSetup
+	apps    []*Connection
+	queued  int
+
+	waited   uint64
+	rejected uint64
+	sync.Mutex
+}
+
+// SetSetupLimits limits the transports the node sets up for its apps at once, the setups
+// waiting keep their turn when the limits change
+func (f *MessengerFactory) SetSetupLimits(l SetupLimits) {
+	f.fieldsMutex.Lock()
+	q := f.setups
+	if q == nil {
+		q = &setupQueue{waiting: make(map[*Connection][]*queuedSetup)}
+		f.setups = q
+	}
+	f.fieldsMutex.Unlock()
+	q.Lock()
+	q.limits = l
+	q.startWaiting(f)
+	q.Unlock()
+}
+
+func (f *MessengerFactory) getSetups() (q *setupQueue) {
+	f.fieldsMutex.RLock()
+	q = f.setups
+	f.fieldsMutex.RUnlock()
+	return
+}
+
+// GetSetupQueueStats returns the transport setups running and waiting, zero without limits
+func (f *MessengerFactory) GetSetupQueueStats() (s SetupQueueStats) {
+	q := f.getSetups()
+	if q == nil {
+		return
+	}
+	q.Lock()
+	s = SetupQueueStats{
+		Running:  q.running,
+		Queued:   q.queued,
+		Waited:   q.waited,
+		Rejected: q.rejected,
+	}
+	q.Unlock()
+	return
+}
+
+// startSetup sets up the transport of the app of conn through the discovery now or once a
+// setup running ends
+func (f *MessengerFactory) startSetup(conn *Connection, req *appConn, discovery *Connection) {
+	q := f.getSetups()
+	if q == nil {
+		req.setup(f, conn, discovery, nil)
+		return
+	}
+	q.Lock()
+	if q.free() {
+		q.running++
+		q.Unlock()
+		req.setup(f, conn, discovery, q.releaser(f))
+		return
+	}
+	if q.limits.MaxQueued > 0 && q.queued >= q.limits.MaxQueued {
+		q.rejected++
+		q.Unlock()
+		msg := fmt.Sprintf("Discovery(%x): too many transports being set up", discovery.GetTargetKey())
+		conn.GetContextLogger().WithField("setup_id", req.SetupID).Infof("transport to node %x app %x: %s", req.Node, req.App, msg)
+		err := conn.writeOP(OP_BUILD_APP_CONN|RESP_PREFIX, &AppConnResp{
+			Discovery: discovery.GetTargetKey(),
+			App:       req.App,
+			Failed:    true,
+			Msg:       PriorityMsg{Priority: Busy, Msg: msg, Type: Failed},
+			SetupID:   req.SetupID,
+		})
+		if err != nil {
+			conn.GetContextLogger().Debugf("write setup busy err %v", err)
+		}
+		return
+	}
+	if _, ok := q.waiting[conn]; !ok {
+		q.apps = append(q.apps, conn)
+	}
+	q.waiting[conn] = append(q.waiting[conn], &queuedSetup{conn: conn, req: req.clone(), discovery: discovery})
+	q.queued++
+	q.waited++
+	q.Unlock()
+	conn.GetContextLogger().WithField("setup_id", req.SetupID).Debugf("transport to node %x app %x waits for its turn", req.Node, req.App)
+}
+
+// clone copies the request to keep it past its Execute, the op goes back to its pool
+func (req *appConn) clone() *appConn {
+	r := *req
+	if req.Trace != nil {
+		trace := *req.Trace
+		r.Trace = &trace
+	}
+	if req.Timeouts != nil {
+		timeouts := *req.Timeouts
+		r.Timeouts = &timeouts
+	}
+	if req.Constraints != nil {
+		constraints := *req.Constraints
+		constraints.DisjointFrom = append([]cipher.PubKey(nil), req.Constraints.DisjointFrom...)
+		r.Constraints = &constraints
+	}
+	r.Knock = append([]byte(nil), req.Knock...)
+	return &r
+}
+
+// cancelQueuedSetups drops the setups of the app of conn to the app waiting for their turn,
+// only the one of setupID if set, and answers them as cancelled
+func (f *MessengerFactory) cancelQueuedSetups(conn *Connection, req *cancelAppConn) {
+	q := f.getSetups()
+	if q == nil {
+		return
+	}
+	var cancelled []*queuedSetup
+	q.Lock()
+	setups := q.waiting[conn]
+	kept := setups[:0]
+	for _, s := range setups {
+		if s.req.App == req.App && (len(req.SetupID) == 0 || s.req.SetupID == req.SetupID) {
+			cancelled = append(cancelled, s)
+			continue
+		}
+		kept = append(kept, s)
+	}
+	q.queued -= len(cancelled)
+	if len(kept) > 0 {
+		q.waiting[conn] = kept
+	} else if len(setups) > 0 {
+		q.dropApp(conn)
+	}
+	q.Unlock()
+	for _, s := range cancelled {
+		discovery := s.discovery.GetTargetKey()
+		err := conn.writeOP(OP_BUILD_APP_CONN|RESP_PREFIX, &AppConnResp{
+			Discovery: discovery,
+			App:       s.req.App,
+			Failed:    true,
+			Msg: PriorityMsg{
+				Priority: Cancelled,
+				Msg:      fmt.Sprintf("Discovery(%x): cancelled by the app", discovery),
+				Type:     Failed,
+			},
+			SetupID: s.req.SetupID,
+		})
+		if err != nil {
+			conn.GetContextLogger().Debugf("write setup cancelled err %v", err)
+		}
+	}
+}
+
+// setupDone frees the turn of the setup of the transport once the nodes connected or it
+// failed
+func (t *Transport) setupDone() {
+	if t.setupRelease != nil {
+		t.setupRelease()
+	}
+}
+
+// free tells if a setup may run now, with the queue locked
+func (q *setupQueue) free() bool {
+	return q.limits.MaxConcurrent <= 0 || q.running < q.limits.MaxConcurrent
+}
+
+// next takes the first setup of the app whose turn it is, the setups of closed apps are
+// dropped, with the queue locked
+func (q *setupQueue) next() *queuedSetup {
+	for len(q.apps) > 0 {
+		conn := q.apps[0]
+		setups := q.waiting[conn]
+		s := setups[0]
+		setups[0] = nil
+		setups = setups[1:]
+		q.queued--
+		q.apps = q.apps[1:]
+		if len(setups) > 0 {
+			q.waiting[conn] = setups
+			q.apps = append(q.apps, conn)
+		} else {
+			delete(q.waiting, conn)
+		}
+		if conn.IsClosed() {
+			continue
+		}
+		return s
+	}
+	return nil
+}
+
+// dropApp forgets the app of conn, with the queue locked
+func (q *setupQueue) dropApp(conn *Connection) {
+	delete(q.waiting, conn)
+	for i, c := range q.apps {
+		if c == conn {
+			q.apps = append(q.apps[:i], q.apps[i+1:]...)
+			break
+		}
+	}
+}
+
+// releaser returns the func a running setup calls once when its nodes connected or it
+// failed, which starts the setups waiting that fit the limit
+func (q *setupQueue) releaser(f *MessengerFactory) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			q.Lock()
+			q.running--
+			q.startWaiting(f)
+			q.Unlock()
+		})
+	}
+}
+
+// startWaiting starts the setups waiting that fit the limit, with the queue locked
+func (q *setupQueue) startWaiting(f *MessengerFactory) {
+	for q.free() {
+		s := q.next()
+		if s == nil {
+			return
+		}
+		q.running++
+		go s.req.setup(f, s.conn, s.discovery, q.releaser(f))
+	}
+}
@@ -0,0 +1,85 @@
+package factory
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/skycoin/skycoin/src/cipher"
+	cn "github.com/skycoin/skywire/pkg/net/conn"
+)
+
+// answers returns the setups answered as failed with their priority
+func answers(t *testing.T, fake *fakeConn) (answered []string) {
+	for _, w := range fake.written {
+		var resp AppConnResp
+		if err := json.Unmarshal(w[MSG_HEADER_END:], &resp); err != nil || w[MSG_OP_BEGIN] != OP_BUILD_APP_CONN|RESP_PREFIX || !resp.Failed {
+			t.Fatalf("answered %x: %v", w, err)
+		}
+		answered = append(answered, resp.SetupID+":"+map[Priority]string{Busy: "busy", Cancelled: "cancelled"}[resp.Msg.Priority])
+	}
+	return
+}
+
+func TestSetupQueue(t *testing.T) {
+	app := cipher.PubKey([33]byte{0x03, 1})
+	for _, c := range []struct {
+		name   string
+		limits SetupLimits
+		// the setups of the apps 0 or 1 queued in turn, by their id
+		setups []string
+		apps   []int
+		// the app closed before the turns are taken
+		closed int
+		cancel *cancelAppConn
+		// the setups taken in turn and the ones answered by app 0
+		turns    string
+		answered string
+		stats    SetupQueueStats
+	}{
+		{name: "apps in turn", limits: SetupLimits{MaxConcurrent: 1}, setups: []string{"a1", "a2", "b1", "a3", "b2"}, apps: []int{0, 0, 1, 0, 1}, closed: -1,
+			turns: "a1,b1,a2,b2,a3", stats: SetupQueueStats{Running: 1, Queued: 5, Waited: 5}},
+		{name: "too many waiting", limits: SetupLimits{MaxConcurrent: 1, MaxQueued: 2}, setups: []string{"a1", "b1", "a2"}, apps: []int{0, 1, 0}, closed: -1,
+			turns: "a1,b1", answered: "a2:busy", stats: SetupQueueStats{Running: 1, Queued: 2, Waited: 2, Rejected: 1}},
+		{name: "closed app", limits: SetupLimits{MaxConcurrent: 1}, setups: []string{"a1", "b1", "a2"}, apps: []int{0, 1, 0}, closed: 0,
+			turns: "b1", stats: SetupQueueStats{Running: 1, Queued: 3, Waited: 3}},
+		{name: "cancelled", limits: SetupLimits{MaxConcurrent: 1}, setups: []string{"a1", "a2", "b1"}, apps: []int{0, 0, 1}, closed: -1,
+			cancel: &cancelAppConn{App: app, SetupID: "a2"}, turns: "a1,b1", answered: "a2:cancelled", stats: SetupQueueStats{Running: 1, Queued: 2, Waited: 3}},
+		{name: "all of the app cancelled", limits: SetupLimits{MaxConcurrent: 1}, setups: []string{"a1", "a2", "b1"}, apps: []int{0, 0, 1}, closed: -1,
+			cancel: &cancelAppConn{App: app}, turns: "b1", answered: "a1:cancelled,a2:cancelled", stats: SetupQueueStats{Running: 1, Queued: 1, Waited: 3}},
+	} {
+		f := NewMessengerFactory()
+		f.SetSetupLimits(c.limits)
+		q := f.getSetups()
+		// a setup runs already, the others wait for their turn
+		q.running = 1
+		discovery, _ := newFakeConnection(f, "127.0.0.1:5000")
+		discovery.targetKey = cipher.PubKey([33]byte{0x04, 1})
+		var apps [2]*Connection
+		var fakes [2]*fakeConn
+		for i := range apps {
+			apps[i], fakes[i] = newFakeConnection(f, "127.0.0.1:5001")
+		}
+		for i, id := range c.setups {
+			f.startSetup(apps[c.apps[i]], &appConn{App: app, SetupID: id}, discovery)
+		}
+		if c.cancel != nil {
+			f.cancelQueuedSetups(apps[0], c.cancel)
+		}
+		if s := f.GetSetupQueueStats(); s != c.stats {
+			t.Errorf("%s: stats %+v, want %+v", c.name, s, c.stats)
+		}
+		if c.closed >= 0 {
+			fakes[c.closed].Connection.(*cn.TCPConn).ConnCommonFields.Close()
+		}
+		var turns []string
+		q.Lock()
+		for s := q.next(); s != nil; s = q.next() {
+			turns = append(turns, s.req.SetupID)
+		}
+		q.Unlock()
+		if strings.Join(turns, ",") != c.turns || strings.Join(answers(t, fakes[0]), ",") != c.answered {
+			t.Errorf("%s: turns %v, answered %v", c.name, turns, answers(t, fakes[0]))
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package factory
+
+import (
+	"fmt"
+	"time"
+)
+
+// SetupPhase of a transport setup that is timed out on its own
+type SetupPhase string
+
+const (
+	// the discovery finds node B and node B answers node A
+	SetupRoute SetupPhase = "route finding"
+	// the nodes connect to each other and node A listens for the app
+	SetupConnect SetupPhase = "transport connection"
+	// the app confirms the port node A listens on
+	SetupConfirm SetupPhase = "app confirmation"
+)
+
+// SetupTimeouts of the phases of a transport setup, a zero field uses the default
+type SetupTimeouts struct {
+	Route   time.Duration `json:",omitempty" wire:"1"`
+	Connect time.Duration `json:",omitempty" wire:"2"`
+	Confirm time.Duration `json:",omitempty" wire:"3"`
+}
+
+var DefaultSetupTimeouts = SetupTimeouts{
+	Route:   10 * time.Second,
+	Connect: 20 * time.Second,
+	Confirm: 10 * time.Second,
+}
+
+// the timeouts an app or the other node sends are kept within these, so that a peer can not
+// have the node give up on a setup at once or hold its resources for long
+const (
+	minSetupTimeout = time.Second
+	maxSetupTimeout = 2 * time.Minute
+)
+
+// or returns t with the zero fields taken from d
+func (t SetupTimeouts) or(d SetupTimeouts) SetupTimeouts {
+	if t.Route <= 0 {
+		t.Route = d.Route
+	}
+	if t.Connect <= 0 {
+		t.Connect = d.Connect
+	}
+	if t.Confirm <= 0 {
+		t.Confirm = d.Confirm
+	}
+	return t
+}
+
+// clamp returns t with the fields kept within minSetupTimeout and maxSetupTimeout, the zero
+// fields are left to or
+func (t SetupTimeouts) clamp() SetupTimeouts {
+	for _, d := range []*time.Duration{&t.Route, &t.Connect, &t.Confirm} {
+		switch {
+		case *d <= 0:
+		case *d < minSetupTimeout:
+			*d = minSetupTimeout
+		case *d > maxSetupTimeout:
+			*d = maxSetupTimeout
+		}
+	}
+	return t
+}
+
+func (t SetupTimeouts) of(phase SetupPhase) time.Duration {
+	switch phase {
+	case SetupRoute:
+		return t.Route
+	case SetupConnect:
+		return t.Connect
+	default:
+		return t.Confirm
+	}
+}
+
+// SetSetupTimeouts sets the timeouts of the transport setups the apps do not set
+func (f *MessengerFactory) SetSetupTimeouts(t SetupTimeouts) {
+	f.fieldsMutex.Lock()
+	f.setupTimeouts = t
+	f.fieldsMutex.Unlock()
+}
+
+func (f *MessengerFactory) GetSetupTimeouts() (t SetupTimeouts) {
+	f.fieldsMutex.RLock()
+	t = f.setupTimeouts
+	f.fieldsMutex.RUnlock()
+	return t.or(DefaultSetupTimeouts)
+}
+
+// SetupTimeout closes the transport if the phase does not complete in time
+func (t *Transport) SetupTimeout(phase SetupPhase) {
+	t.fieldsMutex.Lock()
+	t.setupTimeout(phase)
+	t.fieldsMutex.Unlock()
+}
+
+// setupTimeout is SetupTimeout with the fields of t locked
+func (t *Transport) setupTimeout(phase SetupPhase) {
+	if t.timeoutTimer != nil {
+		t.timeoutTimer.Stop()
+	}
+	d := t.timeouts.of(phase)
+	t.timeoutTimer = time.AfterFunc(d, func() {
+		t.setupTimedOut(phase, d)
+	})
+}
+
+func (t *Transport) setupTimedOut(phase SetupPhase, d time.Duration) {
+	msg := PriorityMsg{
+		Type:     Failed,
+		Msg:      fmt.Sprintf("Discovery(%x): %s timed out after %s", t.getDiscoveryKey(), phase, d),
+		Priority: Timeout,
+	}
+	t.Logger().Infof("transport from app %x to app %x: %s timed out after %s", t.FromApp, t.ToApp, phase, d)
+	if t.isStandby() {
+		t.endSpan(msg.Msg)
+		t.Close()
+		return
+	}
+	t.appConnHolder.PutMessage(msg)
+	if t.clientSide {
+		if phase != SetupConfirm {
+			// the discovery may not reach the app anymore, ask all again
+			t.creator.routes.forget(routeKey{discovery: t.getDiscoveryKey(), node: t.ToNode, app: t.ToApp})
+			t.creator.getPeerStore().failed(t.ToNode)
+		}
+		t.appConnHolder.writeOP(OP_BUILD_APP_CONN|RESP_PREFIX, &AppConnResp{
+			Discovery: t.getDiscoveryKey(),
+			App:       t.ToApp,
+			Failed:    true,
+			Msg:       msg,
+			SetupID:   t.setupID,
+		})
+	}
+	t.endSpan(msg.Msg)
+	t.Close()
+}
@@ -0,0 +1,66 @@
+package factory_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skycoin/skywire/pkg/net/skycoin-messenger/factory"
+	"github.com/skycoin/skywire/pkg/node/nodetest"
+)
+
+func TestSetupConfirmTimeout(t *testing.T) {
+	e := nodetest.NewEnv(t, 1)
+	defer e.Close()
+	a, b := e.StartNode("a"), e.StartNode("b")
+	server := e.ConnectApp(b, "server")
+	server.Offer("127.0.0.1:1", "timeout")
+
+	// the app confirms the port of the transport too late
+	resps := make(chan factory.AppConnResp, 2)
+	f := factory.NewMessengerFactory()
+	defer f.Close()
+	connected := make(chan *factory.Connection, 1)
+	err := f.ConnectWithConfig(a.Apps, &factory.ConnConfig{
+		SeedConfigPath: e.Path("client.json"),
+		OnConnected: func(c *factory.Connection) {
+			connected <- c
+		},
+		AppConnectionInitCallback: func(resp *factory.AppConnResp) *factory.AppFeedback {
+			resps <- *resp
+			if !resp.Failed {
+				time.Sleep(2 * time.Second)
+			}
+			return &factory.AppFeedback{Port: resp.Port, Failed: resp.Failed, Msg: resp.Msg}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var client *factory.Connection
+	select {
+	case client = <-connected:
+	case <-time.After(10 * time.Second):
+		t.Fatal("app not registered")
+	}
+
+	// the timeout the app asks for is kept to the minimum
+	opts := factory.AppDialOptions{Timeouts: factory.SetupTimeouts{Confirm: 10 * time.Millisecond}}
+	if err = client.BuildAppConnectionWithOptions(b.Key, server.GetKey(), e.DiscoveryKey(0), opts); err != nil {
+		t.Fatal(err)
+	}
+	for _, failed := range []bool{false, true} {
+		var resp factory.AppConnResp
+		select {
+		case resp = <-resps:
+		case <-time.After(10 * time.Second):
+			t.Fatal("transport not answered")
+		}
+		if resp.Failed != failed {
+			t.Fatalf("answer %#v", resp)
+		}
+		if failed && (resp.Msg.Priority != factory.Timeout || !strings.HasSuffix(resp.Msg.Msg, "app confirmation timed out after 1s")) {
+			t.Fatalf("confirmation timeout %#v", resp.Msg)
+		}
+	}
+}
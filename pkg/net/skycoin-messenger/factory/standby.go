@@ -0,0 +1,197 @@
+package factory
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+// a standby transport that failed is set up again after this
+const standbyRetryWait = 5 * time.Second
+
+// SetCriticalApps sets the apps whose transports are critical for the apps of the node,
+// as if the apps dialing them asked for it
+func (f *MessengerFactory) SetCriticalApps(keys []cipher.PubKey) {
+	apps := make(map[cipher.PubKey]bool, len(keys))
+	for _, k := range keys {
+		apps[k] = true
+	}
+	f.fieldsMutex.Lock()
+	f.criticalApps = apps
+	f.fieldsMutex.Unlock()
+}
+
+func (f *MessengerFactory) isCriticalApp(app cipher.PubKey) (ok bool) {
+	f.fieldsMutex.RLock()
+	ok = f.criticalApps[app]
+	f.fieldsMutex.RUnlock()
+	return
+}
+
+func (c *Connection) closing() bool {
+	c.fieldsMutex.RLock()
+	defer c.fieldsMutex.RUnlock()
+	return c.closed
+}
+
+// setStandbyIfNotExists keeps tr as the standby of the transport to the app
+func (c *Connection) setStandbyIfNotExists(app cipher.PubKey, tr *Transport) (exists bool) {
+	c.appTransportsMutex.Lock()
+	defer c.appTransportsMutex.Unlock()
+	if c.appStandbys == nil {
+		c.appStandbys = make(map[cipher.PubKey]*Transport)
+	}
+	_, exists = c.appStandbys[app]
+	if !exists {
+		c.appStandbys[app] = tr
+	}
+	return
+}
+
+// takeStandby removes the standby of the transport to the app, only if it is tr unless tr is nil
+func (c *Connection) takeStandby(app cipher.PubKey, tr *Transport) (standby *Transport) {
+	c.appTransportsMutex.Lock()
+	defer c.appTransportsMutex.Unlock()
+	standby, ok := c.appStandbys[app]
+	if !ok || tr != nil && standby != tr {
+		return nil
+	}
+	delete(c.appStandbys, app)
+	return
+}
+
+func (c *Connection) hasStandby(app cipher.PubKey) (ok bool) {
+	c.appTransportsMutex.RLock()
+	_, ok = c.appStandbys[app]
+	c.appTransportsMutex.RUnlock()
+	return
+}
+
+func (c *Connection) takeStandbys() (standbys []*Transport) {
+	c.appTransportsMutex.Lock()
+	defer c.appTransportsMutex.Unlock()
+	for _, tr := range c.appStandbys {
+		standbys = append(standbys, tr)
+	}
+	c.appStandbys = nil
+	return
+}
+
+// promote the standby of node B to the transport to the app (key)
+func (t *Transport) promote(key cipher.PubKey) {
+	t.fieldsMutex.Lock()
+	t.standby = false
+	t.fieldsMutex.Unlock()
+	if t.appConnHolder.setTransportIfNotExists(key, t) {
+		t.Close()
+		return
+	}
+	t.Logger().Infof("standby transport from app %x took over", key)
+}
+
+func (t *Transport) isStandby() bool {
+	t.fieldsMutex.RLock()
+	defer t.fieldsMutex.RUnlock()
+	return t.standby
+}
+
+// prepareStandby sets up a standby transport to the app of the critical transport,
+// by another discovery than avoid if the node is connected to one. The route constraints
+// of the app apply to the standby as to the transport
+func (f *MessengerFactory) prepareStandby(appConn *Connection, dial appConn, avoid cipher.PubKey) {
+	if appConn.closing() {
+		return
+	}
+	var discoveries []*Connection
+	f.ForEachConn(func(connection *Connection) {
+		key := connection.GetTargetKey()
+		if key != dial.Discovery && dial.Discovery != EMPTY_PUBLIC_KEY {
+			return
+		}
+		discoveries = append(discoveries, connection)
+	})
+	discoveries, err := dial.Constraints.filter(appConn, discoveries, dial.App)
+	if err != nil {
+		appConn.GetContextLogger().Infof("no standby transport to node %x app %x: %v", dial.Node, dial.App, err)
+		return
+	}
+	var discovery *Connection
+	for _, connection := range discoveries {
+		key := connection.GetTargetKey()
+		if discovery == nil || discovery.GetTargetKey() == avoid && key != avoid {
+			discovery = connection
+		}
+	}
+	if discovery == nil {
+		return
+	}
+	dial.SetupID = NewSetupID()
+	dial.Trace = nil
+	dial.standby = true
+	appConn.GetContextLogger().WithField("setup_id", dial.SetupID).Infof("setup standby transport to node %x app %x by discovery %x",
+		dial.Node, dial.App, discovery.GetTargetKey())
+	dial.setup(f, appConn, discovery, nil)
+}
+
+// failover replaces the closed critical transport t by its standby on the same port,
+// or dials the app again if there is none. A standby that closed is set up again
+func (f *MessengerFactory) failover(t *Transport) {
+	appConn := t.appConnHolder
+	if appConn.closing() {
+		return
+	}
+	if t.isStandby() {
+		appConn.takeStandby(t.ToApp, t)
+		time.AfterFunc(jitter(standbyRetryWait), func() {
+			primary, ok := appConn.getTransport(t.ToApp)
+			if !ok || appConn.hasStandby(t.ToApp) {
+				return
+			}
+			f.prepareStandby(appConn, t.dial, primary.getDiscoveryKey())
+		})
+		return
+	}
+	if _, ok := appConn.getTransport(t.ToApp); ok {
+		return
+	}
+	standby := appConn.takeStandby(t.ToApp, nil)
+	if standby == nil {
+		dial := t.dial
+		dial.SetupID = NewSetupID()
+		dial.Trace = nil
+		t.Logger().Infof("critical transport to app %x closed without standby, dial again with setup_id %s", t.ToApp, dial.SetupID)
+		dial.Execute(f, appConn)
+		return
+	}
+	if appConn.setTransportIfNotExists(t.ToApp, standby) {
+		standby.Close()
+		return
+	}
+	standby.fieldsMutex.Lock()
+	standby.standby = false
+	standby.servingPort = t.GetServingPort()
+	standby.fieldsMutex.Unlock()
+	err := standby.ListenForApp(func(port int) {
+		msg := PriorityMsg{
+			Priority: Connected,
+			Msg:      fmt.Sprintf("Discovery(%x): Failed over to standby transport to app %x", standby.getDiscoveryKey(), t.ToApp),
+		}
+		appConn.PutMessage(msg)
+		appConn.writeOP(OP_BUILD_APP_CONN|RESP_PREFIX, &AppConnResp{
+			Discovery: standby.getDiscoveryKey(),
+			App:       t.ToApp,
+			Port:      port,
+			Msg:       msg,
+			SetupID:   standby.setupID,
+		})
+		standby.Logger().Infof("transport to app %x failed over to standby on port %d", t.ToApp, port)
+	})
+	if err != nil {
+		standby.Logger().Errorf("fail over to standby: %v", err)
+		standby.Close()
+		return
+	}
+	appConn.setPreviousDiscovery(t.ToApp, standby.getDiscoveryKey())
+	f.prepareStandby(appConn, standby.dial, standby.getDiscoveryKey())
+}
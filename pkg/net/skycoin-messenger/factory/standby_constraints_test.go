@@ -0,0 +1,54 @@
+package factory
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+func TestRouteConstraintsFilter(t *testing.T) {
+	discovery := func(i byte) *Connection {
+		c := newTestConnection()
+		c.targetKey = cipher.PubKey([33]byte{0x03, i})
+		return c
+	}
+	first, second := discovery(1), discovery(2)
+	app, other, standby := cipher.PubKey([33]byte{0x02, 1}), cipher.PubKey([33]byte{0x02, 2}), cipher.PubKey([33]byte{0x02, 3})
+	conn := newTestConnection()
+	conn.appTransports = map[cipher.PubKey]*Transport{other: {ToApp: other, discoveryConn: first}}
+	// the standbys count as the transports of the app
+	conn.appStandbys = map[cipher.PubKey]*Transport{standby: {ToApp: standby, discoveryConn: second}}
+	conn.setPreviousDiscovery(app, second.GetTargetKey())
+
+	for _, c := range []struct {
+		name        string
+		constraints *RouteConstraints
+		allowed     []*Connection
+		err         string
+	}{
+		{name: "none", allowed: []*Connection{first, second}},
+		{name: "disjoint", constraints: &RouteConstraints{DisjointFrom: []cipher.PubKey{other}}, allowed: []*Connection{second}},
+		{name: "disjoint from a standby", constraints: &RouteConstraints{DisjointFrom: []cipher.PubKey{standby}}, allowed: []*Connection{first}},
+		{name: "disjoint from all", constraints: &RouteConstraints{DisjointFrom: []cipher.PubKey{other, standby}}, err: "used by transport to app"},
+		{name: "changed hop", constraints: &RouteConstraints{MinChangedHops: 1}, allowed: []*Connection{first}},
+		{name: "more hops than the route", constraints: &RouteConstraints{MinChangedHops: 2}, err: "2 changed hops asked"},
+	} {
+		allowed, err := c.constraints.filter(conn, []*Connection{first, second}, app)
+		if len(c.err) > 0 {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("%s: got %v, want %s", c.name, err, c.err)
+			}
+			continue
+		}
+		if err != nil || len(allowed) != len(c.allowed) {
+			t.Errorf("%s: allowed %d, want %d: %v", c.name, len(allowed), len(c.allowed), err)
+			continue
+		}
+		for i := range allowed {
+			if allowed[i] != c.allowed[i] {
+				t.Errorf("%s: allowed discovery %x", c.name, allowed[i].GetTargetKey())
+			}
+		}
+	}
+}
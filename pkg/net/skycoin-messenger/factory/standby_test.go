@@ -0,0 +1,69 @@
+package factory_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/skycoin/skywire/pkg/net/skycoin-messenger/factory"
+	"github.com/skycoin/skywire/pkg/node/nodetest"
+)
+
+// primary returns the id of the primary transport of the node, ok if it has one primary and one standby
+func primary(n *nodetest.Node) (id string, ok bool) {
+	var primaries, standbys int
+	for _, tr := range n.GetNodeInfo().Transports {
+		if tr.Standby {
+			standbys++
+		} else {
+			primaries++
+			id = tr.ID
+		}
+	}
+	return id, primaries == 1 && standbys == 1
+}
+
+func TestStandbyFailover(t *testing.T) {
+	e := nodetest.NewEnv(t, 1)
+	defer e.Close()
+	a, b := e.StartNode("a"), e.StartNode("b")
+	server := e.ConnectApp(b, "server")
+	server.Offer(e.Echo(), "echo")
+	client := e.ConnectApp(a, "client")
+	port := client.ConnectWithOptions(b.Key, server.GetKey(), e.DiscoveryKey(0), factory.AppDialOptions{Critical: true}).Port
+
+	// the primary is closed for each reason in turn, node A serves the app on the same port
+	// through the standby only when the transport itself broke
+	for _, c := range []struct {
+		reason   factory.CloseReason
+		failover bool
+	}{
+		{factory.CloseRouteFailure, true},
+		{factory.CloseIdleTimeout, true},
+		{factory.ClosePolicy, false},
+	} {
+		nodetest.WaitFor(t, "the standby on both nodes", func() bool {
+			_, okA := primary(a)
+			_, okB := primary(b)
+			return okA && okB
+		})
+		id, _ := primary(a)
+		if !a.CloseTransport(id, c.reason) {
+			t.Fatalf("%s: transport %s not found", c.reason, id)
+		}
+		if !c.failover {
+			nodetest.WaitFor(t, "no transports on both nodes", func() bool {
+				return len(a.GetNodeInfo().Transports) == 0 && len(b.GetNodeInfo().Transports) == 0
+			})
+			select {
+			case resp := <-client.Resps:
+				t.Fatalf("%s: answered %#v", c.reason, resp)
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+		if resp := client.Answer(); resp.Failed || resp.Port != port {
+			t.Fatalf("%s: fail over answered %#v, want port %d", c.reason, resp, port)
+		}
+		nodetest.Ping(t, port).Close()
+	}
+}
@@ -0,0 +1,69 @@
+package factory
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/skycoin/skywire/pkg/trace"
+)
+
+// SetTracer records the spans of the transport setups, nil disables tracing
+func (f *MessengerFactory) SetTracer(t *trace.Tracer) {
+	f.fieldsMutex.Lock()
+	f.tracer = t
+	f.fieldsMutex.Unlock()
+}
+
+func (f *MessengerFactory) getTracer() (t *trace.Tracer) {
+	root := f.rootFactory()
+	root.fieldsMutex.RLock()
+	t = root.tracer
+	root.fieldsMutex.RUnlock()
+	return
+}
+
+// startSpan starts the span of the transport setup on this node
+func (t *Transport) startSpan(name string, parent *trace.SpanContext) *trace.Span {
+	s := t.creator.getTracer().Start(name, parent)
+	s.SetAttribute("from_node", t.FromNode.Hex())
+	s.SetAttribute("to_node", t.ToNode.Hex())
+	s.SetAttribute("from_app", t.FromApp.Hex())
+	s.SetAttribute("to_app", t.ToApp.Hex())
+	if len(t.setupID) > 0 {
+		s.SetAttribute("setup_id", t.setupID)
+	}
+	t.fieldsMutex.Lock()
+	t.span = s
+	t.fieldsMutex.Unlock()
+	return s
+}
+
+func (t *Transport) spanContext() *trace.SpanContext {
+	t.fieldsMutex.RLock()
+	defer t.fieldsMutex.RUnlock()
+	return t.span.Context()
+}
+
+// endSpan ends the setup span, failed if cause is not empty
+func (t *Transport) endSpan(cause string) {
+	t.fieldsMutex.RLock()
+	s := t.span
+	t.fieldsMutex.RUnlock()
+	if len(cause) > 0 {
+		s.Fail(cause)
+	}
+	s.End()
+}
+
+// NewSetupID returns a random id correlating the logs of one transport setup
+func NewSetupID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Logger returns the logger of the transport tagged with its setup id
+func (t *Transport) Logger() *log.Entry {
+	return log.WithField("setup_id", t.setupID)
+}
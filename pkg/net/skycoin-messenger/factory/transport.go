@@ -16,6 +16,9 @@ import (
 	log "github.com/sirupsen/logrus"
 	"github.com/skycoin/skycoin/src/cipher"
 	cn "github.com/skycoin/skywire/pkg/net/conn"
+	"github.com/skycoin/skywire/pkg/net/factory"
+	"github.com/skycoin/skywire/pkg/net/util"
+	"github.com/skycoin/skywire/pkg/trace"
 )
 
 type Transport struct {
@@ -33,10 +36,12 @@ type Transport struct {
 	FromApp, ToApp   cipher.PubKey
 	servingPort      int
 
-	conns      map[uint32]net.Conn
+	// connections of the apps by their ids in the frames
+	loops      map[uint32]*loop
 	connsMutex sync.RWMutex
 
 	timeoutTimer  *time.Timer
+	timeouts      SetupTimeouts
 	appConnHolder *Connection
 
 	uploadBW   bandwidth
@@ -44,7 +49,43 @@ type Transport struct {
 
 	connAcked bool
 
+	// the transport is not encrypted, agreed by both nodes
+	plain bool
+	// closed when node B answered the request of node A for a plain transport
+	plainDecided     chan struct{}
+	plainDecidedOnce sync.Once
+
 	discoveryConn *Connection
+	// the setup started, node A times the answer of the discovery from it
+	created time.Time
+
+	// setup span of the transport on this node
+	span *trace.Span
+	// correlates the logs of the setup on the app, nodes and discovery
+	setupID string
+	// frees the turn of the setup among the SetupLimits of node A, set before the setup runs
+	setupRelease func()
+	// binds the transport to both nodes, signed by both unless a node is older
+	record       TransportRecord
+	recordSigned bool
+	// latest message schema the other node decodes, sent along with the setup
+	peerSchema int
+	// capabilities both nodes support
+	features Features
+
+	// the transport is failed over when it closes, critical transports of node A only
+	critical bool
+	// the transport is the standby of a critical transport to the same app and does not serve the app
+	standby bool
+	// request of the app the transport was set up for, to set up the standby or dial again
+	dial appConn
+	// random ids of the apps in the messages through the discovery in a private setup
+	routeFromApp, routeApp cipher.PubKey
+	// paces and counts the data of the local app, nil if not limited
+	quota     *transportQuota
+	quotaOnce sync.Once
+	// why the transport was closed
+	closeReason CloseReason
 
 	fieldsMutex sync.RWMutex
 }
@@ -57,6 +98,7 @@ type transportPair struct {
 	timeoutTimer                           *time.Timer
 	closed                                 bool
 	lastCheckedTime                        time.Time
+	record                                 TransportRecord
 	fieldsMutex                            sync.RWMutex
 }
 
@@ -71,6 +113,29 @@ func (p *transportPair) ok() {
 	p.fieldsMutex.Unlock()
 }
 
+// setRecord keeps the record node A signed, node B signs it when it agreed
+func (p *transportPair) setRecord(r TransportRecord) {
+	p.fieldsMutex.Lock()
+	p.record = r
+	p.fieldsMutex.Unlock()
+}
+
+// agreed checks that node B sent the answer on conn and signed the record
+func (p *transportPair) agreed(f *MessengerFactory, conn *Connection, sig []byte) error {
+	p.fieldsMutex.Lock()
+	defer p.fieldsMutex.Unlock()
+	if key, ok := conn.keyIfSet(); !ok || key != p.toNode {
+		return fmt.Errorf("answer of node %x on the connection of %x", p.toNode, key)
+	}
+	r := p.record
+	r.ToSig = sig
+	if err := f.checkRecordTo(&r); err != nil {
+		return err
+	}
+	p.record = r
+	return nil
+}
+
 func (p *transportPair) close() {
 	p.fieldsMutex.Lock()
 	if p.closed {
@@ -192,8 +257,11 @@ func NewTransport(creator *MessengerFactory, appConn *Connection, fromNode, toNo
 		FromApp:       fromApp,
 		ToApp:         toApp,
 		clientSide:    cs,
+		timeouts:      creator.GetSetupTimeouts(),
 		factory:       NewMessengerFactory(),
-		conns:         make(map[uint32]net.Conn),
+		loops:         make(map[uint32]*loop),
+		created:       time.Now(),
+		quota:         creator.getQuotas().forTransport(appConn.GetKey()),
 	}
 	t.factory.Parent = creator
 	t.factory.SetDefaultSeedConfig(creator.GetDefaultSeedConfig())
@@ -246,14 +314,74 @@ func (t *Transport) clientSideConnect(address string, sc *SeedConfig, iv []byte)
 		err = errors.New("clientSideConnect acceptUDPWithConfig return nil conn")
 		return
 	}
-	err = conn.SetCrypto(sc.publicKey, sc.secKey, t.ToNode, iv)
-	if err != nil {
-		return
+	conn.setPeerSchema(t.peerSchema)
+	if t.plain {
+		conn.SetPlain()
+	} else {
+		err = conn.SetCrypto(sc.publicKey, sc.secKey, t.ToNode, iv)
+		if err != nil {
+			return
+		}
 	}
 	err = conn.writeOP(OP_BUILD_APP_CONN_OK|RESP_PREFIX, &nop{})
 	return
 }
 
+// node A waits this long for node B to answer a plain transport request
+const plainDecisionTimeout = 10 * time.Second
+
+// askPlain marks that node A requested a plain transport
+func (t *Transport) askPlain() {
+	t.fieldsMutex.Lock()
+	t.plainDecided = make(chan struct{})
+	t.fieldsMutex.Unlock()
+}
+
+// decidePlain sets the answer of node B, only a requested plain transport can be plain
+func (t *Transport) decidePlain(plain bool) {
+	t.fieldsMutex.Lock()
+	decided := t.plainDecided
+	t.plain = plain && decided != nil
+	t.fieldsMutex.Unlock()
+	if decided != nil {
+		t.plainDecidedOnce.Do(func() { close(decided) })
+	}
+}
+
+// waitPlain waits for the answer of node B if node A requested a plain transport,
+// the packets of node B can arrive before its answer relayed by the discovery
+func (t *Transport) waitPlain() bool {
+	t.fieldsMutex.RLock()
+	decided := t.plainDecided
+	t.fieldsMutex.RUnlock()
+	if decided == nil {
+		return false
+	}
+	select {
+	case <-decided:
+	case <-time.After(plainDecisionTimeout):
+		return false
+	}
+	return t.IsPlain()
+}
+
+// RouteID returns the id of the decision that chose the discovery of the transport, the setup
+// id on node A, empty on node B
+func (t *Transport) RouteID() string {
+	if !t.clientSide {
+		return ""
+	}
+	return t.setupID
+}
+
+// IsPlain returns true if the transport is not encrypted
+func (t *Transport) IsPlain() (plain bool) {
+	t.fieldsMutex.RLock()
+	plain = t.plain
+	t.fieldsMutex.RUnlock()
+	return
+}
+
 func (t *Transport) connAck() {
 	t.fieldsMutex.Lock()
 	t.connAcked = true
@@ -275,9 +403,14 @@ func (t *Transport) serverSiceConnect(address, appAddress string, sc *SeedConfig
 	}
 	conn.CreatedByTransport = t
 	conn.SetKey(t.FromNode)
-	err = conn.SetCrypto(sc.publicKey, sc.secKey, t.FromNode, iv)
-	if err != nil {
-		return
+	conn.setPeerSchema(t.getPeerSchema())
+	if t.IsPlain() {
+		conn.SetPlain()
+	} else {
+		err = conn.SetCrypto(sc.publicKey, sc.secKey, t.FromNode, iv)
+		if err != nil {
+			return
+		}
 	}
 	err = conn.writeOP(OP_BUILD_APP_CONN_OK,
 		&buildConnResp{
@@ -293,22 +426,92 @@ func (t *Transport) serverSiceConnect(address, appAddress string, sc *SeedConfig
 	t.conn = conn
 	t.fieldsMutex.Unlock()
 
-	go t.nodeReadLoop(conn, func(id uint32) net.Conn {
+	go t.nodeReadLoop(conn, func(id uint32, frame LoopFrame) (l *loop, err error) {
 		t.connsMutex.Lock()
 		defer t.connsMutex.Unlock()
-		appConn, ok := t.conns[id]
-		if !ok {
-			appConn, err = net.Dial("tcp", appAddress)
-			if err != nil {
-				log.Debugf("app conn dial err %v", err)
-				return nil
-			}
-			t.conns[id] = appConn
-			go t.appReadLoop(id, appConn, conn, false)
+		l, ok := t.loops[id]
+		if ok {
+			err = l.receive(frame)
+			return
+		}
+		// only the open frame dials the app, data of a loop node B never opened is dropped
+		if frame != LoopFrameOpen {
+			err = ErrLoopUnknown
+			return
 		}
-		return appConn
+		l = &loop{id: id, state: LoopConfirming}
+		t.loops[id] = l
+		err = util.ReserveFDs(1)
+		if err != nil {
+			log.Errorf("app conn of %s refused: %v", appAddress, err)
+			l.transition(LoopClosed)
+			return
+		}
+		l.conn, err = net.Dial("tcp", appAddress)
+		if err != nil {
+			log.Debugf("app conn dial err %v", err)
+			l.transition(LoopClosed)
+			return
+		}
+		l.transition(LoopOpen)
+		go t.appReadLoop(l, conn, false)
+		return
+	})
+
+	return
+}
+
+// requestReversal asks node B over the discovery to dial back when its build response
+// did not arrive (delay) after node A sent to node B
+func (t *Transport) requestReversal(delay time.Duration) {
+	time.Sleep(delay)
+	if tr, ok := t.appConnHolder.getTransport(t.ToApp); ok && tr == t {
+		return
+	}
+	t.fieldsMutex.RLock()
+	closed := t.factory == nil
+	t.fieldsMutex.RUnlock()
+	if closed || t.discoveryConn == nil {
+		return
+	}
+	fromApp, app := t.routeApps()
+	err := t.discoveryConn.writeOP(OP_REVERSE_CONN, &reverseConn{
+		Node:     t.ToNode,
+		App:      app,
+		FromApp:  fromApp,
+		FromNode: t.FromNode,
 	})
+	if err != nil {
+		t.discoveryConn.GetContextLogger().Debugf("request reversal err %v", err)
+	}
+}
 
+// dialBack sends the build response of node B again to node A,
+// a different public endpoint (address) of node A means its nat maps every destination
+// to another port and the reversal can not help
+func (t *Transport) dialBack(address string) (err error) {
+	t.fieldsMutex.RLock()
+	conn := t.conn
+	acked := t.connAcked
+	t.fieldsMutex.RUnlock()
+	if acked {
+		return
+	}
+	if conn == nil {
+		err = errors.New("transport has been closed")
+		return
+	}
+	if conn.GetRemoteAddr().String() != address {
+		err = fmt.Errorf("node endpoint changed from %s to %s", conn.GetRemoteAddr(), address)
+		return
+	}
+	err = conn.writeOP(OP_BUILD_APP_CONN_OK,
+		&buildConnResp{
+			FromNode: t.FromNode,
+			Node:     t.ToNode,
+			FromApp:  t.FromApp,
+			App:      t.ToApp,
+		})
 	return
 }
 
@@ -320,48 +523,97 @@ func (t *Transport) getDiscoveryDisconntedChan() <-chan struct{} {
 }
 
 // Read from node, write to app
-func (t *Transport) nodeReadLoop(conn *Connection, getAppConn func(id uint32) net.Conn) {
+func (t *Transport) nodeReadLoop(conn *Connection, getLoop func(id uint32, frame LoopFrame) (*loop, error)) {
+	reason := CloseRouteFailure
+	// the other node closed the transport and told why
+	var peerClosed bool
 	defer func() {
-		t.Close()
+		t.close(reason, !peerClosed)
 	}()
+	if u, ok := conn.Connection.Connection.(*cn.UDPConn); ok {
+		u.SetSelectiveRetransmission(true)
+	}
+	// loops missing a frame too long are closed
+	gaps := time.NewTicker(reorderGapTimeout / 2)
+	defer gaps.Stop()
 	var err error
 	for {
 		select {
+		case now := <-gaps.C:
+			t.connsMutex.RLock()
+			var stalled []*loop
+			for _, l := range t.loops {
+				if l.reorder.expired(now) != nil {
+					stalled = append(stalled, l)
+				}
+			}
+			t.connsMutex.RUnlock()
+			for _, l := range stalled {
+				t.reorderFailed(conn, l, errReorderGap)
+			}
 		case m, ok := <-conn.GetChanIn():
 			if !ok {
 				conn.GetContextLogger().Debugf("node conn read err %v", err)
+				if conn.GetStatusError() == factory.ErrIdleTimeout {
+					reason = CloseIdleTimeout
+				}
 				return
 			}
 			if cn.DEBUG_DATA_HEX {
 				conn.GetContextLogger().Debugf("get chan in %x", m)
 			}
 			t.downloadBW.add(len(m))
+			if err = t.quota.use(len(m), false); err != nil {
+				t.quotaExceeded(err)
+				reason = ClosePolicy
+				return
+			}
+			op := m[PKG_HEADER_OP_BEGIN]
+			if op == OP_SHUTDOWN {
+				if len(m) > PKG_HEADER_END {
+					reason = CloseReason(m[PKG_HEADER_END])
+				}
+				peerClosed = true
+				conn.GetContextLogger().Debugf("transport closed by the other node: %s", reason)
+				return
+			}
 			id := binary.BigEndian.Uint32(m[PKG_HEADER_ID_BEGIN:PKG_HEADER_ID_END])
-			appConn := getAppConn(id)
-			if appConn == nil {
+			frame := loopFrame(m)
+			l, err := getLoop(id, frame)
+			if err != nil {
+				conn.GetContextLogger().Debugf("drop frame: %v", err)
 				continue
 			}
-			op := m[PKG_HEADER_OP_BEGIN]
-			if op == OP_CLOSE {
-				t.connsMutex.Lock()
-				t.conns[id] = nil
-				t.connsMutex.Unlock()
-				appConn.Close()
+			if frame == LoopFrameClose {
+				// older nodes only close a connection when its app closed it
+				closeReason := CloseAppExit
+				if len(m) > PKG_HEADER_END {
+					closeReason = CloseReason(m[PKG_HEADER_END])
+				}
+				t.closeLoop(l, closeReason, true)
+				continue
+			}
+			if frame == LoopFrameOpen {
 				continue
 			}
-			if len(m) <= PKG_HEADER_END {
+			if op != OP_TRANSPORT_SEQ {
+				t.deliver(l, m[PKG_HEADER_END:])
 				continue
 			}
-			body := m[PKG_HEADER_END:]
-			err = writeAll(appConn, body)
+			seq, body, ok := frameSeq(m)
+			if !ok {
+				continue
+			}
+			ready, err := l.reorder.push(seq, body, time.Now())
 			if err != nil {
-				conn.GetContextLogger().Debugf("app conn write err %v", err)
-				t.connsMutex.Lock()
-				t.conns[id] = nil
-				t.connsMutex.Unlock()
-				appConn.Close()
+				t.reorderFailed(conn, l, err)
 				continue
 			}
+			for _, body := range ready {
+				if !t.deliver(l, body) {
+					break
+				}
+			}
 		case <-t.getDiscoveryDisconntedChan():
 			conn.GetContextLogger().Debugf("transport discovery conn closed")
 			return
@@ -369,21 +621,101 @@ func (t *Transport) nodeReadLoop(conn *Connection, getAppConn func(id uint32) ne
 	}
 }
 
+// reorderFailed closes the loop the frames of could not be put back in order
+func (t *Transport) reorderFailed(conn *Connection, l *loop, err error) {
+	conn.GetContextLogger().Debugf("loop %d: %v", l.id, err)
+	l.reorder.drop()
+	// the read loop of the app tells the other node
+	t.appConnClosed(l.conn, CloseRouteFailure)
+	l.conn.Close()
+}
+
+// ARQStats returns the retransmissions of the connection to the other node, false before
+// the nodes connected
+func (t *Transport) ARQStats() (stats cn.ARQStats, ok bool) {
+	t.fieldsMutex.RLock()
+	conn := t.conn
+	t.fieldsMutex.RUnlock()
+	if conn == nil || conn.Connection == nil {
+		return
+	}
+	u, ok := conn.Connection.Connection.(*cn.UDPConn)
+	if ok {
+		stats = u.GetARQStats()
+	}
+	return
+}
+
+// LoopQueues returns the send queues of the loops of the app to the other node, by loop
+func (t *Transport) LoopQueues() (queues map[uint32]cn.ChannelQueue) {
+	queues = make(map[uint32]cn.ChannelQueue)
+	t.fieldsMutex.RLock()
+	conn := t.conn
+	t.fieldsMutex.RUnlock()
+	if conn == nil {
+		return
+	}
+	t.connsMutex.RLock()
+	defer t.connsMutex.RUnlock()
+	for id, l := range t.loops {
+		if l.channel == 0 {
+			continue
+		}
+		if q, ok := conn.GetChannelQueue(l.channel); ok {
+			queues[id] = q
+		}
+	}
+	return
+}
+
+// deliver writes the data to the app of the loop, closes the loop if the app is gone
+func (t *Transport) deliver(l *loop, body []byte) bool {
+	if len(body) == 0 {
+		return true
+	}
+	err := writeAll(l.conn, body)
+	if err != nil {
+		t.Logger().Debugf("app conn write err %v", err)
+		t.closeLoop(l, CloseRouteFailure, false)
+		return false
+	}
+	return true
+}
+
 // Read from app, write to node
-func (t *Transport) appReadLoop(id uint32, appConn net.Conn, conn *Connection, create bool) {
-	buf := make([]byte, cn.MAX_UDP_PACKAGE_SIZE-100)
+func (t *Transport) appReadLoop(l *loop, conn *Connection, create bool) {
+	id, appConn := l.id, l.conn
+	buf := make([]byte, PKG_HEADER_END+MaxAppPayload)
+	dataBegin := PKG_HEADER_END
+	var seq sequence
+	sequenced := t.Features().Has(FeatureSequence)
+	if sequenced {
+		dataBegin = PKG_SEQ_END
+	}
 	binary.BigEndian.PutUint32(buf[PKG_HEADER_ID_BEGIN:PKG_HEADER_ID_END], id)
 	channel := conn.NewPendingChannel()
 	defer conn.DeletePendingChannel(channel)
+	t.connsMutex.Lock()
+	l.channel = channel
+	t.connsMutex.Unlock()
 	defer func() {
 		if e := recover(); e != nil {
 			conn.GetContextLogger().Debugf("close app conn %d, err %v", id, e)
 		}
 		t.connsMutex.Lock()
 		defer t.connsMutex.Unlock()
-		// exited by err
-		if t.conns[id] != nil {
+		if create {
+			delete(t.loops, id)
+		}
+		if l.state == LoopRequested {
+			l.transition(LoopClosed)
+			return
+		}
+		// exited by err, neither the other node nor the transport closed the loop
+		if l.state == LoopConfirming || l.state == LoopOpen {
+			l.transition(LoopHalfClosed)
 			buf[PKG_HEADER_OP_BEGIN] = OP_CLOSE
+			buf[PKG_HEADER_END] = byte(CloseAppExit)
 			//log.Infof("close %v, %d", create, id)
 			if !conn.IsClosed() {
 				func() {
@@ -392,38 +724,98 @@ func (t *Transport) appReadLoop(id uint32, appConn net.Conn, conn *Connection, c
 							conn.GetContextLogger().Debugf("close app conn %d, err %v", id, e)
 						}
 					}()
-					conn.WriteToChannel(channel, buf[:PKG_HEADER_END])
+					conn.WriteToChannel(channel, buf[:PKG_HEADER_END+1])
 				}()
 			}
-			if create {
-				delete(t.conns, id)
-			} else {
-				t.conns[id] = nil
-			}
-			return
-		}
-		if create {
-			delete(t.conns, id)
+			l.transition(LoopClosing)
+			l.transition(LoopClosed)
 		}
 	}()
 	if create {
+		t.connsMutex.Lock()
+		err := l.transition(LoopConfirming)
+		t.connsMutex.Unlock()
+		if err != nil {
+			return
+		}
 		conn.WriteToChannel(channel, buf[:PKG_HEADER_END])
 	}
+	if sequenced {
+		buf[PKG_HEADER_OP_BEGIN] = OP_TRANSPORT_SEQ
+	}
 	for {
-		n, err := appConn.Read(buf[PKG_HEADER_END:])
+		n, err := appConn.Read(buf[dataBegin:])
 		if err != nil {
 			log.Debugf("app conn read err %v, %d", err, n)
 			return
 		}
-		pkg := buf[:PKG_HEADER_END+n]
+		if sequenced {
+			seq.stamp(buf)
+		}
+		pkg := buf[:dataBegin+n]
 		if cn.DEBUG_DATA_HEX {
 			conn.GetContextLogger().Debugf("app conn in %x", pkg)
 		}
 		t.uploadBW.add(len(pkg))
+		if err = t.quota.use(len(pkg), true); err != nil {
+			t.quotaExceeded(err)
+			t.CloseWithReason(ClosePolicy)
+			return
+		}
 		conn.WriteToChannel(channel, pkg)
 	}
 }
 
+// quotaExceeded tells the app why its transport is closed, once
+func (t *Transport) quotaExceeded(err error) {
+	t.quotaOnce.Do(func() {
+		t.Logger().Infof("close transport: %v", err)
+		msg := PriorityMsg{Priority: QuotaExceeded, Msg: err.Error(), Type: Failed}
+		t.appConnHolder.PutMessage(msg)
+		if !t.clientSide {
+			return
+		}
+		e := t.appConnHolder.writeOP(OP_BUILD_APP_CONN|RESP_PREFIX, &AppConnResp{
+			Discovery: t.getDiscoveryKey(),
+			App:       t.ToApp,
+			Failed:    true,
+			Msg:       msg,
+			SetupID:   t.setupID,
+		})
+		if e != nil {
+			t.Logger().Debugf("write quota exceeded to app: %v", e)
+		}
+	})
+}
+
+func (t *Transport) setPeerSchema(version int) {
+	t.fieldsMutex.Lock()
+	t.peerSchema = version
+	t.fieldsMutex.Unlock()
+}
+
+// setFeatures keeps the capabilities of peer this node supports too
+func (t *Transport) setFeatures(peer Features) {
+	t.fieldsMutex.Lock()
+	t.features = peer & t.creator.getTransportFeatures()
+	t.fieldsMutex.Unlock()
+}
+
+// Features returns the capabilities both nodes of the transport support
+func (t *Transport) Features() (features Features) {
+	t.fieldsMutex.RLock()
+	features = t.features
+	t.fieldsMutex.RUnlock()
+	return
+}
+
+func (t *Transport) getPeerSchema() (version int) {
+	t.fieldsMutex.RLock()
+	version = t.peerSchema
+	t.fieldsMutex.RUnlock()
+	return
+}
+
 func (t *Transport) setUDPConn(conn *Connection) {
 	t.fieldsMutex.Lock()
 	t.conn = conn
@@ -454,10 +846,31 @@ func (t *Transport) ListenForApp(fn func(port int)) (err error) {
 		return
 	}
 
+	err = util.ReserveFDs(1)
+	if err != nil {
+		return
+	}
 	var ln net.Listener
-	var port int
+	// kept by a standby taking over the port of the transport it replaces
+	port := t.servingPort
+	ports := t.creator.getAppPorts()
+	key := appPairKey(t.FromApp, t.ToApp)
+	if port == 0 && ports != nil {
+		port = ports.get(key)
+	}
+	if port > 0 {
+		ln, err = net.Listen("tcp", net.JoinHostPort("", strconv.Itoa(port)))
+		if err == nil {
+			goto OK
+		}
+		log.Errorf("app port %d of %s not available: %v", port, key, err)
+	}
 	for i := 0; i < 3; i++ {
-		port = getAppPort()
+		if ports != nil {
+			port = ports.next(key)
+		} else {
+			port = getAppPort()
+		}
 		address := net.JoinHostPort("", strconv.Itoa(port))
 		ln, err = net.Listen("tcp", address)
 		if err == nil {
@@ -468,6 +881,12 @@ func (t *Transport) ListenForApp(fn func(port int)) (err error) {
 	return
 
 OK:
+	if ports != nil {
+		e := ports.set(key, port)
+		if e != nil {
+			log.Errorf("save app port of %s: %v", key, e)
+		}
+	}
 	t.appNet = ln
 	t.servingPort = port
 
@@ -489,10 +908,15 @@ const (
 	PKG_HEADER_END
 )
 
+// MaxAppPayload is the most data of an app connection sent in one packet between the nodes
+const MaxAppPayload = cn.MAX_UDP_PACKAGE_SIZE - 100 - PKG_HEADER_END
+
 const (
 	OP_TRANSPORT = iota
 	OP_CLOSE
 	OP_SHUTDOWN
+	// a data frame with its sequence, sent once both nodes support FeatureSequence
+	OP_TRANSPORT_SEQ
 )
 
 func (t *Transport) accept() {
@@ -500,11 +924,16 @@ func (t *Transport) accept() {
 	tConn := t.conn
 	t.fieldsMutex.RUnlock()
 
-	go t.nodeReadLoop(tConn, func(id uint32) net.Conn {
-		t.connsMutex.RLock()
-		conn := t.conns[id]
-		t.connsMutex.RUnlock()
-		return conn
+	go t.nodeReadLoop(tConn, func(id uint32, frame LoopFrame) (l *loop, err error) {
+		t.connsMutex.Lock()
+		defer t.connsMutex.Unlock()
+		l, ok := t.loops[id]
+		if !ok {
+			err = ErrLoopUnknown
+			return
+		}
+		err = l.receive(frame)
+		return
 	})
 	var idSeq uint32
 	for {
@@ -512,11 +941,17 @@ func (t *Transport) accept() {
 		if err != nil {
 			return
 		}
-		id := atomic.AddUint32(&idSeq, 1)
+		err = util.ReserveFDs(0)
+		if err != nil {
+			log.Errorf("app conn from %s refused: %v", conn.RemoteAddr(), err)
+			conn.Close()
+			continue
+		}
+		l := &loop{id: atomic.AddUint32(&idSeq, 1), conn: conn, state: LoopRequested}
 		t.connsMutex.Lock()
-		t.conns[id] = conn
+		t.loops[l.id] = l
 		t.connsMutex.Unlock()
-		go t.appReadLoop(id, conn, tConn, true)
+		go t.appReadLoop(l, tConn, true)
 	}
 }
 
@@ -528,12 +963,33 @@ func (t *Transport) getDiscoveryKey() cipher.PubKey {
 }
 
 func (t *Transport) Close() {
+	t.close(CloseUnknown, true)
+}
+
+// CloseWithReason closes the transport and tells the other node and the apps why
+func (t *Transport) CloseWithReason(reason CloseReason) {
+	t.close(reason, true)
+}
+
+// CloseReason returns why the transport was closed
+func (t *Transport) CloseReason() (reason CloseReason) {
+	t.fieldsMutex.RLock()
+	reason = t.closeReason
+	t.fieldsMutex.RUnlock()
+	return
+}
+
+// close tells the other node why, unless it closed the transport, and the local app
+// of every connection left open
+func (t *Transport) close(reason CloseReason, notifyPeer bool) {
 	t.fieldsMutex.Lock()
 	defer t.fieldsMutex.Unlock()
 
 	if t.factory == nil {
 		return
 	}
+	t.closeReason = reason
+	t.setupDone()
 
 	var key cipher.PubKey
 	if t.clientSide {
@@ -542,10 +998,12 @@ func (t *Transport) Close() {
 		key = t.FromApp
 	}
 	tr, ok := t.appConnHolder.getTransport(key)
-	if !ok || !t.clientSide || tr == t {
+	if t.standby {
+		t.appConnHolder.takeStandby(key, t)
+	} else if !ok || tr == t {
 		msg := PriorityMsg{
 			Priority: TransportClosed,
-			Msg:      fmt.Sprintf("Discovery(%s): Transport closed", t.getDiscoveryKey().Hex()),
+			Msg:      fmt.Sprintf("Discovery(%s): Transport closed: %s", t.getDiscoveryKey().Hex(), reason),
 			Type:     Failed,
 		}
 		t.appConnHolder.PutMessage(msg)
@@ -556,29 +1014,72 @@ func (t *Transport) Close() {
 			Msg:       msg,
 		})
 		t.appConnHolder.deleteTransport(key)
+		if !reason.transportFailed() {
+			// the apps or a policy ended the transport, its standby is of no use
+			if standby := t.appConnHolder.takeStandby(key, nil); standby != nil {
+				go standby.CloseWithReason(reason)
+			}
+		} else if !t.clientSide {
+			// node A fails over to the standby, it serves the app on node B from now on
+			if standby := t.appConnHolder.takeStandby(key, nil); standby != nil {
+				standby.promote(key)
+			}
+		}
 	}
 
+	// a setup span still open did not complete
+	t.span.Fail("transport closed")
+	t.span.End()
 	if t.timeoutTimer != nil {
 		t.timeoutTimer.Stop()
 	}
-	t.connsMutex.RLock()
-	for _, v := range t.conns {
-		if v == nil {
+	t.connsMutex.Lock()
+	for _, l := range t.loops {
+		if l.conn == nil || l.transition(LoopClosing) != nil {
 			continue
 		}
-		v.Close()
+		t.appConnClosed(l.conn, reason)
+		l.conn.Close()
+		l.transition(LoopClosed)
 	}
-	t.connsMutex.RUnlock()
+	t.connsMutex.Unlock()
 	if t.appNet != nil {
 		t.appNet.Close()
 		t.appNet = nil
 	}
 	if t.conn != nil {
+		if t.clientSide {
+			t.creator.getPeerStore().measured(t.ToNode, t.conn.getRTT())
+		}
+		if notifyPeer && t.features.Has(FeatureCloseReasons) {
+			// best effort, the other node sees a route failure if it is lost
+			m := make([]byte, PKG_HEADER_END+1)
+			m[PKG_HEADER_OP_BEGIN] = OP_SHUTDOWN
+			m[PKG_HEADER_END] = byte(reason)
+			if err := t.conn.Write(m); err != nil {
+				t.Logger().Debugf("write transport shutdown: %v", err)
+			}
+		}
 		t.conn.Close()
 		t.conn = nil
 	}
+	if !t.clientSide {
+		t.creator.deletePendingTransport(t)
+		go t.creator.capacityChanged()
+	} else {
+		t.appConnHolder.setups.Delete(t)
+	}
+	if t.clientSide && t.routeFromApp != EMPTY_PUBLIC_KEY {
+		t.creator.privateRoutes.Delete(t.routeFromApp)
+	}
 	t.factory.Close()
 	t.factory = nil
+	if fn := t.creator.getOnTransportClosed(); fn != nil {
+		go fn(t)
+	}
+	if t.critical && (t.standby || t.servingPort > 0) && reason.transportFailed() {
+		go t.creator.failover(t)
+	}
 }
 
 func (t *Transport) IsClientSide() bool {
@@ -599,6 +1100,11 @@ func writeAll(conn io.Writer, m []byte) error {
 	return nil
 }
 
+// IsStandby tells whether the transport is kept for a critical transport and serves no app
+func (t *Transport) IsStandby() bool {
+	return t.isStandby()
+}
+
 func (t *Transport) GetServingPort() int {
 	t.fieldsMutex.RLock()
 	port := t.servingPort
@@ -606,22 +1112,6 @@ func (t *Transport) GetServingPort() int {
 	return port
 }
 
-func (t *Transport) SetupTimeout() {
-	t.fieldsMutex.Lock()
-	if t.timeoutTimer != nil {
-		t.timeoutTimer.Stop()
-	}
-	t.timeoutTimer = time.AfterFunc(30*time.Second, func() {
-		t.appConnHolder.PutMessage(PriorityMsg{
-			Type:     Failed,
-			Msg:      "Timeout",
-			Priority: Timeout,
-		})
-		t.Close()
-	})
-	t.fieldsMutex.Unlock()
-}
-
 func (t *Transport) StopTimeout() {
 	t.fieldsMutex.Lock()
 	if t.timeoutTimer != nil {
@@ -690,3 +1180,18 @@ func (t *Transport) GetUploadTotal() uint {
 func (t *Transport) GetDownloadTotal() uint {
 	return t.downloadBW.getTotal()
 }
+
+// SetOnTransportClosed calls fn with every transport of the apps once it is closed, when
+// its totals do not change anymore
+func (f *MessengerFactory) SetOnTransportClosed(fn func(t *Transport)) {
+	f.fieldsMutex.Lock()
+	f.onTransportClosed = fn
+	f.fieldsMutex.Unlock()
+}
+
+func (f *MessengerFactory) getOnTransportClosed() (fn func(t *Transport)) {
+	f.fieldsMutex.RLock()
+	fn = f.onTransportClosed
+	f.fieldsMutex.RUnlock()
+	return
+}
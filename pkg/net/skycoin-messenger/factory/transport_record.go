@@ -0,0 +1,158 @@
+package factory
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+var (
+	errRecordSig      = errors.New("invalid signature of the transport record")
+	errRecordUnsigned = errors.New("unsigned transport record")
+)
+
+// TransportRecord binds a transport to both of its nodes. Node A signs it with the setup,
+// node B signs it once it agreed to the transport, so the discovery only pairs the nodes
+// that both agreed and a node can not claim a transport to a node that never took it.
+// The apps are the ids in the messages through the discovery, route ids in a private setup
+type TransportRecord struct {
+	// random uuid of the transport
+	ID       string
+	FromNode cipher.PubKey
+	ToNode   cipher.PubKey
+	FromApp  cipher.PubKey
+	ToApp    cipher.PubKey
+	// signatures of node A and node B, empty from nodes before the records
+	FromSig []byte `json:",omitempty"`
+	ToSig   []byte `json:",omitempty"`
+}
+
+// newTransportID returns a random uuid
+func newTransportID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+func (r *TransportRecord) hash() cipher.SHA256 {
+	b := append([]byte("skywire-transport:"+r.ID), r.FromNode[:]...)
+	b = append(b, r.ToNode[:]...)
+	b = append(b, r.FromApp[:]...)
+	b = append(b, r.ToApp[:]...)
+	return cipher.SumSHA256(b)
+}
+
+func (r *TransportRecord) sign(sc *SeedConfig) (sig []byte, err error) {
+	if sc == nil {
+		err = errors.New("GetDefaultSeedConfig is nil")
+		return
+	}
+	s := cipher.SignHash(r.hash(), sc.secKey)
+	sig = s[:]
+	return
+}
+
+func (r *TransportRecord) verifySig(key cipher.PubKey, sig []byte) error {
+	var s cipher.Sig
+	if len(sig) != len(s) {
+		return errRecordSig
+	}
+	copy(s[:], sig)
+	if cipher.VerifySignature(key, s, r.hash()) != nil {
+		return errRecordSig
+	}
+	return nil
+}
+
+// Signed returns true if both nodes signed the record
+func (r *TransportRecord) Signed() bool {
+	return len(r.FromSig) > 0 && len(r.ToSig) > 0
+}
+
+// Verify returns an error unless both nodes signed the record
+func (r *TransportRecord) Verify() error {
+	if !r.Signed() {
+		return errRecordUnsigned
+	}
+	if err := r.verifySig(r.FromNode, r.FromSig); err != nil {
+		return err
+	}
+	return r.verifySig(r.ToNode, r.ToSig)
+}
+
+// SetRequireSignedTransports makes the discovery refuse the transports of the nodes that
+// do not sign the transport records, the nodes before the records are refused too
+func (f *MessengerFactory) SetRequireSignedTransports(require bool) {
+	f.fieldsMutex.Lock()
+	f.requireSignedTransports = require
+	f.fieldsMutex.Unlock()
+}
+
+func (f *MessengerFactory) signedTransportsRequired() (require bool) {
+	f.fieldsMutex.RLock()
+	require = f.requireSignedTransports
+	f.fieldsMutex.RUnlock()
+	return
+}
+
+// ForEachTransportRecord calls fn with the record of every transport the discovery paired,
+// the records of older nodes are unsigned
+func (f *MessengerFactory) ForEachTransportRecord(fn func(r TransportRecord)) {
+	var records []TransportRecord
+	m := globalTransportPairManagerInstance
+	m.pairsMutex.RLock()
+	for _, p := range m.pairs {
+		p.fieldsMutex.RLock()
+		if p.timeoutTimer == nil && !p.closed {
+			records = append(records, p.record)
+		}
+		p.fieldsMutex.RUnlock()
+	}
+	m.pairsMutex.RUnlock()
+	for _, r := range records {
+		fn(r)
+	}
+}
+
+// checkRecordFrom verifies the signature of node A on the discovery, a node setting up a
+// transport signs its record unless it is older than the records
+func (f *MessengerFactory) checkRecordFrom(r *TransportRecord) error {
+	if len(r.FromSig) == 0 {
+		if f.signedTransportsRequired() {
+			return errRecordUnsigned
+		}
+		return nil
+	}
+	return r.verifySig(r.FromNode, r.FromSig)
+}
+
+// checkRecordTo verifies the signature of node B on the discovery
+func (f *MessengerFactory) checkRecordTo(r *TransportRecord) error {
+	if len(r.ToSig) == 0 {
+		if f.signedTransportsRequired() {
+			return errRecordUnsigned
+		}
+		return nil
+	}
+	return r.verifySig(r.ToNode, r.ToSig)
+}
+
+// setRecord keeps the record of the transport, signed is true if both nodes signed it
+func (t *Transport) setRecord(r TransportRecord, signed bool) {
+	t.fieldsMutex.Lock()
+	t.record = r
+	t.recordSigned = signed
+	t.fieldsMutex.Unlock()
+}
+
+// Record returns the record of the transport and whether both nodes signed it
+func (t *Transport) Record() (r TransportRecord, signed bool) {
+	t.fieldsMutex.RLock()
+	r, signed = t.record, t.recordSigned
+	t.fieldsMutex.RUnlock()
+	return
+}
@@ -0,0 +1,129 @@
+package factory
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+func TestTransportRecordVerify(t *testing.T) {
+	a, b, other := NewSeedConfig(), NewSeedConfig(), NewSeedConfig()
+	record := func() *TransportRecord {
+		return &TransportRecord{ID: newTransportID(), FromNode: a.publicKey, ToNode: b.publicKey,
+			FromApp: cipher.PubKey([33]byte{0x03, 1}), ToApp: cipher.PubKey([33]byte{0x03, 2})}
+	}
+	sign := func(r *TransportRecord, sc *SeedConfig) []byte {
+		sig, err := r.sign(sc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sig
+	}
+	for _, c := range []struct {
+		name string
+		make func() *TransportRecord
+		// the discovery takes it from node A and node B, without and with signatures required
+		from, to, fromRequired, toRequired bool
+		verified                           bool
+	}{
+		{name: "unsigned", make: record, from: true, to: true},
+		{name: "signed by node A", make: func() *TransportRecord {
+			r := record()
+			r.FromSig = sign(r, a)
+			return r
+		}, from: true, to: true, fromRequired: true},
+		{name: "signed by both", make: func() *TransportRecord {
+			r := record()
+			r.FromSig, r.ToSig = sign(r, a), sign(r, b)
+			return r
+		}, from: true, to: true, fromRequired: true, toRequired: true, verified: true},
+		{name: "signed by another node", make: func() *TransportRecord {
+			r := record()
+			r.FromSig, r.ToSig = sign(r, a), sign(r, other)
+			return r
+		}, from: true, fromRequired: true},
+		{name: "signatures swapped", make: func() *TransportRecord {
+			r := record()
+			r.FromSig, r.ToSig = sign(r, b), sign(r, a)
+			return r
+		}},
+		{name: "changed after signing", make: func() *TransportRecord {
+			r := record()
+			r.FromSig, r.ToSig = sign(r, a), sign(r, b)
+			r.ToApp = cipher.PubKey([33]byte{0x03, 3})
+			return r
+		}},
+		{name: "truncated signature", make: func() *TransportRecord {
+			r := record()
+			r.FromSig = sign(r, a)[1:]
+			return r
+		}, to: true},
+	} {
+		r := c.make()
+		if err := r.Verify(); (err == nil) != c.verified {
+			t.Errorf("%s: verify %v", c.name, err)
+		}
+		f := NewMessengerFactory()
+		for _, required := range []bool{false, true} {
+			f.SetRequireSignedTransports(required)
+			from, to := c.from, c.to
+			if required {
+				from, to = c.fromRequired, c.toRequired
+			}
+			if err := f.checkRecordFrom(r); (err == nil) != from {
+				t.Errorf("%s: from node A, required %v: %v", c.name, required, err)
+			}
+			if err := f.checkRecordTo(r); (err == nil) != to {
+				t.Errorf("%s: from node B, required %v: %v", c.name, required, err)
+			}
+		}
+	}
+	if _, err := record().sign(nil); err == nil {
+		t.Error("signed without a seed")
+	}
+}
+
+func TestTransportID(t *testing.T) {
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	ids := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		id := newTransportID()
+		if !uuid.MatchString(id) || ids[id] {
+			t.Fatalf("transport id %s", id)
+		}
+		ids[id] = true
+	}
+}
+
+func TestForEachTransportRecord(t *testing.T) {
+	app, node := cipher.PubKey([33]byte{0x03, 0xfe}), cipher.PubKey([33]byte{0x02, 0xfe})
+	var pairs []*transportPair
+	for i, state := range []string{"pending", "paired", "closed"} {
+		p := globalTransportPairManagerInstance.create(app, node, node, cipher.PubKey([33]byte{0x03, 0xfe, byte(i)}))
+		p.setRecord(TransportRecord{ID: state})
+		if state != "pending" {
+			p.ok()
+		}
+		if state == "closed" {
+			p.fieldsMutex.Lock()
+			p.closed = true
+			p.fieldsMutex.Unlock()
+		}
+		pairs = append(pairs, p)
+	}
+	defer func() {
+		for _, p := range pairs {
+			p.close()
+		}
+	}()
+	var ids []string
+	NewMessengerFactory().ForEachTransportRecord(func(r TransportRecord) {
+		if r.ID == "pending" || r.ID == "paired" || r.ID == "closed" {
+			ids = append(ids, r.ID)
+		}
+	})
+	if len(ids) != 1 || ids[0] != "paired" {
+		t.Fatalf("records %v", ids)
+	}
+}
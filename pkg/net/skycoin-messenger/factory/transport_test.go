@@ -0,0 +1,53 @@
+package factory
+
+import (
+	"testing"
+	"time"
+
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+func TestPlainDecision(t *testing.T) {
+	// node B can not make plain a transport node A did not ask for
+	tr := &Transport{}
+	tr.decidePlain(true)
+	if tr.IsPlain() || tr.waitPlain() {
+		t.Fatal("plain without asking")
+	}
+
+	// the packets of node B wait for its answer
+	tr = &Transport{}
+	tr.askPlain()
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		tr.decidePlain(true)
+	}()
+	if !tr.waitPlain() || !tr.IsPlain() {
+		t.Fatal("agreed transport encrypted")
+	}
+	// answered twice
+	tr.decidePlain(true)
+
+	tr = &Transport{}
+	tr.askPlain()
+	tr.decidePlain(false)
+	if tr.waitPlain() || tr.IsPlain() {
+		t.Fatal("refused transport plain")
+	}
+}
+
+func TestAllowsPlainTransport(t *testing.T) {
+	f := NewMessengerFactory()
+	listed, other := cipher.PubKey{0x02, 1}, cipher.PubKey{0x02, 2}
+	if f.allowsPlainTransport(listed) {
+		t.Fatal("allowed without nodes set")
+	}
+	f.SetPlainTransportNodes([]cipher.PubKey{listed})
+	if !f.allowsPlainTransport(listed) || f.allowsPlainTransport(other) {
+		t.Fatal("allowed the wrong nodes")
+	}
+	f.SetPlainTransportNodes(nil)
+	if f.allowsPlainTransport(listed) {
+		t.Fatal("still allowed after reset")
+	}
+}
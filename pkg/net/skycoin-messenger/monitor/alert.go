@@ -0,0 +1,515 @@
+package monitor
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/skycoin/skycoin/src/util/file"
+	"github.com/skycoin/skywire/pkg/httputil"
+)
+
+var alertPath = filepath.Join(file.UserHome(), ".skywire", "manager", "alerts.json")
+
+const (
+	// node is not connected for longer than For
+	AlertNodeOffline = "node_offline"
+	// transport count changed at least Threshold times within For
+	AlertTransportFlapping = "transport_flapping"
+	// failed apps appeared at least Threshold times within For
+	AlertAppCrashLoop = "app_crash_loop"
+	// the watchdog of the node reported exceeded resource limits for For
+	AlertNodeResources = "node_resources"
+)
+
+const (
+	SinkWebhook  = "webhook"
+	SinkEmail    = "email"
+	SinkTelegram = "telegram"
+)
+
+var alertSinkTimeout = 10 * time.Second
+
+// shown instead of the secrets of the sinks, a sink set with it keeps the secret it had
+const maskedSecret = "********"
+
+type AlertRule struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	// seconds
+	For       int64 `json:"for"`
+	Threshold int   `json:"threshold"`
+	// node group the rule applies to, empty for all nodes
+	Group string `json:"group,omitempty"`
+	// names of the sinks to notify
+	Sinks []string `json:"sinks"`
+}
+
+type AlertSink struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	// webhook
+	URL string `json:"url,omitempty"`
+	// email
+	SMTPAddr string   `json:"smtp_addr,omitempty"`
+	SMTPUser string   `json:"smtp_user,omitempty"`
+	SMTPPass string   `json:"smtp_pass,omitempty"`
+	From     string   `json:"from,omitempty"`
+	To       []string `json:"to,omitempty"`
+	// telegram
+	BotToken string `json:"bot_token,omitempty"`
+	ChatID   string `json:"chat_id,omitempty"`
+}
+
+type AlertConfig struct {
+	Rules []AlertRule `json:"rules"`
+	Sinks []AlertSink `json:"sinks"`
+}
+
+func (c *AlertConfig) validate() error {
+	sinks := make(map[string]bool)
+	for _, s := range c.Sinks {
+		switch s.Type {
+		case SinkWebhook, SinkEmail, SinkTelegram:
+		default:
+			return fmt.Errorf("sink %s: unknown type %s", s.Name, s.Type)
+		}
+		sinks[s.Name] = true
+	}
+	for _, r := range c.Rules {
+		switch r.Type {
+		case AlertNodeOffline, AlertTransportFlapping, AlertAppCrashLoop, AlertNodeResources:
+		default:
+			return fmt.Errorf("rule %s: unknown type %s", r.Name, r.Type)
+		}
+		if r.For <= 0 {
+			return fmt.Errorf("rule %s: for must be positive", r.Name)
+		}
+		for _, s := range r.Sinks {
+			if !sinks[s] {
+				return fmt.Errorf("rule %s: unknown sink %s", r.Name, s)
+			}
+		}
+	}
+	return nil
+}
+
+// masked returns the config with the secrets of the sinks hidden
+func (c AlertConfig) masked() AlertConfig {
+	sinks := make([]AlertSink, len(c.Sinks))
+	for i, s := range c.Sinks {
+		if len(s.SMTPPass) > 0 {
+			s.SMTPPass = maskedSecret
+		}
+		if len(s.BotToken) > 0 {
+			s.BotToken = maskedSecret
+		}
+		sinks[i] = s
+	}
+	c.Sinks = sinks
+	return c
+}
+
+// keepSecrets puts back the secrets of the sinks set with the masked ones,
+// from the sinks of the same name in the old config (old)
+func (c *AlertConfig) keepSecrets(old AlertConfig) error {
+	for i := range c.Sinks {
+		s := &c.Sinks[i]
+		if s.SMTPPass != maskedSecret && s.BotToken != maskedSecret {
+			continue
+		}
+		var o *AlertSink
+		for j := range old.Sinks {
+			if old.Sinks[j].Name == s.Name {
+				o = &old.Sinks[j]
+			}
+		}
+		if o == nil {
+			return fmt.Errorf("sink %s: no secret to keep", s.Name)
+		}
+		if s.SMTPPass == maskedSecret {
+			s.SMTPPass = o.SMTPPass
+		}
+		if s.BotToken == maskedSecret {
+			s.BotToken = o.BotToken
+		}
+	}
+	return nil
+}
+
+// Alert is a firing rule for a node
+type Alert struct {
+	Rule    string `json:"rule"`
+	Type    string `json:"type"`
+	Node    string `json:"node"`
+	Since   int64  `json:"since"`
+	Message string `json:"message"`
+}
+
+type alerts struct {
+	path   string
+	config AlertConfig
+	// rule name + node key => alert
+	active map[string]*Alert
+	sync.RWMutex
+}
+
+func newAlerts(path string) *alerts {
+	a := &alerts{path: path, active: make(map[string]*Alert)}
+	fb, err := ioutil.ReadFile(path)
+	if err == nil {
+		err = json.Unmarshal(fb, &a.config)
+		if err != nil {
+			log.Errorf("read alert config err: %v", err)
+		}
+	}
+	return a
+}
+
+func (a *alerts) setConfig(c AlertConfig) (err error) {
+	d, err := json.Marshal(c)
+	if err != nil {
+		return
+	}
+	err = os.MkdirAll(filepath.Dir(a.path), 0700)
+	if err != nil {
+		return
+	}
+	err = ioutil.WriteFile(a.path, d, 0600)
+	if err != nil {
+		return
+	}
+	a.Lock()
+	a.config = c
+	a.active = make(map[string]*Alert)
+	a.Unlock()
+	return
+}
+
+func (a *alerts) getConfig() AlertConfig {
+	a.RLock()
+	defer a.RUnlock()
+	return a.config
+}
+
+func (a *alerts) list() (result []Alert) {
+	a.RLock()
+	defer a.RUnlock()
+	result = make([]Alert, 0, len(a.active))
+	for _, v := range a.active {
+		result = append(result, *v)
+	}
+	return
+}
+
+// samples of a node since the unix time (since) in the finest tier
+func (h *history) recent(key string, since int64) (result []Sample) {
+	h.RLock()
+	defer h.RUnlock()
+	n, ok := h.nodes[key]
+	if !ok || len(n.Tiers) == 0 {
+		return
+	}
+	for _, s := range n.Tiers[0] {
+		if s.Time >= since {
+			result = append(result, s)
+		}
+	}
+	return
+}
+
+func (h *history) lastSeen() (result map[string]int64) {
+	result = make(map[string]int64)
+	h.RLock()
+	defer h.RUnlock()
+	for k, n := range h.nodes {
+		if len(n.Tiers) == 0 || len(n.Tiers[0]) == 0 {
+			continue
+		}
+		result[k] = n.Tiers[0][len(n.Tiers[0])-1].Time
+	}
+	return
+}
+
+// evaluateAlerts checks all rules against the node history,
+// the sinks are notified when an alert fires and when it resolves
+func (m *Monitor) evaluateAlerts() {
+	config := m.alerts.getConfig()
+	if len(config.Rules) == 0 {
+		return
+	}
+	u, _ := readUserConfig(userPath)
+	now := time.Now().Unix()
+	seen := m.history.lastSeen()
+	for _, rule := range config.Rules {
+		for node, last := range seen {
+			if len(rule.Group) > 0 && (u == nil || !containsString(u.Groups[rule.Group], node)) {
+				continue
+			}
+			msg, firing := m.evaluateRule(rule, node, last, now)
+			m.updateAlert(config, rule, node, msg, firing, now)
+		}
+	}
+}
+
+func (m *Monitor) evaluateRule(rule AlertRule, node string, last, now int64) (msg string, firing bool) {
+	switch rule.Type {
+	case AlertNodeOffline:
+		connected := false
+		if k, err := pubKeyFromHex(node); err == nil {
+			_, connected = m.factory.GetConnection(k)
+		}
+		if !connected && now-last > rule.For {
+			return fmt.Sprintf("node %s offline for %ds", node, now-last), true
+		}
+	case AlertTransportFlapping:
+		samples := m.history.recent(node, now-rule.For)
+		changes := 0
+		for i := 1; i < len(samples); i++ {
+			if samples[i].Transports != samples[i-1].Transports {
+				changes++
+			}
+		}
+		if changes >= rule.Threshold {
+			return fmt.Sprintf("node %s transports changed %d times in %ds", node, changes, rule.For), true
+		}
+	case AlertAppCrashLoop:
+		samples := m.history.recent(node, now-rule.For)
+		crashes := 0
+		for i := 1; i < len(samples); i++ {
+			if samples[i].FailedApps > samples[i-1].FailedApps {
+				crashes++
+			}
+		}
+		if crashes >= rule.Threshold {
+			return fmt.Sprintf("node %s apps failed %d times in %ds", node, crashes, rule.For), true
+		}
+	case AlertNodeResources:
+		samples := m.history.recent(node, now-rule.For)
+		if len(samples) == 0 || now-samples[0].Time < rule.For/2 {
+			return
+		}
+		for _, s := range samples {
+			if s.ResourcesExceeded == 0 {
+				return
+			}
+		}
+		return fmt.Sprintf("node %s exceeds resource limits for %ds", node, rule.For), true
+	}
+	return
+}
+
+func (m *Monitor) updateAlert(config AlertConfig, rule AlertRule, node, msg string, firing bool, now int64) {
+	id := rule.Name + "/" + node
+	m.alerts.Lock()
+	a, active := m.alerts.active[id]
+	switch {
+	case firing && !active:
+		a = &Alert{Rule: rule.Name, Type: rule.Type, Node: node, Since: now, Message: msg}
+		m.alerts.active[id] = a
+	case !firing && active:
+		delete(m.alerts.active, id)
+	default:
+		m.alerts.Unlock()
+		return
+	}
+	m.alerts.Unlock()
+	text := fmt.Sprintf("[FIRING] %s: %s", rule.Name, msg)
+	if !firing {
+		text = fmt.Sprintf("[RESOLVED] %s: node %s", rule.Name, node)
+	}
+	for _, name := range rule.Sinks {
+		for _, s := range config.Sinks {
+			if s.Name != name {
+				continue
+			}
+			go func(s AlertSink, alert Alert) {
+				err := s.notify(text, alert, firing)
+				if err != nil {
+					log.Errorf("alert sink %s err: %v", s.Name, err)
+				}
+			}(s, *a)
+		}
+	}
+}
+
+func (s *AlertSink) notify(text string, alert Alert, firing bool) (err error) {
+	client := &http.Client{Timeout: alertSinkTimeout}
+	switch s.Type {
+	case SinkWebhook:
+		var d []byte
+		d, err = json.Marshal(struct {
+			Alert
+			Firing bool   `json:"firing"`
+			Text   string `json:"text"`
+		}{alert, firing, text})
+		if err != nil {
+			return
+		}
+		var res *http.Response
+		res, err = client.Post(s.URL, "application/json", bytes.NewReader(d))
+		if err != nil {
+			return
+		}
+		res.Body.Close()
+		if res.StatusCode >= 300 {
+			err = fmt.Errorf("webhook returned %d", res.StatusCode)
+		}
+	case SinkEmail:
+		host := s.SMTPAddr
+		if i := strings.LastIndex(host, ":"); i >= 0 {
+			host = host[:i]
+		}
+		var auth smtp.Auth
+		if len(s.SMTPUser) > 0 {
+			auth = smtp.PlainAuth("", s.SMTPUser, s.SMTPPass, host)
+		}
+		body := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\n\r\n%s\r\n",
+			s.From, strings.Join(s.To, ", "), text, alert.Message)
+		err = sendMail(s.SMTPAddr, host, auth, s.From, s.To, []byte(body))
+	case SinkTelegram:
+		var res *http.Response
+		res, err = client.PostForm("https://api.telegram.org/bot"+s.BotToken+"/sendMessage",
+			url.Values{"chat_id": {s.ChatID}, "text": {text}})
+		if err != nil {
+			return
+		}
+		res.Body.Close()
+		if res.StatusCode >= 300 {
+			err = fmt.Errorf("telegram returned %d", res.StatusCode)
+		}
+	default:
+		err = errors.New("unknown sink type")
+	}
+	return
+}
+
+// sendMail is smtp.SendMail bounded by alertSinkTimeout, so a server that stops answering
+// does not hold the notification forever
+func sendMail(addr, host string, auth smtp.Auth, from string, to []string, msg []byte) (err error) {
+	conn, err := net.DialTimeout("tcp", addr, alertSinkTimeout)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	err = conn.SetDeadline(time.Now().Add(alertSinkTimeout))
+	if err != nil {
+		return
+	}
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		err = c.StartTLS(&tls.Config{ServerName: host})
+		if err != nil {
+			return
+		}
+	}
+	if auth != nil {
+		if ok, _ := c.Extension("AUTH"); ok {
+			err = c.Auth(auth)
+			if err != nil {
+				return
+			}
+		}
+	}
+	err = c.Mail(from)
+	if err != nil {
+		return
+	}
+	for _, addr := range to {
+		err = c.Rcpt(addr)
+		if err != nil {
+			return
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return
+	}
+	_, err = w.Write(msg)
+	if err != nil {
+		return
+	}
+	err = w.Close()
+	if err != nil {
+		return
+	}
+	return c.Quit()
+}
+
+// the sinks and rules are of all the nodes, only an admin of all the nodes sees and sets them
+func (m *Monitor) getAlertConfig(w http.ResponseWriter, r *http.Request) (result []byte, err error, code int) {
+	if !m.authorizeAllNodes(w, r) {
+		return
+	}
+	result, err = json.Marshal(m.alerts.getConfig().masked())
+	return
+}
+
+func (m *Monitor) setAlertConfig(w http.ResponseWriter, r *http.Request) (result []byte, err error, code int) {
+	if !m.authorizeAllNodes(w, r) {
+		return
+	}
+	if r.Method != "POST" {
+		code = BAD_REQUEST
+		err = errors.New("please use post method")
+		return
+	}
+	var config AlertConfig
+	err = json.Unmarshal([]byte(r.FormValue("data")), &config)
+	if err != nil {
+		code = BAD_REQUEST
+		return
+	}
+	err = config.validate()
+	if err != nil {
+		code = BAD_REQUEST
+		return
+	}
+	err = config.keepSecrets(m.alerts.getConfig())
+	if err != nil {
+		code = BAD_REQUEST
+		return
+	}
+	err = m.alerts.setConfig(config)
+	if err != nil {
+		return
+	}
+	result = []byte("true")
+	return
+}
+
+func (m *Monitor) getActiveAlerts(w http.ResponseWriter, r *http.Request) (result []byte, err error, code int) {
+	p, ok := m.requestPrincipal(w, r)
+	if !ok {
+		return
+	}
+	if !p.Role.allows(RoleViewer) {
+		httputil.Fail(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	active := make([]Alert, 0)
+	for _, a := range m.alerts.list() {
+		if p.canAccess(a.Node) {
+			active = append(active, a)
+		}
+	}
+	result, err = json.Marshal(active)
+	return
+}
@@ -0,0 +1,263 @@
+package monitor
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+func TestNodeResourcesAlert(t *testing.T) {
+	tm := newTestMonitor(t, nil)
+	defer tm.close()
+	n, other := tm.connectNode(t), tm.connectNode(t)
+	rule := AlertRule{Name: "resources", Type: AlertNodeResources, For: 600}
+	if err := (&AlertConfig{Rules: []AlertRule{rule}}).validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the nodes report their exceeded limits in their info, the others have no watchdog
+	now := time.Now().Unix()
+	n.setExceeded("goroutines", "heap")
+	tm.sampleNodes()
+	if samples := tm.history.recent(n.key, 0); len(samples) != 1 || samples[0].ResourcesExceeded != 2 {
+		t.Fatalf("samples %+v", samples)
+	}
+	if samples := tm.history.recent(other.key, 0); len(samples) != 1 || samples[0].ResourcesExceeded != 0 {
+		t.Fatalf("samples of the other node %+v", samples)
+	}
+	// not for long enough
+	if _, firing := tm.evaluateRule(rule, n.key, now, now+60); firing {
+		t.Fatal("fired on the first sample")
+	}
+
+	tm.history.add(n.key, Sample{Time: now + 200, ResourcesExceeded: 1})
+	tm.history.add(other.key, Sample{Time: now + 200})
+	if msg, firing := tm.evaluateRule(rule, n.key, now, now+400); !firing || len(msg) == 0 {
+		t.Fatal("not fired on a node exceeding its limits")
+	}
+	if _, firing := tm.evaluateRule(rule, other.key, now, now+400); firing {
+		t.Fatal("fired on a node within its limits")
+	}
+
+	// a sample within the limits resolves it
+	tm.history.add(n.key, Sample{Time: now + 450})
+	if _, firing := tm.evaluateRule(rule, n.key, now, now+500); firing {
+		t.Fatal("fired on a node back within its limits")
+	}
+}
+
+func TestEvaluateRule(t *testing.T) {
+	tm := newTestMonitor(t, nil)
+	defer tm.close()
+	n := tm.connectNode(t)
+	offline := cipher.PubKey([33]byte{0x02, 0x01}).Hex()
+	now := time.Now().Unix()
+	// the transports of the node change twice and its apps fail twice within the last 5 minutes,
+	// the changes before are out of the rules, a sample is kept per minute
+	for i, s := range []Sample{
+		{Time: now - 3000, Transports: 5, FailedApps: 3},
+		{Time: now - 240, Transports: 1},
+		{Time: now - 180, Transports: 2, FailedApps: 1},
+		{Time: now - 120, Transports: 2, FailedApps: 0},
+		{Time: now - 60, Transports: 1, FailedApps: 2},
+	} {
+		tm.history.add(n.key, s)
+		if i == 0 {
+			tm.history.add(offline, s)
+		}
+	}
+
+	for _, c := range []struct {
+		name string
+		rule AlertRule
+		node string
+		last int64
+		want bool
+	}{
+		{name: "offline for longer", rule: AlertRule{Type: AlertNodeOffline, For: 120}, node: offline, last: now - 3000, want: true},
+		{name: "offline not for long enough", rule: AlertRule{Type: AlertNodeOffline, For: 6000}, node: offline, last: now - 3000},
+		{name: "connected", rule: AlertRule{Type: AlertNodeOffline, For: 120}, node: n.key, last: now - 3000},
+		{name: "transports flapping", rule: AlertRule{Type: AlertTransportFlapping, For: 300, Threshold: 2}, node: n.key, want: true},
+		{name: "transports flapping less than the threshold", rule: AlertRule{Type: AlertTransportFlapping, For: 300, Threshold: 3}, node: n.key},
+		{name: "transports flapping out of the window", rule: AlertRule{Type: AlertTransportFlapping, For: 90, Threshold: 1}, node: n.key},
+		{name: "apps crash looping", rule: AlertRule{Type: AlertAppCrashLoop, For: 300, Threshold: 2}, node: n.key, want: true},
+		{name: "apps crash looping less than the threshold", rule: AlertRule{Type: AlertAppCrashLoop, For: 300, Threshold: 3}, node: n.key},
+		{name: "no samples", rule: AlertRule{Type: AlertAppCrashLoop, For: 300, Threshold: 1}, node: offline},
+	} {
+		msg, firing := tm.evaluateRule(c.rule, c.node, c.last, now)
+		if firing != c.want || firing != (len(msg) > 0) {
+			t.Errorf("%s: firing %v %q, want %v", c.name, firing, msg, c.want)
+		}
+	}
+}
+
+func TestAlertWebhook(t *testing.T) {
+	tm := newTestMonitor(t, nil)
+	defer tm.close()
+	n := tm.connectNode(t)
+	type notification struct {
+		Alert
+		Firing bool   `json:"firing"`
+		Text   string `json:"text"`
+	}
+	notified := make(chan notification, 10)
+	sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var v notification
+		if err := json.NewDecoder(r.Body).Decode(&v); err != nil {
+			t.Error(err)
+		}
+		notified <- v
+	}))
+	defer sink.Close()
+	next := func() notification {
+		select {
+		case v := <-notified:
+			return v
+		case <-time.After(5 * time.Second):
+			t.Fatal("no notification")
+		}
+		return notification{}
+	}
+	config := AlertConfig{
+		Rules: []AlertRule{{Name: "resources", Type: AlertNodeResources, For: 120, Sinks: []string{"hook"}}},
+		Sinks: []AlertSink{{Name: "hook", Type: SinkWebhook, URL: sink.URL}},
+	}
+	if err := tm.alerts.setConfig(config); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().Unix()
+	tm.history.add(n.key, Sample{Time: now - 60, ResourcesExceeded: 1})
+	tm.history.add(n.key, Sample{Time: now, ResourcesExceeded: 1})
+	tm.evaluateAlerts()
+	if v := next(); !v.Firing || v.Rule != "resources" || v.Node != n.key || !strings.HasPrefix(v.Text, "[FIRING] resources") {
+		t.Fatalf("firing %+v", v)
+	}
+	if active := tm.alerts.list(); len(active) != 1 || active[0].Node != n.key {
+		t.Fatalf("active %+v", active)
+	}
+	// still firing, not notified again
+	tm.evaluateAlerts()
+
+	tm.history.add(n.key, Sample{Time: now + 60})
+	tm.evaluateAlerts()
+	if v := next(); v.Firing || v.Node != n.key || v.Text != "[RESOLVED] resources: node "+n.key {
+		t.Fatalf("resolved %+v", v)
+	}
+	if active := tm.alerts.list(); len(active) != 0 {
+		t.Fatalf("active after resolved %+v", active)
+	}
+	select {
+	case v := <-notified:
+		t.Fatalf("notified again %+v", v)
+	default:
+	}
+}
+
+func TestAlertConfigAccess(t *testing.T) {
+	tm := newTestMonitor(t, &User{
+		Accounts: append([]Account(nil), testAccounts...),
+		Groups:   map[string][]string{"g": nil},
+	})
+	defer tm.close()
+	u, err := readUserConfig(userPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.Accounts = append(u.Accounts, Account{Name: "group-admin", Pass: getBcrypt("group-admin-pass"), Role: RoleAdmin, Groups: []string{"g"}})
+	if err = WriteConfig(u, userPath); err != nil {
+		t.Fatal(err)
+	}
+	if err = tm.alerts.setConfig(AlertConfig{Sinks: []AlertSink{
+		{Name: "mail", Type: SinkEmail, SMTPAddr: "127.0.0.1:1", SMTPUser: "u", SMTPPass: "smtp-secret"},
+		{Name: "bot", Type: SinkTelegram, BotToken: "bot-secret", ChatID: "1"},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// only an admin of all the nodes sees and sets the config
+	for _, c := range []struct {
+		name string
+		c    *client
+	}{
+		{"viewer", tm.login(t, "viewer", "viewer-pass")},
+		{"operator", tm.login(t, "operator", "operator-pass")},
+		{"admin of a group", tm.login(t, "group-admin", "group-admin-pass")},
+	} {
+		if status, body := c.c.send(http.MethodGet, "/alert/getConfig", nil); status != http.StatusForbidden {
+			t.Errorf("%s get: %d %s", c.name, status, body)
+		}
+		if status, body := c.c.send(http.MethodPost, "/alert/setConfig", url.Values{"data": {"{}"}}); status != http.StatusForbidden {
+			t.Errorf("%s set: %d %s", c.name, status, body)
+		}
+	}
+	if len(tm.alerts.getConfig().Sinks) != 2 {
+		t.Fatalf("config changed %+v", tm.alerts.getConfig())
+	}
+
+	// the secrets are masked, set back masked they are kept
+	admin := tm.login(t, "admin", "admin-pass")
+	status, body := admin.send(http.MethodGet, "/alert/getConfig", nil)
+	if status != http.StatusOK || strings.Contains(body, "secret") || strings.Count(body, maskedSecret) != 2 {
+		t.Fatalf("get %d %s", status, body)
+	}
+	var config AlertConfig
+	if err = json.Unmarshal([]byte(body), &config); err != nil {
+		t.Fatal(err)
+	}
+	config.Sinks[0].SMTPUser = "v"
+	d, _ := json.Marshal(config)
+	if status, body = admin.send(http.MethodPost, "/alert/setConfig", url.Values{"data": {string(d)}}); status != http.StatusOK {
+		t.Fatalf("set %d %s", status, body)
+	}
+	if s := tm.alerts.getConfig().Sinks; s[0].SMTPUser != "v" || s[0].SMTPPass != "smtp-secret" || s[1].BotToken != "bot-secret" {
+		t.Fatalf("secrets not kept %+v", s)
+	}
+	// a masked secret of a new sink has nothing to keep
+	config.Sinks[1].Name = "new"
+	d, _ = json.Marshal(config)
+	if status, body = admin.send(http.MethodPost, "/alert/setConfig", url.Values{"data": {string(d)}}); status != http.StatusBadRequest {
+		t.Fatalf("set a masked new sink %d %s", status, body)
+	}
+}
+
+func TestSendMailTimeout(t *testing.T) {
+	// the server accepts the connection and never greets
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	timeout := alertSinkTimeout
+	alertSinkTimeout = 200 * time.Millisecond
+	defer func() { alertSinkTimeout = timeout }()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- sendMail(l.Addr().String(), "127.0.0.1", nil, "a@b", []string{"c@d"}, []byte("m"))
+	}()
+	select {
+	case err = <-done:
+		if err == nil {
+			t.Fatal("sent to a server not answering")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no timeout")
+	}
+}
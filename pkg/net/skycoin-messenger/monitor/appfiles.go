@@ -0,0 +1,105 @@
+package monitor
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/pkg/errors"
+	"github.com/skycoin/skycoin/src/cipher"
+	"github.com/skycoin/skywire/pkg/node"
+)
+
+// AppFileContent answers the read of an app config file
+type AppFileContent struct {
+	Path    string `json:"path"`
+	Content string `json:"content"`
+	SHA256  string `json:"sha256"`
+}
+
+// content of a file as recorded in the audit log
+func auditContent(d string) string {
+	return fmt.Sprintf("%d bytes, sha256 %s", len(d), cipher.SumSHA256([]byte(d)).Hex())
+}
+
+// authorizeAppFiles checks the request for the app config files of the node in the form value key
+func (m *Monitor) authorizeAppFiles(w http.ResponseWriter, r *http.Request, role Role) bool {
+	return m.authorize(w, r, role, r.FormValue("key"))
+}
+
+func (m *Monitor) listAppFiles(w http.ResponseWriter, r *http.Request) (result []byte, err error, code int) {
+	if !m.authorizeAppFiles(w, r, RoleOperator) {
+		return
+	}
+	res, err := m.nodeRequest(r.FormValue("key"), "/node/run/listAppFiles", url.Values{"app": {r.FormValue("app")}})
+	if err != nil {
+		code = SERVER_ERROR
+		return
+	}
+	result = []byte(res)
+	return
+}
+
+// readAppFile is recorded in the audit log as the app files may hold secrets
+func (m *Monitor) readAppFile(w http.ResponseWriter, r *http.Request) (result []byte, err error, code int) {
+	e := m.newAuditEntry(r, "appFiles/read")
+	e.Target = r.FormValue("app") + "/" + r.FormValue("path")
+	if !m.authorizeAppFiles(w, r, RoleOperator) {
+		e.Status = http.StatusForbidden
+		audit(e)
+		return
+	}
+	res, err := m.nodeRequest(e.Node, "/node/run/readAppFile", url.Values{"app": {r.FormValue("app")}, "path": {r.FormValue("path")}})
+	if err != nil {
+		code = SERVER_ERROR
+		e.Status, e.Error = code, err.Error()
+		audit(e)
+		return
+	}
+	e.Status = http.StatusOK
+	e.Old = auditContent(res)
+	audit(e)
+	result, err = json.Marshal(AppFileContent{Path: r.FormValue("path"), Content: res, SHA256: cipher.SumSHA256([]byte(res)).Hex()})
+	return
+}
+
+func (m *Monitor) writeAppFile(w http.ResponseWriter, r *http.Request) (result []byte, err error, code int) {
+	e := m.newAuditEntry(r, "appFiles/write")
+	app, path, data := r.FormValue("app"), r.FormValue("path"), r.FormValue("data")
+	e.Target = app + "/" + path
+	e.New = auditContent(data)
+	if !m.authorizeAppFiles(w, r, RoleAdmin) {
+		e.Status = http.StatusForbidden
+		audit(e)
+		return
+	}
+	defer func() {
+		e.Status = http.StatusOK
+		if err != nil {
+			e.Status, e.Error = code, err.Error()
+		}
+		audit(e)
+	}()
+	if r.Method != "POST" {
+		code = BAD_REQUEST
+		err = errors.New("please use post method")
+		return
+	}
+	if len(data) > node.MaxAppFileSize {
+		code = BAD_REQUEST
+		err = errors.Errorf("file is larger than %d bytes", node.MaxAppFileSize)
+		return
+	}
+	// a file that does not exist yet has no old content
+	if old, err := m.nodeRequest(e.Node, "/node/run/readAppFile", url.Values{"app": {app}, "path": {path}}); err == nil {
+		e.Old = auditContent(old)
+	}
+	_, err = m.nodeRequest(e.Node, "/node/run/writeAppFile", url.Values{"app": {app}, "path": {path}, "data": {data}})
+	if err != nil {
+		code = SERVER_ERROR
+		return
+	}
+	result = []byte("true")
+	return
+}
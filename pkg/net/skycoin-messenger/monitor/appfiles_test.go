@@ -0,0 +1,83 @@
+package monitor
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/skycoin/skywire/pkg/node"
+)
+
+func TestAppFilesAudit(t *testing.T) {
+	tm := newTestMonitor(t, &User{Accounts: append([]Account(nil), testAccounts...)})
+	defer tm.close()
+	n := tm.connectNode(t)
+	owner := tm.login(t, "", testPass)
+	operator := tm.login(t, "operator", "operator-pass")
+	send := func(c *client, path string, form url.Values) (int, string, AuditEntry) {
+		before := len(auditEntries(t))
+		status, body := c.send(http.MethodPost, path, form)
+		entries := auditEntries(t)
+		if len(entries) != before+1 {
+			t.Fatalf("%s: %d entries recorded", path, len(entries)-before)
+		}
+		return status, body, entries[before]
+	}
+	file := func(path, data string) url.Values {
+		return url.Values{"key": {n.key}, "app": {"sshs"}, "path": {path}, "data": {data}}
+	}
+
+	// a new file has no old content
+	status, body, e := send(owner, "/appFiles/write", file("config.json", "{}"))
+	if status != http.StatusOK || e.Action != "appFiles/write" || e.User != "owner" || e.Node != n.key ||
+		e.Target != "sshs/config.json" || len(e.Old) != 0 || e.New != auditContent("{}") || e.Status != http.StatusOK {
+		t.Fatalf("write: %d %s, recorded %+v", status, body, e)
+	}
+	// the replaced one has, the content itself is never recorded
+	status, body, e = send(owner, "/appFiles/write", file("config.json", `{"secret":"s3cret"}`))
+	if status != http.StatusOK || e.Old != auditContent("{}") || e.New != auditContent(`{"secret":"s3cret"}`) {
+		t.Fatalf("replace: %d %s, recorded %+v", status, body, e)
+	}
+	if strings.Contains(e.Old+e.New, "s3cret") {
+		t.Fatalf("content recorded %+v", e)
+	}
+	if data := n.file("sshs", "config.json"); data != `{"secret":"s3cret"}` {
+		t.Fatalf("file on the node %q", data)
+	}
+
+	// the reads are recorded too
+	status, body, e = send(operator, "/appFiles/read", file("config.json", ""))
+	var content AppFileContent
+	if status != http.StatusOK || json.Unmarshal([]byte(body), &content) != nil || content.Content != `{"secret":"s3cret"}` {
+		t.Fatalf("read: %d %s", status, body)
+	}
+	if e.Action != "appFiles/read" || e.User != "operator" || e.Old != auditContent(content.Content) || e.Status != http.StatusOK {
+		t.Fatalf("read recorded %+v", e)
+	}
+
+	// and so are the refused writes and the failed ones, the file left as it was
+	for _, c := range []struct {
+		c      *client
+		form   url.Values
+		status int
+	}{
+		{operator, file("config.json", "{}"), http.StatusForbidden},
+		{owner, file("config.json", strings.Repeat("a", node.MaxAppFileSize+1)), http.StatusBadRequest},
+		{owner, url.Values{"key": {"unknown"}, "app": {"sshs"}, "path": {"config.json"}, "data": {"{}"}}, SERVER_ERROR},
+	} {
+		status, body, e = send(c.c, "/appFiles/write", c.form)
+		if status == http.StatusOK || e.Status != c.status || e.Action != "appFiles/write" {
+			t.Errorf("%v: %d %s, recorded %+v", c.form.Get("key"), status, body, e)
+		}
+	}
+	n.failPath("/node/run/writeAppFile", true)
+	status, body, e = send(owner, "/appFiles/write", file("config.json", "{}"))
+	if status == http.StatusOK || e.Status != SERVER_ERROR || len(e.Error) == 0 {
+		t.Fatalf("failed write: %d %s, recorded %+v", status, body, e)
+	}
+	if data := n.file("sshs", "config.json"); data != `{"secret":"s3cret"}` {
+		t.Fatalf("file on the node %q", data)
+	}
+}
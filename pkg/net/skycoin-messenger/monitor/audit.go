@@ -0,0 +1,450 @@
+package monitor
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/skycoin/skycoin/src/util/file"
+	"github.com/skycoin/skywire/pkg/httputil"
+)
+
+var auditPath = filepath.Join(file.UserHome(), ".skywire", "manager", "audit.log")
+
+const (
+	// entries returned by /audit/get unless asked for fewer
+	auditDefaultLimit = 100
+	// old and new values are cut to this length
+	auditMaxValue = 4 << 10
+)
+
+// AuditEntry records an action of the manager, one json line in the append only audit log
+type AuditEntry struct {
+	Time int64 `json:"time"`
+	// account, token:<label> of an API token, or empty if the request was not authenticated
+	User string `json:"user"`
+	// address the request came from
+	Remote string `json:"remote"`
+	// api path of the action, e.g. conn/setNodeConfig
+	Action string `json:"action"`
+	Node   string `json:"node,omitempty"`
+	// what the action changed, e.g. the node api path of /req or the file of an app
+	Target string `json:"target,omitempty"`
+	// state before the action and the values it was called with, secrets are left out
+	Old    string `json:"old,omitempty"`
+	New    string `json:"new,omitempty"`
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+var auditMutex sync.Mutex
+
+func audit(e AuditEntry) {
+	e.Old, e.New = truncateAudit(e.Old), truncateAudit(e.New)
+	d, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	auditMutex.Lock()
+	defer auditMutex.Unlock()
+	err = os.MkdirAll(filepath.Dir(auditPath), 0700)
+	if err != nil {
+		log.Errorf("audit log: %v", err)
+		return
+	}
+	// only ever appended to, the entries are not rewritten or removed by the manager
+	f, err := os.OpenFile(auditPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Errorf("audit log: %v", err)
+		return
+	}
+	defer f.Close()
+	_, err = f.Write(append(d, '\n'))
+	if err != nil {
+		log.Errorf("audit log: %v", err)
+	}
+}
+
+func truncateAudit(v string) string {
+	if len(v) > auditMaxValue {
+		return v[:auditMaxValue] + "..."
+	}
+	return v
+}
+
+// readAudit returns the entries from from to to (unix times, 0 for no limit) of the nodes that
+// can be accessed, only of the node if not empty, and the actions not about a node
+func readAudit(node string, from, to int64, canAccess func(string) bool) (entries []AuditEntry, err error) {
+	entries = []AuditEntry{}
+	auditMutex.Lock()
+	defer auditMutex.Unlock()
+	f, err := os.Open(auditPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	s.Buffer(nil, 4*auditMaxValue)
+	for s.Scan() {
+		var e AuditEntry
+		if json.Unmarshal(s.Bytes(), &e) != nil {
+			continue
+		}
+		if (from > 0 && e.Time < from) || (to > 0 && e.Time > to) {
+			continue
+		}
+		if len(node) > 0 && e.Node != node {
+			continue
+		}
+		if len(e.Node) > 0 && !canAccess(e.Node) {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	err = s.Err()
+	return
+}
+
+// auditor records the calls of a state-changing action
+type auditor struct {
+	action string
+	// state before the action, recorded as old, may be nil
+	old func(m *Monitor, r *http.Request) string
+	// the requests it returns false for change nothing and are not recorded, may be nil
+	changes func(r *http.Request) bool
+	// the result of the action is recorded as new instead of its form values
+	result bool
+}
+
+// form values that are never recorded
+var auditSecretValues = map[string]bool{
+	"token":   true,
+	"pass":    true,
+	"oldPass": true,
+	"newPass": true,
+	"sig":     true,
+	"nonce":   true,
+	"otp":     true,
+	"code":    true,
+}
+
+// names of the json fields that are never recorded
+var auditSecretFields = []string{"pass", "token", "secret", "seckey"}
+
+// redactJSON replaces the secret fields of the json in v, v is returned as it is if it is not json
+func redactJSON(v string) string {
+	t := strings.TrimSpace(v)
+	if !strings.HasPrefix(t, "{") && !strings.HasPrefix(t, "[") {
+		return v
+	}
+	var doc interface{}
+	if json.Unmarshal([]byte(t), &doc) != nil {
+		return v
+	}
+	var walk func(interface{}) interface{}
+	walk = func(x interface{}) interface{} {
+		switch t := x.(type) {
+		case map[string]interface{}:
+			for k, v := range t {
+				secret := false
+				for _, s := range auditSecretFields {
+					if strings.Contains(strings.ToLower(k), s) {
+						secret = true
+						break
+					}
+				}
+				if secret {
+					t[k] = "***"
+				} else {
+					t[k] = walk(v)
+				}
+			}
+		case []interface{}:
+			for i := range t {
+				t[i] = walk(t[i])
+			}
+		}
+		return x
+	}
+	d, err := json.Marshal(walk(doc))
+	if err != nil {
+		return v
+	}
+	return string(d)
+}
+
+// auditValues returns the form values of the request without the secrets as json
+func auditValues(r *http.Request) string {
+	values := make(map[string]string)
+	for k, v := range r.Form {
+		if auditSecretValues[k] || len(v) == 0 {
+			continue
+		}
+		values[k] = redactJSON(v[0])
+	}
+	if len(values) == 0 {
+		return ""
+	}
+	d, _ := json.Marshal(values)
+	return string(d)
+}
+
+// auditUser returns the name the request is recorded for
+func (m *Monitor) auditUser(r *http.Request) string {
+	if token, has := bearerToken(r); has {
+		if t, found := m.tokens.lookup(token); found {
+			return "token:" + t.Label
+		}
+		return ""
+	}
+	c, err := r.Cookie("SWSId")
+	if err != nil {
+		return ""
+	}
+	sid, err := url.QueryUnescape(c.Value)
+	if err != nil {
+		return ""
+	}
+	p := wsPrincipal(sid)
+	return p.auditName()
+}
+
+func (p *principal) auditName() string {
+	if len(p.Name) == 0 && p.Role == RoleAdmin {
+		return "owner"
+	}
+	return p.Name
+}
+
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// newAuditEntry starts the entry of the action of the request
+func (m *Monitor) newAuditEntry(r *http.Request, action string) AuditEntry {
+	e := AuditEntry{
+		Time:   time.Now().Unix(),
+		User:   m.auditUser(r),
+		Remote: remoteHost(r),
+		Action: action,
+		Node:   r.FormValue("key"),
+	}
+	if addr := r.FormValue("addr"); len(addr) > 0 {
+		if len(e.Node) == 0 {
+			e.Node = m.nodeKeyByAddr(addr)
+		}
+		if u, err := url.Parse(addr); err == nil {
+			e.Target = u.Path
+		}
+	}
+	return e
+}
+
+// statusWriter remembers the status a handler answered with
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// audited records every call of the handler of a state-changing action in the audit log,
+// including the refused ones
+func (m *Monitor) audited(a auditor, fn func(w http.ResponseWriter, r *http.Request) (result []byte, err error, code int)) func(w http.ResponseWriter, r *http.Request) (result []byte, err error, code int) {
+	return func(w http.ResponseWriter, r *http.Request) (result []byte, err error, code int) {
+		r.ParseForm()
+		if a.changes != nil && !a.changes(r) {
+			return fn(w, r)
+		}
+		e := m.newAuditEntry(r, a.action)
+		if a.old != nil {
+			e.Old = redactJSON(a.old(m, r))
+		}
+		sw := &statusWriter{ResponseWriter: w}
+		result, err, code = fn(sw, r)
+		switch {
+		case err != nil:
+			e.Status = code
+			if e.Status == 0 {
+				e.Status = SERVER_ERROR
+			}
+			e.Error = err.Error()
+		case sw.status != 0:
+			e.Status = sw.status
+		default:
+			e.Status = http.StatusOK
+		}
+		if a.result && err == nil && sw.status == 0 {
+			e.New = redactJSON(string(result))
+		} else {
+			e.New = auditValues(r)
+		}
+		audit(e)
+		return
+	}
+}
+
+func oldNodeConfig(m *Monitor, r *http.Request) string {
+	m.configsMutex.RLock()
+	c := m.configs[r.FormValue("key")]
+	m.configsMutex.RUnlock()
+	if c == nil {
+		return ""
+	}
+	d, _ := json.Marshal(c)
+	return string(d)
+}
+
+func oldClientConnections(m *Monitor, r *http.Request) string {
+	cfs, err := readConfig()
+	if err != nil || cfs == nil {
+		return ""
+	}
+	d, _ := json.Marshal(cfs[r.FormValue("client")])
+	return string(d)
+}
+
+func oldGroup(m *Monitor, r *http.Request) string {
+	u, err := readUserConfig(userPath)
+	if err != nil {
+		return ""
+	}
+	return strings.Join(u.Groups[r.FormValue("name")], ",")
+}
+
+func oldAlertConfig(m *Monitor, r *http.Request) string {
+	d, _ := json.Marshal(m.alerts.getConfig())
+	return string(d)
+}
+
+func oldToken(m *Monitor, r *http.Request) string {
+	for _, t := range m.tokens.list() {
+		if t.ID == r.FormValue("id") {
+			t.Hash = ""
+			d, _ := json.Marshal(t)
+			return string(d)
+		}
+	}
+	return ""
+}
+
+// the requests proxied to a node that only read from it are not recorded
+func nodeRequestChanges(r *http.Request) bool {
+	return nodeRequestRole(r.FormValue("addr")) != RoleViewer
+}
+
+// audit query of the request, the entries are filtered by the nodes the caller can access
+func (m *Monitor) auditQuery(w http.ResponseWriter, r *http.Request) (entries []AuditEntry, ok bool, err error, code int) {
+	p, ok := m.requestPrincipal(w, r)
+	if !ok {
+		return
+	}
+	key := r.FormValue("key")
+	if !p.Role.allows(RoleAdmin) || (len(key) > 0 && !p.canAccess(key)) {
+		httputil.Fail(w, "Forbidden", http.StatusForbidden)
+		return nil, false, nil, 0
+	}
+	var from, to int64
+	if v := r.FormValue("from"); len(v) > 0 {
+		from, err = strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			code = BAD_REQUEST
+			return
+		}
+	}
+	if v := r.FormValue("to"); len(v) > 0 {
+		to, err = strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			code = BAD_REQUEST
+			return
+		}
+	}
+	entries, err = readAudit(key, from, to, p.canAccess)
+	if err != nil {
+		code = SERVER_ERROR
+	}
+	return
+}
+
+func (m *Monitor) getAudit(w http.ResponseWriter, r *http.Request) (result []byte, err error, code int) {
+	entries, ok, err, code := m.auditQuery(w, r)
+	if !ok || err != nil {
+		return
+	}
+	limit := auditDefaultLimit
+	if v := r.FormValue("limit"); len(v) > 0 {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 {
+			code = BAD_REQUEST
+			err = errors.New("invalid limit")
+			return
+		}
+	}
+	if len(entries) > limit {
+		entries = entries[len(entries)-limit:]
+	}
+	result, err = json.Marshal(entries)
+	return
+}
+
+// exportAudit writes all the entries of the query as json lines, or csv with format=csv
+func (m *Monitor) exportAudit(w http.ResponseWriter, r *http.Request) {
+	entries, ok, err, code := m.auditQuery(w, r)
+	if !ok {
+		return
+	}
+	if err != nil {
+		httputil.Fail(w, err.Error(), code)
+		return
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Time < entries[j].Time })
+	var buf bytes.Buffer
+	name := "audit-" + time.Now().UTC().Format("20060102T150405Z")
+	if r.FormValue("format") == "csv" {
+		cw := csv.NewWriter(&buf)
+		cw.Write([]string{"time", "user", "remote", "action", "node", "target", "old", "new", "status", "error"})
+		for _, e := range entries {
+			cw.Write([]string{
+				strconv.FormatInt(e.Time, 10), e.User, e.Remote, e.Action, e.Node, e.Target,
+				e.Old, e.New, strconv.Itoa(e.Status), e.Error,
+			})
+		}
+		cw.Flush()
+		w.Header().Set("Content-Type", "text/csv")
+		name += ".csv"
+	} else {
+		enc := json.NewEncoder(&buf)
+		for _, e := range entries {
+			enc.Encode(e)
+		}
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		name += ".jsonl"
+	}
+	w.Header().Set("Content-Disposition", "attachment; filename="+name)
+	w.Write(buf.Bytes())
+}
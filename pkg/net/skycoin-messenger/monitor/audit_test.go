@@ -0,0 +1,302 @@
+package monitor
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+func auditEntries(t *testing.T) []AuditEntry {
+	entries, err := readAudit("", 0, 0, func(string) bool { return true })
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+func TestAuditEveryMutation(t *testing.T) {
+	tm := newTestMonitor(t, &User{Accounts: append([]Account(nil), testAccounts...)})
+	defer tm.close()
+	owner := tm.login(t, "", testPass)
+	viewer := tm.login(t, "viewer", "viewer-pass")
+	token := tm.bearer(t, owner.createToken(RoleAdmin, ""))
+
+	for _, route := range roleRoutes() {
+		for _, u := range []struct {
+			name string
+			c    *client
+			// the user recorded
+			user string
+			// the route refuses the user
+			refused bool
+		}{
+			{"owner", owner, "owner", false},
+			{"viewer", viewer, "viewer", len(route.role) == 0 || !RoleViewer.allows(route.role)},
+			{"token", token, "token:", len(route.role) == 0},
+		} {
+			if u.c == token && route.session {
+				continue
+			}
+			before := len(auditEntries(t))
+			status, body := u.c.send(http.MethodPost, route.path, route.form)
+			entries := auditEntries(t)
+			if len(route.action) == 0 {
+				if len(entries) != before {
+					t.Errorf("%s %s %v recorded %+v", u.name, route.path, route.form, entries[before:])
+				}
+				continue
+			}
+			if len(entries) != before+1 {
+				t.Errorf("%s %s %v: %d entries recorded", u.name, route.path, route.form, len(entries)-before)
+				continue
+			}
+			e := entries[before]
+			if e.Action != route.action || e.User != u.user || e.Status != status || e.Remote != "127.0.0.1" {
+				t.Errorf("%s %s: got %+v, answered %d %s", u.name, route.path, e, status, body)
+			}
+			if u.refused && e.Status != http.StatusForbidden {
+				t.Errorf("%s %s: refusal recorded as %+v", u.name, route.path, e)
+			}
+			// the secrets of the forms are left out
+			for k := range auditSecretValues {
+				if v := route.form.Get(k); len(v) > 0 && strings.Contains(e.New, v) {
+					t.Errorf("%s %s: %s recorded in %s", u.name, route.path, k, e.New)
+				}
+			}
+		}
+	}
+
+	// the requests that only read are not recorded
+	before := len(auditEntries(t))
+	for _, path := range []string{"/conn/getAll", "/auth/listTokens", "/group/getAll", "/audit/get"} {
+		if status, body := owner.send(http.MethodGet, path, nil); status != http.StatusOK {
+			t.Errorf("%s: %d %s", path, status, body)
+		}
+	}
+	if entries := auditEntries(t); len(entries) != before {
+		t.Fatalf("reads recorded %+v", entries[before:])
+	}
+}
+
+func TestAuditOldAndNew(t *testing.T) {
+	tm := newTestMonitor(t, nil)
+	defer tm.close()
+	n := tm.connectNode(t)
+	c := tm.login(t, "", testPass)
+	set := func(data string) AuditEntry {
+		before := len(auditEntries(t))
+		status, body := c.send(http.MethodPost, "/conn/setNodeConfig", url.Values{"key": {n.key}, "data": {data}})
+		if status != http.StatusOK {
+			t.Fatalf("config: %d %s", status, body)
+		}
+		entries := auditEntries(t)
+		if len(entries) != before+1 {
+			t.Fatalf("%d entries recorded", len(entries)-before)
+		}
+		return entries[before]
+	}
+	e := set(`{"DiscoveryAddresses":["127.0.0.1:5998"]}`)
+	if e.Node != n.key || len(e.Old) != 0 || !strings.Contains(e.New, "5998") {
+		t.Fatalf("first config %+v", e)
+	}
+	e = set(`{"DiscoveryAddresses":["127.0.0.1:5999"]}`)
+	if !strings.Contains(e.Old, "5998") || !strings.Contains(e.New, "5999") {
+		t.Fatalf("second config %+v", e)
+	}
+
+	// the result of an apply is recorded, the changes it made
+	before := len(auditEntries(t))
+	applyState(t, c, n.key, &NodeState{Apps: map[string]bool{"sockss": true}}, false)
+	entries := auditEntries(t)
+	if len(entries) != before+1 {
+		t.Fatalf("%d entries recorded", len(entries)-before)
+	}
+	var res ProvisionResult
+	if err := json.Unmarshal([]byte(entries[before].New), &res); err != nil || changedFields(&res) != "apps.sockss,auto_start,discovery_addresses" {
+		t.Fatalf("apply recorded %+v: %v", entries[before], err)
+	}
+
+	// a node proxied request is recorded with the node and the path
+	before = len(auditEntries(t))
+	if status, body := c.send(http.MethodPost, "/req", url.Values{"addr": {n.srv.URL + "/node/reboot"}}); status != http.StatusOK {
+		t.Fatalf("reboot: %d %s", status, body)
+	}
+	entries = auditEntries(t)
+	if len(entries) != before+1 || entries[before].Node != n.key || entries[before].Target != "/node/reboot" {
+		t.Fatalf("reboot recorded %+v", entries[before:])
+	}
+	if strings.Contains(entries[before].New, tm.token) {
+		t.Fatalf("the token of the manager recorded %s", entries[before].New)
+	}
+
+	status, body := c.send(http.MethodGet, "/audit/get", url.Values{"key": {n.key}})
+	if status != http.StatusOK {
+		t.Fatalf("audit: %d %s", status, body)
+	}
+	var got []AuditEntry
+	if err := json.Unmarshal([]byte(body), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 4 {
+		t.Fatalf("entries of the node %+v", got)
+	}
+}
+
+func TestAuditAppendOnly(t *testing.T) {
+	tm := newTestMonitor(t, nil)
+	defer tm.close()
+	owner := tm.login(t, "", testPass)
+	audit(AuditEntry{Time: 1, User: "owner", Action: "conn/ban", Node: cipher.PubKey{0x02, 1}.Hex()})
+	read := func() []byte {
+		d, err := ioutil.ReadFile(auditPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	if fi, err := os.Stat(auditPath); err != nil || fi.Mode().Perm() != 0600 {
+		t.Fatalf("audit log %v: %v", fi.Mode(), err)
+	}
+
+	// whatever the manager is asked, the entries already in the log stay as they are
+	type request struct {
+		method, path string
+		form         url.Values
+	}
+	requests := []request{
+		{http.MethodGet, "/audit/get", nil},
+		{http.MethodGet, "/audit/export", nil},
+		{http.MethodGet, "/audit/export", url.Values{"format": {"csv"}}},
+	}
+	for _, route := range roleRoutes() {
+		requests = append(requests, request{http.MethodPost, route.path, route.form})
+	}
+	for _, r := range requests {
+		before := read()
+		owner.send(r.method, r.path, r.form)
+		after := read()
+		if !bytes.HasPrefix(after, before) {
+			t.Fatalf("%s %s rewrote the audit log:\n%s\nwas\n%s", r.method, r.path, after, before)
+		}
+		if n := bytes.Count(after[len(before):], []byte("\n")); n > 1 {
+			t.Errorf("%s %s appended %d entries", r.method, r.path, n)
+		}
+	}
+	if e := auditEntries(t)[0]; e.Time != 1 || e.Action != "conn/ban" {
+		t.Fatalf("first entry %+v", e)
+	}
+}
+
+func TestAuditExport(t *testing.T) {
+	in, out := cipher.PubKey{0x02, 1}.Hex(), cipher.PubKey{0x02, 2}.Hex()
+	tm := newTestMonitor(t, &User{
+		Groups: map[string][]string{"g": {in}},
+		Accounts: []Account{
+			{Name: "group-admin", Pass: "group-admin-pass", Role: RoleAdmin, Groups: []string{"g"}},
+			{Name: "operator", Pass: "operator-pass", Role: RoleOperator},
+		},
+	})
+	defer tm.close()
+	// recorded out of order, exported by time
+	logged := []AuditEntry{
+		{Time: 300, User: "owner", Remote: "127.0.0.1", Action: "conn/ban", Node: out, New: `{"duration":"1h"}`, Status: 200},
+		{Time: 100, User: "group-admin", Remote: "127.0.0.1", Action: "conn/setNodeConfig", Node: in, Old: "a,\"b\"", New: "c\nd", Status: 200},
+		{Time: 200, User: "owner", Remote: "127.0.0.1", Action: "auth/createToken", Status: 400, Error: "invalid role"},
+	}
+	for _, e := range logged {
+		audit(e)
+	}
+	owner := tm.login(t, "", testPass)
+	groupAdmin := tm.login(t, "group-admin", "group-admin-pass")
+	operator := tm.login(t, "operator", "operator-pass")
+	export := func(c *client, form url.Values) (int, http.Header, string) {
+		res, err := c.http.Get(c.url + "/audit/export?" + form.Encode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, _ := ioutil.ReadAll(res.Body)
+		return res.StatusCode, res.Header, string(body)
+	}
+	jsonLines := func(body string) (entries []AuditEntry) {
+		entries = []AuditEntry{}
+		dec := json.NewDecoder(strings.NewReader(body))
+		for dec.More() {
+			var e AuditEntry
+			if err := dec.Decode(&e); err != nil {
+				t.Fatalf("%v: %s", err, body)
+			}
+			entries = append(entries, e)
+		}
+		return
+	}
+
+	for _, c := range []struct {
+		name string
+		c    *client
+		form url.Values
+		want []AuditEntry
+	}{
+		{"all", owner, nil, []AuditEntry{logged[1], logged[2], logged[0]}},
+		{"of a node", owner, url.Values{"key": {out}}, []AuditEntry{logged[0]}},
+		{"from", owner, url.Values{"from": {"200"}}, []AuditEntry{logged[2], logged[0]}},
+		{"to", owner, url.Values{"to": {"200"}}, []AuditEntry{logged[1], logged[2]}},
+		{"from to", owner, url.Values{"from": {"150"}, "to": {"250"}}, []AuditEntry{logged[2]}},
+		{"none", owner, url.Values{"from": {"400"}}, []AuditEntry{}},
+		{"of the group", groupAdmin, nil, []AuditEntry{logged[1], logged[2]}},
+	} {
+		status, header, body := export(c.c, c.form)
+		if status != http.StatusOK || header.Get("Content-Type") != "application/x-ndjson" ||
+			!strings.HasPrefix(header.Get("Content-Disposition"), "attachment; filename=audit-") ||
+			!strings.HasSuffix(header.Get("Content-Disposition"), ".jsonl") {
+			t.Fatalf("%s: %d %v %s", c.name, status, header, body)
+		}
+		if got := jsonLines(body); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: exported %+v, want %+v", c.name, got, c.want)
+		}
+	}
+
+	// as csv, a row an entry after the header
+	status, header, body := export(owner, url.Values{"format": {"csv"}})
+	if status != http.StatusOK || header.Get("Content-Type") != "text/csv" || !strings.HasSuffix(header.Get("Content-Disposition"), ".csv") {
+		t.Fatalf("csv: %d %v %s", status, header, body)
+	}
+	rows, err := csv.NewReader(strings.NewReader(body)).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{{"time", "user", "remote", "action", "node", "target", "old", "new", "status", "error"}}
+	for _, e := range []AuditEntry{logged[1], logged[2], logged[0]} {
+		want = append(want, []string{strconv.FormatInt(e.Time, 10), e.User, e.Remote, e.Action, e.Node, e.Target, e.Old, e.New, strconv.Itoa(e.Status), e.Error})
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Fatalf("csv %q, want %q", rows, want)
+	}
+
+	// only the admins export, of the nodes they can access
+	for _, c := range []struct {
+		name   string
+		c      *client
+		form   url.Values
+		status int
+	}{
+		{"operator", operator, nil, http.StatusForbidden},
+		{"group admin of another node", groupAdmin, url.Values{"key": {out}}, http.StatusForbidden},
+		{"bad from", owner, url.Values{"from": {"x"}}, http.StatusBadRequest},
+		{"bad to", owner, url.Values{"to": {"x"}}, http.StatusBadRequest},
+	} {
+		if status, _, body := export(c.c, c.form); status != c.status {
+			t.Errorf("%s: %d %s, want %d", c.name, status, body, c.status)
+		}
+	}
+}
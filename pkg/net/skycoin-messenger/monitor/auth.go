@@ -0,0 +1,541 @@
+package monitor
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/astaxie/beego/session"
+	"github.com/skycoin/skycoin/src/cipher"
+	"github.com/skycoin/skycoin/src/util/file"
+	"github.com/skycoin/skywire/pkg/httputil"
+	"github.com/skycoin/skywire/pkg/net/util"
+)
+
+const (
+	// lifetime of a browser session after login
+	sessionLifetime = 12 * time.Hour
+	// lifetime of a public key login challenge
+	challengeLifetime = 60 * time.Second
+	// challenges pending at most, the ones closest to expiring are dropped above it
+	maxChallenges = 1024
+
+	sessionCookieName = "SWSId"
+	csrfCookieName    = "XSRF-TOKEN"
+	csrfHeaderName    = "X-XSRF-TOKEN"
+)
+
+var tokenPath = filepath.Join(file.UserHome(), ".skywire", "manager", "tokens.json")
+
+// APIToken is used by headless automation, only the hash of the token is stored
+type APIToken struct {
+	ID    string `json:"id"`
+	Label string `json:"label"`
+	Hash  string `json:"hash"`
+	Role  Role   `json:"role"`
+	// node groups the token has access to, empty for all nodes
+	Groups  []string `json:"groups,omitempty"`
+	Created int64    `json:"created"`
+	// unix time, 0 for tokens that never expire
+	Expires int64 `json:"expires"`
+}
+
+func (t *APIToken) expired() bool {
+	return t.Expires != 0 && time.Now().Unix() > t.Expires
+}
+
+type tokenStore struct {
+	path   string
+	tokens []*APIToken
+	sync.RWMutex
+}
+
+func newTokenStore(path string) *tokenStore {
+	s := &tokenStore{path: path}
+	fb, err := ioutil.ReadFile(path)
+	if err == nil {
+		json.Unmarshal(fb, &s.tokens)
+	}
+	return s
+}
+
+func (s *tokenStore) save() (err error) {
+	d, err := json.Marshal(s.tokens)
+	if err != nil {
+		return
+	}
+	err = os.MkdirAll(filepath.Dir(s.path), 0700)
+	if err != nil {
+		return
+	}
+	err = ioutil.WriteFile(s.path, d, 0600)
+	return
+}
+
+func hashToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+// create a new token, the plain token is only returned once
+func (s *tokenStore) create(label string, role Role, groups []string, ttl time.Duration) (token string, t *APIToken, err error) {
+	token, err = getSecureRandomString(32)
+	if err != nil {
+		return
+	}
+	hash := hashToken(token)
+	now := time.Now()
+	t = &APIToken{
+		ID:      hash[:16],
+		Label:   label,
+		Hash:    hash,
+		Role:    role,
+		Groups:  groups,
+		Created: now.Unix(),
+	}
+	if ttl > 0 {
+		t.Expires = now.Add(ttl).Unix()
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.tokens = append(s.tokens, t)
+	err = s.save()
+	return
+}
+
+func (s *tokenStore) revoke(id string) (err error) {
+	s.Lock()
+	defer s.Unlock()
+	for i, t := range s.tokens {
+		if t.ID == id {
+			s.tokens = append(s.tokens[:i], s.tokens[i+1:]...)
+			return s.save()
+		}
+	}
+	return errors.New("token not found")
+}
+
+func (s *tokenStore) list() (result []APIToken) {
+	s.RLock()
+	defer s.RUnlock()
+	result = make([]APIToken, 0, len(s.tokens))
+	for _, t := range s.tokens {
+		c := *t
+		c.Hash = ""
+		result = append(result, c)
+	}
+	return
+}
+
+func (s *tokenStore) lookup(token string) (t *APIToken, ok bool) {
+	hash := hashToken(token)
+	s.RLock()
+	defer s.RUnlock()
+	for _, v := range s.tokens {
+		if util.ConstantTimeEqualString(v.Hash, hash) {
+			if v.expired() {
+				return
+			}
+			return v, true
+		}
+	}
+	return
+}
+
+// public key login challenges
+type challenges struct {
+	nonces map[string]time.Time
+	sync.Mutex
+}
+
+func (c *challenges) create() (nonce string, err error) {
+	nonce, err = getSecureRandomString(32)
+	if err != nil {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	if c.nonces == nil {
+		c.nonces = make(map[string]time.Time)
+	}
+	now := time.Now()
+	c.expire(now)
+	for len(c.nonces) >= maxChallenges {
+		c.dropOldest()
+	}
+	c.nonces[nonce] = now.Add(challengeLifetime)
+	return
+}
+
+// every challenge can be used once
+func (c *challenges) consume(nonce string) bool {
+	c.Lock()
+	defer c.Unlock()
+	now := time.Now()
+	c.expire(now)
+	expire, ok := c.nonces[nonce]
+	if !ok {
+		return false
+	}
+	delete(c.nonces, nonce)
+	return now.Before(expire)
+}
+
+// expire drops the challenges past their lifetime, with the mutex locked
+func (c *challenges) expire(now time.Time) {
+	for k, v := range c.nonces {
+		if now.After(v) {
+			delete(c.nonces, k)
+		}
+	}
+}
+
+// dropOldest drops the challenge closest to expiring, with the mutex locked
+func (c *challenges) dropOldest() {
+	var oldest string
+	var at time.Time
+	for k, v := range c.nonces {
+		if len(oldest) < 1 || v.Before(at) {
+			oldest, at = k, v
+		}
+	}
+	delete(c.nonces, oldest)
+}
+
+func bearerToken(r *http.Request) (token string, ok bool) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return
+	}
+	token = strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+	ok = len(token) > 0
+	return
+}
+
+// hasCredentials checks if the request carries a bearer token or the cookie of a session
+func hasCredentials(r *http.Request) bool {
+	if _, ok := bearerToken(r); ok {
+		return true
+	}
+	_, err := r.Cookie(sessionCookieName)
+	return err == nil
+}
+
+// requestPrincipal returns the identity of the bearer token or the login session of the request,
+// state changing requests of browser sessions must pass the CSRF check
+func (m *Monitor) requestPrincipal(w http.ResponseWriter, r *http.Request) (p *principal, ok bool) {
+	if token, has := bearerToken(r); has {
+		t, found := m.tokens.lookup(token)
+		if !found {
+			httputil.Fail(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		return &principal{Name: t.Label, Role: t.Role, Groups: t.Groups}, true
+	}
+	if !verifyLogin(w, r, false) {
+		return
+	}
+	if !safeMethod(r.Method) && !verifyCSRF(w, r) {
+		httputil.Fail(w, "Invalid CSRF token", http.StatusForbidden)
+		return
+	}
+	sess, _ := globalSessions.SessionStart(w, r)
+	defer sess.SessionRelease(w)
+	if enrollOnly(sess) {
+		httputil.Fail(w, "Two-factor authentication must be set up first", http.StatusForbidden)
+		return
+	}
+	p, ok = sess.Get("principal").(*principal)
+	if !ok {
+		httputil.Fail(w, "Unauthorized", http.StatusFound)
+	}
+	return
+}
+
+// authorize checks that the request has at least the role (role)
+// and, for node specific requests, access to the node (hex public key)
+func (m *Monitor) authorize(w http.ResponseWriter, r *http.Request, role Role, node string) bool {
+	p, ok := m.requestPrincipal(w, r)
+	if !ok {
+		return false
+	}
+	if !p.Role.allows(role) {
+		httputil.Fail(w, "Forbidden", http.StatusForbidden)
+		return false
+	}
+	if len(node) > 0 && !p.canAccess(node) {
+		httputil.Fail(w, "Forbidden", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// regenerateSession moves the session of the request to a new id, so an id set in the browser
+// before, e.g. by another site, is of no use after a login
+func regenerateSession(w http.ResponseWriter, r *http.Request) (sess session.Store, err error) {
+	sess = globalSessions.SessionRegenerateID(w, r)
+	if sess == nil {
+		err = errors.New("failed to start the session")
+		return
+	}
+	// the new cookie is added after the old one, which the later reads of the request would find first
+	cookies := r.Cookies()
+	r.Header.Del("Cookie")
+	for _, c := range cookies {
+		if c.Name != sessionCookieName {
+			r.AddCookie(c)
+		}
+	}
+	r.AddCookie(&http.Cookie{Name: sessionCookieName, Value: url.QueryEscape(sess.SessionID())})
+	return
+}
+
+// start the session of a successful login
+func startSession(w http.ResponseWriter, r *http.Request, p *principal) (err error) {
+	sess, err := regenerateSession(w, r)
+	if err != nil {
+		return
+	}
+	defer sess.SessionRelease(w)
+	// left from an earlier login of the browser
+	sess.Delete("enroll2fa")
+	sess.Delete("totp_pending")
+	err = sess.Set("user", sess.SessionID())
+	if err != nil {
+		return
+	}
+	err = sess.Set("pass", getBcrypt(sess.SessionID()))
+	if err != nil {
+		return
+	}
+	err = sess.Set("principal", p)
+	if err != nil {
+		return
+	}
+	err = sess.Set("expire", time.Now().Add(sessionLifetime).Unix())
+	if err != nil {
+		return
+	}
+	csrf, err := getSecureRandomString(32)
+	if err != nil {
+		return
+	}
+	err = sess.Set("csrf", csrf)
+	if err != nil {
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:   csrfCookieName,
+		Value:  csrf,
+		Path:   "/",
+		MaxAge: int(sessionLifetime / time.Second),
+		// behind a proxy terminating tls the scheme is the one of the client
+		Secure: r.URL.Scheme == "https",
+	})
+	return
+}
+
+// wsPrincipal returns the identity of the session (token) of a websocket request
+func wsPrincipal(token string) *principal {
+	sess, err := globalSessions.GetSessionStore(token)
+	if err != nil || enrollOnly(sess) {
+		return &principal{}
+	}
+	p, ok := sess.Get("principal").(*principal)
+	if !ok {
+		return &principal{}
+	}
+	return p
+}
+
+// node terminals are a shell on the node and need the admin role
+func (p *principal) canTerm(node string) bool {
+	return p.Role.allows(RoleAdmin) && p.canAccess(node)
+}
+
+// only the owner may change the password of the user config
+func isOwnerSession(w http.ResponseWriter, r *http.Request) bool {
+	sess, _ := globalSessions.SessionStart(w, r)
+	defer sess.SessionRelease(w)
+	p, ok := sess.Get("principal").(*principal)
+	return ok && p == owner
+}
+
+// safeMethod returns true for the methods that change nothing, the requests of the other
+// methods of browser sessions must pass the CSRF check
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+func verifyCSRF(w http.ResponseWriter, r *http.Request) bool {
+	sess, _ := globalSessions.SessionStart(w, r)
+	defer sess.SessionRelease(w)
+	csrf, ok := sess.Get("csrf").(string)
+	if !ok || len(csrf) == 0 {
+		return false
+	}
+	return util.ConstantTimeEqualString(r.Header.Get(csrfHeaderName), csrf)
+}
+
+func sessionExpired(expire interface{}) bool {
+	e, ok := expire.(int64)
+	if !ok {
+		return true
+	}
+	return time.Now().Unix() > e
+}
+
+func (m *Monitor) getChallenge(w http.ResponseWriter, r *http.Request) (result []byte, err error, code int) {
+	nonce, err := m.challenges.create()
+	if err != nil {
+		return
+	}
+	result = []byte(nonce)
+	return
+}
+
+// login with a public key listed in the user config by signing sha256(nonce), with the form
+// value otp for the users with two-factor authentication
+func (m *Monitor) keyLogin(w http.ResponseWriter, r *http.Request) (result []byte, err error, code int) {
+	if r.Method != "POST" {
+		code = BAD_REQUEST
+		err = errors.New("please use post method")
+		return
+	}
+	key, err := pubKeyFromHex(r.FormValue("key"))
+	if err != nil {
+		code = BAD_REQUEST
+		return
+	}
+	sig, err := cipher.SigFromHex(r.FormValue("sig"))
+	if err != nil {
+		code = BAD_REQUEST
+		return
+	}
+	nonce := r.FormValue("nonce")
+	if !m.challenges.consume(nonce) {
+		result = []byte("false")
+		return
+	}
+	p, ok := principalByKey(key)
+	if !ok {
+		result = []byte("false")
+		return
+	}
+	err = cipher.VerifySignature(key, sig, cipher.SumSHA256([]byte(nonce)))
+	if err != nil {
+		err = nil
+		result = []byte("false")
+		return
+	}
+	// the key replaces the password, not the second factor
+	result, err = m.loginSecondFactor(w, r, p)
+	return
+}
+
+func (m *Monitor) createToken(w http.ResponseWriter, r *http.Request) (result []byte, err error, code int) {
+	p, ok := m.requestPrincipal(w, r)
+	if !ok {
+		return
+	}
+	if !p.Role.allows(RoleAdmin) {
+		httputil.Fail(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	role := Role(r.FormValue("role"))
+	if !role.valid() {
+		code = BAD_REQUEST
+		err = errors.New("role must be viewer, operator or admin")
+		return
+	}
+	var groups []string
+	if v := r.FormValue("groups"); len(v) > 0 {
+		groups = strings.Split(v, ",")
+	}
+	// a token can not reach more nodes than its creator
+	if len(p.Groups) > 0 {
+		if len(groups) == 0 {
+			groups = p.Groups
+		}
+		for _, g := range groups {
+			if !containsString(p.Groups, g) {
+				code = BAD_REQUEST
+				err = errors.New("no access to group " + g)
+				return
+			}
+		}
+	}
+	var ttl time.Duration
+	if v := r.FormValue("ttl"); len(v) > 0 {
+		var sec int
+		sec, err = strconv.Atoi(v)
+		if err != nil || sec < 0 {
+			code = BAD_REQUEST
+			err = errors.New("ttl must be a positive number of seconds")
+			return
+		}
+		ttl = time.Duration(sec) * time.Second
+	}
+	token, t, err := m.tokens.create(r.FormValue("label"), role, groups, ttl)
+	if err != nil {
+		return
+	}
+	created := *t
+	created.Hash = ""
+	result, err = json.Marshal(struct {
+		Token string `json:"token"`
+		APIToken
+	}{Token: token, APIToken: created})
+	return
+}
+
+func (m *Monitor) listTokens(w http.ResponseWriter, r *http.Request) (result []byte, err error, code int) {
+	if !m.authorize(w, r, RoleAdmin, "") {
+		return
+	}
+	result, err = json.Marshal(m.tokens.list())
+	return
+}
+
+func (m *Monitor) revokeToken(w http.ResponseWriter, r *http.Request) (result []byte, err error, code int) {
+	if !m.authorize(w, r, RoleAdmin, "") {
+		return
+	}
+	err = m.tokens.revoke(r.FormValue("id"))
+	if err != nil {
+		code = NOT_FOUND
+		return
+	}
+	result = []byte("true")
+	return
+}
+
+// getSecureRandomString returns a hex string of n random bytes from crypto/rand
+func getSecureRandomString(n int) (string, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func containsString(s []string, v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
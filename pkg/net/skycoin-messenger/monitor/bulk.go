@@ -0,0 +1,422 @@
+package monitor
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/skycoin/skywire/pkg/httputil"
+	"github.com/skycoin/skywire/pkg/node"
+)
+
+const (
+	// nodes of a bulk job running at the same time
+	bulkParallel = 8
+	// finished bulk jobs are kept for polling this long
+	bulkJobLifetime = time.Hour
+	bulkNodeTimeout = 30 * time.Second
+)
+
+const (
+	bulkPending = "pending"
+	bulkRunning = "running"
+	bulkOk      = "ok"
+	bulkFailed  = "failed"
+)
+
+// bulk actions and the role they require
+var bulkActions = map[string]Role{
+	// data: json of the app auto start config
+	"setAutoStart": RoleOperator,
+	// data: sshs or sockss
+	"restartApp": RoleOperator,
+	// data: json of Config with the discovery addresses
+	"setDiscovery": RoleAdmin,
+}
+
+type BulkNodeResult struct {
+	Status string `json:"status"`
+	Result string `json:"result,omitempty"`
+	Error  string `json:"error,omitempty"`
+	// the node may succeed when the action is run again
+	Retryable bool `json:"retryable,omitempty"`
+}
+
+type BulkJob struct {
+	ID        string                     `json:"id"`
+	Group     string                     `json:"group"`
+	Action    string                     `json:"action"`
+	Started   int64                      `json:"started"`
+	Finished  int64                      `json:"finished"`
+	Succeeded int                        `json:"succeeded"`
+	Failed    int                        `json:"failed"`
+	Nodes     map[string]*BulkNodeResult `json:"nodes"`
+
+	sync.RWMutex
+}
+
+func (j *BulkJob) set(key string, res *BulkNodeResult) {
+	j.Lock()
+	j.Nodes[key] = res
+	switch res.Status {
+	case bulkOk:
+		j.Succeeded++
+	case bulkFailed:
+		j.Failed++
+	}
+	j.Unlock()
+}
+
+func (j *BulkJob) marshal() ([]byte, error) {
+	j.RLock()
+	defer j.RUnlock()
+	return json.Marshal(j)
+}
+
+type bulkJobs struct {
+	jobs map[string]*BulkJob
+	sync.Mutex
+}
+
+func (b *bulkJobs) add(j *BulkJob) {
+	b.Lock()
+	defer b.Unlock()
+	if b.jobs == nil {
+		b.jobs = make(map[string]*BulkJob)
+	}
+	now := time.Now().Unix()
+	for k, v := range b.jobs {
+		v.RLock()
+		expired := v.Finished > 0 && now-v.Finished > int64(bulkJobLifetime/time.Second)
+		v.RUnlock()
+		if expired {
+			delete(b.jobs, k)
+		}
+	}
+	b.jobs[j.ID] = j
+}
+
+func (b *bulkJobs) get(id string) (j *BulkJob, ok bool) {
+	b.Lock()
+	j, ok = b.jobs[id]
+	b.Unlock()
+	return
+}
+
+func (p *principal) canAccessGroup(group string) bool {
+	return len(p.Groups) == 0 || containsString(p.Groups, group)
+}
+
+func (m *Monitor) getGroups(w http.ResponseWriter, r *http.Request) (result []byte, err error, code int) {
+	p, ok := m.requestPrincipal(w, r)
+	if !ok {
+		return
+	}
+	u, err := readUserConfig(userPath)
+	if err != nil {
+		return
+	}
+	groups := make(map[string][]string)
+	for g, keys := range u.Groups {
+		if p.canAccessGroup(g) {
+			groups[g] = keys
+		}
+	}
+	result, err = json.Marshal(groups)
+	return
+}
+
+// setGroup sets the node keys of a group, an empty list of keys removes the group.
+// The admin of some groups only sets them, with the nodes it has access to
+func (m *Monitor) setGroup(w http.ResponseWriter, r *http.Request) (result []byte, err error, code int) {
+	p, ok := m.requestPrincipal(w, r)
+	if !ok {
+		return
+	}
+	if !p.Role.allows(RoleAdmin) {
+		httputil.Fail(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if r.Method != "POST" {
+		code = BAD_REQUEST
+		err = errors.New("please use post method")
+		return
+	}
+	name := r.FormValue("name")
+	if len(name) == 0 {
+		code = BAD_REQUEST
+		err = errors.New("group name is empty")
+		return
+	}
+	if !p.canAccessGroup(name) {
+		httputil.Fail(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	var keys []string
+	for _, k := range strings.Split(r.FormValue("keys"), ",") {
+		k = strings.TrimSpace(k)
+		if len(k) == 0 {
+			continue
+		}
+		if _, err = pubKeyFromHex(k); err != nil {
+			code = BAD_REQUEST
+			return
+		}
+		if !p.canAccess(k) {
+			httputil.Fail(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		keys = append(keys, k)
+	}
+	u, err := readUserConfig(userPath)
+	if err != nil {
+		return
+	}
+	if u.Groups == nil {
+		u.Groups = make(map[string][]string)
+	}
+	if len(keys) == 0 {
+		delete(u.Groups, name)
+	} else {
+		u.Groups[name] = keys
+	}
+	err = WriteConfig(u, userPath)
+	if err != nil {
+		return
+	}
+	result = []byte("true")
+	return
+}
+
+// bulk runs an action on every node of a group and returns the job id,
+// the progress of the nodes can be polled with getBulkJob
+func (m *Monitor) bulk(w http.ResponseWriter, r *http.Request) (result []byte, err error, code int) {
+	action := r.FormValue("action")
+	role, ok := bulkActions[action]
+	if !ok {
+		code = BAD_REQUEST
+		err = fmt.Errorf("unknown action %s", action)
+		return
+	}
+	p, ok := m.requestPrincipal(w, r)
+	if !ok {
+		return
+	}
+	group := r.FormValue("group")
+	if !p.Role.allows(role) || !p.canAccessGroup(group) {
+		httputil.Fail(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if r.Method != "POST" {
+		code = BAD_REQUEST
+		err = errors.New("please use post method")
+		return
+	}
+	u, err := readUserConfig(userPath)
+	if err != nil {
+		return
+	}
+	keys, ok := u.Groups[group]
+	if !ok {
+		code = NOT_FOUND
+		err = errors.New("group not found")
+		return
+	}
+	data := r.FormValue("data")
+	run, err := m.bulkAction(action, data)
+	if err != nil {
+		code = BAD_REQUEST
+		return
+	}
+	id, err := getSecureRandomString(8)
+	if err != nil {
+		return
+	}
+	job := &BulkJob{
+		ID:      id,
+		Group:   group,
+		Action:  action,
+		Started: time.Now().Unix(),
+		Nodes:   make(map[string]*BulkNodeResult),
+	}
+	for _, k := range keys {
+		job.Nodes[k] = &BulkNodeResult{Status: bulkPending}
+	}
+	m.bulkJobs.add(job)
+	go m.runBulkJob(job, keys, run)
+	result, err = json.Marshal(id)
+	return
+}
+
+func (m *Monitor) getBulkJob(w http.ResponseWriter, r *http.Request) (result []byte, err error, code int) {
+	p, ok := m.requestPrincipal(w, r)
+	if !ok {
+		return
+	}
+	job, ok := m.bulkJobs.get(r.FormValue("id"))
+	if !ok || !p.canAccessGroup(job.Group) {
+		code = NOT_FOUND
+		err = errors.New("job not found")
+		return
+	}
+	result, err = job.marshal()
+	return
+}
+
+// a failing node does not stop the job, its error is reported in the node result
+func (m *Monitor) runBulkJob(job *BulkJob, keys []string, run func(key string) (string, error)) {
+	sem := make(chan struct{}, bulkParallel)
+	var wg sync.WaitGroup
+	for _, k := range keys {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(key string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			job.set(key, &BulkNodeResult{Status: bulkRunning})
+			res, err := run(key)
+			if err != nil {
+				log.Debugf("bulk %s on %s: %v", job.Action, key, err)
+				job.set(key, &BulkNodeResult{Status: bulkFailed, Error: err.Error(), Retryable: httputil.IsRetryable(err)})
+				return
+			}
+			job.set(key, &BulkNodeResult{Status: bulkOk, Result: res})
+		}(k)
+	}
+	wg.Wait()
+	job.Lock()
+	job.Finished = time.Now().Unix()
+	job.Unlock()
+}
+
+func (m *Monitor) bulkAction(action, data string) (run func(key string) (string, error), err error) {
+	switch action {
+	case "setAutoStart":
+		if !json.Valid([]byte(data)) {
+			err = errors.New("data is not valid json")
+			return
+		}
+		run = func(key string) (string, error) {
+			return m.nodeRequest(key, "/node/run/setAutoStartConfig", url.Values{"key": {key}, "data": {data}})
+		}
+	case "restartApp":
+		if data != "sshs" && data != "sockss" {
+			err = errors.New("app must be sshs or sockss")
+			return
+		}
+		run = func(key string) (string, error) {
+			values := url.Values{}
+			if data == "sshs" {
+				// sshs is started again with the nodes it allows now, restarted without them it
+				// would allow any node
+				allow, err := m.nodeAllowList(key, data)
+				if err != nil {
+					return "", err
+				}
+				values.Set("data", strings.Join(allow, ","))
+			}
+			return m.nodeRequest(key, "/node/run/"+data, values)
+		}
+	case "setDiscovery":
+		var config *Config
+		err = json.Unmarshal([]byte(data), &config)
+		if err != nil {
+			return
+		}
+		if config == nil || len(config.DiscoveryAddresses) == 0 {
+			err = errors.New("no discovery addresses")
+			return
+		}
+		var d []byte
+		d, err = json.Marshal(node.Config{DiscoveryAddresses: config.DiscoveryAddresses})
+		if err != nil {
+			return
+		}
+		run = func(key string) (string, error) {
+			res, err := m.nodeRequest(key, "/node/run/setNodeConfig", url.Values{"key": {key}, "data": {string(d)}})
+			if err != nil {
+				return "", err
+			}
+			m.configsMutex.Lock()
+			m.configs[key] = config
+			m.configsMutex.Unlock()
+			if res != "true" {
+				return res, nil
+			}
+			return "saved, the node uses the addresses once it restarts", nil
+		}
+	}
+	return
+}
+
+// nodeAllowList returns the nodes the running server app (app) of the node allows
+func (m *Monitor) nodeAllowList(key, app string) (allow []string, err error) {
+	apps, err := m.nodeApps(key)
+	if err != nil {
+		return
+	}
+	for _, a := range apps {
+		if containsString(a.Attributes, app) {
+			allow = a.AllowNodes
+			return
+		}
+	}
+	err = fmt.Errorf("%s is not running, the nodes it allows are unknown", app)
+	return
+}
+
+// nodeAPIAddrByKey returns the node api address of a connected node
+func (m *Monitor) nodeAPIAddrByKey(key string) (addr string, err error) {
+	k, err := pubKeyFromHex(key)
+	if err != nil {
+		return
+	}
+	c, ok := m.factory.GetConnection(k)
+	if !ok {
+		err = errors.New("node is not connected")
+		return
+	}
+	addr, err = nodeAPIAddr(c)
+	if err != nil {
+		return
+	}
+	if len(addr) == 0 {
+		err = errors.New("node api address is unknown")
+	}
+	return
+}
+
+// nodeRequest posts the form (values) to the api of the connected node (key)
+func (m *Monitor) nodeRequest(key, path string, values url.Values) (result string, err error) {
+	addr, err := m.nodeAPIAddrByKey(key)
+	if err != nil {
+		return
+	}
+	values.Set("token", m.token)
+	client := &http.Client{Timeout: bulkNodeTimeout}
+	res, err := client.PostForm("http://"+addr+path, values)
+	if err != nil {
+		return
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return
+	}
+	if res.StatusCode != http.StatusOK {
+		// the nodes before the envelope answer text, see httputil.ReadError
+		err = httputil.ReadError(res, body)
+		return
+	}
+	result = string(body)
+	return
+}
@@ -0,0 +1,162 @@
+package monitor
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/skycoin/skycoin/src/cipher"
+	"github.com/skycoin/skywire/pkg/node"
+)
+
+// runBulk starts the bulk action on the group and returns the job once every node is done
+func runBulk(t *testing.T, c *client, group, action, data string) *BulkJob {
+	status, body := c.send(http.MethodPost, "/group/bulk", url.Values{"group": {group}, "action": {action}, "data": {data}})
+	if status != http.StatusOK {
+		t.Fatalf("bulk %s: %d %s", action, status, body)
+	}
+	var id string
+	if err := json.Unmarshal([]byte(body), &id); err != nil {
+		t.Fatal(err)
+	}
+	job := &BulkJob{}
+	waitFor(t, "the bulk job "+id, func() bool {
+		status, body := c.send(http.MethodGet, "/group/getBulkJob", url.Values{"id": {id}})
+		if status != http.StatusOK {
+			t.Fatalf("bulk job: %d %s", status, body)
+		}
+		job = &BulkJob{}
+		if err := json.Unmarshal([]byte(body), job); err != nil {
+			t.Fatal(err)
+		}
+		return job.Finished > 0
+	})
+	return job
+}
+
+func TestBulkPartialFailure(t *testing.T) {
+	tm := newTestMonitor(t, nil)
+	defer tm.close()
+	ok1, ok2, failing := tm.connectNode(t), tm.connectNode(t), tm.connectNode(t)
+	failing.failPath("/node/run/setAutoStartConfig", true)
+	offline := cipher.PubKey([33]byte{0x02, 0x03}).Hex()
+	c := tm.login(t, "", testPass)
+	keys := strings.Join([]string{ok1.key, ok2.key, failing.key, offline}, ",")
+	if status, body := c.send(http.MethodPost, "/group/set", url.Values{"name": {"g"}, "keys": {keys}}); status != http.StatusOK {
+		t.Fatalf("group: %d %s", status, body)
+	}
+
+	job := runBulk(t, c, "g", "setAutoStart", `{"sockss":true}`)
+	if job.Succeeded != 2 || job.Failed != 2 || len(job.Nodes) != 4 {
+		t.Fatalf("job %+v", job)
+	}
+	for _, n := range []*fakeNode{ok1, ok2} {
+		if res := job.Nodes[n.key]; res.Status != bulkOk {
+			t.Errorf("node %s: %+v", n.key, res)
+		}
+		if asc, _ := n.state(); !asc.Sockss {
+			t.Errorf("node %s not set", n.key)
+		}
+	}
+	// the node may take it when asked again
+	if res := job.Nodes[failing.key]; res.Status != bulkFailed || !strings.Contains(res.Error, "node failure") || !res.Retryable {
+		t.Errorf("failing node %+v", res)
+	}
+	if asc, _ := failing.state(); asc.Sockss {
+		t.Error("the failing node set")
+	}
+	if res := job.Nodes[offline]; res.Status != bulkFailed || !strings.Contains(res.Error, "not connected") || res.Retryable {
+		t.Errorf("offline node %+v", res)
+	}
+
+	// sshs is started again with the nodes it allows, a node not running it fails alone
+	allow := cipher.PubKey([33]byte{0x02, 0x04}).Hex()
+	asc, _ := ok1.state()
+	ok1.setState(asc, []node.NodeApp{{Attributes: []string{"sshs"}, AllowNodes: []string{allow}}})
+	job = runBulk(t, c, "g", "restartApp", "sshs")
+	if job.Succeeded != 1 || job.Failed != 3 {
+		t.Fatalf("job %+v", job)
+	}
+	if res := job.Nodes[ok2.key]; res.Status != bulkFailed || !strings.Contains(res.Error, "sshs is not running") {
+		t.Errorf("node without sshs %+v", res)
+	}
+	if _, apps := ok1.state(); len(apps) != 1 || strings.Join(apps[0].AllowNodes, ",") != allow {
+		t.Fatalf("sshs started again with %+v", apps)
+	}
+}
+
+func TestBulkJobAccess(t *testing.T) {
+	tm := newTestMonitor(t, &User{Accounts: []Account{
+		{Name: "operator", Pass: "operator-pass", Role: RoleOperator, Groups: []string{"other"}},
+	}})
+	defer tm.close()
+	n := tm.connectNode(t)
+	owner := tm.login(t, "", testPass)
+	if status, body := owner.send(http.MethodPost, "/group/set", url.Values{"name": {"g"}, "keys": {n.key}}); status != http.StatusOK {
+		t.Fatalf("group: %d %s", status, body)
+	}
+	job := runBulk(t, owner, "g", "restartApp", "sockss")
+	if job.Succeeded != 1 {
+		t.Fatalf("job %+v", job)
+	}
+	// the jobs of the groups the user has no access to are not found
+	operator := tm.login(t, "operator", "operator-pass")
+	if status, _ := operator.send(http.MethodGet, "/group/getBulkJob", url.Values{"id": {job.ID}}); status != http.StatusNotFound {
+		t.Fatalf("job of another group: got %d", status)
+	}
+	if status, body := owner.send(http.MethodPost, "/group/bulk", url.Values{"group": {"g"}, "action": {"reboot"}}); status != http.StatusBadRequest {
+		t.Fatalf("unknown action: %d %s", status, body)
+	}
+	if status, body := owner.send(http.MethodPost, "/group/bulk", url.Values{"group": {"g"}, "action": {"setAutoStart"}, "data": {"{"}}); status != http.StatusBadRequest {
+		t.Fatalf("invalid data: %d %s", status, body)
+	}
+}
+
+func TestSetGroupAccess(t *testing.T) {
+	in, out, key := cipher.PubKey{0x02, 1}.Hex(), cipher.PubKey{0x02, 2}.Hex(), cipher.PubKey{0x02, 3}.Hex()
+	tm := newTestMonitor(t, &User{
+		Groups: map[string][]string{"g": {in}, "other": {out}},
+		Accounts: []Account{
+			{Name: "admin", Pass: "admin-pass", Role: RoleAdmin},
+			{Name: "group-admin", Pass: "group-admin-pass", Role: RoleAdmin, Groups: []string{"g", "new"}},
+			{Name: "operator", Pass: "operator-pass", Role: RoleOperator},
+		},
+	})
+	defer tm.close()
+	admin := tm.login(t, "admin", "admin-pass")
+	groupAdmin := tm.login(t, "group-admin", "group-admin-pass")
+	operator := tm.login(t, "operator", "operator-pass")
+
+	for _, c := range []struct {
+		name   string
+		c      *client
+		group  string
+		keys   string
+		status int
+		// the keys of the group after the request
+		want string
+	}{
+		{name: "operator", c: operator, group: "g", keys: key, status: http.StatusForbidden, want: in},
+		{name: "admin of all groups", c: admin, group: "other", keys: out + "," + key, status: http.StatusOK, want: out + "," + key},
+		{name: "own group", c: groupAdmin, group: "g", keys: in, status: http.StatusOK, want: in},
+		{name: "node of another group", c: groupAdmin, group: "g", keys: in + "," + out, status: http.StatusForbidden, want: in},
+		{name: "node of no group", c: groupAdmin, group: "g", keys: key, status: http.StatusForbidden, want: in},
+		{name: "another group", c: groupAdmin, group: "other", keys: in, status: http.StatusForbidden, want: out + "," + key},
+		{name: "new group of the account", c: groupAdmin, group: "new", keys: in, status: http.StatusOK, want: in},
+		{name: "invalid key", c: groupAdmin, group: "g", keys: "02", status: http.StatusBadRequest, want: in},
+		{name: "own group removed", c: groupAdmin, group: "new", status: http.StatusOK},
+	} {
+		if status, body := c.c.send(http.MethodPost, "/group/set", url.Values{"name": {c.group}, "keys": {c.keys}}); status != c.status {
+			t.Errorf("%s: got %d %s, want %d", c.name, status, body, c.status)
+		}
+		u, err := readUserConfig(userPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if keys := strings.Join(u.Groups[c.group], ","); keys != c.want {
+			t.Errorf("%s: group %s is %s, want %s", c.name, c.group, keys, c.want)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package monitor
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// the longest ban of a client
+const maxBanDuration = 30 * 24 * time.Hour
+
+// getMetrics returns the clients of the discovery, their throughput and the errors it counted
+func (m *Monitor) getMetrics(w http.ResponseWriter, r *http.Request) (result []byte, err error, code int) {
+	if !m.authorize(w, r, RoleViewer, "") {
+		return
+	}
+	result, err = json.Marshal(m.factory.GetServerMetrics())
+	return
+}
+
+// disconnectClient closes the connection of a client, it may connect again right away
+func (m *Monitor) disconnectClient(w http.ResponseWriter, r *http.Request) (result []byte, err error, code int) {
+	if !m.authorize(w, r, RoleOperator, r.FormValue("key")) {
+		return
+	}
+	key, err := pubKeyFromHex(r.FormValue("key"))
+	if err != nil {
+		code = BAD_REQUEST
+		return
+	}
+	if !m.factory.Disconnect(key) {
+		code = NOT_FOUND
+		err = errors.New("client is not connected")
+		return
+	}
+	result = []byte("true")
+	return
+}
+
+// banClient disconnects a client and refuses its key for a while
+func (m *Monitor) banClient(w http.ResponseWriter, r *http.Request) (result []byte, err error, code int) {
+	if !m.authorize(w, r, RoleAdmin, "") {
+		return
+	}
+	key, err := pubKeyFromHex(r.FormValue("key"))
+	if err != nil {
+		code = BAD_REQUEST
+		return
+	}
+	d, err := time.ParseDuration(r.FormValue("duration"))
+	if err != nil {
+		code = BAD_REQUEST
+		return
+	}
+	if d <= 0 || d > maxBanDuration {
+		code = BAD_REQUEST
+		err = errors.Errorf("duration must be positive and at most %v", maxBanDuration)
+		return
+	}
+	m.factory.Ban(key, d)
+	result = []byte("true")
+	return
+}
+
+func (m *Monitor) unbanClient(w http.ResponseWriter, r *http.Request) (result []byte, err error, code int) {
+	if !m.authorize(w, r, RoleAdmin, "") {
+		return
+	}
+	key, err := pubKeyFromHex(r.FormValue("key"))
+	if err != nil {
+		code = BAD_REQUEST
+		return
+	}
+	if !m.factory.Unban(key) {
+		code = NOT_FOUND
+		err = errors.New("key is not banned")
+		return
+	}
+	result = []byte("true")
+	return
+}
+
+func (m *Monitor) getBans(w http.ResponseWriter, r *http.Request) (result []byte, err error, code int) {
+	if !m.authorize(w, r, RoleViewer, "") {
+		return
+	}
+	result, err = json.Marshal(m.factory.GetBans())
+	return
+}
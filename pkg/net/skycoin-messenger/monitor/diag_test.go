@@ -0,0 +1,46 @@
+package monitor
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+func TestNodeDiag(t *testing.T) {
+	tm := newTestMonitor(t, &User{Accounts: append([]Account(nil), testAccounts...)})
+	defer tm.close()
+	n := tm.connectNode(t)
+	n.setTransports("1.2.3")
+
+	operator := tm.login(t, "operator", "operator-pass")
+	status, body := operator.send(http.MethodGet, "/conn/getNodeDiag", url.Values{"key": {n.key}})
+	if status != http.StatusOK {
+		t.Fatalf("diag: %d %s", status, body)
+	}
+	var d struct {
+		Version string   `json:"version"`
+		Logs    []string `json:"logs"`
+	}
+	if err := json.Unmarshal([]byte(body), &d); err != nil || d.Version != "1.2.3" || len(d.Logs) != 1 {
+		t.Fatalf("diag %v: %s", err, body)
+	}
+	if calls := n.requested(); len(calls) != 1 || calls[0] != "/node/getDiag" {
+		t.Fatalf("requested %v", calls)
+	}
+
+	// the logs and goroutines of a node are not for viewers
+	viewer := tm.login(t, "viewer", "viewer-pass")
+	if status, body = viewer.send(http.MethodGet, "/conn/getNodeDiag", url.Values{"key": {n.key}}); status != http.StatusForbidden {
+		t.Fatalf("viewer: %d %s", status, body)
+	}
+	if status, body = viewer.send(http.MethodPost, "/req", url.Values{"addr": {n.srv.URL + "/node/getDiag"}}); status != http.StatusForbidden {
+		t.Fatalf("viewer through the proxy: %d %s", status, body)
+	}
+	offline := cipher.PubKey([33]byte{0x02, 0x08}).Hex()
+	if status, _ = operator.send(http.MethodGet, "/conn/getNodeDiag", url.Values{"key": {offline}}); status != http.StatusInternalServerError {
+		t.Fatalf("offline node: got %d", status)
+	}
+}
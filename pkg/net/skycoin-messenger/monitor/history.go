@@ -0,0 +1,286 @@
+package monitor
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/skycoin/skycoin/src/cipher"
+	"github.com/skycoin/skycoin/src/util/file"
+	"github.com/skycoin/skywire/pkg/net/skycoin-messenger/factory"
+)
+
+var historyPath = filepath.Join(file.UserHome(), ".skywire", "manager", "history.json")
+
+const (
+	historyInterval     = time.Minute
+	historySaveInterval = 10 * time.Minute
+)
+
+// resolution and retention of the stored time series,
+// the first tier covering a range query is used to answer it
+var historyTiers = []struct {
+	Step      time.Duration
+	Retention time.Duration
+}{
+	{time.Minute, 24 * time.Hour},
+	{15 * time.Minute, 7 * 24 * time.Hour},
+	{time.Hour, 30 * 24 * time.Hour},
+}
+
+// Sample of a node, the byte counters are totals since the node connected
+type Sample struct {
+	Time          int64  `json:"time"`
+	SendBytes     uint64 `json:"send_bytes"`
+	RecvBytes     uint64 `json:"recv_bytes"`
+	Uptime        int64  `json:"uptime"`
+	Transports    int    `json:"transports"`
+	UploadTotal   uint64 `json:"upload_total"`
+	DownloadTotal uint64 `json:"download_total"`
+	FailedApps    int    `json:"failed_apps"`
+	// limits exceeded as reported by the watchdog of the node
+	ResourcesExceeded int `json:"resources_exceeded"`
+}
+
+type nodeHistory struct {
+	// one series per tier
+	Tiers [][]Sample `json:"tiers"`
+}
+
+// add the sample to the first tier and downsample it into the others,
+// a downsampled point is the last sample of its step
+func (h *nodeHistory) add(s Sample) {
+	for len(h.Tiers) < len(historyTiers) {
+		h.Tiers = append(h.Tiers, nil)
+	}
+	for i, t := range historyTiers {
+		step := int64(t.Step / time.Second)
+		series := h.Tiers[i]
+		if n := len(series); n > 0 && series[n-1].Time/step == s.Time/step {
+			series[n-1] = s
+		} else {
+			series = append(series, s)
+		}
+		oldest := s.Time - int64(t.Retention/time.Second)
+		j := 0
+		for j < len(series) && series[j].Time < oldest {
+			j++
+		}
+		h.Tiers[i] = series[j:]
+	}
+}
+
+func (h *nodeHistory) query(from, to int64) (result []Sample) {
+	now := time.Now().Unix()
+	tier := len(historyTiers) - 1
+	for i, t := range historyTiers {
+		if now-from <= int64(t.Retention/time.Second) {
+			tier = i
+			break
+		}
+	}
+	result = make([]Sample, 0)
+	if tier >= len(h.Tiers) {
+		return
+	}
+	for _, s := range h.Tiers[tier] {
+		if s.Time >= from && s.Time <= to {
+			result = append(result, s)
+		}
+	}
+	return
+}
+
+type history struct {
+	path  string
+	nodes map[string]*nodeHistory
+	sync.RWMutex
+}
+
+func newHistory(path string) *history {
+	h := &history{path: path, nodes: make(map[string]*nodeHistory)}
+	fb, err := ioutil.ReadFile(path)
+	if err == nil {
+		err = json.Unmarshal(fb, &h.nodes)
+		if err != nil {
+			log.Errorf("read history err: %v", err)
+			h.nodes = make(map[string]*nodeHistory)
+		}
+	}
+	return h
+}
+
+func (h *history) add(key string, s Sample) {
+	h.Lock()
+	n, ok := h.nodes[key]
+	if !ok {
+		n = &nodeHistory{}
+		h.nodes[key] = n
+	}
+	n.add(s)
+	h.Unlock()
+}
+
+func (h *history) query(key string, from, to int64) (result []Sample, ok bool) {
+	h.RLock()
+	defer h.RUnlock()
+	n, ok := h.nodes[key]
+	if !ok {
+		return
+	}
+	result = n.query(from, to)
+	return
+}
+
+// drop the nodes without a sample within the longest retention
+func (h *history) prune() {
+	oldest := time.Now().Add(-historyTiers[len(historyTiers)-1].Retention).Unix()
+	h.Lock()
+	for k, n := range h.nodes {
+		if len(n.Tiers) == 0 {
+			delete(h.nodes, k)
+			continue
+		}
+		last := n.Tiers[len(n.Tiers)-1]
+		if len(last) == 0 || last[len(last)-1].Time < oldest {
+			delete(h.nodes, k)
+		}
+	}
+	h.Unlock()
+}
+
+func (h *history) save() (err error) {
+	h.RLock()
+	d, err := json.Marshal(h.nodes)
+	h.RUnlock()
+	if err != nil {
+		return
+	}
+	err = os.MkdirAll(filepath.Dir(h.path), 0700)
+	if err != nil {
+		return
+	}
+	err = ioutil.WriteFile(h.path, d, 0600)
+	return
+}
+
+// recordHistory samples all connected nodes and evaluates the alert rules
+// until the monitor is closed
+func (m *Monitor) recordHistory() {
+	ticker := time.NewTicker(historyInterval)
+	defer ticker.Stop()
+	lastSave := time.Now()
+	for {
+		select {
+		case <-m.closed:
+			return
+		case <-ticker.C:
+		}
+		m.sampleNodes()
+		m.evaluateAlerts()
+		if time.Since(lastSave) >= historySaveInterval {
+			m.history.prune()
+			err := m.history.save()
+			if err != nil {
+				log.Errorf("save history err: %v", err)
+			}
+			lastSave = time.Now()
+		}
+	}
+}
+
+func (m *Monitor) sampleNodes() {
+	now := time.Now().Unix()
+	var wg sync.WaitGroup
+	m.factory.ForEachAcceptedConnection(func(key cipher.PubKey, conn *factory.Connection) {
+		s := Sample{
+			Time:      now,
+			SendBytes: conn.GetSentBytes(),
+			RecvBytes: conn.GetReceivedBytes(),
+			Uptime:    now - conn.GetConnectTime(),
+		}
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			m.sampleNodeInfo(key, &s)
+			m.history.add(key, s)
+		}(key.Hex())
+	})
+	wg.Wait()
+}
+
+// add the transports and apps reported by the node api to the sample
+func (m *Monitor) sampleNodeInfo(key string, s *Sample) {
+	res, err := m.nodeRequest(key, "/node/getInfo", url.Values{"transports": {"false"}})
+	if err != nil {
+		log.Debugf("history node %s info: %v", key, err)
+		return
+	}
+	var info struct {
+		AppFeedbacks []struct {
+			Failed bool `json:"failed"`
+		} `json:"app_feedbacks"`
+		Watchdog *struct {
+			Exceeded []string `json:"exceeded"`
+		} `json:"watchdog"`
+	}
+	err = json.Unmarshal([]byte(res), &info)
+	if err != nil {
+		return
+	}
+	err = m.forEachNodeTransport(key, func(t *nodeTransport) {
+		s.Transports++
+		s.UploadTotal += t.UploadTotal
+		s.DownloadTotal += t.DownloadTotal
+	})
+	if err != nil {
+		log.Debugf("history node %s transports: %v", key, err)
+	}
+	for _, f := range info.AppFeedbacks {
+		if f.Failed {
+			s.FailedApps++
+		}
+	}
+	if info.Watchdog != nil {
+		s.ResourcesExceeded = len(info.Watchdog.Exceeded)
+	}
+}
+
+func (m *Monitor) getHistory(w http.ResponseWriter, r *http.Request) (result []byte, err error, code int) {
+	key := r.FormValue("key")
+	if !m.authorize(w, r, RoleViewer, key) {
+		return
+	}
+	now := time.Now().Unix()
+	from, to := now-int64(24*time.Hour/time.Second), now
+	if v := r.FormValue("from"); len(v) > 0 {
+		from, err = strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			code = BAD_REQUEST
+			return
+		}
+	}
+	if v := r.FormValue("to"); len(v) > 0 {
+		to, err = strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			code = BAD_REQUEST
+			return
+		}
+	}
+	samples, ok := m.history.query(key, from, to)
+	if !ok {
+		code = NOT_FOUND
+		err = errors.New("no history for node")
+		return
+	}
+	result, err = json.Marshal(samples)
+	return
+}
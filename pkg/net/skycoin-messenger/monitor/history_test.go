@@ -0,0 +1,138 @@
+package monitor
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+func TestHistoryDownsampling(t *testing.T) {
+	// a sample a minute for two hours from an hour boundary
+	const base = 1500000000 - 1500000000%3600
+	var h nodeHistory
+	for i := int64(0); i < 120; i++ {
+		h.add(Sample{Time: base + i*60, Uptime: i})
+	}
+	if len(h.Tiers) != len(historyTiers) {
+		t.Fatalf("%d tiers", len(h.Tiers))
+	}
+	// the coarser tiers keep the last sample of each step
+	for i, want := range [][]int64{{0, 1, 2}, {14, 29, 44, 59, 74, 89, 104, 119}, {59, 119}} {
+		series := h.Tiers[i]
+		if i == 0 {
+			if len(series) != 120 || series[0].Uptime != 0 || series[119].Uptime != 119 {
+				t.Fatalf("tier 0: %d samples", len(series))
+			}
+			continue
+		}
+		if len(series) != len(want) {
+			t.Fatalf("tier %d: %+v", i, series)
+		}
+		for j, s := range series {
+			if s.Uptime != want[j] || s.Time != base+want[j]*60 {
+				t.Fatalf("tier %d sample %d: %+v, want the one of minute %d", i, j, s, want[j])
+			}
+		}
+	}
+
+	// each tier drops the samples past its retention
+	h.add(Sample{Time: base + 27*3600, Uptime: 1000})
+	if series := h.Tiers[0]; len(series) != 1 || series[0].Uptime != 1000 {
+		t.Fatalf("tier 0 after a day: %+v", series)
+	}
+	if n := len(h.Tiers[1]); n != 9 {
+		t.Fatalf("tier 1 after a day: %d samples", n)
+	}
+	h.add(Sample{Time: base + 8*24*3600 + 4*3600, Uptime: 2000})
+	if series := h.Tiers[1]; len(series) != 1 || series[0].Uptime != 2000 {
+		t.Fatalf("tier 1 after a week: %+v", series)
+	}
+	if n := len(h.Tiers[2]); n != 4 {
+		t.Fatalf("tier 2 after a week: %d samples", n)
+	}
+}
+
+func TestHistoryPrune(t *testing.T) {
+	h := newHistory(historyPath)
+	now := time.Now()
+	retention := historyTiers[len(historyTiers)-1].Retention
+	h.add("old", Sample{Time: now.Add(-retention - time.Hour).Unix()})
+	h.add("recent", Sample{Time: now.Add(-retention + time.Hour).Unix()})
+	h.add("current", Sample{Time: now.Unix()})
+	h.nodes["empty"] = &nodeHistory{}
+	h.prune()
+	if len(h.nodes) != 2 || h.nodes["recent"] == nil || h.nodes["current"] == nil {
+		t.Fatalf("nodes after prune %v", h.nodes)
+	}
+
+	// the pruned history is saved and read back
+	if err := h.save(); err != nil {
+		t.Fatal(err)
+	}
+	if read := newHistory(historyPath); len(read.nodes) != 2 || read.nodes["current"] == nil {
+		t.Fatalf("read back %v", read.nodes)
+	}
+}
+
+func TestHistoryQuery(t *testing.T) {
+	tm := newTestMonitor(t, &User{Accounts: append([]Account(nil), testAccounts...)})
+	defer tm.close()
+	n := tm.connectNode(t)
+	now := time.Now().Unix()
+	// a sample a minute for the last three hours
+	start := now - now%3600 - 3*3600
+	for ts := start; ts <= now; ts += 60 {
+		tm.history.add(n.key, Sample{Time: ts, Uptime: ts - start})
+	}
+	viewer := tm.login(t, "viewer", "viewer-pass")
+	get := func(form url.Values) (status int, samples []Sample) {
+		status, body := viewer.send(http.MethodGet, "/history/get", form)
+		if status == http.StatusOK {
+			if err := json.Unmarshal([]byte(body), &samples); err != nil {
+				t.Fatalf("%v: %v %s", form, err, body)
+			}
+		}
+		return
+	}
+	itoa := func(i int64) string { return strconv.FormatInt(i, 10) }
+
+	// the last day by default
+	if status, samples := get(url.Values{"key": {n.key}}); status != http.StatusOK || len(samples) != int((now-start)/60)+1 {
+		t.Fatalf("default range: %d %d samples", status, len(samples))
+	}
+	// both bounds are included
+	from, to := start+600, start+1200
+	status, samples := get(url.Values{"key": {n.key}, "from": {itoa(from)}, "to": {itoa(to)}})
+	if status != http.StatusOK || len(samples) != 11 || samples[0].Time != from || samples[10].Time != to {
+		t.Fatalf("range: %d %+v", status, samples)
+	}
+	// a range past the first tier is answered from the coarser one
+	status, samples = get(url.Values{"key": {n.key}, "from": {itoa(now - 2*24*3600)}})
+	if status != http.StatusOK || len(samples) < 12 || samples[0].Time != start+14*60 {
+		t.Fatalf("range of two days: %d %+v", status, samples)
+	}
+	if status, samples = get(url.Values{"key": {n.key}, "from": {itoa(now + 60)}}); status != http.StatusOK || len(samples) != 0 {
+		t.Fatalf("range in the future: %d %+v", status, samples)
+	}
+	if status, samples = get(url.Values{"key": {n.key}, "from": {itoa(to)}, "to": {itoa(from)}}); status != http.StatusOK || len(samples) != 0 {
+		t.Fatalf("inverted range: %d %+v", status, samples)
+	}
+
+	for _, form := range []url.Values{
+		{"key": {n.key}, "from": {"yesterday"}},
+		{"key": {n.key}, "to": {"1.5"}},
+	} {
+		if status, _ := get(form); status != http.StatusBadRequest {
+			t.Errorf("%v: got %d", form, status)
+		}
+	}
+	unknown := cipher.PubKey([33]byte{0x02, 0x09}).Hex()
+	if status, _ := get(url.Values{"key": {unknown}}); status != http.StatusNotFound {
+		t.Fatalf("unknown node: got %d", status)
+	}
+}
@@ -0,0 +1,24 @@
+package monitor
+
+import (
+	"net/http"
+	"net/url"
+)
+
+// setNodeMaintenance drains a node for the duration before a reboot, 0 ends the maintenance,
+// see /node/run/maintenance
+func (m *Monitor) setNodeMaintenance(w http.ResponseWriter, r *http.Request) (result []byte, err error, code int) {
+	key := r.FormValue("key")
+	if !m.authorize(w, r, RoleOperator, key) {
+		return
+	}
+	res, err := m.nodeRequest(key, "/node/run/maintenance", url.Values{
+		"duration": {r.FormValue("duration")},
+	})
+	if err != nil {
+		code = SERVER_ERROR
+		return
+	}
+	result = []byte(res)
+	return
+}
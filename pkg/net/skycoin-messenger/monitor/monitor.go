@@ -1,11 +1,15 @@
 package monitor
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"sort"
@@ -19,14 +23,24 @@ import (
 	log "github.com/sirupsen/logrus"
 	"github.com/skycoin/skycoin/src/cipher"
 	"github.com/skycoin/skycoin/src/util/file"
+	"github.com/skycoin/skywire/pkg/httputil"
 	"github.com/skycoin/skywire/pkg/net/skycoin-messenger/factory"
+	"github.com/skycoin/skywire/pkg/net/util"
 )
 
 var globalSessions *session.Manager
 
+// the deadlines of the handlers, answered with 503 when they are exceeded
+const (
+	// answered from the state of the manager
+	stateTimeout = 10 * time.Second
+	// asking a node, longer than a request to the node
+	nodeTimeout = bulkNodeTimeout + 10*time.Second
+)
+
 func init() {
 	sessionConfig := &session.ManagerConfig{
-		CookieName:      "SWSId",
+		CookieName:      sessionCookieName,
 		EnableSetCookie: true,
 		Gclifetime:      3600,
 		Maxlifetime:     86400,
@@ -45,6 +59,8 @@ type Conn struct {
 	RecvBytes   uint64 `json:"recv_bytes"`
 	LastAckTime int64  `json:"last_ack_time"`
 	StartTime   int64  `json:"start_time"`
+	// the node proved it showed a pairing code entered in the manager
+	Paired bool `json:"paired"`
 }
 type NodeServices struct {
 	Type        string `json:"type"`
@@ -74,14 +90,34 @@ type Monitor struct {
 	serverAddress string
 	address       string
 	srv           *http.Server
+	listener      net.Listener
+	cors          *httputil.CORS
+	proxies       *httputil.Proxies
 
 	tag     string
 	version string
 
 	token string
 
+	tokens      *tokenStore
+	challenges  challenges
+	bulkJobs    bulkJobs
+	history     *history
+	alerts      *alerts
+	peerLists   *peerLists
+	pairedNodes *pairedNodes
+	// nonces of the signed requests of the nodes
+	nodeNonces util.ShellNonces
+
+	closed    chan struct{}
+	closeOnce sync.Once
+
 	configs      map[string]*Config
 	configsMutex sync.RWMutex
+
+	twoFactorPolicy   TwoFactorPolicy
+	twoFactorFailures twoFactorFailures
+	twoFactorMutex    sync.RWMutex
 }
 
 func New(f *factory.MessengerFactory, serverAddress, webAddr, tag, version string) *Monitor {
@@ -93,39 +129,326 @@ func New(f *factory.MessengerFactory, serverAddress, webAddr, tag, version strin
 		tag:           tag,
 		version:       version,
 		token:         getRandomString(32),
+		tokens:        newTokenStore(tokenPath),
+		history:       newHistory(historyPath),
+		alerts:        newAlerts(alertPath),
+		peerLists:     newPeerLists(peerListsPath),
+		pairedNodes:   newPairedNodes(pairedNodesPath),
 		configs:       make(map[string]*Config),
+		closed:        make(chan struct{}),
+
+		twoFactorPolicy: TwoFactorOptional,
 	}
 }
 
 func (m *Monitor) Close() error {
-	return m.srv.Close()
+	m.closeOnce.Do(func() {
+		close(m.closed)
+		err := m.history.save()
+		if err != nil {
+			log.Errorf("save history err: %v", err)
+		}
+	})
+	return httputil.Shutdown(m.srv)
 }
+
+// SetCORS lets the pages of the origins of c call the api from the browser
+func (m *Monitor) SetCORS(c *httputil.CORS) {
+	m.cors = c
+}
+
+// SetTrustedProxies takes the address of the clients and the scheme they used from the
+// forwarding headers of the requests of the proxies, see httputil.Proxies
+func (m *Monitor) SetTrustedProxies(p *httputil.Proxies) {
+	m.proxies = p
+}
+
+// SetListener makes the api serve on a listener passed by the service manager instead of
+// listening on its address
+func (m *Monitor) SetListener(ln net.Listener) {
+	m.listener = ln
+}
+
+// SetTLS serves the api with the certificate of c, see httputil.LoadTLS
+func (m *Monitor) SetTLS(c *tls.Config) {
+	m.srv.TLSConfig = c
+}
+
 func (m *Monitor) Start(webDir string) {
-	http.Handle("/", http.FileServer(http.Dir(webDir)))
-	http.HandleFunc("/conn/getAll", bundle(m.getAllNode))
-	http.HandleFunc("/conn/getServerInfo", bundle(m.getServerInfo))
-	http.HandleFunc("/conn/getNode", bundle(m.getNode))
-	http.HandleFunc("/conn/setNodeConfig", bundle(m.setNodeConfig))
-	http.HandleFunc("/conn/getNodeConfig", bundle(m.getNodeConfig))
-	http.HandleFunc("/conn/saveClientConnection", bundle(m.SaveClientConnection))
-	http.HandleFunc("/conn/removeClientConnection", bundle(m.RemoveClientConnection))
-	http.HandleFunc("/conn/editClientConnection", bundle(m.EditClientConnection))
-	http.HandleFunc("/conn/getClientConnection", bundle(m.GetClientConnection))
-	http.HandleFunc("/login", bundle(m.Login))
-	http.HandleFunc("/checkLogin", bundle(m.checkLogin))
-	http.HandleFunc("/updatePass", bundle(m.UpdatePass))
-	http.HandleFunc("/req", bundle(m.req))
-	http.HandleFunc("/term", m.handleNodeTerm)
-	http.HandleFunc("/getPort", bundle(m.getPort))
-	http.HandleFunc("/getToken", bundle(m.getToken))
+	m.srv.Handler = m.proxies.Handler(m.cors.Handler(m.routes(webDir)))
+	go m.recordHistory()
+	go m.syncPeerListsLoop()
 	go func() {
-		if err := m.srv.ListenAndServe(); err != nil {
-			log.Printf("http server: ListenAndServe() error: %s", err)
+		if err := httputil.ServeListener(context.Background(), m.srv, m.listener); err != nil {
+			log.Printf("http server: %s", err)
 		}
 	}()
 	log.Debugf("http server listen on %s", m.address)
 }
 
+// routes returns the handler of the api and of the pages in webDir
+func (m *Monitor) routes(webDir string) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/", http.FileServer(http.Dir(webDir)))
+	spec := httputil.NewSpec("Skywire Manager API", m.version)
+	spec.Description = "The api of the manager, which is also the discovery of its nodes. " +
+		"The requests carry the session cookie of /login or an Authorization: Bearer token"
+	// the routes answer from the state of the manager within stateTimeout unless they set
+	// another timeout
+	get := func(path string, h http.HandlerFunc) *httputil.Route {
+		r := spec.Describe(http.MethodGet, path)
+		mux.Handle(path, r.Timed(stateTimeout, h))
+		return r
+	}
+	post := func(path string, h http.HandlerFunc) *httputil.Route {
+		r := spec.Describe(http.MethodPost, path)
+		mux.Handle(path, r.Timed(stateTimeout, postOnly(h)))
+		return r
+	}
+	get("/conn/getAll", bundle(m.getAllNode)).
+		Doc("The nodes connected to the discovery")
+	get("/conn/getServerInfo", bundle(m.getServerInfo)).
+		Doc("The info of the discovery")
+	get("/conn/getHandshakeStats", bundle(m.getHandshakeStats)).
+		Doc("The handshakes of the nodes with the discovery")
+	get("/conn/getQueryStats", bundle(m.getQueryStats)).
+		Doc("The queries of the nodes to the discovery")
+	get("/conn/getSendStats", bundle(m.getSendStats)).
+		Doc("The messages the discovery forwards between the nodes")
+	get("/conn/getFDStats", bundle(m.getFDStats)).
+		Doc("The file descriptors of the discovery")
+	get("/metrics", bundle(m.getMetrics)).
+		Doc("The clients of the discovery, their throughput and the errors of the discovery")
+	post("/conn/disconnect", bundle(m.audited(auditor{action: "conn/disconnect"}, m.disconnectClient))).
+		Doc("Close the connection of a client").
+		Param("key", "the key of the client")
+	post("/conn/ban", bundle(m.audited(auditor{action: "conn/ban"}, m.banClient))).
+		Doc("Disconnect a client and refuse its key for a while").
+		Param("key", "the key of the client").
+		Param("duration", "how long, like 1h")
+	post("/conn/unban", bundle(m.audited(auditor{action: "conn/unban"}, m.unbanClient))).
+		Doc("Lift the ban of a key").
+		Param("key", "the key")
+	get("/conn/getBans", bundle(m.getBans)).
+		Doc("The keys banned from the discovery")
+	get("/conn/getNodeDiag", bundle(m.getNodeDiag)).
+		Within(nodeTimeout).
+		Doc("The diagnostics of a node").
+		Param("key", "the key of the node")
+	get("/conn/getNodeTransports", bundle(m.getNodeTransports)).
+		Within(nodeTimeout).
+		Doc("A page of the transports of a node").
+		Param("key", "the key of the node").
+		Param("limit", "the most transports in the page").
+		Param("cursor", "the next of the previous page")
+	get("/conn/getNodeProfile", m.getNodeProfile).
+		Within(httputil.NoTimeout).
+		Doc("A runtime profile of a node").
+		Param("key", "the key of the node").
+		Param("type", "cpu, heap, goroutine, block, mutex, allocs or threadcreate").
+		Param("seconds", "duration of the cpu profile")
+	post("/conn/setNodeMaintenance", bundle(m.audited(auditor{action: "conn/setNodeMaintenance"}, m.setNodeMaintenance))).
+		Within(nodeTimeout).
+		Doc("Put a node in maintenance").
+		Param("key", "the key of the node").
+		Param("duration", "how long, like 30m")
+	get("/conn/getNode", bundle(m.getNode)).
+		Doc("The address of a node").
+		Param("key", "the key of the node")
+	post("/conn/setNodeConfig", bundle(m.audited(auditor{action: "conn/setNodeConfig", old: oldNodeConfig}, m.setNodeConfig))).
+		Doc("Set the config the manager keeps for a node").
+		Param("key", "the key of the node").
+		Param("data", "the config")
+	get("/conn/getNodeConfig", bundle(m.getNodeConfig)).
+		Doc("The config the manager keeps for a node").
+		Param("key", "the key of the node")
+	post("/conn/saveClientConnection", bundle(m.audited(auditor{action: "conn/saveClientConnection", old: oldClientConnections}, m.SaveClientConnection))).
+		Doc("Save a connection of a client app").
+		Param("client", "sshc or socksc").
+		Param("data", "the connection")
+	post("/conn/removeClientConnection", bundle(m.audited(auditor{action: "conn/removeClientConnection", old: oldClientConnections}, m.RemoveClientConnection))).
+		Doc("Remove a saved connection of a client app").
+		Param("client", "sshc or socksc").
+		Param("index", "the index of the connection")
+	post("/conn/editClientConnection", bundle(m.audited(auditor{action: "conn/editClientConnection", old: oldClientConnections}, m.EditClientConnection))).
+		Doc("Label a saved connection of a client app").
+		Param("client", "sshc or socksc").
+		Param("index", "the index of the connection").
+		Param("label", "the label")
+	get("/conn/getClientConnection", bundle(m.GetClientConnection)).
+		Doc("The saved connections of a client app").
+		Param("client", "sshc or socksc")
+	post("/login", bundle(m.Login)).
+		Doc("Start a session").
+		Param("user", "the name of the user").
+		Param("pass", "the password").
+		Param("otp", "the code of the authenticator app or a backup code")
+	post("/checkLogin", bundle(m.checkLogin)).
+		Doc("Check that the session is still authorised")
+	post("/updatePass", bundle(m.audited(auditor{action: "updatePass"}, m.UpdatePass))).
+		Doc("Change the password").
+		Param("oldPass", "the password").
+		Param("newPass", "the new password")
+	get("/auth/challenge", bundle(m.getChallenge)).
+		Doc("A nonce to sign for a key login")
+	post("/auth/keyLogin", bundle(m.keyLogin)).
+		Doc("Start a session with a signed nonce").
+		Param("key", "the public key").
+		Param("sig", "the signature of the nonce").
+		Param("nonce", "the nonce of the challenge").
+		Param("otp", "the code of the authenticator app or a backup code")
+	get("/auth/2fa/status", bundle(m.getTwoFactorStatus)).
+		Doc("The two-factor authentication of the user")
+	post("/auth/2fa/enroll", bundle(m.enrollTwoFactor)).
+		Doc("Start setting up two-factor authentication")
+	post("/auth/2fa/confirm", bundle(m.audited(auditor{action: "auth/2fa/confirm"}, m.confirmTwoFactor))).
+		Doc("Finish setting up two-factor authentication").
+		Param("code", "the code of the authenticator app")
+	post("/auth/2fa/disable", bundle(m.audited(auditor{action: "auth/2fa/disable"}, m.disableTwoFactor))).
+		Doc("Turn off two-factor authentication").
+		Param("code", "the code of the authenticator app")
+	post("/auth/2fa/backupCodes", bundle(m.audited(auditor{action: "auth/2fa/backupCodes"}, m.renewBackupCodes))).
+		Doc("Renew the backup codes").
+		Param("code", "the code of the authenticator app")
+	post("/auth/2fa/reset", bundle(m.audited(auditor{action: "auth/2fa/reset"}, m.resetTwoFactor))).
+		Doc("Reset the two-factor authentication of a user").
+		Param("name", "the name of the user")
+	post("/auth/createToken", bundle(m.audited(auditor{action: "auth/createToken"}, m.createToken))).
+		Doc("Create an api token").
+		Param("role", "viewer, operator or admin").
+		Param("groups", "comma separated node groups").
+		Param("label", "the label").
+		Param("ttl", "lifetime in seconds, 0 never expires")
+	get("/auth/listTokens", bundle(m.listTokens)).
+		Doc("The api tokens")
+	post("/auth/revokeToken", bundle(m.audited(auditor{action: "auth/revokeToken", old: oldToken}, m.revokeToken))).
+		Doc("Revoke an api token").
+		Param("id", "the id of the token")
+	get("/group/getAll", bundle(m.getGroups)).
+		Doc("The node groups")
+	post("/group/set", bundle(m.audited(auditor{action: "group/set", old: oldGroup}, m.setGroup))).
+		Doc("Set the nodes of a group").
+		Param("name", "the name of the group").
+		Param("keys", "comma separated node keys")
+	post("/group/bulk", bundle(m.audited(auditor{action: "group/bulk"}, m.bulk))).
+		Doc("Run an action on every node of a group").
+		Param("group", "the name of the group").
+		Param("action", "setAutoStart, restartApp or setDiscovery").
+		Param("data", "the data of the action")
+	get("/group/getBulkJob", bundle(m.getBulkJob)).
+		Doc("The progress of a bulk job").
+		Param("id", "the id of the job")
+	get("/history/get", bundle(m.getHistory)).
+		Doc("The history of a node").
+		Param("key", "the key of the node").
+		Param("from", "unix time").
+		Param("to", "unix time")
+	get("/topology/export", m.exportTopology).
+		Within(httputil.NoTimeout).
+		Doc("The topology of the nodes").
+		Param("format", "json, dot or graphml").
+		Param("anonymize", "true to hash the keys")
+	get("/conn/explainNodeRoute", bundle(m.explainNodeRoute)).
+		Doc("Why the transports of a setup of a node went through their discoveries").
+		Param("key", "the key of the node").
+		Param("id", "the route of the transport")
+	get("/alert/getConfig", bundle(m.getAlertConfig)).
+		Doc("The alert config")
+	post("/alert/setConfig", bundle(m.audited(auditor{action: "alert/setConfig", old: oldAlertConfig}, m.setAlertConfig))).
+		Doc("Set the alert config").
+		Param("data", "the config")
+	get("/alert/getActive", bundle(m.getActiveAlerts)).
+		Doc("The active alerts")
+	get("/provision/getState", bundle(m.getNodeState)).
+		Within(nodeTimeout).
+		Doc("The state of a node").
+		Param("key", "the key of the node")
+	post("/provision/apply", bundle(m.audited(auditor{action: "provision/apply", result: true}, m.applyNodeState))).
+		Within(httputil.NoTimeout).
+		Doc("Apply a state to a node").
+		Param("key", "the key of the node").
+		Param("data", "the state").
+		Param("dry_run", "true to only answer the changes")
+	get("/appFiles/list", bundle(m.listAppFiles)).
+		Within(nodeTimeout).
+		Doc("The config files of an app of a node").
+		Param("key", "the key of the node").
+		Param("app", "the name of the app")
+	get("/appFiles/read", bundle(m.readAppFile)).
+		Within(nodeTimeout).
+		Doc("Read a config file of an app of a node").
+		Param("key", "the key of the node").
+		Param("app", "the name of the app").
+		Param("path", "the path of the file")
+	post("/appFiles/write", bundle(m.writeAppFile)).
+		Within(nodeTimeout).
+		Doc("Write a config file of an app of a node").
+		Param("key", "the key of the node").
+		Param("app", "the name of the app").
+		Param("path", "the path of the file").
+		Param("data", "the content of the file")
+	get("/peers/get", bundle(m.getPeerLists)).
+		Doc("The peer lists").
+		Param("version", "the version, the current one if empty")
+	get("/peers/getHistory", bundle(m.getPeerListsHistory)).
+		Doc("The versions of the peer lists")
+	get("/peers/getStatus", bundle(m.getPeerListsStatus)).
+		Doc("The version of the peer lists each node has")
+	post("/peers/set", bundle(m.audited(auditor{action: "peers/set", old: oldPeerLists}, m.setPeerLists))).
+		Doc("Set the peer lists").
+		Param("data", "the peer lists")
+	post("/peers/rollback", bundle(m.audited(auditor{action: "peers/rollback", old: oldPeerLists, result: true}, m.rollbackPeerLists))).
+		Doc("Roll back to a version of the peer lists").
+		Param("version", "the version")
+	post("/pairing/pair", bundle(m.audited(auditor{action: "pairing/pair", result: true}, m.pairNode))).
+		Within(httputil.NoTimeout).
+		Doc("Pair a node with its pairing code").
+		Param("code", "the pairing code")
+	get("/pairing/getNodes", bundle(m.getPairedNodes)).
+		Doc("The paired nodes")
+	get("/audit/get", bundle(m.getAudit)).
+		Doc("The audit log").
+		Param("key", "the key of a node").
+		Param("from", "unix time").
+		Param("to", "unix time").
+		Param("limit", "the most entries")
+	get("/audit/export", m.exportAudit).
+		Within(httputil.NoTimeout).
+		Doc("Export the audit log").
+		Param("key", "the key of a node").
+		Param("from", "unix time").
+		Param("to", "unix time").
+		Param("format", "json or csv")
+	post("/req", bundle(m.audited(auditor{action: "req", changes: nodeRequestChanges}, m.req))).
+		Within(nodeTimeout).
+		Doc("Forward a request to the api of a node").
+		Param("addr", "the url of the api of the node").
+		Param("method", "GET or POST")
+	get("/term", m.handleNodeTerm).
+		Within(httputil.NoTimeout).
+		Doc("A terminal of a node over a websocket").
+		Param("token", "the token of the manager").
+		Param("url", "the url of the terminal of the node")
+	get("/getPort", bundle(m.getPort)).
+		Doc("The port of the manager")
+	get("/getToken", bundle(m.getToken)).
+		Doc("The token of the manager")
+	mux.Handle(httputil.SpecPath, spec)
+	return mux
+}
+
+// postOnly answers 405 to the requests of a route changing the state that are not POST
+func postOnly(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			httputil.Fail(w, "please use post method", http.StatusMethodNotAllowed)
+			return
+		}
+		h(w, r)
+	}
+}
+
 func bundle(fn func(w http.ResponseWriter, r *http.Request) (result []byte, err error, code int)) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		result, err, code := fn(w, r)
@@ -133,7 +456,7 @@ func bundle(fn func(w http.ResponseWriter, r *http.Request) (result []byte, err
 			if code == 0 {
 				code = SERVER_ERROR
 			}
-			http.Error(w, err.Error(), code)
+			httputil.Fail(w, err.Error(), code)
 			return
 		}
 		w.Header().Set("Content-Type", "application/json")
@@ -142,7 +465,7 @@ func bundle(fn func(w http.ResponseWriter, r *http.Request) (result []byte, err
 }
 
 func (m *Monitor) getPort(w http.ResponseWriter, r *http.Request) (result []byte, err error, code int) {
-	if !verifyLogin(w, r, false) {
+	if !m.authorize(w, r, RoleViewer, "") {
 		return
 	}
 	_, port, err := net.SplitHostPort(m.address)
@@ -153,13 +476,29 @@ func (m *Monitor) getPort(w http.ResponseWriter, r *http.Request) (result []byte
 	return
 }
 
+// getToken answers the token of the node apis to a connected node signing the request with its key,
+// or to an admin of all the nodes
 func (m *Monitor) getToken(w http.ResponseWriter, r *http.Request) (result []byte, err error, code int) {
+	if len(r.Header.Get(util.ShellKeyHeader)) > 0 {
+		err = m.verifyNodeRequest(r)
+		if err != nil {
+			code = http.StatusForbidden
+			return
+		}
+	} else if !hasCredentials(r) {
+		httputil.Fail(w, "Forbidden", http.StatusForbidden)
+		return
+	} else if !m.authorizeAllNodes(w, r) {
+		return
+	}
 	result = []byte(m.token)
 	return
 }
 
 func (m *Monitor) req(w http.ResponseWriter, r *http.Request) (result []byte, err error, code int) {
-	if !verifyLogin(w, r, false) {
+	addr := r.FormValue("addr")
+	key := m.nodeKeyByAddr(addr)
+	if !m.authorize(w, r, nodeRequestRole(addr), key) {
 		return
 	}
 	if r.Method != "POST" {
@@ -167,25 +506,48 @@ func (m *Monitor) req(w http.ResponseWriter, r *http.Request) (result []byte, er
 		err = errors.New("please use post method")
 		return
 	}
-	addr := r.FormValue("addr")
 	if len(addr) == 0 {
 		err = errors.New("Node Address is Empty")
 		return
 	}
+	// only the api of a connected node is asked, at the address it announced
+	if len(key) == 0 {
+		code = BAD_REQUEST
+		err = errors.New("Not the api of a connected node")
+		return
+	}
+	u, err := url.Parse(addr)
+	if err != nil {
+		code = BAD_REQUEST
+		return
+	}
+	host, err := m.nodeAPIAddrByKey(key)
+	if err != nil {
+		code = BAD_REQUEST
+		return
+	}
+	addr = (&url.URL{Scheme: "http", Host: host, Path: u.Path, RawQuery: u.RawQuery}).String()
 	r.PostForm.Add("token", m.token)
-	var res *http.Response
-	method := r.FormValue("method")
-	switch method {
-	case "post":
-		res, err = http.PostForm(addr, r.PostForm)
-		break
-	case "get":
-		res, err = http.Get(addr)
-		break
-	default:
-		res, err = http.PostForm(addr, r.PostForm)
-		break
+	// the request to the node is given up with the request to the manager
+	var nr *http.Request
+	var body []byte
+	if r.FormValue("method") == "get" {
+		nr, err = http.NewRequest(http.MethodGet, addr, nil)
+	} else {
+		body = []byte(r.PostForm.Encode())
+		nr, err = http.NewRequest(http.MethodPost, addr, bytes.NewReader(body))
+		if err == nil {
+			nr.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	}
+	if err != nil {
+		code = BAD_REQUEST
+		return
+	}
+	for k, v := range m.shellSignature(nr.Method, addr, body) {
+		nr.Header.Set(k, v)
 	}
+	res, err := http.DefaultClient.Do(nr.WithContext(r.Context()))
 	if err != nil {
 		if res != nil {
 			return result, err, res.StatusCode
@@ -202,15 +564,28 @@ func (m *Monitor) req(w http.ResponseWriter, r *http.Request) (result []byte, er
 }
 
 func (m *Monitor) getAllNode(w http.ResponseWriter, r *http.Request) (result []byte, err error, code int) {
+	p, ok := m.requestPrincipal(w, r)
+	if !ok {
+		return
+	}
+	if !p.Role.allows(RoleViewer) {
+		httputil.Fail(w, "Forbidden", http.StatusForbidden)
+		return
+	}
 	cs := make([]Conn, 0)
 	m.factory.ForEachAcceptedConnection(func(key cipher.PubKey, conn *factory.Connection) {
+		// the nodes of the other groups are not listed
+		if !p.canAccess(key.Hex()) {
+			return
+		}
 		now := time.Now().Unix()
 		content := Conn{
 			Key:         key.Hex(),
 			SendBytes:   conn.GetSentBytes(),
 			RecvBytes:   conn.GetReceivedBytes(),
 			StartTime:   now - conn.GetConnectTime(),
-			LastAckTime: now - conn.GetLastTime()}
+			LastAckTime: now - conn.GetLastTime(),
+			Paired:      m.pairedNodes.paired(key.Hex())}
 		if conn.IsTCP() {
 			content.Type = "TCP"
 		} else {
@@ -227,7 +602,7 @@ func (m *Monitor) getAllNode(w http.ResponseWriter, r *http.Request) (result []b
 }
 
 func (m *Monitor) getNode(w http.ResponseWriter, r *http.Request) (result []byte, err error, code int) {
-	if !verifyLogin(w, r, false) {
+	if !m.authorize(w, r, RoleViewer, r.FormValue("key")) {
 		return
 	}
 	if r.Method != "POST" {
@@ -235,7 +610,7 @@ func (m *Monitor) getNode(w http.ResponseWriter, r *http.Request) (result []byte
 		err = errors.New("please use post method")
 		return
 	}
-	key, err := cipher.PubKeyFromHex(r.FormValue("key"))
+	key, err := pubKeyFromHex(r.FormValue("key"))
 	if err != nil {
 		code = BAD_REQUEST
 		return
@@ -257,23 +632,10 @@ func (m *Monitor) getNode(w http.ResponseWriter, r *http.Request) (result []byte
 	} else {
 		nodeService.Type = "UDP"
 	}
-	v, ok := c.LoadContext("node-api")
-	if ok {
-		webPort, ok := v.(string)
-		if ok && len(webPort) > 1 {
-			var host, port string
-			host, _, err = net.SplitHostPort(c.GetRemoteAddr().String())
-			if err != nil {
-				code = SERVER_ERROR
-				return
-			}
-			_, port, err = net.SplitHostPort(webPort)
-			if err != nil {
-				code = SERVER_ERROR
-				return
-			}
-			nodeService.Addr = net.JoinHostPort(host, port)
-		}
+	nodeService.Addr, err = nodeAPIAddr(c)
+	if err != nil {
+		code = SERVER_ERROR
+		return
 	}
 	result, err = json.Marshal(nodeService)
 	if err != nil {
@@ -288,7 +650,7 @@ type Config struct {
 }
 
 func (m *Monitor) setNodeConfig(w http.ResponseWriter, r *http.Request) (result []byte, err error, code int) {
-	if !verifyLogin(w, r, false) {
+	if !m.authorize(w, r, RoleAdmin, r.FormValue("key")) {
 		return
 	}
 	if r.Method != "POST" {
@@ -315,7 +677,7 @@ func (m *Monitor) setNodeConfig(w http.ResponseWriter, r *http.Request) (result
 }
 
 func (m *Monitor) getNodeConfig(w http.ResponseWriter, r *http.Request) (result []byte, err error, code int) {
-	if !verifyLogin(w, r, false) {
+	if !m.authorize(w, r, RoleViewer, r.FormValue("key")) {
 		return
 	}
 	if r.Method != "POST" {
@@ -367,7 +729,7 @@ var clientPath = filepath.Join(file.UserHome(), ".skywire", "manager", "clients.
 var clientLimit = 5
 
 func (m *Monitor) SaveClientConnection(w http.ResponseWriter, r *http.Request) (result []byte, err error, code int) {
-	if !verifyLogin(w, r, false) {
+	if !m.authorize(w, r, RoleOperator, "") {
 		return
 	}
 	data := r.FormValue("data")
@@ -411,7 +773,7 @@ func (m *Monitor) SaveClientConnection(w http.ResponseWriter, r *http.Request) (
 }
 
 func (m *Monitor) GetClientConnection(w http.ResponseWriter, r *http.Request) (result []byte, err error, code int) {
-	if !verifyLogin(w, r, false) {
+	if !m.authorize(w, r, RoleViewer, "") {
 		return
 	}
 	client := r.FormValue("client")
@@ -421,7 +783,7 @@ func (m *Monitor) GetClientConnection(w http.ResponseWriter, r *http.Request) (r
 }
 
 func (m *Monitor) RemoveClientConnection(w http.ResponseWriter, r *http.Request) (result []byte, err error, code int) {
-	if !verifyLogin(w, r, false) {
+	if !m.authorize(w, r, RoleOperator, "") {
 		return
 	}
 	client := r.FormValue("client")
@@ -449,7 +811,7 @@ func (m *Monitor) RemoveClientConnection(w http.ResponseWriter, r *http.Request)
 }
 
 func (m *Monitor) EditClientConnection(w http.ResponseWriter, r *http.Request) (result []byte, err error, code int) {
-	if !verifyLogin(w, r, false) {
+	if !m.authorize(w, r, RoleOperator, "") {
 		return
 	}
 	client := r.FormValue("client")
@@ -520,7 +882,7 @@ var upgrader = websocket.Upgrader{
 func (m *Monitor) handleNodeTerm(w http.ResponseWriter, r *http.Request) {
 	token := r.URL.Query()["token"][0]
 	if len(token) == 0 {
-		http.Error(w, "Token is Empty", http.StatusBadRequest)
+		httputil.Fail(w, "Token is Empty", http.StatusBadRequest)
 		return
 	}
 	if !verifyWs(w, r, token) {
@@ -528,9 +890,22 @@ func (m *Monitor) handleNodeTerm(w http.ResponseWriter, r *http.Request) {
 	}
 	url := r.URL.Query()["url"][0]
 	if len(url) == 0 {
-		http.Error(w, "Url is Empty", http.StatusBadRequest)
+		httputil.Fail(w, "Url is Empty", http.StatusBadRequest)
+		return
+	}
+	e := m.newAuditEntry(r, "term")
+	e.Node = m.nodeKeyByAddr(url)
+	e.Target = url
+	e.User = wsPrincipal(token).auditName()
+	// only the terminal of a connected node is opened
+	if len(e.Node) == 0 || !wsPrincipal(token).canTerm(e.Node) {
+		e.Status = http.StatusForbidden
+		audit(e)
+		httputil.Fail(w, "Forbidden", http.StatusForbidden)
 		return
 	}
+	e.Status = http.StatusOK
+	audit(e)
 	upgrader.CheckOrigin = func(r *http.Request) bool {
 		return true
 	}
@@ -542,6 +917,9 @@ func (m *Monitor) handleNodeTerm(w http.ResponseWriter, r *http.Request) {
 	}
 	header := http.Header{}
 	header.Add("manager-token", m.token)
+	for k, v := range m.shellSignature(http.MethodGet, url, nil) {
+		header.Set(k, v)
+	}
 	c, _, err := websocket.DefaultDialer.Dial(string(url), header)
 	if err != nil {
 		log.Errorf("node connection error: %s", err.Error())
@@ -590,33 +968,41 @@ func (m *Monitor) checkLogin(w http.ResponseWriter, r *http.Request) (result []b
 }
 
 func (m *Monitor) Login(w http.ResponseWriter, r *http.Request) (result []byte, err error, code int) {
-	sess, _ := globalSessions.SessionStart(w, r)
-	defer sess.SessionRelease(w)
 	pass := r.FormValue("pass")
 	if len(pass) < 4 || len(pass) > 20 {
 		result = []byte("false")
 		return
 	}
-	err = checkPass(pass)
-	if err != nil {
-		result = []byte("false")
-		return
-	}
-	err = sess.Set("user", sess.SessionID())
-	if err != nil {
-		return
-	}
-	err = sess.Set("pass", getBcrypt(sess.SessionID()))
-	if err != nil {
-		return
+	p := owner
+	if name := r.FormValue("user"); len(name) > 0 {
+		a, ok := findAccount(name)
+		if !ok || !matchPassword(a.Pass, pass) {
+			result = []byte("false")
+			return
+		}
+		p = a.principal()
+	} else {
+		err = checkPass(pass)
+		if err != nil {
+			result = []byte("false")
+			return
+		}
 	}
-	result = []byte("true")
+	result, err = m.loginSecondFactor(w, r, p)
 	return
 }
 func (m *Monitor) UpdatePass(w http.ResponseWriter, r *http.Request) (result []byte, err error, code int) {
 	if !verifyLogin(w, r, true) {
 		return
 	}
+	if !safeMethod(r.Method) && !verifyCSRF(w, r) {
+		httputil.Fail(w, "Invalid CSRF token", http.StatusForbidden)
+		return
+	}
+	if !isOwnerSession(w, r) {
+		httputil.Fail(w, "Forbidden", http.StatusForbidden)
+		return
+	}
 	oldPass := r.FormValue("oldPass")
 	newPass := r.FormValue("newPass")
 	// Check that the old password was provided. We dont care about its length, as long as its not zero.
@@ -638,7 +1024,9 @@ func (m *Monitor) UpdatePass(w http.ResponseWriter, r *http.Request) (result []b
 		result = []byte("The original password is wrong, please confirm and try again.")
 		return
 	}
-	err = WriteConfig(&User{Pass: getBcrypt(newPass)}, userPath)
+	u := *user
+	u.Pass = getBcrypt(newPass)
+	err = WriteConfig(&u, userPath)
 	if err != nil {
 		result = []byte("The server is busy. Please try again later.")
 		return
@@ -653,22 +1041,22 @@ func verifyWs(w http.ResponseWriter, r *http.Request, token string) bool {
 	defer sess.SessionRelease(w)
 	pass := sess.Get("user")
 	if pass == nil {
-		http.Error(w, "Unauthorized", http.StatusFound)
+		httputil.Fail(w, "Unauthorized", http.StatusFound)
 		return false
 	}
 	hash := sess.Get("pass")
 	if pass == nil {
-		http.Error(w, "Unauthorized", http.StatusFound)
+		httputil.Fail(w, "Unauthorized", http.StatusFound)
 		return false
 	}
 	hashStr, ok := hash.(string)
 	if !ok {
-		http.Error(w, "Unauthorized", http.StatusFound)
+		httputil.Fail(w, "Unauthorized", http.StatusFound)
 		return false
 	}
 	passStr, ok := pass.(string)
 	if !ok {
-		http.Error(w, "Unauthorized", http.StatusFound)
+		httputil.Fail(w, "Unauthorized", http.StatusFound)
 		return false
 	}
 	return matchPassword(hashStr, passStr)
@@ -680,35 +1068,91 @@ func verifyLogin(w http.ResponseWriter, r *http.Request, checkDefaultPass bool)
 	defer sess.SessionRelease(w)
 	pass := sess.Get("user")
 	if pass == nil {
-		http.Error(w, "Unauthorized", http.StatusFound)
+		httputil.Fail(w, "Unauthorized", http.StatusFound)
 		return false
 	}
 	hash := sess.Get("pass")
 	if hash == nil {
-		http.Error(w, "Unauthorized", http.StatusFound)
+		httputil.Fail(w, "Unauthorized", http.StatusFound)
 		return false
 	}
 	hashStr, ok := hash.(string)
 	if !ok {
-		http.Error(w, "Unauthorized", http.StatusFound)
+		httputil.Fail(w, "Unauthorized", http.StatusFound)
 		return false
 	}
 	passStr, ok := pass.(string)
 	if !ok {
-		http.Error(w, "Unauthorized", http.StatusFound)
+		httputil.Fail(w, "Unauthorized", http.StatusFound)
 		return false
 	}
 	if !matchPassword(hashStr, passStr) {
-		http.Error(w, "Unauthorized", http.StatusFound)
+		httputil.Fail(w, "Unauthorized", http.StatusFound)
+		return false
+	}
+	if sessionExpired(sess.Get("expire")) {
+		httputil.Fail(w, "Unauthorized", http.StatusFound)
 		return false
 	}
 	if !checkDefaultPass && userHasDefaultPass() {
-		http.Error(w, "Forced change password", http.StatusTemporaryRedirect)
+		httputil.Fail(w, "Forced change password", http.StatusTemporaryRedirect)
 		return false
 	}
 	return true
 }
 
+// getHandshakeStats returns the handshake metrics of the discovery, including the flood protection
+func (m *Monitor) getHandshakeStats(w http.ResponseWriter, r *http.Request) (result []byte, err error, code int) {
+	if !m.authorize(w, r, RoleViewer, "") {
+		return
+	}
+	result, err = json.Marshal(m.factory.GetHandshakeStats())
+	return
+}
+
+// getQueryStats returns the counters of the service queries served or rejected by the discovery
+func (m *Monitor) getQueryStats(w http.ResponseWriter, r *http.Request) (result []byte, err error, code int) {
+	if !m.authorize(w, r, RoleViewer, "") {
+		return
+	}
+	result, err = json.Marshal(m.factory.GetQueryStats())
+	return
+}
+
+// getSendStats returns the counters of the messages forwarded, dropped and the clients evicted
+// by the discovery
+func (m *Monitor) getSendStats(w http.ResponseWriter, r *http.Request) (result []byte, err error, code int) {
+	if !m.authorize(w, r, RoleViewer, "") {
+		return
+	}
+	result, err = json.Marshal(m.factory.GetSendStats())
+	return
+}
+
+// getFDStats returns the open files of the Manager against its limit
+func (m *Monitor) getFDStats(w http.ResponseWriter, r *http.Request) (result []byte, err error, code int) {
+	if !m.authorize(w, r, RoleViewer, "") {
+		return
+	}
+	result, err = json.Marshal(util.GetFDStats())
+	return
+}
+
+// getNodeDiag returns the diagnostics of a node, logs and goroutines included
+func (m *Monitor) getNodeDiag(w http.ResponseWriter, r *http.Request) (result []byte, err error, code int) {
+	key := r.FormValue("key")
+	if !m.authorize(w, r, RoleOperator, key) {
+		return
+	}
+	res, err := m.nodeRequest(key, "/node/getDiag", url.Values{})
+	if err != nil {
+		code = SERVER_ERROR
+		return
+	}
+	result = []byte(res)
+	return
+}
+
 func (m *Monitor) getServerInfo(w http.ResponseWriter, r *http.Request) (result []byte, err error, code int) {
 	sc := m.factory.GetDefaultSeedConfig()
 	if sc == nil {
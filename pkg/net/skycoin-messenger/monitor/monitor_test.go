@@ -0,0 +1,516 @@
+package monitor
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/skycoin/skycoin/src/cipher"
+	"github.com/skycoin/skywire/pkg/httputil"
+	"github.com/skycoin/skywire/pkg/net/skycoin-messenger/factory"
+	"github.com/skycoin/skywire/pkg/node"
+)
+
+const testPass = "owner-pass"
+
+type testMonitor struct {
+	*Monitor
+	srv *httptest.Server
+	dir string
+	// address the nodes connect to, empty until the first node
+	nodesAddr string
+	nodes     []*fakeNode
+}
+
+// newTestMonitor serves the routes of a monitor keeping its files in a temporary directory.
+// The owner logs in with testPass, the accounts of u with their plain Pass
+func newTestMonitor(t *testing.T, u *User) *testMonitor {
+	dir, err := ioutil.TempDir("", "monitor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []*string{&userPath, &tokenPath, &historyPath, &alertPath, &auditPath,
+		&clientPath, &pairedNodesPath, &peerListsPath} {
+		*p = filepath.Join(dir, filepath.Base(*p))
+	}
+	if u == nil {
+		u = &User{}
+	}
+	u.Pass = getBcrypt(testPass)
+	for i := range u.Accounts {
+		u.Accounts[i].Pass = getBcrypt(u.Accounts[i].Pass)
+	}
+	if err = WriteConfig(u, userPath); err != nil {
+		t.Fatal(err)
+	}
+	user = nil
+	m := New(factory.NewMessengerFactory(), "", "127.0.0.1:0", "test", "0.0.0")
+	return &testMonitor{Monitor: m, srv: httptest.NewServer(m.routes(dir)), dir: dir}
+}
+
+func (tm *testMonitor) close() {
+	for _, n := range tm.nodes {
+		n.close()
+	}
+	if len(tm.nodesAddr) > 0 {
+		tm.factory.Close()
+	}
+	tm.srv.Close()
+	os.RemoveAll(tm.dir)
+}
+
+func waitFor(t *testing.T, what string, ok func() bool) {
+	deadline := time.Now().Add(10 * time.Second)
+	for !ok() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// fakeNodeAPI answers the requests of the manager to the api of a node, from the auto start
+// config and the apps it keeps
+type fakeNodeAPI struct {
+	asc  node.AutoStartConfig
+	apps []node.NodeApp
+	// answered by /node/getInfo, /node/getTransports and /node/getDiag
+	version    string
+	transports []nodeTransport
+	// transports in a page of /node/getTransports, the limit asked for if 0
+	page int
+	// limits of the watchdog exceeded, no watchdog if nil
+	exceeded []string
+	// paths answered with 503
+	fail map[string]bool
+	// paths answered with 404, as by the nodes before them
+	missing map[string]bool
+	// paths requested, in order
+	calls []string
+	// the peer lists last pushed
+	peerLists factory.PeerLists
+	// app config files by app/path
+	files map[string]string
+	sync.Mutex
+}
+
+func newFakeNodeAPI() *fakeNodeAPI {
+	return &fakeNodeAPI{fail: make(map[string]bool), missing: make(map[string]bool), files: make(map[string]string)}
+}
+
+func (a *fakeNodeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	a.Lock()
+	defer a.Unlock()
+	a.calls = append(a.calls, r.URL.Path)
+	if a.fail[r.URL.Path] {
+		httputil.Fail(w, "node failure", http.StatusServiceUnavailable)
+		return
+	}
+	if a.missing[r.URL.Path] {
+		http.NotFound(w, r)
+		return
+	}
+	switch r.URL.Path {
+	case "/node/run/getAutoStartConfig":
+		json.NewEncoder(w).Encode(a.asc)
+		return
+	case "/node/getApps":
+		json.NewEncoder(w).Encode(a.apps)
+		return
+	case "/node/getInfo":
+		info := map[string]interface{}{"version": a.version, "transports": a.transports}
+		if a.exceeded != nil {
+			info["watchdog"] = map[string]interface{}{"exceeded": a.exceeded}
+		}
+		json.NewEncoder(w).Encode(info)
+		return
+	case "/node/getTransports":
+		// the cursor is the index of the next transport
+		start, _ := strconv.Atoi(r.FormValue("cursor"))
+		limit, _ := strconv.Atoi(r.FormValue("limit"))
+		if a.page > 0 {
+			limit = a.page
+		}
+		end := start + limit
+		if end >= len(a.transports) {
+			json.NewEncoder(w).Encode(map[string]interface{}{"transports": a.transports[start:]})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"transports": a.transports[start:end], "next": strconv.Itoa(end)})
+		return
+	case "/node/getProfile", "/node/getTrace":
+		w.Header().Set("Content-Disposition", `attachment; filename="`+path.Base(r.URL.Path)+`"`)
+		fmt.Fprintf(w, "%s %s", r.FormValue("type"), r.FormValue("seconds"))
+		return
+	case "/node/getDiag":
+		json.NewEncoder(w).Encode(map[string]interface{}{"version": a.version, "logs": []string{"diag of the node"}})
+		return
+	case "/node/run/setAutoStartConfig":
+		a.asc = node.AutoStartConfig{}
+		if err := json.Unmarshal([]byte(r.FormValue("data")), &a.asc); err != nil {
+			httputil.Fail(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	case "/node/run/sshs", "/node/run/sockss":
+		app := path.Base(r.URL.Path)
+		a.remove(app)
+		var allow []string
+		if v := r.FormValue("data"); len(v) > 0 {
+			allow = strings.Split(v, ",")
+		}
+		a.apps = append(a.apps, node.NodeApp{Key: cipher.PubKey{1}.Hex(), Attributes: []string{app}, AllowNodes: allow})
+	case "/node/run/closeApp":
+		a.remove(r.FormValue("key"))
+	case "/node/run/readAppFile":
+		data, ok := a.files[r.FormValue("app")+"/"+r.FormValue("path")]
+		if !ok {
+			httputil.Fail(w, "no such file", http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(data))
+		return
+	case "/node/run/writeAppFile":
+		a.files[r.FormValue("app")+"/"+r.FormValue("path")] = r.FormValue("data")
+	case "/node/run/setPeerLists":
+		a.peerLists = factory.PeerLists{}
+		if err := json.Unmarshal([]byte(r.FormValue("data")), &a.peerLists); err != nil {
+			httputil.Fail(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	w.Write([]byte("true"))
+}
+
+// remove the running app, with the mutex locked
+func (a *fakeNodeAPI) remove(app string) {
+	for i, v := range a.apps {
+		if containsString(v.Attributes, app) {
+			a.apps = append(a.apps[:i], a.apps[i+1:]...)
+			return
+		}
+	}
+}
+
+// state returns the auto start config and the apps of the node
+func (a *fakeNodeAPI) state() (asc node.AutoStartConfig, apps []node.NodeApp) {
+	a.Lock()
+	defer a.Unlock()
+	return a.asc, append([]node.NodeApp(nil), a.apps...)
+}
+
+// setState replaces the auto start config and the apps of the node
+func (a *fakeNodeAPI) setState(asc node.AutoStartConfig, apps []node.NodeApp) {
+	a.Lock()
+	a.asc, a.apps = asc, apps
+	a.Unlock()
+}
+
+// setTransports replaces the version and the transports the node reports
+func (a *fakeNodeAPI) setTransports(version string, transports ...nodeTransport) {
+	a.Lock()
+	a.version, a.transports = version, transports
+	a.Unlock()
+}
+
+func (a *fakeNodeAPI) setExceeded(limits ...string) {
+	a.Lock()
+	a.exceeded = limits
+	a.Unlock()
+}
+
+// setPage sets the transports in a page of /node/getTransports, the node answers 404 to it
+// as before the pages if missing
+func (a *fakeNodeAPI) setPage(page int, missing bool) {
+	a.Lock()
+	a.page = page
+	a.missing["/node/getTransports"] = missing
+	a.Unlock()
+}
+
+// failPath makes the node answer 503 to the requests of the path
+func (a *fakeNodeAPI) failPath(path string, fail bool) {
+	a.Lock()
+	a.fail[path] = fail
+	a.Unlock()
+}
+
+func (a *fakeNodeAPI) getPeerLists() factory.PeerLists {
+	a.Lock()
+	defer a.Unlock()
+	return a.peerLists
+}
+
+// file returns the app config file written to the node
+func (a *fakeNodeAPI) file(app, path string) string {
+	a.Lock()
+	defer a.Unlock()
+	return a.files[app+"/"+path]
+}
+
+// requested returns the paths requested since the last call
+func (a *fakeNodeAPI) requested() (calls []string) {
+	a.Lock()
+	calls, a.calls = a.calls, nil
+	a.Unlock()
+	return
+}
+
+// fakeNode is a node connected to the monitor, with its api served by a fakeNodeAPI
+type fakeNode struct {
+	key string
+	sc  *factory.SeedConfig
+	*fakeNodeAPI
+	srv *httptest.Server
+	f   *factory.MessengerFactory
+}
+
+func (n *fakeNode) close() {
+	n.f.Close()
+	n.srv.Close()
+}
+
+// connectNode connects a new node to the monitor and waits for the monitor to know its api
+func (tm *testMonitor) connectNode(t *testing.T) *fakeNode {
+	if len(tm.nodesAddr) == 0 {
+		if err := tm.factory.SetDefaultSeedConfig(factory.NewSeedConfig()); err != nil {
+			t.Fatal(err)
+		}
+		ln, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = tm.factory.ListenOn(ln); err != nil {
+			t.Fatal(err)
+		}
+		tm.nodesAddr = ln.Addr().String()
+	}
+	api := newFakeNodeAPI()
+	n := &fakeNode{fakeNodeAPI: api, srv: httptest.NewServer(api), f: factory.NewMessengerFactory()}
+	tm.nodes = append(tm.nodes, n)
+	sc := factory.NewSeedConfig()
+	n.key, n.sc = sc.PublicKey, sc
+	err := n.f.ConnectWithConfig(tm.nodesAddr, &factory.ConnConfig{
+		SeedConfig: sc,
+		Context:    map[string]string{"node-api": n.srv.Listener.Addr().String()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the node "+n.key, func() bool {
+		addr, err := tm.nodeAPIAddrByKey(n.key)
+		return err == nil && addr == n.srv.Listener.Addr().String()
+	})
+	return n
+}
+
+// client is a browser session of the monitor
+type client struct {
+	t    *testing.T
+	url  string
+	http *http.Client
+	// api token sent instead of the session cookie, if not empty
+	token string
+}
+
+// anonymous returns a browser that did not log in
+func (tm *testMonitor) anonymous(t *testing.T) *client {
+	jar, _ := cookiejar.New(nil)
+	return &client{t: t, url: tm.srv.URL, http: &http.Client{
+		Jar: jar,
+		// the api answers 302 to requests without a session
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}}
+}
+
+// login starts the session of the account (name), the owner if empty
+func (tm *testMonitor) login(t *testing.T, name, pass string) *client {
+	c := tm.anonymous(t)
+	status, body := c.forge(http.MethodPost, "/login", url.Values{"user": {name}, "pass": {pass}})
+	if status != http.StatusOK || body != "true" {
+		t.Fatalf("login %q: %d %s", name, status, body)
+	}
+	return c
+}
+
+// bearer returns a client of the api token (token)
+func (tm *testMonitor) bearer(t *testing.T, token string) *client {
+	c := tm.anonymous(t)
+	c.token = token
+	return c
+}
+
+// createToken returns a new api token of the role
+func (c *client) createToken(role Role, groups string) string {
+	status, body := c.send(http.MethodPost, "/auth/createToken", url.Values{"role": {string(role)}, "groups": {groups}})
+	if status != http.StatusOK {
+		c.t.Fatalf("create token: %d %s", status, body)
+	}
+	var created struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal([]byte(body), &created); err != nil {
+		c.t.Fatal(err)
+	}
+	return created.Token
+}
+
+// send makes a request of the pages of the monitor, with the CSRF header
+func (c *client) send(method, path string, form url.Values) (status int, body string) {
+	return c.do(method, path, form, true)
+}
+
+// forge makes a request of another site, which carries the session cookie but cannot
+// read the CSRF cookie
+func (c *client) forge(method, path string, form url.Values) (status int, body string) {
+	return c.do(method, path, form, false)
+}
+
+func (c *client) do(method, path string, form url.Values, csrf bool) (status int, body string) {
+	var req *http.Request
+	var err error
+	if method == http.MethodGet || method == http.MethodHead {
+		req, err = http.NewRequest(method, c.url+path+"?"+form.Encode(), nil)
+	} else {
+		req, err = http.NewRequest(method, c.url+path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if len(c.token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if csrf {
+		u, _ := url.Parse(c.url)
+		for _, cookie := range c.http.Jar.Cookies(u) {
+			if cookie.Name == csrfCookieName {
+				req.Header.Set(csrfHeaderName, cookie.Value)
+			}
+		}
+	}
+	res, err := c.http.Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer res.Body.Close()
+	b, _ := ioutil.ReadAll(res.Body)
+	return res.StatusCode, string(b)
+}
+
+func TestCrossSiteRequests(t *testing.T) {
+	tm := newTestMonitor(t, nil)
+	defer tm.close()
+	c := tm.login(t, "", testPass)
+	form := url.Values{"role": {"admin"}, "label": {"forged"}}
+
+	// a link or an image of another site
+	status, _ := c.forge(http.MethodGet, "/auth/createToken", form)
+	if status != http.StatusMethodNotAllowed {
+		t.Fatalf("cross-site GET: got %d, want %d", status, http.StatusMethodNotAllowed)
+	}
+	for _, method := range []string{http.MethodPut, http.MethodDelete} {
+		if status, _ := c.send(method, "/auth/createToken", form); status != http.StatusMethodNotAllowed {
+			t.Fatalf("%s: got %d, want %d", method, status, http.StatusMethodNotAllowed)
+		}
+	}
+	// a form of another site
+	if status, _ := c.forge(http.MethodPost, "/auth/createToken", form); status != http.StatusForbidden {
+		t.Fatalf("cross-site POST: got %d, want %d", status, http.StatusForbidden)
+	}
+	if tokens := tm.tokens.list(); len(tokens) != 0 {
+		t.Fatalf("tokens created by another site %v", tokens)
+	}
+
+	// the routes that only read need no CSRF header, any other method does
+	if status, _ := c.forge(http.MethodGet, "/auth/listTokens", nil); status != http.StatusOK {
+		t.Fatalf("GET: got %d", status)
+	}
+	if status, _ := c.forge(http.MethodDelete, "/auth/listTokens", nil); status != http.StatusForbidden {
+		t.Fatalf("cross-site DELETE: got %d, want %d", status, http.StatusForbidden)
+	}
+	if status, _ := c.forge(http.MethodPost, "/updatePass", url.Values{"oldPass": {testPass}, "newPass": {"forged"}}); status != http.StatusForbidden {
+		t.Fatalf("cross-site password change: got %d, want %d", status, http.StatusForbidden)
+	}
+
+	if status, body := c.send(http.MethodPost, "/auth/createToken", form); status != http.StatusOK {
+		t.Fatalf("POST of the pages: %d %s", status, body)
+	}
+	if tokens := tm.tokens.list(); len(tokens) != 1 {
+		t.Fatalf("tokens %v", tokens)
+	}
+}
+
+// session returns the id of the session in the cookie of the browser
+func (c *client) session() string {
+	u, _ := url.Parse(c.url)
+	for _, cookie := range c.http.Jar.Cookies(u) {
+		if cookie.Name == sessionCookieName {
+			return cookie.Value
+		}
+	}
+	return ""
+}
+
+// fixate gives the browser the session (sid), as another site could
+func (c *client) fixate(sid string) {
+	u, _ := url.Parse(c.url)
+	c.http.Jar.SetCookies(u, []*http.Cookie{{Name: sessionCookieName, Value: sid, Path: "/"}})
+}
+
+func TestSessionFixation(t *testing.T) {
+	pk, sk := cipher.GenerateKeyPair()
+	tm := newTestMonitor(t, &User{Keys: []string{pk.Hex()}})
+	defer tm.close()
+
+	for _, login := range []struct {
+		name  string
+		login func(c *client) string
+	}{
+		{"password", func(c *client) string {
+			_, body := c.forge(http.MethodPost, "/login", url.Values{"pass": {testPass}})
+			return body
+		}},
+		{"key", func(c *client) string {
+			_, nonce := c.forge(http.MethodGet, "/auth/challenge", nil)
+			sig := cipher.SignHash(cipher.SumSHA256([]byte(nonce)), sk)
+			_, body := c.forge(http.MethodPost, "/auth/keyLogin", url.Values{"key": {pk.Hex()}, "nonce": {nonce}, "sig": {sig.Hex()}})
+			return body
+		}},
+	} {
+		// a session of the manager the attacker started and planted in the browser of the user
+		attacker := tm.anonymous(t)
+		attacker.forge(http.MethodPost, "/checkLogin", nil)
+		sid := attacker.session()
+		if len(sid) == 0 {
+			t.Fatalf("%s: no session started", login.name)
+		}
+		c := tm.anonymous(t)
+		c.fixate(sid)
+		if body := login.login(c); body != "true" {
+			t.Fatalf("%s login: %s", login.name, body)
+		}
+		if c.session() == sid {
+			t.Fatalf("%s login kept the session %s", login.name, sid)
+		}
+		if status, body := c.send(http.MethodGet, "/auth/listTokens", nil); status != http.StatusOK {
+			t.Fatalf("%s login: session refused %d %s", login.name, status, body)
+		}
+		if status, _ := attacker.send(http.MethodGet, "/auth/listTokens", nil); status == http.StatusOK {
+			t.Fatalf("%s login: the planted session logged in", login.name)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package monitor
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/skycoin/skycoin/src/cipher"
+	"github.com/skycoin/skycoin/src/util/file"
+	"github.com/skycoin/skywire/pkg/httputil"
+	"github.com/skycoin/skywire/pkg/net/skycoin-messenger/factory"
+	"github.com/skycoin/skywire/pkg/net/util"
+)
+
+var pairedNodesPath = filepath.Join(file.UserHome(), ".skywire", "manager", "pairedNodes.json")
+
+// PairedNode is a node that proved it showed the pairing code entered in the manager
+type PairedNode struct {
+	Key  string `json:"key"`
+	Time int64  `json:"time"`
+	User string `json:"user,omitempty"`
+}
+
+type pairedNodes struct {
+	path  string
+	nodes map[string]PairedNode
+	sync.RWMutex
+}
+
+func newPairedNodes(path string) *pairedNodes {
+	p := &pairedNodes{path: path, nodes: make(map[string]PairedNode)}
+	fb, err := ioutil.ReadFile(path)
+	if err == nil {
+		err = json.Unmarshal(fb, &p.nodes)
+		if err != nil {
+			log.Errorf("read paired nodes err: %v", err)
+		}
+	}
+	return p
+}
+
+func (p *pairedNodes) paired(key string) bool {
+	p.RLock()
+	defer p.RUnlock()
+	_, ok := p.nodes[key]
+	return ok
+}
+
+func (p *pairedNodes) list() []PairedNode {
+	p.RLock()
+	defer p.RUnlock()
+	l := make([]PairedNode, 0, len(p.nodes))
+	for _, n := range p.nodes {
+		l = append(l, n)
+	}
+	sort.Slice(l, func(i, j int) bool {
+		return l[i].Time < l[j].Time
+	})
+	return l
+}
+
+func (p *pairedNodes) add(n PairedNode) (err error) {
+	p.Lock()
+	defer p.Unlock()
+	nodes := make(map[string]PairedNode, len(p.nodes)+1)
+	for k, v := range p.nodes {
+		nodes[k] = v
+	}
+	nodes[n.Key] = n
+	d, err := json.Marshal(nodes)
+	if err != nil {
+		return
+	}
+	err = os.MkdirAll(filepath.Dir(p.path), 0700)
+	if err != nil {
+		return
+	}
+	err = ioutil.WriteFile(p.path, d, 0600)
+	if err != nil {
+		return
+	}
+	p.nodes = nodes
+	return
+}
+
+// pairNode offers the code to the connected nodes that are not paired yet, the node showing
+// the code trusts the key of the manager and proves its own key by signing the code
+func (m *Monitor) pairNode(w http.ResponseWriter, r *http.Request) (result []byte, err error, code int) {
+	p, ok := m.requestPrincipal(w, r)
+	if !ok {
+		return
+	}
+	// the node is in no group yet
+	if !p.Role.allows(RoleAdmin) || len(p.Groups) > 0 {
+		httputil.Fail(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if r.Method != "POST" {
+		code = BAD_REQUEST
+		err = errors.New("please use post method")
+		return
+	}
+	pairingCode := util.NormalizePairingCode(r.FormValue("code"))
+	if len(pairingCode) != 9 {
+		code = BAD_REQUEST
+		err = errors.New("a pairing code has 8 characters")
+		return
+	}
+	sc := m.factory.GetDefaultSeedConfig()
+	if sc == nil {
+		err = errors.New("the manager has no keys")
+		return
+	}
+	sk, err := cipher.SecKeyFromHex(sc.SecKey)
+	if err != nil {
+		return
+	}
+	defer util.WipeSecKey(&sk)
+
+	var keys []cipher.PubKey
+	m.factory.ForEachAcceptedConnection(func(key cipher.PubKey, conn *factory.Connection) {
+		if !m.pairedNodes.paired(key.Hex()) {
+			keys = append(keys, key)
+		}
+	})
+	for _, key := range keys {
+		res, e := m.nodeRequest(key.Hex(), "/node/pair", url.Values{
+			"code":    {pairingCode},
+			"manager": {sc.PublicKey},
+			"sig":     {util.SignPairing(sk, util.PairingManager, pairingCode, key.Hex()).Hex()},
+		})
+		if e != nil {
+			// the node shows another code, or none
+			continue
+		}
+		var resp struct {
+			Node string `json:"node"`
+			Sig  string `json:"sig"`
+		}
+		if e = json.Unmarshal([]byte(res), &resp); e != nil || resp.Node != key.Hex() {
+			log.Errorf("pairing: invalid answer of node %s: %s", key.Hex(), res)
+			continue
+		}
+		sig, e := cipher.SigFromHex(resp.Sig)
+		if e == nil {
+			e = util.VerifyPairing(key, sig, util.PairingNode, pairingCode, sc.PublicKey)
+		}
+		if e != nil {
+			log.Errorf("pairing: node %s did not sign the code: %v", key.Hex(), e)
+			continue
+		}
+		n := PairedNode{Key: key.Hex(), Time: time.Now().Unix(), User: p.Name}
+		err = m.pairedNodes.add(n)
+		if err != nil {
+			return
+		}
+		result, err = json.Marshal(n)
+		return
+	}
+	code = NOT_FOUND
+	err = errors.New("no connected node shows the code, check it and that it did not expire")
+	return
+}
+
+func (m *Monitor) getPairedNodes(w http.ResponseWriter, r *http.Request) (result []byte, err error, code int) {
+	if !m.authorize(w, r, RoleViewer, "") {
+		return
+	}
+	result, err = json.Marshal(m.pairedNodes.list())
+	return
+}
@@ -0,0 +1,351 @@
+package monitor
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/skycoin/skycoin/src/cipher"
+	"github.com/skycoin/skycoin/src/util/file"
+	"github.com/skycoin/skywire/pkg/httputil"
+	"github.com/skycoin/skywire/pkg/net/skycoin-messenger/factory"
+)
+
+var peerListsPath = filepath.Join(file.UserHome(), ".skywire", "manager", "peerLists.json")
+
+const (
+	// the connected nodes behind the current version are pushed the lists this often
+	peerListsSyncInterval = time.Minute
+	// versions kept for a rollback
+	peerListsKeep = 100
+)
+
+// PeerListsVersion is a version of the node allow and deny lists maintained by the manager
+type PeerListsVersion struct {
+	factory.PeerLists
+	Time int64  `json:"time"`
+	User string `json:"user,omitempty"`
+	// version the lists were copied from by a rollback
+	RolledBackFrom int64 `json:"rolled_back_from,omitempty"`
+}
+
+// PeerListsStatus is the last push of the lists to a node
+type PeerListsStatus struct {
+	Version int64  `json:"version"`
+	Time    int64  `json:"time"`
+	Error   string `json:"error,omitempty"`
+
+	// connect time of the node the lists were pushed for, they are pushed again after it reconnects
+	connected int64
+}
+
+type peerLists struct {
+	path string
+	// oldest first
+	versions []PeerListsVersion
+	// by node key
+	nodes map[string]*PeerListsStatus
+	sync.RWMutex
+
+	// one push of the lists to the nodes at a time
+	syncMutex sync.Mutex
+}
+
+func newPeerLists(path string) *peerLists {
+	p := &peerLists{path: path, nodes: make(map[string]*PeerListsStatus)}
+	fb, err := ioutil.ReadFile(path)
+	if err == nil {
+		err = json.Unmarshal(fb, &p.versions)
+		if err != nil {
+			log.Errorf("read peer lists err: %v", err)
+		}
+	}
+	return p
+}
+
+func (p *peerLists) current() (v PeerListsVersion, ok bool) {
+	p.RLock()
+	defer p.RUnlock()
+	if len(p.versions) == 0 {
+		return
+	}
+	return p.versions[len(p.versions)-1], true
+}
+
+func (p *peerLists) get(version int64) (v PeerListsVersion, ok bool) {
+	p.RLock()
+	defer p.RUnlock()
+	for _, v = range p.versions {
+		if v.Version == version {
+			return v, true
+		}
+	}
+	return PeerListsVersion{}, false
+}
+
+func (p *peerLists) list() []PeerListsVersion {
+	p.RLock()
+	defer p.RUnlock()
+	return append([]PeerListsVersion{}, p.versions...)
+}
+
+// add saves the lists as the next version
+func (p *peerLists) add(l factory.PeerLists, user string, rolledBackFrom int64) (v PeerListsVersion, err error) {
+	err = l.Check()
+	if err != nil {
+		return
+	}
+	p.Lock()
+	defer p.Unlock()
+	l.Version = 1
+	if len(p.versions) > 0 {
+		l.Version = p.versions[len(p.versions)-1].Version + 1
+	}
+	v = PeerListsVersion{PeerLists: l, Time: time.Now().Unix(), User: user, RolledBackFrom: rolledBackFrom}
+	versions := append(p.versions, v)
+	if len(versions) > peerListsKeep {
+		versions = versions[len(versions)-peerListsKeep:]
+	}
+	d, err := json.Marshal(versions)
+	if err != nil {
+		return
+	}
+	err = os.MkdirAll(filepath.Dir(p.path), 0700)
+	if err != nil {
+		return
+	}
+	err = ioutil.WriteFile(p.path, d, 0600)
+	if err != nil {
+		return
+	}
+	p.versions = versions
+	return
+}
+
+func (p *peerLists) status(key string) (s PeerListsStatus, ok bool) {
+	p.RLock()
+	defer p.RUnlock()
+	st, ok := p.nodes[key]
+	if ok {
+		s = *st
+	}
+	return
+}
+
+func (p *peerLists) setStatus(key string, s PeerListsStatus) {
+	p.Lock()
+	p.nodes[key] = &s
+	p.Unlock()
+}
+
+func (m *Monitor) syncPeerListsLoop() {
+	ticker := time.NewTicker(peerListsSyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.closed:
+			return
+		case <-ticker.C:
+		}
+		m.syncPeerLists()
+	}
+}
+
+// syncPeerLists pushes the current lists to the connected nodes that did not apply them yet
+func (m *Monitor) syncPeerLists() {
+	m.peerLists.syncMutex.Lock()
+	defer m.peerLists.syncMutex.Unlock()
+	v, ok := m.peerLists.current()
+	if !ok {
+		return
+	}
+	data, err := json.Marshal(v.PeerLists)
+	if err != nil {
+		log.Errorf("peer lists: %v", err)
+		return
+	}
+	type target struct {
+		key       string
+		connected int64
+	}
+	var targets []target
+	m.factory.ForEachAcceptedConnection(func(key cipher.PubKey, conn *factory.Connection) {
+		k := key.Hex()
+		s, ok := m.peerLists.status(k)
+		if ok && s.Version == v.Version && len(s.Error) == 0 && s.connected == conn.GetConnectTime() {
+			return
+		}
+		targets = append(targets, target{key: k, connected: conn.GetConnectTime()})
+	})
+	sem := make(chan struct{}, bulkParallel)
+	var wg sync.WaitGroup
+	for _, t := range targets {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(t target) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			s := PeerListsStatus{Version: v.Version, Time: time.Now().Unix(), connected: t.connected}
+			_, err := m.nodeRequest(t.key, "/node/run/setPeerLists", url.Values{"data": {string(data)}})
+			if err != nil {
+				log.Debugf("peer lists version %d to %s: %v", v.Version, t.key, err)
+				s.Error = err.Error()
+			}
+			m.peerLists.setStatus(t.key, s)
+		}(t)
+	}
+	wg.Wait()
+}
+
+// the lists apply to all nodes, so only an admin of all groups may change them
+func (m *Monitor) authorizePeerLists(w http.ResponseWriter, r *http.Request) (p *principal, ok bool) {
+	p, ok = m.requestPrincipal(w, r)
+	if !ok {
+		return
+	}
+	if !p.Role.allows(RoleAdmin) || len(p.Groups) > 0 {
+		httputil.Fail(w, "Forbidden", http.StatusForbidden)
+		return nil, false
+	}
+	return
+}
+
+func oldPeerLists(m *Monitor, r *http.Request) string {
+	v, ok := m.peerLists.current()
+	if !ok {
+		return ""
+	}
+	d, _ := json.Marshal(v.PeerLists)
+	return string(d)
+}
+
+// getPeerLists returns the current version, or the version of the form value version
+func (m *Monitor) getPeerLists(w http.ResponseWriter, r *http.Request) (result []byte, err error, code int) {
+	if !m.authorize(w, r, RoleViewer, "") {
+		return
+	}
+	var v PeerListsVersion
+	if s := r.FormValue("version"); len(s) > 0 {
+		var version int64
+		version, err = strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			code = BAD_REQUEST
+			return
+		}
+		var ok bool
+		v, ok = m.peerLists.get(version)
+		if !ok {
+			code = NOT_FOUND
+			err = errors.New("version not found")
+			return
+		}
+	} else {
+		v, _ = m.peerLists.current()
+	}
+	result, err = json.Marshal(v)
+	return
+}
+
+// getPeerListsHistory returns the kept versions without their lists, oldest first
+func (m *Monitor) getPeerListsHistory(w http.ResponseWriter, r *http.Request) (result []byte, err error, code int) {
+	if !m.authorize(w, r, RoleViewer, "") {
+		return
+	}
+	versions := m.peerLists.list()
+	for i := range versions {
+		versions[i].Allow, versions[i].Deny = nil, nil
+	}
+	result, err = json.Marshal(versions)
+	return
+}
+
+// getPeerListsStatus returns the last push of the lists to each node the user may access
+func (m *Monitor) getPeerListsStatus(w http.ResponseWriter, r *http.Request) (result []byte, err error, code int) {
+	p, ok := m.requestPrincipal(w, r)
+	if !ok {
+		return
+	}
+	status := make(map[string]PeerListsStatus)
+	m.peerLists.RLock()
+	for k, s := range m.peerLists.nodes {
+		if p.canAccess(k) {
+			status[k] = *s
+		}
+	}
+	m.peerLists.RUnlock()
+	result, err = json.Marshal(status)
+	return
+}
+
+// setPeerLists saves the lists of the form value data as a new version and pushes it to the nodes
+func (m *Monitor) setPeerLists(w http.ResponseWriter, r *http.Request) (result []byte, err error, code int) {
+	p, ok := m.authorizePeerLists(w, r)
+	if !ok {
+		return
+	}
+	if r.Method != "POST" {
+		code = BAD_REQUEST
+		err = errors.New("please use post method")
+		return
+	}
+	var l factory.PeerLists
+	err = json.Unmarshal([]byte(r.FormValue("data")), &l)
+	if err != nil {
+		code = BAD_REQUEST
+		return
+	}
+	err = l.Check()
+	if err != nil {
+		code = BAD_REQUEST
+		return
+	}
+	v, err := m.peerLists.add(l, p.Name, 0)
+	if err != nil {
+		return
+	}
+	go m.syncPeerLists()
+	result, err = json.Marshal(v)
+	return
+}
+
+// rollbackPeerLists saves the lists of the form value version as a new version, so the nodes
+// that applied a later version accept them too
+func (m *Monitor) rollbackPeerLists(w http.ResponseWriter, r *http.Request) (result []byte, err error, code int) {
+	p, ok := m.authorizePeerLists(w, r)
+	if !ok {
+		return
+	}
+	if r.Method != "POST" {
+		code = BAD_REQUEST
+		err = errors.New("please use post method")
+		return
+	}
+	version, err := strconv.ParseInt(r.FormValue("version"), 10, 64)
+	if err != nil {
+		code = BAD_REQUEST
+		return
+	}
+	old, ok := m.peerLists.get(version)
+	if !ok {
+		code = NOT_FOUND
+		err = errors.New("version not found")
+		return
+	}
+	v, err := m.peerLists.add(old.PeerLists, p.Name, version)
+	if err != nil {
+		return
+	}
+	go m.syncPeerLists()
+	result, err = json.Marshal(v)
+	return
+}
@@ -0,0 +1,71 @@
+package monitor
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+func TestPeerListsRollback(t *testing.T) {
+	tm := newTestMonitor(t, nil)
+	defer tm.close()
+	n := tm.connectNode(t)
+	owner := tm.login(t, "", testPass)
+	denied := cipher.PubKey([33]byte{0x02, 0x05}).Hex()
+	post := func(path string, form url.Values) (v PeerListsVersion) {
+		status, body := owner.send(http.MethodPost, path, form)
+		if status != http.StatusOK {
+			t.Fatalf("%s %v: %d %s", path, form, status, body)
+		}
+		if err := json.Unmarshal([]byte(body), &v); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	pushed := func(version int64) {
+		waitFor(t, "the lists pushed to the node", func() bool {
+			return n.getPeerLists().Version == version
+		})
+	}
+
+	if v := post("/peers/set", url.Values{"data": {`{}`}}); v.Version != 1 {
+		t.Fatalf("first version %+v", v)
+	}
+	pushed(1)
+	if v := post("/peers/set", url.Values{"data": {`{"deny":["` + denied + `"]}`}}); v.Version != 2 {
+		t.Fatalf("deny version %+v", v)
+	}
+	pushed(2)
+	if l := n.getPeerLists(); len(l.Deny) != 1 || l.Deny[0] != denied {
+		t.Fatalf("denied on the node %+v", l)
+	}
+
+	// the rollback is a new version with the old lists, so the nodes at version 2 take it
+	v := post("/peers/rollback", url.Values{"version": {"1"}})
+	if v.Version != 3 || v.RolledBackFrom != 1 || len(v.Deny) != 0 {
+		t.Fatalf("rollback %+v", v)
+	}
+	pushed(3)
+	if l := n.getPeerLists(); len(l.Deny) != 0 {
+		t.Fatalf("denied on the node after the rollback %+v", l)
+	}
+	// the status follows the answer of the node
+	var body string
+	waitFor(t, "the status of the push", func() bool {
+		var status map[string]PeerListsStatus
+		_, body = owner.send(http.MethodGet, "/peers/getStatus", nil)
+		return json.Unmarshal([]byte(body), &status) == nil && status[n.key].Version == 3 && len(status[n.key].Error) == 0
+	})
+	var history []PeerListsVersion
+	_, body = owner.send(http.MethodGet, "/peers/getHistory", nil)
+	if err := json.Unmarshal([]byte(body), &history); err != nil || len(history) != 3 || history[2].RolledBackFrom != 1 {
+		t.Fatalf("history %s", body)
+	}
+
+	if status, body := owner.send(http.MethodPost, "/peers/rollback", url.Values{"version": {"9"}}); status != http.StatusNotFound {
+		t.Fatalf("rollback to an unknown version %d %s", status, body)
+	}
+}
@@ -0,0 +1,54 @@
+package monitor
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/skycoin/skywire/pkg/httputil"
+)
+
+// longest capture a node accepts, see /node/getProfile
+const maxProfileSeconds = 300
+
+// getNodeProfile streams a pprof profile or an execution trace (type=trace) of a node
+func (m *Monitor) getNodeProfile(w http.ResponseWriter, r *http.Request) {
+	key := r.FormValue("key")
+	if !m.authorize(w, r, RoleOperator, key) {
+		return
+	}
+	seconds, err := strconv.Atoi(r.FormValue("seconds"))
+	if err != nil || seconds < 1 || seconds > maxProfileSeconds {
+		seconds = 30
+	}
+	addr, err := m.nodeAPIAddrByKey(key)
+	if err != nil {
+		httputil.Fail(w, err.Error(), NOT_FOUND)
+		return
+	}
+	path := "/node/getProfile"
+	if r.FormValue("type") == "trace" {
+		path = "/node/getTrace"
+	}
+	values := url.Values{
+		"token":   {m.token},
+		"type":    {r.FormValue("type")},
+		"seconds": {strconv.Itoa(seconds)},
+	}
+	client := &http.Client{Timeout: time.Duration(seconds)*time.Second + bulkNodeTimeout}
+	res, err := client.PostForm("http://"+addr+path, values)
+	if err != nil {
+		httputil.Fail(w, err.Error(), SERVER_ERROR)
+		return
+	}
+	defer res.Body.Close()
+	for _, h := range []string{"Content-Type", "Content-Disposition"} {
+		if v := res.Header.Get(h); len(v) > 0 {
+			w.Header().Set(h, v)
+		}
+	}
+	w.WriteHeader(res.StatusCode)
+	io.Copy(w, res.Body)
+}
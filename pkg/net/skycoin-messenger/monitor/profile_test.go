@@ -0,0 +1,51 @@
+package monitor
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+func TestNodeProfile(t *testing.T) {
+	tm := newTestMonitor(t, &User{Accounts: append([]Account(nil), testAccounts...)})
+	defer tm.close()
+	n := tm.connectNode(t)
+	operator := tm.login(t, "operator", "operator-pass")
+
+	for _, c := range []struct {
+		form url.Values
+		// the path requested of the node and the type and seconds it got
+		path, want string
+	}{
+		{url.Values{"type": {"heap"}}, "/node/getProfile", "heap 30"},
+		{url.Values{"type": {"cpu"}, "seconds": {"5"}}, "/node/getProfile", "cpu 5"},
+		{url.Values{"type": {"trace"}, "seconds": {"10"}}, "/node/getTrace", "trace 10"},
+		// a duration the node refuses is replaced by the default one
+		{url.Values{"seconds": {"301"}}, "/node/getProfile", " 30"},
+	} {
+		c.form.Set("key", n.key)
+		status, body := operator.send(http.MethodGet, "/conn/getNodeProfile", c.form)
+		if status != http.StatusOK || body != c.want {
+			t.Errorf("%v: %d %q", c.form, status, body)
+		}
+		if calls := n.requested(); len(calls) != 1 || calls[0] != c.path {
+			t.Errorf("%v: requested %v", c.form, calls)
+		}
+	}
+
+	// the node failing the capture fails the request
+	n.failPath("/node/getProfile", true)
+	if status, _ := operator.send(http.MethodGet, "/conn/getNodeProfile", url.Values{"key": {n.key}}); status != http.StatusServiceUnavailable {
+		t.Fatalf("failed capture: got %d", status)
+	}
+	viewer := tm.login(t, "viewer", "viewer-pass")
+	if status, body := viewer.send(http.MethodGet, "/conn/getNodeProfile", url.Values{"key": {n.key}}); status != http.StatusForbidden {
+		t.Fatalf("viewer: %d %s", status, body)
+	}
+	offline := cipher.PubKey([33]byte{0x02, 0x09}).Hex()
+	if status, _ := operator.send(http.MethodGet, "/conn/getNodeProfile", url.Values{"key": {offline}}); status != http.StatusNotFound {
+		t.Fatalf("offline node: got %d", status)
+	}
+}
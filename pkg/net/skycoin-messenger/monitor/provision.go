@@ -0,0 +1,321 @@
+package monitor
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/skycoin/skywire/pkg/node"
+)
+
+// NodeState is the desired state of a node for declarative provisioning
+type NodeState struct {
+	// server apps (sshs, sockss) that run and start with the node
+	Apps map[string]bool `json:"apps"`
+	// keys of the nodes each server app allows, sshs runs only with some
+	AllowNodes map[string][]string `json:"allow_nodes,omitempty"`
+	// client apps (sshc, socksc) and the remote app they connect to, absent to disable
+	Transports map[string]*TransportState `json:"transports"`
+	// discovery addresses handed out by the manager
+	DiscoveryAddresses []string `json:"discovery_addresses"`
+}
+
+type TransportState struct {
+	NodeKey   string `json:"node_key"`
+	AppKey    string `json:"app_key"`
+	Discovery string `json:"discovery"`
+}
+
+var (
+	provisionApps       = []string{"sshs", "sockss"}
+	provisionTransports = []string{"sshc", "socksc"}
+)
+
+func (s *NodeState) validate() error {
+	for name := range s.Apps {
+		if !containsString(provisionApps, name) {
+			return fmt.Errorf("unknown app %s", name)
+		}
+	}
+	for name, keys := range s.AllowNodes {
+		if !containsString(provisionApps, name) {
+			return fmt.Errorf("unknown app %s", name)
+		}
+		for _, k := range keys {
+			if _, err := pubKeyFromHex(k); err != nil {
+				return fmt.Errorf("allow_nodes %s: %v", name, err)
+			}
+		}
+	}
+	// sshs started without the nodes it allows lets any node in
+	if s.Apps["sshs"] && len(s.AllowNodes["sshs"]) == 0 {
+		return errors.New("sshs must allow some nodes in allow_nodes")
+	}
+	for name, t := range s.Transports {
+		if !containsString(provisionTransports, name) {
+			return fmt.Errorf("unknown transport %s", name)
+		}
+		if t == nil {
+			continue
+		}
+		if len(t.NodeKey) != 66 || len(t.AppKey) != 66 {
+			return fmt.Errorf("transport %s: node and app key must be 66 characters", name)
+		}
+	}
+	return nil
+}
+
+type StateChange struct {
+	Field string `json:"field"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Error string `json:"error,omitempty"`
+}
+
+type ProvisionResult struct {
+	Node    string        `json:"node"`
+	DryRun  bool          `json:"dry_run"`
+	Changed bool          `json:"changed"`
+	Failed  bool          `json:"failed"`
+	Changes []StateChange `json:"changes"`
+}
+
+func (res *ProvisionResult) add(field string, from, to interface{}, err error) {
+	c := StateChange{Field: field, From: fmt.Sprint(from), To: fmt.Sprint(to)}
+	if err != nil {
+		c.Error = err.Error()
+		res.Failed = true
+	}
+	res.Changed = true
+	res.Changes = append(res.Changes, c)
+}
+
+// autoStartConfig of the desired state
+func (s *NodeState) autoStartConfig() (asc node.AutoStartConfig) {
+	asc.Sshs = s.Apps["sshs"]
+	asc.Sockss = s.Apps["sockss"]
+	if t := s.Transports["sshc"]; t != nil {
+		asc.Sshc = true
+		asc.SshcConfNodeKey = t.NodeKey
+		asc.SshcConfAppKey = t.AppKey
+		asc.SshcConfDiscovery = t.Discovery
+	}
+	if t := s.Transports["socksc"]; t != nil {
+		asc.Socksc = true
+		asc.SockscConfNodeKey = t.NodeKey
+		asc.SockscConfAppKey = t.AppKey
+		asc.SockscConfDiscovery = t.Discovery
+	}
+	return
+}
+
+func (m *Monitor) nodeAutoStartConfig(key string) (asc node.AutoStartConfig, err error) {
+	res, err := m.nodeRequest(key, "/node/run/getAutoStartConfig", url.Values{"key": {key}})
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal([]byte(res), &asc)
+	return
+}
+
+// apps connected to the node
+func (m *Monitor) nodeApps(key string) (apps []node.NodeApp, err error) {
+	res, err := m.nodeRequest(key, "/node/getApps", url.Values{})
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal([]byte(res), &apps)
+	return
+}
+
+// currentState reads the state of the node in the form of the provisioning document
+func (m *Monitor) currentState(key string) (s *NodeState, err error) {
+	asc, err := m.nodeAutoStartConfig(key)
+	if err != nil {
+		return
+	}
+	apps, err := m.nodeApps(key)
+	if err != nil {
+		return
+	}
+	s = &NodeState{
+		Apps:       make(map[string]bool),
+		AllowNodes: make(map[string][]string),
+		Transports: make(map[string]*TransportState),
+	}
+	for _, a := range provisionApps {
+		s.Apps[a] = false
+	}
+	for _, a := range apps {
+		for _, attr := range a.Attributes {
+			if _, ok := s.Apps[attr]; ok {
+				s.Apps[attr] = true
+				if len(a.AllowNodes) > 0 {
+					s.AllowNodes[attr] = a.AllowNodes
+				}
+			}
+		}
+	}
+	if asc.Sshc {
+		s.Transports["sshc"] = &TransportState{asc.SshcConfNodeKey, asc.SshcConfAppKey, asc.SshcConfDiscovery}
+	}
+	if asc.Socksc {
+		s.Transports["socksc"] = &TransportState{asc.SockscConfNodeKey, asc.SockscConfAppKey, asc.SockscConfDiscovery}
+	}
+	m.configsMutex.RLock()
+	if c, ok := m.configs[key]; ok && c != nil {
+		s.DiscoveryAddresses = c.DiscoveryAddresses
+	}
+	m.configsMutex.RUnlock()
+	return
+}
+
+// converge applies the changes needed to reach the desired state (desired),
+// applying it again without drift reports no changes
+func (m *Monitor) converge(key string, desired *NodeState, dryRun bool) (res *ProvisionResult, err error) {
+	res = &ProvisionResult{Node: key, DryRun: dryRun, Changes: make([]StateChange, 0)}
+	current, err := m.currentState(key)
+	if err != nil {
+		return
+	}
+	asc, err := m.nodeAutoStartConfig(key)
+	if err != nil {
+		return
+	}
+
+	want := desired.autoStartConfig()
+	if !reflect.DeepEqual(asc, want) {
+		var applyErr error
+		if !dryRun {
+			var d []byte
+			d, applyErr = json.Marshal(want)
+			if applyErr == nil {
+				_, applyErr = m.nodeRequest(key, "/node/run/setAutoStartConfig", url.Values{"key": {key}, "data": {string(d)}})
+			}
+		}
+		res.add("auto_start", autoStartString(asc), autoStartString(want), applyErr)
+	}
+
+	for _, a := range provisionApps {
+		allow := desired.AllowNodes[a]
+		// a running app allowing other nodes is started again with the desired ones
+		restart := current.Apps[a] && desired.Apps[a] && !sameAddresses(current.AllowNodes[a], allow)
+		if current.Apps[a] == desired.Apps[a] && !restart {
+			continue
+		}
+		var applyErr error
+		if !dryRun {
+			if desired.Apps[a] {
+				_, applyErr = m.nodeRequest(key, "/node/run/"+a, url.Values{"data": {strings.Join(allow, ",")}})
+			} else {
+				_, applyErr = m.nodeRequest(key, "/node/run/closeApp", url.Values{"key": {a}})
+			}
+		}
+		if restart {
+			res.add("allow_nodes."+a, current.AllowNodes[a], allow, applyErr)
+			continue
+		}
+		res.add("apps."+a, current.Apps[a], desired.Apps[a], applyErr)
+	}
+
+	for _, t := range provisionTransports {
+		from, to := current.Transports[t], desired.Transports[t]
+		if reflect.DeepEqual(from, to) {
+			continue
+		}
+		var applyErr error
+		if !dryRun {
+			if to != nil {
+				_, applyErr = m.nodeRequest(key, "/node/run/"+t, url.Values{
+					"toNode": {to.NodeKey}, "toApp": {to.AppKey}, "discoveryKey": {to.Discovery}})
+			} else {
+				_, applyErr = m.nodeRequest(key, "/node/run/closeApp", url.Values{"key": {t}})
+			}
+		}
+		res.add("transports."+t, transportString(from), transportString(to), applyErr)
+	}
+
+	if !sameAddresses(current.DiscoveryAddresses, desired.DiscoveryAddresses) {
+		if !dryRun {
+			m.configsMutex.Lock()
+			m.configs[key] = &Config{DiscoveryAddresses: desired.DiscoveryAddresses}
+			m.configsMutex.Unlock()
+		}
+		res.add("discovery_addresses", current.DiscoveryAddresses, desired.DiscoveryAddresses, nil)
+	}
+	return
+}
+
+func autoStartString(asc node.AutoStartConfig) string {
+	d, _ := json.Marshal(asc)
+	return string(d)
+}
+
+func transportString(t *TransportState) string {
+	if t == nil {
+		return "none"
+	}
+	return t.NodeKey + "/" + t.AppKey + "@" + t.Discovery
+}
+
+func sameAddresses(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	x := append([]string(nil), a...)
+	y := append([]string(nil), b...)
+	sort.Strings(x)
+	sort.Strings(y)
+	return strings.Join(x, ",") == strings.Join(y, ",")
+}
+
+func (m *Monitor) getNodeState(w http.ResponseWriter, r *http.Request) (result []byte, err error, code int) {
+	key := r.FormValue("key")
+	if !m.authorize(w, r, RoleViewer, key) {
+		return
+	}
+	s, err := m.currentState(key)
+	if err != nil {
+		code = SERVER_ERROR
+		return
+	}
+	result, err = json.Marshal(s)
+	return
+}
+
+// applyNodeState converges the node to the desired state document and reports the diff,
+// with dry_run=true only the diff is reported
+func (m *Monitor) applyNodeState(w http.ResponseWriter, r *http.Request) (result []byte, err error, code int) {
+	key := r.FormValue("key")
+	if !m.authorize(w, r, RoleAdmin, key) {
+		return
+	}
+	if r.Method != "POST" {
+		code = BAD_REQUEST
+		err = errors.New("please use post method")
+		return
+	}
+	var desired NodeState
+	err = json.Unmarshal([]byte(r.FormValue("data")), &desired)
+	if err != nil {
+		code = BAD_REQUEST
+		return
+	}
+	err = desired.validate()
+	if err != nil {
+		code = BAD_REQUEST
+		return
+	}
+	res, err := m.converge(key, &desired, r.FormValue("dry_run") == "true")
+	if err != nil {
+		code = SERVER_ERROR
+		return
+	}
+	result, err = json.Marshal(res)
+	return
+}
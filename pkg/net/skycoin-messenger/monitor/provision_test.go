@@ -0,0 +1,177 @@
+package monitor
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/skycoin/skycoin/src/cipher"
+	"github.com/skycoin/skywire/pkg/node"
+)
+
+// applyState posts the desired state of the node and returns the result
+func applyState(t *testing.T, c *client, key string, desired *NodeState, dryRun bool) *ProvisionResult {
+	d, err := json.Marshal(desired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	form := url.Values{"key": {key}, "data": {string(d)}}
+	if dryRun {
+		form.Set("dry_run", "true")
+	}
+	status, body := c.send(http.MethodPost, "/provision/apply", form)
+	if status != http.StatusOK {
+		t.Fatalf("apply: %d %s", status, body)
+	}
+	res := &ProvisionResult{}
+	if err = json.Unmarshal([]byte(body), res); err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func changedFields(res *ProvisionResult) string {
+	var fields []string
+	for _, c := range res.Changes {
+		fields = append(fields, c.Field)
+	}
+	sort.Strings(fields)
+	return strings.Join(fields, ",")
+}
+
+// changes returns the paths of the requests that change the node
+func changes(calls []string) (paths []string) {
+	for _, p := range calls {
+		if p != "/node/run/getAutoStartConfig" && p != "/node/getApps" {
+			paths = append(paths, p)
+		}
+	}
+	return
+}
+
+func TestProvisionDiffAndApply(t *testing.T) {
+	tm := newTestMonitor(t, nil)
+	defer tm.close()
+	n := tm.connectNode(t)
+	c := tm.login(t, "", testPass)
+	allow := cipher.PubKey([33]byte{0x02, 0x05}).Hex()
+	remote := cipher.PubKey([33]byte{0x02, 0x06}).Hex()
+	// sockss runs and starts with the node
+	n.setState(node.AutoStartConfig{Sockss: true}, []node.NodeApp{{Attributes: []string{"sockss"}}})
+	desired := &NodeState{
+		Apps:               map[string]bool{"sshs": true, "sockss": false},
+		AllowNodes:         map[string][]string{"sshs": {allow}},
+		Transports:         map[string]*TransportState{"sshc": {NodeKey: remote, AppKey: remote, Discovery: "127.0.0.1:5999"}},
+		DiscoveryAddresses: []string{"127.0.0.1:5999"},
+	}
+
+	status, body := c.send(http.MethodGet, "/provision/getState", url.Values{"key": {n.key}})
+	if status != http.StatusOK {
+		t.Fatalf("state: %d %s", status, body)
+	}
+	var current NodeState
+	if err := json.Unmarshal([]byte(body), &current); err != nil {
+		t.Fatal(err)
+	}
+	if !current.Apps["sockss"] || current.Apps["sshs"] || len(current.Transports) != 0 {
+		t.Fatalf("state %+v", current)
+	}
+
+	// the dry run reports the diff and changes nothing
+	n.requested()
+	res := applyState(t, c, n.key, desired, true)
+	want := "apps.sockss,apps.sshs,auto_start,discovery_addresses,transports.sshc"
+	if !res.DryRun || !res.Changed || res.Failed || changedFields(res) != want {
+		t.Fatalf("dry run %+v", res)
+	}
+	if calls := changes(n.requested()); len(calls) != 0 {
+		t.Fatalf("the dry run requested %v", calls)
+	}
+	if asc, _ := n.state(); !reflect.DeepEqual(asc, node.AutoStartConfig{Sockss: true}) {
+		t.Fatalf("the dry run changed %+v", asc)
+	}
+
+	res = applyState(t, c, n.key, desired, false)
+	if res.DryRun || !res.Changed || res.Failed || changedFields(res) != want {
+		t.Fatalf("apply %+v", res)
+	}
+	asc, apps := n.state()
+	if !reflect.DeepEqual(asc, desired.autoStartConfig()) {
+		t.Fatalf("auto start %+v", asc)
+	}
+	if len(apps) != 1 || !containsString(apps[0].Attributes, "sshs") || strings.Join(apps[0].AllowNodes, ",") != allow {
+		t.Fatalf("apps %+v", apps)
+	}
+	calls := strings.Join(changes(n.requested()), " ")
+	for _, p := range []string{"/node/run/setAutoStartConfig", "/node/run/sshs", "/node/run/closeApp", "/node/run/sshc"} {
+		if !strings.Contains(calls, p) {
+			t.Errorf("%s not requested: %s", p, calls)
+		}
+	}
+
+	// applied again without drift, nothing changes
+	res = applyState(t, c, n.key, desired, false)
+	if res.Changed || len(res.Changes) != 0 {
+		t.Fatalf("applied again %+v", res)
+	}
+	if calls := changes(n.requested()); len(calls) != 0 {
+		t.Fatalf("applied again requested %v", calls)
+	}
+
+	// sshs allowing other nodes is started again with the desired ones
+	n.setState(asc, []node.NodeApp{{Attributes: []string{"sshs"}, AllowNodes: []string{remote}}})
+	res = applyState(t, c, n.key, desired, false)
+	if changedFields(res) != "allow_nodes.sshs" {
+		t.Fatalf("drift %+v", res)
+	}
+	if _, apps = n.state(); len(apps) != 1 || strings.Join(apps[0].AllowNodes, ",") != allow {
+		t.Fatalf("apps %+v", apps)
+	}
+}
+
+func TestProvisionFailure(t *testing.T) {
+	tm := newTestMonitor(t, nil)
+	defer tm.close()
+	n := tm.connectNode(t)
+	c := tm.login(t, "", testPass)
+	n.failPath("/node/run/sockss", true)
+
+	// the failed change is reported, the others are still applied
+	res := applyState(t, c, n.key, &NodeState{Apps: map[string]bool{"sockss": true}, DiscoveryAddresses: []string{"127.0.0.1:5999"}}, false)
+	if !res.Failed || changedFields(res) != "apps.sockss,auto_start,discovery_addresses" {
+		t.Fatalf("apply %+v", res)
+	}
+	for _, change := range res.Changes {
+		if (change.Field == "apps.sockss") != (len(change.Error) > 0) {
+			t.Errorf("change %+v", change)
+		}
+	}
+	if asc, _ := n.state(); !asc.Sockss {
+		t.Fatalf("auto start not applied %+v", asc)
+	}
+
+	for _, c2 := range []struct {
+		name string
+		data string
+	}{
+		{"invalid json", "{"},
+		{"unknown app", `{"apps":{"vpn":true}}`},
+		{"sshs allowing any node", `{"apps":{"sshs":true}}`},
+		{"invalid allowed node", `{"apps":{"sshs":true},"allow_nodes":{"sshs":["02"]}}`},
+		{"short transport key", `{"transports":{"sshc":{"node_key":"02","app_key":"02"}}}`},
+	} {
+		if status, body := c.send(http.MethodPost, "/provision/apply", url.Values{"key": {n.key}, "data": {c2.data}}); status != http.StatusBadRequest {
+			t.Errorf("%s: got %d %s", c2.name, status, body)
+		}
+	}
+
+	// a node that is not connected
+	offline := cipher.PubKey([33]byte{0x02, 0x07}).Hex()
+	if status, _ := c.send(http.MethodPost, "/provision/apply", url.Values{"key": {offline}, "data": {"{}"}}); status != http.StatusInternalServerError {
+		t.Fatalf("offline node: got %d", status)
+	}
+}
@@ -0,0 +1,248 @@
+package monitor
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/skycoin/skycoin/src/cipher"
+	"github.com/skycoin/skywire/pkg/httputil"
+	"github.com/skycoin/skywire/pkg/net/skycoin-messenger/factory"
+	"github.com/skycoin/skywire/pkg/net/util"
+)
+
+// Role of a manager account or API token
+type Role string
+
+const (
+	// dashboard visibility only
+	RoleViewer Role = "viewer"
+	// may also start and stop apps and reboot nodes
+	RoleOperator Role = "operator"
+	// full access, including node config, updates, shells and keys
+	RoleAdmin Role = "admin"
+)
+
+func (r Role) level() int {
+	switch r {
+	case RoleViewer:
+		return 1
+	case RoleOperator:
+		return 2
+	case RoleAdmin:
+		return 3
+	}
+	return 0
+}
+
+func (r Role) valid() bool {
+	return r.level() > 0
+}
+
+func (r Role) allows(required Role) bool {
+	return r.valid() && r.level() >= required.level()
+}
+
+// Account is an additional manager user with a limited role,
+// the password of the User config is the owner and always admin
+type Account struct {
+	Name string
+	Pass string
+	Keys []string `json:",omitempty"`
+	Role Role
+	// node groups the account has access to, empty for all nodes
+	Groups []string `json:",omitempty"`
+	// second factor of the password login, nil if not enrolled
+	TwoFactor *TwoFactor `json:",omitempty"`
+}
+
+// principal is the identity of an authorized request
+type principal struct {
+	Name   string
+	Role   Role
+	Groups []string
+}
+
+var owner = &principal{Role: RoleAdmin}
+
+func (a *Account) principal() *principal {
+	return &principal{Name: a.Name, Role: a.Role, Groups: a.Groups}
+}
+
+// canAccess checks if the node (hex public key) is in one of the groups of the principal
+func (p *principal) canAccess(node string) bool {
+	if len(p.Groups) == 0 {
+		return true
+	}
+	if len(node) == 0 {
+		return false
+	}
+	u, err := readUserConfig(userPath)
+	if err != nil {
+		return false
+	}
+	for _, g := range p.Groups {
+		for _, k := range u.Groups[g] {
+			if k == node {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// findAccount returns the account with the name (name)
+func findAccount(name string) (a *Account, ok bool) {
+	u, err := readUserConfig(userPath)
+	if err != nil {
+		return
+	}
+	for i := range u.Accounts {
+		if u.Accounts[i].Name == name {
+			return &u.Accounts[i], true
+		}
+	}
+	return
+}
+
+// principalByKey returns the owner or the account allowed to login with the public key
+func principalByKey(key cipher.PubKey) (p *principal, ok bool) {
+	u, err := readUserConfig(userPath)
+	if err != nil {
+		return
+	}
+	hex := key.Hex()
+	for _, k := range u.Keys {
+		if k == hex {
+			return owner, true
+		}
+	}
+	for i := range u.Accounts {
+		for _, k := range u.Accounts[i].Keys {
+			if k == hex {
+				return u.Accounts[i].principal(), true
+			}
+		}
+	}
+	return
+}
+
+// role required to proxy a request to a node api path by /req or /term
+func nodeRequestRole(addr string) Role {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return RoleAdmin
+	}
+	p := path.Clean(u.Path)
+	switch p {
+	case "/node/getSig", "/node/run/getShellOutput":
+		return RoleAdmin
+	case "/node/reboot",
+		"/node/run/sshs", "/node/run/sshc", "/node/run/sockss", "/node/run/socksc",
+		"/node/run/closeApp", "/node/run/searchServices", "/node/run/setAutoStartConfig",
+		"/node/getDiag", "/node/getProfile", "/node/getTrace", "/node/run/maintenance":
+		return RoleOperator
+	case "/node/run/checkUpdate":
+		return RoleViewer
+	}
+	if strings.HasPrefix(p, "/node/get") || strings.HasPrefix(p, "/node/run/get") {
+		return RoleViewer
+	}
+	return RoleAdmin
+}
+
+// authorizeAllNodes checks that the request is of an admin of all the nodes, for the routes
+// whose changes or secrets are not of a node of a group
+func (m *Monitor) authorizeAllNodes(w http.ResponseWriter, r *http.Request) bool {
+	p, ok := m.requestPrincipal(w, r)
+	if !ok {
+		return false
+	}
+	if !p.Role.allows(RoleAdmin) || len(p.Groups) > 0 {
+		httputil.Fail(w, "Forbidden", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// verifyNodeRequest checks the request is signed by a connected node with its key, in the
+// headers of the shell signatures
+func (m *Monitor) verifyNodeRequest(r *http.Request) (err error) {
+	key, err := pubKeyFromHex(r.Header.Get(util.ShellKeyHeader))
+	if err != nil {
+		return
+	}
+	if _, ok := m.factory.GetConnection(key); !ok {
+		err = errors.New("node is not connected")
+		return
+	}
+	return util.VerifyShellRequest(r, []cipher.PubKey{key}, key.Hex(), &m.nodeNonces, time.Now())
+}
+
+// node api paths that also need the signature of the manager key, see -shell-manager-key of the node
+var shellPaths = map[string]bool{
+	"/node/run/runShell":       true,
+	"/node/run/runCmd":         true,
+	"/node/run/getShellOutput": true,
+	"/node/run/term":           true,
+}
+
+// shellSignature signs a request with the method and the body to a shell path of the node api
+// url (addr), nil for the other paths
+func (m *Monitor) shellSignature(method, addr string, body []byte) map[string]string {
+	u, err := url.Parse(addr)
+	if err != nil || !shellPaths[path.Clean(u.Path)] {
+		return nil
+	}
+	sc := m.factory.GetDefaultSeedConfig()
+	if sc == nil {
+		return nil
+	}
+	sk, err := cipher.SecKeyFromHex(sc.SecKey)
+	if err != nil {
+		return nil
+	}
+	defer util.WipeSecKey(&sk)
+	return util.SignShellRequest(sk, m.nodeKeyByAddr(addr), method, u, body, time.Now())
+}
+
+// nodeAPIAddr returns the address of the node api of a connected node
+func nodeAPIAddr(c *factory.Connection) (addr string, err error) {
+	v, ok := c.LoadContext("node-api")
+	if !ok {
+		return
+	}
+	webPort, ok := v.(string)
+	if !ok || len(webPort) <= 1 {
+		return
+	}
+	host, _, err := net.SplitHostPort(c.GetRemoteAddr().String())
+	if err != nil {
+		return
+	}
+	_, port, err := net.SplitHostPort(webPort)
+	if err != nil {
+		return
+	}
+	addr = net.JoinHostPort(host, port)
+	return
+}
+
+// nodeKeyByAddr returns the key of the node serving the api url (addr)
+func (m *Monitor) nodeKeyByAddr(addr string) (key string) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return
+	}
+	m.factory.ForEachAcceptedConnection(func(k cipher.PubKey, conn *factory.Connection) {
+		a, err := nodeAPIAddr(conn)
+		if err == nil && len(a) > 0 && a == u.Host {
+			key = k.Hex()
+		}
+	})
+	return
+}
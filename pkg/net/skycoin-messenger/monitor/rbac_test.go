@@ -0,0 +1,248 @@
+package monitor
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skycoin/skycoin/src/cipher"
+	"github.com/skycoin/skywire/pkg/net/skycoin-messenger/factory"
+	"github.com/skycoin/skywire/pkg/net/util"
+)
+
+// roleRoute is a request of a route changing the state, made so it changes little
+type roleRoute struct {
+	path string
+	form url.Values
+	// the least role allowed, only the owner if empty
+	role Role
+	// the route is for the user of a browser session, tokens have no use for it
+	session bool
+	// the action recorded in the audit log, empty if it is not recorded
+	action string
+}
+
+func roleRoutes() []roleRoute {
+	key := cipher.PubKey([33]byte{0x02, 0x01}).Hex()
+	return []roleRoute{
+		{path: "/conn/disconnect", form: url.Values{"key": {key}}, role: RoleOperator, action: "conn/disconnect"},
+		{path: "/conn/ban", form: url.Values{"key": {key}, "duration": {"1h"}}, role: RoleAdmin, action: "conn/ban"},
+		{path: "/conn/unban", form: url.Values{"key": {key}}, role: RoleAdmin, action: "conn/unban"},
+		{path: "/conn/setNodeMaintenance", form: url.Values{"key": {""}, "duration": {"30m"}}, role: RoleOperator, action: "conn/setNodeMaintenance"},
+		{path: "/conn/setNodeConfig", form: url.Values{"key": {key}, "data": {`{"DiscoveryAddresses":["127.0.0.1:5999"]}`}}, role: RoleAdmin, action: "conn/setNodeConfig"},
+		{path: "/conn/saveClientConnection", form: url.Values{"client": {"sshc"}, "data": {"{}"}}, role: RoleOperator, action: "conn/saveClientConnection"},
+		{path: "/conn/removeClientConnection", form: url.Values{"client": {"sshc"}, "index": {"none"}}, role: RoleOperator, action: "conn/removeClientConnection"},
+		{path: "/conn/editClientConnection", form: url.Values{"client": {"sshc"}, "index": {"none"}, "label": {"l"}}, role: RoleOperator, action: "conn/editClientConnection"},
+		{path: "/updatePass", form: url.Values{"oldPass": {"wrong-pass"}, "newPass": {"new-pass"}}, session: true, action: "updatePass"},
+		{path: "/auth/2fa/confirm", form: url.Values{"code": {"000000"}}, role: RoleViewer, session: true, action: "auth/2fa/confirm"},
+		{path: "/auth/2fa/disable", form: url.Values{"code": {"000000"}}, role: RoleViewer, session: true, action: "auth/2fa/disable"},
+		{path: "/auth/2fa/backupCodes", form: url.Values{"code": {"000000"}}, role: RoleViewer, session: true, action: "auth/2fa/backupCodes"},
+		{path: "/auth/2fa/enroll", role: RoleViewer, session: true},
+		{path: "/auth/2fa/reset", form: url.Values{"name": {"nobody"}}, action: "auth/2fa/reset"},
+		{path: "/auth/createToken", form: url.Values{"role": {"viewer"}, "label": {"test"}}, role: RoleAdmin, action: "auth/createToken"},
+		{path: "/auth/revokeToken", form: url.Values{"id": {"none"}}, role: RoleAdmin, action: "auth/revokeToken"},
+		{path: "/group/set", form: url.Values{"name": {"other"}, "keys": {""}}, role: RoleAdmin, action: "group/set"},
+		{path: "/group/bulk", form: url.Values{"group": {"none"}, "action": {"setAutoStart"}, "data": {"{}"}}, role: RoleOperator, action: "group/bulk"},
+		{path: "/group/bulk", form: url.Values{"group": {"none"}, "action": {"restartApp"}, "data": {"sockss"}}, role: RoleOperator, action: "group/bulk"},
+		{path: "/group/bulk", form: url.Values{"group": {"none"}, "action": {"setDiscovery"}, "data": {`{"DiscoveryAddresses":["127.0.0.1:5999"]}`}}, role: RoleAdmin, action: "group/bulk"},
+		{path: "/alert/setConfig", form: url.Values{"data": {"{"}}, role: RoleAdmin, action: "alert/setConfig"},
+		{path: "/provision/apply", form: url.Values{"key": {""}, "data": {"{"}}, role: RoleAdmin, action: "provision/apply"},
+		{path: "/appFiles/write", form: url.Values{"key": {""}, "app": {"sshs"}, "path": {"config.json"}, "data": {"{}"}}, role: RoleAdmin, action: "appFiles/write"},
+		{path: "/peers/set", form: url.Values{"data": {"{"}}, role: RoleAdmin, action: "peers/set"},
+		{path: "/peers/rollback", form: url.Values{"version": {"none"}}, role: RoleAdmin, action: "peers/rollback"},
+		{path: "/pairing/pair", form: url.Values{"code": {"short"}}, role: RoleAdmin, action: "pairing/pair"},
+		// the manager proxies to a node api path with the role it needs, no node serves port 1
+		{path: "/req", form: url.Values{"addr": {"http://127.0.0.1:1/node/getInfo"}, "method": {"get"}}, role: RoleViewer},
+		{path: "/req", form: url.Values{"addr": {"http://127.0.0.1:1/node/reboot"}}, role: RoleOperator, action: "req"},
+		{path: "/req", form: url.Values{"addr": {"http://127.0.0.1:1/node/run/setNodeConfig"}}, role: RoleAdmin, action: "req"},
+		{path: "/req", form: url.Values{"addr": {"http://127.0.0.1:1/node/run/runCmd"}}, role: RoleAdmin, action: "req"},
+	}
+}
+
+var testAccounts = []Account{
+	{Name: "viewer", Pass: "viewer-pass", Role: RoleViewer},
+	{Name: "operator", Pass: "operator-pass", Role: RoleOperator},
+	{Name: "admin", Pass: "admin-pass", Role: RoleAdmin},
+}
+
+// checkRole checks the answer to a request of the route by a user with the role, "" for the owner
+func checkRole(t *testing.T, who string, role Role, route roleRoute, status int, body string) {
+	allowed := role == "" || (len(route.role) > 0 && role.allows(route.role))
+	if allowed && (status == http.StatusForbidden || status == http.StatusFound || status == http.StatusUnauthorized) {
+		t.Errorf("%s %s %v: refused %d %s", who, route.path, route.form, status, body)
+	}
+	if !allowed && status != http.StatusForbidden {
+		t.Errorf("%s %s %v: got %d %s, want %d", who, route.path, route.form, status, body, http.StatusForbidden)
+	}
+}
+
+func TestRoleMatrix(t *testing.T) {
+	tm := newTestMonitor(t, &User{Accounts: append([]Account(nil), testAccounts...)})
+	defer tm.close()
+	owner := tm.login(t, "", testPass)
+	for _, a := range testAccounts {
+		c := tm.login(t, a.Name, a.Name+"-pass")
+		bearer := tm.bearer(t, owner.createToken(a.Role, ""))
+		for _, route := range roleRoutes() {
+			status, body := c.send(http.MethodPost, route.path, route.form)
+			checkRole(t, a.Name, a.Role, route, status, body)
+			if route.session || len(route.role) == 0 {
+				continue
+			}
+			status, body = bearer.send(http.MethodPost, route.path, route.form)
+			checkRole(t, "token of "+a.Name, a.Role, route, status, body)
+		}
+	}
+	for _, route := range roleRoutes() {
+		status, body := owner.send(http.MethodPost, route.path, route.form)
+		checkRole(t, "owner", "", route, status, body)
+	}
+}
+
+func TestRoleGroups(t *testing.T) {
+	tm := newTestMonitor(t, nil)
+	defer tm.close()
+	in, out := tm.connectNode(t), tm.connectNode(t)
+	u, err := readUserConfig(userPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.Groups = map[string][]string{"g": {in.key}, "other": {out.key}}
+	u.Accounts = []Account{
+		{Name: "operator", Pass: getBcrypt("operator-pass"), Role: RoleOperator, Groups: []string{"g"}},
+		{Name: "admin", Pass: getBcrypt("admin-pass"), Role: RoleAdmin, Groups: []string{"g"}},
+	}
+	if err = WriteConfig(u, userPath); err != nil {
+		t.Fatal(err)
+	}
+	operator := tm.login(t, "operator", "operator-pass")
+	admin := tm.login(t, "admin", "admin-pass")
+	owner := tm.login(t, "", testPass)
+	other := newFakeNodeAPI()
+	otherSrv := httptest.NewServer(other)
+	defer otherSrv.Close()
+
+	for _, c := range []struct {
+		name   string
+		c      *client
+		path   string
+		form   url.Values
+		status int
+	}{
+		{"node of the group", operator, "/conn/setNodeMaintenance", url.Values{"key": {in.key}, "duration": {"30m"}}, http.StatusOK},
+		{"node of another group", operator, "/conn/setNodeMaintenance", url.Values{"key": {out.key}, "duration": {"30m"}}, http.StatusForbidden},
+		{"proxy to the node of the group", operator, "/req", url.Values{"addr": {in.srv.URL + "/node/reboot"}}, http.StatusOK},
+		{"proxy to the node of another group", operator, "/req", url.Values{"addr": {out.srv.URL + "/node/reboot"}}, http.StatusForbidden},
+		{"bulk on the group", operator, "/group/bulk", url.Values{"group": {"g"}, "action": {"restartApp"}, "data": {"sockss"}}, http.StatusOK},
+		{"bulk on another group", operator, "/group/bulk", url.Values{"group": {"other"}, "action": {"restartApp"}, "data": {"sockss"}}, http.StatusForbidden},
+		{"config of another group", admin, "/conn/setNodeConfig", url.Values{"key": {out.key}, "data": {"{}"}}, http.StatusForbidden},
+		{"state of another group", admin, "/provision/apply", url.Values{"key": {out.key}, "data": {"{}"}}, http.StatusForbidden},
+		// the peer lists and the paired nodes are of all the nodes
+		{"peer lists", admin, "/peers/set", url.Values{"data": {"{}"}}, http.StatusForbidden},
+		{"pairing", admin, "/pairing/pair", url.Values{"code": {"ABCD-EFGH"}}, http.StatusForbidden},
+		{"token of another group", admin, "/auth/createToken", url.Values{"role": {"viewer"}, "groups": {"other"}}, http.StatusBadRequest},
+		// the manager only proxies to the apis of the connected nodes
+		{"proxy to another host", admin, "/req", url.Values{"addr": {otherSrv.URL + "/node/getInfo"}, "method": {"get"}}, http.StatusBadRequest},
+		{"proxy to another host by the owner", owner, "/req", url.Values{"addr": {otherSrv.URL + "/node/getInfo"}, "method": {"get"}}, http.StatusBadRequest},
+	} {
+		if status, body := c.c.send(http.MethodPost, c.path, c.form); status != c.status {
+			t.Errorf("%s: got %d %s, want %d", c.name, status, body, c.status)
+		}
+	}
+	if calls := out.requested(); len(calls) != 0 {
+		t.Fatalf("the node of another group requested %v", calls)
+	}
+	if calls := in.requested(); len(calls) == 0 {
+		t.Fatal("the node of the group not requested")
+	}
+	if calls := other.requested(); len(calls) != 0 {
+		t.Fatalf("the host of no node requested %v", calls)
+	}
+
+	// the nodes of the other groups are not listed
+	status, body := operator.send(http.MethodGet, "/conn/getAll", nil)
+	var conns []Conn
+	if err = json.Unmarshal([]byte(body), &conns); status != http.StatusOK || err != nil {
+		t.Fatalf("nodes %d %s: %v", status, body, err)
+	}
+	if len(conns) != 1 || conns[0].Key != in.key {
+		t.Fatalf("nodes of the group %#v", conns)
+	}
+	if status, body = owner.send(http.MethodGet, "/conn/getAll", nil); strings.Count(body, `"key"`) != 2 {
+		t.Fatalf("nodes of the owner %d %s", status, body)
+	}
+}
+
+func TestTokenRoles(t *testing.T) {
+	tm := newTestMonitor(t, &User{Accounts: append([]Account(nil), testAccounts...)})
+	defer tm.close()
+	n := tm.connectNode(t)
+	u, err := readUserConfig(userPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.Groups = map[string][]string{"g": {n.key}}
+	u.Accounts = append(u.Accounts, Account{Name: "group-admin", Pass: getBcrypt("group-admin-pass"), Role: RoleAdmin, Groups: []string{"g"}})
+	if err = WriteConfig(u, userPath); err != nil {
+		t.Fatal(err)
+	}
+	// sign returns the headers of a request of the node (sc) to the token
+	tokenURL, _ := url.Parse(tm.srv.URL + "/getToken")
+	sign := func(sc *factory.SeedConfig) map[string]string {
+		sk := cipher.MustSecKeyFromHex(sc.SecKey)
+		return util.SignShellRequest(sk, sc.PublicKey, http.MethodGet, tokenURL, nil, time.Now())
+	}
+	replayed := sign(n.sc)
+	getToken := func(headers map[string]string) (int, string) {
+		req, err := http.NewRequest(http.MethodGet, tokenURL.String(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		b, _ := ioutil.ReadAll(res.Body)
+		return res.StatusCode, string(b)
+	}
+	if status, body := getToken(replayed); status != http.StatusOK || body != tm.token {
+		t.Fatalf("token of the connected node %d %s", status, body)
+	}
+
+	for _, c := range []struct {
+		name string
+		c    *client
+		// headers of a request of a node, instead of the client
+		headers map[string]string
+		status  int
+	}{
+		{name: "anonymous", c: tm.anonymous(t), status: http.StatusForbidden},
+		{name: "viewer", c: tm.login(t, "viewer", "viewer-pass"), status: http.StatusForbidden},
+		{name: "operator", c: tm.login(t, "operator", "operator-pass"), status: http.StatusForbidden},
+		{name: "admin of a group", c: tm.login(t, "group-admin", "group-admin-pass"), status: http.StatusForbidden},
+		{name: "admin", c: tm.login(t, "admin", "admin-pass"), status: http.StatusOK},
+		{name: "owner", c: tm.login(t, "", testPass), status: http.StatusOK},
+		{name: "node not connected", headers: sign(factory.NewSeedConfig()), status: http.StatusForbidden},
+		{name: "signature replayed", headers: replayed, status: http.StatusForbidden},
+	} {
+		var status int
+		var body string
+		if c.c != nil {
+			status, body = c.c.send(http.MethodGet, "/getToken", nil)
+		} else {
+			status, body = getToken(c.headers)
+		}
+		if status != c.status || (status == http.StatusOK) != (body == tm.token) {
+			t.Errorf("%s: got %d %s, want %d", c.name, status, body, c.status)
+		}
+	}
+}
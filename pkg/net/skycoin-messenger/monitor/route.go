@@ -0,0 +1,22 @@
+package monitor
+
+import (
+	"net/http"
+	"net/url"
+)
+
+// explainNodeRoute answers the replay of the route decision of a transport of the node, see
+// /node/explainRoute
+func (m *Monitor) explainNodeRoute(w http.ResponseWriter, r *http.Request) (result []byte, err error, code int) {
+	key := r.FormValue("key")
+	if !m.authorize(w, r, RoleViewer, key) {
+		return
+	}
+	res, err := m.nodeRequest(key, "/node/explainRoute", url.Values{"id": {r.FormValue("id")}})
+	if err != nil {
+		code = SERVER_ERROR
+		return
+	}
+	result = []byte(res)
+	return
+}
@@ -0,0 +1,293 @@
+package monitor
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/skycoin/skycoin/src/cipher"
+	"github.com/skycoin/skywire/pkg/httputil"
+	"github.com/skycoin/skywire/pkg/net/skycoin-messenger/factory"
+)
+
+type TopologyNode struct {
+	Key string `json:"key"`
+	// connected to this manager, the other nodes are only known as transport ends
+	Managed bool   `json:"managed"`
+	Version string `json:"version,omitempty"`
+}
+
+type TopologyEdge struct {
+	From          string `json:"from"`
+	To            string `json:"to"`
+	FromApp       string `json:"from_app"`
+	ToApp         string `json:"to_app"`
+	UploadTotal   uint64 `json:"upload_total"`
+	DownloadTotal uint64 `json:"download_total"`
+}
+
+// Topology of the nodes and transports known to the manager
+type Topology struct {
+	Time  int64          `json:"time"`
+	Nodes []TopologyNode `json:"nodes"`
+	Edges []TopologyEdge `json:"edges"`
+}
+
+// topology collects the transports reported by the nodes the principal (p) has access to,
+// a transport reported by both of its nodes is listed once
+func (m *Monitor) topology(p *principal) (t *Topology) {
+	var keys []string
+	m.factory.ForEachAcceptedConnection(func(key cipher.PubKey, conn *factory.Connection) {
+		if p.canAccess(key.Hex()) {
+			keys = append(keys, key.Hex())
+		}
+	})
+	nodes := make(map[string]*TopologyNode)
+	edges := make(map[string]*TopologyEdge)
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	for _, k := range keys {
+		nodes[k] = &TopologyNode{Key: k, Managed: true}
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			res, err := m.nodeRequest(key, "/node/getInfo", url.Values{"transports": {"false"}})
+			if err != nil {
+				log.Debugf("topology node %s info: %v", key, err)
+				return
+			}
+			var info struct {
+				Version string `json:"version"`
+			}
+			if json.Unmarshal([]byte(res), &info) != nil {
+				return
+			}
+			mutex.Lock()
+			nodes[key].Version = info.Version
+			mutex.Unlock()
+			err = m.forEachNodeTransport(key, func(tr *nodeTransport) {
+				id := tr.FromNode + tr.FromApp + tr.ToNode + tr.ToApp
+				mutex.Lock()
+				defer mutex.Unlock()
+				if _, ok := edges[id]; ok {
+					return
+				}
+				edges[id] = &TopologyEdge{
+					From:          tr.FromNode,
+					To:            tr.ToNode,
+					FromApp:       tr.FromApp,
+					ToApp:         tr.ToApp,
+					UploadTotal:   tr.UploadTotal,
+					DownloadTotal: tr.DownloadTotal,
+				}
+			})
+			if err != nil {
+				log.Debugf("topology node %s transports: %v", key, err)
+			}
+		}(k)
+	}
+	wg.Wait()
+
+	t = &Topology{Time: time.Now().Unix(), Nodes: make([]TopologyNode, 0), Edges: make([]TopologyEdge, 0)}
+	for _, e := range edges {
+		for _, k := range []string{e.From, e.To} {
+			if _, ok := nodes[k]; !ok {
+				nodes[k] = &TopologyNode{Key: k}
+			}
+		}
+		t.Edges = append(t.Edges, *e)
+	}
+	for _, n := range nodes {
+		t.Nodes = append(t.Nodes, *n)
+	}
+	sort.Slice(t.Nodes, func(i, j int) bool { return t.Nodes[i].Key < t.Nodes[j].Key })
+	sort.Slice(t.Edges, func(i, j int) bool {
+		if t.Edges[i].From != t.Edges[j].From {
+			return t.Edges[i].From < t.Edges[j].From
+		}
+		return t.Edges[i].To < t.Edges[j].To
+	})
+	return
+}
+
+// anonymize replaces every key by a hash with a random salt, the keys of one export
+// stay consistent but can not be linked to the keys of another export
+func (t *Topology) anonymize() (err error) {
+	salt := make([]byte, 16)
+	_, err = rand.Read(salt)
+	if err != nil {
+		return
+	}
+	hide := func(key string) string {
+		if len(key) == 0 {
+			return key
+		}
+		h := sha256.Sum256(append(append([]byte(nil), salt...), key...))
+		return hex.EncodeToString(h[:8])
+	}
+	for i := range t.Nodes {
+		t.Nodes[i].Key = hide(t.Nodes[i].Key)
+	}
+	for i := range t.Edges {
+		e := &t.Edges[i]
+		e.From, e.To, e.FromApp, e.ToApp = hide(e.From), hide(e.To), hide(e.FromApp), hide(e.ToApp)
+	}
+	return
+}
+
+func (t *Topology) dot() []byte {
+	var b bytes.Buffer
+	b.WriteString("digraph skywire {\n")
+	for _, n := range t.Nodes {
+		style := ""
+		if !n.Managed {
+			style = ", style=dashed"
+		}
+		fmt.Fprintf(&b, "\t%q [label=%q%s];\n", n.Key, shortKey(n.Key), style)
+	}
+	for _, e := range t.Edges {
+		fmt.Fprintf(&b, "\t%q -> %q [label=\"%d/%d\"];\n", e.From, e.To, e.UploadTotal, e.DownloadTotal)
+	}
+	b.WriteString("}\n")
+	return b.Bytes()
+}
+
+func shortKey(key string) string {
+	if len(key) > 16 {
+		return key[:16]
+	}
+	return key
+}
+
+type graphML struct {
+	XMLName xml.Name     `xml:"graphml"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	Keys    []graphMLKey `xml:"key"`
+	Graph   graphMLGraph `xml:"graph"`
+}
+
+type graphMLKey struct {
+	ID   string `xml:"id,attr"`
+	For  string `xml:"for,attr"`
+	Name string `xml:"attr.name,attr"`
+	Type string `xml:"attr.type,attr"`
+}
+
+type graphMLGraph struct {
+	EdgeDefault string        `xml:"edgedefault,attr"`
+	Nodes       []graphMLNode `xml:"node"`
+	Edges       []graphMLEdge `xml:"edge"`
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+type graphMLNode struct {
+	ID   string        `xml:"id,attr"`
+	Data []graphMLData `xml:"data"`
+}
+
+type graphMLEdge struct {
+	Source string        `xml:"source,attr"`
+	Target string        `xml:"target,attr"`
+	Data   []graphMLData `xml:"data"`
+}
+
+func (t *Topology) graphML() (d []byte, err error) {
+	g := graphML{
+		Xmlns: "http://graphml.graphdrawing.org/xmlns",
+		Keys: []graphMLKey{
+			{"managed", "node", "managed", "boolean"},
+			{"version", "node", "version", "string"},
+			{"from_app", "edge", "from_app", "string"},
+			{"to_app", "edge", "to_app", "string"},
+			{"upload_total", "edge", "upload_total", "long"},
+			{"download_total", "edge", "download_total", "long"},
+		},
+		Graph: graphMLGraph{EdgeDefault: "directed"},
+	}
+	for _, n := range t.Nodes {
+		g.Graph.Nodes = append(g.Graph.Nodes, graphMLNode{ID: n.Key, Data: []graphMLData{
+			{"managed", fmt.Sprint(n.Managed)},
+			{"version", n.Version},
+		}})
+	}
+	for _, e := range t.Edges {
+		g.Graph.Edges = append(g.Graph.Edges, graphMLEdge{Source: e.From, Target: e.To, Data: []graphMLData{
+			{"from_app", e.FromApp},
+			{"to_app", e.ToApp},
+			{"upload_total", fmt.Sprint(e.UploadTotal)},
+			{"download_total", fmt.Sprint(e.DownloadTotal)},
+		}})
+	}
+	d, err = xml.MarshalIndent(g, "", "  ")
+	if err != nil {
+		return
+	}
+	d = append([]byte(xml.Header), d...)
+	return
+}
+
+// exportTopology writes the topology as json, dot or graphml,
+// with anonymize=true the public keys are replaced by salted hashes
+func (m *Monitor) exportTopology(w http.ResponseWriter, r *http.Request) {
+	p, ok := m.requestPrincipal(w, r)
+	if !ok {
+		return
+	}
+	if !p.Role.allows(RoleViewer) {
+		httputil.Fail(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	format := r.FormValue("format")
+	if len(format) == 0 {
+		format = "json"
+	}
+	var contentType string
+	switch format {
+	case "json":
+		contentType = "application/json"
+	case "dot":
+		contentType = "text/vnd.graphviz"
+	case "graphml":
+		contentType = "application/graphml+xml"
+	default:
+		httputil.Fail(w, "format must be json, dot or graphml", BAD_REQUEST)
+		return
+	}
+	t := m.topology(p)
+	if r.FormValue("anonymize") == "true" {
+		if err := t.anonymize(); err != nil {
+			httputil.Fail(w, err.Error(), SERVER_ERROR)
+			return
+		}
+	}
+	var d []byte
+	var err error
+	switch format {
+	case "json":
+		d, err = json.Marshal(t)
+	case "dot":
+		d = t.dot()
+	case "graphml":
+		d, err = t.graphML()
+	}
+	if err != nil {
+		httputil.Fail(w, err.Error(), SERVER_ERROR)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Write(d)
+}
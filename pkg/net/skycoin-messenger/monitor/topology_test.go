@@ -0,0 +1,138 @@
+package monitor
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+// exportTopology returns the topology exported in the format
+func exportTopology(t *testing.T, c *client, format string, anonymize bool) string {
+	form := url.Values{"format": {format}}
+	if anonymize {
+		form.Set("anonymize", "true")
+	}
+	status, body := c.send(http.MethodGet, "/topology/export", form)
+	if status != http.StatusOK {
+		t.Fatalf("export %s: %d %s", format, status, body)
+	}
+	return body
+}
+
+func TestTopologyExport(t *testing.T) {
+	tm := newTestMonitor(t, nil)
+	defer tm.close()
+	a, b := tm.connectNode(t), tm.connectNode(t)
+	remote := cipher.PubKey([33]byte{0x02, 0x08}).Hex()
+	app := cipher.PubKey([33]byte{0x02, 0x09}).Hex()
+	ab := nodeTransport{FromNode: a.key, ToNode: b.key, FromApp: app, ToApp: app, UploadTotal: 10, DownloadTotal: 20}
+	// both nodes report the transport between them
+	a.setTransports("1.0", ab, nodeTransport{FromNode: a.key, ToNode: remote, FromApp: app, ToApp: app})
+	b.setTransports("1.1", ab)
+	c := tm.login(t, "", testPass)
+
+	var topo Topology
+	if err := json.Unmarshal([]byte(exportTopology(t, c, "json", false)), &topo); err != nil {
+		t.Fatal(err)
+	}
+	if len(topo.Nodes) != 3 || len(topo.Edges) != 2 {
+		t.Fatalf("topology %+v", topo)
+	}
+	for _, n := range topo.Nodes {
+		managed := n.Key == a.key || n.Key == b.key
+		if n.Managed != managed || (n.Key == b.key && n.Version != "1.1") {
+			t.Errorf("node %+v", n)
+		}
+	}
+	for _, e := range topo.Edges {
+		if e.To == b.key && (e.UploadTotal != 10 || e.DownloadTotal != 20) {
+			t.Errorf("edge %+v", e)
+		}
+	}
+
+	dot := exportTopology(t, c, "dot", false)
+	if !strings.HasPrefix(dot, "digraph skywire {") || strings.Count(dot, "->") != 2 ||
+		!strings.Contains(dot, `"`+a.key+`" -> "`+b.key+`" [label="10/20"]`) || strings.Count(dot, "style=dashed") != 1 {
+		t.Fatalf("dot %s", dot)
+	}
+
+	var g graphML
+	if err := xml.Unmarshal([]byte(exportTopology(t, c, "graphml", false)), &g); err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Graph.Nodes) != 3 || len(g.Graph.Edges) != 2 || g.Graph.EdgeDefault != "directed" {
+		t.Fatalf("graphml %+v", g)
+	}
+
+	// the anonymized keys are consistent within the export and hide the keys
+	anon := exportTopology(t, c, "json", true)
+	for _, k := range []string{a.key, b.key, remote, app} {
+		if strings.Contains(anon, k) {
+			t.Fatalf("key %s in %s", k, anon)
+		}
+	}
+	topo = Topology{}
+	if err := json.Unmarshal([]byte(anon), &topo); err != nil {
+		t.Fatal(err)
+	}
+	keys := make(map[string]bool)
+	for _, n := range topo.Nodes {
+		keys[n.Key] = true
+	}
+	for _, e := range topo.Edges {
+		if !keys[e.From] || !keys[e.To] || e.FromApp != e.ToApp {
+			t.Fatalf("anonymized edge %+v of %v", e, keys)
+		}
+	}
+	if again := exportTopology(t, c, "json", true); strings.Contains(again, topo.Nodes[0].Key) {
+		t.Fatal("the salt of an export reused")
+	}
+
+	if status, body := c.send(http.MethodGet, "/topology/export", url.Values{"format": {"svg"}}); status != http.StatusBadRequest {
+		t.Fatalf("unknown format: %d %s", status, body)
+	}
+}
+
+func TestTopologyGroups(t *testing.T) {
+	tm := newTestMonitor(t, nil)
+	defer tm.close()
+	in, out := tm.connectNode(t), tm.connectNode(t)
+	app := cipher.PubKey([33]byte{0x02, 0x09}).Hex()
+	in.setTransports("1.0", nodeTransport{FromNode: out.key, ToNode: in.key, FromApp: app, ToApp: app})
+	out.setTransports("1.0", nodeTransport{FromNode: out.key, ToNode: cipher.PubKey([33]byte{0x02, 0x08}).Hex(), FromApp: app, ToApp: app})
+	u, err := readUserConfig(userPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.Groups = map[string][]string{"g": {in.key}}
+	u.Accounts = []Account{{Name: "viewer", Pass: getBcrypt("viewer-pass"), Role: RoleViewer, Groups: []string{"g"}}}
+	if err = WriteConfig(u, userPath); err != nil {
+		t.Fatal(err)
+	}
+
+	// the nodes of the other groups are only known as the ends of the transports
+	var topo Topology
+	if err = json.Unmarshal([]byte(exportTopology(t, tm.login(t, "viewer", "viewer-pass"), "json", false)), &topo); err != nil {
+		t.Fatal(err)
+	}
+	if len(topo.Edges) != 1 || len(topo.Nodes) != 2 {
+		t.Fatalf("topology %+v", topo)
+	}
+	for _, n := range topo.Nodes {
+		if n.Managed != (n.Key == in.key) {
+			t.Errorf("node %+v", n)
+		}
+	}
+	if calls := out.requested(); len(calls) != 0 {
+		t.Fatalf("the node of another group requested %v", calls)
+	}
+
+	if status, _ := tm.anonymous(t).send(http.MethodGet, "/topology/export", nil); status == http.StatusOK {
+		t.Fatal("exported without a session")
+	}
+}
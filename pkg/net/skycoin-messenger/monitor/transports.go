@@ -0,0 +1,94 @@
+package monitor
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/skycoin/skywire/pkg/httputil"
+)
+
+// transports of a page requested from a node
+const nodeTransportPage = 500
+
+// nodeTransport is a transport as reported by the node api
+type nodeTransport struct {
+	FromNode      string `json:"from_node"`
+	ToNode        string `json:"to_node"`
+	FromApp       string `json:"from_app"`
+	ToApp         string `json:"to_app"`
+	UploadTotal   uint64 `json:"upload_total"`
+	DownloadTotal uint64 `json:"download_total"`
+}
+
+// forEachNodeTransport pages through the transports of the node, so only a page of a node
+// with many transports is held at a time. Nodes without /node/getTransports are asked for
+// their info with all the transports.
+func (m *Monitor) forEachNodeTransport(key string, fn func(t *nodeTransport)) (err error) {
+	var cursor string
+	for {
+		var res string
+		res, err = m.nodeRequest(key, "/node/getTransports", url.Values{
+			"cursor": {cursor},
+			"limit":  {strconv.Itoa(nodeTransportPage)},
+		})
+		if e, ok := err.(*httputil.Error); ok && e.Status == http.StatusNotFound && len(cursor) == 0 {
+			return m.forEachInfoTransport(key, fn)
+		}
+		if err != nil {
+			return
+		}
+		var page struct {
+			Transports []nodeTransport `json:"transports"`
+			Next       string          `json:"next"`
+		}
+		err = json.Unmarshal([]byte(res), &page)
+		if err != nil {
+			return
+		}
+		for i := range page.Transports {
+			fn(&page.Transports[i])
+		}
+		if len(page.Next) == 0 {
+			return
+		}
+		cursor = page.Next
+	}
+}
+
+func (m *Monitor) forEachInfoTransport(key string, fn func(t *nodeTransport)) (err error) {
+	res, err := m.nodeRequest(key, "/node/getInfo", url.Values{})
+	if err != nil {
+		return
+	}
+	var info struct {
+		Transports []nodeTransport `json:"transports"`
+	}
+	err = json.Unmarshal([]byte(res), &info)
+	if err != nil {
+		return
+	}
+	for i := range info.Transports {
+		fn(&info.Transports[i])
+	}
+	return
+}
+
+// getNodeTransports answers a page of the transports of a node, see /node/getTransports
+func (m *Monitor) getNodeTransports(w http.ResponseWriter, r *http.Request) (result []byte, err error, code int) {
+	key := r.FormValue("key")
+	if !m.authorize(w, r, RoleViewer, key) {
+		return
+	}
+	res, err := m.nodeRequest(key, "/node/getTransports", url.Values{
+		"cursor": {r.FormValue("cursor")},
+		"limit":  {r.FormValue("limit")},
+	})
+	if err != nil {
+		code = SERVER_ERROR
+		return
+	}
+	result = []byte(res)
+	return
+}
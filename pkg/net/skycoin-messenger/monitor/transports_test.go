@@ -0,0 +1,79 @@
+package monitor
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+func TestNodeTransports(t *testing.T) {
+	tm := newTestMonitor(t, nil)
+	defer tm.close()
+	n := tm.connectNode(t)
+	var transports []nodeTransport
+	for i := 0; i < 5; i++ {
+		app := cipher.PubKey([33]byte{0x02, byte(i)}).Hex()
+		transports = append(transports, nodeTransport{FromNode: n.key, FromApp: app, ToApp: app})
+	}
+	n.setTransports("1.0", transports...)
+	n.setPage(2, false)
+
+	// walk returns the apps of the transports of the node and the paths requested
+	walk := func() (apps []string, calls []string) {
+		err := tm.forEachNodeTransport(n.key, func(tr *nodeTransport) {
+			apps = append(apps, tr.FromApp)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return apps, n.requested()
+	}
+	check := func(name string, apps []string) {
+		if len(apps) != len(transports) {
+			t.Fatalf("%s: apps %v", name, apps)
+		}
+		for i, app := range apps {
+			if app != transports[i].FromApp {
+				t.Fatalf("%s: app %d %s", name, i, app)
+			}
+		}
+	}
+
+	// the pages are requested until the last one
+	apps, calls := walk()
+	check("pages", apps)
+	if len(calls) != 3 {
+		t.Fatalf("requested %v", calls)
+	}
+	for _, c := range calls {
+		if c != "/node/getTransports" {
+			t.Fatalf("requested %v", calls)
+		}
+	}
+
+	// the manager answers a page as the node does
+	c := tm.login(t, "", testPass)
+	status, body := c.send(http.MethodGet, "/conn/getNodeTransports", url.Values{"key": {n.key}, "cursor": {"2"}, "limit": {"2"}})
+	var page struct {
+		Transports []nodeTransport `json:"transports"`
+		Next       string          `json:"next"`
+	}
+	if status != http.StatusOK || json.Unmarshal([]byte(body), &page) != nil {
+		t.Fatalf("page: %d %s", status, body)
+	}
+	if len(page.Transports) != 2 || page.Transports[0] != transports[2] || page.Next != "4" {
+		t.Fatalf("page %s", body)
+	}
+	n.requested()
+
+	// the nodes before the pages answer all their transports in their info
+	n.setPage(0, true)
+	apps, calls = walk()
+	check("info", apps)
+	if len(calls) != 2 || calls[0] != "/node/getTransports" || calls[1] != "/node/getInfo" {
+		t.Fatalf("requested %v", calls)
+	}
+}
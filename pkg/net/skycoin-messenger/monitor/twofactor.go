@@ -0,0 +1,555 @@
+package monitor
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/astaxie/beego/session"
+	"github.com/pkg/errors"
+	"github.com/skycoin/skywire/pkg/httputil"
+	"github.com/skycoin/skywire/pkg/net/util"
+)
+
+const (
+	totpPeriod = 30
+	totpDigits = 6
+	// steps before and after the current one accepted for the clock of the phone
+	totpSkew        = 1
+	backupCodeCount = 10
+	// wrong codes allowed before the codes of a user are refused for twoFactorLockout
+	twoFactorMaxFailures = 5
+	twoFactorLockout     = 5 * time.Minute
+	twoFactorIssuer      = "Skywire Manager"
+)
+
+// TwoFactor is the TOTP enrollment of the owner or an account
+type TwoFactor struct {
+	// base32 secret shared with the authenticator app
+	Secret string
+	// bcrypt hashes of the backup codes not used yet
+	BackupCodes []string `json:",omitempty"`
+	// time step of the last accepted code, each code is accepted once
+	LastStep int64 `json:",omitempty"`
+}
+
+// TwoFactorPolicy tells which password logins need a second factor
+type TwoFactorPolicy string
+
+const (
+	// the users choose whether to enroll
+	TwoFactorOptional TwoFactorPolicy = "optional"
+	// admins must enroll before using the manager
+	TwoFactorAdmins TwoFactorPolicy = "admin"
+	// every user must enroll before using the manager
+	TwoFactorAll TwoFactorPolicy = "all"
+)
+
+func ParseTwoFactorPolicy(s string) (p TwoFactorPolicy, err error) {
+	p = TwoFactorPolicy(s)
+	switch p {
+	case TwoFactorOptional, TwoFactorAdmins, TwoFactorAll:
+	default:
+		err = fmt.Errorf("two-factor policy must be optional, admin or all, not %q", s)
+	}
+	return
+}
+
+func (p TwoFactorPolicy) requires(role Role) bool {
+	switch p {
+	case TwoFactorAll:
+		return true
+	case TwoFactorAdmins:
+		return role.allows(RoleAdmin)
+	}
+	return false
+}
+
+// SetTwoFactorPolicy sets which password logins need a second factor, the owner and accounts
+// without one that need it may only enroll after logging in
+func (m *Monitor) SetTwoFactorPolicy(p TwoFactorPolicy) {
+	m.twoFactorMutex.Lock()
+	m.twoFactorPolicy = p
+	m.twoFactorMutex.Unlock()
+}
+
+func (m *Monitor) getTwoFactorPolicy() TwoFactorPolicy {
+	m.twoFactorMutex.RLock()
+	defer m.twoFactorMutex.RUnlock()
+	return m.twoFactorPolicy
+}
+
+func totpCode(secret []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0xf
+	v := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, v%1000000)
+}
+
+func newTOTPSecret() (string, error) {
+	b := make([]byte, 20)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.EncodeToString(b), nil
+}
+
+// totpURI is shown as a QR code for the authenticator app
+func totpURI(name, secret string) string {
+	if len(name) == 0 {
+		name = "owner"
+	}
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", twoFactorIssuer)
+	v.Set("digits", fmt.Sprint(totpDigits))
+	v.Set("period", fmt.Sprint(totpPeriod))
+	// some authenticator apps do not read + as a space
+	return "otpauth://totp/" + url.PathEscape(twoFactorIssuer+":"+name) + "?" + strings.Replace(v.Encode(), "+", "%20", -1)
+}
+
+// newBackupCodes returns the codes to show once and their hashes to keep
+func newBackupCodes() (codes, hashes []string, err error) {
+	for i := 0; i < backupCodeCount; i++ {
+		var c string
+		c, err = getSecureRandomString(5)
+		if err != nil {
+			return
+		}
+		codes = append(codes, c[:5]+"-"+c[5:])
+		hashes = append(hashes, getBcrypt(c))
+	}
+	return
+}
+
+func normalizeBackupCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+// verifyTOTP checks a code of the authenticator app, the step of the code is remembered
+func (tf *TwoFactor) verifyTOTP(code string, now time.Time) bool {
+	secret, err := base32.StdEncoding.DecodeString(tf.Secret)
+	if err != nil || len(code) != totpDigits {
+		return false
+	}
+	step := now.Unix() / totpPeriod
+	for s := step - totpSkew; s <= step+totpSkew; s++ {
+		if s <= tf.LastStep {
+			continue
+		}
+		if util.ConstantTimeEqualString(totpCode(secret, s), code) {
+			tf.LastStep = s
+			return true
+		}
+	}
+	return false
+}
+
+// verify checks a code of the authenticator app or a backup code, which is used up
+func (tf *TwoFactor) verify(code string, now time.Time) bool {
+	code = strings.TrimSpace(code)
+	if tf.verifyTOTP(code, now) {
+		return true
+	}
+	code = normalizeBackupCode(code)
+	for i, h := range tf.BackupCodes {
+		if matchPassword(h, code) {
+			tf.BackupCodes = append(tf.BackupCodes[:i:i], tf.BackupCodes[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// the user config is read and written by several requests at once
+var twoFactorConfigMutex sync.Mutex
+
+// loadTwoFactor returns the enrollment of the owner or account, nil if there is none
+func loadTwoFactor(p *principal) (tf *TwoFactor, err error) {
+	u, err := readUserConfig(userPath)
+	if err != nil {
+		return
+	}
+	if p == owner {
+		return u.TwoFactor, nil
+	}
+	for i := range u.Accounts {
+		if u.Accounts[i].Name == p.Name {
+			return u.Accounts[i].TwoFactor, nil
+		}
+	}
+	err = errors.New("account not found")
+	return
+}
+
+// saveTwoFactor sets the enrollment of the owner or account, nil to remove it
+func saveTwoFactor(p *principal, tf *TwoFactor) (err error) {
+	u, err := readUserConfig(userPath)
+	if err != nil {
+		return
+	}
+	if p == owner {
+		u.TwoFactor = tf
+		return WriteConfig(u, userPath)
+	}
+	for i := range u.Accounts {
+		if u.Accounts[i].Name == p.Name {
+			u.Accounts[i].TwoFactor = tf
+			return WriteConfig(u, userPath)
+		}
+	}
+	return errors.New("account not found")
+}
+
+// twoFactorFailures locks out the users that entered too many wrong codes
+type twoFactorFailures struct {
+	counts map[string]int
+	until  map[string]time.Time
+	sync.Mutex
+}
+
+func (f *twoFactorFailures) locked(name string) bool {
+	f.Lock()
+	defer f.Unlock()
+	return time.Now().Before(f.until[name])
+}
+
+func (f *twoFactorFailures) add(name string) {
+	f.Lock()
+	defer f.Unlock()
+	if f.counts == nil {
+		f.counts = make(map[string]int)
+		f.until = make(map[string]time.Time)
+	}
+	f.counts[name]++
+	if f.counts[name] >= twoFactorMaxFailures {
+		f.until[name] = time.Now().Add(twoFactorLockout)
+		delete(f.counts, name)
+	}
+}
+
+func (f *twoFactorFailures) reset(name string) {
+	f.Lock()
+	delete(f.counts, name)
+	f.Unlock()
+}
+
+// checkCode verifies the code of the user and saves the used up code
+func (m *Monitor) checkCode(p *principal, tf *TwoFactor, code string) (ok bool, err error) {
+	name := p.auditName()
+	if m.twoFactorFailures.locked(name) {
+		return
+	}
+	if !tf.verify(code, time.Now()) {
+		m.twoFactorFailures.add(name)
+		return
+	}
+	m.twoFactorFailures.reset(name)
+	err = saveTwoFactor(p, tf)
+	ok = err == nil
+	return
+}
+
+// loginSecondFactor completes a password login of the user with the form value otp, the
+// result is "otp" while it is missing and "enroll" for a user that must enroll first
+func (m *Monitor) loginSecondFactor(w http.ResponseWriter, r *http.Request, p *principal) (result []byte, err error) {
+	twoFactorConfigMutex.Lock()
+	defer twoFactorConfigMutex.Unlock()
+	tf, err := loadTwoFactor(p)
+	if err != nil {
+		return
+	}
+	if tf == nil {
+		err = startSession(w, r, p)
+		if err != nil {
+			return
+		}
+		if !m.getTwoFactorPolicy().requires(p.Role) {
+			result = []byte("true")
+			return
+		}
+		err = setSessionValue(w, r, "enroll2fa", true)
+		result = []byte("enroll")
+		return
+	}
+	code := r.FormValue("otp")
+	if len(code) == 0 {
+		result = []byte("otp")
+		return
+	}
+	ok, err := m.checkCode(p, tf, code)
+	if err != nil || !ok {
+		result = []byte("false")
+		return
+	}
+	err = startSession(w, r, p)
+	if err != nil {
+		return
+	}
+	result = []byte("true")
+	return
+}
+
+func setSessionValue(w http.ResponseWriter, r *http.Request, key string, value interface{}) (err error) {
+	sess, err := globalSessions.SessionStart(w, r)
+	if err != nil {
+		return
+	}
+	defer sess.SessionRelease(w)
+	return sess.Set(key, value)
+}
+
+// enrollOnly tells the session may only enroll a second factor
+func enrollOnly(sess session.Store) bool {
+	v, _ := sess.Get("enroll2fa").(bool)
+	return v
+}
+
+// twoFactorPrincipal returns the user of the session of a two-factor request, the sessions that
+// may only enroll included
+func twoFactorPrincipal(w http.ResponseWriter, r *http.Request) (p *principal, ok bool) {
+	if !verifyLogin(w, r, false) {
+		return
+	}
+	if !safeMethod(r.Method) && !verifyCSRF(w, r) {
+		httputil.Fail(w, "Invalid CSRF token", http.StatusForbidden)
+		return
+	}
+	sess, _ := globalSessions.SessionStart(w, r)
+	defer sess.SessionRelease(w)
+	p, ok = sess.Get("principal").(*principal)
+	if !ok {
+		httputil.Fail(w, "Unauthorized", http.StatusFound)
+	}
+	return
+}
+
+type TwoFactorStatus struct {
+	Enabled     bool `json:"enabled"`
+	Required    bool `json:"required"`
+	BackupCodes int  `json:"backup_codes"`
+}
+
+func (m *Monitor) getTwoFactorStatus(w http.ResponseWriter, r *http.Request) (result []byte, err error, code int) {
+	p, ok := twoFactorPrincipal(w, r)
+	if !ok {
+		return
+	}
+	tf, err := loadTwoFactor(p)
+	if err != nil {
+		return
+	}
+	s := TwoFactorStatus{Required: m.getTwoFactorPolicy().requires(p.Role)}
+	if tf != nil {
+		s.Enabled = true
+		s.BackupCodes = len(tf.BackupCodes)
+	}
+	result, err = json.Marshal(s)
+	return
+}
+
+type TwoFactorEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// enrollTwoFactor starts an enrollment, it is enabled once a code of it is confirmed
+func (m *Monitor) enrollTwoFactor(w http.ResponseWriter, r *http.Request) (result []byte, err error, code int) {
+	p, ok := twoFactorPrincipal(w, r)
+	if !ok {
+		return
+	}
+	if r.Method != "POST" {
+		code = BAD_REQUEST
+		err = errors.New("please use post method")
+		return
+	}
+	tf, err := loadTwoFactor(p)
+	if err != nil {
+		return
+	}
+	if tf != nil {
+		code = BAD_REQUEST
+		err = errors.New("two-factor authentication is already enabled")
+		return
+	}
+	secret, err := newTOTPSecret()
+	if err != nil {
+		return
+	}
+	err = setSessionValue(w, r, "totp_pending", secret)
+	if err != nil {
+		return
+	}
+	result, err = json.Marshal(TwoFactorEnrollment{Secret: secret, URI: totpURI(p.Name, secret)})
+	return
+}
+
+type TwoFactorBackupCodes struct {
+	BackupCodes []string `json:"backup_codes"`
+}
+
+// confirmTwoFactor enables the enrollment of the session with a code of the authenticator app
+// and returns the backup codes, they are not shown again
+func (m *Monitor) confirmTwoFactor(w http.ResponseWriter, r *http.Request) (result []byte, err error, code int) {
+	p, ok := twoFactorPrincipal(w, r)
+	if !ok {
+		return
+	}
+	if r.Method != "POST" {
+		code = BAD_REQUEST
+		err = errors.New("please use post method")
+		return
+	}
+	sess, _ := globalSessions.SessionStart(w, r)
+	secret, _ := sess.Get("totp_pending").(string)
+	sess.SessionRelease(w)
+	if len(secret) == 0 {
+		code = BAD_REQUEST
+		err = errors.New("no enrollment started")
+		return
+	}
+	tf := &TwoFactor{Secret: secret}
+	if m.twoFactorFailures.locked(p.auditName()) || !tf.verifyTOTP(r.FormValue("code"), time.Now()) {
+		m.twoFactorFailures.add(p.auditName())
+		code = BAD_REQUEST
+		err = errors.New("wrong code")
+		return
+	}
+	codes, hashes, err := newBackupCodes()
+	if err != nil {
+		return
+	}
+	tf.BackupCodes = hashes
+	twoFactorConfigMutex.Lock()
+	err = saveTwoFactor(p, tf)
+	twoFactorConfigMutex.Unlock()
+	if err != nil {
+		return
+	}
+	// the session that could only enroll may do everything now, under a new id
+	sess, err = regenerateSession(w, r)
+	if err != nil {
+		return
+	}
+	sess.Delete("totp_pending")
+	sess.Delete("enroll2fa")
+	sess.SessionRelease(w)
+	result, err = json.Marshal(TwoFactorBackupCodes{BackupCodes: codes})
+	return
+}
+
+// verifiedTwoFactor returns the enrollment of the user of the session after checking the form value code
+func (m *Monitor) verifiedTwoFactor(w http.ResponseWriter, r *http.Request) (p *principal, tf *TwoFactor, err error, code int) {
+	p, ok := twoFactorPrincipal(w, r)
+	if !ok {
+		return
+	}
+	if r.Method != "POST" {
+		code = BAD_REQUEST
+		err = errors.New("please use post method")
+		return
+	}
+	tf, err = loadTwoFactor(p)
+	if err != nil {
+		return
+	}
+	if tf == nil {
+		code = BAD_REQUEST
+		err = errors.New("two-factor authentication is not enabled")
+		return
+	}
+	ok, err = m.checkCode(p, tf, r.FormValue("code"))
+	if err == nil && !ok {
+		code = BAD_REQUEST
+		err = errors.New("wrong code")
+	}
+	return
+}
+
+// disableTwoFactor removes the enrollment of the user, unless the policy requires it
+func (m *Monitor) disableTwoFactor(w http.ResponseWriter, r *http.Request) (result []byte, err error, code int) {
+	twoFactorConfigMutex.Lock()
+	defer twoFactorConfigMutex.Unlock()
+	p, tf, err, code := m.verifiedTwoFactor(w, r)
+	if tf == nil || err != nil {
+		return
+	}
+	if m.getTwoFactorPolicy().requires(p.Role) {
+		code = http.StatusForbidden
+		err = errors.New("two-factor authentication is required")
+		return
+	}
+	err = saveTwoFactor(p, nil)
+	if err != nil {
+		return
+	}
+	result = []byte("true")
+	return
+}
+
+// renewBackupCodes replaces the backup codes of the user
+func (m *Monitor) renewBackupCodes(w http.ResponseWriter, r *http.Request) (result []byte, err error, code int) {
+	twoFactorConfigMutex.Lock()
+	defer twoFactorConfigMutex.Unlock()
+	p, tf, err, code := m.verifiedTwoFactor(w, r)
+	if tf == nil || err != nil {
+		return
+	}
+	codes, hashes, err := newBackupCodes()
+	if err != nil {
+		return
+	}
+	tf.BackupCodes = hashes
+	err = saveTwoFactor(p, tf)
+	if err != nil {
+		return
+	}
+	result, err = json.Marshal(TwoFactorBackupCodes{BackupCodes: codes})
+	return
+}
+
+// resetTwoFactor removes the enrollment of the account (name) that lost its phone and backup
+// codes, only the owner may do it
+func (m *Monitor) resetTwoFactor(w http.ResponseWriter, r *http.Request) (result []byte, err error, code int) {
+	if !m.authorize(w, r, RoleAdmin, "") {
+		return
+	}
+	if !isOwnerSession(w, r) {
+		httputil.Fail(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if r.Method != "POST" {
+		code = BAD_REQUEST
+		err = errors.New("please use post method")
+		return
+	}
+	a, ok := findAccount(r.FormValue("name"))
+	if !ok {
+		code = NOT_FOUND
+		err = errors.New("account not found")
+		return
+	}
+	twoFactorConfigMutex.Lock()
+	err = saveTwoFactor(a.principal(), nil)
+	twoFactorConfigMutex.Unlock()
+	if err != nil {
+		return
+	}
+	result = []byte("true")
+	return
+}
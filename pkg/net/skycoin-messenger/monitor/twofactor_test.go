@@ -0,0 +1,250 @@
+package monitor
+
+import (
+	"encoding/base32"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+// the secret of the test vectors of RFC 6238 for SHA1
+var rfcSecret = []byte("12345678901234567890")
+
+func TestTOTPCode(t *testing.T) {
+	// the last 6 of the 8 digits of the vectors
+	for _, v := range []struct {
+		time int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	} {
+		if code := totpCode(rfcSecret, v.time/totpPeriod); code != v.code {
+			t.Errorf("at %d: got %s, want %s", v.time, code, v.code)
+		}
+	}
+}
+
+func newTestTwoFactor() *TwoFactor {
+	return &TwoFactor{Secret: base32.StdEncoding.EncodeToString(rfcSecret)}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	tf := newTestTwoFactor()
+	now := time.Unix(1111111111, 0)
+	step := now.Unix() / totpPeriod
+	if tf.verifyTOTP(totpCode(rfcSecret, step-2), now) {
+		t.Fatal("a code two steps old accepted")
+	}
+	if !tf.verifyTOTP(totpCode(rfcSecret, step-1), now) {
+		t.Fatal("a code of the previous step refused")
+	}
+	if !tf.verifyTOTP(totpCode(rfcSecret, step), now) {
+		t.Fatal("a current code refused")
+	}
+	if tf.verifyTOTP(totpCode(rfcSecret, step), now) {
+		t.Fatal("a code accepted twice")
+	}
+	if tf.verifyTOTP(totpCode(rfcSecret, step-1), now) {
+		t.Fatal("a code older than the last accepted one accepted")
+	}
+	if !tf.verifyTOTP(totpCode(rfcSecret, step+1), now) {
+		t.Fatal("a code of the next step refused")
+	}
+	if tf.verifyTOTP("12345", now) || tf.verifyTOTP("", now) {
+		t.Fatal("a short code accepted")
+	}
+}
+
+func TestBackupCodes(t *testing.T) {
+	codes, hashes, err := newBackupCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != backupCodeCount || len(hashes) != backupCodeCount {
+		t.Fatalf("%d codes", len(codes))
+	}
+	tf := newTestTwoFactor()
+	tf.BackupCodes = hashes
+	now := time.Now()
+	if !tf.verify(codes[3], now) {
+		t.Fatal("a backup code refused")
+	}
+	if tf.verify(codes[3], now) {
+		t.Fatal("a backup code accepted twice")
+	}
+	if len(tf.BackupCodes) != backupCodeCount-1 {
+		t.Fatalf("%d codes left", len(tf.BackupCodes))
+	}
+	// typed without the dash
+	c := codes[5][:5] + " " + codes[5][6:]
+	if !tf.verify(c, now) {
+		t.Fatalf("%q refused", c)
+	}
+	if tf.verify("00000-00000", now) {
+		t.Fatal("a wrong code accepted")
+	}
+	if len(tf.BackupCodes) != backupCodeCount-2 {
+		t.Fatalf("%d codes left", len(tf.BackupCodes))
+	}
+}
+
+func TestTwoFactorLockout(t *testing.T) {
+	tm := newTestMonitor(t, &User{TwoFactor: newTestTwoFactor()})
+	defer tm.close()
+	code := func() string {
+		return totpCode(rfcSecret, time.Now().Unix()/totpPeriod)
+	}
+	check := func(code string) bool {
+		tf, err := loadTwoFactor(owner)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ok, err := tm.checkCode(owner, tf, code)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ok
+	}
+
+	// a right code resets the count of wrong ones
+	for i := 0; i < twoFactorMaxFailures-1; i++ {
+		if check("000000") {
+			t.Fatal("a wrong code accepted")
+		}
+	}
+	if !check(code()) {
+		t.Fatal("a right code refused below the threshold")
+	}
+	for i := 0; i < twoFactorMaxFailures-1; i++ {
+		check("000000")
+	}
+	if tm.twoFactorFailures.locked(owner.auditName()) {
+		t.Fatal("locked out below the threshold after a right code")
+	}
+
+	check("000000")
+	if !tm.twoFactorFailures.locked(owner.auditName()) {
+		t.Fatalf("not locked out after %d wrong codes", twoFactorMaxFailures)
+	}
+	// the code of the next step would be right
+	if check(totpCode(rfcSecret, time.Now().Unix()/totpPeriod+1)) {
+		t.Fatal("a right code accepted while locked out")
+	}
+	tm.twoFactorFailures.until[owner.auditName()] = time.Now()
+	if !check(totpCode(rfcSecret, time.Now().Unix()/totpPeriod+1)) {
+		t.Fatal("a right code refused after the lockout")
+	}
+}
+
+func TestKeyLoginTwoFactor(t *testing.T) {
+	pk, sk := cipher.GenerateKeyPair()
+	tm := newTestMonitor(t, &User{Keys: []string{pk.Hex()}, TwoFactor: newTestTwoFactor()})
+	defer tm.close()
+	c := tm.anonymous(t)
+	keyLogin := func(otp string) string {
+		status, nonce := c.forge(http.MethodGet, "/auth/challenge", nil)
+		if status != http.StatusOK {
+			t.Fatalf("challenge: %d %s", status, nonce)
+		}
+		sig := cipher.SignHash(cipher.SumSHA256([]byte(nonce)), sk)
+		status, body := c.forge(http.MethodPost, "/auth/keyLogin", url.Values{
+			"key": {pk.Hex()}, "nonce": {nonce}, "sig": {sig.Hex()}, "otp": {otp}})
+		if status != http.StatusOK {
+			t.Fatalf("key login: %d %s", status, body)
+		}
+		return body
+	}
+
+	if body := keyLogin(""); body != "otp" {
+		t.Fatalf("a key login without the code: got %s, want otp", body)
+	}
+	if status, _ := c.send(http.MethodGet, "/auth/listTokens", nil); status == http.StatusOK {
+		t.Fatal("a session started without the code")
+	}
+	if body := keyLogin("000000"); body != "false" {
+		t.Fatalf("a key login with a wrong code: got %s", body)
+	}
+	if body := keyLogin(totpCode(rfcSecret, time.Now().Unix()/totpPeriod)); body != "true" {
+		t.Fatalf("a key login with the code: got %s", body)
+	}
+	if status, body := c.send(http.MethodGet, "/auth/listTokens", nil); status != http.StatusOK {
+		t.Fatalf("the session of the key login: %d %s", status, body)
+	}
+}
+
+func TestTwoFactorSessionID(t *testing.T) {
+	tm := newTestMonitor(t, &User{TwoFactor: newTestTwoFactor()})
+	defer tm.close()
+	login := func(c *client, form url.Values) string {
+		status, body := c.forge(http.MethodPost, "/login", form)
+		if status != http.StatusOK {
+			t.Fatalf("login: %d %s", status, body)
+		}
+		return body
+	}
+	// a session planted in the browser
+	attacker := tm.anonymous(t)
+	attacker.forge(http.MethodPost, "/checkLogin", nil)
+	sid := attacker.session()
+	c := tm.anonymous(t)
+	c.fixate(sid)
+
+	// the password alone starts no session, the code a new one
+	if body := login(c, url.Values{"pass": {testPass}}); body != "otp" {
+		t.Fatalf("login without the code: %s", body)
+	}
+	if body := login(c, url.Values{"pass": {testPass}, "otp": {totpCode(rfcSecret, time.Now().Unix()/totpPeriod)}}); body != "true" {
+		t.Fatalf("login with the code: %s", body)
+	}
+	if c.session() == sid {
+		t.Fatal("the login with the code kept the planted session")
+	}
+	if status, _ := attacker.send(http.MethodGet, "/auth/listTokens", nil); status == http.StatusOK {
+		t.Fatal("the planted session logged in")
+	}
+
+	// an enrollment the policy requires gets a new id once its code is confirmed
+	if err := saveTwoFactor(owner, nil); err != nil {
+		t.Fatal(err)
+	}
+	tm.SetTwoFactorPolicy(TwoFactorAll)
+	c = tm.anonymous(t)
+	if body := login(c, url.Values{"pass": {testPass}}); body != "enroll" {
+		t.Fatalf("login to enroll: %s", body)
+	}
+	enrolling := tm.anonymous(t)
+	enrolling.fixate(c.session())
+	if status, _ := c.send(http.MethodGet, "/auth/listTokens", nil); status != http.StatusForbidden {
+		t.Fatalf("the session of the enrollment: %d", status)
+	}
+	status, body := c.send(http.MethodPost, "/auth/2fa/enroll", nil)
+	var enrollment TwoFactorEnrollment
+	if status != http.StatusOK || json.Unmarshal([]byte(body), &enrollment) != nil {
+		t.Fatalf("enroll: %d %s", status, body)
+	}
+	secret, err := base32.StdEncoding.DecodeString(enrollment.Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status, body := c.send(http.MethodPost, "/auth/2fa/confirm", url.Values{"code": {totpCode(secret, time.Now().Unix()/totpPeriod)}}); status != http.StatusOK {
+		t.Fatalf("confirm: %d %s", status, body)
+	}
+	if c.session() == enrolling.session() {
+		t.Fatal("the confirmed enrollment kept the session id")
+	}
+	if status, body := c.send(http.MethodGet, "/auth/listTokens", nil); status != http.StatusOK {
+		t.Fatalf("the session after the enrollment: %d %s", status, body)
+	}
+	if status, _ := enrolling.send(http.MethodGet, "/auth/listTokens", nil); status == http.StatusOK {
+		t.Fatal("the id of the enrollment logged in")
+	}
+}
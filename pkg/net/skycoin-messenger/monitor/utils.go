@@ -10,13 +10,21 @@ import (
 	"path/filepath"
 	"time"
 
+	"github.com/skycoin/skycoin/src/cipher"
 	"golang.org/x/crypto/bcrypt"
 )
 
 // User struct contains the user configuration, specifically the users
-// hashed password (Pass)
+// hashed password (Pass), the public keys allowed to login by
+// signing a challenge (Keys), the accounts with limited roles (Accounts)
+// and the node groups (Groups) mapping a group name to node keys
 type User struct {
-	Pass string
+	Pass     string
+	Keys     []string            `json:",omitempty"`
+	Accounts []Account           `json:",omitempty"`
+	Groups   map[string][]string `json:",omitempty"`
+	// second factor of the owner login, nil if not enrolled
+	TwoFactor *TwoFactor `json:",omitempty"`
 }
 
 var user *User
@@ -120,3 +128,13 @@ func getRandomString(len int) string {
 func isDefaultPass(pass string) bool {
 	return pass == "1234"
 }
+
+// pubKeyFromHex decodes the hex key (s) of a request or a config,
+// cipher.PubKeyFromHex panics on a key of the wrong length
+func pubKeyFromHex(s string) (key cipher.PubKey, err error) {
+	if len(s) != 2*len(key) {
+		err = errors.New("Invalid public key length")
+		return
+	}
+	return cipher.PubKeyFromHex(s)
+}
@@ -15,9 +15,10 @@ const (
 
 const (
 	OP_ACCOUNT = iota // query created keys
-	OP_REG // create key
-	OP_LOGIN // use key to login
-	OP_SEND // send msg to others
-	OP_ACK // ack msg
+	OP_REG            // create key
+	OP_LOGIN          // use key to login
+	OP_SEND           // send msg to others
+	OP_ACK            // ack msg
+	OP_ATTACH         // send attachment to others, pushes the progress of the transfers
 	OP_SIZE
 )
@@ -3,6 +3,7 @@ package msg
 import (
 	"sync"
 
+	"github.com/skycoin/skywire/pkg/net/skycoin-messenger/attach"
 	"github.com/skycoin/skywire/pkg/net/skycoin-messenger/factory"
 )
 
@@ -19,6 +20,8 @@ type OPer interface {
 	SetFactory(factory *factory.MessengerFactory)
 	PushLoop(*factory.Connection)
 	Push(op byte, d interface{})
+	// transfers of the attachments, nil if not logged in or disabled
+	GetTransfers() *attach.Transfers
 }
 
 func GetOP(opn int) (op OP) {
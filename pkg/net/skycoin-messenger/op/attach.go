@@ -0,0 +1,38 @@
+package op
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/skycoin/skycoin/src/cipher"
+	"github.com/skycoin/skywire/pkg/net/skycoin-messenger/msg"
+)
+
+// Attach sends the attachment uploaded to the store before
+type Attach struct {
+	PublicKey string
+	Hash      string
+	Name      string
+	Type      string
+}
+
+func init() {
+	msg.OP_POOL[msg.OP_ATTACH] = &sync.Pool{
+		New: func() interface{} {
+			return new(Attach)
+		},
+	}
+}
+
+func (a *Attach) Execute(c msg.OPer) error {
+	key, err := cipher.PubKeyFromHex(a.PublicKey)
+	if err != nil {
+		return err
+	}
+	t := c.GetTransfers()
+	if t == nil {
+		return errors.New("attachments are disabled")
+	}
+	_, err = t.Send(key, a.Hash, a.Name, a.Type)
+	return err
+}
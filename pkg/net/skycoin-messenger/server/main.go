@@ -6,20 +6,43 @@ import (
 	"os/signal"
 
 	"path/filepath"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/skycoin/skycoin/src/util/file"
 	"github.com/skycoin/skywire/pkg/net/skycoin-messenger/factory"
+	"github.com/skycoin/skywire/pkg/trace"
 )
 
 var (
 	address  string
 	seedPath string
+
+	handshake factory.HandshakeProtection
+	queries   factory.QueryLimits
+	sends     factory.SendLimits
+
+	maxClockSkew  time.Duration
+	traceEndpoint string
 )
 
 func parseFlags() {
 	flag.StringVar(&address, "address", ":8080", "address to listen on")
 	flag.StringVar(&seedPath, "seed-path", filepath.Join(file.UserHome(), ".skyim", "server", "keys.json"), "dir path to save seeds info")
+	flag.Int64Var(&handshake.CookieThreshold, "handshake-cookie-threshold", 256, "require a cookie round trip once this many handshakes are in progress, 0 to disable")
+	flag.Float64Var(&handshake.IPRate, "handshake-ip-rate", 10, "handshakes per second allowed from an ip, 0 for no limit")
+	flag.Float64Var(&handshake.KeyRate, "handshake-key-rate", 2, "handshakes per second allowed for a public key, 0 for no limit")
+	flag.IntVar(&handshake.Burst, "handshake-burst", 20, "handshakes allowed at once above the rates")
+	flag.Float64Var(&queries.Rate, "query-rate", 5, "service queries per second allowed for a node, 0 for no limit")
+	flag.IntVar(&queries.Burst, "query-burst", 20, "service queries allowed at once above the rate")
+	flag.IntVar(&queries.HourlyQuota, "query-hourly-quota", 3600, "service queries allowed for a node per hour, 0 for no quota")
+	flag.Float64Var(&sends.Rate, "send-rate", 200, "messages per second a client may send to the others, 0 for no limit")
+	flag.IntVar(&sends.Burst, "send-burst", 400, "messages a client may send at once above the rate")
+	flag.IntVar(&sends.MaxQueued, "send-max-queued", 1<<20, "bytes queued for a client, the messages above are dropped, 0 to write them from the connection of the sender")
+	flag.DurationVar(&sends.StallTimeout, "send-stall-timeout", 30*time.Second, "evict a client that has not taken a message queued for this long, 0 to keep it")
+	flag.Int64Var(&sends.MaxTotalQueued, "send-max-total-queued", 256<<20, "bytes queued for all the clients, above it the client with the most queued is evicted, 0 for no limit")
+	flag.StringVar(&traceEndpoint, "trace-endpoint", "", "OTLP/HTTP endpoint to export the spans of the forwarded transport setups to, e.g. http://localhost:4318/v1/traces")
+	flag.DurationVar(&maxClockSkew, "max-clock-skew", 10*time.Minute, "ignore the services of nodes whose clock is further off, 0 to accept any")
 	flag.Parse()
 }
 
@@ -32,7 +55,20 @@ func main() {
 	f := factory.NewMessengerFactory()
 	f.SetDefaultSeedConfigPath(seedPath)
 	f.SetLoggerLevel(factory.DebugLevel)
-	err := f.Listen(address)
+	err := f.SetHandshakeProtection(handshake)
+	if err != nil {
+		log.Error(err)
+		os.Exit(1)
+	}
+	f.SetQueryLimits(queries)
+	f.SetSendLimits(sends)
+	f.SetMaxClockSkew(maxClockSkew)
+	if len(traceEndpoint) > 0 {
+		tracer := trace.NewTracer("skywire-discovery", traceEndpoint)
+		defer tracer.Close()
+		f.SetTracer(tracer)
+	}
+	err = f.Listen(address)
 	log.Debugf("listen on %s", address)
 	if err != nil {
 		log.Error(err)
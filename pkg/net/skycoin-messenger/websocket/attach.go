@@ -0,0 +1,25 @@
+package websocket
+
+import (
+	"sync"
+
+	"github.com/skycoin/skywire/pkg/net/skycoin-messenger/attach"
+)
+
+var (
+	attachmentStore      *attach.Store
+	attachmentStoreMutex sync.RWMutex
+)
+
+// SetAttachmentStore enables the attachments of the clients, kept in store
+func SetAttachmentStore(store *attach.Store) {
+	attachmentStoreMutex.Lock()
+	attachmentStore = store
+	attachmentStoreMutex.Unlock()
+}
+
+func getAttachmentStore() *attach.Store {
+	attachmentStoreMutex.RLock()
+	defer attachmentStoreMutex.RUnlock()
+	return attachmentStore
+}
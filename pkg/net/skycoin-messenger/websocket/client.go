@@ -11,6 +11,7 @@ import (
 	"github.com/gorilla/websocket"
 	log "github.com/sirupsen/logrus"
 	"github.com/skycoin/skycoin/src/cipher"
+	"github.com/skycoin/skywire/pkg/net/skycoin-messenger/attach"
 	net "github.com/skycoin/skywire/pkg/net/skycoin-messenger/factory"
 	"github.com/skycoin/skywire/pkg/net/skycoin-messenger/msg"
 	_ "github.com/skycoin/skywire/pkg/net/skycoin-messenger/op"
@@ -19,6 +20,8 @@ import (
 type Client struct {
 	sync.RWMutex
 	factory *net.MessengerFactory
+	// of the connection to the messenger server
+	transfers *attach.Transfers
 
 	push   chan interface{}
 	Logger *log.Entry
@@ -44,6 +47,39 @@ func (c *Client) SetFactory(factory *net.MessengerFactory) {
 	c.Unlock()
 }
 
+func (c *Client) GetTransfers() *attach.Transfers {
+	c.RLock()
+	defer c.RUnlock()
+	return c.transfers
+}
+
+// setTransfers starts the transfers of the attachments over the connection to the messenger server
+func (c *Client) setTransfers(conn *net.Connection) (t *attach.Transfers) {
+	store := getAttachmentStore()
+	if store != nil {
+		t = attach.NewTransfers(store, conn.GetRemoteAddr().String(),
+			func(to cipher.PubKey, m []byte) error {
+				return conn.Send(to, m)
+			},
+			func(e attach.Event) {
+				// the websocket may close while the transfers stop
+				defer func() {
+					if err := recover(); err != nil {
+						c.Logger.Debugf("push attachment event recovered err %v", err)
+					}
+				}()
+				c.Push(msg.OP_ATTACH, &e)
+			})
+	}
+	c.Lock()
+	if c.transfers != nil {
+		c.transfers.Close()
+	}
+	c.transfers = t
+	c.Unlock()
+	return
+}
+
 type pushMsg struct {
 	op   byte
 	data interface{}
@@ -69,6 +105,7 @@ func (c *Client) PushLoop(conn *net.Connection) {
 		}
 	}()
 	key := conn.GetKey()
+	transfers := c.setTransfers(conn)
 	c.Push(msg.OP_LOGIN, &msg.Reg{PublicKey: key.Hex()})
 	for {
 		select {
@@ -83,6 +120,14 @@ func (c *Client) PushLoop(conn *net.Connection) {
 					continue
 				}
 				key := cipher.NewPubKey(m[net.SEND_MSG_PUBLIC_KEY_BEGIN:net.SEND_MSG_PUBLIC_KEY_END])
+				if attach.IsControl(m[net.SEND_MSG_META_END:]) {
+					if transfers != nil {
+						if err := transfers.Handle(key, m[net.SEND_MSG_META_END:]); err != nil {
+							c.Logger.Errorf("attachment from %x: %v", key, err)
+						}
+					}
+					continue
+				}
 				c.Push(msg.OP_SEND, msg.GetPushMsg(key.Hex(), string(m[net.SEND_MSG_META_END:])))
 			}
 		}
@@ -95,6 +140,12 @@ func (c *Client) readLoop() {
 			c.Logger.Errorf("readLoop recovered err %v", err)
 		}
 		c.conn.Close()
+		c.Lock()
+		if c.transfers != nil {
+			c.transfers.Close()
+			c.transfers = nil
+		}
+		c.Unlock()
 		close(c.push)
 	}()
 	c.conn.SetReadLimit(maxMessageSize)
@@ -0,0 +1,27 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Field64 is a 64-bit field accessed with sync/atomic, by its offset in the allocated struct
+type Field64 struct {
+	Name   string
+	Offset uintptr
+}
+
+// CheckAlign64 returns an error naming the fields that are not 64-bit aligned, sync/atomic
+// panics on them on 32-bit platforms where only the start of an allocated struct is aligned
+func CheckAlign64(fields ...Field64) error {
+	var misaligned []string
+	for _, f := range fields {
+		if f.Offset%8 != 0 {
+			misaligned = append(misaligned, fmt.Sprintf("%s at offset %d", f.Name, f.Offset))
+		}
+	}
+	if len(misaligned) > 0 {
+		return fmt.Errorf("64-bit fields not aligned: %s", strings.Join(misaligned, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,12 @@
+package util
+
+import "testing"
+
+func TestCheckAlign64(t *testing.T) {
+	if err := CheckAlign64(Field64{"a", 0}, Field64{"b", 8}, Field64{"c", 64}); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckAlign64(Field64{"a", 0}, Field64{"b", 12}); err == nil {
+		t.Fatal("field at offset 12 accepted")
+	}
+}
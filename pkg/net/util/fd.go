@@ -0,0 +1,113 @@
+package util
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrTooManyFiles refuses a new connection, listener or pipe when the process is close to
+// its open file limit, so the ones already open keep working
+var ErrTooManyFiles = errors.New("too many open files, refused near the file descriptor limit")
+
+const (
+	// the open files are counted again after this interval or when the estimate is near the limit
+	fdSampleInterval = time.Second
+	// descriptors kept for logs, config files and dns lookups, at least fdMinReserve
+	fdReserveDivisor = 20
+	fdMinReserve     = 16
+)
+
+// FDStats is the file descriptor headroom of the process
+type FDStats struct {
+	// open file limit of the process, 0 when unknown
+	Limit uint64 `json:"limit"`
+	// descriptors open at the last count, and reserved since
+	Open int `json:"open"`
+	// descriptors kept for files, not handed out to connections
+	Reserve int `json:"reserve"`
+	// descriptors left for new connections
+	Headroom int `json:"headroom"`
+	// connections, listeners and pipes refused near the limit
+	Refused uint64 `json:"refused"`
+}
+
+type fdGuard struct {
+	limit func() uint64
+	count func() (int, error)
+
+	open      int
+	sampledAt time.Time
+	refused   uint64
+
+	sync.Mutex
+}
+
+var fds = &fdGuard{limit: fdLimit, count: countFDs}
+
+// ReserveFDs checks that n more descriptors may be opened and counts them as open until
+// the next count, it fails with ErrTooManyFiles when they would cut into the reserve
+func ReserveFDs(n int) error {
+	return fds.reserve(n)
+}
+
+// GetFDStats returns the file descriptor headroom of the process
+func GetFDStats() FDStats {
+	return fds.stats()
+}
+
+func fdReserve(limit uint64) int {
+	r := int(limit / fdReserveDivisor)
+	if r < fdMinReserve {
+		r = fdMinReserve
+	}
+	return r
+}
+
+// sample counts the open descriptors, closed ones are only seen here
+func (g *fdGuard) sample(now time.Time) {
+	n, err := g.count()
+	if err != nil {
+		return
+	}
+	g.open = n
+	g.sampledAt = now
+}
+
+func (g *fdGuard) reserve(n int) error {
+	limit := g.limit()
+	if limit == 0 {
+		return nil
+	}
+	g.Lock()
+	defer g.Unlock()
+	now := time.Now()
+	reserve := fdReserve(limit)
+	if now.Sub(g.sampledAt) > fdSampleInterval || uint64(g.open+n+reserve) > limit {
+		g.sample(now)
+	}
+	if uint64(g.open+n+reserve) > limit {
+		g.refused++
+		return ErrTooManyFiles
+	}
+	g.open += n
+	return nil
+}
+
+func (g *fdGuard) stats() (s FDStats) {
+	s.Limit = g.limit()
+	g.Lock()
+	defer g.Unlock()
+	g.sample(time.Now())
+	s.Open = g.open
+	s.Refused = g.refused
+	if s.Limit == 0 {
+		return
+	}
+	s.Reserve = fdReserve(s.Limit)
+	s.Headroom = int(s.Limit) - s.Reserve - s.Open
+	if s.Headroom < 0 {
+		s.Headroom = 0
+	}
+	return
+}
@@ -0,0 +1,22 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd,!dragonfly
+
+package util
+
+import "errors"
+
+var ErrFDLimitUnsupported = errors.New("open file limits are not supported on this platform")
+
+// RaiseFDLimit is not available on this platform.
+func RaiseFDLimit() (uint64, error) {
+	return 0, ErrFDLimitUnsupported
+}
+
+// no limit, nothing is refused
+func fdLimit() uint64 {
+	return 0
+}
+
+func countFDs() (int, error) {
+	return 0, ErrFDLimitUnsupported
+}
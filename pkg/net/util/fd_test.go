@@ -0,0 +1,33 @@
+package util
+
+import "testing"
+
+func TestFDGuard(t *testing.T) {
+	open := 60
+	g := &fdGuard{
+		limit: func() uint64 { return 100 },
+		count: func() (int, error) { return open, nil },
+	}
+	// 100 - 16 reserved - 60 open
+	for i := 0; i < 24; i++ {
+		if err := g.reserve(1); err != nil {
+			t.Fatalf("reserve %d: %v", i, err)
+		}
+		open++
+	}
+	if err := g.reserve(1); err != ErrTooManyFiles {
+		t.Fatalf("reserve into the reserve: %v", err)
+	}
+	if s := g.stats(); s.Headroom != 0 || s.Refused != 1 || s.Reserve != 16 {
+		t.Fatalf("stats %+v", s)
+	}
+	// closed descriptors are counted again near the limit
+	open = 70
+	if err := g.reserve(10); err != nil {
+		t.Fatal(err)
+	}
+	open += 10
+	if s := g.stats(); s.Open != 80 || s.Headroom != 4 {
+		t.Fatalf("stats %+v", s)
+	}
+}
@@ -0,0 +1,59 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+// +build linux darwin freebsd netbsd openbsd dragonfly
+
+package util
+
+import (
+	"os"
+	"runtime"
+
+	"golang.org/x/sys/unix"
+)
+
+// RaiseFDLimit raises the soft open file limit of the process to its hard limit
+// and returns the new limit.
+func RaiseFDLimit() (limit uint64, err error) {
+	var rl unix.Rlimit
+	err = unix.Getrlimit(unix.RLIMIT_NOFILE, &rl)
+	if err != nil {
+		return
+	}
+	limit = uint64(rl.Cur)
+	if rl.Cur >= rl.Max {
+		return
+	}
+	rl.Cur = rl.Max
+	err = unix.Setrlimit(unix.RLIMIT_NOFILE, &rl)
+	if err != nil {
+		return
+	}
+	limit = uint64(rl.Cur)
+	return
+}
+
+func fdLimit() uint64 {
+	var rl unix.Rlimit
+	if unix.Getrlimit(unix.RLIMIT_NOFILE, &rl) != nil {
+		return 0
+	}
+	return uint64(rl.Cur)
+}
+
+func countFDs() (n int, err error) {
+	dir := "/dev/fd"
+	if runtime.GOOS == "linux" {
+		dir = "/proc/self/fd"
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	names, err := d.Readdirnames(-1)
+	if err != nil {
+		return
+	}
+	// without the descriptor of the directory
+	n = len(names) - 1
+	return
+}
@@ -0,0 +1,57 @@
+package util
+
+import (
+	"crypto/subtle"
+
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+// Wipe overwrites b with zeros so key material does not linger in memory
+// after it is no longer needed.
+func Wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// WipeSecKey zeroes the secret key in place.
+func WipeSecKey(sk *cipher.SecKey) {
+	if sk == nil {
+		return
+	}
+	Wipe(sk[:])
+}
+
+// ConstantTimeEqual compares a and b in time depending only on their
+// lengths, use it for every comparison involving secrets.
+func ConstantTimeEqual(a, b []byte) bool {
+	return subtle.ConstantTimeCompare(a, b) == 1
+}
+
+// ConstantTimeEqualString is ConstantTimeEqual for tokens and passwords.
+func ConstantTimeEqualString(a, b string) bool {
+	return ConstantTimeEqual([]byte(a), []byte(b))
+}
+
+// SecKeyEqual compares two secret keys in constant time.
+func SecKeyEqual(a, b cipher.SecKey) bool {
+	return ConstantTimeEqual(a[:], b[:])
+}
+
+// LockSecKey prevents the memory of the secret key from being swapped to disk
+// where the platform supports it. The key must not be copied afterwards.
+func LockSecKey(sk *cipher.SecKey) error {
+	if sk == nil {
+		return nil
+	}
+	return LockMemory(sk[:])
+}
+
+// UnlockSecKey wipes the secret key and releases its memory lock.
+func UnlockSecKey(sk *cipher.SecKey) error {
+	if sk == nil {
+		return nil
+	}
+	WipeSecKey(sk)
+	return UnlockMemory(sk[:])
+}
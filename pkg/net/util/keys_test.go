@@ -0,0 +1,40 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+func TestWipeSecKey(t *testing.T) {
+	_, sk := cipher.GenerateKeyPair()
+	WipeSecKey(&sk)
+	if sk != (cipher.SecKey{}) {
+		t.Fatalf("sk not wiped %x", sk[:])
+	}
+	WipeSecKey(nil)
+}
+
+func TestConstantTimeEqual(t *testing.T) {
+	cases := []struct {
+		a, b  string
+		equal bool
+	}{
+		{"", "", true},
+		{"token", "token", true},
+		{"token", "tokem", false},
+		{"token", "token1", false},
+		{"", "token", false},
+	}
+	for _, c := range cases {
+		if ConstantTimeEqualString(c.a, c.b) != c.equal {
+			t.Fatalf("ConstantTimeEqualString(%q, %q) != %v", c.a, c.b, c.equal)
+		}
+	}
+
+	_, sk1 := cipher.GenerateKeyPair()
+	_, sk2 := cipher.GenerateKeyPair()
+	if !SecKeyEqual(sk1, sk1) || SecKeyEqual(sk1, sk2) {
+		t.Fatal("SecKeyEqual mismatch")
+	}
+}
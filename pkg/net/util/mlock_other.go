@@ -0,0 +1,18 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd,!dragonfly
+
+package util
+
+import "errors"
+
+var ErrMlockUnsupported = errors.New("memory locking is not supported on this platform")
+
+// LockMemory is not available on this platform.
+func LockMemory(b []byte) error {
+	return ErrMlockUnsupported
+}
+
+// UnlockMemory is not available on this platform.
+func UnlockMemory(b []byte) error {
+	return ErrMlockUnsupported
+}
@@ -0,0 +1,22 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+// +build linux darwin freebsd netbsd openbsd dragonfly
+
+package util
+
+import "golang.org/x/sys/unix"
+
+// LockMemory locks b into RAM so it is never written to swap.
+func LockMemory(b []byte) error {
+	if len(b) == 0 {
+		return nil
+	}
+	return unix.Mlock(b)
+}
+
+// UnlockMemory releases a lock taken by LockMemory.
+func UnlockMemory(b []byte) error {
+	if len(b) == 0 {
+		return nil
+	}
+	return unix.Munlock(b)
+}
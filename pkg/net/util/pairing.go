@@ -0,0 +1,67 @@
+package util
+
+import (
+	"crypto/rand"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+// PairingCodeTTL is how long a node accepts its pairing code
+const PairingCodeTTL = 10 * time.Minute
+
+// the sides of a pairing, each signs the code and the key of the other side
+const (
+	PairingManager = "manager"
+	PairingNode    = "node"
+)
+
+// no 0, O, 1 and I, a code is read off a console and typed in
+const pairingAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// NewPairingCode returns a random code of 8 characters, written as XXXX-XXXX
+func NewPairingCode() (code string, err error) {
+	b := make([]byte, 8)
+	_, err = rand.Read(b)
+	if err != nil {
+		return
+	}
+	for i := range b {
+		b[i] = pairingAlphabet[int(b[i])%len(pairingAlphabet)]
+	}
+	code = string(b[:4]) + "-" + string(b[4:])
+	return
+}
+
+// NormalizePairingCode returns the code as NewPairingCode writes it, whatever case and
+// separators were typed
+func NormalizePairingCode(code string) string {
+	code = strings.ToUpper(code)
+	code = strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, code)
+	if len(code) != 8 {
+		return code
+	}
+	return code[:4] + "-" + code[4:]
+}
+
+func pairingHash(side, code, peer string) cipher.SHA256 {
+	return cipher.SumSHA256([]byte(fmt.Sprintf("skywire-pairing\n%s\n%s\n%s", side, NormalizePairingCode(code), peer)))
+}
+
+// SignPairing signs the code and the hex key of the other side (peer) as the side, which
+// proves the signer knows the code and owns its key
+func SignPairing(sk cipher.SecKey, side, code, peer string) cipher.Sig {
+	return cipher.SignHash(pairingHash(side, code, peer), sk)
+}
+
+// VerifyPairing checks the signature of the side with key of the code and the hex key peer
+func VerifyPairing(key cipher.PubKey, sig cipher.Sig, side, code, peer string) error {
+	return cipher.VerifySignature(key, sig, pairingHash(side, code, peer))
+}
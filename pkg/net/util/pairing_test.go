@@ -0,0 +1,39 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+func TestPairingCode(t *testing.T) {
+	code, err := NewPairingCode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(code) != 9 || code[4] != '-' {
+		t.Fatalf("code %s", code)
+	}
+	typed := "  " + code[:4] + " " + code[5:]
+	if NormalizePairingCode(typed) != code {
+		t.Errorf("%q normalized to %q", typed, NormalizePairingCode(typed))
+	}
+}
+
+func TestVerifyPairing(t *testing.T) {
+	manager, sk := cipher.GenerateKeyPair()
+	node, _ := cipher.GenerateKeyPair()
+	sig := SignPairing(sk, PairingManager, "ABCD-EFGH", node.Hex())
+	if err := VerifyPairing(manager, sig, PairingManager, "abcdefgh", node.Hex()); err != nil {
+		t.Errorf("signed: %v", err)
+	}
+	if err := VerifyPairing(manager, sig, PairingManager, "ABCD-EFGJ", node.Hex()); err == nil {
+		t.Error("other code verified")
+	}
+	if err := VerifyPairing(manager, sig, PairingNode, "ABCD-EFGH", node.Hex()); err == nil {
+		t.Error("other side verified")
+	}
+	if err := VerifyPairing(manager, sig, PairingManager, "ABCD-EFGH", manager.Hex()); err == nil {
+		t.Error("other peer verified")
+	}
+}
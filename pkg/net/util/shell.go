@@ -0,0 +1,151 @@
+package util
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+// headers carrying the signature of a manager for the shell of a node
+const (
+	ShellKeyHeader   = "manager-key"
+	ShellTimeHeader  = "manager-time"
+	ShellNonceHeader = "manager-nonce"
+	ShellSigHeader   = "manager-sig"
+)
+
+const (
+	// ShellSignatureMaxAge is how far the time of a shell signature may be off the clock of the node
+	ShellSignatureMaxAge = time.Minute
+	// bodies of the shell requests signed at most
+	shellBodyMax = 1 << 20
+	// nonces of the last ShellSignatureMaxAge remembered at most, the requests above it are refused
+	shellNoncesMax = 10000
+)
+
+// ErrShellDisabled is returned when the node lists no manager key for the shell
+var ErrShellDisabled = errors.New("the shell of the node is disabled")
+
+// the manager signs the node, the request, the time and a nonce so a signature is of no use
+// for another node or request, nor again
+func shellHash(node, method string, u *url.URL, body []byte, t int64, nonce string) cipher.SHA256 {
+	return cipher.SumSHA256([]byte(fmt.Sprintf("skywire-shell\n%s\n%s\n%s\n%s\n%d\n%s\n%s",
+		node, method, u.Path, u.RawQuery, t, nonce, cipher.SumSHA256(body).Hex())))
+}
+
+// SignShellRequest returns the values for the signature headers of a request with the method
+// and the body to the shell api url of the node with the hex key node
+func SignShellRequest(sk cipher.SecKey, node, method string, u *url.URL, body []byte, now time.Time) map[string]string {
+	t := now.Unix()
+	nonce := hex.EncodeToString(cipher.RandByte(16))
+	return map[string]string{
+		ShellKeyHeader:   cipher.PubKeyFromSecKey(sk).Hex(),
+		ShellTimeHeader:  strconv.FormatInt(t, 10),
+		ShellNonceHeader: nonce,
+		ShellSigHeader:   cipher.SignHash(shellHash(node, method, u, body, t, nonce), sk).Hex(),
+	}
+}
+
+// ShellNonces are the nonces of the shell signatures a node accepted lately, each is accepted once
+type ShellNonces struct {
+	seen map[string]time.Time
+	sync.Mutex
+}
+
+// use records the nonce of a signature accepted at now, false if it was used before or too
+// many were used lately
+func (n *ShellNonces) use(nonce string, now time.Time) bool {
+	n.Lock()
+	defer n.Unlock()
+	if n.seen == nil {
+		n.seen = make(map[string]time.Time)
+	}
+	if _, ok := n.seen[nonce]; ok {
+		return false
+	}
+	if len(n.seen) >= shellNoncesMax {
+		for k, v := range n.seen {
+			// the signatures of these are too old to be accepted
+			if now.Sub(v) > 2*ShellSignatureMaxAge {
+				delete(n.seen, k)
+			}
+		}
+		if len(n.seen) >= shellNoncesMax {
+			return false
+		}
+	}
+	n.seen[nonce] = now
+	return true
+}
+
+// VerifyShellRequest checks the request to the shell of the node is signed by one of the manager
+// keys with a nonce not used before, the body is read and put back for the handler
+func VerifyShellRequest(r *http.Request, keys []cipher.PubKey, node string, nonces *ShellNonces, now time.Time) (err error) {
+	if len(keys) < 1 {
+		return ErrShellDisabled
+	}
+	// PubKeyFromHex panics on a key of the wrong length
+	hexKey := r.Header.Get(ShellKeyHeader)
+	if len(hexKey) != 2*len(cipher.PubKey{}) {
+		return errors.New("manager key missing")
+	}
+	key, err := cipher.PubKeyFromHex(hexKey)
+	if err != nil {
+		return fmt.Errorf("manager key: %v", err)
+	}
+	allowed := false
+	for _, k := range keys {
+		if k == key {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return fmt.Errorf("manager %s may not use the shell", key.Hex())
+	}
+	t, err := strconv.ParseInt(r.Header.Get(ShellTimeHeader), 10, 64)
+	if err != nil {
+		return fmt.Errorf("manager time: %v", err)
+	}
+	if d := now.Sub(time.Unix(t, 0)); d > ShellSignatureMaxAge || d < -ShellSignatureMaxAge {
+		return fmt.Errorf("manager time off by %v", d)
+	}
+	nonce := r.Header.Get(ShellNonceHeader)
+	if len(nonce) == 0 {
+		return errors.New("manager nonce missing")
+	}
+	sig, err := cipher.SigFromHex(r.Header.Get(ShellSigHeader))
+	if err != nil {
+		return fmt.Errorf("manager sig: %v", err)
+	}
+	var body []byte
+	if r.Body != nil {
+		body, err = ioutil.ReadAll(io.LimitReader(r.Body, shellBodyMax+1))
+		if err != nil {
+			return
+		}
+		r.Body.Close()
+		if len(body) > shellBodyMax {
+			return errors.New("shell request too large")
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	err = cipher.VerifySignature(key, sig, shellHash(node, r.Method, r.URL, body, t, nonce))
+	if err != nil {
+		return
+	}
+	if !nonces.use(nonce, now) {
+		return errors.New("manager nonce used before")
+	}
+	return
+}
@@ -0,0 +1,141 @@
+package util
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+func TestVerifyShellRequest(t *testing.T) {
+	pk, sk := cipher.GenerateKeyPair()
+	other, _ := cipher.GenerateKeyPair()
+	node, _ := cipher.GenerateKeyPair()
+	now := time.Now()
+	sign := func(method, target, body string, at time.Time) map[string]string {
+		u, err := url.Parse(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return SignShellRequest(sk, node.Hex(), method, u, []byte(body), at)
+	}
+	cases := []struct {
+		name   string
+		keys   []cipher.PubKey
+		method string
+		target string
+		body   string
+		values map[string]string
+		ok     bool
+	}{
+		{"signed", []cipher.PubKey{other, pk}, "GET", "/node/run/term", "", sign("GET", "/node/run/term", "", now), true},
+		{"signed post", []cipher.PubKey{pk}, "POST", "/node/run/runCmd", "cmd=ls", sign("POST", "/node/run/runCmd", "cmd=ls", now), true},
+		{"signed query", []cipher.PubKey{pk}, "GET", "/node/run/getShellOutput?id=1", "", sign("GET", "/node/run/getShellOutput?id=1", "", now), true},
+		{"disabled", nil, "GET", "/node/run/term", "", sign("GET", "/node/run/term", "", now), false},
+		{"not listed", []cipher.PubKey{other}, "GET", "/node/run/term", "", sign("GET", "/node/run/term", "", now), false},
+		{"other path", []cipher.PubKey{pk}, "GET", "/node/run/runCmd", "", sign("GET", "/node/run/term", "", now), false},
+		{"other method", []cipher.PubKey{pk}, "POST", "/node/run/runCmd", "cmd=ls", sign("GET", "/node/run/runCmd", "cmd=ls", now), false},
+		{"other query", []cipher.PubKey{pk}, "GET", "/node/run/getShellOutput?id=2", "", sign("GET", "/node/run/getShellOutput?id=1", "", now), false},
+		{"other body", []cipher.PubKey{pk}, "POST", "/node/run/runCmd", "cmd=rm", sign("POST", "/node/run/runCmd", "cmd=ls", now), false},
+		{"old", []cipher.PubKey{pk}, "GET", "/node/run/term", "", sign("GET", "/node/run/term", "", now.Add(-2*ShellSignatureMaxAge)), false},
+		{"unsigned", []cipher.PubKey{pk}, "GET", "/node/run/term", "", nil, false},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest(c.method, c.target, strings.NewReader(c.body))
+		for k, v := range c.values {
+			r.Header.Set(k, v)
+		}
+		err := VerifyShellRequest(r, c.keys, node.Hex(), &ShellNonces{}, now)
+		if (err == nil) != c.ok {
+			t.Errorf("%s: %v", c.name, err)
+		}
+	}
+
+	// the signature is not a form value anymore, it would be part of the body it signs
+	values := sign("GET", "/node/run/term", "", now)
+	form := url.Values{}
+	for k, v := range values {
+		form.Set(k, v)
+	}
+	r := httptest.NewRequest("GET", "/node/run/term?"+form.Encode(), nil)
+	if err := VerifyShellRequest(r, []cipher.PubKey{pk}, node.Hex(), &ShellNonces{}, now); err == nil {
+		t.Error("a signature in the form accepted")
+	}
+}
+
+func TestShellRequestBody(t *testing.T) {
+	pk, sk := cipher.GenerateKeyPair()
+	node, _ := cipher.GenerateKeyPair()
+	u, _ := url.Parse("/node/run/runCmd")
+	r := httptest.NewRequest("POST", u.String(), strings.NewReader("cmd=ls"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for k, v := range SignShellRequest(sk, node.Hex(), "POST", u, []byte("cmd=ls"), time.Now()) {
+		r.Header.Set(k, v)
+	}
+	if err := VerifyShellRequest(r, []cipher.PubKey{pk}, node.Hex(), &ShellNonces{}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if cmd := r.FormValue("cmd"); cmd != "ls" {
+		t.Fatalf("the handler reads %q", cmd)
+	}
+
+	big := strings.Repeat("a", shellBodyMax+1)
+	r = httptest.NewRequest("POST", u.String(), strings.NewReader(big))
+	for k, v := range SignShellRequest(sk, node.Hex(), "POST", u, []byte(big), time.Now()) {
+		r.Header.Set(k, v)
+	}
+	if err := VerifyShellRequest(r, []cipher.PubKey{pk}, node.Hex(), &ShellNonces{}, time.Now()); err == nil {
+		t.Fatal("a body over the limit accepted")
+	}
+	if _, err := ioutil.ReadAll(r.Body); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShellNonceReplay(t *testing.T) {
+	pk, sk := cipher.GenerateKeyPair()
+	node, _ := cipher.GenerateKeyPair()
+	u, _ := url.Parse("/node/run/term")
+	now := time.Now()
+	values := SignShellRequest(sk, node.Hex(), "GET", u, nil, now)
+	nonces := &ShellNonces{}
+	verify := func(at time.Time) error {
+		r := httptest.NewRequest("GET", u.String(), nil)
+		for k, v := range values {
+			r.Header.Set(k, v)
+		}
+		return VerifyShellRequest(r, []cipher.PubKey{pk}, node.Hex(), nonces, at)
+	}
+	if err := verify(now); err != nil {
+		t.Fatal(err)
+	}
+	if err := verify(now.Add(time.Second)); err == nil {
+		t.Fatal("a signature replayed within the window accepted")
+	}
+	// a new signature of the same request
+	values = SignShellRequest(sk, node.Hex(), "GET", u, nil, now)
+	if err := verify(now.Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	// only the nonces of signatures that would be too old are dropped
+	n := &ShellNonces{}
+	for i := 0; i < shellNoncesMax; i++ {
+		if !n.use(string(rune(i)), now) {
+			t.Fatalf("nonce %d refused", i)
+		}
+	}
+	if n.use("new", now.Add(ShellSignatureMaxAge)) {
+		t.Fatal("a nonce accepted above the limit")
+	}
+	if !n.use("new", now.Add(2*ShellSignatureMaxAge+time.Second)) {
+		t.Fatal("the old nonces not dropped")
+	}
+	if len(n.seen) != 1 {
+		t.Fatalf("%d nonces kept", len(n.seen))
+	}
+}
@@ -0,0 +1,71 @@
+package wire
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+)
+
+type selfCheckInner struct {
+	Name string `wire:"1"`
+}
+
+// covers every kind of field with values that differ in each byte, so a byte order or
+// word size dependent encoding shows up as different bytes
+type selfCheckMessage struct {
+	Signed   int64           `wire:"1"`
+	Unsigned uint64          `wire:"2"`
+	Small    uint32          `wire:"3"`
+	Int      int             `wire:"4"`
+	Flag     bool            `wire:"5"`
+	Text     string          `wire:"6"`
+	Data     []byte          `wire:"7"`
+	Key      [3]byte         `wire:"8"`
+	Inner    *selfCheckInner `wire:"9"`
+}
+
+var selfCheckValue = selfCheckMessage{
+	Signed:   -0x0102030405060708,
+	Unsigned: 0x8877665544332211,
+	Small:    0xa1b2c3d4,
+	Int:      -0x01020304,
+	Flag:     true,
+	Text:     "skywire",
+	Data:     []byte{0xde, 0xad, 0xbe, 0xef},
+	Key:      [3]byte{1, 2, 3},
+	Inner:    &selfCheckInner{Name: "in"},
+}
+
+// the fields of selfCheckValue as encoded on every platform, without the version header
+var selfCheckEncoding = []byte{
+	0x08, 0x8f, 0x9c, 0xb0, 0xd0, 0x80, 0xc1, 0x81, 0x82, 0x02, 0x10, 0x91,
+	0xc4, 0xcc, 0xa1, 0xd4, 0xca, 0xd9, 0xbb, 0x88, 0x01, 0x18, 0xd4, 0x87,
+	0xcb, 0x8d, 0x0a, 0x20, 0x87, 0x8c, 0x90, 0x10, 0x28, 0x01, 0x32, 0x07,
+	0x73, 0x6b, 0x79, 0x77, 0x69, 0x72, 0x65, 0x3a, 0x04, 0xde, 0xad, 0xbe,
+	0xef, 0x42, 0x03, 0x01, 0x02, 0x03, 0x4a, 0x04, 0x0a, 0x02, 0x69, 0x6e,
+}
+
+// SelfCheck encodes and decodes a message covering every kind of field and compares the
+// result with the encoding of the reference platform
+func SelfCheck() error {
+	fields, err := appendFields(nil, reflect.ValueOf(selfCheckValue))
+	if err != nil {
+		return fmt.Errorf("wire self check: %v", err)
+	}
+	if !bytes.Equal(fields, selfCheckEncoding) {
+		return fmt.Errorf("wire self check: encoded %x, expected %x", fields, selfCheckEncoding)
+	}
+	data, err := Marshal(&selfCheckValue)
+	if err != nil {
+		return fmt.Errorf("wire self check: %v", err)
+	}
+	var m selfCheckMessage
+	err = Unmarshal(data, &m)
+	if err != nil {
+		return fmt.Errorf("wire self check: %v", err)
+	}
+	if !reflect.DeepEqual(m, selfCheckValue) {
+		return fmt.Errorf("wire self check: decoded %+v, expected %+v", m, selfCheckValue)
+	}
+	return nil
+}
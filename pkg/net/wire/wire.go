@@ -0,0 +1,344 @@
+// Package wire is the versioned binary schema of the setup and app messages.
+//
+// A message starts with a zero byte, which never starts a JSON message,
+// and the schema version of the sender as uvarint. Then follow its fields,
+// each a uvarint key of tag<<3|wire type and the value:
+//
+//	Varint  uvarint; bools and unsigned integers as is, signed integers zigzag encoded
+//	Bytes   uvarint length and the bytes; strings, byte slices, byte arrays and nested messages
+//
+// The tag of a field is set by its `wire` struct tag. Fields holding their
+// zero value are left out, except byte arrays and nested messages. A slice of
+// byte arrays is repeated, one Bytes value with the tag of the field per element.
+//
+// Decoding rules, so that nodes of different versions understand each other:
+//   - fields with an unknown tag are skipped, missing fields are zero
+//   - a message of any version is decoded the same way
+//   - a new version only adds fields; a tag is never reused and the type of a field never changes
+package wire
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"sync"
+)
+
+// Version is the schema version of this build
+//
+//	1  first version
+//	2  sealed apps of private transport setups
+const Version = 2
+
+const (
+	Varint = 0
+	Bytes  = 2
+)
+
+var (
+	ErrNotSchema = errors.New("wire: not a schema message")
+	ErrTruncated = errors.New("wire: message truncated")
+)
+
+type field struct {
+	index int
+	tag   uint64
+	name  string
+}
+
+type schema struct {
+	fields []field
+	// every exported field has a tag
+	complete bool
+}
+
+var schemas sync.Map
+
+func schemaOf(t reflect.Type) *schema {
+	if s, ok := schemas.Load(t); ok {
+		return s.(*schema)
+	}
+	s := &schema{complete: true}
+	tags := make(map[uint64]string)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("wire")
+		if tag == "" {
+			if f.PkgPath == "" {
+				s.complete = false
+			}
+			continue
+		}
+		n, err := strconv.ParseUint(tag, 10, 32)
+		if err != nil || n == 0 {
+			panic(fmt.Sprintf("wire: invalid tag %q of %s.%s", tag, t, f.Name))
+		}
+		if other, ok := tags[n]; ok {
+			panic(fmt.Sprintf("wire: tag %d of %s used by %s and %s", n, t, other, f.Name))
+		}
+		tags[n] = f.Name
+		s.fields = append(s.fields, field{index: i, tag: n, name: f.Name})
+	}
+	schemas.Store(t, s)
+	return s
+}
+
+// Has reports whether v is a struct, or a pointer to one, with a tag on every exported field
+func Has(v interface{}) bool {
+	t := reflect.TypeOf(v)
+	if t == nil {
+		return false
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct && schemaOf(t).complete
+}
+
+// Is reports whether data is a schema message rather than JSON
+func Is(data []byte) bool {
+	return len(data) > 0 && data[0] == 0
+}
+
+// MessageVersion returns the schema version data was encoded with
+func MessageVersion(data []byte) (version uint64, err error) {
+	if !Is(data) {
+		err = ErrNotSchema
+		return
+	}
+	version, n := binary.Uvarint(data[1:])
+	if n <= 0 {
+		err = ErrTruncated
+	}
+	return
+}
+
+// Marshal encodes v, a struct or a pointer to one
+func Marshal(v interface{}) (data []byte, err error) {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Struct {
+		err = fmt.Errorf("wire: can not marshal %T", v)
+		return
+	}
+	data = appendUvarint([]byte{0}, Version)
+	return appendFields(data, rv)
+}
+
+func appendUvarint(b []byte, x uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], x)
+	return append(b, buf[:n]...)
+}
+
+func appendVarint(b []byte, x int64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(buf[:], x)
+	return append(b, buf[:n]...)
+}
+
+func appendKey(b []byte, tag uint64, wireType uint64) []byte {
+	return appendUvarint(b, tag<<3|wireType)
+}
+
+func appendBytes(b []byte, tag uint64, value []byte) []byte {
+	b = appendKey(b, tag, Bytes)
+	b = appendUvarint(b, uint64(len(value)))
+	return append(b, value...)
+}
+
+func appendFields(b []byte, v reflect.Value) (_ []byte, err error) {
+	for _, f := range schemaOf(v.Type()).fields {
+		fv := v.Field(f.index)
+		switch fv.Kind() {
+		case reflect.Bool:
+			if fv.Bool() {
+				b = appendKey(b, f.tag, Varint)
+				b = appendUvarint(b, 1)
+			}
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if fv.Int() != 0 {
+				b = appendKey(b, f.tag, Varint)
+				b = appendVarint(b, fv.Int())
+			}
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			if fv.Uint() != 0 {
+				b = appendKey(b, f.tag, Varint)
+				b = appendUvarint(b, fv.Uint())
+			}
+		case reflect.String:
+			if fv.Len() > 0 {
+				b = appendBytes(b, f.tag, []byte(fv.String()))
+			}
+		case reflect.Slice:
+			if isByteArray(fv.Type().Elem()) {
+				for i := 0; i < fv.Len(); i++ {
+					value := make([]byte, fv.Index(i).Len())
+					reflect.Copy(reflect.ValueOf(value), fv.Index(i))
+					b = appendBytes(b, f.tag, value)
+				}
+				continue
+			}
+			if fv.Type().Elem().Kind() != reflect.Uint8 {
+				return nil, fmt.Errorf("wire: unsupported type %s of %s", fv.Type(), f.name)
+			}
+			if fv.Len() > 0 {
+				b = appendBytes(b, f.tag, fv.Bytes())
+			}
+		case reflect.Array:
+			if fv.Type().Elem().Kind() != reflect.Uint8 {
+				return nil, fmt.Errorf("wire: unsupported type %s of %s", fv.Type(), f.name)
+			}
+			value := make([]byte, fv.Len())
+			reflect.Copy(reflect.ValueOf(value), fv)
+			b = appendBytes(b, f.tag, value)
+		case reflect.Ptr:
+			if fv.IsNil() {
+				continue
+			}
+			fv = fv.Elem()
+			fallthrough
+		case reflect.Struct:
+			if fv.Kind() != reflect.Struct {
+				return nil, fmt.Errorf("wire: unsupported type %s of %s", fv.Type(), f.name)
+			}
+			var value []byte
+			value, err = appendFields(nil, fv)
+			if err != nil {
+				return
+			}
+			b = appendBytes(b, f.tag, value)
+		default:
+			return nil, fmt.Errorf("wire: unsupported type %s of %s", fv.Type(), f.name)
+		}
+	}
+	return b, nil
+}
+
+func isByteArray(t reflect.Type) bool {
+	return t.Kind() == reflect.Array && t.Elem().Kind() == reflect.Uint8
+}
+
+// Unmarshal decodes data into v, a pointer to a struct, which is reset first
+func Unmarshal(data []byte, v interface{}) (err error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("wire: can not unmarshal into %T", v)
+	}
+	if !Is(data) {
+		return ErrNotSchema
+	}
+	_, n := binary.Uvarint(data[1:])
+	if n <= 0 {
+		return ErrTruncated
+	}
+	rv = rv.Elem()
+	rv.Set(reflect.Zero(rv.Type()))
+	return decodeFields(data[1+n:], rv)
+}
+
+func decodeFields(b []byte, v reflect.Value) (err error) {
+	s := schemaOf(v.Type())
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return ErrTruncated
+		}
+		b = b[n:]
+		tag, wireType := key>>3, key&7
+		var x uint64
+		var value []byte
+		switch wireType {
+		case Varint:
+			x, n = binary.Uvarint(b)
+			if n <= 0 {
+				return ErrTruncated
+			}
+			b = b[n:]
+		case Bytes:
+			var l uint64
+			l, n = binary.Uvarint(b)
+			if n <= 0 || l > uint64(len(b)-n) {
+				return ErrTruncated
+			}
+			value = b[n : n+int(l)]
+			b = b[n+int(l):]
+		default:
+			return fmt.Errorf("wire: unknown wire type %d of tag %d", wireType, tag)
+		}
+		for _, f := range s.fields {
+			if f.tag != tag {
+				continue
+			}
+			err = setField(v.Field(f.index), wireType, x, value)
+			if err != nil {
+				return fmt.Errorf("wire: field %s: %v", f.name, err)
+			}
+			break
+		}
+	}
+	return
+}
+
+func setField(fv reflect.Value, wireType, x uint64, value []byte) (err error) {
+	expected := uint64(Bytes)
+	switch fv.Kind() {
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		expected = Varint
+	}
+	if wireType != expected {
+		return fmt.Errorf("wire type %d, expected %d", wireType, expected)
+	}
+	switch fv.Kind() {
+	case reflect.Bool:
+		fv.SetBool(x != 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i := int64(x>>1) ^ -int64(x&1)
+		if fv.OverflowInt(i) {
+			return fmt.Errorf("%d overflows %s", i, fv.Type())
+		}
+		fv.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if fv.OverflowUint(x) {
+			return fmt.Errorf("%d overflows %s", x, fv.Type())
+		}
+		fv.SetUint(x)
+	case reflect.String:
+		fv.SetString(string(value))
+	case reflect.Slice:
+		if elem := fv.Type().Elem(); isByteArray(elem) {
+			if len(value) != elem.Len() {
+				return fmt.Errorf("%d bytes, expected %d", len(value), elem.Len())
+			}
+			e := reflect.New(elem).Elem()
+			reflect.Copy(e, reflect.ValueOf(value))
+			fv.Set(reflect.Append(fv, e))
+			return
+		}
+		if fv.Type().Elem().Kind() != reflect.Uint8 {
+			return fmt.Errorf("unsupported type %s", fv.Type())
+		}
+		fv.SetBytes(append([]byte(nil), value...))
+	case reflect.Array:
+		if fv.Type().Elem().Kind() != reflect.Uint8 {
+			return fmt.Errorf("unsupported type %s", fv.Type())
+		}
+		if len(value) != fv.Len() {
+			return fmt.Errorf("%d bytes, expected %d", len(value), fv.Len())
+		}
+		reflect.Copy(fv, reflect.ValueOf(value))
+	case reflect.Ptr:
+		if fv.Type().Elem().Kind() != reflect.Struct {
+			return fmt.Errorf("unsupported type %s", fv.Type())
+		}
+		fv.Set(reflect.New(fv.Type().Elem()))
+		return decodeFields(value, fv.Elem())
+	case reflect.Struct:
+		return decodeFields(value, fv)
+	default:
+		return fmt.Errorf("unsupported type %s", fv.Type())
+	}
+	return
+}
@@ -0,0 +1,21 @@
+//go:build 386 || arm || mips || mipsle
+// +build 386 arm mips mipsle
+
+package wire
+
+import "testing"
+
+// an int is 32 bits here, a larger value sent by a 64-bit peer must fail instead of wrapping
+func TestIntOverflow32(t *testing.T) {
+	type wide struct {
+		Port int64 `wire:"3"`
+	}
+	data, err := Marshal(&wide{Port: 1 << 40})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out message
+	if err = Unmarshal(data, &out); err == nil {
+		t.Fatalf("1<<40 decoded into int as %d", out.Port)
+	}
+}
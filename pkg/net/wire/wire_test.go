@@ -0,0 +1,121 @@
+package wire
+
+import (
+	"reflect"
+	"testing"
+)
+
+type nested struct {
+	ID string `wire:"1"`
+}
+
+type message struct {
+	Key    [4]byte   `wire:"1"`
+	Num    []byte    `wire:"2"`
+	Port   int       `wire:"3"`
+	Failed bool      `wire:"4"`
+	Msg    string    `wire:"5"`
+	Inner  nested    `wire:"6"`
+	Trace  *nested   `wire:"7"`
+	Count  uint16    `wire:"8"`
+	Keys   [][4]byte `wire:"9"`
+}
+
+type messageV0 struct {
+	Key  [4]byte `wire:"1"`
+	Port int     `wire:"3"`
+}
+
+func TestRoundTrip(t *testing.T) {
+	in := message{
+		Key:    [4]byte{1, 2, 3, 4},
+		Num:    []byte{5, 6},
+		Port:   -30000,
+		Failed: true,
+		Msg:    "ok",
+		Inner:  nested{ID: "a"},
+		Trace:  &nested{ID: "b"},
+		Count:  7,
+		Keys:   [][4]byte{{1}, {2, 3}},
+	}
+	data, err := Marshal(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !Is(data) {
+		t.Fatal("not a schema message")
+	}
+	if v, err := MessageVersion(data); err != nil || v != Version {
+		t.Fatalf("version %d %v", v, err)
+	}
+	var out message
+	if err = Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("got %#v, expected %#v", out, in)
+	}
+}
+
+func TestUnknownFieldsSkipped(t *testing.T) {
+	data, err := Marshal(&message{Key: [4]byte{1}, Port: 8000, Msg: "new", Trace: &nested{ID: "x"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out messageV0
+	if err = Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Key != [4]byte{1} || out.Port != 8000 {
+		t.Fatalf("got %#v", out)
+	}
+}
+
+func TestUnmarshalResets(t *testing.T) {
+	data, err := Marshal(&message{Port: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := message{Failed: true, Msg: "stale"}
+	if err = Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Failed || out.Msg != "" || out.Port != 1 {
+		t.Fatalf("got %#v", out)
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	var out message
+	if err := Unmarshal([]byte(`{"Port":1}`), &out); err != ErrNotSchema {
+		t.Fatalf("json: %v", err)
+	}
+	data, _ := Marshal(&message{Msg: "truncated"})
+	if err := Unmarshal(data[:len(data)-1], &out); err != ErrTruncated {
+		t.Fatalf("truncated: %v", err)
+	}
+	bad := appendUvarint([]byte{0, Version}, 5<<3|Varint)
+	bad = appendUvarint(bad, 1)
+	if err := Unmarshal(bad, &out); err == nil {
+		t.Fatal("wire type mismatch accepted")
+	}
+}
+
+func TestHas(t *testing.T) {
+	type partial struct {
+		A int `wire:"1"`
+		B int
+	}
+	if !Has(&message{}) || !Has(struct{}{}) {
+		t.Fatal("schema not found")
+	}
+	if Has(&partial{}) || Has(1) || Has(nil) {
+		t.Fatal("schema found")
+	}
+}
+
+func TestSelfCheck(t *testing.T) {
+	if err := SelfCheck(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,324 @@
+package node
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/skycoin/skycoin/src/cipher"
+	"github.com/skycoin/skywire/pkg/net/skycoin-messenger/factory"
+)
+
+const (
+	// the running transports are counted this often
+	accountingSampleInterval = 10 * time.Second
+	accountingFilePrefix     = "usage-"
+	accountingTimeFormat     = "20060102T150405Z"
+)
+
+// AccountingConfig of the usage reports of the node
+type AccountingConfig struct {
+	// time a report covers, the reports start at multiples of it
+	Interval time.Duration
+	// directory the reports are written to as json and csv, in memory only if empty
+	Dir string
+	// reports older than this are removed, 0 keeps them all
+	Keep time.Duration
+}
+
+// UsageRecord is the use of the node by the transports between an app of the node and a remote node
+type UsageRecord struct {
+	App  string `json:"app"`
+	Peer string `json:"peer"`
+	// bytes from the app to the peer
+	Upload uint64 `json:"upload"`
+	// bytes from the peer to the app
+	Download uint64 `json:"download"`
+	// transports opened in the period or running at its start
+	Transports int `json:"transports"`
+	// time the transports were open in the period, added up
+	Seconds float64 `json:"seconds"`
+}
+
+// UsageReport covers the unix times from Start to End
+type UsageReport struct {
+	Start   int64         `json:"start"`
+	End     int64         `json:"end"`
+	Records []UsageRecord `json:"records"`
+}
+
+type usageKey struct {
+	app, peer string
+}
+
+// counted totals of a running transport
+type transportUsage struct {
+	key         usageKey
+	up, down    uint
+	lastCounted time.Time
+}
+
+type accounting struct {
+	config     AccountingConfig
+	start      time.Time
+	end        time.Time
+	records    map[usageKey]*UsageRecord
+	transports map[*factory.Transport]*transportUsage
+	reports    []UsageReport
+	sync.Mutex
+}
+
+// StartAccounting reports the usage of the node by app and remote node every interval until
+// the node is closed, the last report is cut short when the node closes
+func (n *Node) StartAccounting(config AccountingConfig) (err error) {
+	if config.Interval <= 0 {
+		return fmt.Errorf("invalid usage report interval %v", config.Interval)
+	}
+	now := time.Now()
+	a := &accounting{
+		config:     config,
+		records:    make(map[usageKey]*UsageRecord),
+		transports: make(map[*factory.Transport]*transportUsage),
+	}
+	a.begin(now)
+	if len(config.Dir) > 0 {
+		if err = os.MkdirAll(config.Dir, 0700); err != nil {
+			return
+		}
+		a.load(now)
+	}
+	n.accountingMutex.Lock()
+	n.accounting = a
+	n.accountingMutex.Unlock()
+	n.supervise(AccountingSubsystem, func() {
+		ticker := time.NewTicker(accountingSampleInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-n.closing:
+				return
+			case now := <-ticker.C:
+				a.sample(n, now, false)
+			}
+		}
+	})
+	return
+}
+
+// finishAccounting cuts the running report short and writes it, when the node closes
+func (n *Node) finishAccounting() {
+	n.accountingMutex.RLock()
+	a := n.accounting
+	n.accountingMutex.RUnlock()
+	if a != nil {
+		a.sample(n, time.Now(), true)
+	}
+}
+
+// GetUsageReports returns the reports ending after since, the last one is the running period
+func (n *Node) GetUsageReports(since time.Time) (reports []UsageReport) {
+	n.accountingMutex.RLock()
+	a := n.accounting
+	n.accountingMutex.RUnlock()
+	if a == nil {
+		return
+	}
+	a.Lock()
+	defer a.Unlock()
+	for _, r := range a.reports {
+		if r.End > since.Unix() {
+			reports = append(reports, r)
+		}
+	}
+	reports = append(reports, a.report(time.Now()))
+	return
+}
+
+func (a *accounting) begin(now time.Time) {
+	a.start = now
+	a.end = now.Truncate(a.config.Interval).Add(a.config.Interval)
+	a.records = make(map[usageKey]*UsageRecord)
+	for _, u := range a.transports {
+		a.record(u.key).Transports++
+	}
+}
+
+func (a *accounting) record(key usageKey) *UsageRecord {
+	r, ok := a.records[key]
+	if !ok {
+		r = &UsageRecord{App: key.app, Peer: key.peer}
+		a.records[key] = r
+	}
+	return r
+}
+
+// count adds what the transport did since it was counted last
+func (a *accounting) count(t *factory.Transport, now time.Time, closed bool) {
+	u, ok := a.transports[t]
+	if !ok {
+		if t.IsStandby() {
+			return
+		}
+		key := usageKey{app: t.ToApp.Hex(), peer: t.FromNode.Hex()}
+		if t.IsClientSide() {
+			key = usageKey{app: t.FromApp.Hex(), peer: t.ToNode.Hex()}
+		}
+		u = &transportUsage{key: key, lastCounted: now}
+		a.transports[t] = u
+		a.record(key).Transports++
+	}
+	up, down := t.GetUploadTotal(), t.GetDownloadTotal()
+	r := a.record(u.key)
+	r.Upload += uint64(up - u.up)
+	r.Download += uint64(down - u.down)
+	r.Seconds += now.Sub(u.lastCounted).Seconds()
+	u.up, u.down, u.lastCounted = up, down, now
+	if closed {
+		delete(a.transports, t)
+	}
+}
+
+// sample counts the running transports and ends the report when its period is over
+func (a *accounting) sample(n *Node, now time.Time, closing bool) {
+	var ts []*factory.Transport
+	n.apps.ForEachAcceptedConnection(func(key cipher.PubKey, conn *factory.Connection) {
+		conn.ForEachTransport(func(t *factory.Transport) {
+			ts = append(ts, t)
+		})
+	})
+	a.Lock()
+	defer a.Unlock()
+	end := now
+	if !closing && now.After(a.end) {
+		end = a.end
+	}
+	for _, t := range ts {
+		a.count(t, end, false)
+	}
+	if closing || !now.Before(a.end) {
+		a.finish(a.report(end))
+		a.begin(end)
+	}
+}
+
+func (a *accounting) report(end time.Time) (r UsageReport) {
+	r = UsageReport{Start: a.start.Unix(), End: end.Unix(), Records: []UsageRecord{}}
+	for _, v := range a.records {
+		r.Records = append(r.Records, *v)
+	}
+	sort.Slice(r.Records, func(i, j int) bool {
+		if r.Records[i].App != r.Records[j].App {
+			return r.Records[i].App < r.Records[j].App
+		}
+		return r.Records[i].Peer < r.Records[j].Peer
+	})
+	return
+}
+
+// finish keeps the report and writes it, the reports older than Keep are removed
+func (a *accounting) finish(r UsageReport) {
+	a.reports = append(a.reports, r)
+	var oldest time.Time
+	if a.config.Keep > 0 {
+		oldest = time.Unix(r.End, 0).Add(-a.config.Keep)
+		for len(a.reports) > 0 && a.reports[0].End < oldest.Unix() {
+			a.reports = a.reports[1:]
+		}
+	}
+	if len(a.config.Dir) < 1 {
+		return
+	}
+	name := filepath.Join(a.config.Dir, accountingFilePrefix+time.Unix(r.Start, 0).UTC().Format(accountingTimeFormat))
+	d, err := json.Marshal(r)
+	if err == nil {
+		err = ioutil.WriteFile(name+".json", d, 0600)
+	}
+	if err == nil {
+		err = ioutil.WriteFile(name+".csv", UsageCSV([]UsageReport{r}), 0600)
+	}
+	if err != nil {
+		log.Errorf("write usage report: %v", err)
+	}
+	if !oldest.IsZero() {
+		a.prune(oldest)
+	}
+}
+
+// load the reports written before that are not too old
+func (a *accounting) load(now time.Time) {
+	fis, err := ioutil.ReadDir(a.config.Dir)
+	if err != nil {
+		log.Errorf("read usage reports: %v", err)
+		return
+	}
+	for _, fi := range fis {
+		if !strings.HasPrefix(fi.Name(), accountingFilePrefix) || filepath.Ext(fi.Name()) != ".json" {
+			continue
+		}
+		d, err := ioutil.ReadFile(filepath.Join(a.config.Dir, fi.Name()))
+		if err != nil {
+			continue
+		}
+		var r UsageReport
+		if json.Unmarshal(d, &r) != nil {
+			continue
+		}
+		if a.config.Keep > 0 && r.End < now.Add(-a.config.Keep).Unix() {
+			continue
+		}
+		a.reports = append(a.reports, r)
+	}
+	sort.Slice(a.reports, func(i, j int) bool { return a.reports[i].Start < a.reports[j].Start })
+}
+
+// prune removes the report files that started before oldest
+func (a *accounting) prune(oldest time.Time) {
+	fis, err := ioutil.ReadDir(a.config.Dir)
+	if err != nil {
+		return
+	}
+	for _, fi := range fis {
+		name := fi.Name()
+		if !strings.HasPrefix(name, accountingFilePrefix) {
+			continue
+		}
+		start, err := time.Parse(accountingTimeFormat, strings.TrimSuffix(strings.TrimPrefix(name, accountingFilePrefix), filepath.Ext(name)))
+		if err != nil || !start.Before(oldest) {
+			continue
+		}
+		os.Remove(filepath.Join(a.config.Dir, name))
+	}
+}
+
+// UsageCSV writes the records of the reports as csv with a header
+func UsageCSV(reports []UsageReport) []byte {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{"start", "end", "app", "peer", "upload", "download", "transports", "seconds"})
+	for _, r := range reports {
+		for _, v := range r.Records {
+			w.Write([]string{
+				strconv.FormatInt(r.Start, 10),
+				strconv.FormatInt(r.End, 10),
+				v.App,
+				v.Peer,
+				strconv.FormatUint(v.Upload, 10),
+				strconv.FormatUint(v.Download, 10),
+				strconv.Itoa(v.Transports),
+				strconv.FormatFloat(v.Seconds, 'f', 0, 64),
+			})
+		}
+	}
+	w.Flush()
+	return buf.Bytes()
+}
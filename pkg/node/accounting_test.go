@@ -0,0 +1,82 @@
+package node_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skycoin/skywire/pkg/node"
+	"github.com/skycoin/skywire/pkg/node/nodetest"
+)
+
+func TestAccounting(t *testing.T) {
+	e := nodetest.NewEnv(t, 1)
+	defer e.Close()
+	config := node.AccountingConfig{Interval: time.Hour, Dir: e.Path("usage"), Keep: 24 * time.Hour}
+	// a report older than the ones kept
+	old, _ := json.Marshal(node.UsageReport{Start: 946684800, End: 946688400, Records: []node.UsageRecord{}})
+	os.MkdirAll(config.Dir, 0700)
+	if err := ioutil.WriteFile(filepath.Join(config.Dir, "usage-20000101T000000Z.json"), old, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	start := func() *nodetest.Node {
+		n := e.StartNode("a")
+		if err := n.StartAccounting(config); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	a, b := start(), e.StartNode("b")
+	server := e.ConnectApp(b, "server")
+	server.Offer(e.Echo(), "usage")
+	client := e.ConnectApp(a, "client")
+	resp := client.Connect(b.Key, server.GetKey(), e.DiscoveryKey(0))
+	nodetest.Ping(t, resp.Port).Close()
+	app := client.GetKey().Hex()
+	client.Close()
+
+	// the closed transport is counted in the running report by app and remote node
+	var record node.UsageRecord
+	nodetest.WaitFor(t, "the usage of the closed transport", func() bool {
+		reports := a.GetUsageReports(time.Time{})
+		if len(reports) != 1 || len(reports[0].Records) != 1 {
+			return false
+		}
+		record = reports[0].Records[0]
+		return record.Download > 0
+	})
+	if record.App != app || record.Peer != b.Key.Hex() || record.Transports != 1 || record.Upload == 0 {
+		t.Fatalf("record %+v", record)
+	}
+
+	// the report is cut short and written once the node closed, the old one is removed
+	a.Close()
+	files, err := filepath.Glob(filepath.Join(config.Dir, "usage-*"))
+	if err != nil || len(files) != 2 {
+		t.Fatalf("report files %v %v", files, err)
+	}
+	for _, f := range files {
+		if strings.Contains(f, "20000101") {
+			t.Fatalf("old report kept %s", f)
+		}
+	}
+	csv, err := ioutil.ReadFile(strings.TrimSuffix(files[0], filepath.Ext(files[0])) + ".csv")
+	if err != nil || !strings.Contains(string(csv), app+","+b.Key.Hex()) {
+		t.Fatalf("csv report %s %v", csv, err)
+	}
+
+	// the reports are read again when the node starts
+	a = start()
+	reports := a.GetUsageReports(time.Time{})
+	if len(reports) != 2 || len(reports[0].Records) != 1 || reports[0].Records[0] != record || len(reports[1].Records) != 0 {
+		t.Fatalf("reports after a restart %+v", reports)
+	}
+	if reports = a.GetUsageReports(time.Unix(reports[0].End, 0)); len(reports) != 1 {
+		t.Fatalf("reports since the end of the first %+v", reports)
+	}
+}
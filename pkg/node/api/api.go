@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"os"
 	"os/exec"
@@ -19,7 +20,9 @@ import (
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	"github.com/skycoin/skycoin/src/cipher"
+	"github.com/skycoin/skywire/pkg/httputil"
 	"github.com/skycoin/skywire/pkg/net/skycoin-messenger/factory"
+	"github.com/skycoin/skywire/pkg/net/util"
 	"github.com/skycoin/skywire/pkg/node"
 )
 
@@ -30,8 +33,13 @@ type NodeApi struct {
 	confPath string
 	osSignal chan os.Signal
 	srv      *http.Server
+	listener net.Listener
 
 	token string
+	// managers allowed to use the shell, none disables it
+	shellKeys []cipher.PubKey
+	// nonces of the signed shell requests, each is accepted once
+	shellNonces util.ShellNonces
 
 	apps map[string]*appCxt
 	sync.RWMutex
@@ -43,6 +51,64 @@ func (na *NodeApi) SetToken(newToken string) {
 	na.token = newToken
 }
 
+// SetShellKeys enables the shell and terminal of the node for the managers with the hex keys,
+// their requests must be signed besides carrying the token
+func (na *NodeApi) SetShellKeys(keys []string) (err error) {
+	pks := make([]cipher.PubKey, 0, len(keys))
+	for _, v := range keys {
+		var k cipher.PubKey
+		if len(v) != 2*len(k) {
+			err = fmt.Errorf("shell manager key %s: invalid length", v)
+			return
+		}
+		k, err = cipher.PubKeyFromHex(v)
+		if err != nil {
+			err = fmt.Errorf("shell manager key %s: %v", v, err)
+			return
+		}
+		pks = append(pks, k)
+	}
+	na.Lock()
+	na.shellKeys = pks
+	na.Unlock()
+	return
+}
+
+// verifyShell checks the request to the shell is signed by an allowed manager
+func (na *NodeApi) verifyShell(r *http.Request) (err error) {
+	na.RLock()
+	keys := na.shellKeys
+	na.RUnlock()
+	if len(keys) < 1 {
+		return util.ErrShellDisabled
+	}
+	node, err := na.node.GetNodeKey()
+	if err != nil {
+		return
+	}
+	return util.VerifyShellRequest(r, keys, node, &na.shellNonces, time.Now())
+}
+
+// wrapShell is wrap for the shell paths, which also need the signature of a manager. The
+// signature covers the body, so it is checked before wrap reads the form
+func (na *NodeApi) wrapShell(fn func(w http.ResponseWriter, r *http.Request) (result []byte, err error)) func(w http.ResponseWriter, r *http.Request) {
+	wrapped := na.wrap(fn)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := na.verifyShell(r); err != nil {
+			log.Warnf("shell request refused: %v", err)
+			httputil.WriteError(w, err)
+			return
+		}
+		wrapped(w, r)
+	}
+}
+
+// SetListener makes the api serve on a listener passed by the service manager
+// instead of listening on its address
+func (na *NodeApi) SetListener(ln net.Listener) {
+	na.listener = ln
+}
+
 type appCxt struct {
 	cxt    context.Context
 	cancel context.CancelFunc
@@ -90,7 +156,7 @@ func (na *NodeApi) Close() error {
 			v.cancel()
 		}
 	}
-	return na.srv.Close()
+	return httputil.Shutdown(na.srv)
 }
 
 func (na *NodeApi) StartSrv() {
@@ -102,33 +168,142 @@ func (na *NodeApi) StartSrv() {
 	if err != nil {
 		log.Errorf("after launch error: %s", err)
 	}
-	http.HandleFunc("/node/getSig", na.wrap(na.getSig))
-	http.HandleFunc("/node/getInfo", na.wrap(na.getInfo))
-	http.HandleFunc("/node/getMsg", na.wrap(na.getMsg))
-	http.HandleFunc("/node/getApps", na.wrap(na.getApps))
-	http.HandleFunc("/node/reboot", na.wrap(na.runReboot))
-	http.HandleFunc("/node/run/sshs", na.wrap(na.runSshs))
-	http.HandleFunc("/node/run/sshc", na.wrap(na.runSshc))
-	http.HandleFunc("/node/run/sockss", na.wrap(na.runSockss))
-	http.HandleFunc("/node/run/socksc", na.wrap(na.runSocksc))
-	http.HandleFunc("/node/run/update", na.wrap(na.update))
-	http.HandleFunc("/node/run/checkUpdate", na.wrap(na.checkUpdate))
-	http.HandleFunc("/node/run/setNodeConfig", na.wrap(na.setNodeConfig))
-	http.HandleFunc("/node/run/updateNode", na.wrap(na.updateNode))
-	http.HandleFunc("/node/run/runShell", na.wrap(na.runShell))
-	http.HandleFunc("/node/run/runCmd", na.wrap(na.runCmd))
-	http.HandleFunc("/node/run/getShellOutput", na.wrap(na.getShellOutput))
-	http.HandleFunc("/node/run/searchServices", na.wrap(na.search))
-	http.HandleFunc("/node/run/getSearchServicesResult", na.wrap(na.getSearchResult))
-	http.HandleFunc("/node/run/getAutoStartConfig", na.wrap(na.getAutoStartConfig))
-	http.HandleFunc("/node/run/setAutoStartConfig", na.wrap(na.setAutoStartConfig))
-	http.HandleFunc("/node/run/closeApp", na.wrap(na.closeApp))
+	spec := httputil.NewSpec("Skywire Node API", node.Version)
+	spec.Description = "The api the manager runs the node with, every request carries the token of the manager"
+	handle := func(path string, h http.HandlerFunc) *httputil.Route {
+		http.HandleFunc(path, h)
+		return spec.Describe(http.MethodPost, path).Param("token", "the token of the manager")
+	}
+	handle("/node/getSig", na.wrap(na.getSig)).
+		Doc("Sign data with the key of the node").
+		Param("data", "the data to sign")
+	handle("/node/getInfo", na.wrap(na.getInfo)).
+		Doc("The info of the node").
+		Param("transports", "false to leave out the transports")
+	handle("/node/getTransports", na.wrap(na.getTransports)).
+		Doc("A page of the transports of the node").
+		Param("limit", "the most transports in the page").
+		Param("cursor", "the next of the previous page")
+	handle("/node/getMsg", na.wrap(na.getMsg)).
+		Doc("The messages of the discovery").
+		Param("key", "the key of the discovery")
+	handle("/node/getApps", na.wrap(na.getApps)).
+		Doc("The apps registered with the node")
+	handle("/node/getMetrics", na.wrap(na.getMetrics)).
+		Doc("The metrics of the node")
+	handle("/node/explainRoute", na.wrap(na.explainRoute)).
+		Doc("Why the transports of a setup went through their discoveries").
+		Param("id", "the route of the transport")
+	handle("/node/getUsage", na.wrap(na.getUsage)).
+		Doc("The usage reports ending after a time").
+		Param("since", "unix time").
+		Param("format", "csv for csv")
+	handle("/node/getDiag", na.wrap(na.getDiag)).
+		Doc("The diagnostics of the node")
+	handle("/node/getProfile", na.wrap(na.getProfile)).
+		Doc("A runtime profile of the node").
+		Param("type", "cpu, heap, goroutine, block, mutex, allocs or threadcreate").
+		Param("seconds", "duration of the cpu profile")
+	handle("/node/getTrace", na.wrap(na.getTrace)).
+		Doc("An execution trace of the node").
+		Param("seconds", "duration of the trace")
+	handle("/node/getPeerLists", na.wrap(na.getPeerLists)).
+		Doc("The peer lists of the node")
+	handle("/node/getPeers", na.wrap(na.getPeers)).
+		Doc("What the transports learned of the other nodes")
+	handle("/node/getOnboarding", na.wrap(na.getOnboarding)).
+		Doc("The onboarding summary of the node")
+	handle("/node/getMaintenance", na.wrap(na.getMaintenance)).
+		Doc("The maintenance of the node")
+	handle("/node/pair", na.wrap(na.pair)).
+		Doc("Pair the node with a manager").
+		Param("manager", "the key of the manager").
+		Param("sig", "the signature of the manager").
+		Param("code", "the pairing code")
+	handle("/node/reboot", na.wrap(na.runReboot)).
+		Doc("Reboot the host of the node")
+	handle("/node/run/maintenance", na.wrap(na.runMaintenance)).
+		Doc("Put the node in maintenance").
+		Param("duration", "how long, like 30m")
+	handle("/node/run/sshs", na.wrap(na.runSshs)).
+		Doc("Run the ssh server app").
+		Param("data", "the keys allowed to connect, separated by commas")
+	handle("/node/run/sshc", na.wrap(na.runSshc)).
+		Doc("Run the ssh client app").
+		Param("toNode", "the key of the node to connect to").
+		Param("toApp", "the key of the app to connect to").
+		Param("discoveryKey", "the key of the discovery")
+	handle("/node/run/sockss", na.wrap(na.runSockss)).
+		Doc("Run the socks server app")
+	handle("/node/run/socksc", na.wrap(na.runSocksc)).
+		Doc("Run the socks client app").
+		Param("toNode", "the key of the node to connect to").
+		Param("toApp", "the key of the app to connect to").
+		Param("discoveryKey", "the key of the discovery")
+	handle("/node/run/update", na.wrap(na.update)).
+		Doc("Update the node")
+	handle("/node/run/checkUpdate", na.wrap(na.checkUpdate)).
+		Doc("Check whether an update of the node is available")
+	handle("/node/run/setNodeConfig", na.wrap(na.setNodeConfig)).
+		Doc("Set the config of the node").
+		Param("key", "the key of the node").
+		Param("data", "the config")
+	handle("/node/run/updateNode", na.wrap(na.updateNode)).
+		Doc("Update the node with the update script")
+	handle("/node/run/runShell", na.wrapShell(na.runShell)).
+		Doc("Run a shell on the node")
+	handle("/node/run/runCmd", na.wrapShell(na.runCmd)).
+		Doc("Run a command in the shell").
+		Param("command", "the command")
+	handle("/node/run/getShellOutput", na.wrapShell(na.getShellOutput)).
+		Doc("The output of the shell")
+	handle("/node/run/searchServices", na.wrap(na.search)).
+		Doc("Search the discoveries for the nodes running an app").
+		Param("key", "the key of the app").
+		Param("pages", "the page").
+		Param("limit", "the most results in the page").
+		Param("discoveryKey", "the key of the discovery")
+	handle("/node/run/getSearchServicesResult", na.wrap(na.getSearchResult)).
+		Doc("The results of the search")
+	handle("/node/run/getAutoStartConfig", na.wrap(na.getAutoStartConfig)).
+		Doc("The apps started with the node").
+		Param("key", "the key of the node")
+	handle("/node/run/setAutoStartConfig", na.wrap(na.setAutoStartConfig)).
+		Doc("Set the apps started with the node").
+		Param("key", "the key of the node").
+		Param("data", "the config")
+	handle("/node/run/closeApp", na.wrap(na.closeApp)).
+		Doc("Close an app").
+		Param("key", "the key of the app")
+	handle("/node/run/listAppFiles", na.wrap(na.listAppFiles)).
+		Doc("The config files of an app").
+		Param("app", "the name of the app")
+	handle("/node/run/readAppFile", na.wrap(na.readAppFile)).
+		Doc("Read a config file of an app").
+		Param("app", "the name of the app").
+		Param("path", "the path of the file")
+	handle("/node/run/writeAppFile", na.wrap(na.writeAppFile)).
+		Doc("Write a config file of an app").
+		Param("app", "the name of the app").
+		Param("path", "the path of the file").
+		Param("data", "the content of the file")
+	handle("/node/run/setPeerLists", na.wrap(na.setPeerLists)).
+		Doc("Set the peer lists of the node").
+		Param("data", "the peer lists")
 	http.HandleFunc("/node/run/term", na.handleXtermsocket)
+	spec.Describe(http.MethodGet, "/node/run/term").
+		Doc("A terminal of the node over a websocket, with the token in the manager-token header")
+	http.Handle(httputil.SpecPath, spec)
 	na.srv.Handler = http.DefaultServeMux
 	go func() {
-		log.Debugf("http server listening on %s", na.address)
-		if err := na.srv.ListenAndServe(); err != nil {
-			log.Errorf("http server: ListenAndServe() error: %s", err)
+		if na.listener != nil {
+			log.Debugf("http server listening on %s", na.listener.Addr())
+		} else {
+			log.Debugf("http server listening on %s", na.address)
+		}
+		err := httputil.ServeListener(context.Background(), na.srv, na.listener)
+		if err != nil {
+			log.Errorf("http server: %s", err)
 		}
 	}()
 }
@@ -143,12 +318,17 @@ func (na *NodeApi) getSig(w http.ResponseWriter, r *http.Request) (result []byte
 		err = errors.New("Hash is Empty!")
 		return
 	}
+	if na.node.ClockSkewed() {
+		err = errors.New("the clock of the node is skewed, refusing to sign")
+		return
+	}
 	hash := cipher.SumSHA256([]byte(data))
 	sc, err := factory.ReadSeedConfig(na.config.SeedPath)
 	if err != nil {
 		return
 	}
 	secKey := cipher.MustSecKeyFromHex(sc.SecKey)
+	defer util.WipeSecKey(&secKey)
 	sig := &Sig{
 		Sig: cipher.SignHash(hash, secKey).Hex(),
 	}
@@ -175,7 +355,12 @@ func (na *NodeApi) closeApp(w http.ResponseWriter, r *http.Request) (result []by
 	return
 }
 
+// getInfo answers the info of the node, without the transports if transports=false
 func (na *NodeApi) getInfo(w http.ResponseWriter, r *http.Request) (result []byte, err error) {
+	if r.FormValue("transports") == "false" {
+		result, err = json.Marshal(na.node.GetNodeInfoWithoutTransports())
+		return
+	}
 	result, err = json.Marshal(na.node.GetNodeInfo())
 	if err != nil {
 		return
@@ -183,6 +368,19 @@ func (na *NodeApi) getInfo(w http.ResponseWriter, r *http.Request) (result []byt
 	return
 }
 
+// getTransports answers a page of up to limit transports after the cursor
+func (na *NodeApi) getTransports(w http.ResponseWriter, r *http.Request) (result []byte, err error) {
+	var limit int
+	if l := r.FormValue("limit"); len(l) > 0 {
+		limit, err = strconv.Atoi(l)
+		if err != nil {
+			return
+		}
+	}
+	result, err = json.Marshal(na.node.GetTransports(r.FormValue("cursor"), limit))
+	return
+}
+
 func (na *NodeApi) getMsg(w http.ResponseWriter, r *http.Request) (result []byte, err error) {
 	k, err := cipher.PubKeyFromHex(r.FormValue("key"))
 	if err != nil {
@@ -203,16 +401,191 @@ func (na *NodeApi) getApps(w http.ResponseWriter, r *http.Request) (result []byt
 	return
 }
 
+func (na *NodeApi) getMetrics(w http.ResponseWriter, r *http.Request) (result []byte, err error) {
+	result, err = json.Marshal(na.node.GetMetrics())
+	return
+}
+
+// explainRoute answers the replay of the choice of the discoveries of a transport
+func (na *NodeApi) explainRoute(w http.ResponseWriter, r *http.Request) (result []byte, err error) {
+	e, ok := na.node.ExplainRoute(r.FormValue("id"))
+	if !ok {
+		err = fmt.Errorf("no route decision %s, the node keeps the last ones", r.FormValue("id"))
+		return
+	}
+	result, err = json.Marshal(e)
+	return
+}
+
+// getUsage answers the usage reports ending after the unix time since, as json or
+// with format=csv as csv
+func (na *NodeApi) getUsage(w http.ResponseWriter, r *http.Request) (result []byte, err error) {
+	var since int64
+	if s := r.FormValue("since"); len(s) > 0 {
+		since, err = strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return
+		}
+	}
+	reports := na.node.GetUsageReports(time.Unix(since, 0))
+	if r.FormValue("format") == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		result = node.UsageCSV(reports)
+		return
+	}
+	if reports == nil {
+		reports = []node.UsageReport{}
+	}
+	result, err = json.Marshal(reports)
+	return
+}
+
+func (na *NodeApi) listAppFiles(w http.ResponseWriter, r *http.Request) (result []byte, err error) {
+	files, err := na.node.ListAppFiles(r.FormValue("app"))
+	if err != nil {
+		return
+	}
+	result, err = json.Marshal(files)
+	return
+}
+
+func (na *NodeApi) readAppFile(w http.ResponseWriter, r *http.Request) (result []byte, err error) {
+	result, err = na.node.ReadAppFile(r.FormValue("app"), r.FormValue("path"))
+	if err != nil {
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	return
+}
+
+func (na *NodeApi) writeAppFile(w http.ResponseWriter, r *http.Request) (result []byte, err error) {
+	err = na.node.WriteAppFile(r.FormValue("app"), r.FormValue("path"), []byte(r.FormValue("data")))
+	if err != nil {
+		return
+	}
+	result = []byte("true")
+	return
+}
+
+func (na *NodeApi) getPeerLists(w http.ResponseWriter, r *http.Request) (result []byte, err error) {
+	result, err = json.Marshal(na.node.GetPeerLists())
+	return
+}
+
+func (na *NodeApi) getPeers(w http.ResponseWriter, r *http.Request) (result []byte, err error) {
+	peers := na.node.GetPeers()
+	if peers == nil {
+		peers = []factory.PeerRecord{}
+	}
+	result, err = json.Marshal(peers)
+	return
+}
+
+func (na *NodeApi) getOnboarding(w http.ResponseWriter, r *http.Request) (result []byte, err error) {
+	o := na.node.GetOnboarding()
+	if o == nil {
+		err = errors.New("the node has no onboarding summary")
+		return
+	}
+	result, err = json.Marshal(o)
+	return
+}
+
+func (na *NodeApi) getMaintenance(w http.ResponseWriter, r *http.Request) (result []byte, err error) {
+	m := na.node.GetMaintenance()
+	if m == nil {
+		m = &node.MaintenanceStatus{Events: []node.MaintenanceEvent{}}
+	}
+	result, err = json.Marshal(m)
+	return
+}
+
+// runMaintenance drains the node for the duration, 0 ends the maintenance
+func (na *NodeApi) runMaintenance(w http.ResponseWriter, r *http.Request) (result []byte, err error) {
+	d, err := time.ParseDuration(r.FormValue("duration"))
+	if err != nil {
+		return
+	}
+	na.node.StartMaintenance(d)
+	return na.getMaintenance(w, r)
+}
+
+type pairResp struct {
+	Node string `json:"node"`
+	Sig  string `json:"sig"`
+}
+
+// pair trusts the manager that signed the pairing code of the node
+func (na *NodeApi) pair(w http.ResponseWriter, r *http.Request) (result []byte, err error) {
+	hexKey := r.FormValue("manager")
+	if len(hexKey) != 2*len(cipher.PubKey{}) {
+		err = errors.New("invalid manager key")
+		return
+	}
+	manager, err := cipher.PubKeyFromHex(hexKey)
+	if err != nil {
+		return
+	}
+	sig, err := cipher.SigFromHex(r.FormValue("sig"))
+	if err != nil {
+		return
+	}
+	nodeSig, err := na.node.Pair(r.FormValue("code"), manager, sig)
+	if err != nil {
+		return
+	}
+	key, err := na.node.GetNodeKey()
+	if err != nil {
+		return
+	}
+	result, err = json.Marshal(pairResp{Node: key, Sig: nodeSig.Hex()})
+	return
+}
+
+func (na *NodeApi) setPeerLists(w http.ResponseWriter, r *http.Request) (result []byte, err error) {
+	var l factory.PeerLists
+	err = json.Unmarshal([]byte(r.FormValue("data")), &l)
+	if err != nil {
+		return
+	}
+	err = na.node.ApplyPeerLists(l)
+	if err != nil {
+		return
+	}
+	result = []byte("true")
+	return
+}
+
+// diag of the node with its config, the keys are not part of it
+type diag struct {
+	node.Diagnostics
+	Config    node.Config         `json:"config"`
+	AutoStart *node.AutoStartFile `json:"auto_start,omitempty"`
+}
+
+func (na *NodeApi) getDiag(w http.ResponseWriter, r *http.Request) (result []byte, err error) {
+	d := diag{
+		Diagnostics: na.node.GetDiagnostics(),
+		Config:      *na.config,
+	}
+	f, e := na.node.ReadAutoStartConfig()
+	if e == nil {
+		d.AutoStart = &f
+	}
+	result, err = json.Marshal(d)
+	return
+}
+
 func (na *NodeApi) wrap(fn func(w http.ResponseWriter, r *http.Request) (result []byte, err error)) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		token := r.FormValue("token")
-		if token != na.token {
+		if !util.ConstantTimeEqualString(token, na.token) {
 			w.Write([]byte("manager token is null"))
 			return
 		}
 		result, err := fn(w, r)
 		if err != nil {
-			http.Error(w, err.Error(), 500)
+			httputil.WriteError(w, err)
 			return
 		}
 		if len(w.Header().Get("Content-Type")) <= 0 {
@@ -292,7 +665,7 @@ func (na *NodeApi) startSshc(toNode, toApp, disvoerveryKey string) (err error) {
 		return
 	}
 	go func() {
-		cmd.Wait()
+		na.appExited(key, app, cmd.Wait())
 		close(isOk)
 	}()
 	return
@@ -342,7 +715,7 @@ func (na *NodeApi) startSocksc(toNode, toApp, disvoerveryKey string) (err error)
 		return
 	}
 	go func() {
-		cmd.Wait()
+		na.appExited(key, app, cmd.Wait())
 		close(isOk)
 	}()
 	return
@@ -392,7 +765,7 @@ func (na *NodeApi) startSshs(arr []string) (err error) {
 		return
 	}
 	go func() {
-		cmd.Wait()
+		na.appExited(key, app, cmd.Wait())
 		close(isOk)
 	}()
 	return
@@ -430,7 +803,7 @@ func (na *NodeApi) startSockss() (err error) {
 		return
 	}
 	go func() {
-		cmd.Wait()
+		na.appExited(key, app, cmd.Wait())
 		close(isOk)
 	}()
 
@@ -452,10 +825,24 @@ func (na *NodeApi) checkUpdate(w http.ResponseWriter, r *http.Request) (result [
 	if err != nil {
 		return
 	}
+	if strings.TrimSpace(string(out)) == "true" {
+		na.node.Event(node.EventUpdateAvailable, map[string]string{"version": node.Version})
+	}
 	result = out
 	return
 }
 
+// appExited runs the hooks of a crash when the app exited with an error it was not stopped by
+func (na *NodeApi) appExited(key string, app *appCxt, err error) {
+	if err == nil || app.cxt.Err() != nil {
+		return
+	}
+	na.node.Event(node.EventAppCrashed, map[string]string{
+		"app":   key,
+		"error": err.Error(),
+	})
+}
+
 func (na *NodeApi) update(w http.ResponseWriter, r *http.Request) (result []byte, err error) {
 	var cmd *exec.Cmd
 	var gopath = os.Getenv("GOPATH")
@@ -474,6 +861,10 @@ func (na *NodeApi) update(w http.ResponseWriter, r *http.Request) (result []byte
 }
 
 func (na *NodeApi) setNodeConfig(w http.ResponseWriter, r *http.Request) (result []byte, err error) {
+	if na.node.IsStateless() {
+		err = errors.New("node is stateless, set the config by flags or environment")
+		return
+	}
 	key := r.FormValue("key")
 	if len(key) != 66 {
 		err = errors.New("invalid key")
@@ -710,7 +1101,12 @@ var upgrader = websocket.Upgrader{
 
 func (na *NodeApi) handleXtermsocket(w http.ResponseWriter, r *http.Request) {
 	token := r.Header.Get("manager-token")
-	if token != na.token {
+	if !util.ConstantTimeEqualString(token, na.token) {
+		return
+	}
+	if err := na.verifyShell(r); err != nil {
+		log.Warnf("terminal refused: %v", err)
+		httputil.Fail(w, err.Error(), http.StatusForbidden)
 		return
 	}
 	xterm(w, r)
@@ -859,7 +1255,7 @@ func (na *NodeApi) getAutoStartConfig(w http.ResponseWriter, r *http.Request) (r
 				return
 			}
 		} else {
-			log.Errorf("read launch config err:", err)
+			log.Errorf("read launch config err: %v", err)
 			return
 		}
 	}
@@ -890,7 +1286,7 @@ func (na *NodeApi) setAutoStartConfig(w http.ResponseWriter, r *http.Request) (r
 				return
 			}
 		} else {
-			log.Errorf("read launch config err:", err)
+			log.Errorf("read launch config err: %v", err)
 			return
 		}
 	}
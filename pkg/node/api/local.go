@@ -0,0 +1,229 @@
+package api
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/skycoin/skywire/pkg/httputil"
+	"github.com/skycoin/skywire/pkg/net/skycoin-messenger/factory"
+	"github.com/skycoin/skywire/pkg/node"
+)
+
+// LocalApiPrefix is the path of the version of the local api, the paths of its resources follow
+const LocalApiPrefix = "/api/v1"
+
+// the resources are answered from the state of the node, 503 after this long
+const localApiTimeout = 10 * time.Second
+
+// LocalApi serves the state of the node as JSON resources to the scripts and dashboards of its
+// host, without the token of the manager. It only reads, what changes the node goes through
+// the manager
+type LocalApi struct {
+	node   *node.Node
+	config *node.Config
+	router *httputil.Router
+	srv    *http.Server
+	ln     net.Listener
+}
+
+func NewLocal(addr string, n *node.Node, config *node.Config) *LocalApi {
+	la := &LocalApi{node: n, config: config, router: httputil.NewRouter()}
+	r := la.router
+	r.Timeout = localApiTimeout
+	r.Get(LocalApiPrefix+"/info", la.getInfo).
+		Doc("The info of the node without its transports").
+		Returns(node.NodeInfo{})
+	r.Get(LocalApiPrefix+"/config", la.getConfig).
+		Doc("The config the node runs with").
+		Returns(node.Config{})
+	r.Get(LocalApiPrefix+"/transports", la.getTransports).
+		Doc("A page of the transports of the node").
+		Param("limit", "the most transports in the page").
+		Param("cursor", "the next of the previous page").
+		Returns(node.TransportPage{})
+	r.Get(LocalApiPrefix+"/transports/{id}", la.getTransport).
+		Doc("The transport with the id of its record").
+		Returns(node.NodeTransport{})
+	r.Get(LocalApiPrefix+"/transports/{id}/loops", la.getLoops).
+		Doc("The state of the connections of the apps carried by the transport").
+		Returns(map[uint32]string{})
+	r.Get(LocalApiPrefix+"/routes", la.getRoutes).
+		Doc("The answers of the discoveries kept for the transports of the apps").
+		Returns([]factory.CachedRoute{})
+	r.Get(LocalApiPrefix+"/apps", la.getApps).
+		Doc("The apps registered with the node").
+		Returns([]node.NodeApp{})
+	r.Get(LocalApiPrefix+"/peers", la.getPeers).
+		Doc("What the transports learned of the other nodes").
+		Returns([]factory.PeerRecord{})
+	r.Get(LocalApiPrefix+"/subsystems", la.getSubsystems).
+		Doc("The subsystems of the node").
+		Returns([]node.SubsystemStatus{})
+	r.Get(LocalApiPrefix+"/metrics", la.getMetrics).
+		Doc("The metrics of the node").
+		Returns(node.Metrics{})
+	r.Get(LocalApiPrefix+"/usage", la.getUsage).
+		Doc("The usage reports ending after a time").
+		Param("since", "unix time").
+		Returns([]node.UsageReport{})
+	r.Get(LocalApiPrefix+"/maintenance", la.getMaintenance).
+		Doc("The maintenance of the node").
+		Returns(node.MaintenanceStatus{})
+	r.Get(LocalApiPrefix+"/bandwidth", la.getBandwidth).
+		Doc("The last results of the bandwidth tests of the node").
+		Returns(node.BandwidthTests{})
+	r.ServeSpec("Skywire Node Local API", node.Version)
+	la.srv = &http.Server{Addr: addr, Handler: r}
+	return la
+}
+
+// SetListener makes the api serve on a listener passed by the service manager instead of
+// listening on its address
+func (la *LocalApi) SetListener(ln net.Listener) {
+	la.ln = ln
+}
+
+// Start listens on the address of the api
+func (la *LocalApi) Start() (err error) {
+	if la.ln == nil {
+		la.ln, err = net.Listen("tcp", la.srv.Addr)
+		if err != nil {
+			return
+		}
+	}
+	log.Infof("local api listening on %s", la.ln.Addr())
+	go func() {
+		err := httputil.ServeListener(context.Background(), la.srv, la.ln)
+		if err != nil {
+			log.Errorf("local api: %v", err)
+		}
+	}()
+	return
+}
+
+// Addr returns the address the api listens on, nil before it is started
+func (la *LocalApi) Addr() net.Addr {
+	if la.ln == nil {
+		return nil
+	}
+	return la.ln.Addr()
+}
+
+func (la *LocalApi) Close() error {
+	return httputil.Shutdown(la.srv)
+}
+
+func (la *LocalApi) getInfo(r *http.Request, p httputil.Params) (interface{}, error) {
+	return la.node.GetNodeInfoWithoutTransports(), nil
+}
+
+// getConfig answers the config with the discoveries the node runs with, read from the node
+// config when no flag set them
+func (la *LocalApi) getConfig(r *http.Request, p httputil.Params) (interface{}, error) {
+	c := *la.config
+	if len(c.DiscoveryAddresses) == 0 {
+		c.DiscoveryAddresses = la.node.GetDiscoveries()
+	}
+	return c, nil
+}
+
+// getTransports answers a page of up to limit transports after the cursor, like /node/getTransports
+func (la *LocalApi) getTransports(r *http.Request, p httputil.Params) (interface{}, error) {
+	var limit int
+	if l := r.FormValue("limit"); len(l) > 0 {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil {
+			return nil, httputil.Errorf(http.StatusBadRequest, "invalid limit %q", l)
+		}
+	}
+	return la.node.GetTransports(r.FormValue("cursor"), limit), nil
+}
+
+func (la *LocalApi) getTransport(r *http.Request, p httputil.Params) (interface{}, error) {
+	t, ok := la.node.GetTransport(p["id"])
+	if !ok {
+		return nil, httputil.Errorf(http.StatusNotFound, "no transport %s", p["id"])
+	}
+	return t, nil
+}
+
+func (la *LocalApi) getLoops(r *http.Request, p httputil.Params) (interface{}, error) {
+	t, ok := la.node.GetTransport(p["id"])
+	if !ok {
+		return nil, httputil.Errorf(http.StatusNotFound, "no transport %s", p["id"])
+	}
+	if t.Loops == nil {
+		return map[uint32]string{}, nil
+	}
+	return t.Loops, nil
+}
+
+func (la *LocalApi) getRoutes(r *http.Request, p httputil.Params) (interface{}, error) {
+	routes := la.node.GetRoutes()
+	if routes == nil {
+		routes = []factory.CachedRoute{}
+	}
+	return routes, nil
+}
+
+func (la *LocalApi) getApps(r *http.Request, p httputil.Params) (interface{}, error) {
+	apps := la.node.GetApps()
+	if apps == nil {
+		apps = []node.NodeApp{}
+	}
+	return apps, nil
+}
+
+func (la *LocalApi) getPeers(r *http.Request, p httputil.Params) (interface{}, error) {
+	peers := la.node.GetPeers()
+	if peers == nil {
+		peers = []factory.PeerRecord{}
+	}
+	return peers, nil
+}
+
+func (la *LocalApi) getSubsystems(r *http.Request, p httputil.Params) (interface{}, error) {
+	s := la.node.GetSubsystems()
+	if s == nil {
+		s = []node.SubsystemStatus{}
+	}
+	return s, nil
+}
+
+func (la *LocalApi) getMetrics(r *http.Request, p httputil.Params) (interface{}, error) {
+	return la.node.GetMetrics(), nil
+}
+
+// getUsage answers the usage reports ending after the unix time since
+func (la *LocalApi) getUsage(r *http.Request, p httputil.Params) (interface{}, error) {
+	var since int64
+	if s := r.FormValue("since"); len(s) > 0 {
+		var err error
+		since, err = strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, httputil.Errorf(http.StatusBadRequest, "invalid since %q", s)
+		}
+	}
+	reports := la.node.GetUsageReports(time.Unix(since, 0))
+	if reports == nil {
+		reports = []node.UsageReport{}
+	}
+	return reports, nil
+}
+
+func (la *LocalApi) getMaintenance(r *http.Request, p httputil.Params) (interface{}, error) {
+	m := la.node.GetMaintenance()
+	if m == nil {
+		m = &node.MaintenanceStatus{Events: []node.MaintenanceEvent{}}
+	}
+	return m, nil
+}
+
+func (la *LocalApi) getBandwidth(r *http.Request, p httputil.Params) (interface{}, error) {
+	return la.node.GetBandwidthTests(), nil
+}
@@ -0,0 +1,96 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/skycoin/skywire/pkg/httputil"
+	"github.com/skycoin/skywire/pkg/node"
+	"github.com/skycoin/skywire/pkg/node/api"
+	"github.com/skycoin/skywire/pkg/node/nodetest"
+)
+
+// openLoop starts two nodes with an open loop of a transport between them and returns the
+// dialing node, the loop is closed with the connection
+func openLoop(t *testing.T) (e *nodetest.Env, a *nodetest.Node, conn net.Conn) {
+	e = nodetest.NewEnv(t, 1)
+	a, b := e.StartNode("a"), e.StartNode("b")
+	server := e.ConnectApp(b, "server")
+	server.Offer(e.Echo(), "echo")
+	port := e.ConnectApp(a, "client").Connect(b.Key, server.GetKey(), e.DiscoveryKey(0)).Port
+	conn = nodetest.Ping(t, port)
+	nodetest.WaitFor(t, "the open loop", func() bool {
+		tr := a.GetNodeInfo().Transports
+		return len(tr) == 1 && tr[0].Loops[1] == "open"
+	})
+	return
+}
+
+func TestLocalApi(t *testing.T) {
+	e, a, conn := openLoop(t)
+	defer e.Close()
+	defer conn.Close()
+	la := api.NewLocal("127.0.0.1:0", a.Node, &node.Config{})
+	if err := la.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer la.Close()
+	id := a.GetTransports("", 0).Transports[0].ID
+	// the errors are answered in the envelope with their code
+	code := func(code string) func(v interface{}) bool {
+		return func(v interface{}) bool {
+			e := v.(*httputil.Error)
+			return e.Code == code && len(e.Message) > 0 && !e.Retryable
+		}
+	}
+	type spec struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+
+	for _, c := range []struct {
+		path   string
+		status int
+		// the body is decoded into v and checked by ok
+		v  interface{}
+		ok func(v interface{}) bool
+	}{
+		{path: api.LocalApiPrefix + "/info", status: http.StatusOK, v: &node.NodeInfo{}, ok: func(v interface{}) bool {
+			return len(v.(*node.NodeInfo).Transports) == 0
+		}},
+		{path: api.LocalApiPrefix + "/transports", status: http.StatusOK, v: &node.TransportPage{}, ok: func(v interface{}) bool {
+			return len(v.(*node.TransportPage).Transports) == 1
+		}},
+		{path: api.LocalApiPrefix + "/transports?limit=x", status: http.StatusBadRequest, v: &httputil.Error{}, ok: code(httputil.CodeBadRequest)},
+		{path: api.LocalApiPrefix + "/transports/" + id, status: http.StatusOK, v: &node.NodeTransport{}, ok: func(v interface{}) bool {
+			return v.(*node.NodeTransport).ID == id
+		}},
+		{path: api.LocalApiPrefix + "/transports/unknown", status: http.StatusNotFound, v: &httputil.Error{}, ok: code(httputil.CodeNotFound)},
+		{path: api.LocalApiPrefix + "/transports/" + id + "/loops", status: http.StatusOK, v: &map[string]string{}, ok: func(v interface{}) bool {
+			return (*v.(*map[string]string))["1"] == "open"
+		}},
+		{path: api.LocalApiPrefix + "/transports/unknown/loops", status: http.StatusNotFound, v: &httputil.Error{}, ok: code(httputil.CodeNotFound)},
+		{path: api.LocalApiPrefix + "/peers", status: http.StatusOK},
+		{path: api.LocalApiPrefix + "/subsystems", status: http.StatusOK},
+		{path: httputil.SpecPath, status: http.StatusOK, v: &spec{}, ok: func(v interface{}) bool {
+			return len(v.(*spec).Paths[api.LocalApiPrefix+"/transports/{id}/loops"]["get"]) > 0
+		}},
+	} {
+		resp, err := http.Get("http://" + la.Addr().String() + c.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.v != nil {
+			err = json.NewDecoder(resp.Body).Decode(c.v)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != c.status || err != nil {
+			t.Errorf("%s: %d, want %d: %v", c.path, resp.StatusCode, c.status, err)
+			continue
+		}
+		if c.ok != nil && !c.ok(c.v) {
+			t.Errorf("%s: %#v", c.path, c.v)
+		}
+	}
+}
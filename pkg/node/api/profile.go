@@ -0,0 +1,102 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"runtime/pprof"
+	"runtime/trace"
+	"strconv"
+	"time"
+
+	"github.com/skycoin/skywire/pkg/httputil"
+)
+
+const (
+	defaultProfileSeconds = 30
+	maxProfileSeconds     = 300
+)
+
+func profileDuration(r *http.Request) (d time.Duration, err error) {
+	seconds := defaultProfileSeconds
+	if s := r.FormValue("seconds"); len(s) > 0 {
+		seconds, err = strconv.Atoi(s)
+		if err != nil {
+			err = httputil.Errorf(http.StatusBadRequest, "invalid seconds %q", s)
+			return
+		}
+	}
+	if seconds < 1 || seconds > maxProfileSeconds {
+		err = httputil.Errorf(http.StatusBadRequest, "seconds must be between 1 and %d", maxProfileSeconds)
+		return
+	}
+	d = time.Duration(seconds) * time.Second
+	return
+}
+
+// wait the duration of a capture unless the request is canceled
+func capture(r *http.Request, d time.Duration) {
+	select {
+	case <-time.After(d):
+	case <-r.Context().Done():
+	}
+}
+
+func setProfileHeaders(w http.ResponseWriter, name string) {
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, name))
+}
+
+// getProfile captures a cpu profile for seconds or writes the named runtime profile
+// (heap, goroutine, block, mutex, allocs, threadcreate) in the pprof format
+func (na *NodeApi) getProfile(w http.ResponseWriter, r *http.Request) (result []byte, err error) {
+	name := r.FormValue("type")
+	if len(name) == 0 {
+		name = "cpu"
+	}
+	var b bytes.Buffer
+	if name == "cpu" {
+		var d time.Duration
+		d, err = profileDuration(r)
+		if err != nil {
+			return
+		}
+		err = pprof.StartCPUProfile(&b)
+		if err != nil {
+			return
+		}
+		capture(r, d)
+		pprof.StopCPUProfile()
+	} else {
+		p := pprof.Lookup(name)
+		if p == nil {
+			err = httputil.Errorf(http.StatusBadRequest, "unknown profile %s", name)
+			return
+		}
+		err = p.WriteTo(&b, 0)
+		if err != nil {
+			return
+		}
+	}
+	setProfileHeaders(w, name+".pprof")
+	result = b.Bytes()
+	return
+}
+
+// getTrace captures an execution trace for seconds, see go tool trace
+func (na *NodeApi) getTrace(w http.ResponseWriter, r *http.Request) (result []byte, err error) {
+	d, err := profileDuration(r)
+	if err != nil {
+		return
+	}
+	var b bytes.Buffer
+	err = trace.Start(&b)
+	if err != nil {
+		return
+	}
+	capture(r, d)
+	trace.Stop()
+	setProfileHeaders(w, "trace.out")
+	result = b.Bytes()
+	return
+}
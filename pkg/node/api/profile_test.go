@@ -0,0 +1,71 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func requestProfile(ctx context.Context, na *NodeApi, fn func(w http.ResponseWriter, r *http.Request) ([]byte, error), form url.Values) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(form.Encode())).WithContext(ctx)
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	na.wrap(fn)(w, r)
+	return w
+}
+
+func TestProfile(t *testing.T) {
+	na := &NodeApi{token: "manager"}
+	ctx := context.Background()
+	// the profiles are gzipped protocol buffers
+	gz := []byte{0x1f, 0x8b}
+	for _, typ := range []string{"heap", "goroutine"} {
+		w := requestProfile(ctx, na, na.getProfile, url.Values{"token": {"manager"}, "type": {typ}})
+		if w.Code != http.StatusOK || !bytes.HasPrefix(w.Body.Bytes(), gz) {
+			t.Fatalf("%s profile: %d %q", typ, w.Code, w.Body.Bytes())
+		}
+		if d := w.Header().Get("Content-Disposition"); !strings.Contains(d, typ+".pprof") {
+			t.Fatalf("%s profile saved as %s", typ, d)
+		}
+	}
+	start := time.Now()
+	w := requestProfile(ctx, na, na.getProfile, url.Values{"token": {"manager"}, "seconds": {"1"}})
+	if w.Code != http.StatusOK || !bytes.HasPrefix(w.Body.Bytes(), gz) || time.Since(start) < time.Second {
+		t.Fatalf("cpu profile: %d in %s", w.Code, time.Since(start))
+	}
+	w = requestProfile(ctx, na, na.getTrace, url.Values{"token": {"manager"}, "seconds": {"1"}})
+	if w.Code != http.StatusOK || w.Body.Len() == 0 || !strings.Contains(w.Header().Get("Content-Disposition"), "trace.out") {
+		t.Fatalf("trace: %d %s", w.Code, w.Header())
+	}
+
+	// a capture ends with the request
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	start = time.Now()
+	w = requestProfile(canceled, na, na.getTrace, url.Values{"token": {"manager"}, "seconds": {"300"}})
+	if w.Code != http.StatusOK || time.Since(start) > 10*time.Second {
+		t.Fatalf("canceled trace: %d in %s", w.Code, time.Since(start))
+	}
+
+	for _, form := range []url.Values{
+		{"type": {"unknown"}},
+		{"seconds": {"0"}},
+		{"seconds": {"301"}},
+		{"seconds": {"a minute"}},
+	} {
+		form.Set("token", "manager")
+		if w = requestProfile(ctx, na, na.getProfile, form); w.Code != http.StatusBadRequest {
+			t.Errorf("%v: %d %s", form, w.Code, w.Body)
+		}
+	}
+	// only the manager gets the profiles
+	w = requestProfile(ctx, na, na.getProfile, url.Values{"token": {"other"}, "type": {"heap"}})
+	if bytes.HasPrefix(w.Body.Bytes(), gz) {
+		t.Fatal("profile without the token of the manager")
+	}
+}
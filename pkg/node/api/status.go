@@ -0,0 +1,142 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/skycoin/skywire/pkg/httputil"
+	"github.com/skycoin/skywire/pkg/node"
+)
+
+// the status is read from the state of the node, 503 after this long
+const statusPageTimeout = 5 * time.Second
+
+var statusTemplate = template.Must(template.New("status").Funcs(template.FuncMap{
+	"uptime": func(s int64) string { return (time.Duration(s) * time.Second).String() },
+	"bytes":  formatBytes,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="60">
+<title>Skywire node {{.Key}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+td, th { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+</style>
+</head>
+<body>
+<h1>Skywire node</h1>
+<table>
+<tr><th>Key</th><td>{{.Key}}</td></tr>
+<tr><th>Version</th><td>{{.Version}}</td></tr>
+<tr><th>Uptime</th><td>{{uptime .Uptime}}</td></tr>
+<tr><th>Transports</th><td>{{.Transports}}</td></tr>
+<tr><th>Bandwidth</th><td>{{bytes .UploadBW}}/s up, {{bytes .DownloadBW}}/s down</td></tr>
+<tr><th>Carried</th><td>{{bytes .UploadTotal}} up, {{bytes .DownloadTotal}} down</td></tr>
+</table>
+<p><a href="status">json</a></p>
+</body>
+</html>
+`))
+
+func formatBytes(b uint64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+	div, exp := uint64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
+
+// StatusPage serves the public status of the node to anyone, as a page on / and as JSON on
+// /status, so the operator of a node can show it is healthy. It holds nothing of the other
+// nodes and the apps
+type StatusPage struct {
+	node   *node.Node
+	router *httputil.Router
+	srv    *http.Server
+	ln     net.Listener
+}
+
+func NewStatusPage(addr string, n *node.Node) *StatusPage {
+	sp := &StatusPage{node: n, router: httputil.NewRouter()}
+	r := sp.router
+	r.Timeout = statusPageTimeout
+	r.Get("/status", sp.getStatus)
+	mux := http.NewServeMux()
+	mux.Handle("/status", r)
+	mux.Handle("/", httputil.Timeout(statusPageTimeout, http.HandlerFunc(sp.page)))
+	sp.srv = &http.Server{Addr: addr, Handler: mux}
+	return sp
+}
+
+// SetListener makes the page serve on a listener passed by the service manager instead of
+// listening on its address
+func (sp *StatusPage) SetListener(ln net.Listener) {
+	sp.ln = ln
+}
+
+// Start listens on the address of the page
+func (sp *StatusPage) Start() (err error) {
+	if sp.ln == nil {
+		sp.ln, err = net.Listen("tcp", sp.srv.Addr)
+		if err != nil {
+			return
+		}
+	}
+	log.Infof("status page listening on %s", sp.ln.Addr())
+	go func() {
+		err := httputil.ServeListener(context.Background(), sp.srv, sp.ln)
+		if err != nil {
+			log.Errorf("status page: %v", err)
+		}
+	}()
+	return
+}
+
+// Addr returns the address the page listens on, nil before it is started
+func (sp *StatusPage) Addr() net.Addr {
+	if sp.ln == nil {
+		return nil
+	}
+	return sp.ln.Addr()
+}
+
+func (sp *StatusPage) Close() error {
+	return httputil.Shutdown(sp.srv)
+}
+
+func (sp *StatusPage) getStatus(r *http.Request, p httputil.Params) (interface{}, error) {
+	return sp.node.GetPublicStatus(), nil
+}
+
+func (sp *StatusPage) page(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		httputil.WriteError(w, httputil.Errorf(http.StatusNotFound, "no page %s", r.URL.Path))
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		httputil.WriteError(w, httputil.Errorf(http.StatusMethodNotAllowed, "%s is not allowed on %s", r.Method, r.URL.Path))
+		return
+	}
+	var b bytes.Buffer
+	if err := statusTemplate.Execute(&b, sp.node.GetPublicStatus()); err != nil {
+		httputil.WriteError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(b.Bytes())
+}
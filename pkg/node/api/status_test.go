@@ -0,0 +1,45 @@
+package api_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/skycoin/skywire/pkg/node"
+	"github.com/skycoin/skywire/pkg/node/api"
+)
+
+func TestStatusPage(t *testing.T) {
+	e, a, conn := openLoop(t)
+	defer e.Close()
+	defer conn.Close()
+
+	// the status page shows the sums of the transports without them
+	sp := api.NewStatusPage("127.0.0.1:0", a.Node)
+	if err := sp.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer sp.Close()
+	resp, err := http.Get("http://" + sp.Addr().String() + "/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var status node.PublicStatus
+	err = json.NewDecoder(resp.Body).Decode(&status)
+	resp.Body.Close()
+	if err != nil || status.Transports != 1 || status.Version != node.Version || status.UploadTotal == 0 {
+		t.Fatalf("status %v: %#v", err, status)
+	}
+	resp, err = http.Get("http://" + sp.Addr().String() + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	html, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	toNode := a.GetTransports("", 0).Transports[0].ToNode
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(html), "<td>1</td>") || strings.Contains(string(html), toNode) {
+		t.Fatalf("status page %d %s", resp.StatusCode, html)
+	}
+}
@@ -0,0 +1,212 @@
+package node
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// largest app config file read or written for the manager
+	MaxAppFileSize = 256 << 10
+	// files listed at most in the config directory of an app
+	maxAppFiles = 1000
+)
+
+// AppConfigDirs are the config directories of the apps the node runs, relative to the root
+// set by SetAppConfigRoot
+var AppConfigDirs = map[string]string{
+	"sshs":   "sshs",
+	"sshc":   "sshc",
+	"sockss": "ss",
+	"socksc": "sc",
+}
+
+var errAppFilesDisabled = errors.New("the app config files of the node are not shared")
+
+// AppFile is a file in the config directory of an app
+type AppFile struct {
+	// slash separated, relative to the directory
+	Path    string `json:"path"`
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mod_time"`
+}
+
+// SetAppConfigRoot shares the config directories of the apps under root with the manager,
+// an empty root shares nothing
+func (n *Node) SetAppConfigRoot(root string) {
+	n.appConfigRootMutex.Lock()
+	n.appConfigRoot = root
+	n.appConfigRootMutex.Unlock()
+}
+
+// appConfigDir returns the config directory of the app with the symlinks resolved
+func (n *Node) appConfigDir(app string) (dir string, err error) {
+	n.appConfigRootMutex.RLock()
+	root := n.appConfigRoot
+	n.appConfigRootMutex.RUnlock()
+	if len(root) < 1 {
+		err = errAppFilesDisabled
+		return
+	}
+	sub, ok := AppConfigDirs[app]
+	if !ok {
+		err = fmt.Errorf("unknown app %s", app)
+		return
+	}
+	dir, err = filepath.EvalSymlinks(filepath.Join(root, sub))
+	return
+}
+
+// the keys of the apps and the hidden files are never shared
+func hiddenAppFile(name string) bool {
+	return strings.HasPrefix(name, ".") || name == "keys.json"
+}
+
+// appFilePath returns the path of the file (name) in the directory, the file may not exist yet
+// but its directory must, and neither may lead out of the directory
+func appFilePath(dir, name string) (path string, err error) {
+	rel := filepath.FromSlash(name)
+	if filepath.IsAbs(rel) || strings.HasPrefix(rel, string(filepath.Separator)) {
+		err = fmt.Errorf("file %s is not relative to the app config directory", name)
+		return
+	}
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		if part == ".." {
+			err = fmt.Errorf("file %s is outside of the app config directory", name)
+			return
+		}
+	}
+	rel = filepath.Clean(rel)
+	if rel == "." {
+		err = errors.New("file name is empty")
+		return
+	}
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		if hiddenAppFile(part) {
+			err = fmt.Errorf("file %s is not shared", name)
+			return
+		}
+	}
+	path = filepath.Join(dir, rel)
+	parent, err := filepath.EvalSymlinks(filepath.Dir(path))
+	if err != nil {
+		return
+	}
+	if parent != dir && !strings.HasPrefix(parent, dir+string(filepath.Separator)) {
+		err = fmt.Errorf("file %s is outside of the app config directory", name)
+		return
+	}
+	path = filepath.Join(parent, filepath.Base(path))
+	fi, err := os.Lstat(path)
+	if err == nil && !fi.Mode().IsRegular() {
+		err = fmt.Errorf("%s is not a regular file", name)
+	} else if os.IsNotExist(err) {
+		err = nil
+	}
+	return
+}
+
+// ListAppFiles returns the files in the config directory of the app, the symlinks are skipped
+func (n *Node) ListAppFiles(app string) (files []AppFile, err error) {
+	dir, err := n.appConfigDir(app)
+	if err != nil {
+		return
+	}
+	files = []AppFile{}
+	err = filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != dir && hiddenAppFile(fi.Name()) {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		if len(files) >= maxAppFiles {
+			return fmt.Errorf("more than %d files", maxAppFiles)
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, AppFile{Path: filepath.ToSlash(rel), Size: fi.Size(), ModTime: fi.ModTime().Unix()})
+		return nil
+	})
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return
+}
+
+// ReadAppFile returns the content of the file (name) in the config directory of the app
+func (n *Node) ReadAppFile(app, name string) (data []byte, err error) {
+	dir, err := n.appConfigDir(app)
+	if err != nil {
+		return
+	}
+	path, err := appFilePath(dir, name)
+	if err != nil {
+		return
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	data, err = ioutil.ReadAll(io.LimitReader(f, MaxAppFileSize+1))
+	if err == nil && len(data) > MaxAppFileSize {
+		data, err = nil, fmt.Errorf("file %s is larger than %d bytes", name, MaxAppFileSize)
+	}
+	return
+}
+
+// WriteAppFile replaces the file (name) in the config directory of the app with data, or
+// creates it, the app reads it when it is started next
+func (n *Node) WriteAppFile(app, name string, data []byte) (err error) {
+	if len(data) > MaxAppFileSize {
+		return fmt.Errorf("file %s is larger than %d bytes", name, MaxAppFileSize)
+	}
+	dir, err := n.appConfigDir(app)
+	if err != nil {
+		return
+	}
+	path, err := appFilePath(dir, name)
+	if err != nil {
+		return
+	}
+	mode := os.FileMode(0600)
+	if fi, err := os.Stat(path); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	// written next to the file and renamed over it, so the app never reads half of it
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".appfile-")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Chmod(mode)
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return
+	}
+	log.Infof("app config file %s of %s written, %d bytes", name, app, len(data))
+	return
+}
@@ -0,0 +1,111 @@
+package node
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestAppFiles(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the symlinks need privileges")
+	}
+	root, err := ioutil.TempDir("", "appfiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	dir, outside := filepath.Join(root, AppConfigDirs["sshs"]), filepath.Join(root, "outside")
+	for _, d := range []string{dir, filepath.Join(dir, "sub"), outside} {
+		if err = os.Mkdir(d, 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write := func(path, data string) {
+		if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(filepath.Join(dir, "keys.json"), "keys")
+	write(filepath.Join(dir, ".hidden"), "hidden")
+	write(filepath.Join(outside, "secret"), "secret")
+	if err = os.Symlink(outside, filepath.Join(dir, "parent")); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Symlink(filepath.Join(outside, "secret"), filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	n := &Node{}
+	if err = n.WriteAppFile("sshs", "config.json", []byte("{}")); err != errAppFilesDisabled {
+		t.Fatalf("write without a root: %v", err)
+	}
+	n.SetAppConfigRoot(root)
+	if _, err = n.ReadAppFile("unknown", "config.json"); err == nil {
+		t.Fatal("file of an unknown app read")
+	}
+	for _, name := range []string{
+		"",
+		".",
+		"../outside/secret",
+		"sub/../../outside/secret",
+		"/config.json",
+		filepath.Join(outside, "secret"),
+		"keys.json",
+		"sub/keys.json",
+		".hidden",
+		".ssh/config",
+		"parent/secret",
+		"link",
+		"sub",
+	} {
+		if _, err := n.ReadAppFile("sshs", name); err == nil {
+			t.Errorf("%q read", name)
+		}
+		if err := n.WriteAppFile("sshs", name, []byte("written")); err == nil {
+			t.Errorf("%q written", name)
+		}
+	}
+	// nothing was replaced or created through them
+	for path, data := range map[string]string{
+		filepath.Join(dir, "keys.json"):    "keys",
+		filepath.Join(dir, ".hidden"):      "hidden",
+		filepath.Join(outside, "secret"):   "secret",
+		filepath.Join(root, "config.json"): "",
+	} {
+		if b, _ := ioutil.ReadFile(path); string(b) != data {
+			t.Errorf("%s holds %q", path, b)
+		}
+	}
+
+	// the files in the directory are written, read and listed, the hidden ones and the symlinks left out
+	if err = n.WriteAppFile("sshs", "sub/config.json", []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := n.ReadAppFile("sshs", "./sub/config.json"); err != nil || string(data) != "{}" {
+		t.Fatalf("read %q: %v", data, err)
+	}
+	files, err := n.ListAppFiles("sshs")
+	if err != nil || len(files) != 1 || files[0].Path != "sub/config.json" || files[0].Size != 2 {
+		t.Fatalf("files %+v: %v", files, err)
+	}
+
+	// up to the size limit
+	big := bytes.Repeat([]byte("a"), MaxAppFileSize)
+	if err = n.WriteAppFile("sshs", "big", big); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := n.ReadAppFile("sshs", "big"); err != nil || len(data) != MaxAppFileSize {
+		t.Fatalf("read %d bytes: %v", len(data), err)
+	}
+	if err = n.WriteAppFile("sshs", "big", append(big, 'a')); err == nil {
+		t.Fatal("file larger than the limit written")
+	}
+	write(filepath.Join(dir, "bigger"), string(big)+"a")
+	if _, err = n.ReadAppFile("sshs", "bigger"); err == nil {
+		t.Fatal("file larger than the limit read")
+	}
+}
@@ -0,0 +1,21 @@
+package node
+
+import (
+	log "github.com/sirupsen/logrus"
+	"github.com/skycoin/skywire/pkg/appnet"
+)
+
+// StartAppSocket serves the apps written in other languages on the unix socket at path
+// until the node is closed, the protocol is described in docs/api/AppSocket.md
+func (n *Node) StartAppSocket(path string) (err error) {
+	s, err := appnet.Listen(path, n.lnAddr)
+	if err != nil {
+		return
+	}
+	log.Infof("serving apps on %s", path)
+	go func() {
+		<-n.closing
+		s.Close()
+	}()
+	return
+}
@@ -0,0 +1,453 @@
+package node
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/skycoin/skycoin/src/cipher"
+	"github.com/skycoin/skywire/pkg/httputil"
+	"github.com/skycoin/skywire/pkg/net/skycoin-messenger/factory"
+	"github.com/skycoin/skywire/pkg/net/util"
+)
+
+// BandwidthTestService is the attribute of the app echoing the bandwidth tests of other nodes
+const BandwidthTestService = "bandwidth_test"
+
+const (
+	// the results kept in memory, the file keeps them all
+	bandwidthResultsKept = 100
+	bandwidthResultsFile = "results.json"
+	// keys of the app echoing the tests, kept so the other nodes can list it as a peer
+	bandwidthServerSeed    = "server.json"
+	bandwidthSetupTimeout  = 30 * time.Second
+	bandwidthChunk         = 32 << 10
+	bandwidthReportTimeout = 30 * time.Second
+)
+
+// BandwidthPeer is an app of a volunteer node echoing the bandwidth tests
+type BandwidthPeer struct {
+	Node cipher.PubKey
+	App  cipher.PubKey
+}
+
+// ParseBandwidthPeer parses node:app, the hex public keys of the node and of its app
+func ParseBandwidthPeer(s string) (p BandwidthPeer, err error) {
+	keys := strings.Split(s, ":")
+	if len(keys) != 2 {
+		err = fmt.Errorf("invalid bandwidth test peer %q, want node:app", s)
+		return
+	}
+	if p.Node, err = cipher.PubKeyFromHex(keys[0]); err != nil {
+		err = fmt.Errorf("bandwidth test peer %s: %v", s, err)
+		return
+	}
+	if p.App, err = cipher.PubKeyFromHex(keys[1]); err != nil {
+		err = fmt.Errorf("bandwidth test peer %s: %v", s, err)
+	}
+	return
+}
+
+// BandwidthTestConfig schedules the tests of the throughput of the loops to the peers
+type BandwidthTestConfig struct {
+	Peers []BandwidthPeer
+	// the peers are tested one after the other every interval, for duration each
+	Interval time.Duration
+	Duration time.Duration
+	// the results are appended to results.json in the directory, kept in memory only if empty
+	Dir string
+	// the signed summary of each round is posted there, not sent if empty
+	ReportURL string
+	// echo the tests of other nodes with an app of the node
+	Serve bool
+}
+
+// BandwidthResult is a test of the loop to a peer, the bytes it echoed in the duration
+type BandwidthResult struct {
+	Time int64  `json:"time"`
+	Node string `json:"node"`
+	App  string `json:"app"`
+	// milliseconds the transport took to set up
+	Setup int64 `json:"setup"`
+	Bytes int64 `json:"bytes"`
+	// bytes per second, 0 if the test failed
+	Throughput int64  `json:"throughput"`
+	Error      string `json:"error,omitempty"`
+}
+
+// BandwidthSummary is a round of tests, signed by the node
+type BandwidthSummary struct {
+	Node    string            `json:"node"`
+	Version string            `json:"version"`
+	Results []BandwidthResult `json:"results"`
+	// signature of the node of the sha256 of the summary without it
+	Sig string `json:"sig,omitempty"`
+}
+
+type bandwidthTests struct {
+	config BandwidthTestConfig
+	// the app echoing the tests, nil if it does not serve them
+	server *factory.Connection
+	echo   net.Listener
+
+	results []BandwidthResult
+	sync.RWMutex
+}
+
+// StartBandwidthTests tests the peers every interval until the node is closed, and echoes the
+// tests of other nodes if the config serves them
+func (n *Node) StartBandwidthTests(config BandwidthTestConfig) (err error) {
+	if len(config.Peers) > 0 && (config.Interval <= 0 || config.Duration <= 0) {
+		return fmt.Errorf("invalid bandwidth test interval %v or duration %v", config.Interval, config.Duration)
+	}
+	b := &bandwidthTests{config: config}
+	if len(config.Dir) > 0 {
+		if err = os.MkdirAll(config.Dir, 0700); err != nil {
+			return
+		}
+		b.load()
+	}
+	if config.Serve {
+		if err = b.serve(n); err != nil {
+			return
+		}
+	}
+	n.bandwidthMutex.Lock()
+	n.bandwidth = b
+	n.bandwidthMutex.Unlock()
+	if len(config.Peers) == 0 {
+		return
+	}
+	n.supervise(BandwidthSubsystem, func() {
+		ticker := time.NewTicker(config.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-n.closing:
+				return
+			case <-ticker.C:
+				b.round(n)
+			}
+		}
+	})
+	return
+}
+
+// BandwidthTests are the last results of the tests of the node
+type BandwidthTests struct {
+	// key of the app echoing the tests of other nodes, empty if the node does not serve them
+	Server string `json:"server,omitempty"`
+	// the newest last
+	Results []BandwidthResult `json:"results"`
+}
+
+// GetBandwidthTests returns the last results of the tests of the node
+func (n *Node) GetBandwidthTests() (t BandwidthTests) {
+	t.Results = []BandwidthResult{}
+	n.bandwidthMutex.RLock()
+	b := n.bandwidth
+	n.bandwidthMutex.RUnlock()
+	if b == nil {
+		return
+	}
+	b.RLock()
+	t.Results = append(t.Results, b.results...)
+	if b.server != nil {
+		t.Server = b.server.GetKey().Hex()
+	}
+	b.RUnlock()
+	return
+}
+
+// serve registers the app echoing the tests with the node
+func (b *bandwidthTests) serve(n *Node) (err error) {
+	b.echo, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return
+	}
+	go func() {
+		for {
+			c, err := b.echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(c, c)
+				c.Close()
+			}()
+		}
+	}()
+	go func() {
+		<-n.closing
+		b.echo.Close()
+	}()
+	config := &factory.ConnConfig{SeedConfig: factory.NewSeedConfig()}
+	if len(b.config.Dir) > 0 {
+		config = &factory.ConnConfig{SeedConfigPath: filepath.Join(b.config.Dir, bandwidthServerSeed)}
+	}
+	connected := make(chan *factory.Connection, 1)
+	config.OnConnected = func(c *factory.Connection) {
+		if err := c.OfferServiceWithAddress(b.echo.Addr().String(), Version, BandwidthTestService); err != nil {
+			log.Errorf("offer the bandwidth tests: %v", err)
+		}
+		select {
+		case connected <- c:
+		default:
+		}
+	}
+	f := factory.NewMessengerFactory()
+	if err = f.ConnectWithConfig(n.lnAddr, config); err != nil {
+		return
+	}
+	select {
+	case c := <-connected:
+		log.Infof("echoing the bandwidth tests of other nodes with app %x", c.GetKey())
+		b.Lock()
+		b.server = c
+		b.Unlock()
+	case <-time.After(bandwidthSetupTimeout):
+		f.Close()
+		return fmt.Errorf("bandwidth test app not registered with the node")
+	}
+	go func() {
+		<-n.closing
+		f.Close()
+	}()
+	return
+}
+
+// round tests the peers one after the other, stores the results and reports them
+func (b *bandwidthTests) round(n *Node) {
+	setups := make(map[string]chan factory.AppConnResp)
+	var setupsMutex sync.Mutex
+	connected := make(chan *factory.Connection, 1)
+	f := factory.NewMessengerFactory()
+	defer f.Close()
+	err := f.ConnectWithConfig(n.lnAddr, &factory.ConnConfig{
+		SeedConfig: factory.NewSeedConfig(),
+		OnConnected: func(c *factory.Connection) {
+			select {
+			case connected <- c:
+			default:
+			}
+		},
+		AppConnectionInitCallback: func(resp *factory.AppConnResp) *factory.AppFeedback {
+			setupsMutex.Lock()
+			ch, ok := setups[resp.SetupID]
+			setupsMutex.Unlock()
+			if ok {
+				select {
+				case ch <- *resp:
+				default:
+				}
+			}
+			return &factory.AppFeedback{Port: resp.Port, Failed: resp.Failed, Msg: resp.Msg}
+		},
+	})
+	var c *factory.Connection
+	if err == nil {
+		select {
+		case c = <-connected:
+		case <-time.After(bandwidthSetupTimeout):
+			err = fmt.Errorf("bandwidth test app not registered with the node")
+		}
+	}
+	var results []BandwidthResult
+	for _, p := range b.config.Peers {
+		r := BandwidthResult{Time: time.Now().Unix(), Node: p.Node.Hex(), App: p.App.Hex()}
+		if err != nil {
+			r.Error = err.Error()
+		} else {
+			id := factory.NewSetupID()
+			ch := make(chan factory.AppConnResp, 1)
+			setupsMutex.Lock()
+			setups[id] = ch
+			setupsMutex.Unlock()
+			b.test(c, p, id, ch, &r)
+		}
+		if len(r.Error) > 0 {
+			log.Warnf("bandwidth test of node %x app %x: %s", p.Node, p.App, r.Error)
+		} else {
+			log.Debugf("bandwidth test of node %x app %x: %d B/s", p.Node, p.App, r.Throughput)
+		}
+		results = append(results, r)
+	}
+	b.store(results)
+	if len(b.config.ReportURL) > 0 {
+		if err := b.report(n, results); err != nil {
+			log.Warnf("report the bandwidth tests to %s: %v", b.config.ReportURL, err)
+		}
+	}
+}
+
+// test echoes data through a loop to the peer for the duration and counts what came back
+func (b *bandwidthTests) test(c *factory.Connection, p BandwidthPeer, id string, setup chan factory.AppConnResp, r *BandwidthResult) {
+	start := time.Now()
+	err := c.BuildAppConnectionWithOptions(p.Node, p.App, factory.EMPTY_PUBLIC_KEY, factory.AppDialOptions{SetupID: id})
+	if err != nil {
+		r.Error = err.Error()
+		return
+	}
+	var resp factory.AppConnResp
+	select {
+	case resp = <-setup:
+	case <-time.After(bandwidthSetupTimeout):
+		c.CancelAppConnection(p.App, id)
+		r.Error = "transport not set up in time"
+		return
+	}
+	r.Setup = int64(time.Since(start) / time.Millisecond)
+	if resp.Failed {
+		r.Error = resp.Msg.Msg
+		return
+	}
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(resp.Port)), bandwidthSetupTimeout)
+	if err != nil {
+		r.Error = err.Error()
+		return
+	}
+	defer conn.Close()
+	start = time.Now()
+	end := start.Add(b.config.Duration)
+	conn.SetDeadline(end)
+	go func() {
+		buf := make([]byte, bandwidthChunk)
+		for time.Now().Before(end) {
+			if _, err := conn.Write(buf); err != nil {
+				return
+			}
+		}
+	}()
+	buf := make([]byte, bandwidthChunk)
+	for {
+		n, err := conn.Read(buf)
+		r.Bytes += int64(n)
+		if err != nil {
+			if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+				r.Error = err.Error()
+			}
+			break
+		}
+	}
+	if elapsed := time.Since(start); len(r.Error) == 0 && elapsed > 0 {
+		r.Throughput = int64(float64(r.Bytes) / elapsed.Seconds())
+	}
+}
+
+// store keeps the last results and appends them to the file
+func (b *bandwidthTests) store(results []BandwidthResult) {
+	b.Lock()
+	b.results = append(b.results, results...)
+	if len(b.results) > bandwidthResultsKept {
+		b.results = append([]BandwidthResult(nil), b.results[len(b.results)-bandwidthResultsKept:]...)
+	}
+	b.Unlock()
+	if len(b.config.Dir) == 0 {
+		return
+	}
+	var buf bytes.Buffer
+	for _, r := range results {
+		d, _ := json.Marshal(r)
+		buf.Write(d)
+		buf.WriteByte('\n')
+	}
+	file, err := os.OpenFile(filepath.Join(b.config.Dir, bandwidthResultsFile), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Errorf("store the bandwidth tests: %v", err)
+		return
+	}
+	defer file.Close()
+	if _, err = file.Write(buf.Bytes()); err != nil {
+		log.Errorf("store the bandwidth tests: %v", err)
+	}
+}
+
+// load reads the last results of the file
+func (b *bandwidthTests) load() {
+	file, err := os.Open(filepath.Join(b.config.Dir, bandwidthResultsFile))
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	s := bufio.NewScanner(file)
+	for s.Scan() {
+		var r BandwidthResult
+		if json.Unmarshal(s.Bytes(), &r) != nil {
+			continue
+		}
+		b.results = append(b.results, r)
+		if len(b.results) > 2*bandwidthResultsKept {
+			b.results = append([]BandwidthResult(nil), b.results[len(b.results)-bandwidthResultsKept:]...)
+		}
+	}
+	if len(b.results) > bandwidthResultsKept {
+		b.results = b.results[len(b.results)-bandwidthResultsKept:]
+	}
+}
+
+// report posts the summary of the results signed by the node
+func (b *bandwidthTests) report(n *Node, results []BandwidthResult) (err error) {
+	if n.ClockSkewed() {
+		return fmt.Errorf("the clock of the node is skewed, refusing to sign")
+	}
+	sc, err := factory.ReadSeedConfig(n.seedConfigPath)
+	if err != nil {
+		return
+	}
+	sk, err := cipher.SecKeyFromHex(sc.SecKey)
+	if err != nil {
+		return
+	}
+	defer util.WipeSecKey(&sk)
+	s := BandwidthSummary{Node: sc.PublicKey, Version: Version, Results: results}
+	d, err := json.Marshal(s)
+	if err != nil {
+		return
+	}
+	s.Sig = cipher.SignHash(cipher.SumSHA256(d), sk).Hex()
+	d, err = json.Marshal(s)
+	if err != nil {
+		return
+	}
+	client := &http.Client{Timeout: bandwidthReportTimeout}
+	res, err := client.Post(b.config.ReportURL, "application/json", bytes.NewReader(d))
+	if err != nil {
+		return
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(res.Body)
+		return httputil.ReadError(res, body)
+	}
+	return
+}
+
+// VerifyBandwidthSummary checks the summary is signed by its node
+func VerifyBandwidthSummary(s BandwidthSummary) (err error) {
+	node, err := cipher.PubKeyFromHex(s.Node)
+	if err != nil {
+		return
+	}
+	sig, err := cipher.SigFromHex(s.Sig)
+	if err != nil {
+		return
+	}
+	s.Sig = ""
+	d, err := json.Marshal(s)
+	if err != nil {
+		return
+	}
+	return cipher.VerifySignature(node, sig, cipher.SumSHA256(d))
+}
@@ -0,0 +1,113 @@
+package node_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skycoin/skywire/pkg/httputil"
+	"github.com/skycoin/skywire/pkg/node"
+	"github.com/skycoin/skywire/pkg/node/nodetest"
+)
+
+func TestBandwidthTests(t *testing.T) {
+	e := nodetest.NewEnv(t, 1)
+	defer e.Close()
+	a, b := e.StartNode("a"), e.StartNode("b")
+	err := b.StartBandwidthTests(node.BandwidthTestConfig{Serve: true, Dir: e.Path("b", "bandwidth")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := b.GetBandwidthTests().Server
+	if len(server) == 0 {
+		t.Fatal("no app echoing the tests")
+	}
+
+	summaries := make(chan node.BandwidthSummary, 10)
+	uptime := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var s node.BandwidthSummary
+		if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+			httputil.Fail(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		summaries <- s
+	}))
+	defer uptime.Close()
+	p, err := node.ParseBandwidthPeer(b.Key.Hex() + ":" + server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = a.StartBandwidthTests(node.BandwidthTestConfig{
+		Peers:     []node.BandwidthPeer{p},
+		Interval:  100 * time.Millisecond,
+		Duration:  200 * time.Millisecond,
+		Dir:       e.Path("a", "bandwidth"),
+		ReportURL: uptime.URL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s node.BandwidthSummary
+	select {
+	case s = <-summaries:
+	case <-time.After(10 * time.Second):
+		t.Fatal("no summary reported")
+	}
+	if s.Node != a.Key.Hex() || len(s.Results) != 1 || s.Results[0].App != server {
+		t.Fatalf("summary %#v", s)
+	}
+	if r := s.Results[0]; len(r.Error) > 0 || r.Throughput == 0 || r.Bytes == 0 {
+		t.Fatalf("result %#v", r)
+	}
+	if err = node.VerifyBandwidthSummary(s); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		name   string
+		change func(s *node.BandwidthSummary)
+	}{
+		{"throughput", func(s *node.BandwidthSummary) { s.Results[0].Throughput++ }},
+		{"result dropped", func(s *node.BandwidthSummary) { s.Results = nil }},
+		{"other node", func(s *node.BandwidthSummary) { s.Node = b.Key.Hex() }},
+		{"version", func(s *node.BandwidthSummary) { s.Version += "-dirty" }},
+		{"no signature", func(s *node.BandwidthSummary) { s.Sig = "" }},
+	} {
+		changed := s
+		changed.Results = append([]node.BandwidthResult(nil), s.Results...)
+		c.change(&changed)
+		if node.VerifyBandwidthSummary(changed) == nil {
+			t.Errorf("summary with the %s changed verified", c.name)
+		}
+	}
+	if got := a.GetBandwidthTests().Results; len(got) == 0 || got[0].Throughput == 0 {
+		t.Fatalf("results %#v", got)
+	}
+	stored, err := ioutil.ReadFile(e.Path("a", "bandwidth", "results.json"))
+	if err != nil || !bytes.Contains(stored, []byte(server)) {
+		t.Fatalf("stored results %v: %s", err, stored)
+	}
+}
+
+func TestParseBandwidthPeer(t *testing.T) {
+	n, a := "02"+strings.Repeat("01", 32), "03"+strings.Repeat("02", 32)
+	for _, c := range []struct {
+		s  string
+		ok bool
+	}{
+		{s: n + ":" + a, ok: true},
+		{s: n},
+		{s: n + ":" + a + ":" + a},
+		{s: "node:" + a},
+		{s: n + ":app"},
+	} {
+		p, err := node.ParseBandwidthPeer(c.s)
+		if (err == nil) != c.ok || (c.ok && (p.Node.Hex() != n || p.App.Hex() != a)) {
+			t.Errorf("%s: %#v %v", c.s, p, err)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package node
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/skycoin/skywire/pkg/net/ntp"
+)
+
+const clockCheckInterval = 30 * time.Minute
+
+// StartClockCheck measures the clock offset now and then periodically until the node is closed,
+// a skew above maxSkew is logged and the node refuses to sign until the clock is fixed
+func (n *Node) StartClockCheck(servers []string, maxSkew time.Duration) {
+	n.clockMutex.Lock()
+	n.maxClockSkew = maxSkew
+	n.clockMutex.Unlock()
+	n.supervise(ClockSubsystem, func() {
+		ticker := time.NewTicker(clockCheckInterval)
+		defer ticker.Stop()
+		for {
+			n.checkClock(servers)
+			select {
+			case <-n.closing:
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+}
+
+func (n *Node) checkClock(servers []string) {
+	res := n.services.Clock.Check(servers)
+	n.clockMutex.Lock()
+	n.clock = res
+	maxSkew := n.maxClockSkew
+	n.clockMutex.Unlock()
+	switch {
+	case len(res.Error) > 0:
+		log.Debugf("clock check: %s", res.Error)
+	case maxSkew > 0 && res.Skew() > maxSkew:
+		log.Warnf("clock check: the clock is off by %dms from %s, more than %s, fix the time of the system",
+			res.Offset, res.Server, maxSkew)
+	default:
+		log.Debugf("clock check: offset %dms from %s", res.Offset, res.Server)
+	}
+}
+
+// GetClock returns the last clock check, nil before the first check
+func (n *Node) GetClock() *ntp.Result {
+	n.clockMutex.RLock()
+	defer n.clockMutex.RUnlock()
+	return n.clock
+}
+
+// ClockSkewed reports if the last clock check measured a skew above the limit
+func (n *Node) ClockSkewed() bool {
+	n.clockMutex.RLock()
+	defer n.clockMutex.RUnlock()
+	return n.maxClockSkew > 0 && n.clock.Skew() > n.maxClockSkew
+}
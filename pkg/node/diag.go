@@ -0,0 +1,102 @@
+package node
+
+import (
+	"bytes"
+	"regexp"
+	"runtime"
+	"runtime/pprof"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// log lines kept for the diagnostics
+const recentLogSize = 1000
+
+// 32 byte hex strings, the size of secret keys and tokens, public keys are 33 bytes
+var secretPattern = regexp.MustCompile(`\b[0-9a-fA-F]{64}\b`)
+
+// recentLogs keeps the last log lines of the process
+type recentLogs struct {
+	lines []string
+	next  int
+	full  bool
+	sync.Mutex
+}
+
+func (l *recentLogs) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (l *recentLogs) Fire(entry *log.Entry) error {
+	line, err := entry.String()
+	if err != nil {
+		return err
+	}
+	line = secretPattern.ReplaceAllString(strings.TrimRight(line, "\n"), "[redacted]")
+	l.Lock()
+	l.lines[l.next] = line
+	l.next = (l.next + 1) % len(l.lines)
+	if l.next == 0 {
+		l.full = true
+	}
+	l.Unlock()
+	return nil
+}
+
+func (l *recentLogs) get() (lines []string) {
+	l.Lock()
+	defer l.Unlock()
+	if l.full {
+		lines = append(lines, l.lines[l.next:]...)
+	}
+	lines = append(lines, l.lines[:l.next]...)
+	return
+}
+
+var (
+	logs        = &recentLogs{lines: make([]string, recentLogSize)}
+	logsHook    sync.Once
+	processTime = time.Now()
+)
+
+// KeepRecentLogs makes the diagnostics include the last log lines
+func KeepRecentLogs() {
+	logsHook.Do(func() {
+		log.AddHook(logs)
+	})
+}
+
+type Diagnostics struct {
+	Version    string    `json:"version"`
+	GoVersion  string    `json:"go_version"`
+	OS         string    `json:"os"`
+	Arch       string    `json:"arch"`
+	Uptime     int64     `json:"uptime"`
+	Info       NodeInfo  `json:"info"`
+	Metrics    Metrics   `json:"metrics"`
+	Apps       []NodeApp `json:"apps"`
+	Logs       []string  `json:"logs"`
+	Goroutines string    `json:"goroutines"`
+}
+
+// GetDiagnostics collects the state of the node for a bug report, it holds no keys
+func (n *Node) GetDiagnostics() (d Diagnostics) {
+	d = Diagnostics{
+		Version:   Version,
+		GoVersion: runtime.Version(),
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+		Uptime:    int64(time.Since(processTime) / time.Second),
+		Info:      n.GetNodeInfo(),
+		Metrics:   n.GetMetrics(),
+		Apps:      n.GetApps(),
+		Logs:      logs.get(),
+	}
+	var b bytes.Buffer
+	pprof.Lookup("goroutine").WriteTo(&b, 2)
+	d.Goroutines = b.String()
+	return
+}
@@ -0,0 +1,75 @@
+package node_test
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/skycoin/skywire/pkg/node"
+	"github.com/skycoin/skywire/pkg/node/nodetest"
+)
+
+func TestDiagnostics(t *testing.T) {
+	e := nodetest.NewEnv(t, 1)
+	defer e.Close()
+	n := e.StartNode("a")
+
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+	secret := strings.Repeat("ab", 32)
+	for i := 0; i < 2000; i++ {
+		log.Infof("line %d", i)
+	}
+	log.Infof("seckey %s of node %s", secret, n.Key.Hex())
+
+	d := n.GetDiagnostics()
+	if d.Version != node.Version || len(d.Goroutines) == 0 || len(d.Info.Discoveries) != 1 {
+		t.Fatalf("diagnostics %s %d %+v", d.Version, len(d.Goroutines), d.Info)
+	}
+	// the last lines are kept in order, the oldest are dropped
+	if len(d.Logs) == 0 || len(d.Logs) >= 2000 || strings.Contains(strings.Join(d.Logs, "\n"), `"line 0"`) {
+		t.Fatalf("%d lines kept", len(d.Logs))
+	}
+	var last, seckey string
+	for _, l := range d.Logs {
+		if strings.Contains(l, "line 1999") {
+			last = l
+		}
+		if strings.Contains(l, "seckey") {
+			seckey = l
+		}
+	}
+	if len(last) == 0 {
+		t.Fatal("last line dropped")
+	}
+	// secret keys are redacted, the public keys are kept
+	if strings.Contains(seckey, secret) || !strings.Contains(seckey, "[redacted]") || !strings.Contains(seckey, n.Key.Hex()) {
+		t.Fatalf("line of the keys %s", seckey)
+	}
+}
+
+func TestWatchdogDiagnostics(t *testing.T) {
+	e := nodetest.NewEnv(t, 1)
+	defer e.Close()
+	n := e.StartNode("a")
+	if n.GetNodeInfo().Watchdog != nil {
+		t.Fatal("watchdog reported before it started")
+	}
+	dir := e.Path("diag")
+	n.StartWatchdog(node.WatchdogConfig{MaxGoroutines: 1, MaxHeapMB: 1 << 20, DiagDir: dir})
+
+	// the first sample exceeds the goroutines and writes the diagnostics
+	nodetest.WaitFor(t, "the diagnostics of the watchdog", func() bool {
+		fis, _ := ioutil.ReadDir(dir)
+		return len(fis) == 1
+	})
+	w := n.GetNodeInfo().Watchdog
+	if w == nil || len(w.Exceeded) != 1 || w.Exceeded[0] != "goroutines" || w.Goroutines < 2 || w.HeapBytes == 0 {
+		t.Fatalf("watchdog %+v", w)
+	}
+	if len(w.Events) != 1 || !w.Events[0].Exceeded || !strings.Contains(w.Events[0].Message, "exceeds 1") {
+		t.Fatalf("events %+v", w.Events)
+	}
+}
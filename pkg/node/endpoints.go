@@ -0,0 +1,29 @@
+package node
+
+import (
+	"github.com/skycoin/skywire/pkg/net/resolver"
+)
+
+// SetServiceDNS resolves the addresses of the discoveries and the manager with r. An address
+// without a port is the name of SRV records, like _skywire-discovery._tcp.example.com, the
+// addresses of a host with several are tried in turns and the ones that failed after the others
+func (n *Node) SetServiceDNS(r *resolver.Resolver) {
+	e := resolver.NewEndpoints(r)
+	n.endpointsMutex.Lock()
+	n.endpoints = e
+	n.endpointsMutex.Unlock()
+	n.apps.SetDialer(e.Dial)
+	n.manager.SetDialer(e.Dial)
+}
+
+// GetEndpoints returns the addresses of the discoveries and the manager that failed the last
+// time they were dialed
+func (n *Node) GetEndpoints() []resolver.EndpointStatus {
+	n.endpointsMutex.RLock()
+	e := n.endpoints
+	n.endpointsMutex.RUnlock()
+	if e == nil {
+		return nil
+	}
+	return e.Status()
+}
@@ -0,0 +1,221 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/skycoin/skycoin/src/cipher"
+	"github.com/skycoin/skywire/pkg/net/skycoin-messenger/factory"
+)
+
+// the events the hooks run on
+const (
+	// a transport of the apps closed by a failure, not by an app or node
+	EventTransportDown = "transport_down"
+	// an app started by the node exited by itself with an error
+	EventAppCrashed = "app_crashed"
+	// the update script found a newer version
+	EventUpdateAvailable = "update_available"
+	// the peer lists refused a transport from another node to an app
+	EventUnknownPeer = "loop_from_unknown_peer"
+)
+
+// Events are the events hooks can run on
+var Events = []string{EventTransportDown, EventAppCrashed, EventUpdateAvailable, EventUnknownPeer}
+
+const (
+	// a hook runs at most once in this long by default, the events in between are dropped
+	DefaultHookInterval = 10 * time.Second
+	// a hook still running after this long is killed
+	hookTimeout = 30 * time.Second
+	// the output of a failed hook logged
+	hookOutputLimit = 1024
+)
+
+// Hook runs Command with the shell of the host on Event. The details of the event are in the
+// environment, SKYWIRE_EVENT, SKYWIRE_NODE, SKYWIRE_TIME (unix seconds), SKYWIRE_DROPPED (the
+// events dropped by the rate limit since the last run) and SKYWIRE_<DETAIL> for the details
+// of the event
+type Hook struct {
+	Event   string
+	Command string
+}
+
+// ParseHook parses event=command
+func ParseHook(s string) (h Hook, err error) {
+	i := strings.Index(s, "=")
+	if i < 1 || i == len(s)-1 {
+		err = fmt.Errorf("invalid hook %q, want event=command", s)
+		return
+	}
+	h = Hook{Event: strings.TrimSpace(s[:i]), Command: s[i+1:]}
+	for _, e := range Events {
+		if e == h.Event {
+			return
+		}
+	}
+	err = fmt.Errorf("unknown event %s of hook %q, the events are %v", h.Event, s, Events)
+	return
+}
+
+// HooksConfig is the hooks of the node and how often each may run
+type HooksConfig struct {
+	Hooks []Hook
+	// the least time between two runs of a hook, DefaultHookInterval when 0
+	Interval time.Duration
+}
+
+type hookState struct {
+	Hook
+	running bool
+	last    time.Time
+	dropped int
+}
+
+type hooks struct {
+	interval time.Duration
+	list     []*hookState
+	sync.Mutex
+}
+
+// SetHooks replaces the hooks of the node
+func (n *Node) SetHooks(config HooksConfig) (err error) {
+	h := &hooks{interval: config.Interval}
+	if h.interval <= 0 {
+		h.interval = DefaultHookInterval
+	}
+	for _, hook := range config.Hooks {
+		if _, err = ParseHook(hook.Event + "=" + hook.Command); err != nil {
+			return
+		}
+		h.list = append(h.list, &hookState{Hook: hook})
+	}
+	n.hooksMutex.Lock()
+	n.hooks = h
+	n.hooksMutex.Unlock()
+	return
+}
+
+// Event runs the hooks of the event with the details, keys like remote_node, in the environment
+// as SKYWIRE_REMOTE_NODE. A hook already running or run less than the interval ago skips the
+// event, its next run gets the count of the events skipped
+func (n *Node) Event(event string, details map[string]string) {
+	n.hooksMutex.RLock()
+	h := n.hooks
+	n.hooksMutex.RUnlock()
+	if h == nil {
+		return
+	}
+	now := time.Now()
+	h.Lock()
+	defer h.Unlock()
+	for _, s := range h.list {
+		if s.Event != event {
+			continue
+		}
+		if s.running || now.Sub(s.last) < h.interval {
+			s.dropped++
+			continue
+		}
+		s.running, s.last = true, now
+		env := n.hookEnv(event, now, s.dropped, details)
+		s.dropped = 0
+		go func(s *hookState) {
+			runHook(s.Hook, env)
+			h.Lock()
+			s.running = false
+			h.Unlock()
+		}(s)
+	}
+}
+
+func (n *Node) hookEnv(event string, now time.Time, dropped int, details map[string]string) []string {
+	key, _ := n.GetNodeKey()
+	env := append(os.Environ(),
+		"SKYWIRE_EVENT="+event,
+		"SKYWIRE_NODE="+key,
+		"SKYWIRE_TIME="+strconv.FormatInt(now.Unix(), 10),
+		"SKYWIRE_DROPPED="+strconv.Itoa(dropped),
+	)
+	keys := make([]string, 0, len(details))
+	for k := range details {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		env = append(env, "SKYWIRE_"+strings.ToUpper(k)+"="+details[k])
+	}
+	return env
+}
+
+func runHook(h Hook, env []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+	defer cancel()
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", h.Command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", h.Command)
+	}
+	cmd.Env = env
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := cmd.Run(); err != nil {
+		o := out.Bytes()
+		if len(o) > hookOutputLimit {
+			o = o[:hookOutputLimit]
+		}
+		log.Warnf("hook %s %q: %v: %s", h.Event, h.Command, err, o)
+		return
+	}
+	log.Debugf("hook %s %q ran", h.Event, h.Command)
+}
+
+// transportClosed counts the closed transport in the usage reports and runs the hooks when it
+// failed
+func (n *Node) transportClosed(t *factory.Transport) {
+	n.accountingMutex.RLock()
+	a := n.accounting
+	n.accountingMutex.RUnlock()
+	if a != nil {
+		a.Lock()
+		a.count(t, time.Now(), true)
+		a.Unlock()
+	}
+	reason := t.CloseReason()
+	if !reason.Failed() || t.IsStandby() {
+		return
+	}
+	app, remoteNode, remoteApp := t.ToApp, t.FromNode, t.FromApp
+	if t.IsClientSide() {
+		app, remoteNode, remoteApp = t.FromApp, t.ToNode, t.ToApp
+	}
+	r, _ := t.Record()
+	n.Event(EventTransportDown, map[string]string{
+		"transport_id": r.ID,
+		"app":          app.Hex(),
+		"remote_node":  remoteNode.Hex(),
+		"remote_app":   remoteApp.Hex(),
+		"close_reason": reason.String(),
+		"upload":       strconv.FormatUint(uint64(t.GetUploadTotal()), 10),
+		"download":     strconv.FormatUint(uint64(t.GetDownloadTotal()), 10),
+	})
+}
+
+func (n *Node) peerRefused(node, app cipher.PubKey) {
+	n.Event(EventUnknownPeer, map[string]string{
+		"remote_node": node.Hex(),
+		"app":         app.Hex(),
+	})
+}
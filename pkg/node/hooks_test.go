@@ -0,0 +1,91 @@
+package node
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseHook(t *testing.T) {
+	for _, c := range []struct {
+		s    string
+		hook Hook
+		ok   bool
+	}{
+		{s: EventAppCrashed + "=echo $SKYWIRE_APP", hook: Hook{Event: EventAppCrashed, Command: "echo $SKYWIRE_APP"}, ok: true},
+		{s: " " + EventTransportDown + " =a=b", hook: Hook{Event: EventTransportDown, Command: "a=b"}, ok: true},
+		{s: "transport_up=true"},
+		{s: "=true"},
+		{s: EventAppCrashed + "="},
+		{s: EventAppCrashed},
+	} {
+		h, err := ParseHook(c.s)
+		if (err == nil) != c.ok || (c.ok && h != c.hook) {
+			t.Errorf("%q: %#v %v", c.s, h, err)
+		}
+	}
+	n := &Node{}
+	if err := n.SetHooks(HooksConfig{Hooks: []Hook{{Event: "transport_up", Command: "true"}}}); err == nil || n.hooks != nil {
+		t.Fatal("hook of an unknown event set")
+	}
+	if err := n.SetHooks(HooksConfig{}); err != nil || n.hooks.interval != DefaultHookInterval {
+		t.Fatalf("interval %v: %v", n.hooks, err)
+	}
+}
+
+func TestHookEnv(t *testing.T) {
+	n := &Node{}
+	env := n.hookEnv(EventTransportDown, time.Unix(100, 0), 3, map[string]string{"remote_node": "b", "app": "a"})
+	got := strings.Join(env[len(env)-6:], " ")
+	want := "SKYWIRE_EVENT=transport_down SKYWIRE_NODE= SKYWIRE_TIME=100 SKYWIRE_DROPPED=3 SKYWIRE_APP=a SKYWIRE_REMOTE_NODE=b"
+	if got != want {
+		t.Fatalf("env %q, want %q", got, want)
+	}
+}
+
+func TestHookInterval(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the hook runs a unix shell")
+	}
+	dir, err := ioutil.TempDir("", "hooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "out")
+	n := &Node{}
+	err = n.SetHooks(HooksConfig{Hooks: []Hook{
+		{Event: EventUnknownPeer, Command: `echo "$SKYWIRE_REMOTE_NODE $SKYWIRE_DROPPED" >> ` + out},
+	}, Interval: 300 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := func(n int) string {
+		for i := 0; i < 100; i++ {
+			b, _ := ioutil.ReadFile(out)
+			if l := strings.Split(strings.TrimSpace(string(b)), "\n"); len(l) == n && len(l[0]) > 0 {
+				return strings.Join(l, ",")
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		t.Fatalf("the hook did not run %d times", n)
+		return ""
+	}
+	// the events within the interval are dropped, the other events run no hook
+	for _, node := range []string{"a", "b", "c"} {
+		n.Event(EventUnknownPeer, map[string]string{"remote_node": node})
+	}
+	n.Event(EventAppCrashed, map[string]string{"app": "sshs"})
+	if got := lines(1); got != "a 0" {
+		t.Fatalf("first run %q", got)
+	}
+	time.Sleep(400 * time.Millisecond)
+	n.Event(EventUnknownPeer, map[string]string{"remote_node": "d"})
+	if got := lines(2); got != "a 0,d 2" {
+		t.Fatalf("runs %q", got)
+	}
+}
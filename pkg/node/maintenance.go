@@ -0,0 +1,146 @@
+package node
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// the transports still open are counted this often during a maintenance
+	maintenanceCheckInterval = 5 * time.Second
+	maintenanceEvents        = 50
+)
+
+type MaintenanceEvent struct {
+	Time int64 `json:"time"`
+	// started, extended, drained or ended
+	Event   string `json:"event"`
+	Message string `json:"message"`
+}
+
+type MaintenanceStatus struct {
+	// unix time the maintenance ends, 0 if the node is not in maintenance
+	Until int64 `json:"until,omitempty"`
+	// transports to the apps still open
+	Transports int                `json:"transports"`
+	Events     []MaintenanceEvent `json:"events"`
+}
+
+type maintenanceState struct {
+	until time.Time
+	// all transports to the apps closed since the maintenance started
+	drained bool
+	// closed to stop the check of the running maintenance
+	stop   chan struct{}
+	events []MaintenanceEvent
+	sync.Mutex
+}
+
+// StartMaintenance drains the node for the duration before a reboot, a duration of 0 ends
+// the maintenance. The discoveries stop forwarding setups to the node and the node refuses
+// the new transports to its apps, the transports open keep running until they close or
+// their apps move them to other nodes. The events are logged and kept in the status.
+func (n *Node) StartMaintenance(d time.Duration) {
+	if d <= 0 {
+		n.EndMaintenance()
+		return
+	}
+	m := &n.maintenance
+	until := time.Now().Add(d)
+	m.Lock()
+	defer m.Unlock()
+	n.apps.SetMaintenance(until)
+	if m.stop != nil {
+		m.until = until
+		m.event("extended", fmt.Sprintf("maintenance extended until %s", until.Format(time.RFC3339)))
+		return
+	}
+	m.until = until
+	m.drained = false
+	m.stop = make(chan struct{})
+	m.event("started", fmt.Sprintf("maintenance until %s, %d transports open", until.Format(time.RFC3339), n.apps.GetCapacity().Transports))
+	go n.checkMaintenance(m.stop)
+}
+
+// EndMaintenance lets the node take transports again
+func (n *Node) EndMaintenance() {
+	m := &n.maintenance
+	m.Lock()
+	defer m.Unlock()
+	if m.stop != nil {
+		close(m.stop)
+		n.endMaintenance("maintenance ended")
+	}
+}
+
+// endMaintenance is called with the state locked
+func (n *Node) endMaintenance(msg string) {
+	m := &n.maintenance
+	m.stop = nil
+	m.until = time.Time{}
+	n.apps.SetMaintenance(time.Time{})
+	m.event("ended", msg)
+}
+
+// GetMaintenance returns the maintenance of the node and its events, nil if the node was
+// never in maintenance
+func (n *Node) GetMaintenance() *MaintenanceStatus {
+	m := &n.maintenance
+	m.Lock()
+	defer m.Unlock()
+	if len(m.events) == 0 {
+		return nil
+	}
+	s := &MaintenanceStatus{
+		Transports: n.apps.GetCapacity().Transports,
+		Events:     append([]MaintenanceEvent(nil), m.events...),
+	}
+	if !m.until.IsZero() {
+		s.Until = m.until.Unix()
+	}
+	return s
+}
+
+// checkMaintenance records when the transports drained and ends the maintenance in time
+func (n *Node) checkMaintenance(stop chan struct{}) {
+	ticker := time.NewTicker(maintenanceCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.closing:
+			return
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		m := &n.maintenance
+		m.Lock()
+		if m.stop != stop {
+			m.Unlock()
+			return
+		}
+		if !m.drained && n.apps.GetCapacity().Transports == 0 {
+			m.drained = true
+			m.event("drained", "all transports closed, the node can be rebooted")
+		}
+		expired := time.Now().After(m.until)
+		if expired {
+			n.endMaintenance("maintenance expired")
+		}
+		m.Unlock()
+		if expired {
+			return
+		}
+	}
+}
+
+func (m *maintenanceState) event(event, msg string) {
+	log.Infof("maintenance: %s", msg)
+	m.events = append(m.events, MaintenanceEvent{Time: time.Now().Unix(), Event: event, Message: msg})
+	if len(m.events) > maintenanceEvents {
+		m.events = m.events[len(m.events)-maintenanceEvents:]
+	}
+}
@@ -0,0 +1,58 @@
+package node
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skycoin/skywire/pkg/net/skycoin-messenger/factory"
+)
+
+func TestMaintenance(t *testing.T) {
+	n := &Node{apps: factory.NewMessengerFactory(), closing: make(chan struct{})}
+	defer close(n.closing)
+	if m := n.GetMaintenance(); m != nil {
+		t.Fatalf("maintenance of a node never in maintenance %#v", m)
+	}
+	for _, c := range []struct {
+		name     string
+		duration time.Duration
+		// the events so far
+		events string
+		in     bool
+	}{
+		{name: "start", duration: 10 * time.Minute, events: "started", in: true},
+		{name: "extend", duration: time.Hour, events: "started,extended", in: true},
+		{name: "end", events: "started,extended,ended"},
+		{name: "end again", events: "started,extended,ended"},
+		{name: "start again", duration: time.Minute, events: "started,extended,ended,started", in: true},
+		{name: "end at once", duration: -time.Minute, events: "started,extended,ended,started,ended"},
+	} {
+		n.StartMaintenance(c.duration)
+		m := n.GetMaintenance()
+		var events []string
+		for _, e := range m.Events {
+			events = append(events, e.Event)
+		}
+		if strings.Join(events, ",") != c.events {
+			t.Errorf("%s: events %v, want %s", c.name, events, c.events)
+		}
+		// the factory announces the maintenance and refuses the transports until its end
+		until := n.apps.GetMaintenance()
+		if c.in != (m.Until > 0) || c.in == until.IsZero() {
+			t.Errorf("%s: until %d, factory until %v", c.name, m.Until, until)
+		}
+		if c.in && (m.Until != until.Unix() || time.Until(until) > c.duration || time.Until(until) < c.duration-time.Minute) {
+			t.Errorf("%s: until %d, factory until %v", c.name, m.Until, until)
+		}
+	}
+
+	// the events kept are the last ones, the start of this maintenance is dropped
+	for i := 0; i <= maintenanceEvents; i++ {
+		n.StartMaintenance(time.Minute)
+	}
+	if m := n.GetMaintenance(); len(m.Events) != maintenanceEvents || m.Events[0].Event != "extended" {
+		t.Fatalf("%d events, first %#v", len(m.Events), m.Events[0])
+	}
+	n.EndMaintenance()
+}
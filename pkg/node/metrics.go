@@ -0,0 +1,27 @@
+package node
+
+import (
+	"github.com/skycoin/skywire/pkg/net/nat"
+	"github.com/skycoin/skywire/pkg/net/skycoin-messenger/factory"
+	"github.com/skycoin/skywire/pkg/net/util"
+)
+
+type Metrics struct {
+	// handshakes of the connections to discoveries, apps and transports
+	Handshakes factory.HandshakeStats `json:"handshakes"`
+	// handshakes of the connection to the manager
+	ManagerHandshakes factory.HandshakeStats `json:"manager_handshakes"`
+	// last nat detection result
+	NAT *nat.Result `json:"nat,omitempty"`
+	// open files against the limit of the process
+	FileDescriptors util.FDStats `json:"file_descriptors"`
+}
+
+func (n *Node) GetMetrics() Metrics {
+	return Metrics{
+		Handshakes:        n.apps.GetHandshakeStats(),
+		ManagerHandshakes: n.manager.GetHandshakeStats(),
+		NAT:               n.GetNAT(),
+		FileDescriptors:   util.GetFDStats(),
+	}
+}
@@ -0,0 +1,55 @@
+package node
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/skycoin/skywire/pkg/net/nat"
+	"github.com/skycoin/skywire/pkg/net/skycoin-messenger/factory"
+)
+
+const natDetectInterval = 30 * time.Minute
+
+// StartNATDetection detects the nat type now and then periodically until the node is closed,
+// a changed type is announced to the discoveries
+func (n *Node) StartNATDetection(servers []string) {
+	n.supervise(NATSubsystem, func() {
+		ticker := time.NewTicker(natDetectInterval)
+		defer ticker.Stop()
+		for {
+			n.detectNAT(servers)
+			select {
+			case <-n.closing:
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+}
+
+func (n *Node) detectNAT(servers []string) {
+	res := n.services.NAT.Detect(servers)
+	if len(res.Error) > 0 {
+		log.Debugf("nat detection: %s (%s)", res.Type, res.Error)
+	} else {
+		log.Infof("nat detection: %s, mapped address %s", res.Type, res.Mapped)
+	}
+	n.natMutex.Lock()
+	changed := n.nat == nil || n.nat.Type != res.Type
+	n.nat = res
+	n.natMutex.Unlock()
+	if !changed {
+		return
+	}
+	n.apps.SetNatType(res.Type.String())
+	n.apps.ForEachConn(func(c *factory.Connection) {
+		n.apps.ResyncToDiscovery(c)
+	})
+}
+
+// GetNAT returns the last nat detection result, nil before the first detection
+func (n *Node) GetNAT() *nat.Result {
+	n.natMutex.RLock()
+	defer n.natMutex.RUnlock()
+	return n.nat
+}
@@ -0,0 +1,119 @@
+package node
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/skycoin/skywire/pkg/net/nat"
+	"github.com/skycoin/skywire/pkg/net/ntp"
+	"github.com/skycoin/skywire/pkg/net/skycoin-messenger/factory"
+)
+
+const TestNetwork = "testnet"
+
+// NetworkProfile is the infrastructure of a network, the discoveries and the servers the
+// node detects its nat type and checks its clock with
+type NetworkProfile struct {
+	Name        string    `json:"name"`
+	Discoveries Addresses `json:"discoveries"`
+	// empty for the default servers
+	STUNServers Addresses `json:"stun_servers,omitempty"`
+	NTPServers  Addresses `json:"ntp_servers,omitempty"`
+}
+
+// Networks are the built-in profiles, the test network has no public discoveries, its
+// nodes are started with -discovery-address
+var Networks = map[string]NetworkProfile{
+	factory.MainNetwork: {
+		Name:        factory.MainNetwork,
+		Discoveries: NewNodeConf().DiscoveryAddresses,
+	},
+	TestNetwork: {
+		Name: TestNetwork,
+	},
+}
+
+// LoadNetworkProfile returns the profile in the json file at path, or the built-in profile
+// name if path is empty
+func LoadNetworkProfile(name, path string) (p NetworkProfile, err error) {
+	if len(path) == 0 {
+		var ok bool
+		p, ok = Networks[name]
+		if !ok {
+			names := make([]string, 0, len(Networks))
+			for n := range Networks {
+				names = append(names, n)
+			}
+			sort.Strings(names)
+			err = fmt.Errorf("unknown network %s, the networks are %s or a profile file", name, strings.Join(names, ", "))
+		}
+		return
+	}
+	d, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(d, &p)
+	if err != nil {
+		err = fmt.Errorf("network profile %s: %v", path, err)
+		return
+	}
+	if len(p.Name) == 0 {
+		err = fmt.Errorf("network profile %s has no name", path)
+		return
+	}
+	// the profile names the network unless another network was asked for
+	if p.Name != name && name != factory.MainNetwork {
+		err = fmt.Errorf("network profile %s is for network %s, not %s", path, p.Name, name)
+	}
+	return
+}
+
+// STUN returns the stun servers of the network
+func (p NetworkProfile) STUN() []string {
+	if len(p.STUNServers) == 0 {
+		return nat.DefaultServers
+	}
+	return p.STUNServers
+}
+
+// NTP returns the ntp servers of the network
+func (p NetworkProfile) NTP() []string {
+	if len(p.NTPServers) == 0 {
+		return ntp.DefaultServers
+	}
+	return p.NTPServers
+}
+
+// NodeConf returns the default config of a node of the network
+func (p NetworkProfile) NodeConf() *Config {
+	c := &Config{DiscoveryAddresses: append(Addresses(nil), p.Discoveries...)}
+	if p.Name != factory.MainNetwork {
+		c.Network = p.Name
+	}
+	return c
+}
+
+// GetNetwork returns the network of the config, the configs before networks are for
+// the main network
+func (c *Config) GetNetwork() string {
+	if len(c.Network) == 0 {
+		return factory.MainNetwork
+	}
+	return c.Network
+}
+
+// SetNetwork sets the network of the node, the node refuses the discoveries, managers and
+// nodes of other networks
+func (n *Node) SetNetwork(name string) {
+	n.apps.SetNetwork(name)
+	n.manager.SetNetwork(name)
+}
+
+// GetNetwork returns the network of the node
+func (n *Node) GetNetwork() string {
+	return n.apps.GetNetwork()
+}
@@ -0,0 +1,84 @@
+package node_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/skycoin/skywire/pkg/net/skycoin-messenger/factory"
+	"github.com/skycoin/skywire/pkg/node"
+	"github.com/skycoin/skywire/pkg/node/nodetest"
+)
+
+func TestLoadNetworkProfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "network")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := func(name, d string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(d), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	devnet := file("devnet.json", `{"name":"devnet","discoveries":["127.0.0.1:5999-key"]}`)
+	for _, c := range []struct {
+		name, network, path string
+		// the network of the profile, empty if it is refused
+		want        string
+		discoveries int
+	}{
+		{name: "main network", network: factory.MainNetwork, want: factory.MainNetwork, discoveries: len(node.NewNodeConf().DiscoveryAddresses)},
+		{name: "test network", network: node.TestNetwork, want: node.TestNetwork},
+		{name: "unknown network", network: "devnet"},
+		{name: "profile file", network: factory.MainNetwork, path: devnet, want: "devnet", discoveries: 1},
+		{name: "profile of its network", network: "devnet", path: devnet, want: "devnet", discoveries: 1},
+		{name: "profile of another network", network: node.TestNetwork, path: devnet},
+		{name: "profile without a name", network: factory.MainNetwork, path: file("noname.json", `{"discoveries":[]}`)},
+		{name: "invalid profile", network: factory.MainNetwork, path: file("invalid.json", `{`)},
+		{name: "missing profile", network: factory.MainNetwork, path: filepath.Join(dir, "missing.json")},
+	} {
+		p, err := node.LoadNetworkProfile(c.network, c.path)
+		if len(c.want) == 0 {
+			if err == nil {
+				t.Errorf("%s: loaded %#v", c.name, p)
+			}
+			continue
+		}
+		if err != nil || p.Name != c.want || len(p.Discoveries) != c.discoveries || p.NodeConf().GetNetwork() != c.want {
+			t.Errorf("%s: profile %#v: %v", c.name, p, err)
+		}
+	}
+}
+
+func TestNetworks(t *testing.T) {
+	e := nodetest.NewEnv(t, 1)
+	defer e.Close()
+	d := e.Discoveries[0]
+	d.SetNetwork(node.TestNetwork)
+
+	// only the nodes of its network register with the discovery
+	for _, c := range []struct {
+		network    string
+		registered bool
+		wait       time.Duration
+	}{
+		{node.TestNetwork, true, 10 * time.Second},
+		{"", false, time.Second},
+		{"devnet", false, time.Second},
+	} {
+		n := e.NewNode("node-" + c.network)
+		n.SetNetwork(c.network)
+		o, err := e.Start(n).Onboard("", "", c.wait)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if o.Discoveries[d.Address()] != c.registered {
+			t.Errorf("node of network %q registered %v", c.network, o.Discoveries[d.Address()])
+		}
+	}
+}
@@ -2,8 +2,11 @@ package node
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"net"
+	"net/url"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -13,7 +16,14 @@ import (
 
 	log "github.com/sirupsen/logrus"
 	"github.com/skycoin/skycoin/src/cipher"
+	cn "github.com/skycoin/skywire/pkg/net/conn"
+	"github.com/skycoin/skywire/pkg/net/nat"
+	"github.com/skycoin/skywire/pkg/net/ntp"
+	"github.com/skycoin/skywire/pkg/net/portmap"
+	"github.com/skycoin/skywire/pkg/net/resolver"
 	"github.com/skycoin/skywire/pkg/net/skycoin-messenger/factory"
+	"github.com/skycoin/skywire/pkg/net/util"
+	"github.com/skycoin/skywire/pkg/trace"
 )
 
 type Addresses []string
@@ -45,6 +55,56 @@ type Node struct {
 
 	srs      []*SearchResult
 	srsMutex sync.Mutex
+
+	// stateless nodes keep the auto start config in memory
+	stateless      bool
+	autoStart      *AutoStartFile
+	autoStartMutex sync.Mutex
+
+	nat      *nat.Result
+	natMutex sync.RWMutex
+
+	portMapping      *portmap.Mapping
+	portMappingMutex sync.RWMutex
+
+	services Services
+
+	endpoints      *resolver.Endpoints
+	endpointsMutex sync.RWMutex
+
+	onboarding      *Onboarding
+	onboardingMutex sync.Mutex
+
+	pairing pairingState
+
+	watchdog      *watchdog
+	watchdogMutex sync.RWMutex
+
+	maintenance maintenanceState
+
+	accounting      *accounting
+	accountingMutex sync.RWMutex
+
+	hooks      *hooks
+	hooksMutex sync.RWMutex
+
+	bandwidth      *bandwidthTests
+	bandwidthMutex sync.RWMutex
+
+	appConfigRoot      string
+	appConfigRootMutex sync.RWMutex
+
+	peerListsPath  string
+	peerListsMutex sync.Mutex
+
+	clock        *ntp.Result
+	maxClockSkew time.Duration
+	clockMutex   sync.RWMutex
+
+	subsystems subsystems
+
+	closing chan struct{}
+	closed  sync.Once
 }
 
 type Config struct {
@@ -57,6 +117,8 @@ type Config struct {
 	SeedPath           string    `json:"seed_path"`
 	AutoStartPath      string    `json:"auto_start_path"`
 	WebPort            string    `json:"web_port"`
+	// empty for the main network
+	Network string `json:"network,omitempty"`
 }
 
 type NodeConfigs struct {
@@ -65,6 +127,7 @@ type NodeConfigs struct {
 }
 
 func New(seedPath, launchConfigPath, webPort string) *Node {
+	KeepRecentLogs()
 	apps := factory.NewMessengerFactory()
 	apps.SetLoggerLevel(factory.DebugLevel)
 	apps.Proxy = true
@@ -77,13 +140,73 @@ func New(seedPath, launchConfigPath, webPort string) *Node {
 	m := factory.NewMessengerFactory()
 	m.SetDefaultSeedConfigPath(seedPath)
 	m.SetAppVersion(Version)
-	return &Node{
+	n := &Node{
 		apps:             apps,
 		manager:          m,
 		seedConfigPath:   seedPath,
 		launchConfigPath: launchConfigPath,
 		webPort:          webPort,
+		closing:          make(chan struct{}),
+	}
+	n.SetServices(Services{})
+	apps.SetOnTransportClosed(n.transportClosed)
+	apps.SetOnPeerRefused(n.peerRefused)
+	return n
+}
+
+// SetPlainTransportNodes disables the encryption of transports with the nodes (hex public keys)
+// whose link is already encrypted and authenticated, the other node must list this node too
+func (n *Node) SetPlainTransportNodes(nodes []string) (err error) {
+	keys := make([]cipher.PubKey, 0, len(nodes))
+	for _, v := range nodes {
+		var k cipher.PubKey
+		k, err = pubKeyFromHex(v)
+		if err != nil {
+			err = fmt.Errorf("plain transport node %s: %v", v, err)
+			return
+		}
+		keys = append(keys, k)
+	}
+	n.apps.SetPlainTransportNodes(keys)
+	return
+}
+
+// ExplainRoute replays why the transports of a setup of an app of the node were set up through
+// their discoveries, id is the route of the transports
+func (n *Node) ExplainRoute(id string) (factory.RouteExplanation, bool) {
+	return n.apps.ExplainRoute(id)
+}
+
+// SetPathCost ranks the paths of the transports of the apps by the weights, nil to set them up
+// through all the discoveries at once
+func (n *Node) SetPathCost(w *factory.PathCost) {
+	n.apps.SetPathCost(w)
+}
+
+// pubKeyFromHex decodes the hex key (s) of a config,
+// cipher.PubKeyFromHex panics on a key of the wrong length
+func pubKeyFromHex(s string) (key cipher.PubKey, err error) {
+	if len(s) != 2*len(key) {
+		err = errors.New("Invalid public key length")
+		return
+	}
+	return cipher.PubKeyFromHex(s)
+}
+
+// SetCriticalApps keeps a standby transport for the transports to the apps (hex public keys)
+func (n *Node) SetCriticalApps(apps []string) (err error) {
+	keys := make([]cipher.PubKey, 0, len(apps))
+	for _, v := range apps {
+		var k cipher.PubKey
+		k, err = cipher.PubKeyFromHex(v)
+		if err != nil {
+			err = fmt.Errorf("critical app %s: %v", v, err)
+			return
+		}
+		keys = append(keys, k)
 	}
+	n.apps.SetCriticalApps(keys)
+	return
 }
 
 func (n *Node) GetManager() *factory.MessengerFactory {
@@ -91,6 +214,13 @@ func (n *Node) GetManager() *factory.MessengerFactory {
 }
 
 func (n *Node) Close() {
+	n.closed.Do(func() {
+		close(n.closing)
+		// the last report is written before the transports it counts are closed
+		n.finishAccounting()
+	})
+	n.apps.SaveQuotaUsage()
+	n.apps.SavePeers()
 	n.apps.Close()
 	n.manager.Close()
 }
@@ -111,6 +241,23 @@ func (n *Node) Start(discoveries Addresses, address string) (err error) {
 		}()
 	}
 
+	err = n.connectDiscoveries(discoveries)
+	return
+}
+
+// StartWithListener starts the node on a listener passed by the service manager
+func (n *Node) StartWithListener(discoveries Addresses, ln *net.TCPListener) (err error) {
+	n.discoveries = discoveries
+	n.lnAddr = ln.Addr().String()
+	err = n.apps.ListenOn(ln)
+	if err != nil {
+		return
+	}
+	err = n.connectDiscoveries(discoveries)
+	return
+}
+
+func (n *Node) connectDiscoveries(discoveries Addresses) (err error) {
 	for _, addr := range discoveries {
 		err = n.connectDiscovery(addr)
 		if err != nil {
@@ -123,22 +270,24 @@ func (n *Node) Start(discoveries Addresses, address string) (err error) {
 
 func (n *Node) connectDiscovery(addr string) (err error) {
 	n.onDiscoveries.Store(addr, false)
-	split := strings.Split(addr, "-")
-	if len(split) != 2 {
+	// the host may have dashes, the key has none
+	i := strings.LastIndex(addr, "-")
+	if i < 1 {
 		err = fmt.Errorf("discovery address %s is not valid", addr)
 		return
 	}
-	tk, err := cipher.PubKeyFromHex(split[1])
+	tk, err := cipher.PubKeyFromHex(addr[i+1:])
 	if err != nil {
 		err = fmt.Errorf("discovery address %s is not valid", addr)
 		return
 	}
-	err = n.apps.ConnectWithConfig(split[0], &factory.ConnConfig{
-		TargetKey:     tk,
-		Reconnect:     true,
-		ReconnectWait: 10 * time.Second,
+	err = n.apps.ConnectWithConfig(addr[:i], &factory.ConnConfig{
+		TargetKey:        tk,
+		Reconnect:        true,
+		ReconnectWait:    10 * time.Second,
+		ReconnectMaxWait: 5 * time.Minute,
 		OnConnected: func(connection *factory.Connection) {
-			go func() {
+			n.supervise(DiscoverySubsystem, func() {
 				for {
 					select {
 					case m, ok := <-connection.GetChanIn():
@@ -148,7 +297,7 @@ func (n *Node) connectDiscovery(addr string) (err error) {
 						log.Debugf("discoveries:%x", m)
 					}
 				}
-			}()
+			})
 			n.apps.ResyncToDiscovery(connection)
 			n.onDiscoveries.Store(addr, true)
 		},
@@ -161,18 +310,23 @@ func (n *Node) connectDiscovery(addr string) (err error) {
 }
 
 func (n *Node) ConnectManager(managerAddr string, onConnection func()(success bool)) (err error) {
+	// a paired node only connects to the manager it is paired with
+	managerKey, _ := n.pairedManager()
 	err = n.manager.ConnectWithConfig(managerAddr, &factory.ConnConfig{
-		Context:       map[string]string{"node-api": n.webPort},
-		Reconnect:     true,
-		ReconnectWait: 10 * time.Second,
+		TargetKey:        managerKey,
+		Context:          map[string]string{"node-api": n.webPort},
+		Reconnect:        true,
+		ReconnectWait:    10 * time.Second,
+		ReconnectMaxWait: 5 * time.Minute,
 		OnConnected: func(connection *factory.Connection) {
-			go func() {
+			// the api of the node is started here
+			n.supervise(ManagerSubsystem, func() {
 				// try to run the function until the connection is closed or it is successful
-				for !connection.IsClosed() && !onConnection() {
-					// if the function is not successful, wait and try again
-					time.Sleep(5 * time.Second)
+				for failures := 0; !connection.IsClosed() && !onConnection(); failures++ {
+					// if the function is not successful, wait longer each time and try again
+					time.Sleep(factory.Backoff(5*time.Second, time.Minute, failures))
 				}
-			}()
+			})
 			go func() {
 				for {
 					select {
@@ -193,10 +347,32 @@ func (n *Node) ConnectManager(managerAddr string, onConnection func()(success bo
 	return
 }
 
+// SignManagerRequest returns the headers signing a request of the node to the manager api url (u)
+// with the key of the node, the manager gives the token of the node api to connected nodes only
+func (n *Node) SignManagerRequest(method string, u *url.URL) (headers map[string]string, err error) {
+	sc := n.manager.GetDefaultSeedConfig()
+	if sc == nil {
+		err = errors.New("the node has no keys")
+		return
+	}
+	sk, err := cipher.SecKeyFromHex(sc.SecKey)
+	if err != nil {
+		return
+	}
+	defer util.WipeSecKey(&sk)
+	headers = util.SignShellRequest(sk, sc.PublicKey, method, u, nil, time.Now())
+	return
+}
+
 func (n *Node) GetListenAddress() string {
 	return n.lnAddr
 }
 
+// GetDiscoveries returns the addresses of the discoveries the node was started with
+func (n *Node) GetDiscoveries() Addresses {
+	return n.discoveries
+}
+
 type NodeTransport struct {
 	FromNode string `json:"from_node"`
 	ToNode   string `json:"to_node"`
@@ -207,6 +383,22 @@ type NodeTransport struct {
 	DownloadBW    uint `json:"download_bandwidth"`
 	UploadTotal   uint `json:"upload_total"`
 	DownloadTotal uint `json:"download_total"`
+
+	// not encrypted, the nodes trust the link between them
+	Plain bool `json:"plain,omitempty"`
+	// id of the decision that chose the discovery of the transport, see ExplainRoute
+	Route string `json:"route,omitempty"`
+	// capabilities both nodes support
+	Features []string `json:"features,omitempty"`
+	// uuid of the transport and whether both nodes signed its record
+	ID     string `json:"id,omitempty"`
+	Signed bool   `json:"signed,omitempty"`
+	// kept for a critical transport between the same apps, it serves them once that one fails
+	Standby bool `json:"standby,omitempty"`
+	// state of the connections of the apps by their ids
+	Loops map[uint32]string `json:"loops,omitempty"`
+	// retransmissions between the nodes, missing before they connected
+	ARQ *cn.ARQStats `json:"arq,omitempty"`
 }
 
 type NodeInfo struct {
@@ -216,6 +408,22 @@ type NodeInfo struct {
 	Version      string          `json:"version"`
 	Tag          string          `json:"tag"`
 	Os           string          `json:"os"`
+	NAT          *nat.Result     `json:"nat,omitempty"`
+	PortMapping  *portmap.Status `json:"port_mapping,omitempty"`
+	Watchdog     *WatchdogStatus `json:"watchdog,omitempty"`
+	Clock        *ntp.Result     `json:"clock,omitempty"`
+	// bytes the transports of each app used this month, if the apps have quotas
+	QuotaUsage map[string]int64 `json:"quota_usage,omitempty"`
+	// transports to the apps the node takes and has, if it sets a capacity
+	Capacity *factory.Capacity `json:"capacity,omitempty"`
+	// the maintenance running and the events of the last ones
+	Maintenance *MaintenanceStatus `json:"maintenance,omitempty"`
+	// what the node fixed after it reconnected to its discoveries
+	Reconciliations []factory.Reconciliation `json:"reconciliations,omitempty"`
+	// the subsystems of the node by stage, if it starts them in order
+	Subsystems []SubsystemStatus `json:"subsystems,omitempty"`
+	// addresses of the discoveries and the manager that failed the last time they were dialed
+	Endpoints []resolver.EndpointStatus `json:"endpoints,omitempty"`
 }
 
 type FeedBackItem struct {
@@ -225,22 +433,63 @@ type FeedBackItem struct {
 	UnreadMessages int    `json:"unread"`
 }
 
+func newNodeTransport(v *factory.Transport) NodeTransport {
+	record, signed := v.Record()
+	var arq *cn.ARQStats
+	if stats, ok := v.ARQStats(); ok {
+		arq = &stats
+	}
+	return NodeTransport{
+		FromNode:      v.FromNode.Hex(),
+		ToNode:        v.ToNode.Hex(),
+		FromApp:       v.FromApp.Hex(),
+		ToApp:         v.ToApp.Hex(),
+		UploadBW:      v.GetUploadBandwidth(),
+		DownloadBW:    v.GetDownloadBandwidth(),
+		UploadTotal:   v.GetUploadTotal(),
+		DownloadTotal: v.GetDownloadTotal(),
+		Plain:         v.IsPlain(),
+		Route:         v.RouteID(),
+		Features:      v.Features().Names(),
+		ID:            record.ID,
+		Signed:        signed,
+		Standby:       v.IsStandby(),
+		Loops:         loopStates(v),
+		ARQ:           arq,
+	}
+}
+
+func loopStates(v *factory.Transport) map[uint32]string {
+	states := v.LoopStates()
+	if len(states) == 0 {
+		return nil
+	}
+	loops := make(map[uint32]string, len(states))
+	for id, s := range states {
+		loops[id] = s.String()
+	}
+	return loops
+}
+
 func (n *Node) GetNodeInfo() (ni NodeInfo) {
+	return n.getNodeInfo(true)
+}
+
+// GetNodeInfoWithoutTransports leaves out the transports, a node with many transports
+// pages through them with GetTransports
+func (n *Node) GetNodeInfoWithoutTransports() (ni NodeInfo) {
+	return n.getNodeInfo(false)
+}
+
+func (n *Node) getNodeInfo(transports bool) (ni NodeInfo) {
 	var ts []NodeTransport
 	var afs []FeedBackItem
 	n.apps.ForEachAcceptedConnection(func(key cipher.PubKey, conn *factory.Connection) {
-		conn.ForEachTransport(func(v *factory.Transport) {
-			ts = append(ts, NodeTransport{
-				FromNode:      v.FromNode.Hex(),
-				ToNode:        v.ToNode.Hex(),
-				FromApp:       v.FromApp.Hex(),
-				ToApp:         v.ToApp.Hex(),
-				UploadBW:      v.GetUploadBandwidth(),
-				DownloadBW:    v.GetDownloadBandwidth(),
-				UploadTotal:   v.GetUploadTotal(),
-				DownloadTotal: v.GetDownloadTotal(),
+		if transports {
+			conn.ForEachTransport(func(v *factory.Transport) {
+				ts = append(ts, newNodeTransport(v))
 			})
-		})
+		}
 		feedback := conn.GetAppFeedback()
 		if feedback != nil {
 			afs = append(afs, FeedBackItem{
@@ -266,12 +515,22 @@ func (n *Node) GetNodeInfo() (ni NodeInfo) {
 		return true
 	})
 	ni = NodeInfo{
-		Discoveries:  d,
-		Transports:   ts,
-		AppFeedbacks: afs,
-		Version:      Version,
-		Tag:          Tag,
-		Os:           runtime.GOOS,
+		Discoveries:     d,
+		Transports:      ts,
+		AppFeedbacks:    afs,
+		Version:         Version,
+		Tag:             Tag,
+		Os:              runtime.GOOS,
+		NAT:             n.GetNAT(),
+		PortMapping:     n.GetPortMapping(),
+		Watchdog:        n.GetWatchdog(),
+		Clock:           n.GetClock(),
+		QuotaUsage:      n.GetQuotaUsage(),
+		Capacity:        n.getCapacity(),
+		Maintenance:     n.GetMaintenance(),
+		Reconciliations: n.apps.GetReconciliations(),
+		Subsystems:      n.GetSubsystems(),
+		Endpoints:       n.GetEndpoints(),
 	}
 	return
 }
@@ -337,6 +596,9 @@ func (n *Node) Search(pages, limit int, discoveryKey cipher.PubKey, attr string)
 }
 
 func (n *Node) searchResultCallback(resp *factory.QueryByAttrsResp) {
+	if resp != nil && len(resp.Error) > 0 {
+		log.Errorf("search %d rejected by discovery: %s", resp.Seq, resp.Error)
+	}
 	n.srsMutex.Lock()
 	if resp != nil && resp.Result != nil {
 		var apps = make([]SearchResultApp, 0)
@@ -418,7 +680,129 @@ func (n *Node) NewAutoStartConfig() AutoStartConfig {
 	return sc
 }
 
+// SetStateless keeps the auto start config (asc) in memory instead of the auto start path,
+// a nil config starts with the default one
+func (n *Node) SetStateless(asc *AutoStartConfig) (err error) {
+	n.autoStartMutex.Lock()
+	defer n.autoStartMutex.Unlock()
+	n.stateless = true
+	if asc == nil {
+		return
+	}
+	key, err := n.GetNodeKey()
+	if err != nil {
+		return
+	}
+	f := n.NewAutoStartFile()
+	f.Config[key] = *asc
+	n.autoStart = &f
+	return
+}
+
+// SetTracer records the spans of the transport setups of the apps
+func (n *Node) SetTracer(t *trace.Tracer) {
+	n.apps.SetTracer(t)
+}
+
+// SetAnnounceSchedule sets when the services of the apps are announced to the discoveries
+func (n *Node) SetAnnounceSchedule(s factory.AnnounceSchedule) {
+	n.apps.SetAnnounceSchedule(s)
+}
+
+// SetRouteCache sets the cache of the answers of the discoveries to the transports of the apps
+func (n *Node) SetRouteCache(config factory.RouteCacheConfig) {
+	n.apps.SetRouteCache(config)
+}
+
+// SetRoutingPolicy chooses the discoveries of the transports of the apps with p, see
+// factory.RoutingPolicy
+func (n *Node) SetRoutingPolicy(p factory.RoutingPolicy) {
+	n.apps.SetRoutingPolicy(p)
+}
+
+// SetPrivateSetups hides the apps of all transports of the node from the discoveries
+func (n *Node) SetPrivateSetups(private bool) {
+	n.apps.SetPrivateSetups(private)
+}
+
+// SetSetupTimeouts sets the timeouts of the transport setups of the apps that do not set them
+func (n *Node) SetSetupTimeouts(t factory.SetupTimeouts) {
+	n.apps.SetSetupTimeouts(t)
+}
+
+// SetSetupLimits limits the transport setups of the apps running at once
+func (n *Node) SetSetupLimits(l factory.SetupLimits) {
+	n.apps.SetSetupLimits(l)
+}
+
+// GetSetupQueueStats returns the transport setups of the apps running and waiting for their turn
+func (n *Node) GetSetupQueueStats() factory.SetupQueueStats {
+	return n.apps.GetSetupQueueStats()
+}
+
+// SetAppPortsPath keeps the port of every app pair at the path, so the addresses
+// saved by the apps stay valid across restarts
+func (n *Node) SetAppPortsPath(path string) error {
+	return n.apps.SetAppPortsPath(path)
+}
+
+// SetQuotas limits the bandwidth and the monthly data of the transports of the apps
+func (n *Node) SetQuotas(config factory.QuotaConfig) error {
+	return n.apps.SetQuotas(config)
+}
+
+// SetCapacity limits the transports to the apps of the node, 0 for no limit, and sets the
+// bandwidth in bytes per second announced to the discoveries, 0 to announce none
+func (n *Node) SetCapacity(maxTransports int, bandwidth int64) {
+	n.apps.SetCapacity(maxTransports, bandwidth)
+}
+
+func (n *Node) getCapacity() *factory.Capacity {
+	c := n.apps.GetCapacity()
+	if c.MaxTransports == 0 && c.Bandwidth == 0 && c.MaintenanceUntil == 0 {
+		return nil
+	}
+	return &c
+}
+
+// GetQuotaUsage returns the bytes the transports of each app used this month, by app key
+func (n *Node) GetQuotaUsage() map[string]int64 {
+	return n.apps.GetQuotaUsage()
+}
+
+// SetPeerStorePath keeps what the transports of the apps learned of the other nodes in the
+// json file at path, the discovery of the last transport to a node is asked first after a
+// restart too, empty to keep the peers in memory
+func (n *Node) SetPeerStorePath(path string) error {
+	return n.apps.SetPeerStorePath(path)
+}
+
+// GetPeers returns the nodes the transports of the apps were set up or failed with
+func (n *Node) GetPeers() []factory.PeerRecord {
+	return n.apps.GetPeers()
+}
+
+func (n *Node) IsStateless() bool {
+	n.autoStartMutex.Lock()
+	defer n.autoStartMutex.Unlock()
+	return n.stateless
+}
+
 func (n *Node) ReadAutoStartConfig() (f AutoStartFile, err error) {
+	n.autoStartMutex.Lock()
+	if n.stateless {
+		defer n.autoStartMutex.Unlock()
+		if n.autoStart == nil {
+			err = &os.PathError{Op: "read", Path: "auto start config", Err: os.ErrNotExist}
+			return
+		}
+		f = n.NewAutoStartFile()
+		for k, v := range n.autoStart.Config {
+			f.Config[k] = v
+		}
+		return
+	}
+	n.autoStartMutex.Unlock()
 	fb, err := ioutil.ReadFile(n.launchConfigPath)
 	if err != nil {
 		return
@@ -445,6 +829,13 @@ func (n *Node) ReadOld1AutoStartConfig() (lc Old1AutoStartConfig, err error) {
 }
 
 func (n *Node) WriteAutoStartConfig(f AutoStartFile, path string) (err error) {
+	n.autoStartMutex.Lock()
+	if n.stateless {
+		n.autoStart = &f
+		n.autoStartMutex.Unlock()
+		return
+	}
+	n.autoStartMutex.Unlock()
 	d, err := json.Marshal(f)
 	if err != nil {
 		return
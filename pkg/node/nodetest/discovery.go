@@ -0,0 +1,200 @@
+// Package nodetest provides in-memory fakes of the outside services a node uses, so nodes,
+// apps and discoveries can run together in a test without the network
+package nodetest
+
+import (
+	"net"
+	"sort"
+	"sync"
+
+	"github.com/skycoin/skycoin/src/cipher"
+	"github.com/skycoin/skywire/pkg/net/skycoin-messenger/factory"
+)
+
+// Store is an in-memory factory.DiscoveryStore
+type Store struct {
+	nodes map[cipher.PubKey]*factory.NodeServices
+	sync.RWMutex
+}
+
+func NewStore() *Store {
+	return &Store{nodes: make(map[cipher.PubKey]*factory.NodeServices)}
+}
+
+func (s *Store) RegisterService(key cipher.PubKey, ns *factory.NodeServices) error {
+	s.Lock()
+	s.nodes[key] = ns
+	s.Unlock()
+	return nil
+}
+
+func (s *Store) UnRegisterService(key cipher.PubKey) error {
+	s.Lock()
+	delete(s.nodes, key)
+	s.Unlock()
+	return nil
+}
+
+// Services returns the services the node registered, nil if it registered none
+func (s *Store) Services(node cipher.PubKey) *factory.NodeServices {
+	s.RLock()
+	defer s.RUnlock()
+	return s.nodes[node]
+}
+
+// sorted by node key so the pages are stable
+func (s *Store) sortedNodes() (keys []cipher.PubKey) {
+	keys = make([]cipher.PubKey, 0, len(s.nodes))
+	for k := range s.nodes {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].Hex() < keys[j].Hex()
+	})
+	return
+}
+
+func allowed(service *factory.Service, node cipher.PubKey) bool {
+	if len(service.AllowNodes) < 1 {
+		return true
+	}
+	for _, k := range service.AllowNodes {
+		if k == node.Hex() {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Store) FindServiceAddresses(keys []cipher.PubKey, exclude cipher.PubKey) (result []*factory.ServiceInfo) {
+	s.RLock()
+	defer s.RUnlock()
+	nodes := s.sortedNodes()
+	for _, key := range keys {
+		info := &factory.ServiceInfo{PubKey: key}
+		for _, node := range nodes {
+			if node == exclude {
+				continue
+			}
+			ns := s.nodes[node]
+			for _, service := range ns.Services {
+				if service.Key == key && allowed(service, exclude) {
+					info.Nodes = append(info.Nodes, &factory.NodeInfo{PubKey: node, Address: ns.ServiceAddress})
+					break
+				}
+			}
+		}
+		result = append(result, info)
+	}
+	return
+}
+
+func hasAttrs(service *factory.Service, attrs []string) bool {
+	for _, attr := range attrs {
+		found := false
+		for _, a := range service.Attributes {
+			if a == attr {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Store) FindByAttributes(attrs ...string) *factory.AttrNodesInfo {
+	return s.FindByAttributesAndPaging(1, 0, attrs...)
+}
+
+// FindByAttributesAndPaging returns the nodes with an app having all the attrs, pages start
+// at 1 and a limit of 0 returns every node
+func (s *Store) FindByAttributesAndPaging(page, limit int, attrs ...string) *factory.AttrNodesInfo {
+	s.RLock()
+	defer s.RUnlock()
+	var matched []*factory.AttrNodeInfo
+	for _, node := range s.sortedNodes() {
+		ns := s.nodes[node]
+		info := &factory.AttrNodeInfo{Node: node, Location: ns.Location, Version: ns.Version}
+		for _, service := range ns.Services {
+			if service.HideFromDiscovery || !hasAttrs(service, attrs) {
+				continue
+			}
+			info.Apps = append(info.Apps, service.Key)
+			info.AppInfos = append(info.AppInfos, &factory.AttrAppInfo{Key: service.Key, Version: service.Version})
+		}
+		if len(info.Apps) > 0 {
+			matched = append(matched, info)
+		}
+	}
+	result := &factory.AttrNodesInfo{Count: int64(len(matched))}
+	if limit <= 0 {
+		result.Nodes = matched
+		return result
+	}
+	if page < 1 {
+		page = 1
+	}
+	start := (page - 1) * limit
+	if start >= len(matched) {
+		return result
+	}
+	end := start + limit
+	if end > len(matched) {
+		end = len(matched)
+	}
+	result.Nodes = matched[start:end]
+	return result
+}
+
+// Discovery is a discovery listening on the loopback with an in-memory store
+type Discovery struct {
+	*factory.MessengerFactory
+	Store   *Store
+	address string
+}
+
+// ports tried by NewDiscovery, the udp port of the same number as a free tcp port may be taken
+// by the tests of another package
+const discoveryListenTries = 5
+
+// NewDiscovery starts a discovery on a free loopback port
+func NewDiscovery() (d *Discovery, err error) {
+	for i := 0; i < discoveryListenTries; i++ {
+		d, err = newDiscovery()
+		if err == nil {
+			return
+		}
+	}
+	return
+}
+
+func newDiscovery() (d *Discovery, err error) {
+	ln, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		return
+	}
+	f := factory.NewMessengerFactory()
+	f.SetDefaultSeedConfig(factory.NewSeedConfig())
+	store := NewStore()
+	f.SetDiscoveryStore(store)
+	err = f.ListenOn(ln)
+	if err != nil {
+		ln.Close()
+		f.Close()
+		return
+	}
+	d = &Discovery{
+		MessengerFactory: f,
+		Store:            store,
+		address:          ln.Addr().String() + "-" + f.GetDefaultSeedConfig().PublicKey,
+	}
+	return
+}
+
+// Address returns the address of the discovery the way nodes are configured with it
+func (d *Discovery) Address() string {
+	return d.address
+}
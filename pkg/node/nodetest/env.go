@@ -0,0 +1,312 @@
+package nodetest
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skycoin/skycoin/src/cipher"
+	"github.com/skycoin/skywire/pkg/net/skycoin-messenger/factory"
+	"github.com/skycoin/skywire/pkg/node"
+)
+
+// Env runs the discoveries, nodes and apps of a test with their keys in a temporary directory
+type Env struct {
+	t   testing.TB
+	Dir string
+	// the nodes started by the env connect to all of them
+	Discoveries []*Discovery
+	closers     []func()
+}
+
+// NewEnv starts the discoveries of a test, the test fails if they can not start
+func NewEnv(t testing.TB, discoveries int) *Env {
+	dir, err := ioutil.TempDir("", "nodetest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &Env{t: t, Dir: dir}
+	for i := 0; i < discoveries; i++ {
+		d, err := NewDiscovery()
+		if err != nil {
+			e.Close()
+			t.Fatal(err)
+		}
+		e.Discoveries = append(e.Discoveries, d)
+	}
+	return e
+}
+
+// Close closes the apps, nodes and discoveries of the test and removes its directory
+func (e *Env) Close() {
+	for i := len(e.closers) - 1; i >= 0; i-- {
+		e.closers[i]()
+	}
+	e.closers = nil
+	for _, d := range e.Discoveries {
+		d.Close()
+	}
+	os.RemoveAll(e.Dir)
+}
+
+// Path returns the path of elem in the directory of the test
+func (e *Env) Path(elem ...string) string {
+	return filepath.Join(append([]string{e.Dir}, elem...)...)
+}
+
+// Addresses returns the addresses of the discoveries the way nodes are configured with them
+func (e *Env) Addresses() (addresses node.Addresses) {
+	for _, d := range e.Discoveries {
+		addresses = append(addresses, d.Address())
+	}
+	return
+}
+
+// DiscoveryKey returns the public key of the i-th discovery
+func (e *Env) DiscoveryKey(i int) cipher.PubKey {
+	return cipher.MustPubKeyFromHex(e.Discoveries[i].GetDefaultSeedConfig().PublicKey)
+}
+
+// Node is a node started by an Env
+type Node struct {
+	*node.Node
+	Key cipher.PubKey
+	// address the node serves apps on
+	Apps string
+}
+
+// NewNode creates a node with its keys in the directory name and the fakes of the outside
+// services, it is not started
+func (e *Env) NewNode(name string) *node.Node {
+	n := node.New(e.Path(name, "keys.json"), e.Path(name, "autoStart.json"), "")
+	n.SetServices(New().Services())
+	return n
+}
+
+// Start starts n on a loopback port with all the discoveries, it is closed with the env
+func (e *Env) Start(n *node.Node) *Node {
+	ln, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	e.closers = append(e.closers, n.Close)
+	err = n.StartWithListener(e.Addresses(), ln)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	k, err := n.GetNodeKey()
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	return &Node{Node: n, Key: cipher.MustPubKeyFromHex(k), Apps: ln.Addr().String()}
+}
+
+// StartNode creates and starts a node with its keys in the directory name
+func (e *Env) StartNode(name string) *Node {
+	return e.Start(e.NewNode(name))
+}
+
+// App is an app registered with a node, the answers to its transport setups are queued
+// until it reads them with Answer
+type App struct {
+	*factory.Connection
+	Node  *Node
+	Resps chan factory.AppConnResp
+	e     *Env
+}
+
+// ConnectApp registers an app with its keys in name.json with the node
+func (e *Env) ConnectApp(n *Node, name string) *App {
+	a := &App{Node: n, Resps: make(chan factory.AppConnResp, 64), e: e}
+	f := factory.NewMessengerFactory()
+	connected := make(chan *factory.Connection, 1)
+	e.closers = append(e.closers, func() { f.Close() })
+	err := f.ConnectWithConfig(n.Apps, &factory.ConnConfig{
+		SeedConfigPath: e.Path(name + ".json"),
+		OnConnected: func(c *factory.Connection) {
+			connected <- c
+		},
+		AppConnectionInitCallback: func(resp *factory.AppConnResp) *factory.AppFeedback {
+			select {
+			case a.Resps <- *resp:
+			default:
+			}
+			return &factory.AppFeedback{Port: resp.Port, Failed: resp.Failed, Msg: resp.Msg}
+		},
+	})
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	select {
+	case a.Connection = <-connected:
+	case <-time.After(10 * time.Second):
+		e.t.Fatalf("app %s not registered", name)
+	}
+	e.closers = append(e.closers, a.Close)
+	return a
+}
+
+// Offer offers a service of the app at address and waits for the discoveries to list it
+func (a *App) Offer(address string, attrs ...string) {
+	err := a.OfferServiceWithAddress(address, "1.0", attrs...)
+	if err != nil {
+		a.e.t.Fatal(err)
+	}
+	a.WaitListed()
+}
+
+// WaitListed waits until every discovery lists a service of the app
+func (a *App) WaitListed() {
+	key := a.GetKey()
+	WaitFor(a.e.t, "the service at the discoveries", func() bool {
+		for _, d := range a.e.Discoveries {
+			ns := d.Store.Services(a.Node.Key)
+			if ns == nil {
+				return false
+			}
+			listed := false
+			for _, s := range ns.Services {
+				listed = listed || s.Key == key
+			}
+			if !listed {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// Dial sets up a transport to the app of the node through the discovery and returns the answer
+func (a *App) Dial(node, app, discovery cipher.PubKey) factory.AppConnResp {
+	return a.DialWithOptions(node, app, discovery, factory.AppDialOptions{})
+}
+
+// DialWithOptions sets up a transport with opts and returns the answer
+func (a *App) DialWithOptions(node, app, discovery cipher.PubKey, opts factory.AppDialOptions) factory.AppConnResp {
+	err := a.BuildAppConnectionWithOptions(node, app, discovery, opts)
+	if err != nil {
+		a.e.t.Fatal(err)
+	}
+	return a.Answer()
+}
+
+// Answer waits for the next answer to a transport setup of the app
+func (a *App) Answer() (resp factory.AppConnResp) {
+	select {
+	case resp = <-a.Resps:
+	case <-time.After(10 * time.Second):
+		a.e.t.Fatal("transport not answered")
+	}
+	return
+}
+
+// Connect sets up a transport like Dial, the test fails if the transport failed
+func (a *App) Connect(node, app, discovery cipher.PubKey) factory.AppConnResp {
+	return a.ConnectWithOptions(node, app, discovery, factory.AppDialOptions{})
+}
+
+// ConnectWithOptions sets up a transport like DialWithOptions, the test fails if the
+// transport failed
+func (a *App) ConnectWithOptions(node, app, discovery cipher.PubKey, opts factory.AppDialOptions) factory.AppConnResp {
+	resp := a.DialWithOptions(node, app, discovery, opts)
+	if resp.Failed {
+		a.e.t.Fatalf("transport failed: %s", resp.Msg.Msg)
+	}
+	return resp
+}
+
+// Echo serves a tcp echo on a loopback port and returns its address
+func (e *Env) Echo() string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	e.closers = append(e.closers, func() { ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(c, c)
+				c.Close()
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// Ping connects to the app port of a node and sends a ping to the echo served through it,
+// the connection is returned open
+func Ping(t testing.TB, port int) net.Conn {
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = conn.Write([]byte("ping")); err != nil {
+		conn.Close()
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	buf := make([]byte, 4)
+	if _, err = io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		conn.Close()
+		t.Fatalf("echo %q, %v", buf, err)
+	}
+	conn.SetReadDeadline(time.Time{})
+	return conn
+}
+
+// ConnectClient connects a messaging client with a new key to the discovery
+func (d *Discovery) ConnectClient(t testing.TB) *factory.Connection {
+	f := factory.NewMessengerFactory()
+	c, err := d.Connect(f, &factory.ConnConfig{SeedConfig: factory.NewSeedConfig()}, 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// Connect connects f as a client with config to the discovery, the first connection
+// is returned
+func (d *Discovery) Connect(f *factory.MessengerFactory, config *factory.ConnConfig, timeout time.Duration) (*factory.Connection, error) {
+	i := strings.LastIndex(d.address, "-")
+	connected := make(chan *factory.Connection, 1)
+	config.TargetKey = cipher.MustPubKeyFromHex(d.address[i+1:])
+	config.OnConnected = func(c *factory.Connection) {
+		select {
+		case connected <- c:
+		default:
+		}
+	}
+	err := f.ConnectWithConfig(d.address[:i], config)
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case c := <-connected:
+		return c, nil
+	case <-time.After(timeout):
+		f.Close()
+		return nil, errors.New("client not connected")
+	}
+}
+
+// WaitFor polls ok until it returns true, the test fails after 10 seconds
+func WaitFor(t testing.TB, what string, ok func() bool) {
+	deadline := time.Now().Add(10 * time.Second)
+	for !ok() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
@@ -0,0 +1,161 @@
+package nodetest
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/skycoin/skywire/pkg/net/nat"
+	"github.com/skycoin/skywire/pkg/net/ntp"
+	"github.com/skycoin/skywire/pkg/net/portmap"
+	"github.com/skycoin/skywire/pkg/node"
+)
+
+// NAT detects the type it is set to
+type NAT struct {
+	result nat.Result
+	sync.Mutex
+}
+
+// Set changes the type the next detections return
+func (f *NAT) Set(t nat.Type, mapped string) {
+	f.Lock()
+	f.result = nat.Result{Type: t, Mapped: mapped, DirectUDP: t.DirectUDP()}
+	f.Unlock()
+}
+
+func (f *NAT) Detect(servers []string) *nat.Result {
+	f.Lock()
+	r := f.result
+	f.Unlock()
+	r.Detected = time.Now().Unix()
+	return &r
+}
+
+// Clock measures the offset it is set to
+type Clock struct {
+	offset time.Duration
+	panics int
+	sync.Mutex
+}
+
+// PanicNext makes the next checks panic
+func (f *Clock) PanicNext(checks int) {
+	f.Lock()
+	f.panics = checks
+	f.Unlock()
+}
+
+// Set changes the offset the next checks return
+func (f *Clock) Set(offset time.Duration) {
+	f.Lock()
+	f.offset = offset
+	f.Unlock()
+}
+
+func (f *Clock) Check(servers []string) *ntp.Result {
+	f.Lock()
+	offset := f.offset
+	panics := f.panics > 0
+	if panics {
+		f.panics--
+	}
+	f.Unlock()
+	if panics {
+		panic("fake clock check failed")
+	}
+	return &ntp.Result{Offset: int64(offset / time.Millisecond), Server: "fake", Checked: time.Now().Unix()}
+}
+
+var ErrNoMappings = errors.New("fake gateway refused the mapping")
+
+// Gateway maps ports in memory
+type Gateway struct {
+	// no gateway is found while set
+	Absent bool
+	// the mappings fail while set
+	Refuse bool
+	// the port mapped for an internal port is the port plus Offset
+	Offset int
+	// 127.0.0.1 if nil
+	External net.IP
+
+	mappings map[string]int
+	sync.Mutex
+}
+
+func (g *Gateway) Discover() (portmap.Mapper, error) {
+	g.Lock()
+	defer g.Unlock()
+	if g.Absent {
+		return nil, portmap.ErrNoGateway
+	}
+	return g, nil
+}
+
+func (g *Gateway) Name() string {
+	return "fake"
+}
+
+func (g *Gateway) ExternalIP() (net.IP, error) {
+	g.Lock()
+	defer g.Unlock()
+	if g.External == nil {
+		return net.IPv4(127, 0, 0, 1), nil
+	}
+	return g.External, nil
+}
+
+func mappingKey(protocol string, internal int) string {
+	return fmt.Sprintf("%s/%d", protocol, internal)
+}
+
+func (g *Gateway) AddMapping(protocol string, internal, external int, lifetime time.Duration) (mapped int, err error) {
+	g.Lock()
+	defer g.Unlock()
+	if g.Refuse {
+		err = ErrNoMappings
+		return
+	}
+	if g.mappings == nil {
+		g.mappings = make(map[string]int)
+	}
+	mapped = internal + g.Offset
+	g.mappings[mappingKey(protocol, internal)] = mapped
+	return
+}
+
+func (g *Gateway) DeleteMapping(protocol string, internal, external int) error {
+	g.Lock()
+	delete(g.mappings, mappingKey(protocol, internal))
+	g.Unlock()
+	return nil
+}
+
+// Mapped returns the external port of the internal port, 0 when it is not mapped
+func (g *Gateway) Mapped(protocol string, internal int) int {
+	g.Lock()
+	defer g.Unlock()
+	return g.mappings[mappingKey(protocol, internal)]
+}
+
+// Fakes are the fake clients of a node
+type Fakes struct {
+	NAT     *NAT
+	Clock   *Clock
+	Gateway *Gateway
+}
+
+// New returns fakes of an open nat, an exact clock and a gateway mapping the same ports
+func New() *Fakes {
+	f := &Fakes{NAT: &NAT{}, Clock: &Clock{}, Gateway: &Gateway{}}
+	f.NAT.Set(nat.Open, "")
+	return f
+}
+
+// Services returns the fakes for node.SetServices
+func (f *Fakes) Services() node.Services {
+	return node.Services{NAT: f.NAT, Clock: f.Clock, Gateway: f.Gateway}
+}
@@ -0,0 +1,92 @@
+package nodetest
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/skycoin/skycoin/src/cipher"
+	"github.com/skycoin/skywire/pkg/net/nat"
+	"github.com/skycoin/skywire/pkg/net/portmap"
+	"github.com/skycoin/skywire/pkg/net/skycoin-messenger/factory"
+)
+
+func TestNodeWithFakes(t *testing.T) {
+	e := NewEnv(t, 1)
+	defer e.Close()
+	d := e.Discoveries[0]
+
+	fakes := New()
+	fakes.NAT.Set(nat.FullCone, "203.0.113.1:5000")
+	fakes.Clock.Set(1500 * time.Millisecond)
+	fakes.Gateway.Offset = 1000
+
+	n := e.NewNode("node")
+	n.SetServices(fakes.Services())
+	started := e.Start(n)
+	n.StartNATDetection(nil)
+	n.StartClockCheck(nil, time.Second)
+	n.StartPortMapping()
+
+	app := e.ConnectApp(started, "app")
+	if err := app.OfferServiceWithAddress("", "1.0", "nodetest"); err != nil {
+		t.Fatal(err)
+	}
+
+	_, p, _ := net.SplitHostPort(started.Apps)
+	port, _ := strconv.Atoi(p)
+	external := "127.0.0.1:" + strconv.Itoa(port+1000)
+	WaitFor(t, "the services of the node", func() bool {
+		ns := d.Store.Services(started.Key)
+		return ns != nil && ns.NatType == nat.FullCone.String() && ns.ExternalAddress == external
+	})
+
+	found := d.Store.FindByAttributes("nodetest")
+	if found.Count != 1 || found.Nodes[0].Node != started.Key {
+		t.Fatalf("find by attributes %#v", found)
+	}
+	if found := d.Store.FindByAttributes("nodetest", "other"); found.Count != 0 {
+		t.Fatalf("find by attributes of another app %#v", found)
+	}
+
+	if r := n.GetNAT(); r == nil || r.Mapped != "203.0.113.1:5000" {
+		t.Fatalf("nat %#v", r)
+	}
+	if !n.ClockSkewed() {
+		t.Fatalf("clock %#v not skewed", n.GetClock())
+	}
+	if mapped := fakes.Gateway.Mapped(portmap.TCP, port); mapped != port+1000 {
+		t.Fatalf("mapped port %d", mapped)
+	}
+}
+
+func TestStorePaging(t *testing.T) {
+	s := NewStore()
+	for i := byte(1); i <= 5; i++ {
+		s.RegisterService(cipher.PubKey{i}, &factory.NodeServices{
+			Services: []*factory.Service{{Key: cipher.PubKey{0xf0 + i}, Attributes: []string{"vpn"}}},
+		})
+	}
+	s.RegisterService(cipher.PubKey{9}, &factory.NodeServices{
+		Services: []*factory.Service{{Key: cipher.PubKey{0xff}, Attributes: []string{"vpn"}, HideFromDiscovery: true}},
+	})
+
+	r := s.FindByAttributesAndPaging(2, 2, "vpn")
+	if r.Count != 5 || len(r.Nodes) != 2 || r.Nodes[0].Node != (cipher.PubKey{3}) {
+		t.Fatalf("page 2 %#v", r)
+	}
+	r = s.FindByAttributesAndPaging(3, 2, "vpn")
+	if len(r.Nodes) != 1 || r.Nodes[0].Node != (cipher.PubKey{5}) {
+		t.Fatalf("page 3 %#v", r)
+	}
+
+	infos := s.FindServiceAddresses([]cipher.PubKey{{0xf1}, {0xff}}, cipher.PubKey{1})
+	if len(infos) != 2 || len(infos[0].Nodes) != 0 || len(infos[1].Nodes) != 1 {
+		t.Fatalf("service addresses %#v", infos)
+	}
+	s.UnRegisterService(cipher.PubKey{9})
+	if s.Services(cipher.PubKey{9}) != nil {
+		t.Fatal("services after unregister")
+	}
+}
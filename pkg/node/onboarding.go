@@ -0,0 +1,190 @@
+package node
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// written next to the keys of the node, so the summary outlives the first start
+const onboardingFile = "onboarding.json"
+
+// Onboarding summarizes the first start of a node, which generated its keys and default
+// config, registered with the discoveries and set the default apps to start
+type Onboarding struct {
+	Time     int64  `json:"time"`
+	NodeKey  string `json:"node_key"`
+	SeedPath string `json:"seed_path"`
+	// empty if the discoveries were given on the command line
+	ConfPath string `json:"conf_path,omitempty"`
+	// discoveries and whether the node is registered with them
+	Discoveries map[string]bool `json:"discoveries"`
+	// default apps, started once the node api runs
+	Apps []string `json:"apps"`
+	// empty if the node does not connect to a manager
+	Manager string `json:"manager,omitempty"`
+	WebPort string `json:"web_port"`
+	// the code the manager pairs with the node with, shown on the console only
+	PairingCode    string    `json:"-"`
+	PairingExpires time.Time `json:"-"`
+}
+
+// Apps returns the names of the apps the config starts
+func (c AutoStartConfig) Apps() (apps []string) {
+	if c.Sockss {
+		apps = append(apps, "sockss")
+	}
+	if c.Sshs {
+		apps = append(apps, "sshs")
+	}
+	if c.Socksc {
+		apps = append(apps, "socksc")
+	}
+	if c.Sshc {
+		apps = append(apps, "sshc")
+	}
+	return
+}
+
+// Onboard waits up to wait for the node to register with all its discoveries and returns
+// the summary of its first start, which is kept next to the keys
+func (n *Node) Onboard(confPath, manager string, wait time.Duration) (o *Onboarding, err error) {
+	key, err := n.GetNodeKey()
+	if err != nil {
+		return
+	}
+	deadline := time.Now().Add(wait)
+	for !n.registered() && time.Now().Before(deadline) {
+		select {
+		case <-n.closing:
+			err = errors.New("the node closed")
+			return
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	o = &Onboarding{
+		Time:        time.Now().Unix(),
+		NodeKey:     key,
+		SeedPath:    n.seedConfigPath,
+		ConfPath:    confPath,
+		Discoveries: n.discoveryStatus(),
+		Apps:        n.NewAutoStartConfig().Apps(),
+		Manager:     manager,
+		WebPort:     n.webPort,
+	}
+	if len(manager) > 0 {
+		o.PairingCode, o.PairingExpires, err = n.PairingCode()
+		if err != nil {
+			return
+		}
+	}
+	n.onboardingMutex.Lock()
+	n.onboarding = o
+	n.onboardingMutex.Unlock()
+	if n.stateless {
+		return
+	}
+	d, err := json.Marshal(o)
+	if err != nil {
+		return
+	}
+	err = ioutil.WriteFile(filepath.Join(filepath.Dir(n.seedConfigPath), onboardingFile), d, 0600)
+	return
+}
+
+func (n *Node) registered() bool {
+	for _, ok := range n.discoveryStatus() {
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (n *Node) discoveryStatus() map[string]bool {
+	status := make(map[string]bool)
+	n.onDiscoveries.Range(func(key, value interface{}) bool {
+		k, ok := key.(string)
+		if !ok {
+			return true
+		}
+		v, ok := value.(bool)
+		if ok {
+			status[k] = v
+		}
+		return true
+	})
+	return status
+}
+
+// GetOnboarding returns the summary of the first start of the node with the current state
+// of the discoveries, nil if the node was set up before onboarding summaries existed
+func (n *Node) GetOnboarding() *Onboarding {
+	n.onboardingMutex.Lock()
+	o := n.onboarding
+	n.onboardingMutex.Unlock()
+	if o == nil {
+		d, err := ioutil.ReadFile(filepath.Join(filepath.Dir(n.seedConfigPath), onboardingFile))
+		if err != nil {
+			return nil
+		}
+		o = &Onboarding{}
+		if json.Unmarshal(d, o) != nil {
+			return nil
+		}
+	}
+	c := *o
+	c.Discoveries = n.discoveryStatus()
+	c.PairingCode = ""
+	c.PairingExpires = time.Time{}
+	return &c
+}
+
+// Summary returns the summary as text for the console
+func (o *Onboarding) Summary() string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "\nskywire node set up\n\n")
+	fmt.Fprintf(&b, "  public key   %s\n", o.NodeKey)
+	fmt.Fprintf(&b, "  keys         %s (back them up, they are the identity of the node)\n", o.SeedPath)
+	if len(o.ConfPath) > 0 {
+		fmt.Fprintf(&b, "  config       %s\n", o.ConfPath)
+	}
+	addrs := make([]string, 0, len(o.Discoveries))
+	for addr := range o.Discoveries {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	for _, addr := range addrs {
+		status := "registered"
+		if !o.Discoveries[addr] {
+			status = "not reachable yet, retrying"
+		}
+		fmt.Fprintf(&b, "  discovery    %s %s\n", addr, status)
+	}
+	if len(o.Apps) > 0 {
+		fmt.Fprintf(&b, "  apps         %v\n", o.Apps)
+	}
+	if len(o.Manager) > 0 {
+		fmt.Fprintf(&b, "  manager      %s\n", o.Manager)
+		if len(o.PairingCode) > 0 {
+			fmt.Fprintf(&b, "  pairing code %s (enter it in the manager before %s)\n", o.PairingCode, o.PairingExpires.Format("15:04"))
+		}
+	} else {
+		fmt.Fprintf(&b, "  manager      none, start the node with -connect-manager to manage it\n")
+	}
+	fmt.Fprintf(&b, "  node api     %s\n", o.WebPort)
+	fmt.Fprintf(&b, "\nthe summary is kept in %s\n\n", filepath.Join(filepath.Dir(o.SeedPath), onboardingFile))
+	return b.String()
+}
+
+// FirstRun reports if a node with the keys at seedPath starts for the first time
+func FirstRun(seedPath string) bool {
+	_, err := os.Stat(seedPath)
+	return os.IsNotExist(err)
+}
@@ -0,0 +1,96 @@
+package node_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skycoin/skywire/pkg/node"
+	"github.com/skycoin/skywire/pkg/node/nodetest"
+)
+
+func TestOnboarding(t *testing.T) {
+	e := nodetest.NewEnv(t, 1)
+	defer e.Close()
+	d := e.Discoveries[0]
+
+	seedPath := e.Path("node", "keys.json")
+	if !node.FirstRun(seedPath) {
+		t.Fatal("no keys yet, the node should start for the first time")
+	}
+	n := node.New(seedPath, e.Path("node", "autoStart.json"), ":6001")
+	n.SetServices(nodetest.New().Services())
+	if n.GetOnboarding() != nil {
+		t.Fatal("summary before onboarding")
+	}
+	e.Start(n)
+	o, err := n.Onboard("", "", 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !o.Discoveries[d.Address()] || len(o.NodeKey) != 66 || len(o.Apps) != 1 {
+		t.Fatalf("summary %#v", o)
+	}
+	if node.FirstRun(seedPath) {
+		t.Fatal("the keys were not generated")
+	}
+	if _, err := os.Stat(e.Path("node", "onboarding.json")); err != nil {
+		t.Fatal(err)
+	}
+	if got := n.GetOnboarding(); got == nil || got.NodeKey != o.NodeKey {
+		t.Fatalf("summary from the node %#v", got)
+	}
+}
+
+func TestAutoStartApps(t *testing.T) {
+	for _, c := range []struct {
+		config node.AutoStartConfig
+		apps   string
+	}{
+		{node.AutoStartConfig{}, ""},
+		{node.AutoStartConfig{Sockss: true}, "sockss"},
+		{node.AutoStartConfig{Sshs: true, Socksc: true}, "sshs,socksc"},
+		{node.AutoStartConfig{Sockss: true, Sshs: true, Socksc: true, Sshc: true}, "sockss,sshs,socksc,sshc"},
+	} {
+		if apps := strings.Join(c.config.Apps(), ","); apps != c.apps {
+			t.Errorf("%+v: apps %q, want %q", c.config, apps, c.apps)
+		}
+	}
+}
+
+func TestOnboardingSummary(t *testing.T) {
+	base := node.Onboarding{
+		NodeKey:     "key",
+		SeedPath:    filepath.Join("node", "keys.json"),
+		Discoveries: map[string]bool{"b:5999-d": false, "a:5999-d": true},
+		WebPort:     ":6001",
+	}
+	paired := base
+	paired.Manager = "manager:5998"
+	paired.PairingCode = "ABCD-EFGH"
+	paired.PairingExpires = time.Date(2018, 1, 1, 12, 30, 0, 0, time.Local)
+	for _, c := range []struct {
+		name string
+		o    node.Onboarding
+		want []string
+	}{
+		{"without a manager", base, []string{
+			"discovery    a:5999-d registered\n  discovery    b:5999-d not reachable yet, retrying\n",
+			"manager      none, start the node with -connect-manager",
+			"kept in " + filepath.Join("node", "onboarding.json"),
+		}},
+		{"with a manager", paired, []string{
+			"manager      manager:5998\n",
+			"pairing code ABCD-EFGH (enter it in the manager before 12:30)",
+		}},
+	} {
+		s := c.o.Summary()
+		for _, want := range c.want {
+			if !strings.Contains(s, want) {
+				t.Errorf("%s: %q not in\n%s", c.name, want, s)
+			}
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package node
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/skycoin/skycoin/src/cipher"
+	"github.com/skycoin/skywire/pkg/net/util"
+)
+
+const (
+	// written next to the keys of the node
+	pairingFile = "pairing.json"
+	// wrong codes a pairing code survives, a new code is made after that
+	pairingMaxFailures = 5
+)
+
+var (
+	ErrPaired         = errors.New("the node is already paired with a manager")
+	ErrPairingExpired = errors.New("no pairing code, or it expired")
+	ErrPairingCode    = errors.New("wrong pairing code")
+)
+
+// Pairing is the manager the node trusts, the node only connects to the manager with the key
+type Pairing struct {
+	Manager string `json:"manager"`
+	Time    int64  `json:"time"`
+}
+
+type pairingState struct {
+	code     string
+	expires  time.Time
+	failures int
+	// a new code was made, for the console
+	changed chan struct{}
+
+	paired *Pairing
+	loaded bool
+	sync.Mutex
+}
+
+func (n *Node) pairingPath() string {
+	return filepath.Join(filepath.Dir(n.seedConfigPath), pairingFile)
+}
+
+// called with the lock held
+func (n *Node) loadPairing() {
+	if n.pairing.loaded {
+		return
+	}
+	n.pairing.loaded = true
+	d, err := ioutil.ReadFile(n.pairingPath())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Errorf("read pairing: %v", err)
+		}
+		return
+	}
+	p := &Pairing{}
+	err = json.Unmarshal(d, p)
+	if err != nil {
+		log.Errorf("read pairing: %v", err)
+		return
+	}
+	n.pairing.paired = p
+}
+
+// GetPairing returns the manager the node is paired with, nil if it is not paired
+func (n *Node) GetPairing() *Pairing {
+	n.pairing.Lock()
+	defer n.pairing.Unlock()
+	n.loadPairing()
+	if n.pairing.paired == nil {
+		return nil
+	}
+	p := *n.pairing.paired
+	return &p
+}
+
+// pairedManager returns the key of the manager the node is paired with
+func (n *Node) pairedManager() (key cipher.PubKey, ok bool) {
+	p := n.GetPairing()
+	if p == nil {
+		return
+	}
+	key, err := cipher.PubKeyFromHex(p.Manager)
+	if err != nil {
+		log.Errorf("paired manager key %s: %v", p.Manager, err)
+		return
+	}
+	ok = true
+	return
+}
+
+// PairingCode returns the code a manager pairs with the node with until expires, a new code
+// once the last one expired, an empty code if the node is paired
+func (n *Node) PairingCode() (code string, expires time.Time, err error) {
+	n.pairing.Lock()
+	defer n.pairing.Unlock()
+	n.loadPairing()
+	if n.pairing.paired != nil {
+		return
+	}
+	if len(n.pairing.code) == 0 || time.Now().After(n.pairing.expires) {
+		err = n.newPairingCode()
+		if err != nil {
+			return
+		}
+	}
+	code, expires = n.pairing.code, n.pairing.expires
+	return
+}
+
+// called with the lock held
+func (n *Node) newPairingCode() (err error) {
+	code, err := util.NewPairingCode()
+	if err != nil {
+		return
+	}
+	n.pairing.code = code
+	n.pairing.expires = time.Now().Add(util.PairingCodeTTL)
+	n.pairing.failures = 0
+	if n.pairing.changed != nil {
+		close(n.pairing.changed)
+		n.pairing.changed = nil
+	}
+	return
+}
+
+// Pair trusts the manager if it signed the current code, the node answers with its own
+// signature so the manager trusts the node
+func (n *Node) Pair(code string, manager cipher.PubKey, sig cipher.Sig) (nodeSig cipher.Sig, err error) {
+	sc := n.apps.GetDefaultSeedConfig()
+	if sc == nil {
+		err = errors.New("the node has no keys")
+		return
+	}
+	n.pairing.Lock()
+	defer n.pairing.Unlock()
+	n.loadPairing()
+	if n.pairing.paired != nil {
+		err = ErrPaired
+		return
+	}
+	if len(n.pairing.code) == 0 || time.Now().After(n.pairing.expires) {
+		err = ErrPairingExpired
+		return
+	}
+	code = util.NormalizePairingCode(code)
+	if !util.ConstantTimeEqualString(code, n.pairing.code) ||
+		util.VerifyPairing(manager, sig, util.PairingManager, code, sc.PublicKey) != nil {
+		n.pairing.failures++
+		if n.pairing.failures >= pairingMaxFailures {
+			log.Warnf("pairing: %d wrong codes, making a new code", n.pairing.failures)
+			err = n.newPairingCode()
+			if err != nil {
+				return
+			}
+		}
+		err = ErrPairingCode
+		return
+	}
+	sk, err := cipher.SecKeyFromHex(sc.SecKey)
+	if err != nil {
+		return
+	}
+	defer util.WipeSecKey(&sk)
+	p := &Pairing{Manager: manager.Hex(), Time: time.Now().Unix()}
+	if !n.stateless {
+		var d []byte
+		d, err = json.Marshal(p)
+		if err != nil {
+			return
+		}
+		err = ioutil.WriteFile(n.pairingPath(), d, 0600)
+		if err != nil {
+			return
+		}
+	}
+	n.pairing.paired = p
+	n.pairing.code = ""
+	if n.pairing.changed != nil {
+		close(n.pairing.changed)
+		n.pairing.changed = nil
+	}
+	nodeSig = util.SignPairing(sk, util.PairingNode, code, manager.Hex())
+	log.Infof("paired with manager %s", p.Manager)
+	return
+}
+
+// StartPairingCodes calls show with every new pairing code until the node is paired or closed
+func (n *Node) StartPairingCodes(show func(code string, expires time.Time)) {
+	go func() {
+		for {
+			code, expires, err := n.PairingCode()
+			if err != nil {
+				log.Errorf("pairing code: %v", err)
+				return
+			}
+			if len(code) == 0 {
+				return
+			}
+			show(code, expires)
+			n.pairing.Lock()
+			if n.pairing.changed == nil {
+				n.pairing.changed = make(chan struct{})
+			}
+			changed := n.pairing.changed
+			n.pairing.Unlock()
+			select {
+			case <-n.closing:
+				return
+			case <-changed:
+			case <-time.After(time.Until(expires)):
+			}
+		}
+	}()
+}
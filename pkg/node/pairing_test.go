@@ -0,0 +1,89 @@
+package node_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/skycoin/skycoin/src/cipher"
+	"github.com/skycoin/skywire/pkg/net/util"
+	"github.com/skycoin/skywire/pkg/node"
+	"github.com/skycoin/skywire/pkg/node/nodetest"
+)
+
+func TestPairing(t *testing.T) {
+	e := nodetest.NewEnv(t, 0)
+	defer e.Close()
+	n := e.NewNode("node")
+	defer n.Close()
+	nodeKey, err := n.GetNodeKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager, sk := cipher.GenerateKeyPair()
+
+	code, _, err := n.PairingCode()
+	if err != nil || len(code) == 0 {
+		t.Fatalf("code %q: %v", code, err)
+	}
+	other, err := util.NewPairingCode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == code {
+		other = "XXXX-XXXX"
+	}
+	_, otherSK := cipher.GenerateKeyPair()
+	for _, c := range []struct {
+		name string
+		code string
+		sig  cipher.Sig
+	}{
+		{"another code", other, util.SignPairing(sk, util.PairingManager, other, nodeKey)},
+		{"signed for another node", code, util.SignPairing(sk, util.PairingManager, code, manager.Hex())},
+		{"signed as the node", code, util.SignPairing(sk, util.PairingNode, code, nodeKey)},
+		{"signed by another manager", code, util.SignPairing(otherSK, util.PairingManager, code, nodeKey)},
+	} {
+		if _, err = n.Pair(c.code, manager, c.sig); err != node.ErrPairingCode {
+			t.Fatalf("pair with %s: %v", c.name, err)
+		}
+	}
+
+	sig, err := n.Pair(code, manager, util.SignPairing(sk, util.PairingManager, code, nodeKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, err := cipher.PubKeyFromHex(nodeKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := util.VerifyPairing(pk, sig, util.PairingNode, code, manager.Hex()); err != nil {
+		t.Fatalf("signature of the node: %v", err)
+	}
+	if p := n.GetPairing(); p == nil || p.Manager != manager.Hex() {
+		t.Fatalf("pairing %#v", p)
+	}
+	if code, _, _ := n.PairingCode(); len(code) > 0 {
+		t.Fatal("code of a paired node")
+	}
+	if _, err := os.Stat(e.Path("node", "pairing.json")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPairingCodeRenewed(t *testing.T) {
+	e := nodetest.NewEnv(t, 0)
+	defer e.Close()
+	n := e.NewNode("node")
+	defer n.Close()
+	manager, sk := cipher.GenerateKeyPair()
+	code, _, err := n.PairingCode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		n.Pair("XXXX-XXXX", manager, util.SignPairing(sk, util.PairingManager, "XXXX-XXXX", ""))
+	}
+	if renewed, _, _ := n.PairingCode(); renewed == code {
+		t.Fatal("the code survived 5 wrong codes")
+	}
+}
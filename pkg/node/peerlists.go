@@ -0,0 +1,80 @@
+package node
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/skycoin/skywire/pkg/net/skycoin-messenger/factory"
+)
+
+// SetPeerListsPath loads the peer lists last pushed by the manager from the json file at path
+// and keeps the next ones there, in memory only if path is empty
+func (n *Node) SetPeerListsPath(path string) (err error) {
+	n.peerListsMutex.Lock()
+	defer n.peerListsMutex.Unlock()
+	n.peerListsPath = path
+	if len(path) < 1 {
+		return
+	}
+	d, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	var l factory.PeerLists
+	err = json.Unmarshal(d, &l)
+	if err != nil {
+		return
+	}
+	return n.apps.SetPeerLists(l)
+}
+
+// GetPeerLists returns the peer lists applied to the transports of the apps
+func (n *Node) GetPeerLists() factory.PeerLists {
+	return n.apps.GetPeerLists()
+}
+
+// ApplyPeerLists applies the peer lists pushed by the manager, lists older than the applied
+// ones are refused so a manager that is behind does not undo a change
+func (n *Node) ApplyPeerLists(l factory.PeerLists) (err error) {
+	n.peerListsMutex.Lock()
+	defer n.peerListsMutex.Unlock()
+	current := n.apps.GetPeerLists()
+	if l.Version < current.Version {
+		return fmt.Errorf("peer lists version %d is older than the applied version %d", l.Version, current.Version)
+	}
+	if l.Version == current.Version && current.Version > 0 {
+		return
+	}
+	err = l.Check()
+	if err != nil {
+		return
+	}
+	if len(n.peerListsPath) > 0 {
+		var d []byte
+		d, err = json.Marshal(l)
+		if err != nil {
+			return
+		}
+		err = os.MkdirAll(filepath.Dir(n.peerListsPath), 0700)
+		if err != nil {
+			return
+		}
+		err = ioutil.WriteFile(n.peerListsPath, d, 0600)
+		if err != nil {
+			return
+		}
+	}
+	err = n.apps.SetPeerLists(l)
+	if err != nil {
+		return
+	}
+	log.Infof("peer lists version %d applied, %d allowed, %d denied", l.Version, len(l.Allow), len(l.Deny))
+	return
+}
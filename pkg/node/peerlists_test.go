@@ -0,0 +1,66 @@
+package node_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/skycoin/skywire/pkg/net/skycoin-messenger/factory"
+	"github.com/skycoin/skywire/pkg/node/nodetest"
+)
+
+func TestPeerLists(t *testing.T) {
+	e := nodetest.NewEnv(t, 1)
+	defer e.Close()
+	a, b := e.StartNode("a"), e.StartNode("b")
+	server := e.ConnectApp(b, "server")
+	server.Offer(e.Echo(), "echo")
+	client := e.ConnectApp(a, "client")
+	connect := func() factory.AppConnResp {
+		return client.Dial(b.Key, server.GetKey(), e.DiscoveryKey(0))
+	}
+	transports := func(n *nodetest.Node) int {
+		return len(n.GetNodeInfo().Transports)
+	}
+	if resp := connect(); resp.Failed {
+		t.Fatalf("transport before the lists %#v", resp)
+	}
+	nodetest.WaitFor(t, "the transport", func() bool { return transports(a) == 1 && transports(b) == 1 })
+
+	// the deny list closes the open transport and refuses the next ones
+	if err := a.ApplyPeerLists(factory.PeerLists{Version: 1}); err != nil {
+		t.Fatal(err)
+	}
+	deny := factory.PeerLists{Version: 2, Deny: []string{b.Key.Hex()}}
+	if err := a.ApplyPeerLists(deny); err != nil {
+		t.Fatal(err)
+	}
+	nodetest.WaitFor(t, "the denied transport closed", func() bool { return transports(a) == 0 && transports(b) == 0 })
+	if resp := connect(); !resp.Failed || !strings.Contains(resp.Msg.Msg, "peer lists") {
+		t.Fatalf("transport to a denied node %#v", resp)
+	}
+	// the lists of version 1 pushed again by a manager behind are refused
+	if err := a.ApplyPeerLists(factory.PeerLists{Version: 1}); err == nil {
+		t.Fatal("older lists applied")
+	}
+	if l := a.GetPeerLists(); l.Version != 2 || len(l.Deny) != 1 {
+		t.Fatalf("lists after the older ones %#v", l)
+	}
+
+	// a rollback to version 1 is pushed as version 3, it restores the transports
+	if err := a.ApplyPeerLists(factory.PeerLists{Version: 3}); err != nil {
+		t.Fatal(err)
+	}
+	if resp := connect(); resp.Failed {
+		t.Fatalf("transport after the rollback %#v", resp)
+	}
+	nodetest.WaitFor(t, "the restored transport", func() bool { return transports(a) == 1 && transports(b) == 1 })
+
+	// the node of the app refuses the transports of the nodes it denies too
+	if err := b.ApplyPeerLists(factory.PeerLists{Version: 1, Deny: []string{a.Key.Hex()}}); err != nil {
+		t.Fatal(err)
+	}
+	nodetest.WaitFor(t, "the transport closed by the node of the app", func() bool { return transports(a) == 0 && transports(b) == 0 })
+	if resp := connect(); !resp.Failed {
+		t.Fatalf("transport from a denied node %#v", resp)
+	}
+}
@@ -0,0 +1,84 @@
+package node
+
+import (
+	"net"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/skycoin/skywire/pkg/net/portmap"
+	"github.com/skycoin/skywire/pkg/net/skycoin-messenger/factory"
+)
+
+// gateway discovery is retried this often while no gateway supports port mapping
+const portMapRetryInterval = 10 * time.Minute
+
+// StartPortMapping maps the tcp listen port of the node on the gateway with NAT-PMP, PCP or UPnP
+// and announces the external address to the discoveries, the mapping is renewed until the node is closed
+func (n *Node) StartPortMapping() {
+	_, p, err := net.SplitHostPort(n.lnAddr)
+	if err != nil {
+		log.Errorf("port mapping: invalid listen address %s", n.lnAddr)
+		return
+	}
+	port, err := strconv.Atoi(p)
+	if err != nil || port == 0 {
+		log.Errorf("port mapping: invalid listen port %s", p)
+		return
+	}
+	n.supervise(PortMappingSubsystem, func() {
+		for {
+			if n.mapPort(port) {
+				return
+			}
+			select {
+			case <-n.closing:
+				return
+			case <-time.After(portMapRetryInterval):
+			}
+		}
+	})
+}
+
+func (n *Node) mapPort(port int) (ok bool) {
+	m, err := n.services.Gateway.Discover()
+	if err != nil {
+		log.Debugf("port mapping: %v", err)
+		return
+	}
+	mp, err := portmap.Map(m, portmap.TCP, port, n.setExternalAddress)
+	if err != nil {
+		log.Errorf("port mapping with %s err: %v", m.Name(), err)
+		return
+	}
+	log.Infof("port mapping with %s: %d => %s", m.Name(), port, mp.External())
+	n.portMappingMutex.Lock()
+	n.portMapping = mp
+	n.portMappingMutex.Unlock()
+	go func() {
+		<-n.closing
+		err := mp.Close()
+		if err != nil {
+			log.Debugf("delete port mapping err: %v", err)
+		}
+	}()
+	return true
+}
+
+func (n *Node) setExternalAddress(addr string) {
+	n.apps.SetExternalAddress(addr)
+	n.apps.ForEachConn(func(c *factory.Connection) {
+		n.apps.ResyncToDiscovery(c)
+	})
+}
+
+// GetPortMapping returns the status of the port mapping, nil when the port is not mapped
+func (n *Node) GetPortMapping() *portmap.Status {
+	n.portMappingMutex.RLock()
+	defer n.portMappingMutex.RUnlock()
+	if n.portMapping == nil {
+		return nil
+	}
+	s := n.portMapping.Status()
+	return &s
+}
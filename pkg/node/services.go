@@ -0,0 +1,61 @@
+package node
+
+import (
+	"github.com/skycoin/skywire/pkg/net/nat"
+	"github.com/skycoin/skywire/pkg/net/ntp"
+	"github.com/skycoin/skywire/pkg/net/portmap"
+)
+
+// NATDetector detects the nat type with the stun servers
+type NATDetector interface {
+	Detect(servers []string) *nat.Result
+}
+
+// ClockChecker measures the clock offset with the ntp servers
+type ClockChecker interface {
+	Check(servers []string) *ntp.Result
+}
+
+// GatewayDiscoverer finds the gateway that maps the listen port
+type GatewayDiscoverer interface {
+	Discover() (portmap.Mapper, error)
+}
+
+// Services are the clients of the outside services the node uses, a nil client uses the network
+type Services struct {
+	NAT     NATDetector
+	Clock   ClockChecker
+	Gateway GatewayDiscoverer
+}
+
+type netNAT struct{}
+
+func (netNAT) Detect(servers []string) *nat.Result {
+	return nat.Detect(servers)
+}
+
+type netClock struct{}
+
+func (netClock) Check(servers []string) *ntp.Result {
+	return ntp.Check(servers)
+}
+
+type netGateway struct{}
+
+func (netGateway) Discover() (portmap.Mapper, error) {
+	return portmap.Discover()
+}
+
+// SetServices replaces the clients of the outside services, call it before the node starts
+func (n *Node) SetServices(s Services) {
+	if s.NAT == nil {
+		s.NAT = netNAT{}
+	}
+	if s.Clock == nil {
+		s.Clock = netClock{}
+	}
+	if s.Gateway == nil {
+		s.Gateway = netGateway{}
+	}
+	n.services = s
+}
@@ -0,0 +1,45 @@
+package node
+
+import (
+	"time"
+
+	"github.com/skycoin/skycoin/src/cipher"
+	"github.com/skycoin/skywire/pkg/net/skycoin-messenger/factory"
+)
+
+// PublicStatus is the health of the node anyone may see, it tells nothing of the other nodes
+// and the apps
+type PublicStatus struct {
+	Version string `json:"version"`
+	Key     string `json:"key"`
+	// seconds since the node started
+	Uptime int64 `json:"uptime"`
+	// transports of the apps open, the standby ones left out
+	Transports int `json:"transports"`
+	// bytes per second of the transports open
+	UploadBW   uint64 `json:"upload_bandwidth"`
+	DownloadBW uint64 `json:"download_bandwidth"`
+	// bytes the transports open carried
+	UploadTotal   uint64 `json:"upload_total"`
+	DownloadTotal uint64 `json:"download_total"`
+}
+
+// GetPublicStatus returns the version, key, uptime and the sums of the transports of the node
+func (n *Node) GetPublicStatus() (s PublicStatus) {
+	s.Version = Version
+	s.Key, _ = n.GetNodeKey()
+	s.Uptime = int64(time.Since(processTime) / time.Second)
+	n.apps.ForEachAcceptedConnection(func(key cipher.PubKey, conn *factory.Connection) {
+		conn.ForEachTransport(func(t *factory.Transport) {
+			if t.IsStandby() {
+				return
+			}
+			s.Transports++
+			s.UploadBW += uint64(t.GetUploadBandwidth())
+			s.DownloadBW += uint64(t.GetDownloadBandwidth())
+			s.UploadTotal += uint64(t.GetUploadTotal())
+			s.DownloadTotal += uint64(t.GetDownloadTotal())
+		})
+	})
+	return
+}
@@ -0,0 +1,335 @@
+package node
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// a stage of subsystems without a timeout of their own waits this long to start or stop
+const DefaultStageTimeout = 30 * time.Second
+
+// Subsystem is a part of the node started after the subsystems it requires and stopped
+// before them
+type Subsystem struct {
+	Name string
+	// subsystems that must have started, it is skipped if one did not
+	Requires []string
+	// subsystems it starts after if they are added, started or not
+	After []string
+	// nil if the subsystem has nothing to start or stop, the ones stopped with the node
+	// have no Stop
+	Start func() error
+	Stop  func()
+	// of its start and stop, DefaultStageTimeout if 0
+	Timeout time.Duration
+}
+
+func (s *Subsystem) timeout() time.Duration {
+	if s.Timeout > 0 {
+		return s.Timeout
+	}
+	return DefaultStageTimeout
+}
+
+const (
+	SubsystemPending = "pending"
+	SubsystemStarted = "started"
+	SubsystemFailed  = "failed"
+	// the start did not return in time, the subsystem may still come up
+	SubsystemTimeout = "timeout"
+	// a subsystem it requires did not start
+	SubsystemSkipped = "skipped"
+	SubsystemStopped = "stopped"
+)
+
+type SubsystemStatus struct {
+	Name  string `json:"name"`
+	State string `json:"state"`
+	Error string `json:"error,omitempty"`
+	// subsystems of a stage start together once the stages before it started
+	Stage int   `json:"stage"`
+	Took  int64 `json:"took_ms"`
+	// times the subsystem was restarted after a panic and the last panic
+	Restarts int    `json:"restarts,omitempty"`
+	Panic    string `json:"panic,omitempty"`
+}
+
+// PartialStartError names the subsystems that did not start, the others run
+type PartialStartError struct {
+	Failed  []string
+	Skipped []string
+}
+
+func (e *PartialStartError) Error() string {
+	msg := fmt.Sprintf("subsystems not started: %s", strings.Join(e.Failed, ", "))
+	if len(e.Skipped) > 0 {
+		msg += fmt.Sprintf(", skipped: %s", strings.Join(e.Skipped, ", "))
+	}
+	return msg
+}
+
+type subsystems struct {
+	list   []*Subsystem
+	stages [][]*Subsystem
+	status map[string]*SubsystemStatus
+	sync.Mutex
+}
+
+// AddSubsystem registers a subsystem started by StartSubsystems
+func (n *Node) AddSubsystem(s Subsystem) {
+	n.subsystems.Lock()
+	n.subsystems.list = append(n.subsystems.list, &s)
+	n.subsystems.Unlock()
+}
+
+// stagesOf orders the subsystems in stages, each one after the stages of the subsystems it
+// requires
+func stagesOf(list []*Subsystem) (stages [][]*Subsystem, err error) {
+	byName := make(map[string]*Subsystem, len(list))
+	for _, s := range list {
+		if _, ok := byName[s.Name]; ok {
+			err = fmt.Errorf("subsystem %s added twice", s.Name)
+			return
+		}
+		byName[s.Name] = s
+	}
+	for _, s := range list {
+		for _, r := range s.Requires {
+			if _, ok := byName[r]; !ok {
+				err = fmt.Errorf("subsystem %s requires unknown subsystem %s", s.Name, r)
+				return
+			}
+		}
+	}
+	staged := make(map[string]bool, len(list))
+	for len(staged) < len(list) {
+		var stage []*Subsystem
+		for _, s := range list {
+			if staged[s.Name] {
+				continue
+			}
+			ready := true
+			for _, r := range s.Requires {
+				if !staged[r] {
+					ready = false
+					break
+				}
+			}
+			for _, r := range s.After {
+				if _, ok := byName[r]; ok && !staged[r] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				stage = append(stage, s)
+			}
+		}
+		if len(stage) == 0 {
+			var left []string
+			for _, s := range list {
+				if !staged[s.Name] {
+					left = append(left, s.Name)
+				}
+			}
+			err = fmt.Errorf("subsystems %s require each other", strings.Join(left, ", "))
+			return
+		}
+		for _, s := range stage {
+			staged[s.Name] = true
+		}
+		stages = append(stages, stage)
+	}
+	return
+}
+
+// StartSubsystems starts the subsystems stage by stage. A subsystem that fails or does not
+// start in time is reported and the ones requiring it are skipped, the others start anyway,
+// so the error is a *PartialStartError unless the subsystems do not form a graph
+func (n *Node) StartSubsystems() (err error) {
+	m := &n.subsystems
+	m.Lock()
+	stages, err := stagesOf(m.list)
+	if err != nil {
+		m.Unlock()
+		return
+	}
+	m.stages = stages
+	m.status = make(map[string]*SubsystemStatus, len(m.list))
+	for i, stage := range stages {
+		for _, s := range stage {
+			m.status[s.Name] = &SubsystemStatus{Name: s.Name, State: SubsystemPending, Stage: i}
+		}
+	}
+	m.Unlock()
+
+	partial := &PartialStartError{}
+	for i, stage := range stages {
+		var wg sync.WaitGroup
+		for _, s := range stage {
+			if missing := m.missing(s); len(missing) > 0 {
+				m.set(s.Name, SubsystemSkipped, fmt.Sprintf("requires %s", strings.Join(missing, ", ")), 0)
+				partial.Skipped = append(partial.Skipped, s.Name)
+				continue
+			}
+			wg.Add(1)
+			go func(s *Subsystem) {
+				defer wg.Done()
+				m.start(s)
+			}(s)
+		}
+		wg.Wait()
+		for _, s := range stage {
+			if st := m.get(s.Name); st.State == SubsystemFailed || st.State == SubsystemTimeout {
+				partial.Failed = append(partial.Failed, s.Name)
+			}
+		}
+		log.Debugf("subsystems stage %d started", i)
+	}
+	if len(partial.Failed) > 0 || len(partial.Skipped) > 0 {
+		err = partial
+	}
+	return
+}
+
+func (m *subsystems) start(s *Subsystem) {
+	if s.Start == nil {
+		m.set(s.Name, SubsystemStarted, "", 0)
+		return
+	}
+	begin := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		var err error
+		report, panicked := runRecovered(func() {
+			err = s.Start()
+		})
+		if panicked {
+			log.Errorf("subsystem %s panicked on start: %s", s.Name, report)
+			err = fmt.Errorf("panic: %s", strings.SplitN(report, "\n", 2)[0])
+		}
+		done <- err
+	}()
+	timer := time.NewTimer(s.timeout())
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		took := time.Since(begin)
+		if err != nil {
+			log.Errorf("subsystem %s: %v", s.Name, err)
+			m.set(s.Name, SubsystemFailed, err.Error(), took)
+			return
+		}
+		log.Infof("subsystem %s started in %s", s.Name, took)
+		m.set(s.Name, SubsystemStarted, "", took)
+	case <-timer.C:
+		log.Errorf("subsystem %s did not start in %s", s.Name, s.timeout())
+		m.set(s.Name, SubsystemTimeout, fmt.Sprintf("not started in %s", s.timeout()), s.timeout())
+	}
+}
+
+// missing returns the subsystems s requires that did not start
+func (m *subsystems) missing(s *Subsystem) (missing []string) {
+	for _, r := range s.Requires {
+		if m.get(r).State != SubsystemStarted {
+			missing = append(missing, r)
+		}
+	}
+	return
+}
+
+func (m *subsystems) get(name string) (s SubsystemStatus) {
+	m.Lock()
+	if v, ok := m.status[name]; ok {
+		s = *v
+	}
+	m.Unlock()
+	return
+}
+
+func (m *subsystems) set(name, state, msg string, took time.Duration) {
+	m.Lock()
+	if v, ok := m.status[name]; ok {
+		v.State = state
+		v.Error = msg
+		if took > 0 {
+			v.Took = int64(took / time.Millisecond)
+		}
+	}
+	m.Unlock()
+}
+
+// StopSubsystems stops the subsystems in the reverse order of their stages, each stage waits
+// for its subsystems up to their timeouts. Every subsystem that was started is stopped, a
+// failed start may have left a part of it running
+func (n *Node) StopSubsystems() {
+	m := &n.subsystems
+	m.Lock()
+	stages := m.stages
+	m.stages = nil
+	m.Unlock()
+	for i := len(stages) - 1; i >= 0; i-- {
+		var wg sync.WaitGroup
+		for _, s := range stages[i] {
+			switch m.get(s.Name).State {
+			case SubsystemPending, SubsystemSkipped, SubsystemStopped:
+				continue
+			}
+			if s.Stop == nil {
+				m.set(s.Name, SubsystemStopped, "", 0)
+				continue
+			}
+			wg.Add(1)
+			go func(s *Subsystem) {
+				defer wg.Done()
+				m.stop(s)
+			}(s)
+		}
+		wg.Wait()
+	}
+}
+
+func (m *subsystems) stop(s *Subsystem) {
+	done := make(chan struct{})
+	go func() {
+		if report, panicked := runRecovered(s.Stop); panicked {
+			log.Errorf("subsystem %s panicked on stop: %s", s.Name, report)
+		}
+		close(done)
+	}()
+	timer := time.NewTimer(s.timeout())
+	defer timer.Stop()
+	select {
+	case <-done:
+		m.set(s.Name, SubsystemStopped, "", 0)
+	case <-timer.C:
+		log.Errorf("subsystem %s did not stop in %s", s.Name, s.timeout())
+		m.set(s.Name, SubsystemTimeout, fmt.Sprintf("not stopped in %s", s.timeout()), 0)
+	}
+}
+
+// GetSubsystems returns the subsystems by stage, nil before they were started
+func (n *Node) GetSubsystems() []SubsystemStatus {
+	m := &n.subsystems
+	m.Lock()
+	defer m.Unlock()
+	if len(m.status) == 0 {
+		return nil
+	}
+	list := make([]SubsystemStatus, 0, len(m.status))
+	for _, s := range m.status {
+		list = append(list, *s)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Stage != list[j].Stage {
+			return list[i].Stage < list[j].Stage
+		}
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
@@ -0,0 +1,203 @@
+package node_test
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/skycoin/skywire/pkg/node"
+	"github.com/skycoin/skywire/pkg/node/nodetest"
+)
+
+// subsystem is the spec of a subsystem of the tests, it fails to start with err
+type subsystem struct {
+	name            string
+	requires, after []string
+	err             error
+}
+
+func TestSubsystemStages(t *testing.T) {
+	e := nodetest.NewEnv(t, 0)
+	defer e.Close()
+	for _, c := range []struct {
+		name       string
+		subsystems []subsystem
+		// name:state:stage of the subsystems by stage, empty if they do not start
+		states          string
+		failed, skipped string
+		notGraph        bool
+	}{
+		{name: "chain", subsystems: []subsystem{
+			{name: "apps", requires: []string{"transports"}},
+			{name: "transports", requires: []string{"discovery"}},
+			{name: "discovery"},
+		}, states: "discovery:started:0,transports:started:1,apps:started:2"},
+		{name: "failed requirement", subsystems: []subsystem{
+			{name: "discovery"},
+			{name: "router", requires: []string{"discovery"}, err: errors.New("no routes")},
+			{name: "proxy", requires: []string{"router"}},
+			{name: "apps", requires: []string{"discovery"}},
+		}, states: "discovery:started:0,apps:started:1,router:failed:1,proxy:skipped:2", failed: "router", skipped: "proxy"},
+		// after orders the start without requiring, an unknown one is ignored
+		{name: "after", subsystems: []subsystem{
+			{name: "router", err: errors.New("no routes")},
+			{name: "manager", after: []string{"router", "unknown"}},
+		}, states: "router:failed:0,manager:started:1", failed: "router"},
+		{name: "unknown requirement", subsystems: []subsystem{{name: "apps", requires: []string{"unknown"}}}, notGraph: true},
+		{name: "added twice", subsystems: []subsystem{{name: "apps"}, {name: "apps"}}, notGraph: true},
+		{name: "requiring each other", subsystems: []subsystem{
+			{name: "a", requires: []string{"b"}},
+			{name: "b", after: []string{"a"}},
+		}, notGraph: true},
+	} {
+		n := node.New(e.Path("keys.json"), e.Path("autoStart.json"), "")
+		for _, s := range c.subsystems {
+			err := s.err
+			n.AddSubsystem(node.Subsystem{Name: s.name, Requires: s.requires, After: s.after, Start: func() error { return err }})
+		}
+		err := n.StartSubsystems()
+		if c.notGraph {
+			if _, ok := err.(*node.PartialStartError); ok || err == nil || n.GetSubsystems() != nil {
+				t.Errorf("%s: started %v", c.name, err)
+			}
+			continue
+		}
+		var states []string
+		for _, s := range n.GetSubsystems() {
+			states = append(states, fmt.Sprintf("%s:%s:%d", s.Name, s.State, s.Stage))
+		}
+		if strings.Join(states, ",") != c.states {
+			t.Errorf("%s: states %v, want %s", c.name, states, c.states)
+		}
+		var failed, skipped string
+		if partial, ok := err.(*node.PartialStartError); ok {
+			failed, skipped = strings.Join(partial.Failed, ","), strings.Join(partial.Skipped, ",")
+		} else if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+		}
+		if failed != c.failed || skipped != c.skipped {
+			t.Errorf("%s: failed %q, skipped %q", c.name, failed, skipped)
+		}
+	}
+}
+
+func TestSubsystems(t *testing.T) {
+	e := nodetest.NewEnv(t, 0)
+	defer e.Close()
+	n := node.New(e.Path("keys.json"), e.Path("autoStart.json"), "")
+	var order []string
+	var mutex sync.Mutex
+	record := func(what string) {
+		mutex.Lock()
+		order = append(order, what)
+		mutex.Unlock()
+	}
+	add := func(name string, requires []string, err error) {
+		n.AddSubsystem(node.Subsystem{
+			Name:     name,
+			Requires: requires,
+			Start: func() error {
+				record("start " + name)
+				return err
+			},
+			Stop: func() {
+				record("stop " + name)
+			},
+		})
+	}
+	add("apps", []string{"transports"}, nil)
+	add("transports", []string{"discovery"}, nil)
+	add("discovery", nil, nil)
+	add("router", []string{"transports"}, errors.New("no routes"))
+	add("proxy", []string{"router"}, nil)
+	n.AddSubsystem(node.Subsystem{
+		Name:     "slow",
+		Requires: []string{"discovery"},
+		Timeout:  100 * time.Millisecond,
+		Start: func() error {
+			time.Sleep(time.Second)
+			return nil
+		},
+	})
+
+	err := n.StartSubsystems()
+	partial, ok := err.(*node.PartialStartError)
+	if !ok || strings.Join(partial.Failed, ",") != "slow,router" || strings.Join(partial.Skipped, ",") != "proxy" {
+		t.Fatalf("start %v", err)
+	}
+	for _, s := range n.GetNodeInfo().Subsystems {
+		if s.Name == "slow" && s.State != node.SubsystemTimeout {
+			t.Fatalf("slow %#v", s)
+		}
+	}
+
+	n.StopSubsystems()
+	mutex.Lock()
+	got := strings.Join(order, ",")
+	mutex.Unlock()
+	// the subsystems of a stage start and stop together, the failed ones are stopped too
+	if !strings.HasPrefix(got, "start discovery,start transports,") ||
+		!strings.HasSuffix(got, "stop transports,stop discovery") ||
+		strings.Contains(got, "proxy") || !strings.Contains(got, "stop router") ||
+		strings.Index(got, "stop apps") > strings.Index(got, "stop transports") {
+		t.Fatalf("order %s", got)
+	}
+	for _, s := range n.GetSubsystems() {
+		if want := node.SubsystemStopped; s.Name != "proxy" && s.State != want {
+			t.Fatalf("%s %#v, want %s", s.Name, s, want)
+		}
+	}
+}
+
+func TestSubsystemPanics(t *testing.T) {
+	e := nodetest.NewEnv(t, 0)
+	defer e.Close()
+	fakes := nodetest.New()
+	fakes.Clock.PanicNext(2)
+	n := node.New(e.Path("keys.json"), e.Path("autoStart.json"), "")
+	n.SetServices(fakes.Services())
+	n.AddSubsystem(node.Subsystem{Name: node.NodeSubsystem, Stop: n.Close})
+	n.AddSubsystem(node.Subsystem{
+		Name:     node.ClockSubsystem,
+		Requires: []string{node.NodeSubsystem},
+		Start: func() error {
+			n.StartClockCheck(nil, time.Second)
+			return nil
+		},
+	})
+	n.AddSubsystem(node.Subsystem{
+		Name:     "broken",
+		Requires: []string{node.NodeSubsystem},
+		Start: func() error {
+			var m map[string]int
+			m["start"]++
+			return nil
+		},
+	})
+	err := n.StartSubsystems()
+	if partial, ok := err.(*node.PartialStartError); !ok || strings.Join(partial.Failed, ",") != "broken" {
+		t.Fatalf("start %v", err)
+	}
+	defer n.StopSubsystems()
+	status := func(name string) (s node.SubsystemStatus) {
+		for _, s = range n.GetNodeInfo().Subsystems {
+			if s.Name == name {
+				return
+			}
+		}
+		return node.SubsystemStatus{}
+	}
+	if s := status("broken"); !strings.HasPrefix(s.Error, "panic: assignment to entry in nil map") {
+		t.Fatalf("broken %#v", s)
+	}
+	// the clock check is started again after each panic
+	nodetest.WaitFor(t, "the clock checked", func() bool {
+		return n.GetClock() != nil
+	})
+	if s := status(node.ClockSubsystem); s.State != node.SubsystemStarted || s.Restarts != 2 || s.Panic == "" {
+		t.Fatalf("clock %#v", s)
+	}
+}
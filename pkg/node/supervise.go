@@ -0,0 +1,105 @@
+package node
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/skycoin/skywire/pkg/net/skycoin-messenger/factory"
+)
+
+// names of the subsystems of the node, the loops the node supervises report their panics
+// and restarts to the subsystem added with their name
+const (
+	NodeSubsystem        = "node"
+	DiscoverySubsystem   = "discovery"
+	NATSubsystem         = "nat"
+	PortMappingSubsystem = "port_mapping"
+	WatchdogSubsystem    = "watchdog"
+	ClockSubsystem       = "clock"
+	AccountingSubsystem  = "accounting"
+	AppSocketSubsystem   = "app_socket"
+	LocalApiSubsystem    = "local_api"
+	StatusPageSubsystem  = "status_page"
+	BandwidthSubsystem   = "bandwidth_test"
+	ManagerSubsystem     = "manager"
+)
+
+const (
+	// a subsystem panicking more often within subsystemRestartWindow stays down
+	maxSubsystemRestarts   = 3
+	subsystemRestartWindow = 10 * time.Minute
+	// doubled with each restart within the window
+	subsystemRestartWait = time.Second
+)
+
+// supervise runs the loop of a subsystem in a goroutine, a panic is logged with the stack
+// and the loop started again instead of taking down the node
+func (n *Node) supervise(name string, run func()) {
+	go func() {
+		var restarts []time.Time
+		for {
+			report, panicked := runRecovered(run)
+			if !panicked {
+				return
+			}
+			select {
+			case <-n.closing:
+				log.Errorf("subsystem %s panicked while the node closed: %s", name, report)
+				return
+			default:
+			}
+			now := time.Now()
+			for len(restarts) > 0 && now.Sub(restarts[0]) > subsystemRestartWindow {
+				restarts = restarts[1:]
+			}
+			if len(restarts) >= maxSubsystemRestarts {
+				log.Errorf("subsystem %s panicked, stopped after %d restarts in %s: %s", name, len(restarts), subsystemRestartWindow, report)
+				n.subsystems.panicked(name, report, false)
+				return
+			}
+			wait := factory.Backoff(subsystemRestartWait, subsystemRestartWindow, len(restarts))
+			restarts = append(restarts, now)
+			log.Errorf("subsystem %s panicked, restart %d in %s: %s", name, len(restarts), wait, report)
+			n.subsystems.panicked(name, report, true)
+			select {
+			case <-n.closing:
+				return
+			case <-time.After(wait):
+			}
+		}
+	}()
+}
+
+// runRecovered returns the report of the panic of run, with the stack of the goroutine
+func runRecovered(run func()) (report string, panicked bool) {
+	defer func() {
+		if e := recover(); e != nil {
+			report = fmt.Sprintf("%v (%d goroutines)\n%s", e, runtime.NumGoroutine(), debug.Stack())
+			panicked = true
+		}
+	}()
+	run()
+	return
+}
+
+// panicked records the panic of a subsystem added to the node, restarted or stopped for good
+func (m *subsystems) panicked(name, report string, restarted bool) {
+	m.Lock()
+	defer m.Unlock()
+	v, ok := m.status[name]
+	if !ok {
+		return
+	}
+	// the stack is in the log
+	v.Panic = strings.SplitN(report, "\n", 2)[0]
+	if restarted {
+		v.Restarts++
+		return
+	}
+	v.State = SubsystemFailed
+	v.Error = fmt.Sprintf("stopped after %d restarts", v.Restarts)
+}
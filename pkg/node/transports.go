@@ -0,0 +1,118 @@
+package node
+
+import (
+	"sort"
+
+	"github.com/skycoin/skycoin/src/cipher"
+	"github.com/skycoin/skywire/pkg/net/skycoin-messenger/factory"
+)
+
+const (
+	// transports in a page if the request sets no limit
+	DefaultTransportPage = 100
+	MaxTransportPage     = 1000
+)
+
+// TransportPage is a page of the transports of the node ordered by their cursors, Next is
+// the cursor to request the following page with, empty on the last page
+type TransportPage struct {
+	Transports []NodeTransport `json:"transports"`
+	Next       string          `json:"next,omitempty"`
+}
+
+type pagedTransport struct {
+	cursor string
+	tr     *factory.Transport
+}
+
+// GetTransports returns up to limit transports after the cursor, only the transports of
+// the page are kept while walking the transports of the node. The cursor of a transport is
+// the key of its local app followed by the key of the remote app, and ":standby" for the
+// standby of a critical transport, so the pages stay in order while transports come and go.
+func (n *Node) GetTransports(cursor string, limit int) (page TransportPage) {
+	if limit <= 0 {
+		limit = DefaultTransportPage
+	} else if limit > MaxTransportPage {
+		limit = MaxTransportPage
+	}
+	// one more than the page to know if another page follows
+	ps := make([]pagedTransport, 0, limit+1)
+	n.apps.ForEachAcceptedConnection(func(key cipher.PubKey, conn *factory.Connection) {
+		conn.ForEachTransport(func(v *factory.Transport) {
+			remote := v.ToApp
+			if v.ToApp == key {
+				remote = v.FromApp
+			}
+			c := key.Hex() + remote.Hex()
+			if v.IsStandby() {
+				c += ":standby"
+			}
+			if c <= cursor {
+				return
+			}
+			i := sort.Search(len(ps), func(i int) bool { return ps[i].cursor >= c })
+			if i > limit {
+				return
+			}
+			if len(ps) <= limit {
+				ps = append(ps, pagedTransport{})
+			}
+			copy(ps[i+1:], ps[i:])
+			ps[i] = pagedTransport{cursor: c, tr: v}
+		})
+	})
+	if len(ps) > limit {
+		ps = ps[:limit]
+		page.Next = ps[limit-1].cursor
+	}
+	page.Transports = make([]NodeTransport, len(ps))
+	for i, p := range ps {
+		page.Transports[i] = newNodeTransport(p.tr)
+	}
+	return
+}
+
+// GetTransport returns the transport with the id of its record, the transports of the nodes
+// before the records have none
+func (n *Node) GetTransport(id string) (t NodeTransport, ok bool) {
+	if len(id) == 0 {
+		return
+	}
+	n.apps.ForEachAcceptedConnection(func(key cipher.PubKey, conn *factory.Connection) {
+		conn.ForEachTransport(func(v *factory.Transport) {
+			if ok {
+				return
+			}
+			if record, _ := v.Record(); record.ID == id {
+				t, ok = newNodeTransport(v), true
+			}
+		})
+	})
+	return
+}
+
+// CloseTransport closes the transport with the id of its record and tells the other node
+// the reason. A critical transport closed with a failure fails over to its standby
+func (n *Node) CloseTransport(id string, reason factory.CloseReason) (ok bool) {
+	if len(id) == 0 {
+		return
+	}
+	var tr *factory.Transport
+	n.apps.ForEachAcceptedConnection(func(key cipher.PubKey, conn *factory.Connection) {
+		conn.ForEachTransport(func(v *factory.Transport) {
+			if record, _ := v.Record(); tr == nil && record.ID == id {
+				tr = v
+			}
+		})
+	})
+	if tr == nil {
+		return
+	}
+	tr.CloseWithReason(reason)
+	return true
+}
+
+// GetRoutes returns the answers of the discoveries the node keeps for the transports of its apps
+func (n *Node) GetRoutes() []factory.CachedRoute {
+	return n.apps.GetRoutes()
+}
@@ -0,0 +1,174 @@
+package node_test
+
+import (
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/skycoin/skywire/pkg/node/nodetest"
+)
+
+func TestLoopStates(t *testing.T) {
+	e := nodetest.NewEnv(t, 1)
+	defer e.Close()
+	a, b := e.StartNode("a"), e.StartNode("b")
+	server := e.ConnectApp(b, "server")
+	server.Offer(e.Echo(), "echo")
+	port := e.ConnectApp(a, "client").Connect(b.Key, server.GetKey(), e.DiscoveryKey(0)).Port
+
+	// the loop stays open while the connection to the app port does
+	conn := nodetest.Ping(t, port)
+	defer conn.Close()
+	loops := func(n *nodetest.Node) map[uint32]string {
+		tr := n.GetNodeInfo().Transports
+		if len(tr) != 1 {
+			return nil
+		}
+		return tr[0].Loops
+	}
+	nodetest.WaitFor(t, "the open loop on both nodes", func() bool {
+		return loops(a)[1] == "open" && loops(b)[1] == "open"
+	})
+	for _, n := range []*nodetest.Node{a, b} {
+		if arq := n.GetNodeInfo().Transports[0].ARQ; arq == nil || arq.Acks == 0 {
+			t.Fatalf("retransmissions of the transport %#v", arq)
+		}
+	}
+	page := a.GetTransports("", 0)
+	if len(page.Transports) != 1 || page.Transports[0].Loops[1] != "open" {
+		t.Fatalf("transports %#v", page)
+	}
+
+	conn.Close()
+	nodetest.WaitFor(t, "the loop closed on both nodes", func() bool {
+		_, ok := loops(a)[1]
+		return !ok && loops(b)[1] == "closed"
+	})
+}
+
+func TestPlainTransports(t *testing.T) {
+	e := nodetest.NewEnv(t, 1)
+	defer e.Close()
+	echo := e.Echo()
+	// connect starts a transport from node a to node b and returns if both see it plain
+	connect := func(a, b *nodetest.Node) bool {
+		server := e.ConnectApp(b, "server-"+b.Key.Hex())
+		server.Offer(echo, "echo")
+		port := e.ConnectApp(a, "client-"+a.Key.Hex()).Connect(b.Key, server.GetKey(), e.DiscoveryKey(0)).Port
+		nodetest.Ping(t, port).Close()
+		plain := true
+		for _, n := range []*nodetest.Node{a, b} {
+			tr := n.GetNodeInfo().Transports
+			if len(tr) != 1 {
+				t.Fatalf("transports %#v", tr)
+			}
+			plain = plain && tr[0].Plain
+		}
+		return plain
+	}
+
+	// only node a lists node b, the transport stays encrypted
+	a, b := e.StartNode("a"), e.StartNode("b")
+	if err := a.SetPlainTransportNodes([]string{b.Key.Hex()}); err != nil {
+		t.Fatal(err)
+	}
+	if connect(a, b) {
+		t.Fatal("plain transport listed by one node")
+	}
+
+	c, d := e.StartNode("c"), e.StartNode("d")
+	if err := c.SetPlainTransportNodes([]string{d.Key.Hex()}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetPlainTransportNodes([]string{c.Key.Hex()}); err != nil {
+		t.Fatal(err)
+	}
+	if !connect(c, d) {
+		t.Fatal("transport listed by both nodes encrypted")
+	}
+
+	if err := a.SetPlainTransportNodes([]string{"02"}); err == nil {
+		t.Fatal("invalid key accepted")
+	}
+}
+
+func TestAppPortKept(t *testing.T) {
+	e := nodetest.NewEnv(t, 1)
+	defer e.Close()
+	path := e.Path("a", "app_ports.json")
+	start := func() *nodetest.Node {
+		n := e.NewNode("a")
+		if err := n.SetAppPortsPath(path); err != nil {
+			t.Fatal(err)
+		}
+		return e.Start(n)
+	}
+	a, b := start(), e.StartNode("b")
+	server := e.ConnectApp(b, "server")
+	server.Offer(e.Echo(), "echo")
+	client := e.ConnectApp(a, "client")
+	port := client.Connect(b.Key, server.GetKey(), e.DiscoveryKey(0)).Port
+	nodetest.Ping(t, port).Close()
+
+	// the app comes back with the same keys and is served on the same port
+	client.Close()
+	// the transport of the app is closed by the node once it sees the app leave
+	nodetest.WaitFor(t, "the app port closed", func() bool {
+		ln, err := net.Listen("tcp", net.JoinHostPort("", strconv.Itoa(port)))
+		if err != nil {
+			return false
+		}
+		ln.Close()
+		return true
+	})
+	client = e.ConnectApp(a, "client")
+	if got := client.Connect(b.Key, server.GetKey(), e.DiscoveryKey(0)).Port; got != port {
+		t.Fatalf("port %d after the app restart, was %d", got, port)
+	}
+
+	// and so after the node restarts
+	client.Close()
+	a.Close()
+	a = start()
+	client = e.ConnectApp(a, "client")
+	if got := client.Connect(b.Key, server.GetKey(), e.DiscoveryKey(0)).Port; got != port {
+		t.Fatalf("port %d after the node restart, was %d", got, port)
+	}
+	nodetest.Ping(t, port).Close()
+}
+
+func TestTransportPages(t *testing.T) {
+	e := nodetest.NewEnv(t, 1)
+	defer e.Close()
+	a, b := e.StartNode("a"), e.StartNode("b")
+	server := e.ConnectApp(b, "server")
+	server.Offer(e.Echo(), "echo")
+	for i := 0; i < 3; i++ {
+		port := e.ConnectApp(a, "client-"+strconv.Itoa(i)).Connect(b.Key, server.GetKey(), e.DiscoveryKey(0)).Port
+		nodetest.Ping(t, port).Close()
+	}
+	all := a.GetTransports("", 0)
+	if len(all.Transports) != 3 || len(all.Next) != 0 {
+		t.Fatalf("transports %#v", all)
+	}
+
+	// a transport a page, in the order of the first page, until the last one
+	var cursor string
+	for i, want := range all.Transports {
+		page := a.GetTransports(cursor, 1)
+		if len(page.Transports) != 1 || page.Transports[0].FromApp != want.FromApp {
+			t.Fatalf("page %d %#v", i, page)
+		}
+		if last := i == len(all.Transports)-1; last != (len(page.Next) == 0) {
+			t.Fatalf("next of page %d %q", i, page.Next)
+		}
+		if len(cursor) > 0 && len(page.Next) > 0 && page.Next <= cursor {
+			t.Fatalf("cursor %q after %q", page.Next, cursor)
+		}
+		cursor = page.Next
+	}
+	// the cursor of the last transport has no transport after it
+	if page := a.GetTransports(all.Transports[2].FromApp+all.Transports[2].ToApp, 0); len(page.Transports) != 0 {
+		t.Fatalf("after the last transport %#v", page)
+	}
+}
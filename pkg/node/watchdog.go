@@ -0,0 +1,246 @@
+package node
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	watchdogInterval = time.Minute
+	// the slopes are fitted over this window
+	watchdogWindow = time.Hour
+	// at most one diagnostic bundle per period
+	watchdogDiagPeriod = time.Hour
+	watchdogEvents     = 50
+)
+
+// WatchdogConfig limits the resources of the node, a zero limit is not checked,
+// the slopes are growths per hour
+type WatchdogConfig struct {
+	MaxGoroutines  int
+	MaxFDs         int
+	MaxHeapMB      int
+	GoroutineSlope float64
+	FDSlope        float64
+	HeapSlopeMB    float64
+	// write a diagnostic bundle to the directory when a limit is exceeded
+	DiagDir string
+}
+
+type resourceSample struct {
+	time       time.Time
+	goroutines int
+	// -1 if the open files can not be counted on this platform
+	fds  int
+	heap uint64
+}
+
+type WatchdogEvent struct {
+	Time     int64  `json:"time"`
+	Resource string `json:"resource"`
+	Exceeded bool   `json:"exceeded"`
+	Message  string `json:"message"`
+}
+
+type WatchdogStatus struct {
+	Goroutines int    `json:"goroutines"`
+	FDs        int    `json:"fds"`
+	HeapBytes  uint64 `json:"heap_bytes"`
+	// limits exceeded right now
+	Exceeded []string        `json:"exceeded"`
+	Events   []WatchdogEvent `json:"events"`
+}
+
+type watchdog struct {
+	config   WatchdogConfig
+	samples  []resourceSample
+	exceeded map[string]bool
+	events   []WatchdogEvent
+	lastDiag time.Time
+	sync.Mutex
+}
+
+// StartWatchdog samples the goroutines, open files and heap of the node until it is closed,
+// exceeded limits are logged, reported in the node info and can write a diagnostic bundle
+func (n *Node) StartWatchdog(config WatchdogConfig) {
+	w := &watchdog{config: config, exceeded: make(map[string]bool)}
+	n.watchdogMutex.Lock()
+	n.watchdog = w
+	n.watchdogMutex.Unlock()
+	n.supervise(WatchdogSubsystem, func() {
+		ticker := time.NewTicker(watchdogInterval)
+		defer ticker.Stop()
+		for {
+			if w.sample(sampleResources()) {
+				w.writeDiag(n)
+			}
+			select {
+			case <-n.closing:
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+}
+
+// GetWatchdog returns the resources and the events of the watchdog, nil if it is not started
+func (n *Node) GetWatchdog() *WatchdogStatus {
+	n.watchdogMutex.RLock()
+	w := n.watchdog
+	n.watchdogMutex.RUnlock()
+	if w == nil {
+		return nil
+	}
+	return w.status()
+}
+
+func sampleResources() (s resourceSample) {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	s = resourceSample{
+		time:       time.Now(),
+		goroutines: runtime.NumGoroutine(),
+		fds:        -1,
+		heap:       ms.HeapAlloc,
+	}
+	for _, dir := range []string{"/proc/self/fd", "/dev/fd"} {
+		fis, err := ioutil.ReadDir(dir)
+		if err == nil {
+			s.fds = len(fis)
+			break
+		}
+	}
+	return
+}
+
+// slope of the values per hour by least squares
+func slope(samples []resourceSample, value func(resourceSample) float64) float64 {
+	var st, sv, stt, stv float64
+	t0 := samples[0].time
+	for _, s := range samples {
+		t := s.time.Sub(t0).Hours()
+		v := value(s)
+		st += t
+		sv += v
+		stt += t * t
+		stv += t * v
+	}
+	n := float64(len(samples))
+	d := n*stt - st*st
+	if d == 0 {
+		return 0
+	}
+	return (n*stv - st*sv) / d
+}
+
+// sample adds the sample and checks the limits, true if a limit started being exceeded
+func (w *watchdog) sample(s resourceSample) (fired bool) {
+	w.Lock()
+	defer w.Unlock()
+	w.samples = append(w.samples, s)
+	for len(w.samples) > 0 && s.time.Sub(w.samples[0].time) > watchdogWindow {
+		w.samples = w.samples[1:]
+	}
+	// the slopes are only meaningful over half of the window at least
+	fit := s.time.Sub(w.samples[0].time) >= watchdogWindow/2
+	const mb = 1 << 20
+	check := func(resource string, value, limit float64, unit string) {
+		if limit <= 0 {
+			return
+		}
+		fired = w.set(resource, value > limit, s.time,
+			fmt.Sprintf("%s %.0f%s exceeds %.0f%s", resource, value, unit, limit, unit)) || fired
+	}
+	check("goroutines", float64(s.goroutines), float64(w.config.MaxGoroutines), "")
+	if s.fds >= 0 {
+		check("fds", float64(s.fds), float64(w.config.MaxFDs), "")
+	}
+	check("heap", float64(s.heap)/mb, float64(w.config.MaxHeapMB), "MB")
+	if fit {
+		check("goroutines_slope", slope(w.samples, func(s resourceSample) float64 { return float64(s.goroutines) }),
+			w.config.GoroutineSlope, "/h")
+		if s.fds >= 0 {
+			check("fds_slope", slope(w.samples, func(s resourceSample) float64 { return float64(s.fds) }),
+				w.config.FDSlope, "/h")
+		}
+		check("heap_slope", slope(w.samples, func(s resourceSample) float64 { return float64(s.heap) / mb }),
+			w.config.HeapSlopeMB, "MB/h")
+	}
+	return
+}
+
+// set records a change of the state of the limit, true if it started being exceeded
+func (w *watchdog) set(resource string, exceeded bool, now time.Time, msg string) bool {
+	if w.exceeded[resource] == exceeded {
+		return false
+	}
+	w.exceeded[resource] = exceeded
+	if exceeded {
+		log.Warnf("watchdog: %s", msg)
+	} else {
+		msg = resource + " back within its limit"
+		log.Infof("watchdog: %s", msg)
+	}
+	w.events = append(w.events, WatchdogEvent{Time: now.Unix(), Resource: resource, Exceeded: exceeded, Message: msg})
+	if len(w.events) > watchdogEvents {
+		w.events = w.events[len(w.events)-watchdogEvents:]
+	}
+	return exceeded
+}
+
+func (w *watchdog) status() (s *WatchdogStatus) {
+	w.Lock()
+	defer w.Unlock()
+	s = &WatchdogStatus{
+		FDs:      -1,
+		Exceeded: make([]string, 0),
+		Events:   append([]WatchdogEvent{}, w.events...),
+	}
+	if len(w.samples) > 0 {
+		last := w.samples[len(w.samples)-1]
+		s.Goroutines = last.goroutines
+		s.FDs = last.fds
+		s.HeapBytes = last.heap
+	}
+	for k, v := range w.exceeded {
+		if v {
+			s.Exceeded = append(s.Exceeded, k)
+		}
+	}
+	return
+}
+
+func (w *watchdog) writeDiag(n *Node) {
+	if len(w.config.DiagDir) == 0 {
+		return
+	}
+	w.Lock()
+	if time.Since(w.lastDiag) < watchdogDiagPeriod {
+		w.Unlock()
+		return
+	}
+	w.lastDiag = time.Now()
+	w.Unlock()
+	d, err := json.Marshal(n.GetDiagnostics())
+	if err == nil {
+		err = os.MkdirAll(w.config.DiagDir, 0700)
+	}
+	if err == nil {
+		path := filepath.Join(w.config.DiagDir, fmt.Sprintf("diag-%s.json", time.Now().Format("20060102-150405")))
+		err = ioutil.WriteFile(path, d, 0600)
+		if err == nil {
+			log.Infof("watchdog: diagnostics written to %s", path)
+		}
+	}
+	if err != nil {
+		log.Errorf("watchdog: write diagnostics: %v", err)
+	}
+}
@@ -0,0 +1,71 @@
+package node
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestSlope(t *testing.T) {
+	t0 := time.Unix(0, 0)
+	var samples []resourceSample
+	for i := 0; i < 5; i++ {
+		samples = append(samples, resourceSample{time: t0.Add(time.Duration(i) * 15 * time.Minute), goroutines: 100 + 25*i})
+	}
+	goroutines := func(s resourceSample) float64 { return float64(s.goroutines) }
+	if s := slope(samples, goroutines); math.Abs(s-100) > 1e-9 {
+		t.Fatalf("slope %f, want 100 per hour", s)
+	}
+	if s := slope(samples[:1], goroutines); s != 0 {
+		t.Fatalf("slope of a sample %f", s)
+	}
+}
+
+func TestWatchdogLimits(t *testing.T) {
+	w := &watchdog{config: WatchdogConfig{MaxGoroutines: 100, MaxFDs: 50, GoroutineSlope: 60}, exceeded: make(map[string]bool)}
+	t0 := time.Unix(1000, 0)
+	// an unknown number of open files is not checked
+	if w.sample(resourceSample{time: t0, goroutines: 10, fds: -1}) {
+		t.Fatal("fired within the limits")
+	}
+	if !w.sample(resourceSample{time: t0.Add(time.Minute), goroutines: 150, fds: 60}) {
+		t.Fatal("not fired above the limits")
+	}
+	// fired once while the limit stays exceeded
+	if w.sample(resourceSample{time: t0.Add(2 * time.Minute), goroutines: 160, fds: 60}) {
+		t.Fatal("fired again")
+	}
+	s := w.status()
+	if len(s.Exceeded) != 2 || s.Goroutines != 160 || s.FDs != 60 || len(s.Events) != 2 {
+		t.Fatalf("status %+v", s)
+	}
+	w.sample(resourceSample{time: t0.Add(3 * time.Minute), goroutines: 90, fds: 10})
+	s = w.status()
+	if len(s.Exceeded) != 0 || len(s.Events) != 4 || s.Events[3].Exceeded {
+		t.Fatalf("status back within the limits %+v", s)
+	}
+
+	// the slope is checked once the samples cover half of the window
+	w = &watchdog{config: WatchdogConfig{MaxGoroutines: 100, GoroutineSlope: 60}, exceeded: make(map[string]bool)}
+	for i, g := range []int{10, 20, 30, 45} {
+		fired := w.sample(resourceSample{time: t0.Add(time.Duration(i) * 10 * time.Minute), goroutines: g, fds: -1})
+		if fired != (i == 3) {
+			t.Fatalf("sample %d fired %v", i, fired)
+		}
+	}
+	if s = w.status(); len(s.Exceeded) != 1 || s.Exceeded[0] != "goroutines_slope" || s.FDs != -1 {
+		t.Fatalf("status %+v", s)
+	}
+
+	// the samples older than the window are dropped
+	w.sample(resourceSample{time: t0.Add(3 * watchdogWindow), goroutines: 90, fds: -1})
+	if len(w.samples) != 1 {
+		t.Fatalf("%d samples kept", len(w.samples))
+	}
+	for i := 0; i < 2*watchdogEvents; i++ {
+		w.set("heap", i%2 == 0, t0, "heap")
+	}
+	if len(w.status().Events) != watchdogEvents {
+		t.Fatalf("%d events kept", len(w.status().Events))
+	}
+}
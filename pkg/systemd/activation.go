@@ -0,0 +1,41 @@
+package systemd
+
+import (
+	"net"
+	"os"
+	"strconv"
+)
+
+// first file descriptor passed by socket activation
+const listenFdsStart = 3
+
+// Listeners returns the sockets passed by systemd socket activation in the
+// order of the ListenStream lines of the socket unit.
+// It returns no listeners when the process was not socket activated.
+func Listeners() (lns []net.Listener, err error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		err = nil
+		return
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		err = nil
+		return
+	}
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	for i := 0; i < n; i++ {
+		fd := listenFdsStart + i
+		f := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+		var ln net.Listener
+		ln, err = net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return
+		}
+		lns = append(lns, ln)
+	}
+	return
+}
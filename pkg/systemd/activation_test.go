@@ -0,0 +1,81 @@
+package systemd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestListenersChild runs in the process started by TestListeners with the sockets passed to it
+func TestListenersChild(t *testing.T) {
+	if os.Getenv("SYSTEMD_TEST_CHILD") != "1" {
+		return
+	}
+	// the service manager sets the pid of the process it starts
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	lns, err := Listeners()
+	if err != nil {
+		fmt.Println("error", err)
+		return
+	}
+	for _, ln := range lns {
+		fmt.Println("listener", ln.Addr())
+		ln.Close()
+	}
+	fmt.Println("unset", os.Getenv("LISTEN_PID") == "" && os.Getenv("LISTEN_FDS") == "")
+}
+
+func TestListeners(t *testing.T) {
+	var files []*os.File
+	var want []string
+	for i := 0; i < 2; i++ {
+		ln, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ln.Close()
+		f, err := ln.File()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		files = append(files, f)
+		want = append(want, "listener "+ln.Addr().String())
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^TestListenersChild$")
+	cmd.Env = append(os.Environ(), "SYSTEMD_TEST_CHILD=1", "LISTEN_FDS=2")
+	cmd.ExtraFiles = files
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	got := string(out)
+	if !strings.Contains(got, strings.Join(append(want, "unset true"), "\n")) {
+		t.Fatalf("child printed %q, want %q", got, want)
+	}
+}
+
+func TestListenersNotActivated(t *testing.T) {
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	for _, env := range []struct {
+		pid, fds string
+	}{
+		{"", ""},
+		// the sockets were passed to another process
+		{strconv.Itoa(os.Getpid() + 1), "1"},
+		{strconv.Itoa(os.Getpid()), "0"},
+		{strconv.Itoa(os.Getpid()), "x"},
+	} {
+		os.Setenv("LISTEN_PID", env.pid)
+		os.Setenv("LISTEN_FDS", env.fds)
+		lns, err := Listeners()
+		if err != nil || len(lns) != 0 {
+			t.Fatalf("pid %q fds %q: %v %v", env.pid, env.fds, lns, err)
+		}
+	}
+}
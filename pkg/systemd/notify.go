@@ -0,0 +1,76 @@
+package systemd
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	Ready    = "READY=1"
+	Stopping = "STOPPING=1"
+	Watchdog = "WATCHDOG=1"
+)
+
+// Notify sends the state to the service manager, sent is false when
+// the process is not supervised by systemd
+func Notify(state string) (sent bool, err error) {
+	path := os.Getenv("NOTIFY_SOCKET")
+	if len(path) == 0 {
+		return
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	if err != nil {
+		return
+	}
+	sent = true
+	return
+}
+
+// WatchdogInterval returns the watchdog timeout configured by WatchdogSec
+func WatchdogInterval() (interval time.Duration, ok bool) {
+	if pid := os.Getenv("WATCHDOG_PID"); len(pid) > 0 && pid != strconv.Itoa(os.Getpid()) {
+		return
+	}
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return
+	}
+	return time.Duration(usec) * time.Microsecond, true
+}
+
+// StartWatchdog pings the watchdog at half of its timeout while alive returns true
+// or alive is nil, until stop is closed
+func StartWatchdog(alive func() bool, stop <-chan struct{}) {
+	interval, ok := WatchdogInterval()
+	if !ok {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			if alive != nil && !alive() {
+				log.Warn("watchdog: service is not alive, skip ping")
+				continue
+			}
+			_, err := Notify(Watchdog)
+			if err != nil {
+				log.Errorf("watchdog notify err: %v", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,105 @@
+package systemd
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// listenNotify listens on a notify socket like the service manager does
+func listenNotify(t *testing.T) (*net.UnixConn, func()) {
+	dir, err := ioutil.TempDir("", "systemd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	os.Setenv("NOTIFY_SOCKET", path)
+	return conn, func() {
+		os.Unsetenv("NOTIFY_SOCKET")
+		conn.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func readNotify(conn *net.UnixConn, timeout time.Duration) (string, error) {
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	return string(buf[:n]), err
+}
+
+func TestNotify(t *testing.T) {
+	os.Unsetenv("NOTIFY_SOCKET")
+	if sent, err := Notify(Ready); sent || err != nil {
+		t.Fatalf("sent %v, %v without a notify socket", sent, err)
+	}
+
+	conn, done := listenNotify(t)
+	defer done()
+	if sent, err := Notify(Ready); !sent || err != nil {
+		t.Fatalf("sent %v, %v", sent, err)
+	}
+	if state, err := readNotify(conn, time.Second); err != nil || state != Ready {
+		t.Fatalf("got %q, %v", state, err)
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	defer os.Unsetenv("WATCHDOG_USEC")
+	defer os.Unsetenv("WATCHDOG_PID")
+	os.Setenv("WATCHDOG_USEC", "30000000")
+	if interval, ok := WatchdogInterval(); !ok || interval != 30*time.Second {
+		t.Fatalf("interval %v %v", interval, ok)
+	}
+	os.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	if _, ok := WatchdogInterval(); !ok {
+		t.Fatal("the watchdog of this process disabled")
+	}
+	os.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()+1))
+	if _, ok := WatchdogInterval(); ok {
+		t.Fatal("the watchdog of another process enabled")
+	}
+	os.Unsetenv("WATCHDOG_PID")
+	for _, usec := range []string{"", "0", "x"} {
+		os.Setenv("WATCHDOG_USEC", usec)
+		if _, ok := WatchdogInterval(); ok {
+			t.Fatalf("watchdog enabled by %q", usec)
+		}
+	}
+}
+
+func TestStartWatchdog(t *testing.T) {
+	conn, done := listenNotify(t)
+	defer done()
+	defer os.Unsetenv("WATCHDOG_USEC")
+	os.Setenv("WATCHDOG_USEC", "20000")
+
+	stop := make(chan struct{})
+	StartWatchdog(nil, stop)
+	if state, err := readNotify(conn, time.Second); err != nil || state != Watchdog {
+		t.Fatalf("got %q, %v", state, err)
+	}
+	close(stop)
+	// a ping may have been sent before the stop
+	readNotify(conn, 50*time.Millisecond)
+	if state, err := readNotify(conn, 100*time.Millisecond); err == nil {
+		t.Fatalf("pinged %q after the stop", state)
+	}
+
+	// a service that is not alive is not pinged
+	stop = make(chan struct{})
+	defer close(stop)
+	StartWatchdog(func() bool { return false }, stop)
+	if state, err := readNotify(conn, 100*time.Millisecond); err == nil {
+		t.Fatalf("pinged %q while not alive", state)
+	}
+}
@@ -0,0 +1,69 @@
+package systemd
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Unit describes a service with an optional activation socket
+type Unit struct {
+	Name        string
+	Description string
+	ExecStart   []string
+	User        string
+	// seconds, 0 disables the watchdog
+	WatchdogSec int
+	// listen addresses in the order they are passed to the service,
+	// empty for no socket unit
+	Sockets []string
+}
+
+// Service returns the content of the .service unit file
+func (u *Unit) Service() string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "[Unit]\nDescription=%s\nAfter=network-online.target\nWants=network-online.target\n", u.Description)
+	if len(u.Sockets) > 0 {
+		fmt.Fprintf(&b, "Requires=%s.socket\n", u.Name)
+	}
+	b.WriteString("\n[Service]\nType=notify\nNotifyAccess=main\n")
+	fmt.Fprintf(&b, "ExecStart=%s\n", quoteArgs(u.ExecStart))
+	if len(u.User) > 0 {
+		fmt.Fprintf(&b, "User=%s\n", u.User)
+	}
+	if u.WatchdogSec > 0 {
+		fmt.Fprintf(&b, "WatchdogSec=%d\n", u.WatchdogSec)
+	}
+	b.WriteString("Restart=on-failure\nRestartSec=5\n\n[Install]\nWantedBy=multi-user.target\n")
+	return b.String()
+}
+
+// Socket returns the content of the .socket unit file
+func (u *Unit) Socket() string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "[Unit]\nDescription=%s sockets\n\n[Socket]\n", u.Description)
+	for _, addr := range u.Sockets {
+		fmt.Fprintf(&b, "ListenStream=%s\n", listenAddress(addr))
+	}
+	b.WriteString("\n[Install]\nWantedBy=sockets.target\n")
+	return b.String()
+}
+
+// systemd does not accept addresses without a host like ":5000"
+func listenAddress(addr string) string {
+	if strings.HasPrefix(addr, ":") {
+		return addr[1:]
+	}
+	return addr
+}
+
+func quoteArgs(args []string) string {
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		if strings.ContainsAny(a, " \t\"'\\") {
+			a = "\"" + strings.Replace(strings.Replace(a, "\\", "\\\\", -1), "\"", "\\\"", -1) + "\""
+		}
+		quoted[i] = a
+	}
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,43 @@
+package systemd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUnit(t *testing.T) {
+	u := &Unit{
+		Name:        "skywire-node",
+		Description: "Skywire node",
+		ExecStart:   []string{"/usr/bin/skywire-node", "-dir", "/var/lib/sky wire", `-name="a\b"`},
+		User:        "skywire",
+		WatchdogSec: 30,
+		Sockets:     []string{":5000", "127.0.0.1:6001"},
+	}
+	service := u.Service()
+	for _, line := range []string{
+		"Description=Skywire node",
+		"Requires=skywire-node.socket",
+		"Type=notify",
+		`ExecStart=/usr/bin/skywire-node -dir "/var/lib/sky wire" "-name=\"a\\b\""`,
+		"User=skywire",
+		"WatchdogSec=30",
+	} {
+		if !strings.Contains(service, line+"\n") {
+			t.Errorf("service without %q:\n%s", line, service)
+		}
+	}
+	socket := u.Socket()
+	if !strings.Contains(socket, "ListenStream=5000\nListenStream=127.0.0.1:6001\n") {
+		t.Errorf("socket:\n%s", socket)
+	}
+
+	// without sockets, a user or a watchdog
+	u = &Unit{Name: "skywire-node", ExecStart: []string{"/usr/bin/skywire-node"}}
+	service = u.Service()
+	for _, line := range []string{"Requires=", "User=", "WatchdogSec="} {
+		if strings.Contains(service, line) {
+			t.Errorf("service with %q:\n%s", line, service)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+// Package trace records spans of the protocol exchanges between nodes and exports them
+// with the OpenTelemetry protocol (OTLP/HTTP JSON), e.g. to Jaeger. The span context
+// travels in the protocol frames, so a transport setup is one trace across the nodes.
+package trace
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	exportInterval = 5 * time.Second
+	exportTimeout  = 10 * time.Second
+	// spans kept while the collector is not reachable
+	maxPending = 4096
+)
+
+// SpanContext identifies a span across nodes, the ids are hex like in W3C trace context
+type SpanContext struct {
+	TraceID string `json:"trace_id" wire:"1"`
+	SpanID  string `json:"span_id" wire:"2"`
+}
+
+type Span struct {
+	tracer *Tracer
+	name   string
+	ctx    SpanContext
+	parent string
+	start  time.Time
+	end    time.Time
+	attrs  map[string]string
+	err    string
+	ended  bool
+	sync.Mutex
+}
+
+// Tracer exports the ended spans to an OTLP/HTTP endpoint, a nil Tracer records nothing
+type Tracer struct {
+	service  string
+	endpoint string
+	client   *http.Client
+	pending  []*Span
+	closing  chan struct{}
+	done     chan struct{}
+	sync.Mutex
+}
+
+// NewTracer exports the spans of the service to the endpoint, e.g. http://localhost:4318/v1/traces
+func NewTracer(service, endpoint string) *Tracer {
+	t := &Tracer{
+		service:  service,
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: exportTimeout},
+		closing:  make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go t.exportLoop()
+	return t
+}
+
+func randomID(size int) string {
+	b := make([]byte, size)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Start a span, a child of parent or the root of a new trace if parent is nil
+func (t *Tracer) Start(name string, parent *SpanContext) *Span {
+	if t == nil {
+		return nil
+	}
+	s := &Span{
+		tracer: t,
+		name:   name,
+		start:  time.Now(),
+		attrs:  make(map[string]string),
+		ctx:    SpanContext{SpanID: randomID(8)},
+	}
+	if parent != nil && len(parent.TraceID) == 32 {
+		s.ctx.TraceID = parent.TraceID
+		s.parent = parent.SpanID
+	} else {
+		s.ctx.TraceID = randomID(16)
+	}
+	return s
+}
+
+// Context to send to the next node, nil for a nil span
+func (s *Span) Context() *SpanContext {
+	if s == nil {
+		return nil
+	}
+	ctx := s.ctx
+	return &ctx
+}
+
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	if !s.ended {
+		s.attrs[key] = fmt.Sprint(value)
+	}
+	s.Unlock()
+}
+
+// Fail marks the span as failed with the cause, an ended span is not changed
+func (s *Span) Fail(cause string) {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	if !s.ended {
+		s.err = cause
+	}
+	s.Unlock()
+}
+
+// End the span, only the first call counts
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	if s.ended {
+		s.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.Unlock()
+	s.tracer.add(s)
+}
+
+func (t *Tracer) add(s *Span) {
+	t.Lock()
+	if len(t.pending) < maxPending {
+		t.pending = append(t.pending, s)
+	}
+	t.Unlock()
+}
+
+func (t *Tracer) exportLoop() {
+	defer close(t.done)
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.closing:
+			t.export()
+			return
+		case <-ticker.C:
+			t.export()
+		}
+	}
+}
+
+// Close exports the remaining spans
+func (t *Tracer) Close() {
+	if t == nil {
+		return
+	}
+	close(t.closing)
+	<-t.done
+}
+
+type keyValue struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue string `json:"stringValue"`
+	} `json:"value"`
+}
+
+func attribute(k, v string) (kv keyValue) {
+	kv.Key = k
+	kv.Value.StringValue = v
+	return
+}
+
+type otlpSpan struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              int        `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []keyValue `json:"attributes,omitempty"`
+	Status            struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	} `json:"status"`
+}
+
+// otlp span kind and status code
+const (
+	spanKindInternal = 1
+	statusError      = 2
+)
+
+func (t *Tracer) marshal(spans []*Span) ([]byte, error) {
+	out := make([]otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		s.Lock()
+		o := otlpSpan{
+			TraceID:           s.ctx.TraceID,
+			SpanID:            s.ctx.SpanID,
+			ParentSpanID:      s.parent,
+			Name:              s.name,
+			Kind:              spanKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+		}
+		for k, v := range s.attrs {
+			o.Attributes = append(o.Attributes, attribute(k, v))
+		}
+		if len(s.err) > 0 {
+			o.Status.Code = statusError
+			o.Status.Message = s.err
+		}
+		s.Unlock()
+		out = append(out, o)
+	}
+	type scopeSpans struct {
+		Scope struct {
+			Name string `json:"name"`
+		} `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	type resourceSpans struct {
+		Resource struct {
+			Attributes []keyValue `json:"attributes"`
+		} `json:"resource"`
+		ScopeSpans []scopeSpans `json:"scopeSpans"`
+	}
+	var rs resourceSpans
+	rs.Resource.Attributes = []keyValue{attribute("service.name", t.service)}
+	ss := scopeSpans{Spans: out}
+	ss.Scope.Name = "skywire"
+	rs.ScopeSpans = []scopeSpans{ss}
+	return json.Marshal(map[string][]resourceSpans{"resourceSpans": {rs}})
+}
+
+func (t *Tracer) export() {
+	t.Lock()
+	spans := t.pending
+	t.pending = nil
+	t.Unlock()
+	if len(spans) == 0 {
+		return
+	}
+	d, err := t.marshal(spans)
+	if err != nil {
+		log.Errorf("trace export: %v", err)
+		return
+	}
+	res, err := t.client.Post(t.endpoint, "application/json", bytes.NewReader(d))
+	if err != nil {
+		log.Debugf("trace export: %v", err)
+		return
+	}
+	res.Body.Close()
+	if res.StatusCode >= 300 {
+		log.Debugf("trace export: collector returned %d", res.StatusCode)
+	}
+}
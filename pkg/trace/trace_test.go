@@ -0,0 +1,74 @@
+package trace
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNilTracer(t *testing.T) {
+	var tracer *Tracer
+	s := tracer.Start("noop", nil)
+	s.SetAttribute("k", "v")
+	s.Fail("x")
+	s.End()
+	if s.Context() != nil {
+		t.Error("nil span has a context")
+	}
+	tracer.Close()
+}
+
+func TestExport(t *testing.T) {
+	bodies := make(chan []byte, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d, _ := ioutil.ReadAll(r.Body)
+		bodies <- d
+	}))
+	defer srv.Close()
+
+	tracer := NewTracer("node", srv.URL)
+	root := tracer.Start("transport.setup", nil)
+	child := tracer.Start("discovery.forward", root.Context())
+	child.Fail("node not found")
+	child.End()
+	child.End()
+	root.SetAttribute("to_node", "02ab")
+	root.End()
+	tracer.Close()
+
+	var req struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Spans []struct {
+					TraceID      string `json:"traceId"`
+					SpanID       string `json:"spanId"`
+					ParentSpanID string `json:"parentSpanId"`
+					Name         string `json:"name"`
+					Status       struct {
+						Code int `json:"code"`
+					} `json:"status"`
+				} `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	err := json.Unmarshal(<-bodies, &req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spans := req.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("%d spans exported, want 2", len(spans))
+	}
+	c, r := spans[0], spans[1]
+	if len(r.TraceID) != 32 || len(r.SpanID) != 16 {
+		t.Errorf("invalid ids %s %s", r.TraceID, r.SpanID)
+	}
+	if c.TraceID != r.TraceID || c.ParentSpanID != r.SpanID {
+		t.Error("child is not part of the trace of the root")
+	}
+	if c.Status.Code != statusError || r.Status.Code != 0 {
+		t.Error("wrong status")
+	}
+}
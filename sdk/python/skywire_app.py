@@ -0,0 +1,175 @@
+"""Reference client of the skywire app socket, see docs/api/AppSocket.md.
+
+Needs Python 3.6 or later and nothing outside the standard library.
+
+    app = AppClient("/run/skywire/apps.sock")
+    key = app.register("echo", "1.0.0", TYPE_PUBLIC, ":9000")
+    # serve ":9000" as usual, the node forwards the connections of other apps to it
+
+    client = AppClient("/run/skywire/apps.sock")
+    client.register("echo-client", "1.0.0", TYPE_CLIENT)
+    conn = client.connect(node_key, app_key, local_port=9443)
+    sock = socket.create_connection((conn["Host"], conn["Port"]))
+"""
+
+import json
+import queue
+import socket
+import struct
+import threading
+
+PROTOCOL_VERSION = 1
+MAX_FRAME_SIZE = 64 << 10
+# heartbeats missed before the node is taken for dead
+PING_MISSES = 3
+
+TYPE_PUBLIC = "public"
+TYPE_PRIVATE = "private"
+TYPE_CLIENT = "client"
+
+
+class AppError(Exception):
+    """A request the node answered with an error, or a failed connection."""
+
+
+def write_frame(sock, frame):
+    body = json.dumps(frame, separators=(",", ":")).encode()
+    if len(body) > MAX_FRAME_SIZE:
+        raise ValueError("frame of %d bytes larger than %d" % (len(body), MAX_FRAME_SIZE))
+    sock.sendall(struct.pack(">I", len(body)) + body)
+
+
+def _read_exactly(sock, n):
+    buf = b""
+    while len(buf) < n:
+        chunk = sock.recv(n - len(buf))
+        if not chunk:
+            raise EOFError("app socket closed")
+        buf += chunk
+    return buf
+
+
+def read_frame(sock):
+    (size,) = struct.unpack(">I", _read_exactly(sock, 4))
+    if size > MAX_FRAME_SIZE:
+        raise ValueError("frame of %d bytes larger than %d" % (size, MAX_FRAME_SIZE))
+    return json.loads(_read_exactly(sock, size).decode())
+
+
+class AppClient:
+    """An app on the node serving the unix socket at path.
+
+    on_frame is called, on the reader thread, with the frames that answer no request:
+    the connections of standbys taking over, the closed connections with their reason
+    and the disconnected of the node.
+
+    With ping, an interval in seconds, the node and the app ping each other and a node
+    silent for PING_MISSES intervals is taken for dead: the socket is closed, the waiting
+    requests fail and on_frame gets a disconnected.
+    """
+
+    def __init__(self, path, on_frame=None, timeout=60, ping=0):
+        self.timeout = timeout
+        self.on_frame = on_frame
+        self._sock = socket.socket(socket.AF_UNIX, socket.SOCK_STREAM)
+        self._sock.connect(path)
+        self._write_lock = threading.Lock()
+        self._seq = 0
+        self._waiting = {}
+        self._lock = threading.Lock()
+        self._closed = None
+
+        # the hello comes before the reader starts
+        hello = {"Op": "hello", "Version": PROTOCOL_VERSION}
+        if ping > 0:
+            hello["Ping"] = max(1, int(ping * 1000))
+        write_frame(self._sock, hello)
+        hello = read_frame(self._sock)
+        if hello.get("Op") != "hello":
+            self._sock.close()
+            raise AppError(hello.get("Error", "unexpected %s" % hello.get("Op")))
+        self.node_version = hello.get("Version")
+        # the interval the node pings at, None without heartbeats
+        self.ping_interval = hello["Ping"] / 1000 if hello.get("Ping") else None
+        if self.ping_interval:
+            self._sock.settimeout(PING_MISSES * self.ping_interval)
+
+        self._reader = threading.Thread(target=self._read, daemon=True)
+        self._reader.start()
+
+    def _read(self):
+        try:
+            while True:
+                frame = read_frame(self._sock)
+                if frame.get("Op") == "ping":
+                    with self._write_lock:
+                        write_frame(self._sock, {"Op": "pong"})
+                    continue
+                answer = None
+                with self._lock:
+                    if frame.get("Seq") in self._waiting:
+                        answer = self._waiting.pop(frame["Seq"])
+                if answer is not None:
+                    answer.put(frame)
+                elif self.on_frame is not None:
+                    self.on_frame(frame)
+        except socket.timeout:
+            self._sock.close()
+            self._fail(AppError("no heartbeat of the node for %gs" % (PING_MISSES * self.ping_interval)))
+            if self.on_frame is not None:
+                self.on_frame({"Op": "disconnected", "Error": "node not answering"})
+        except (EOFError, OSError, ValueError) as e:
+            self._fail(e)
+
+    def _fail(self, e):
+        with self._lock:
+            self._closed = e
+            waiting, self._waiting = self._waiting, {}
+        for answer in waiting.values():
+            answer.put({"Op": "error", "Error": str(e)})
+
+    def request(self, frame):
+        """Sends frame and returns the answer of the node, raises AppError on an error."""
+        answer = queue.Queue(1)
+        with self._lock:
+            if self._closed is not None:
+                raise AppError(str(self._closed))
+            self._seq += 1
+            frame = dict(frame, Seq=self._seq)
+            self._waiting[self._seq] = answer
+        with self._write_lock:
+            write_frame(self._sock, frame)
+        try:
+            resp = answer.get(timeout=self.timeout)
+        except queue.Empty:
+            with self._lock:
+                self._waiting.pop(frame["Seq"], None)
+            raise AppError("no answer to %s" % frame["Op"])
+        if resp.get("Op") == "error" or resp.get("Failed"):
+            raise AppError(resp.get("Error", "failed"))
+        return resp
+
+    def register(self, service, version, app_type, address="", allow_nodes=None, seed_path=""):
+        """Registers the app and returns its hex key."""
+        frame = {"Op": "register", "Service": service, "AppVersion": version, "Type": app_type, "Address": address}
+        if allow_nodes:
+            frame["AllowNodes"] = list(allow_nodes)
+        if seed_path:
+            frame["SeedPath"] = seed_path
+        return self.request(frame)["Key"]
+
+    def connect(self, node, app, discovery="", local_port=0, critical=False, private=False):
+        """Connects to the app on the node, returns the connection frame with the Host and Port to dial."""
+        frame = {"Op": "connect", "Node": node, "Key": app}
+        if discovery:
+            frame["Discovery"] = discovery
+        if local_port:
+            frame["LocalPort"] = local_port
+        if critical:
+            frame["Critical"] = True
+        if private:
+            frame["Private"] = True
+        return self.request(frame)
+
+    def close(self):
+        self._sock.close()